@@ -0,0 +1,127 @@
+package a11y
+
+import (
+	"bufio"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// helper listens on the AT-SPI registry through pyatspi, which is what
+// ships with every desktop that has a screen reader.
+//
+//go:embed atspi.py
+var helper string
+
+// Event is an accessibility event from the host: a widget gaining focus
+// or its value, name or text changing.
+type Event struct {
+	Type        string    `json:"type"` // focus, value, name or text
+	App         string    `json:"app,omitempty"`
+	Role        string    `json:"role,omitempty"`
+	Name        string    `json:"name,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Value       string    `json:"value,omitempty"`
+	Time        time.Time `json:"time"`
+}
+
+// Monitor runs the AT-SPI helper while anyone is subscribed and fans its
+// events out to the subscribers.
+type Monitor struct {
+	mu      sync.Mutex
+	display string
+	cmd     *exec.Cmd
+	subs    map[chan Event]struct{}
+}
+
+func NewMonitor(display string) *Monitor {
+	return &Monitor{display: display, subs: make(map[chan Event]struct{})}
+}
+
+// Subscribe returns a channel of events, starting the helper for the
+// first subscriber. The channel is closed when the helper exits; call the
+// returned function to unsubscribe.
+func (m *Monitor) Subscribe() (<-chan Event, func(), error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.cmd == nil {
+		if err := m.start(); err != nil {
+			return nil, nil, err
+		}
+	}
+	ch := make(chan Event, 64)
+	m.subs[ch] = struct{}{}
+	return ch, func() { m.unsubscribe(ch) }, nil
+}
+
+func (m *Monitor) unsubscribe(ch chan Event) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.subs[ch]; !ok {
+		return
+	}
+	delete(m.subs, ch)
+	close(ch)
+	if len(m.subs) == 0 && m.cmd != nil {
+		m.cmd.Process.Kill()
+		m.cmd = nil
+	}
+}
+
+func (m *Monitor) start() error {
+	cmd := exec.Command("python3", "-c", helper)
+	cmd.Env = append(os.Environ(), "DISPLAY="+m.display, "GTK_MODULES=gail:atk-bridge")
+	cmd.Stderr = os.Stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start AT-SPI helper: %w", err)
+	}
+	m.cmd = cmd
+	fmt.Printf("Started AT-SPI monitor on %s (pid %d)\n", m.display, cmd.Process.Pid)
+
+	go func() {
+		sc := bufio.NewScanner(out)
+		for sc.Scan() {
+			var ev Event
+			if json.Unmarshal(sc.Bytes(), &ev) != nil {
+				continue
+			}
+			ev.Time = time.Now()
+			m.publish(ev)
+		}
+		err := cmd.Wait()
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if m.cmd != cmd {
+			return
+		}
+		fmt.Printf("AT-SPI monitor exited: %v\n", err)
+		m.cmd = nil
+		for ch := range m.subs {
+			close(ch)
+			delete(m.subs, ch)
+		}
+	}()
+	return nil
+}
+
+// publish delivers ev to every subscriber, dropping it for those that are
+// not keeping up.
+func (m *Monitor) publish(ev Event) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for ch := range m.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
@@ -0,0 +1,44 @@
+# Prints AT-SPI events from the session bus as JSON lines for remoter.
+import json
+import sys
+
+import pyatspi
+
+def describe(kind, event, value=None):
+    src = event.source
+    out = {"type": kind}
+    try:
+        out["role"] = src.getRoleName()
+        out["name"] = src.name or ""
+        out["description"] = src.description or ""
+        app = src.getApplication()
+        if app is not None:
+            out["app"] = app.name or ""
+    except Exception:
+        return
+    if value is not None:
+        out["value"] = value
+    sys.stdout.write(json.dumps(out) + "\n")
+    sys.stdout.flush()
+
+def on_focus(event):
+    if event.detail1:
+        describe("focus", event)
+
+def on_value(event):
+    try:
+        describe("value", event, str(event.source.queryValue().currentValue))
+    except Exception:
+        describe("value", event)
+
+def on_name(event):
+    describe("name", event)
+
+def on_text(event):
+    describe("text", event, event.any_data if isinstance(event.any_data, str) else None)
+
+pyatspi.Registry.registerEventListener(on_focus, "object:state-changed:focused")
+pyatspi.Registry.registerEventListener(on_value, "object:property-change:accessible-value")
+pyatspi.Registry.registerEventListener(on_name, "object:property-change:accessible-name")
+pyatspi.Registry.registerEventListener(on_text, "object:text-changed:insert")
+pyatspi.Registry.start()
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AdaptiveBitrateConfig lowers the main stream's bitrate while most
+// viewers fall behind and raises it back towards the configured bitrate
+// once they keep up. Each change restarts the encoder.
+type AdaptiveBitrateConfig struct {
+	Enabled    bool   `json:"enabled"`
+	MinBitrate string `json:"min_bitrate,omitempty"` // default 200k
+	Interval   string `json:"interval,omitempty"`    // default 5s
+}
+
+// abrRecoverTicks is how many healthy intervals pass before stepping the
+// bitrate back up, so it doesn't oscillate around the network's limit.
+const abrRecoverTicks = 3
+
+// parseBitrate converts ffmpeg's "800k"/"2M" notation to bits per second.
+func parseBitrate(s string) (int, error) {
+	if !bitrateRe.MatchString(s) {
+		return 0, fmt.Errorf("invalid bitrate %q", s)
+	}
+	mult := 1
+	switch strings.ToLower(s[len(s)-1:]) {
+	case "k":
+		mult, s = 1000, s[:len(s)-1]
+	case "m":
+		mult, s = 1000000, s[:len(s)-1]
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid bitrate %q", s)
+	}
+	return n * mult, nil
+}
+
+func formatBitrate(bps int) string {
+	return fmt.Sprintf("%dk", bps/1000)
+}
+
+// viewerBacklog counts the main stream's full-quality viewers and how many of them are
+// falling behind.
+func viewerBacklog() (total, behind int) {
+	clientsMux.RLock()
+	defer clientsMux.RUnlock()
+	for c := range clients {
+		if c.streamKey() != "" || c.transport == transportRecord || c.transport == transportPush {
+			continue
+		}
+		total++
+		if c.behind() {
+			behind++
+		}
+	}
+	return total, behind
+}
+
+// adaptBitrate runs the adaptive bitrate controller until the process
+// exits.
+func adaptBitrate(cfg *AdaptiveBitrateConfig) error {
+	floor, err := parseBitrate(cmp.Or(cfg.MinBitrate, "200k"))
+	if err != nil {
+		return err
+	}
+	interval, err := time.ParseDuration(cmp.Or(cfg.Interval, "5s"))
+	if err != nil {
+		return fmt.Errorf("invalid adaptive bitrate interval: %w", err)
+	}
+
+	go func() {
+		healthy := 0
+		for range time.Tick(interval) {
+			if !services.encoder.Status().Running {
+				continue
+			}
+			services.mu.Lock()
+			target, err := parseBitrate(services.cfg.Bitrate)
+			services.mu.Unlock()
+			if err != nil {
+				continue
+			}
+			settings := services.encoder.Settings()
+			current, err := parseBitrate(settings.Bitrate)
+			if err != nil {
+				continue
+			}
+
+			next := current
+			total, behind := viewerBacklog()
+			switch {
+			case total > 0 && behind*2 > total:
+				healthy = 0
+				next = max(current*3/4, min(floor, target))
+			case behind == 0:
+				if healthy++; healthy >= abrRecoverTicks {
+					healthy = 0
+					next = min(current*5/4, target)
+				}
+			default:
+				healthy = 0
+			}
+			if next == current {
+				continue
+			}
+
+			log.Printf("Adaptive bitrate: %d of %d viewers behind, %s -> %s",
+				behind, total, settings.Bitrate, formatBitrate(next))
+			settings.Bitrate = formatBitrate(next)
+			if err := services.encoder.Update(settings); err != nil {
+				log.Printf("Adaptive bitrate: failed to restart encoder: %v", err)
+			}
+		}
+	}()
+	return nil
+}
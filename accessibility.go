@@ -0,0 +1,63 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/nathfavour/remoter/a11y"
+	"github.com/nathfavour/remoter/i18n"
+	"github.com/nathfavour/remoter/zst"
+)
+
+// a11yMonitor streams AT-SPI events of the main display, when enabled.
+var a11yMonitor *a11y.Monitor
+
+// handleA11y sends accessibility events as JSON text messages over a
+// WebSocket of their own, so /ws stays a pure video stream.
+func handleA11y(w http.ResponseWriter, r *http.Request) {
+	if a11yMonitor == nil {
+		i18n.Error(w, r, http.StatusNotFound, "a11y_disabled")
+		return
+	}
+	conn, zstd, err := upgradeAux(w, r)
+	if err != nil {
+		log.Printf("WebSocket upgrade error: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	events, unsubscribe, err := a11yMonitor.Subscribe()
+	if err != nil {
+		log.Printf("Accessibility stream unavailable: %v", err)
+		return
+	}
+	defer unsubscribe()
+	log.Printf("Accessibility client %s connected", r.RemoteAddr)
+
+	// The client never sends anything; reading detects when it leaves.
+	gone := make(chan struct{})
+	keepAlive(conn, gone)
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			if err := zst.WriteJSON(conn, ev, zstd); err != nil {
+				return
+			}
+		case <-gone:
+			log.Printf("Accessibility client %s disconnected", r.RemoteAddr)
+			return
+		}
+	}
+}
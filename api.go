@@ -0,0 +1,555 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+
+	"github.com/nathfavour/remoter/automation"
+	"github.com/nathfavour/remoter/ffmpeg"
+	"github.com/nathfavour/remoter/power"
+	"github.com/nathfavour/remoter/session"
+	"github.com/nathfavour/remoter/vdisplay"
+)
+
+var bitrateRe = regexp.MustCompile(`^\d+[kKmM]?$`)
+
+func registerAPI(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/status", handleStatus)
+	mux.HandleFunc("GET /api/v1/pipeline", handleStatus)
+	mux.HandleFunc("PATCH /api/v1/pipeline", handlePipelineUpdate)
+	mux.HandleFunc("PUT /api/v1/pipeline/roi", handleSetROI)
+	mux.HandleFunc("DELETE /api/v1/pipeline/roi", handleClearROI)
+	mux.HandleFunc("PUT /api/v1/pipeline/capture", handleSetCapture)
+	mux.HandleFunc("DELETE /api/v1/pipeline/capture", handleClearCapture)
+	mux.HandleFunc("PUT /api/v1/pipeline/resolution", handleSetResolution)
+	mux.HandleFunc("DELETE /api/v1/pipeline/resolution", handleClearResolution)
+	mux.HandleFunc("GET /api/v1/pipeline/masks", handleGetMasks)
+	mux.HandleFunc("PUT /api/v1/pipeline/masks", handleSetMasks)
+	mux.HandleFunc("DELETE /api/v1/pipeline/masks", handleClearMasks)
+	mux.HandleFunc("GET /api/v1/audio", handleGetAudio)
+	mux.HandleFunc("GET /api/v1/audio/sources", handleListAudioSources)
+	mux.HandleFunc("PUT /api/v1/audio/sources", handleSetAudioSources)
+	mux.HandleFunc("POST /api/v1/stream/pause", handlePauseStream)
+	mux.HandleFunc("POST /api/v1/stream/resume", handleResumeStream)
+	mux.HandleFunc("GET /api/v1/services", handleListServices)
+	mux.HandleFunc("POST /api/v1/services/{name}/{action}", handleServiceAction)
+	mux.HandleFunc("POST /api/v1/power/{action}", handlePowerAction)
+	mux.HandleFunc("GET /api/v1/stats", handleStats)
+	mux.HandleFunc("GET /api/v1/clients", handleListClients)
+	mux.HandleFunc("DELETE /api/v1/clients/{id}", handleKickClient)
+	mux.HandleFunc("GET /api/v1/templates", handleListTemplates)
+	mux.HandleFunc("GET /api/v1/sessions", handleListSessions)
+	mux.HandleFunc("POST /api/v1/sessions", handleCreateSession)
+	mux.HandleFunc("POST /api/v1/sessions/kiosk", handleCreateKiosk)
+	mux.HandleFunc("DELETE /api/v1/sessions/{id}", handleDestroySession)
+	mux.HandleFunc("POST /api/v1/sessions/{id}/share", handleShareSession)
+	mux.HandleFunc("GET /api/v1/desktops", handleListDesktops)
+	mux.HandleFunc("POST /api/v1/desktops", handleCreateDesktop)
+	mux.HandleFunc("DELETE /api/v1/desktops/{id}", handleDestroyDesktop)
+	mux.HandleFunc("GET /api/v1/invites", handleListInvites)
+	mux.HandleFunc("POST /api/v1/invites", handleCreateInvite)
+	mux.HandleFunc("DELETE /api/v1/invites/{id}", handleRevokeInvite)
+	mux.HandleFunc("GET /api/v1/streams", handleListStreams)
+	mux.HandleFunc("PATCH /api/v1/streams/{id}", handleUpdateStream)
+	mux.HandleFunc("GET /api/v1/cast/devices", handleListCastDevices)
+	mux.HandleFunc("GET /api/v1/casts", handleListCasts)
+	mux.HandleFunc("POST /api/v1/casts", handleStartCast)
+	mux.HandleFunc("DELETE /api/v1/casts/{id}", handleStopCast)
+	mux.HandleFunc("GET /api/v1/recordings", handleListRecordings)
+	mux.HandleFunc("POST /api/v1/recordings", handleStartRecording)
+	mux.HandleFunc("POST /api/v1/recordings/{name}/stop", handleStopRecording)
+	mux.HandleFunc("GET /api/v1/recordings/{name}", handlePlayRecording)
+	mux.HandleFunc("GET /api/v1/recordings/{name}/chapters", handleRecordingChapters)
+	mux.HandleFunc("POST /api/v1/recordings/{name}/clip", handleExportClip)
+	mux.HandleFunc("DELETE /api/v1/recordings/{name}", handleDeleteRecording)
+	mux.HandleFunc("GET /api/v1/schedules", handleListSchedules)
+	mux.HandleFunc("POST /api/v1/schedules", handleAddSchedule)
+	mux.HandleFunc("DELETE /api/v1/schedules/{name}", handleDeleteSchedule)
+	mux.HandleFunc("GET /api/v1/retention", handleGetRetention)
+	mux.HandleFunc("PUT /api/v1/retention", handleSetRetention)
+	mux.HandleFunc("POST /api/v1/retention/apply", handleApplyRetention)
+	mux.HandleFunc("GET /api/v1/replay", handleReplayStatus)
+	mux.HandleFunc("POST /api/v1/replay/export", handleExportReplay)
+	mux.HandleFunc("GET /api/v1/usage", handleUsage)
+	mux.HandleFunc("GET /api/v1/usage/{user}", handleUserUsage)
+	mux.HandleFunc("GET /api/v1/transports", handleTransports)
+	mux.HandleFunc("POST /api/v1/transports/fallback", handleTransportFallback)
+	mux.HandleFunc("GET /api/v1/sync", handleSyncManifest)
+	mux.HandleFunc("GET /api/v1/sync/files/{path...}", handleSyncDownload)
+	mux.HandleFunc("PUT /api/v1/sync/files/{path...}", handleSyncUpload)
+	mux.HandleFunc("DELETE /api/v1/sync/files/{path...}", handleSyncDelete)
+	mux.HandleFunc("GET /api/v1/control", handleGetControl)
+	mux.HandleFunc("POST /api/v1/control/grant", handleGrantControl)
+	mux.HandleFunc("POST /api/v1/control/revoke", handleRevokeControl)
+	mux.HandleFunc("GET /api/v1/keys", handleListKeys)
+	mux.HandleFunc("POST /api/v1/keys/pair", handleCreatePairing)
+	mux.HandleFunc("DELETE /api/v1/keys/{id}", handleRevokeKey)
+	mux.HandleFunc("GET /api/v1/chat", handleChatHistory)
+	mux.HandleFunc("POST /api/v1/chat", handlePostChat)
+	mux.HandleFunc("GET /api/v1/commands", handleListCommands)
+	mux.HandleFunc("POST /api/v1/commands/{name}/run", handleRunCommand)
+	registerAutomationAPI(mux)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to write API response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, services.state())
+}
+
+func handleListServices(w http.ResponseWriter, r *http.Request) {
+	list := []serviceStatus{}
+	for _, s := range declared {
+		list = append(list, s.status())
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// handleServiceAction starts, stops or restarts a declared service under
+// supervision; ffmpeg, audio and vnc can also be run undeclared, without.
+func handleServiceAction(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	s := declaredService(name)
+	if s == nil && name != "ffmpeg" && name != "audio" && name != "vnc" {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown service %q", name))
+		return
+	}
+
+	var err error
+	switch action := r.PathValue("action"); {
+	case s != nil && action == "start":
+		err = s.start()
+	case s != nil && action == "stop":
+		err = s.halt()
+	case s != nil && action == "restart":
+		err = s.restart()
+	case action == "start":
+		err = services.start(name)
+	case action == "stop":
+		err = services.stop(name)
+	case action == "restart":
+		err = services.restart(name)
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown action %q", action))
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+
+	log.Printf("API: %s %s", r.PathValue("action"), name)
+	writeJSON(w, http.StatusOK, services.state())
+}
+
+// powerActions is set in main when power actions are enabled.
+var powerActions bool
+
+// handlePowerAction locks or logs out of the main display's desktop
+// session, or suspends or reboots the machine. It takes an admin, never
+// a request let in because auth is off; the control socket is an admin's.
+func handlePowerAction(w http.ResponseWriter, r *http.Request) {
+	if !powerActions {
+		writeError(w, http.StatusNotFound, fmt.Errorf("power actions are disabled; set power_actions in the config"))
+		return
+	}
+	if requestAuth(r).Role != "admin" {
+		writeError(w, http.StatusForbidden, fmt.Errorf("power actions need an admin login"))
+		return
+	}
+	action := r.PathValue("action")
+	if !slices.Contains(power.Actions, action) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown action %q", action))
+		return
+	}
+	// Say so before the machine goes away.
+	who := cmp.Or(requestAuth(r).User, r.RemoteAddr)
+	log.Printf("API: %s requested %s", who, action)
+	emit(eventPowerAction, map[string]string{"action": action, "user": requestAuth(r).User})
+	if err := power.Do(action, services.encoder.Settings().Display); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handlePipelineUpdate(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Framerate int    `json:"framerate"`
+		Bitrate   string `json:"bitrate"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	if req.Framerate < 0 || req.Framerate > 120 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("framerate must be between 1 and 120"))
+		return
+	}
+	if req.Bitrate != "" && !bitrateRe.MatchString(req.Bitrate) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid bitrate %q", req.Bitrate))
+		return
+	}
+
+	if err := services.updatePipeline(req.Framerate, req.Bitrate); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	log.Printf("API: pipeline updated (framerate=%d, bitrate=%q)", req.Framerate, req.Bitrate)
+	writeJSON(w, http.StatusOK, services.state())
+}
+
+// handleSetROI boosts the quality of a rectangle, or of the current
+// bounds of a window given by name.
+func handleSetROI(w http.ResponseWriter, r *http.Request) {
+	roi, ok := decodeRegion(w, r)
+	if !ok {
+		return
+	}
+	if err := services.setROI(&roi); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	log.Printf("API: region of interest set to %dx%d+%d+%d", roi.W, roi.H, roi.X, roi.Y)
+	writeJSON(w, http.StatusOK, services.state())
+}
+
+// decodeRegion reads a rectangle, or the current geometry of a named
+// window or XRandR monitor, from the request body, replying with an error
+// if it is invalid.
+func decodeRegion(w http.ResponseWriter, r *http.Request) (ffmpeg.Region, bool) {
+	var req struct {
+		ffmpeg.Region
+		Window  string `json:"window"`
+		Monitor string `json:"monitor"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return ffmpeg.Region{}, false
+	}
+	region := req.Region
+	if req.Window != "" {
+		x, y, width, height, err := automation.New(services.encoder.Settings().Display).WindowGeometry(req.Window)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return ffmpeg.Region{}, false
+		}
+		region = ffmpeg.Region{X: x, Y: y, W: width, H: height}
+	}
+	if req.Monitor != "" {
+		if virtualDisplay != nil {
+			writeError(w, http.StatusConflict, fmt.Errorf("the main stream shows a virtual display, not a monitor"))
+			return ffmpeg.Region{}, false
+		}
+		x, y, width, height, err := vdisplay.OutputGeometry(services.encoder.Settings().Display, req.Monitor)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return ffmpeg.Region{}, false
+		}
+		region = ffmpeg.Region{X: x, Y: y, W: width, H: height}
+	}
+	if region.W <= 0 || region.H <= 0 || region.X < 0 || region.Y < 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("region needs a non-negative position and a positive size"))
+		return ffmpeg.Region{}, false
+	}
+	return region, true
+}
+
+func handleClearROI(w http.ResponseWriter, r *http.Request) {
+	if err := services.setROI(nil); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	log.Printf("API: region of interest cleared")
+	writeJSON(w, http.StatusOK, services.state())
+}
+
+// handleSetResolution changes the main stream's resolution on the fly.
+// Viewers connected with ?notify=1 are told the new size; jsmpeg adapts
+// on its own from the next sequence header.
+func handleSetResolution(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Res string `json:"res"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	if _, _, err := ffmpeg.ParseSize(req.Res); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := services.setResolution(req.Res); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	announceResolution()
+	log.Printf("API: resolution set to %s", req.Res)
+	writeJSON(w, http.StatusOK, services.state())
+}
+
+// handleClearResolution streams a real display at its own size again.
+func handleClearResolution(w http.ResponseWriter, r *http.Request) {
+	if virtualDisplay != nil {
+		writeError(w, http.StatusConflict, fmt.Errorf("a virtual display always streams at its own resolution"))
+		return
+	}
+	if err := services.setResolution(""); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	announceResolution()
+	log.Printf("API: resolution reset to the display's")
+	writeJSON(w, http.StatusOK, services.state())
+}
+
+// announceResolution tells viewers and the event bus the size the main
+// stream now has.
+func announceResolution() {
+	res := placeholderRes()
+	notifyViewers("", map[string]any{"type": "resolution", "res": res})
+	emit(eventStreamResolution, map[string]any{"res": res})
+}
+
+// handleSetCapture restricts capture to a rectangle, or to the current
+// geometry of a named window, so the rest of the screen never leaves the
+// host.
+func handleSetCapture(w http.ResponseWriter, r *http.Request) {
+	region, ok := decodeRegion(w, r)
+	if !ok {
+		return
+	}
+	if err := services.setCapture(&region); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	log.Printf("API: capture area set to %dx%d+%d+%d", region.W, region.H, region.X, region.Y)
+	writeJSON(w, http.StatusOK, services.state())
+}
+
+func handleClearCapture(w http.ResponseWriter, r *http.Request) {
+	if err := services.setCapture(nil); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	log.Printf("API: capture area cleared, capturing the full screen")
+	writeJSON(w, http.StatusOK, services.state())
+}
+
+func handleListClients(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"clients": listClients()})
+}
+
+func handleKickClient(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !kickClient(id) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no client with id %q", id))
+		return
+	}
+	log.Printf("API: kicked client %s", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handleListTemplates(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"templates": sessions.Templates()})
+}
+
+func handleListSessions(w http.ResponseWriter, r *http.Request) {
+	list := sessions.List()
+	if a := requestAuth(r); isolated(a) {
+		list = slices.DeleteFunc(list, func(info session.Info) bool { return info.User != a.User })
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"sessions": list})
+}
+
+func handleCreateSession(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Template    string  `json:"template"`
+		User        string  `json:"user"`
+		Password    string  `json:"password,omitempty"`
+		Title       *string `json:"title,omitempty"`
+		Description *string `json:"description,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	if a := requestAuth(r); isolated(a) {
+		// Isolated users only get desktops of their own.
+		req.User = a.User
+		if req.Template == "" {
+			req.Template = isolation.Template
+		}
+	}
+	if req.Template == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("template is required"))
+		return
+	}
+	owner := requestAuth(r).User
+	if err := quotas.admitSession(owner); err != nil {
+		writeError(w, http.StatusForbidden, fmt.Errorf("quota exceeded: %w", err))
+		return
+	}
+
+	info, err := createSession(owner, req.Template, req.User, req.Password, req.Title, req.Description)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, info)
+}
+
+// createSession starts a session from a template for owner, whose quota
+// was checked, and streams it.
+func createSession(owner, template, user, password string, title, description *string) (session.Info, error) {
+	info, err := sessions.Create(template, user, password)
+	if err != nil {
+		return info, err
+	}
+	quotas.ownSession(info.ID, owner)
+	if title != nil || description != nil {
+		info, _ = sessions.SetMeta(info.ID, title, description)
+	}
+	log.Printf("API: created session %s from template %q on %s", info.ID, info.Template, info.Display)
+	emit(eventSessionCreated, info)
+	if err := startSessionStream(info); err != nil {
+		log.Printf("Warning: failed to start stream for session %s: %v", info.ID, err)
+	}
+	return info, nil
+}
+
+// handleCreateKiosk starts a session running only a kiosk-mode browser
+// at the given URL and streams it.
+func handleCreateKiosk(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		URL      string `json:"url"`
+		Res      string `json:"res"`
+		Browser  string `json:"browser"`
+		Title    string `json:"title"`
+		Lifetime string `json:"lifetime"`
+		User     string `json:"user"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	if req.Res != "" && !resRe.MatchString(req.Res) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid resolution %q; use WxH or WxHxDEPTH", req.Res))
+		return
+	}
+	t, err := session.KioskTemplate(req.URL, req.Res, req.Browser)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.Title != "" {
+		t.Title = req.Title
+	}
+	t.Lifetime = req.Lifetime
+	owner := requestAuth(r).User
+	if err := quotas.admitSession(owner); err != nil {
+		writeError(w, http.StatusForbidden, fmt.Errorf("quota exceeded: %w", err))
+		return
+	}
+
+	info, err := sessions.CreateFrom("kiosk", t, req.User, "")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	quotas.ownSession(info.ID, owner)
+	log.Printf("API: created kiosk session %s for %s on %s", info.ID, req.URL, info.Display)
+	emit(eventSessionCreated, info)
+	if err := startSessionStream(info); err != nil {
+		log.Printf("Warning: failed to start stream for session %s: %v", info.ID, err)
+	}
+	writeJSON(w, http.StatusCreated, info)
+}
+
+func handleDestroySession(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if a := requestAuth(r); isolated(a) && !ownsSession(a.User, id) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no session with id %q", id))
+		return
+	}
+	if err := destroySession(id); err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func destroySession(id string) error {
+	stopSessionStream(id)
+	if err := sessions.Destroy(id); err != nil {
+		return err
+	}
+	log.Printf("API: destroyed session %s", id)
+	emit(eventSessionDestroyed, map[string]string{"id": id})
+	return nil
+}
+
+func handleListDesktops(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"desktops": desktops.List()})
+}
+
+// desktopApps maps the app names desktops may be created with to their
+// commands, set in main.
+var desktopApps map[string]string
+
+// handleCreateDesktop starts a VNC desktop on a free display, at the given
+// resolution and running the named apps from desktop_apps (the default
+// desktop when none).
+func handleCreateDesktop(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Res  string   `json:"res"`
+		Apps []string `json:"apps"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	apps := make([]string, 0, len(req.Apps))
+	for _, name := range req.Apps {
+		cmd, ok := desktopApps[name]
+		if !ok {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("unknown app %q", name))
+			return
+		}
+		apps = append(apps, cmd)
+	}
+	d, err := desktops.Create(req.Res, apps)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	log.Printf("API: created VNC desktop %s on %s (port %d)", d.ID, d.Display, d.Port)
+	writeJSON(w, http.StatusCreated, d)
+}
+
+func handleDestroyDesktop(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if err := desktops.Destroy(id); err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	log.Printf("API: destroyed VNC desktop %s", id)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/nathfavour/remoter/audio"
+	"github.com/nathfavour/remoter/i18n"
+)
+
+// audioQuality is the tier the audio encoder posts the main stream's sound
+// under, so the hub fans it out and a pause silences it like the video.
+const audioQuality = "audio"
+
+// AudioConfig streams the desktop's sound to viewers on /audio, mixed
+// from one or more PulseAudio/PipeWire sources.
+type AudioConfig struct {
+	Enabled bool `json:"enabled"`
+	// Sources default to the monitor of the default sink.
+	Sources []audio.Source `json:"sources,omitempty"`
+	Bitrate string         `json:"bitrate,omitempty"` // default 128k
+	// NoiseModel is the RNNoise model used by sources with denoise set;
+	// without one they use ffmpeg's FFT denoiser.
+	NoiseModel string `json:"noise_model,omitempty"`
+}
+
+func audioSettings(cfg *Config) audio.Settings {
+	s := audio.Settings{
+		Bitrate: "128k",
+		Output:  func(r io.Reader) { ingestStream("", audioQuality, r) },
+	}
+	if a := cfg.Audio; a != nil {
+		s.Sources = a.Sources
+		s.Bitrate = cmp.Or(a.Bitrate, s.Bitrate)
+		s.NoiseModel = a.NoiseModel
+	}
+	return s
+}
+
+// handleAudio serves the main stream's sound as MP3, which browsers play
+// natively with an <audio> element; /ws stays a pure video stream.
+func handleAudio(w http.ResponseWriter, r *http.Request) {
+	if !services.audio.Status().Running {
+		i18n.Error(w, r, http.StatusNotFound, "audio_unavailable")
+		return
+	}
+	if err := quotas.admitStream(requestAuth(r).User); err != nil {
+		i18n.Error(w, r, http.StatusForbidden, "quota_exceeded", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "audio/mpeg")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	c := newHTTPClient(w, r)
+	c.quality.Store(audioQuality)
+	totalClients := addClient(c)
+	log.Printf("New audio client %s connected. Total clients: %d", c.describe(), totalClients)
+
+	select {
+	case <-r.Context().Done():
+		c.close()
+	case <-c.done:
+	}
+
+	totalClients = removeClient(c)
+	log.Printf("Audio client %s disconnected. Total clients: %d", c.describe(), totalClients)
+}
+
+func handleGetAudio(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, services.audio.Status())
+}
+
+// handleListAudioSources lists the sources the sound server offers and,
+// under PipeWire, the applications playing sound.
+func handleListAudioSources(w http.ResponseWriter, r *http.Request) {
+	devices, err := audio.ListSources()
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	if devices == nil {
+		devices = []audio.Device{}
+	}
+	apps, err := audio.ListApps()
+	if err != nil {
+		log.Printf("Warning: application audio unavailable: %v", err)
+	}
+	if apps == nil {
+		apps = []audio.App{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"sources": devices, "apps": apps})
+}
+
+// handleSetAudioSources replaces the mixed sources and their gains and
+// clean-up filters.
+func handleSetAudioSources(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Sources []audio.Source `json:"sources"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	if err := audio.Validate(req.Sources); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := services.setAudioSources(req.Sources); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	log.Printf("API: %d audio source(s) set", len(req.Sources))
+	writeJSON(w, http.StatusOK, services.audio.Status())
+}
@@ -0,0 +1,79 @@
+package audio
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// App is an application currently playing sound through PipeWire.
+type App struct {
+	Name   string `json:"name"`
+	Binary string `json:"binary,omitempty"`
+	PID    string `json:"pid,omitempty"`
+	Serial string `json:"serial"`
+}
+
+// ListApps asks PipeWire for the playback streams of applications.
+func ListApps() ([]App, error) {
+	out, err := exec.Command("pw-dump").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to query PipeWire: %w", err)
+	}
+	var objects []struct {
+		Type string `json:"type"`
+		Info struct {
+			Props map[string]any `json:"props"`
+		} `json:"info"`
+	}
+	if err := json.Unmarshal(out, &objects); err != nil {
+		return nil, fmt.Errorf("failed to parse pw-dump output: %w", err)
+	}
+	var apps []App
+	for _, o := range objects {
+		props := o.Info.Props
+		if o.Type != "PipeWire:Interface:Node" || props["media.class"] != "Stream/Output/Audio" {
+			continue
+		}
+		prop := func(key string) string {
+			if v, ok := props[key]; ok {
+				return fmt.Sprint(v)
+			}
+			return ""
+		}
+		apps = append(apps, App{
+			Name:   prop("application.name"),
+			Binary: prop("application.process.binary"),
+			PID:    prop("application.process.id"),
+			Serial: prop("object.serial"),
+		})
+	}
+	return apps, nil
+}
+
+// findApp returns the stream of the application named name or running
+// the binary name.
+func findApp(name string) (App, error) {
+	apps, err := ListApps()
+	if err != nil {
+		return App{}, err
+	}
+	for _, a := range apps {
+		if strings.EqualFold(a.Name, name) || strings.EqualFold(a.Binary, name) {
+			return a, nil
+		}
+	}
+	return App{}, fmt.Errorf("%s is not playing any sound", name)
+}
+
+// recordApp returns a pw-record process writing the application's sound to
+// stdout as 48kHz stereo s16le, so it is heard only from that application.
+// The sound keeps playing on the host as well.
+func recordApp(name string) (*exec.Cmd, error) {
+	app, err := findApp(name)
+	if err != nil {
+		return nil, err
+	}
+	return exec.Command("pw-record", "--target", app.Serial, "--format", "s16", "--rate", "48000", "--channels", "2", "-"), nil
+}
@@ -0,0 +1,377 @@
+package audio
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultSource is what the sound server's default sink plays, i.e. the
+// desktop audio.
+const DefaultSource = "@DEFAULT_MONITOR@"
+
+// Source is a PulseAudio source mixed into the stream: a microphone, or
+// "<sink>.monitor" for whatever a sink plays. PipeWire serves the same
+// names through pipewire-pulse. Alternatively App names an application
+// (or its binary) whose sound alone is captured, which needs PipeWire.
+type Source struct {
+	Name string `json:"name,omitempty"`
+	App  string `json:"app,omitempty"`
+	// Gain is applied before mixing, in dB.
+	Gain float64 `json:"gain_db,omitempty"`
+	// Denoise suppresses background noise, with RNNoise when the settings
+	// name a model and an FFT denoiser otherwise.
+	Denoise bool `json:"denoise,omitempty"`
+	// AGC evens out the level, so quiet and loud speakers sound alike.
+	AGC bool `json:"agc,omitempty"`
+}
+
+// Settings describes what is captured and how it is encoded.
+type Settings struct {
+	Sources []Source
+	Bitrate string
+	// Output receives the MP3 stream from a pipe ffmpeg inherits; it
+	// returns when r ends.
+	Output func(r io.Reader)
+	// NoiseModel is an RNNoise model (.rnnn) for denoising sources, e.g.
+	// one from github.com/GregorR/rnnoise-models.
+	NoiseModel string
+}
+
+// ValidateModel checks an RNNoise model can be used in a filter graph.
+func ValidateModel(path string) error {
+	if path == "" {
+		return nil
+	}
+	if strings.ContainsAny(path, "'\\") {
+		return fmt.Errorf("noise model path %q must not contain quotes or backslashes", path)
+	}
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("failed to read noise model: %w", err)
+	}
+	return nil
+}
+
+// Validate checks sources name distinct sources with a sane gain.
+func Validate(sources []Source) error {
+	seen := make(map[string]bool)
+	for _, s := range sources {
+		if (s.Name == "") == (s.App == "") {
+			return fmt.Errorf("audio source needs either a name or an app")
+		}
+		key := s.label()
+		if seen[key] {
+			return fmt.Errorf("duplicate audio source %s", key)
+		}
+		seen[key] = true
+		if s.Gain < -60 || s.Gain > 30 {
+			return fmt.Errorf("gain of %s must be between -60 and 30 dB", key)
+		}
+	}
+	return nil
+}
+
+func (s Source) label() string {
+	if s.App != "" {
+		return fmt.Sprintf("app %q", s.App)
+	}
+	return fmt.Sprintf("%q", s.Name)
+}
+
+// Device is a source the sound server offers.
+type Device struct {
+	Name    string `json:"name"`
+	Driver  string `json:"driver,omitempty"`
+	State   string `json:"state,omitempty"`
+	Monitor bool   `json:"monitor"`
+}
+
+// ListSources asks the sound server for its sources through pactl.
+func ListSources() ([]Device, error) {
+	out, err := exec.Command("pactl", "list", "short", "sources").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audio sources: %w", err)
+	}
+	var devices []Device
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		// index, name, driver, sample spec, state
+		fields := strings.Split(sc.Text(), "\t")
+		if len(fields) < 2 {
+			continue
+		}
+		d := Device{Name: fields[1], Monitor: strings.HasSuffix(fields[1], ".monitor")}
+		if len(fields) > 2 {
+			d.Driver = fields[2]
+		}
+		if len(fields) > 4 {
+			d.State = fields[4]
+		}
+		devices = append(devices, d)
+	}
+	return devices, nil
+}
+
+// Status is a snapshot of the audio pipeline.
+type Status struct {
+	Running   bool      `json:"running"`
+	PID       int       `json:"pid,omitempty"`
+	Sources   []Source  `json:"sources"`
+	Bitrate   string    `json:"bitrate"`
+	StartedAt time.Time `json:"started_at,omitempty"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// Encoder supervises an ffmpeg process that records and mixes the
+// sources and posts the result as MP3.
+type Encoder struct {
+	mu       sync.Mutex
+	settings Settings
+	cmd      *exec.Cmd
+	done     chan struct{}
+	status   Status
+}
+
+// run is ffmpeg and the recorders feeding it application sound.
+type run struct {
+	cmd       *exec.Cmd
+	recorders []*exec.Cmd
+}
+
+func NewEncoder(s Settings) *Encoder {
+	return &Encoder{settings: s}
+}
+
+// Settings returns the settings the next start will use.
+func (e *Encoder) Settings() Settings {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.settings
+}
+
+// Status returns a snapshot of the pipeline state.
+func (e *Encoder) Status() Status {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	st := e.status
+	st.Sources = e.settings.Sources
+	st.Bitrate = e.settings.Bitrate
+	return st
+}
+
+// mixGraph cleans up each input, applies its gain and mixes them into
+// [out].
+func mixGraph(sources []Source, model string) string {
+	var chains, labels []string
+	for i, s := range sources {
+		var filters []string
+		if s.Denoise {
+			if model != "" {
+				filters = append(filters, fmt.Sprintf("arnndn=m='%s'", model))
+			} else {
+				filters = append(filters, "afftdn=nf=-25")
+			}
+		}
+		if s.AGC {
+			// Level over 250ms windows, boosting by at most 10x.
+			filters = append(filters, "dynaudnorm=f=250:g=15:m=10")
+		}
+		filters = append(filters, fmt.Sprintf("volume=%gdB", s.Gain))
+		chains = append(chains, fmt.Sprintf("[%d:a]%s[a%d]", i, strings.Join(filters, ","), i))
+		labels = append(labels, fmt.Sprintf("[a%d]", i))
+	}
+	// normalize=0 keeps the gains as given instead of dividing by the
+	// number of inputs.
+	chains = append(chains, fmt.Sprintf("%samix=inputs=%d:normalize=0[out]", strings.Join(labels, ""), len(sources)))
+	return strings.Join(chains, ";")
+}
+
+func (e *Encoder) command() (*run, error) {
+	sources := e.settings.Sources
+	if len(sources) == 0 {
+		sources = []Source{{Name: DefaultSource}}
+	}
+	r := &run{}
+	var args []string
+	var pipes []*os.File
+	for _, s := range sources {
+		if s.Name != "" {
+			args = append(args, "-f", "pulse", "-i", s.Name)
+			continue
+		}
+		rec, err := recordApp(s.App)
+		if err != nil {
+			closeAll(pipes)
+			return nil, err
+		}
+		pr, pw, err := os.Pipe()
+		if err != nil {
+			closeAll(pipes)
+			return nil, err
+		}
+		rec.Stdout = pw
+		rec.Stderr = os.Stderr
+		pipes = append(pipes, pr, pw)
+		r.recorders = append(r.recorders, rec)
+		// ExtraFiles start at descriptor 3.
+		args = append(args, "-f", "s16le", "-ar", "48000", "-ac", "2", "-i", fmt.Sprintf("pipe:%d", 2+len(r.recorders)))
+	}
+	out, outw, err := os.Pipe()
+	if err != nil {
+		closeAll(pipes)
+		return nil, err
+	}
+	// After the recorders' descriptors.
+	pipes = append(pipes, outw, out)
+	args = append(args,
+		"-filter_complex", mixGraph(sources, e.settings.NoiseModel),
+		"-map", "[out]",
+		"-c:a", "libmp3lame",
+		"-b:a", e.settings.Bitrate,
+		"-ar", "44100",
+		"-f", "mp3",
+		fmt.Sprintf("pipe:%d", 3+len(r.recorders)),
+	)
+	fmt.Printf("Starting audio: ffmpeg %s\n", strings.Join(args, " "))
+	r.cmd = exec.Command("ffmpeg", args...)
+	for i := 0; i < len(pipes); i += 2 {
+		r.cmd.ExtraFiles = append(r.cmd.ExtraFiles, pipes[i])
+	}
+	r.cmd.Stdout = os.Stdout
+	r.cmd.Stderr = os.Stderr
+
+	for _, rec := range r.recorders {
+		if err := rec.Start(); err != nil {
+			r.kill()
+			closeAll(pipes)
+			return nil, fmt.Errorf("failed to start pw-record: %w", err)
+		}
+	}
+	if err := r.cmd.Start(); err != nil {
+		r.kill()
+		closeAll(pipes)
+		return nil, err
+	}
+	// The children hold their own copies.
+	closeAll(pipes[:len(pipes)-1])
+	output := e.settings.Output
+	go func() {
+		defer out.Close()
+		if output == nil {
+			io.Copy(io.Discard, out)
+			return
+		}
+		output(out)
+	}()
+	return r, nil
+}
+
+// kill stops the recorders, which ffmpeg's exit alone does not.
+func (r *run) kill() {
+	for _, rec := range r.recorders {
+		if rec.Process != nil {
+			rec.Process.Kill()
+			rec.Wait()
+		}
+	}
+}
+
+func closeAll(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
+
+// Start launches ffmpeg in the background.
+func (e *Encoder) Start() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.cmd != nil {
+		return fmt.Errorf("audio is already running")
+	}
+
+	r, err := e.command()
+	if err != nil {
+		e.status.LastError = err.Error()
+		return fmt.Errorf("failed to start audio: %w", err)
+	}
+	cmd := r.cmd
+
+	done := make(chan struct{})
+	e.cmd = cmd
+	e.done = done
+	e.status = Status{Running: true, PID: cmd.Process.Pid, StartedAt: time.Now()}
+
+	go func() {
+		err := cmd.Wait()
+		r.kill()
+		e.mu.Lock()
+		if e.cmd == cmd {
+			e.cmd = nil
+			e.status.Running = false
+			e.status.PID = 0
+			if err != nil {
+				e.status.LastError = err.Error()
+			}
+		}
+		e.mu.Unlock()
+		if err != nil {
+			fmt.Printf("Audio encoder exited with error: %v\n", err)
+		}
+		close(done)
+	}()
+	return nil
+}
+
+// Stop terminates the running ffmpeg process and waits for it to exit.
+func (e *Encoder) Stop() error {
+	e.mu.Lock()
+	cmd, done := e.cmd, e.done
+	if cmd == nil {
+		e.mu.Unlock()
+		return fmt.Errorf("audio is not running")
+	}
+	e.cmd = nil
+	e.status.Running = false
+	e.status.PID = 0
+	e.mu.Unlock()
+
+	_ = cmd.Process.Signal(os.Interrupt)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		_ = cmd.Process.Kill()
+		<-done
+	}
+	return nil
+}
+
+// Restart stops ffmpeg if it is running and starts it again.
+func (e *Encoder) Restart() error {
+	if e.Status().Running {
+		if err := e.Stop(); err != nil {
+			return err
+		}
+	}
+	return e.Start()
+}
+
+// Update replaces the settings, restarting ffmpeg if it is running so the
+// new values take effect.
+func (e *Encoder) Update(s Settings) error {
+	e.mu.Lock()
+	e.settings = s
+	running := e.cmd != nil
+	e.mu.Unlock()
+
+	if running {
+		return e.Restart()
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// auditEntry is one line of the input audit log: input replayed on a
+// desktop and who sent it. Controllers that connected with a paired key
+// sign their messages; their entries keep the signed message, signature
+// and public key, so `remoter audit --verify` can show the key's owner
+// sent them, even after the key is revoked.
+type auditEntry struct {
+	Time      time.Time     `json:"time"`
+	Stream    string        `json:"stream,omitempty"`
+	User      string        `json:"user,omitempty"`
+	Name      string        `json:"name"`
+	Key       string        `json:"key,omitempty"`
+	PublicKey []byte        `json:"public_key,omitempty"`
+	Input     *controlInput `json:"input"`
+	Signed    []byte        `json:"signed,omitempty"`
+	Sig       []byte        `json:"sig,omitempty"`
+}
+
+// auditLog appends entries to a file as JSON lines.
+type auditLog struct {
+	mu     sync.Mutex
+	f      *os.File
+	failed bool
+}
+
+// inputAudit records input on the control channel, when enabled.
+var inputAudit *auditLog
+
+func openAuditLog(path string) (*auditLog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	return &auditLog{f: f}, nil
+}
+
+// record appends e. Pointer moves are left out: on their own they change
+// nothing, and they would drown out the rest.
+func (l *auditLog) record(e auditEntry) {
+	if l == nil || e.Input.Type == "move" {
+		return
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.f.Write(append(data, '\n')); err != nil && !l.failed {
+		log.Printf("Warning: failed to write the audit log: %v", err)
+		l.failed = true
+	}
+}
+
+// verify reports whether e was signed by the key it names, and is for
+// the input the signed message holds. It returns an error for unsigned
+// entries too.
+func (e *auditEntry) verify() error {
+	if e.Sig == nil {
+		return fmt.Errorf("not signed")
+	}
+	if len(e.PublicKey) != ed25519.PublicKeySize || keyID(e.PublicKey) != e.Key {
+		return fmt.Errorf("public key does not match key %s", e.Key)
+	}
+	if !ed25519.Verify(e.PublicKey, e.Signed, e.Sig) {
+		return fmt.Errorf("bad signature")
+	}
+	var sc signedControl
+	if err := json.Unmarshal(e.Signed, &sc); err != nil {
+		return fmt.Errorf("malformed signed message: %w", err)
+	}
+	signed, _ := json.Marshal(sc.Input)
+	logged, _ := json.Marshal(e.Input)
+	if !bytes.Equal(signed, logged) {
+		return fmt.Errorf("input differs from the signed message")
+	}
+	return nil
+}
+
+func (l *auditLog) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.f.Close()
+}
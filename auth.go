@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/nathfavour/remoter/i18n"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// authOff is set at startup when the authenticator has no users and no
+// OIDC, for the few handlers that must tell that apart from a request
+// that bypassed the login with no role.
+var authOff bool
+
+// authenticator protects every endpoint with HTTP basic auth against
+// bcrypt hashes from the config and an optional htpasswd-style users file,
+// and/or with OIDC logins.
+type authenticator struct {
+	users map[string][]byte
+	oidc  *oidcProvider
+
+	// verified caches credentials that already passed bcrypt, keyed by
+	// user and password digest, so page loads don't pay for a hash per
+	// asset.
+	mu       sync.Mutex
+	verified map[string]bool
+}
+
+// loadUsersFile reads "user:bcrypt-hash" lines, skipping blanks and
+// comments.
+func loadUsersFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open users file: %w", err)
+	}
+	defer f.Close()
+
+	users := make(map[string]string)
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, hash, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected user:hash", path, n)
+		}
+		users[name] = hash
+	}
+	return users, sc.Err()
+}
+
+func newAuthenticator(users map[string]string, usersFile string, oidc *OIDCConfig) (*authenticator, error) {
+	all := make(map[string]string)
+	if usersFile != "" {
+		fromFile, err := loadUsersFile(usersFile)
+		if err != nil {
+			return nil, err
+		}
+		for name, hash := range fromFile {
+			all[name] = hash
+		}
+	}
+	for name, hash := range users {
+		all[name] = hash
+	}
+
+	a := &authenticator{users: make(map[string][]byte), verified: make(map[string]bool)}
+	for name, hash := range all {
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("invalid bcrypt hash for user %q: %w", name, err)
+		}
+		a.users[name] = []byte(hash)
+	}
+	if oidc != nil && oidc.Issuer != "" {
+		p, err := newOIDCProvider(oidc)
+		if err != nil {
+			return nil, err
+		}
+		a.oidc = p
+	}
+	return a, nil
+}
+
+// off reports whether no login is configured, letting everyone in.
+func (a *authenticator) off() bool {
+	return len(a.users) == 0 && a.oidc == nil
+}
+
+func (a *authenticator) check(username, password string) bool {
+	hash, ok := a.users[username]
+	if !ok {
+		return false
+	}
+	key := fmt.Sprintf("%s\x00%x", username, sha256.Sum256([]byte(password)))
+	a.mu.Lock()
+	hit := a.verified[key]
+	a.mu.Unlock()
+	if hit {
+		return true
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil {
+		return false
+	}
+	a.mu.Lock()
+	a.verified[key] = true
+	a.mu.Unlock()
+	return true
+}
+
+// rolePermits keeps viewers out of the control API; only the transport
+// negotiation endpoints they need remain open to them.
+func rolePermits(role, path string) bool {
+	if role == "admin" || !strings.HasPrefix(path, "/api/") {
+		return true
+	}
+	return strings.HasPrefix(path, "/api/v1/transports")
+}
+
+// requireAdmin answers requests from viewers and other non-admin roles
+// with 403, letting admins and requests with no role, as when auth is
+// off, through. It reports whether the request may go on.
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if a := requestAuth(r); a.Role != "" && a.Role != "admin" {
+		i18n.Error(w, r, http.StatusForbidden, "forbidden")
+		return false
+	}
+	return true
+}
+
+// register adds the OIDC login endpoints, when configured.
+func (a *authenticator) register(mux *http.ServeMux) {
+	if a.oidc != nil {
+		a.oidc.register(mux)
+	}
+}
+
+// wrap requires a login on every request except cast devices fetching
+// their stream, transfer and pairing codes being redeemed, the public
+// status page and requests covered by a share link. Basic auth users are admins, unless isolation
+// confines them to their own sessions; OIDC users get the role their
+// claims map to, and requests signed with a paired key that of the key.
+func (a *authenticator) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if g, ok := shareAccess(w, r); ok {
+			next.ServeHTTP(w, withAuth(r, authInfo{User: g.User, Role: g.Role, Session: g.Session, Invite: g.Invite}))
+			return
+		}
+		if job, ok := castAccess(r); ok {
+			next.ServeHTTP(w, withAuth(r, authInfo{User: job.User, Role: "view", Session: job.Stream}))
+			return
+		}
+		if gridAccess(r) {
+			next.ServeHTTP(w, withAuth(r, authInfo{Role: "view"}))
+			return
+		}
+		// A transfer code stands in for the login on the new device.
+		if r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/handover/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if statusPage && r.Method == http.MethodGet && r.URL.Path == "/status" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.Method == http.MethodGet && r.URL.Path == "/healthz" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.Method == http.MethodPost && r.URL.Path == "/pair" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if k, err := clientKeys.authenticate(r); err != nil {
+			log.Printf("Rejected key signature from %s: %v", r.RemoteAddr, err)
+			emit(eventAuthFailed, map[string]any{"method": "key", "remote_addr": r.RemoteAddr, "error": err.Error()})
+			i18n.Error(w, r, http.StatusUnauthorized, "unauthorized")
+			return
+		} else if k != nil {
+			info := authInfo{User: k.User, Role: k.Role, Key: k.ID}
+			if isolated(info) {
+				if guardIsolated(w, r, info) {
+					return
+				}
+			} else if !rolePermits(info.Role, r.URL.Path) {
+				i18n.Error(w, r, http.StatusForbidden, "forbidden")
+				return
+			}
+			next.ServeHTTP(w, withAuth(r, info))
+			return
+		}
+		if a.off() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if a.oidc != nil && strings.HasPrefix(r.URL.Path, "/auth/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		username, password, ok := r.BasicAuth()
+		if ok && a.check(username, password) {
+			info := authInfo{User: username, Role: basicAuthRole(username)}
+			if guardIsolated(w, r, info) {
+				return
+			}
+			next.ServeHTTP(w, withAuth(r, info))
+			return
+		}
+		if ok {
+			log.Printf("Rejected credentials for %q from %s", username, r.RemoteAddr)
+			emit(eventAuthFailed, map[string]any{"user": username, "method": "basic", "remote_addr": r.RemoteAddr})
+		}
+
+		if a.oidc != nil {
+			login, ok := a.oidc.authenticate(r)
+			if !ok {
+				a.oidc.redirectToLogin(w, r)
+				return
+			}
+			if isolated(login) {
+				if guardIsolated(w, r, login) {
+					return
+				}
+			} else if !rolePermits(login.Role, r.URL.Path) {
+				i18n.Error(w, r, http.StatusForbidden, "forbidden")
+				return
+			}
+			next.ServeHTTP(w, withAuth(r, login))
+			return
+		}
+
+		w.Header().Set("WWW-Authenticate", `Basic realm="remoter", charset="UTF-8"`)
+		i18n.Error(w, r, http.StatusUnauthorized, "unauthorized")
+	})
+}
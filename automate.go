@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/nathfavour/remoter/automation"
+)
+
+func registerAutomationAPI(mux *http.ServeMux) {
+	mux.HandleFunc("POST /api/v1/automation/{target}/move", handleAutomationMove)
+	mux.HandleFunc("POST /api/v1/automation/{target}/click", handleAutomationClick)
+	mux.HandleFunc("POST /api/v1/automation/{target}/type", handleAutomationType)
+	mux.HandleFunc("POST /api/v1/automation/{target}/key", handleAutomationKey)
+	mux.HandleFunc("GET /api/v1/automation/{target}/screenshot", handleAutomationScreenshot)
+	mux.HandleFunc("POST /api/v1/automation/{target}/wait-for-pixel", handleAutomationWaitForPixel)
+}
+
+// automationDriver resolves the {target} of an automation request: "main"
+// for the shared display, or the ID of an X11 session.
+func automationDriver(w http.ResponseWriter, r *http.Request) (*automation.Driver, bool) {
+	target := r.PathValue("target")
+	if target == "main" {
+		if refuseWhileLocked(w) {
+			return nil, false
+		}
+		return automation.New(services.encoder.Settings().Display), true
+	}
+	info, ok := sessions.Get(target)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no session with id %q", target))
+		return nil, false
+	}
+	if info.Backend != "x11" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("automation needs an x11 session, %s is %s", target, info.Backend))
+		return nil, false
+	}
+	return automation.New(info.Display), true
+}
+
+// decodeAutomation decodes the request body into req, reporting failures.
+func decodeAutomation(w http.ResponseWriter, r *http.Request, req any) bool {
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return false
+	}
+	return true
+}
+
+func automationDone(w http.ResponseWriter, r *http.Request, action string, err error) {
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	log.Printf("API: automation %s on %s", action, r.PathValue("target"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handleAutomationMove(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		X int `json:"x"`
+		Y int `json:"y"`
+	}
+	d, ok := automationDriver(w, r)
+	if !ok || !decodeAutomation(w, r, &req) {
+		return
+	}
+	automationDone(w, r, "move", d.Move(req.X, req.Y))
+}
+
+// handleAutomationClick clicks at x,y when given, otherwise where the
+// pointer is.
+func handleAutomationClick(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		X      *int `json:"x"`
+		Y      *int `json:"y"`
+		Button int  `json:"button"`
+		Count  int  `json:"count"`
+	}{Button: 1, Count: 1}
+	d, ok := automationDriver(w, r)
+	if !ok || !decodeAutomation(w, r, &req) {
+		return
+	}
+	if (req.X == nil) != (req.Y == nil) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("x and y must be given together"))
+		return
+	}
+	if req.Button < 1 || req.Button > 9 || req.Count < 1 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid button or count"))
+		return
+	}
+	if req.X != nil {
+		if err := d.Move(*req.X, *req.Y); err != nil {
+			automationDone(w, r, "click", err)
+			return
+		}
+	}
+	automationDone(w, r, "click", d.Click(req.Button, req.Count))
+}
+
+func handleAutomationType(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		Text    string `json:"text"`
+		DelayMS int    `json:"delay_ms"`
+	}{DelayMS: 12}
+	d, ok := automationDriver(w, r)
+	if !ok || !decodeAutomation(w, r, &req) {
+		return
+	}
+	automationDone(w, r, "type", d.Type(req.Text, time.Duration(req.DelayMS)*time.Millisecond))
+}
+
+func handleAutomationKey(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Keys []string `json:"keys"`
+	}
+	d, ok := automationDriver(w, r)
+	if !ok || !decodeAutomation(w, r, &req) {
+		return
+	}
+	if len(req.Keys) == 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("keys is required"))
+		return
+	}
+	automationDone(w, r, "key", d.Key(req.Keys...))
+}
+
+func handleAutomationScreenshot(w http.ResponseWriter, r *http.Request) {
+	d, ok := automationDriver(w, r)
+	if !ok {
+		return
+	}
+	data, err := d.Screenshot()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if r.PathValue("target") == "main" {
+		if masks := screenMasks(services.encoder.Settings().Display); len(masks) > 0 {
+			if data, err = maskPNG(data, masks); err != nil {
+				writeError(w, http.StatusInternalServerError, err)
+				return
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(data)
+}
+
+// handleAutomationWaitForPixel blocks until a pixel reaches a color, so
+// tests can wait for the UI to settle instead of sleeping.
+func handleAutomationWaitForPixel(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		X         int    `json:"x"`
+		Y         int    `json:"y"`
+		Color     string `json:"color"`
+		Tolerance int    `json:"tolerance"`
+		Timeout   string `json:"timeout"`
+	}{Timeout: "10s"}
+	d, ok := automationDriver(w, r)
+	if !ok || !decodeAutomation(w, r, &req) {
+		return
+	}
+	want, err := automation.ParseColor(req.Color)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	timeout, err := time.ParseDuration(req.Timeout)
+	if err != nil || timeout <= 0 || timeout > 5*time.Minute {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("timeout must be a duration up to 5m"))
+		return
+	}
+
+	if r.PathValue("target") == "main" {
+		for _, m := range screenMasks(services.encoder.Settings().Display) {
+			if (image.Point{req.X, req.Y}).In(m) {
+				writeError(w, http.StatusForbidden, fmt.Errorf("pixel %d,%d is under a privacy mask", req.X, req.Y))
+				return
+			}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	start := time.Now()
+	got, err := d.WaitForPixel(ctx, req.X, req.Y, want, req.Tolerance)
+	resp := map[string]any{
+		"matched": err == nil,
+		"color":   automation.FormatColor(got),
+		"elapsed": time.Since(start).String(),
+	}
+	switch {
+	case err == nil:
+		writeJSON(w, http.StatusOK, resp)
+	case errors.Is(err, context.DeadlineExceeded):
+		writeJSON(w, http.StatusRequestTimeout, resp)
+	default:
+		writeError(w, http.StatusInternalServerError, err)
+	}
+}
@@ -0,0 +1,168 @@
+package automation
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Driver injects input into an X display with xdotool and captures it
+// with ffmpeg, for driving GUI tests.
+type Driver struct {
+	display string
+}
+
+func New(display string) *Driver {
+	return &Driver{display: display}
+}
+
+func (d *Driver) xdotool(args ...string) error {
+	cmd := exec.Command("xdotool", args...)
+	cmd.Env = append(os.Environ(), "DISPLAY="+d.display)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("xdotool %s failed: %v: %s", args[0], err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// Move puts the pointer at x,y.
+func (d *Driver) Move(x, y int) error {
+	return d.xdotool("mousemove", strconv.Itoa(x), strconv.Itoa(y))
+}
+
+// Click presses button count times at the current pointer position.
+func (d *Driver) Click(button, count int) error {
+	return d.xdotool("click", "--repeat", strconv.Itoa(count), strconv.Itoa(button))
+}
+
+// Down presses button without releasing it, for drags.
+func (d *Driver) Down(button int) error {
+	return d.xdotool("mousedown", strconv.Itoa(button))
+}
+
+// Up releases a button pressed with Down.
+func (d *Driver) Up(button int) error {
+	return d.xdotool("mouseup", strconv.Itoa(button))
+}
+
+// Type types text into the focused window with delay between keystrokes.
+func (d *Driver) Type(text string, delay time.Duration) error {
+	return d.xdotool("type", "--delay", strconv.Itoa(int(delay/time.Millisecond)), "--", text)
+}
+
+// Key sends key combinations such as "ctrl+a" or "Return".
+func (d *Driver) Key(keys ...string) error {
+	return d.xdotool(append([]string{"key", "--"}, keys...)...)
+}
+
+// DisplaySize returns the size of the screen.
+func (d *Driver) DisplaySize() (w, h int, err error) {
+	cmd := exec.Command("xdotool", "getdisplaygeometry")
+	cmd.Env = append(os.Environ(), "DISPLAY="+d.display)
+	out, err := cmd.Output()
+	if err != nil {
+		return 0, 0, fmt.Errorf("xdotool getdisplaygeometry failed: %v", err)
+	}
+	if _, err := fmt.Sscanf(string(out), "%d %d", &w, &h); err != nil {
+		return 0, 0, fmt.Errorf("unexpected display geometry %q", strings.TrimSpace(string(out)))
+	}
+	return w, h, nil
+}
+
+// WindowGeometry returns the position and size of the first visible
+// window whose name matches the regular expression name.
+func (d *Driver) WindowGeometry(name string) (x, y, w, h int, err error) {
+	cmd := exec.Command("xdotool", "search", "--onlyvisible", "--name", name, "getwindowgeometry", "--shell", "%1")
+	cmd.Env = append(os.Environ(), "DISPLAY="+d.display)
+	out, err := cmd.Output()
+	if err != nil {
+		return 0, 0, 0, 0, fmt.Errorf("no visible window matching %q", name)
+	}
+	vals := make(map[string]int)
+	for _, line := range strings.Split(string(out), "\n") {
+		k, v, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		n, _ := strconv.Atoi(v)
+		vals[k] = n
+	}
+	return vals["X"], vals["Y"], vals["WIDTH"], vals["HEIGHT"], nil
+}
+
+// Screenshot grabs one frame of the display as PNG.
+func (d *Driver) Screenshot() ([]byte, error) {
+	cmd := exec.Command("ffmpeg", "-loglevel", "error",
+		"-f", "x11grab", "-i", d.display,
+		"-frames:v", "1", "-f", "image2", "-vcodec", "png", "-")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to capture %s: %v: %s", d.display, err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// Pixel returns the color at x,y.
+func (d *Driver) Pixel(x, y int) (color.RGBA, error) {
+	data, err := d.Screenshot()
+	if err != nil {
+		return color.RGBA{}, err
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return color.RGBA{}, fmt.Errorf("failed to decode screenshot: %w", err)
+	}
+	if !(image.Point{x, y}).In(img.Bounds()) {
+		return color.RGBA{}, fmt.Errorf("pixel %d,%d is outside the %v screen", x, y, img.Bounds().Size())
+	}
+	return color.RGBAModel.Convert(img.At(x, y)).(color.RGBA), nil
+}
+
+// WaitForPixel polls until the pixel at x,y is within tolerance of want on
+// every channel, returning the last color seen.
+func (d *Driver) WaitForPixel(ctx context.Context, x, y int, want color.RGBA, tolerance int) (color.RGBA, error) {
+	for {
+		got, err := d.Pixel(x, y)
+		if err != nil {
+			return got, err
+		}
+		if near(got.R, want.R, tolerance) && near(got.G, want.G, tolerance) && near(got.B, want.B, tolerance) {
+			return got, nil
+		}
+		select {
+		case <-ctx.Done():
+			return got, ctx.Err()
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+}
+
+func near(a, b uint8, tolerance int) bool {
+	diff := int(a) - int(b)
+	return diff <= tolerance && diff >= -tolerance
+}
+
+// ParseColor reads "#rrggbb".
+func ParseColor(s string) (color.RGBA, error) {
+	s = strings.TrimPrefix(s, "#")
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil || len(s) != 6 {
+		return color.RGBA{}, fmt.Errorf("invalid color %q, want #rrggbb", s)
+	}
+	return color.RGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 0xff}, nil
+}
+
+// FormatColor writes c as "#rrggbb".
+func FormatColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
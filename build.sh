@@ -7,7 +7,12 @@ BUILD_DIR="build"
 
 mkdir -p $BUILD_DIR
 
+# Optional features, e.g. TAGS=tsnet ./build.sh
+# (tsnet needs `go get tailscale.com/tsnet` first; pam needs the libpam
+# headers and `go get github.com/msteinert/pam/v2`).
+TAGS="${TAGS:-}"
+
 # Build for Linux amd64
-GOOS=linux GOARCH=amd64 go build -o $BUILD_DIR/$APP_NAME .
+GOOS=linux GOARCH=amd64 go build -tags "$TAGS" -o $BUILD_DIR/$APP_NAME .
 
 echo "Build complete: $BUILD_DIR/$APP_NAME"
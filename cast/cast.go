@@ -0,0 +1,102 @@
+package cast
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+const (
+	KindChromecast = "chromecast"
+	KindDLNA       = "dlna"
+)
+
+// Device is a renderer found on the LAN.
+type Device struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+	Host string `json:"host"`
+
+	port        int    // Chromecast control port
+	controlURL  string // DLNA AVTransport control URL
+	serviceType string
+}
+
+// Media is what a device is told to play.
+type Media struct {
+	URL         string
+	ContentType string
+	Title       string
+}
+
+// Session is media playing on a device.
+type Session interface {
+	Stop() error
+}
+
+// Discover searches the LAN for Chromecasts and DLNA renderers for up to
+// timeout.
+func Discover(timeout time.Duration) ([]Device, error) {
+	var (
+		wg       sync.WaitGroup
+		ccs, dls []Device
+		ccErr    error
+		dlErr    error
+	)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		ccs, ccErr = discoverChromecasts(timeout)
+	}()
+	go func() {
+		defer wg.Done()
+		dls, dlErr = discoverRenderers(timeout)
+	}()
+	wg.Wait()
+	if ccErr != nil && dlErr != nil {
+		return nil, fmt.Errorf("failed to search for devices: %v; %v", ccErr, dlErr)
+	}
+	return append(ccs, dls...), nil
+}
+
+// Play tells dev to play m.
+func Play(dev Device, m Media) (Session, error) {
+	switch dev.Kind {
+	case KindChromecast:
+		return playChromecast(dev, m)
+	case KindDLNA:
+		return playRenderer(dev, m)
+	}
+	return nil, fmt.Errorf("unknown device kind %q", dev.Kind)
+}
+
+// LocalIP returns the address of this host on the network dev is on, the
+// one dev can fetch media from.
+func LocalIP(dev Device) (string, error) {
+	c, err := net.Dial("udp4", net.JoinHostPort(dev.Host, "9"))
+	if err != nil {
+		return "", fmt.Errorf("no route to %s: %w", dev.Host, err)
+	}
+	defer c.Close()
+	return c.LocalAddr().(*net.UDPAddr).IP.String(), nil
+}
+
+// Transcoder returns the process converting the MPEG-1 stream on its
+// stdin to what kind plays, on its stdout, and that format's MIME type.
+// DLNA renderers take the stream as is, muxed into MPEG program stream;
+// Chromecasts need VP8 in WebM.
+func Transcoder(kind, bitrate string) (*exec.Cmd, string) {
+	args := []string{"-loglevel", "error", "-fflags", "+genpts", "-f", "mpegvideo", "-i", "pipe:0"}
+	if kind == KindChromecast {
+		args = append(args,
+			"-c:v", "libvpx", "-deadline", "realtime", "-cpu-used", "8",
+			"-b:v", bitrate, "-g", "60",
+			"-f", "webm", "-live", "1", "pipe:1")
+		return exec.Command("ffmpeg", args...), "video/webm"
+	}
+	args = append(args, "-c:v", "copy", "-f", "vob", "pipe:1")
+	return exec.Command("ffmpeg", args...), "video/mpeg"
+}
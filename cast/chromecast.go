@@ -0,0 +1,265 @@
+package cast
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	nsConnection = "urn:x-cast:com.google.cast.tp.connection"
+	nsHeartbeat  = "urn:x-cast:com.google.cast.tp.heartbeat"
+	nsReceiver   = "urn:x-cast:com.google.cast.receiver"
+	nsMedia      = "urn:x-cast:com.google.cast.media"
+
+	// defaultReceiver is Google's Default Media Receiver app.
+	defaultReceiver = "CC1AD845"
+	senderID        = "sender-0"
+	receiverID      = "receiver-0"
+)
+
+// castMessage is the CastV2 protobuf envelope; only string payloads are
+// used.
+type castMessage struct {
+	source, destination, namespace, payload string
+}
+
+func appendField(b []byte, field int, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(field<<3|2))
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+func (m castMessage) marshal() []byte {
+	b := []byte{0x08, 0} // protocol_version CASTV2_1_0
+	b = appendField(b, 2, m.source)
+	b = appendField(b, 3, m.destination)
+	b = appendField(b, 4, m.namespace)
+	b = append(b, 0x28, 0) // payload_type STRING
+	return appendField(b, 6, m.payload)
+}
+
+func unmarshalCastMessage(b []byte) (castMessage, error) {
+	var m castMessage
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return m, fmt.Errorf("bad field key")
+		}
+		b = b[n:]
+		switch key & 7 {
+		case 0:
+			_, n = binary.Uvarint(b)
+			if n <= 0 {
+				return m, fmt.Errorf("bad varint")
+			}
+			b = b[n:]
+		case 2:
+			size, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < size {
+				return m, fmt.Errorf("bad field length")
+			}
+			s := string(b[n : n+int(size)])
+			b = b[n+int(size):]
+			switch key >> 3 {
+			case 2:
+				m.source = s
+			case 3:
+				m.destination = s
+			case 4:
+				m.namespace = s
+			case 6:
+				m.payload = s
+			}
+		default:
+			return m, fmt.Errorf("unsupported wire type %d", key&7)
+		}
+	}
+	return m, nil
+}
+
+// chromecast is a connection to a Cast device running the Default Media
+// Receiver with our stream loaded.
+type chromecast struct {
+	dev  Device
+	conn net.Conn
+
+	mu        sync.Mutex
+	requestID int
+	sessionID string
+	done      chan struct{}
+}
+
+func (c *chromecast) send(dest, ns string, payload map[string]any) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := payload["requestId"]; !ok && ns != nsConnection && ns != nsHeartbeat {
+		c.requestID++
+		payload["requestId"] = c.requestID
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	msg := castMessage{source: senderID, destination: dest, namespace: ns, payload: string(data)}.marshal()
+	frame := binary.BigEndian.AppendUint32(nil, uint32(len(msg)))
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_, err = c.conn.Write(append(frame, msg...))
+	return err
+}
+
+func (c *chromecast) read() (castMessage, map[string]any, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(c.conn, size[:]); err != nil {
+		return castMessage{}, nil, err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > 64*1024 {
+		return castMessage{}, nil, fmt.Errorf("message too large (%d bytes)", n)
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(c.conn, buf); err != nil {
+		return castMessage{}, nil, err
+	}
+	m, err := unmarshalCastMessage(buf)
+	if err != nil {
+		return m, nil, err
+	}
+	var payload map[string]any
+	json.Unmarshal([]byte(m.payload), &payload)
+	if m.namespace == nsHeartbeat && payload["type"] == "PING" {
+		c.send(m.source, nsHeartbeat, map[string]any{"type": "PONG"})
+	}
+	return m, payload, nil
+}
+
+// await reads messages until match accepts one, failing on the media and
+// receiver errors.
+func (c *chromecast) await(match func(castMessage, map[string]any) bool) (map[string]any, error) {
+	for {
+		m, payload, err := c.read()
+		if err != nil {
+			return nil, fmt.Errorf("lost connection to %s: %w", c.dev.Name, err)
+		}
+		switch payload["type"] {
+		case "LAUNCH_ERROR", "LOAD_FAILED", "LOAD_CANCELLED", "INVALID_REQUEST":
+			return nil, fmt.Errorf("%s refused the stream: %s", c.dev.Name, m.payload)
+		}
+		if match(m, payload) {
+			return payload, nil
+		}
+	}
+}
+
+func playChromecast(dev Device, m Media) (Session, error) {
+	addr := net.JoinHostPort(dev.Host, strconv.Itoa(dev.port))
+	// Cast devices present certificates signed by Google's device CA,
+	// which is not in any system pool.
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 10 * time.Second}, "tcp", addr, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", dev.Name, err)
+	}
+	c := &chromecast{dev: dev, conn: conn, done: make(chan struct{})}
+	conn.SetReadDeadline(time.Now().Add(30 * time.Second))
+
+	if err := c.send(receiverID, nsConnection, map[string]any{"type": "CONNECT"}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := c.send(receiverID, nsReceiver, map[string]any{"type": "LAUNCH", "appId": defaultReceiver}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	var transportID string
+	_, err = c.await(func(msg castMessage, p map[string]any) bool {
+		if p["type"] != "RECEIVER_STATUS" {
+			return false
+		}
+		status, _ := p["status"].(map[string]any)
+		apps, _ := status["applications"].([]any)
+		for _, a := range apps {
+			app, _ := a.(map[string]any)
+			if app["appId"] == defaultReceiver {
+				transportID, _ = app["transportId"].(string)
+				c.sessionID, _ = app["sessionId"].(string)
+				return transportID != ""
+			}
+		}
+		return false
+	})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if err := c.send(transportID, nsConnection, map[string]any{"type": "CONNECT"}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := c.send(transportID, nsMedia, map[string]any{
+		"type":     "LOAD",
+		"autoplay": true,
+		"media": map[string]any{
+			"contentId":   m.URL,
+			"contentType": m.ContentType,
+			"streamType":  "LIVE",
+			"metadata":    map[string]any{"metadataType": 0, "title": m.Title},
+		},
+	}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if _, err := c.await(func(msg castMessage, p map[string]any) bool {
+		return p["type"] == "MEDIA_STATUS"
+	}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	go c.keepAlive()
+	return c, nil
+}
+
+// keepAlive pings the device, which drops senders that go quiet, and
+// answers its pings until the connection ends.
+func (c *chromecast) keepAlive() {
+	go func() {
+		t := time.NewTicker(5 * time.Second)
+		defer t.Stop()
+		for {
+			select {
+			case <-c.done:
+				return
+			case <-t.C:
+				if c.send(receiverID, nsHeartbeat, map[string]any{"type": "PING"}) != nil {
+					return
+				}
+			}
+		}
+	}()
+	for {
+		if _, _, err := c.read(); err != nil {
+			break
+		}
+	}
+	c.mu.Lock()
+	select {
+	case <-c.done:
+	default:
+		close(c.done)
+	}
+	c.mu.Unlock()
+}
+
+func (c *chromecast) Stop() error {
+	err := c.send(receiverID, nsReceiver, map[string]any{"type": "STOP", "sessionId": c.sessionID})
+	c.conn.Close()
+	return err
+}
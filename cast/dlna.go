@@ -0,0 +1,209 @@
+package cast
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const ssdpSearch = "M-SEARCH * HTTP/1.1\r\n" +
+	"HOST: 239.255.255.250:1900\r\n" +
+	"MAN: \"ssdp:discover\"\r\n" +
+	"MX: 2\r\n" +
+	"ST: urn:schemas-upnp-org:device:MediaRenderer:1\r\n\r\n"
+
+// discoverRenderers sends an SSDP search and describes every media
+// renderer that answers within timeout.
+func discoverRenderers(timeout time.Duration) ([]Device, error) {
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	dst := &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: 1900}
+	if _, err := conn.WriteTo([]byte(ssdpSearch), dst); err != nil {
+		return nil, err
+	}
+
+	locations := make(map[string]bool)
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			break
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if err != nil {
+			continue
+		}
+		if loc := resp.Header.Get("Location"); loc != "" {
+			locations[loc] = true
+		}
+	}
+
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		devices []Device
+	)
+	for loc := range locations {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			dev, err := describeRenderer(loc)
+			if err != nil {
+				fmt.Printf("Warning: skipping renderer at %s: %v\n", loc, err)
+				return
+			}
+			mu.Lock()
+			devices = append(devices, dev)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return devices, nil
+}
+
+type upnpDevice struct {
+	FriendlyName string `xml:"friendlyName"`
+	UDN          string `xml:"UDN"`
+	Services     []struct {
+		ServiceType string `xml:"serviceType"`
+		ControlURL  string `xml:"controlURL"`
+	} `xml:"serviceList>service"`
+	Devices []upnpDevice `xml:"deviceList>device"`
+}
+
+// findAVTransport returns the device offering AVTransport and the
+// service's type and control URL.
+func (d upnpDevice) findAVTransport() (upnpDevice, string, string) {
+	for _, s := range d.Services {
+		if strings.Contains(s.ServiceType, ":AVTransport:") {
+			return d, s.ServiceType, s.ControlURL
+		}
+	}
+	for _, child := range d.Devices {
+		if dev, st, cu := child.findAVTransport(); cu != "" {
+			return dev, st, cu
+		}
+	}
+	return d, "", ""
+}
+
+func describeRenderer(loc string) (Device, error) {
+	resp, err := (&http.Client{Timeout: 5 * time.Second}).Get(loc)
+	if err != nil {
+		return Device{}, fmt.Errorf("failed to fetch description: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var root struct {
+		URLBase string     `xml:"URLBase"`
+		Device  upnpDevice `xml:"device"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&root); err != nil {
+		return Device{}, fmt.Errorf("failed to parse description: %w", err)
+	}
+	dev, serviceType, control := root.Device.findAVTransport()
+	if control == "" {
+		return Device{}, fmt.Errorf("renderer has no AVTransport service")
+	}
+
+	base, _ := url.Parse(loc)
+	if root.URLBase != "" {
+		if b, err := url.Parse(root.URLBase); err == nil {
+			base = b
+		}
+	}
+	ref, err := url.Parse(control)
+	if err != nil {
+		return Device{}, fmt.Errorf("invalid control URL %q: %w", control, err)
+	}
+	return Device{
+		ID:          strings.TrimPrefix(dev.UDN, "uuid:"),
+		Name:        dev.FriendlyName,
+		Kind:        KindDLNA,
+		Host:        base.Hostname(),
+		controlURL:  base.ResolveReference(ref).String(),
+		serviceType: serviceType,
+	}, nil
+}
+
+// renderer is a DLNA renderer playing our stream.
+type renderer struct {
+	dev Device
+}
+
+// didl describes m to renderers that want to know what they are about to
+// play before they fetch it.
+func didl(m Media) string {
+	var b strings.Builder
+	b.WriteString(`<DIDL-Lite xmlns="urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:upnp="urn:schemas-upnp-org:metadata-1-0/upnp/">`)
+	b.WriteString(`<item id="0" parentID="-1" restricted="1"><dc:title>`)
+	xml.EscapeText(&b, []byte(m.Title))
+	b.WriteString(`</dc:title><upnp:class>object.item.videoItem</upnp:class>`)
+	fmt.Fprintf(&b, `<res protocolInfo="http-get:*:%s:*">`, m.ContentType)
+	xml.EscapeText(&b, []byte(m.URL))
+	b.WriteString(`</res></item></DIDL-Lite>`)
+	return b.String()
+}
+
+func playRenderer(dev Device, m Media) (Session, error) {
+	r := &renderer{dev: dev}
+	if err := r.soap("SetAVTransportURI", [][2]string{
+		{"InstanceID", "0"},
+		{"CurrentURI", m.URL},
+		{"CurrentURIMetaData", didl(m)},
+	}); err != nil {
+		return nil, err
+	}
+	if err := r.soap("Play", [][2]string{{"InstanceID", "0"}, {"Speed", "1"}}); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *renderer) Stop() error {
+	return r.soap("Stop", [][2]string{{"InstanceID", "0"}})
+}
+
+// soap invokes action on the renderer's AVTransport service.
+func (r *renderer) soap(action string, args [][2]string) error {
+	var body strings.Builder
+	body.WriteString(`<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body>`)
+	fmt.Fprintf(&body, `<u:%s xmlns:u="%s">`, action, r.dev.serviceType)
+	for _, a := range args {
+		body.WriteString("<" + a[0] + ">")
+		xml.EscapeText(&body, []byte(a[1]))
+		body.WriteString("</" + a[0] + ">")
+	}
+	fmt.Fprintf(&body, `</u:%s></s:Body></s:Envelope>`, action)
+
+	req, err := http.NewRequest("POST", r.dev.controlURL, strings.NewReader(body.String()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", fmt.Sprintf(`"%s#%s"`, r.dev.serviceType, action))
+
+	resp, err := (&http.Client{Timeout: 5 * time.Second}).Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach %s: %w", r.dev.Name, err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s refused %s: %s", r.dev.Name, action, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+package cast
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	googlecastService = "_googlecast._tcp.local"
+
+	dnsTypeA   = 1
+	dnsTypePTR = 12
+	dnsTypeTXT = 16
+	dnsTypeSRV = 33
+)
+
+// mdnsQuery asks for PTR records of service, requesting unicast replies
+// (the QU bit) so they come back to our socket.
+func mdnsQuery(service string) []byte {
+	msg := make([]byte, 12)
+	binary.BigEndian.PutUint16(msg[4:], 1) // one question
+	for _, label := range strings.Split(service, ".") {
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	msg = append(msg, 0)
+	msg = binary.BigEndian.AppendUint16(msg, dnsTypePTR)
+	return binary.BigEndian.AppendUint16(msg, 0x8001)
+}
+
+// readName decodes the possibly compressed name at off in msg and returns
+// it with the offset just past it.
+func readName(msg []byte, off int) (string, int, error) {
+	var labels []string
+	end := -1
+	for jumps := 0; ; {
+		if off >= len(msg) {
+			return "", 0, fmt.Errorf("name out of bounds")
+		}
+		n := int(msg[off])
+		switch {
+		case n == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return strings.Join(labels, "."), end, nil
+		case n&0xC0 == 0xC0:
+			if off+1 >= len(msg) || jumps > 16 {
+				return "", 0, fmt.Errorf("bad name pointer")
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3FFF)
+			jumps++
+		default:
+			if off+1+n > len(msg) {
+				return "", 0, fmt.Errorf("label out of bounds")
+			}
+			labels = append(labels, string(msg[off+1:off+1+n]))
+			off += 1 + n
+		}
+	}
+}
+
+type srvRecord struct {
+	target string
+	port   int
+}
+
+// castRecords accumulates what mDNS answers say about Chromecasts.
+type castRecords struct {
+	instances map[string]bool
+	srv       map[string]srvRecord
+	txt       map[string]map[string]string
+	hosts     map[string]string
+}
+
+func (rs *castRecords) parse(msg []byte) error {
+	if len(msg) < 12 {
+		return fmt.Errorf("short message")
+	}
+	qd := int(binary.BigEndian.Uint16(msg[4:]))
+	records := int(binary.BigEndian.Uint16(msg[6:])) + int(binary.BigEndian.Uint16(msg[8:])) + int(binary.BigEndian.Uint16(msg[10:]))
+	off := 12
+	for i := 0; i < qd; i++ {
+		_, next, err := readName(msg, off)
+		if err != nil {
+			return err
+		}
+		off = next + 4
+	}
+	for i := 0; i < records; i++ {
+		name, next, err := readName(msg, off)
+		if err != nil {
+			return err
+		}
+		if next+10 > len(msg) {
+			return fmt.Errorf("record out of bounds")
+		}
+		typ := binary.BigEndian.Uint16(msg[next:])
+		size := int(binary.BigEndian.Uint16(msg[next+8:]))
+		data := next + 10
+		if data+size > len(msg) {
+			return fmt.Errorf("record data out of bounds")
+		}
+		rdata := msg[data : data+size]
+		off = data + size
+
+		switch typ {
+		case dnsTypePTR:
+			if strings.EqualFold(name, googlecastService) {
+				if inst, _, err := readName(msg, data); err == nil {
+					rs.instances[inst] = true
+				}
+			}
+		case dnsTypeSRV:
+			if size < 7 {
+				continue
+			}
+			target, _, err := readName(msg, data+6)
+			if err != nil {
+				continue
+			}
+			rs.srv[name] = srvRecord{target, int(binary.BigEndian.Uint16(rdata[4:]))}
+		case dnsTypeTXT:
+			kv := make(map[string]string)
+			for p := 0; p < len(rdata); {
+				n := int(rdata[p])
+				if p+1+n > len(rdata) {
+					break
+				}
+				k, v, _ := strings.Cut(string(rdata[p+1:p+1+n]), "=")
+				kv[k] = v
+				p += 1 + n
+			}
+			rs.txt[name] = kv
+		case dnsTypeA:
+			if size == 4 {
+				rs.hosts[name] = net.IP(rdata).String()
+			}
+		}
+	}
+	return nil
+}
+
+// discoverChromecasts browses mDNS for Cast devices for up to timeout.
+func discoverChromecasts(timeout time.Duration) ([]Device, error) {
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	dst := &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+	if _, err := conn.WriteTo(mdnsQuery(googlecastService), dst); err != nil {
+		return nil, err
+	}
+
+	rs := &castRecords{
+		instances: make(map[string]bool),
+		srv:       make(map[string]srvRecord),
+		txt:       make(map[string]map[string]string),
+		hosts:     make(map[string]string),
+	}
+	// Devices that leave out the A record are reached at the address they
+	// answered from.
+	senders := make(map[string]string)
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			break
+		}
+		before := len(rs.srv)
+		if rs.parse(buf[:n]) != nil {
+			continue
+		}
+		if len(rs.srv) != before {
+			for inst := range rs.srv {
+				if _, ok := senders[inst]; !ok {
+					senders[inst] = from.(*net.UDPAddr).IP.String()
+				}
+			}
+		}
+	}
+
+	var devices []Device
+	for inst := range rs.instances {
+		srv, ok := rs.srv[inst]
+		if !ok {
+			continue
+		}
+		host := rs.hosts[srv.target]
+		if host == "" {
+			host = senders[inst]
+		}
+		txt := rs.txt[inst]
+		name := txt["fn"]
+		if name == "" {
+			name, _, _ = strings.Cut(inst, ".")
+		}
+		id := txt["id"]
+		if id == "" {
+			id = inst
+		}
+		devices = append(devices, Device{
+			ID:   id,
+			Name: name,
+			Kind: KindChromecast,
+			Host: host,
+			port: srv.port,
+		})
+	}
+	return devices, nil
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"cmp"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nathfavour/remoter/cast"
+)
+
+// castSearchTimeout is how long a device search listens for answers.
+const castSearchTimeout = 3 * time.Second
+
+var (
+	// castDevices are the devices the last search found, by ID.
+	castDevices    = make(map[string]cast.Device)
+	castDevicesMux sync.Mutex
+
+	activeCasts    = make(map[string]*castJob)
+	activeCastsMux sync.Mutex
+)
+
+// castJob is a stream being played by a device on the LAN. The device
+// fetches it from /cast/<ID>, which needs no login: the ID is the secret.
+type castJob struct {
+	ID        string      `json:"id"`
+	Device    cast.Device `json:"device"`
+	Stream    string      `json:"stream,omitempty"`
+	User      string      `json:"user,omitempty"`
+	StartedAt time.Time   `json:"started_at"`
+
+	session cast.Session
+	done    chan struct{}
+}
+
+// findCastDevice looks up a device by ID or name, searching the LAN when
+// the last search did not find it.
+func findCastDevice(ref string) (cast.Device, error) {
+	lookup := func() (cast.Device, bool) {
+		castDevicesMux.Lock()
+		defer castDevicesMux.Unlock()
+		for _, dev := range castDevices {
+			if dev.ID == ref || strings.EqualFold(dev.Name, ref) {
+				return dev, true
+			}
+		}
+		return cast.Device{}, false
+	}
+	if dev, ok := lookup(); ok {
+		return dev, nil
+	}
+	if _, err := searchCastDevices(); err != nil {
+		return cast.Device{}, err
+	}
+	if dev, ok := lookup(); ok {
+		return dev, nil
+	}
+	return cast.Device{}, fmt.Errorf("no cast device %q found", ref)
+}
+
+func searchCastDevices() ([]cast.Device, error) {
+	devices, err := cast.Discover(castSearchTimeout)
+	if err != nil {
+		return nil, err
+	}
+	castDevicesMux.Lock()
+	defer castDevicesMux.Unlock()
+	clear(castDevices)
+	for _, dev := range devices {
+		castDevices[dev.ID] = dev
+	}
+	return devices, nil
+}
+
+// startCast has the device ref play stream on behalf of user.
+func startCast(ref, stream, user string) (*castJob, error) {
+	if !streamExists(stream) {
+		return nil, fmt.Errorf("no such stream %q", stream)
+	}
+	dev, err := findCastDevice(ref)
+	if err != nil {
+		return nil, err
+	}
+	ip, err := cast.LocalIP(dev)
+	if err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	job := &castJob{
+		ID:        hex.EncodeToString(b),
+		Device:    dev,
+		Stream:    stream,
+		User:      user,
+		StartedAt: time.Now(),
+		done:      make(chan struct{}),
+	}
+	// Registered first: the device fetches the stream before Play returns.
+	activeCastsMux.Lock()
+	activeCasts[job.ID] = job
+	activeCastsMux.Unlock()
+
+	_, contentType := cast.Transcoder(dev.Kind, "")
+	services.mu.Lock()
+	title := cmp.Or(services.cfg.Title, "remoter")
+	port := services.cfg.Port
+	services.mu.Unlock()
+	session, err := cast.Play(dev, cast.Media{
+		URL:         fmt.Sprintf("http://%s:%d/cast/%s", ip, port, job.ID),
+		ContentType: contentType,
+		Title:       title,
+	})
+	if err != nil {
+		activeCastsMux.Lock()
+		delete(activeCasts, job.ID)
+		activeCastsMux.Unlock()
+		close(job.done)
+		return nil, err
+	}
+	activeCastsMux.Lock()
+	job.session = session
+	activeCastsMux.Unlock()
+	log.Printf("Casting %s to %s (%s)", cmp.Or(stream, "the main display"), dev.Name, dev.Kind)
+	emit(eventCastStarted, job)
+	return job, nil
+}
+
+// stop ends playback on the device and closes its connections.
+func (job *castJob) stop() {
+	activeCastsMux.Lock()
+	if _, ok := activeCasts[job.ID]; !ok {
+		activeCastsMux.Unlock()
+		return
+	}
+	delete(activeCasts, job.ID)
+	session := job.session
+	activeCastsMux.Unlock()
+
+	if session != nil {
+		if err := session.Stop(); err != nil {
+			log.Printf("Warning: failed to stop %s: %v", job.Device.Name, err)
+		}
+	}
+	close(job.done)
+	log.Printf("Stopped casting to %s", job.Device.Name)
+	emit(eventCastStopped, job)
+}
+
+func activeCast(id string) (*castJob, bool) {
+	activeCastsMux.Lock()
+	defer activeCastsMux.Unlock()
+	job, ok := activeCasts[id]
+	return job, ok
+}
+
+// stopCasts ends every cast, used on shutdown.
+func stopCasts() {
+	activeCastsMux.Lock()
+	jobs := make([]*castJob, 0, len(activeCasts))
+	for _, job := range activeCasts {
+		jobs = append(jobs, job)
+	}
+	activeCastsMux.Unlock()
+	for _, job := range jobs {
+		job.stop()
+	}
+}
+
+// castAccess admits a device fetching the stream of an active cast.
+func castAccess(r *http.Request) (*castJob, bool) {
+	id, ok := strings.CutPrefix(r.URL.Path, "/cast/")
+	if !ok {
+		return nil, false
+	}
+	return activeCast(id)
+}
+
+// handleCastMedia serves a cast's stream in the format its device plays,
+// converted by ffmpeg from the broadcast.
+func handleCastMedia(w http.ResponseWriter, r *http.Request) {
+	job, ok := activeCast(r.PathValue("id"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	services.mu.Lock()
+	bitrate := services.cfg.Bitrate
+	services.mu.Unlock()
+	cmd, contentType := cast.Transcoder(job.Device.Kind, bitrate)
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	if job.Device.Kind == cast.KindDLNA {
+		w.Header().Set("transferMode.dlna.org", "Streaming")
+		w.Header().Set("contentFeatures.dlna.org", "DLNA.ORG_OP=00;DLNA.ORG_CI=1;DLNA.ORG_FLAGS=01700000000000000000000000000000")
+	}
+	// Renderers probe with HEAD before they play.
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := cmd.Start(); err != nil {
+		log.Printf("Failed to start cast transcoder: %v", err)
+		http.Error(w, "failed to start transcoder", http.StatusInternalServerError)
+		return
+	}
+
+	c := newClient(transportCast, r)
+	c.stream = job.Stream
+	c.user = job.User
+	c.w = stdin
+	totalClients := addClient(c)
+	log.Printf("Cast device %s connected. Total clients: %d", job.Device.Name, totalClients)
+
+	copied := make(chan struct{})
+	go func() {
+		defer close(copied)
+		io.Copy(flushWriter{w}, stdout)
+	}()
+
+	select {
+	case <-r.Context().Done():
+	case <-job.done:
+	case <-c.done:
+	case <-copied:
+	}
+	// Killing the transcoder first unblocks a write stuck on its stdin.
+	cmd.Process.Kill()
+	c.close()
+	stdin.Close()
+	<-copied
+	cmd.Wait()
+	totalClients = removeClient(c)
+	log.Printf("Cast device %s disconnected. Total clients: %d", job.Device.Name, totalClients)
+}
+
+// flushWriter flushes every write, so the device gets data as it is
+// transcoded.
+type flushWriter struct {
+	w http.ResponseWriter
+}
+
+func (f flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if fl, ok := f.w.(http.Flusher); ok {
+		fl.Flush()
+	}
+	return n, err
+}
+
+func handleListCastDevices(w http.ResponseWriter, r *http.Request) {
+	devices, err := searchCastDevices()
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	if devices == nil {
+		devices = []cast.Device{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"devices": devices})
+}
+
+func handleListCasts(w http.ResponseWriter, r *http.Request) {
+	activeCastsMux.Lock()
+	jobs := make([]*castJob, 0, len(activeCasts))
+	for _, job := range activeCasts {
+		jobs = append(jobs, job)
+	}
+	activeCastsMux.Unlock()
+	writeJSON(w, http.StatusOK, map[string]any{"casts": jobs})
+}
+
+// handleStartCast casts a stream to a device given by ID or name.
+func handleStartCast(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Device  string `json:"device"`
+		Session string `json:"session"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	if req.Device == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("device is required"))
+		return
+	}
+	job, err := startCast(req.Device, req.Session, requestAuth(r).User)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	log.Printf("API: casting to %s", job.Device.Name)
+	writeJSON(w, http.StatusCreated, job)
+}
+
+func handleStopCast(w http.ResponseWriter, r *http.Request) {
+	job, ok := activeCast(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no cast %q", r.PathValue("id")))
+		return
+	}
+	job.stop()
+	log.Printf("API: stopped casting to %s", job.Device.Name)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nathfavour/remoter/idle"
+	"github.com/nathfavour/remoter/storage"
+)
+
+// chaptersSuffix names the sidecar holding a recording's chapters next to
+// it in recordingStore.
+const chaptersSuffix = ".chapters.json"
+
+// idleGap is how long nobody has to touch the main desktop before its
+// recordings get an "Idle" chapter.
+const idleGap = 2 * time.Minute
+
+// Chapter marks a point of interest in a recording, At seconds in.
+type Chapter struct {
+	At    float64   `json:"at"`
+	Time  time.Time `json:"time"`
+	Title string    `json:"title"`
+	Event string    `json:"event,omitempty"`
+}
+
+// addChapter marks t in the recording; chapters may arrive out of order.
+func (rec *recording) addChapter(t time.Time, title, event string) {
+	at := t.Sub(rec.StartedAt).Seconds()
+	if at < 0 {
+		at, t = 0, rec.StartedAt
+	}
+	rec.chaptersMu.Lock()
+	rec.chapters = append(rec.chapters, Chapter{At: at, Time: t, Title: title, Event: event})
+	rec.chaptersMu.Unlock()
+}
+
+func (rec *recording) sortedChapters() []Chapter {
+	rec.chaptersMu.Lock()
+	chapters := append([]Chapter(nil), rec.chapters...)
+	rec.chaptersMu.Unlock()
+	sort.SliceStable(chapters, func(i, j int) bool { return chapters[i].At < chapters[j].At })
+	return chapters
+}
+
+// chapterList is the JSON shape of a recording's chapters, stored beside
+// it and served by the chapters API.
+type chapterList struct {
+	Name     string    `json:"name"`
+	Duration float64   `json:"duration"`
+	Chapters []Chapter `json:"chapters"`
+}
+
+func (rec *recording) chapterList() chapterList {
+	return chapterList{Name: rec.Name, Duration: time.Since(rec.StartedAt).Seconds(), Chapters: rec.sortedChapters()}
+}
+
+// saveChapters stores the recording's chapters beside it.
+func (rec *recording) saveChapters() error {
+	data, err := json.Marshal(rec.chapterList())
+	if err != nil {
+		return err
+	}
+	w, err := recordingStore.Create(rec.Name + chaptersSuffix)
+	if err != nil {
+		return fmt.Errorf("failed to create chapters: %w", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return fmt.Errorf("failed to write chapters: %w", err)
+	}
+	return w.Close()
+}
+
+func loadChapters(name string) (chapterList, error) {
+	var list chapterList
+	rd, err := recordingStore.Open(name + chaptersSuffix)
+	if err != nil {
+		return list, err
+	}
+	defer rd.Close()
+	data, err := io.ReadAll(rd)
+	if err != nil {
+		return list, fmt.Errorf("failed to read chapters: %w", err)
+	}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return list, fmt.Errorf("failed to parse chapters: %w", err)
+	}
+	return list, nil
+}
+
+// deleteChapters removes a recording's chapters, which older recordings
+// may not have.
+func deleteChapters(name string) {
+	if err := recordingStore.Delete(name + chaptersSuffix); err != nil && !errors.Is(err, storage.ErrNotExist) {
+		log.Printf("Warning: failed to delete chapters of %s: %v", name, err)
+	}
+}
+
+// markChapters turns significant events into chapters of the recordings
+// of the stream they concern.
+func markChapters(typ string, data any) {
+	var stream, title string
+	switch typ {
+	case eventClientConnected, eventClientDisconnected:
+		info, ok := data.(clientInfo)
+		if !ok || (info.Role != "control" && info.Role != "admin") {
+			return
+		}
+		who := info.User
+		if who == "" {
+			who = info.RemoteAddr
+		}
+		stream, title = info.Session, "Controller joined: "+who
+		if typ == eventClientDisconnected {
+			title = "Controller left: " + who
+		}
+	case eventControlGranted:
+		m, _ := data.(map[string]any)
+		stream, _ = m["session"].(string)
+		title = "Control granted"
+		if by, _ := m["by"].(string); by != "" {
+			title += " by " + by
+		}
+	case eventControlRevoked:
+		m, _ := data.(map[string]any)
+		stream, _ = m["session"].(string)
+		title = "Control released"
+	case eventEncoderStarted:
+		title = "Source started"
+	case eventEncoderExited:
+		title = "Source lost"
+	case eventStreamResolution:
+		m, _ := data.(map[string]any)
+		title = fmt.Sprintf("Resolution changed to %v", m["res"])
+	case eventStreamPaused:
+		title = "Paused"
+	case eventStreamResumed:
+		title = "Resumed"
+	case eventScreenLocked:
+		title = "Screen locked"
+	case eventScreenUnlocked:
+		title = "Screen unlocked"
+	default:
+		return
+	}
+	now := time.Now()
+	for _, rec := range recordingsOf(stream) {
+		rec.addChapter(now, title, typ)
+	}
+}
+
+func recordingsOf(stream string) []*recording {
+	activeRecordingsMux.Lock()
+	defer activeRecordingsMux.Unlock()
+	var recs []*recording
+	for _, rec := range activeRecordings {
+		if rec.Stream == stream {
+			recs = append(recs, rec)
+		}
+	}
+	return recs
+}
+
+// watchIdleGaps marks where the main desktop went idle for idleGap or
+// more, and where it became active again, in its recordings.
+func watchIdleGaps(display string) {
+	t := time.NewTicker(idleCheckInterval)
+	defer t.Stop()
+	idleSince := time.Time{}
+	for range t.C {
+		recs := recordingsOf("")
+		if len(recs) == 0 {
+			idleSince = time.Time{}
+			continue
+		}
+		idleFor, err := idle.Duration(display)
+		if err != nil {
+			continue
+		}
+		now := time.Now()
+		switch {
+		case idleFor >= idleGap && idleSince.IsZero():
+			// The gap began when input stopped, not when it was noticed.
+			idleSince = now.Add(-idleFor)
+			for _, rec := range recs {
+				rec.addChapter(idleSince, "Idle", "idle")
+			}
+		case idleFor < idleGap && !idleSince.IsZero():
+			idleSince = time.Time{}
+			for _, rec := range recs {
+				rec.addChapter(now.Add(-idleFor), "Activity resumed", "active")
+			}
+		}
+	}
+}
+
+// handleRecordingChapters lists a recording's chapters, as JSON or, with
+// ?format=vtt, as a WebVTT chapters track for HTML5 players.
+func handleRecordingChapters(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	var list chapterList
+	if rec, ok := activeRecording(name); ok {
+		list = rec.chapterList()
+	} else {
+		var err error
+		if list, err = loadChapters(name); err != nil {
+			status := http.StatusBadGateway
+			if errors.Is(err, storage.ErrNotExist) {
+				status = http.StatusNotFound
+			}
+			writeError(w, status, err)
+			return
+		}
+	}
+	if r.URL.Query().Get("format") != "vtt" {
+		writeJSON(w, http.StatusOK, list)
+		return
+	}
+	var b strings.Builder
+	b.WriteString("WEBVTT\n")
+	for i, c := range list.Chapters {
+		end := max(list.Duration, c.At)
+		if i+1 < len(list.Chapters) {
+			end = max(list.Chapters[i+1].At, c.At)
+		}
+		fmt.Fprintf(&b, "\n%d\n%s --> %s\n%s\n", i+1, vttTime(c.At), vttTime(end), c.Title)
+	}
+	w.Header().Set("Content-Type", "text/vtt; charset=utf-8")
+	io.WriteString(w, b.String())
+}
+
+func vttTime(seconds float64) string {
+	d := time.Duration(seconds * float64(time.Second))
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60, d.Milliseconds()%1000)
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+	"github.com/nathfavour/remoter/i18n"
+	"github.com/nathfavour/remoter/zst"
+)
+
+// ChatConfig enables a text chat between the viewers of a stream and the
+// host, at /chat (/s/{id}/chat for sessions) and through the API.
+type ChatConfig struct {
+	Enabled bool `json:"enabled"`
+	// OSD shows viewers' messages on the host's screen as desktop
+	// notifications, through notify-send.
+	OSD bool `json:"osd,omitempty"`
+	// History is how many messages of each stream newcomers are shown,
+	// default 50.
+	History int `json:"history,omitempty"`
+}
+
+const (
+	maxChatText = 500
+	maxChatName = 32
+	// chatInterval is the least time between one member's messages.
+	chatInterval = 500 * time.Millisecond
+)
+
+type chatMessage struct {
+	Type   string    `json:"type"` // "chat"
+	Stream string    `json:"stream,omitempty"`
+	From   string    `json:"from"`
+	Host   bool      `json:"host,omitempty"` // sent by the host, through the API
+	Text   string    `json:"text"`
+	At     time.Time `json:"at"`
+}
+
+// chatMember is one viewer's chat connection.
+type chatMember struct {
+	stream string
+	mu     sync.Mutex
+	conn   *websocket.Conn
+	zstd   bool
+}
+
+func (m *chatMember) send(msg chatMessage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.conn.SetWriteDeadline(time.Now().Add(pingTimeout))
+	return zst.WriteJSON(m.conn, msg, m.zstd)
+}
+
+type chatRoom struct {
+	cfg *ChatConfig
+
+	mu       sync.Mutex
+	members  map[*chatMember]bool
+	history  map[string][]chatMessage // by stream
+	osdError bool
+}
+
+// chat is set in main when chat is enabled.
+var chat *chatRoom
+
+func newChatRoom(cfg *ChatConfig) *chatRoom {
+	return &chatRoom{cfg: cfg, members: make(map[*chatMember]bool), history: make(map[string][]chatMessage)}
+}
+
+// post relays msg to everyone chatting on its stream and keeps it for
+// those who join later.
+func (room *chatRoom) post(msg chatMessage) {
+	msg.Type, msg.At = "chat", time.Now()
+	room.mu.Lock()
+	h := append(room.history[msg.Stream], msg)
+	if limit := cmp.Or(room.cfg.History, 50); len(h) > limit {
+		h = h[len(h)-limit:]
+	}
+	room.history[msg.Stream] = h
+	var members []*chatMember
+	for m := range room.members {
+		if m.stream == msg.Stream {
+			members = append(members, m)
+		}
+	}
+	room.mu.Unlock()
+
+	for _, m := range members {
+		m.send(msg)
+	}
+	emit(eventChatMessage, msg)
+	if room.cfg.OSD && !msg.Host && msg.Stream == "" {
+		go room.showOnHost(msg)
+	}
+}
+
+// showOnHost pops msg up on the main display.
+func (room *chatRoom) showOnHost(msg chatMessage) {
+	cmd := exec.Command("notify-send", "--app-name=remoter", msg.From, msg.Text)
+	cmd.Env = append(os.Environ(), "DISPLAY="+services.encoder.Settings().Display)
+	out, err := cmd.CombinedOutput()
+	if detail := strings.TrimSpace(string(out)); err != nil && detail != "" {
+		err = fmt.Errorf("%w: %s", err, detail)
+	}
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	// Warn once, not for every message.
+	if err != nil && !room.osdError {
+		log.Printf("Warning: failed to show chat on the host: %v", err)
+	}
+	room.osdError = err != nil
+}
+
+// chatText tidies what a member typed, reporting whether anything is left.
+func chatText(s string, limit int) (string, bool) {
+	s = strings.TrimSpace(strings.ToValidUTF8(s, ""))
+	if utf8.RuneCountInString(s) > limit {
+		s = string([]rune(s)[:limit])
+	}
+	return s, s != ""
+}
+
+// viewerName is what a viewer is known by in chat and control: their
+// login, or the ?name= they chose, or else a name from their address.
+func viewerName(r *http.Request) string {
+	if u := requestAuth(r).User; u != "" {
+		return u
+	}
+	if name, ok := chatText(r.URL.Query().Get("name"), maxChatName); ok {
+		return name
+	}
+	return "Viewer " + remoteIP(r).String()
+}
+
+// handleChat joins a viewer to the chat of a stream over a WebSocket: it
+// receives the recent history, then every message as
+// {"type": "chat", "from", "text", "at"}, and sends {"text"} to post.
+func handleChat(w http.ResponseWriter, r *http.Request) {
+	if chat == nil {
+		i18n.Error(w, r, http.StatusNotFound, "chat_disabled")
+		return
+	}
+	stream := r.PathValue("session")
+	if !streamExists(stream) {
+		i18n.Error(w, r, http.StatusNotFound, "no_such_session")
+		return
+	}
+	conn, zstd, err := upgradeAux(w, r)
+	if err != nil {
+		log.Printf("WebSocket upgrade error: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	m := &chatMember{stream: stream, conn: conn, zstd: zstd}
+	name := viewerName(r)
+	chat.mu.Lock()
+	history := append([]chatMessage(nil), chat.history[stream]...)
+	chat.members[m] = true
+	chat.mu.Unlock()
+	defer func() {
+		chat.mu.Lock()
+		delete(chat.members, m)
+		chat.mu.Unlock()
+	}()
+	for _, msg := range history {
+		if m.send(msg) != nil {
+			return
+		}
+	}
+
+	gone := make(chan struct{})
+	defer close(gone)
+	keepAlive(conn, gone)
+	var last time.Time
+	for {
+		data, err := zst.ReadMessage(conn, zstd)
+		if err != nil {
+			return
+		}
+		var in struct {
+			Text string `json:"text"`
+		}
+		if json.Unmarshal(data, &in) != nil {
+			continue
+		}
+		text, ok := chatText(in.Text, maxChatText)
+		if !ok || time.Since(last) < chatInterval {
+			continue
+		}
+		last = time.Now()
+		chat.post(chatMessage{Stream: stream, From: name, Text: text})
+	}
+}
+
+func handleChatHistory(w http.ResponseWriter, r *http.Request) {
+	if chat == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("chat is not enabled"))
+		return
+	}
+	stream := r.URL.Query().Get("session")
+	chat.mu.Lock()
+	history := append([]chatMessage{}, chat.history[stream]...)
+	chat.mu.Unlock()
+	writeJSON(w, http.StatusOK, history)
+}
+
+// handlePostChat sends a message from the host to a stream's viewers.
+func handlePostChat(w http.ResponseWriter, r *http.Request) {
+	if chat == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("chat is not enabled"))
+		return
+	}
+	var req struct {
+		Session string `json:"session"`
+		Text    string `json:"text"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	if !streamExists(req.Session) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no streaming session with id %q", req.Session))
+		return
+	}
+	text, ok := chatText(req.Text, maxChatText)
+	if !ok {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("text is required"))
+		return
+	}
+	chat.post(chatMessage{Stream: req.Session, From: cmp.Or(requestAuth(r).User, "host"), Host: true, Text: text})
+	w.WriteHeader(http.StatusNoContent)
+}
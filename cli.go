@@ -0,0 +1,1179 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nathfavour/remoter/cast"
+	"github.com/nathfavour/remoter/ffmpeg"
+	"github.com/nathfavour/remoter/filesync"
+	"github.com/nathfavour/remoter/loadtest"
+	"github.com/nathfavour/remoter/power"
+	"github.com/nathfavour/remoter/relay"
+	"github.com/nathfavour/remoter/session"
+	"github.com/nathfavour/remoter/storage"
+	"github.com/nathfavour/remoter/vnc"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// runCommand dispatches the CLI subcommands that talk to a running
+// instance through the control API.
+func runCommand(args []string) error {
+	switch args[0] {
+	case "session":
+		return runSessionCommand(args[1:])
+	case "desktop":
+		return runDesktopCommand(args[1:])
+	case "relay":
+		return runRelayCommand(args[1:])
+	case "user":
+		return runUserCommand(args[1:])
+	case "invite":
+		return runInviteCommand(args[1:])
+	case "keys":
+		return runKeysCommand(args[1:])
+	case "audit":
+		return runAuditCommand(args[1:])
+	case "record":
+		return runRecordCommand(args[1:])
+	case "cast":
+		return runCastCommand(args[1:])
+	case "sync":
+		return runSyncCommand(args[1:])
+	case "loadtest":
+		return runLoadtestCommand(args[1:])
+	case "power":
+		if len(args) != 2 || !slices.Contains(power.Actions, args[1]) {
+			printUsage()
+			return fmt.Errorf("usage: remoter power %s", strings.Join(power.Actions, "|"))
+		}
+		if err := apiRequest("POST", "/api/v1/power/"+args[1], nil, nil); err != nil {
+			return err
+		}
+		fmt.Printf("Requested %s\n", args[1])
+		return nil
+	case "chat":
+		return runChatCommand(args[1:])
+	case "control":
+		return runControlCommand(args[1:])
+	case "config":
+		return runConfigCommand(args[1:])
+	case "doctor":
+		return runDoctorCommand(args[1:])
+	case "service":
+		return runServiceCommand(args[1:])
+	case "status":
+		return runStatusCommand()
+	case "stop", "restart":
+		cfg, err := loadOrCreateConfig()
+		if err != nil {
+			return err
+		}
+		if err := stopInstance(cfg.Port); err != nil && args[0] == "stop" {
+			return err
+		}
+		if args[0] == "restart" {
+			return daemonize(cfg.Port)
+		}
+		return nil
+	case "quality":
+		return runQualityCommand(args[1:])
+	case "source":
+		return runSourceCommand(args[1:])
+	case "pause", "resume":
+		if err := apiRequest("POST", "/api/v1/stream/"+args[0], nil, nil); err != nil {
+			return err
+		}
+		fmt.Printf("Stream %sd\n", args[0])
+		return nil
+	case "help", "-h", "--help":
+		printUsage()
+		return nil
+	}
+	printUsage()
+	return fmt.Errorf("unknown command %q", args[0])
+}
+
+func printUsage() {
+	fmt.Fprintf(os.Stderr, `Usage:
+  remoter [--config file] [--daemon]         start the server, in the background with --daemon
+  remoter [--config file] <command>          run a command against the server
+  remoter status                             show whether the server runs and its services
+  remoter stop                               shut the server down
+  remoter restart                            restart the server in the background
+  remoter service install [--user] [--name n]  install a systemd unit running the server
+  remoter service uninstall [--user] [--name n]  disable and remove it
+  remoter config validate [file]             check the config file without starting the server
+  remoter doctor                             check that this machine is ready to stream
+  remoter session create --template <name>   create a virtual session
+  remoter session list                       list virtual sessions
+  remoter session delete <id>                destroy a virtual session
+  remoter session share <id> [--role r]      print a viewer link for a session
+  remoter session kiosk <url> [--res WxH]    stream a kiosk browser at url
+  remoter desktop create [--res WxHxD] [--app name]... start a VNC desktop on a free display
+  remoter desktop list                       list VNC desktops
+  remoter desktop delete <id>                stop a VNC desktop
+  remoter relay --listen <addr> --secret <s> run a relay for hosts behind NAT
+  remoter invite [--role r] [--ttl 1h]        mint a single-use viewer link
+  remoter invite list                        list invites
+  remoter invite revoke <id>                 revoke an invite
+  remoter keys pair [--user u] [--role r] [--name n]  mint a code that pairs a client's key
+  remoter keys list                          list paired keys
+  remoter keys revoke <id>                   forget a paired key
+  remoter audit [--verify]                   print the input audit log, checking signatures
+  remoter record start [--session id]        start recording a stream
+  remoter record stop <name>                 stop a recording
+  remoter record list                        list recordings
+  remoter record schedule --cron c --duration d [--session id] [--keep n] [--max-age d] [--catch-up] <name>
+                                             record a stream at cron times
+  remoter record clip --start s --duration d [--format gif] <name>  export a clip of a recording
+  remoter record replay [--duration 2m] [--format mpg|webm|gif]  save what the replay buffer holds
+  remoter record schedules                   list recording schedules
+  remoter record unschedule <name>           delete a recording schedule
+  remoter record retention [--max-bytes n] [--max-age d]  show or set how long recordings are kept
+  remoter cast devices                       list Chromecasts and DLNA renderers
+  remoter cast start [--session id] <device> cast a stream to a device
+  remoter cast stop <id>                     stop casting
+  remoter pause                              freeze the stream on a placeholder
+  remoter resume                             resume the stream
+  remoter sync [--server url] [--interval 10s] [--once] <dir>
+                                             keep dir in step with the host's synced folder
+  remoter loadtest [--clients 200] [--url ws://…] [--duration 30s] [--read-rate n] [--slow n --slow-rate n]
+                                             measure how the broadcast holds up under many viewers
+  remoter control [--session id]             show who holds control of a stream and who asks for it
+  remoter control grant [--session id] <controller>  hand control to a viewer
+  remoter control revoke [--session id]      take control back from its holder
+  remoter quality                            show the main stream's framerate and bitrate
+  remoter quality set [--bitrate 2M] [--fps 30]  change them on the running encoder
+  remoter source                             show what part of the screen is captured
+  remoter source set --monitor <output> | --window <name> | --region WxH+X+Y | --full
+                                             capture one monitor, a window, an area or the whole screen
+  remoter chat [--session id] <text>         send a message to the viewers of a stream
+  remoter power lock|logout|suspend|reboot   lock or end the desktop session, or suspend or reboot the host
+  remoter user add <name> [--password-stdin] add or update a login
+  remoter user delete <name>                 remove a login
+
+The config file is $XDG_CONFIG_HOME/remoter/config.json, or
+~/.remoter.json where only that exists, unless --config names another.
+Commands reach the server through its control socket, as its own user;
+over TCP, when logins are configured, the CLI authenticates with the
+REMOTER_USER and REMOTER_PASSWORD environment variables.
+`)
+}
+
+// apiRequest performs a control API call against the local instance and
+// decodes the JSON response into out, if non-nil.
+func apiRequest(method, path string, body, out any) error {
+	cfg, err := loadOrCreateConfig()
+	if err != nil {
+		return err
+	}
+
+	var rd io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		rd = bytes.NewReader(data)
+	}
+
+	url := fmt.Sprintf("http://127.0.0.1:%d%s", cfg.Port, path)
+	req, err := http.NewRequest(method, url, rd)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if username := os.Getenv("REMOTER_USER"); username != "" {
+		req.SetBasicAuth(username, os.Getenv("REMOTER_PASSWORD"))
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	if sock := controlSocketPath(cfg.Port); socketExists(sock) && checkInstanceDir() == nil {
+		client.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", sock)
+			},
+		}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach remoter at %s (is it running?): %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&apiErr) == nil && apiErr.Error != "" {
+			return fmt.Errorf("%s", apiErr.Error)
+		}
+		return fmt.Errorf("request failed: %s", resp.Status)
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("failed to decode response: %w", err)
+		}
+	}
+	return nil
+}
+
+func socketExists(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.Mode()&os.ModeSocket != 0
+}
+
+func runSessionCommand(args []string) error {
+	if len(args) == 0 {
+		printUsage()
+		return fmt.Errorf("missing session subcommand")
+	}
+
+	switch args[0] {
+	case "create":
+		fs := flag.NewFlagSet("session create", flag.ExitOnError)
+		template := fs.String("template", "", "name of the session template to instantiate")
+		username := fs.String("user", "", "user to create the session for (default: current user)")
+		passwordStdin := fs.Bool("password-stdin", false, "read the PAM password for login templates from stdin")
+		title := fs.String("title", "", "title shown to viewers (default: the template's)")
+		fs.Parse(args[1:])
+		if *template == "" {
+			return fmt.Errorf("--template is required")
+		}
+
+		if *username == "" {
+			if usr, err := user.Current(); err == nil {
+				*username = usr.Username
+			}
+		}
+		body := map[string]string{"template": *template, "user": *username}
+		if *title != "" {
+			body["title"] = *title
+		}
+		if *passwordStdin {
+			line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+			if err != nil && err != io.EOF {
+				return fmt.Errorf("failed to read password: %w", err)
+			}
+			body["password"] = strings.TrimRight(line, "\r\n")
+		}
+
+		var info session.Info
+		if err := apiRequest("POST", "/api/v1/sessions", body, &info); err != nil {
+			return err
+		}
+		fmt.Printf("Created session %s (template %q) on display %s\n", info.ID, info.Template, info.Display)
+		return nil
+
+	case "list":
+		var resp struct {
+			Sessions []session.Info `json:"sessions"`
+		}
+		if err := apiRequest("GET", "/api/v1/sessions", nil, &resp); err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tTEMPLATE\tTITLE\tDISPLAY\tRES\tUSER\tCREATED")
+		for _, s := range resp.Sessions {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", s.ID, s.Template, s.Title, s.Display, s.Res, s.User, s.CreatedAt.Format(time.RFC3339))
+		}
+		return tw.Flush()
+
+	case "delete":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: remoter session delete <id>")
+		}
+		if err := apiRequest("DELETE", "/api/v1/sessions/"+args[1], nil, nil); err != nil {
+			return err
+		}
+		fmt.Printf("Destroyed session %s\n", args[1])
+		return nil
+
+	case "kiosk":
+		if len(args) < 2 || strings.HasPrefix(args[1], "-") {
+			return fmt.Errorf("usage: remoter session kiosk <url> [--res 1920x1080] [--browser chromium] [--title t] [--lifetime 8h]")
+		}
+		fs := flag.NewFlagSet("session kiosk", flag.ExitOnError)
+		res := fs.String("res", "", "browser window size (default 1920x1080)")
+		browser := fs.String("browser", "", "browser to run: chromium, chromium-browser, google-chrome or firefox (default: the first installed)")
+		title := fs.String("title", "", "title shown to viewers (default: the url's host)")
+		lifetime := fs.String("lifetime", "", "destroy the session after this long")
+		fs.Parse(args[2:])
+
+		body := map[string]string{"url": args[1], "res": *res, "browser": *browser, "title": *title, "lifetime": *lifetime}
+		if usr, err := user.Current(); err == nil {
+			body["user"] = usr.Username
+		}
+		var info session.Info
+		if err := apiRequest("POST", "/api/v1/sessions/kiosk", body, &info); err != nil {
+			return err
+		}
+		fmt.Printf("Created kiosk session %s on display %s\n", info.ID, info.Display)
+		fmt.Printf("Watch it at /s/%s/view, or mint a link with: remoter session share %s\n", info.ID, info.ID)
+		return nil
+
+	case "share":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: remoter session share <id> [--role view|control] [--ttl 24h]")
+		}
+		fs := flag.NewFlagSet("session share", flag.ExitOnError)
+		role := fs.String("role", "view", "role granted by the link: view or control")
+		ttl := fs.String("ttl", "24h", "how long the link stays valid")
+		fs.Parse(args[2:])
+
+		var resp struct {
+			URL       string    `json:"url"`
+			ExpiresAt time.Time `json:"expires_at"`
+		}
+		body := map[string]string{"role": *role, "ttl": *ttl}
+		if err := apiRequest("POST", "/api/v1/sessions/"+args[1]+"/share", body, &resp); err != nil {
+			return err
+		}
+		fmt.Printf("%s\n(valid until %s)\n", resp.URL, resp.ExpiresAt.Format(time.RFC3339))
+		return nil
+	}
+	return fmt.Errorf("unknown session subcommand %q", args[0])
+}
+
+// appList collects repeated --app flags.
+type appList []string
+
+func (a *appList) String() string     { return strings.Join(*a, ", ") }
+func (a *appList) Set(v string) error { *a = append(*a, v); return nil }
+
+func runDesktopCommand(args []string) error {
+	if len(args) == 0 {
+		printUsage()
+		return fmt.Errorf("missing desktop subcommand")
+	}
+
+	switch args[0] {
+	case "create":
+		fs := flag.NewFlagSet("desktop create", flag.ExitOnError)
+		res := fs.String("res", "", "screen size and depth (default 1920x1080x24)")
+		var apps appList
+		fs.Var(&apps, "app", "desktop_apps entry to run on the desktop, repeatable; the first is the window manager (default: openbox desktop)")
+		fs.Parse(args[1:])
+
+		var d vnc.Desktop
+		if err := apiRequest("POST", "/api/v1/desktops", map[string]any{"res": *res, "apps": apps}, &d); err != nil {
+			return err
+		}
+		fmt.Printf("Created desktop %s on display %s, VNC port %d on localhost, password %s\n", d.ID, d.Display, d.Port, d.Password)
+		return nil
+
+	case "list":
+		var resp struct {
+			Desktops []vnc.Desktop `json:"desktops"`
+		}
+		if err := apiRequest("GET", "/api/v1/desktops", nil, &resp); err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tDISPLAY\tPORT\tRES\tAPPS\tSTARTED")
+		for _, d := range resp.Desktops {
+			apps := strings.Join(d.Apps, ", ")
+			if apps == "" {
+				apps = "(default)"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%s\n", d.ID, d.Display, d.Port, d.Res, apps, d.StartedAt.Format(time.RFC3339))
+		}
+		return tw.Flush()
+
+	case "delete":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: remoter desktop delete <id>")
+		}
+		if err := apiRequest("DELETE", "/api/v1/desktops/"+args[1], nil, nil); err != nil {
+			return err
+		}
+		fmt.Printf("Stopped desktop %s\n", args[1])
+		return nil
+	}
+	return fmt.Errorf("unknown desktop subcommand %q", args[0])
+}
+
+func runRelayCommand(args []string) error {
+	fs := flag.NewFlagSet("relay", flag.ExitOnError)
+	listen := fs.String("listen", ":8090", "address to listen on")
+	secret := fs.String("secret", "", "shared secret hosts must present")
+	fs.Parse(args)
+	if *secret == "" {
+		return fmt.Errorf("--secret is required")
+	}
+
+	log.Printf("Starting relay server on %s", *listen)
+	return http.ListenAndServe(*listen, relay.NewServer(*secret).Handler())
+}
+
+func runInviteCommand(args []string) error {
+	if len(args) > 0 && args[0] == "list" {
+		var resp struct {
+			Invites []invite `json:"invites"`
+		}
+		if err := apiRequest("GET", "/api/v1/invites", nil, &resp); err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tSESSION\tROLE\tEXPIRES\tSTATE")
+		for _, inv := range resp.Invites {
+			state := "unused"
+			switch {
+			case inv.Revoked:
+				state = "revoked"
+			case time.Now().After(inv.ExpiresAt):
+				state = "expired"
+			case !inv.RedeemedAt.IsZero():
+				state = "redeemed by " + inv.RedeemedBy
+			}
+			session := inv.Session
+			if session == "" {
+				session = "main"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", inv.ID, session, inv.Role, inv.ExpiresAt.Format(time.RFC3339), state)
+		}
+		return tw.Flush()
+	}
+	if len(args) > 0 && args[0] == "revoke" {
+		if len(args) < 2 {
+			return fmt.Errorf("usage: remoter invite revoke <id>")
+		}
+		if err := apiRequest("DELETE", "/api/v1/invites/"+args[1], nil, nil); err != nil {
+			return err
+		}
+		fmt.Printf("Revoked invite %s\n", args[1])
+		return nil
+	}
+
+	fs := flag.NewFlagSet("invite", flag.ExitOnError)
+	session := fs.String("session", "", "session to invite to (default: the main display)")
+	role := fs.String("role", "view", "role granted by the invite: view or control")
+	ttl := fs.String("ttl", "1h", "how long the invite stays valid")
+	fs.Parse(args)
+
+	var resp struct {
+		Invite invite `json:"invite"`
+		URL    string `json:"url"`
+	}
+	body := map[string]string{"session": *session, "role": *role, "ttl": *ttl}
+	if err := apiRequest("POST", "/api/v1/invites", body, &resp); err != nil {
+		return err
+	}
+	fmt.Printf("%s\n(single use, valid until %s)\n", resp.URL, resp.Invite.ExpiresAt.Format(time.RFC3339))
+	return nil
+}
+
+func runKeysCommand(args []string) error {
+	sub := "list"
+	if len(args) > 0 {
+		sub, args = args[0], args[1:]
+	}
+	switch sub {
+	case "list":
+		var keys []clientKey
+		if err := apiRequest("GET", "/api/v1/keys", nil, &keys); err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tNAME\tUSER\tROLE\tPAIRED\tLAST USED")
+		for _, k := range keys {
+			used := "never"
+			if !k.LastUsed.IsZero() {
+				used = k.LastUsed.Format(time.RFC3339)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", k.ID, k.Name, k.User, k.Role, k.PairedAt.Format(time.RFC3339), used)
+		}
+		return tw.Flush()
+	case "pair":
+		fs := flag.NewFlagSet("keys pair", flag.ExitOnError)
+		user := fs.String("user", "", "user the key logs in as (default: you)")
+		role := fs.String("role", "control", "role the key gets: view, control or admin")
+		name := fs.String("name", "", "name of the client (default: the one it sends)")
+		fs.Parse(args)
+		var resp struct {
+			Code      string    `json:"code"`
+			ExpiresAt time.Time `json:"expires_at"`
+		}
+		body := map[string]string{"user": *user, "role": *role, "name": *name}
+		if err := apiRequest("POST", "/api/v1/keys/pair", body, &resp); err != nil {
+			return err
+		}
+		fmt.Printf("%s\n(pairs one key, valid until %s)\n", resp.Code, resp.ExpiresAt.Format(time.RFC3339))
+		return nil
+	case "revoke":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: remoter keys revoke <id>")
+		}
+		if err := apiRequest("DELETE", "/api/v1/keys/"+args[0], nil, nil); err != nil {
+			return err
+		}
+		fmt.Printf("Revoked key %s\n", args[0])
+		return nil
+	}
+	return fmt.Errorf("unknown keys subcommand %q", sub)
+}
+
+// runAuditCommand prints the input audit log, which it reads directly
+// rather than through the API, so it works with the server down.
+func runAuditCommand(args []string) error {
+	fs := flag.NewFlagSet("audit", flag.ExitOnError)
+	verify := fs.Bool("verify", false, "check the signatures of entries from paired keys, failing on a bad one")
+	fs.Parse(args)
+	path, err := getConfigPath()
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(filepath.Join(filepath.Dir(path), ".remoter-audit.log"))
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer f.Close()
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tSTREAM\tWHO\tINPUT\tSIGNATURE")
+	bad := 0
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	for n := 1; sc.Scan(); n++ {
+		var e auditEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil || e.Input == nil {
+			return fmt.Errorf("audit log line %d is malformed", n)
+		}
+		who := cmp.Or(e.User, e.Name)
+		if e.Key != "" {
+			who += " (key " + e.Key + ")"
+		}
+		input := e.Input.Type
+		switch e.Input.Type {
+		case "key":
+			input += " " + strings.Join(e.Input.Keys, "+")
+		case "type":
+			input += fmt.Sprintf(" %q", e.Input.Text)
+		default:
+			input += fmt.Sprintf(" %d at %.3f,%.3f", max(e.Input.Button, 1), e.Input.X, e.Input.Y)
+		}
+		sig := "-"
+		if e.Sig != nil {
+			sig = "present"
+			if *verify {
+				if err := e.verify(); err != nil {
+					sig = "BAD: " + err.Error()
+					bad++
+				} else {
+					sig = "valid"
+				}
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", e.Time.Format(time.RFC3339), cmp.Or(e.Stream, "main"), who, input, sig)
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	tw.Flush()
+	if bad > 0 {
+		return fmt.Errorf("%d entries failed verification", bad)
+	}
+	return nil
+}
+
+func runRecordCommand(args []string) error {
+	if len(args) == 0 {
+		printUsage()
+		return fmt.Errorf("missing record subcommand")
+	}
+
+	switch args[0] {
+	case "start":
+		fs := flag.NewFlagSet("record start", flag.ExitOnError)
+		session := fs.String("session", "", "session to record (default: the main display)")
+		quality := fs.String("quality", "", "quality tier to record (default: full quality)")
+		fs.Parse(args[1:])
+
+		var rec recording
+		body := map[string]string{"stream": *session, "quality": *quality}
+		if err := apiRequest("POST", "/api/v1/recordings", body, &rec); err != nil {
+			return err
+		}
+		fmt.Printf("Recording to %s\n", rec.Name)
+		return nil
+	case "stop":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: remoter record stop <name>")
+		}
+		var info recordingInfo
+		if err := apiRequest("POST", "/api/v1/recordings/"+args[1]+"/stop", nil, &info); err != nil {
+			return err
+		}
+		fmt.Printf("Saved %s (%d bytes)\n", info.Name, info.Size)
+		return nil
+	case "list":
+		var resp struct {
+			Recordings []recordingInfo `json:"recordings"`
+		}
+		if err := apiRequest("GET", "/api/v1/recordings", nil, &resp); err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tSIZE\tMODIFIED\tSTATE")
+		for _, rec := range resp.Recordings {
+			state := "saved"
+			if rec.Active {
+				state = "recording"
+			}
+			fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", rec.Name, rec.Size, rec.ModTime.Format(time.RFC3339), state)
+		}
+		return tw.Flush()
+	case "schedule":
+		fs := flag.NewFlagSet("record schedule", flag.ExitOnError)
+		cronExpr := fs.String("cron", "", `when to start, e.g. "0 14 * * tue" or "@daily"`)
+		duration := fs.String("duration", "", "how long to record, e.g. 1h30m")
+		session := fs.String("session", "", "session to record (default: the main display)")
+		quality := fs.String("quality", "", "quality tier to record (default: full quality)")
+		keep := fs.Int("keep", 0, "newest recordings to keep (default: all)")
+		maxAge := fs.String("max-age", "", "delete recordings older than this, e.g. 720h")
+		catchUp := fs.Bool("catch-up", false, "start right away when inside a window that already began")
+		fs.Parse(args[1:])
+		if fs.NArg() != 1 {
+			return fmt.Errorf("usage: remoter record schedule --cron <expr> --duration <d> <name>")
+		}
+
+		var job scheduledJob
+		body := RecordingSchedule{
+			Name:     fs.Arg(0),
+			Cron:     *cronExpr,
+			Duration: *duration,
+			Stream:   *session,
+			Quality:  *quality,
+			Keep:     *keep,
+			MaxAge:   *maxAge,
+			CatchUp:  *catchUp,
+		}
+		if err := apiRequest("POST", "/api/v1/schedules", body, &job); err != nil {
+			return err
+		}
+		if job.Next != nil {
+			fmt.Printf("Schedule %s next records at %s\n", job.Name, job.Next.Format(time.RFC3339))
+		} else {
+			fmt.Printf("Schedule %s saved\n", job.Name)
+		}
+		return nil
+	case "schedules":
+		var resp struct {
+			Schedules []scheduledJob `json:"schedules"`
+		}
+		if err := apiRequest("GET", "/api/v1/schedules", nil, &resp); err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tCRON\tDURATION\tSTREAM\tNEXT\tRECORDING")
+		for _, job := range resp.Schedules {
+			next := "-"
+			if job.Next != nil {
+				next = job.Next.Format(time.RFC3339)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", job.Name, job.Cron, job.Duration, cmp.Or(job.Stream, "main"), next, cmp.Or(job.Recording, "-"))
+		}
+		return tw.Flush()
+	case "unschedule":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: remoter record unschedule <name>")
+		}
+		if err := apiRequest("DELETE", "/api/v1/schedules/"+args[1], nil, nil); err != nil {
+			return err
+		}
+		fmt.Printf("Deleted schedule %s\n", args[1])
+		return nil
+	case "retention":
+		fs := flag.NewFlagSet("record retention", flag.ExitOnError)
+		maxBytes := fs.Int64("max-bytes", -1, "total size recordings may take, 0 for no limit")
+		maxAge := fs.String("max-age", "-", `delete recordings older than this, e.g. 720h, "" for no limit`)
+		fs.Parse(args[1:])
+
+		var p RetentionPolicy
+		if err := apiRequest("GET", "/api/v1/retention", nil, &p); err != nil {
+			return err
+		}
+		if *maxBytes >= 0 || *maxAge != "-" {
+			if *maxBytes >= 0 {
+				p.MaxTotalBytes = *maxBytes
+			}
+			if *maxAge != "-" {
+				p.MaxAge = *maxAge
+			}
+			var resp struct {
+				Deleted []string `json:"deleted"`
+			}
+			if err := apiRequest("PUT", "/api/v1/retention", p, &resp); err != nil {
+				return err
+			}
+			for _, name := range resp.Deleted {
+				fmt.Printf("Deleted %s\n", name)
+			}
+		}
+		size := "unlimited"
+		if p.MaxTotalBytes > 0 {
+			size = fmt.Sprintf("%d bytes", p.MaxTotalBytes)
+		}
+		fmt.Printf("Max total size: %s\nMax age: %s\n", size, cmp.Or(p.MaxAge, "unlimited"))
+		return nil
+	case "clip":
+		fs := flag.NewFlagSet("record clip", flag.ExitOnError)
+		start := fs.String("start", "0s", "where the clip starts, e.g. 1m30s")
+		duration := fs.String("duration", "", "how long the clip runs, e.g. 15s")
+		format := fs.String("format", "webm", "webm or gif")
+		width := fs.Int("width", 0, "width to scale to (default: the recording's)")
+		fs.Parse(args[1:])
+		if fs.NArg() != 1 {
+			return fmt.Errorf("usage: remoter record clip --start <s> --duration <d> [--format webm|gif] <name>")
+		}
+
+		var obj storage.Object
+		body := map[string]any{"start": *start, "duration": *duration, "format": *format, "width": *width}
+		if err := apiRequest("POST", "/api/v1/recordings/"+fs.Arg(0)+"/clip", body, &obj); err != nil {
+			return err
+		}
+		fmt.Printf("Exported %s (%d bytes)\n", obj.Name, obj.Size)
+		return nil
+	case "replay":
+		fs := flag.NewFlagSet("record replay", flag.ExitOnError)
+		duration := fs.String("duration", "2m", "how far back to export")
+		format := fs.String("format", "mpg", "mpg, webm or gif")
+		width := fs.Int("width", 0, "width to scale webm and gif to (default: the stream's)")
+		fs.Parse(args[1:])
+
+		var obj storage.Object
+		body := map[string]any{"duration": *duration, "format": *format, "width": *width}
+		if err := apiRequest("POST", "/api/v1/replay/export", body, &obj); err != nil {
+			return err
+		}
+		fmt.Printf("Exported %s (%d bytes)\n", obj.Name, obj.Size)
+		return nil
+	}
+	return fmt.Errorf("unknown record subcommand %q", args[0])
+}
+
+func runCastCommand(args []string) error {
+	if len(args) == 0 {
+		printUsage()
+		return fmt.Errorf("missing cast subcommand")
+	}
+
+	switch args[0] {
+	case "devices":
+		var resp struct {
+			Devices []cast.Device `json:"devices"`
+		}
+		if err := apiRequest("GET", "/api/v1/cast/devices", nil, &resp); err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tKIND\tHOST\tID")
+		for _, dev := range resp.Devices {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", dev.Name, dev.Kind, dev.Host, dev.ID)
+		}
+		return tw.Flush()
+	case "start":
+		fs := flag.NewFlagSet("cast start", flag.ExitOnError)
+		session := fs.String("session", "", "session to cast (default: the main display)")
+		fs.Parse(args[1:])
+		if fs.NArg() < 1 {
+			return fmt.Errorf("usage: remoter cast start [--session id] <device>")
+		}
+		var job castJob
+		body := map[string]string{"device": fs.Arg(0), "session": *session}
+		if err := apiRequest("POST", "/api/v1/casts", body, &job); err != nil {
+			return err
+		}
+		fmt.Printf("Casting to %s; stop with: remoter cast stop %s\n", job.Device.Name, job.ID)
+		return nil
+	case "stop":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: remoter cast stop <id>")
+		}
+		return apiRequest("DELETE", "/api/v1/casts/"+args[1], nil, nil)
+	}
+	return fmt.Errorf("unknown cast subcommand %q", args[0])
+}
+
+// runSyncCommand keeps a local folder in step with the host's synced
+// folder, a round every interval until interrupted.
+func runSyncCommand(args []string) error {
+	fs := flag.NewFlagSet("sync", flag.ExitOnError)
+	server := fs.String("server", "", "remoter to sync with (default: the local instance)")
+	interval := fs.Duration("interval", 10*time.Second, "time between rounds")
+	once := fs.Bool("once", false, "sync once and exit")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: remoter sync [--server url] [--interval 10s] [--once] <dir>")
+	}
+	if *server == "" {
+		cfg, err := loadOrCreateConfig()
+		if err != nil {
+			return err
+		}
+		*server = fmt.Sprintf("http://127.0.0.1:%d", cfg.Port)
+	}
+	c := &filesync.Client{
+		Server:   *server,
+		User:     os.Getenv("REMOTER_USER"),
+		Password: os.Getenv("REMOTER_PASSWORD"),
+		Dir:      fs.Arg(0),
+		HTTP:     &http.Client{Timeout: 5 * time.Minute},
+		Logf:     log.Printf,
+	}
+	for {
+		err := c.Sync()
+		if *once {
+			return err
+		}
+		if err != nil {
+			log.Printf("Sync failed: %v", err)
+		}
+		time.Sleep(*interval)
+	}
+}
+
+// runLoadtestCommand connects synthetic viewers to a stream and reports
+// how the broadcaster kept up with them.
+func runLoadtestCommand(args []string) error {
+	fs := flag.NewFlagSet("loadtest", flag.ExitOnError)
+	clients := fs.Int("clients", 200, "viewers to connect")
+	target := fs.String("url", "", "stream to view (default: the local instance's /ws)")
+	duration := fs.Duration("duration", 30*time.Second, "how long to view")
+	ramp := fs.Duration("ramp", 10*time.Millisecond, "time between two viewers connecting")
+	readRate := fs.Int64("read-rate", 0, "bytes per second each viewer reads (0: as fast as sent)")
+	slow := fs.Int("slow", 0, "viewers that read at --slow-rate instead")
+	slowRate := fs.Int64("slow-rate", 16<<10, "bytes per second the slow viewers read")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	fs.Parse(args)
+	if *target == "" {
+		cfg, err := loadOrCreateConfig()
+		if err != nil {
+			return err
+		}
+		*target = fmt.Sprintf("ws://127.0.0.1:%d/ws", cfg.Port)
+	}
+	u, err := url.Parse(*target)
+	if err != nil {
+		return fmt.Errorf("invalid url: %w", err)
+	}
+	api := &url.URL{Scheme: strings.Replace(u.Scheme, "ws", "http", 1), Host: u.Host, Path: "/api/v1/clients"}
+
+	log.Printf("Connecting %d viewers to %s for %s", *clients, *target, *duration)
+	rep, err := loadtest.Run(context.Background(), loadtest.Options{
+		URL:      *target,
+		Clients:  *clients,
+		ReadRate: *readRate,
+		Slow:     *slow,
+		SlowRate: *slowRate,
+		Ramp:     *ramp,
+		Duration: *duration,
+		User:     os.Getenv("REMOTER_USER"),
+		Password: os.Getenv("REMOTER_PASSWORD"),
+		API:      api.String(),
+		Interval: 5 * time.Second,
+		Logf:     log.Printf,
+	})
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rep)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Viewers\t%d connected, %d failed to connect\n", rep.Connected, rep.Failed)
+	fmt.Fprintf(tw, "Disconnected while viewing\t%d (%d of %d slow)\n", rep.Dropped, rep.SlowDrops, *slow)
+	fmt.Fprintf(tw, "Received\t%.1f MB in %d messages, %.1f MB/s\n", float64(rep.Bytes)/1e6, rep.Messages, float64(rep.Bytes)/1e6/rep.Seconds)
+	printPercentiles(tw, "First byte (ms)", rep.FirstByte)
+	printPercentiles(tw, "Broadcast skew (ms)", rep.Skew)
+	if s := rep.Server; s != nil {
+		if s.Error != "" {
+			fmt.Fprintf(tw, "Server figures\tunavailable: %s\n", s.Error)
+		} else {
+			fmt.Fprintf(tw, "Dropped, as the server saw it\t%d\n", s.Dropped)
+			printPercentiles(tw, "Server write latency (ms)", s.WriteLatency)
+			printPercentiles(tw, "Server queue depth", s.QueueDepth)
+		}
+	}
+	for msg, n := range rep.Errors {
+		fmt.Fprintf(tw, "Error\t%dx %s\n", n, msg)
+	}
+	return tw.Flush()
+}
+
+func printPercentiles(w io.Writer, label string, p loadtest.Percentiles) {
+	if p.Samples == 0 {
+		fmt.Fprintf(w, "%s\tno samples\n", label)
+		return
+	}
+	fmt.Fprintf(w, "%s\tp50 %.1f  p95 %.1f  p99 %.1f  max %.1f\n", label, p.P50, p.P95, p.P99, p.Max)
+}
+
+func runControlCommand(args []string) error {
+	sub := "show"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		sub, args = args[0], args[1:]
+	}
+	fs := flag.NewFlagSet("control "+sub, flag.ExitOnError)
+	session := fs.String("session", "", "session to act on (default: the main display)")
+	fs.Parse(args)
+	var state controlState
+	switch sub {
+	case "show":
+		if err := apiRequest("GET", "/api/v1/control?session="+url.QueryEscape(*session), nil, &state); err != nil {
+			return err
+		}
+	case "grant":
+		if fs.NArg() != 1 {
+			return fmt.Errorf("usage: remoter control grant [--session id] <controller>")
+		}
+		body := map[string]string{"session": *session, "controller": fs.Arg(0)}
+		if err := apiRequest("POST", "/api/v1/control/grant", body, &state); err != nil {
+			return err
+		}
+	case "revoke":
+		if err := apiRequest("POST", "/api/v1/control/revoke", map[string]string{"session": *session}, &state); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown control subcommand %q", sub)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "CONTROLLER\tNAME\tSTATE")
+	if h := state.Holder; h != nil {
+		fmt.Fprintf(tw, "%s\t%s\tholds control\n", h.ID, h.Name)
+	}
+	for _, r := range state.Requests {
+		fmt.Fprintf(tw, "%s\t%s\tasks for control\n", r.ID, r.Name)
+	}
+	return tw.Flush()
+}
+
+// runConfigCommand checks the config file as the server would on start,
+// without creating or updating it, and lists every problem found.
+func runConfigCommand(args []string) error {
+	if len(args) == 0 || args[0] != "validate" {
+		return fmt.Errorf("usage: remoter config validate [file]")
+	}
+	fs := flag.NewFlagSet("config validate", flag.ExitOnError)
+	fs.Parse(args[1:])
+	path := fs.Arg(0)
+	if path == "" {
+		var err error
+		if path, err = getConfigPath(); err != nil {
+			return err
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var cfg Config
+	if err := decodeConfig(data, &cfg, false); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if err := decodeConfig(data, &Config{}, true); err != nil {
+		fmt.Printf("%s: warning: %s, which is ignored\n", path, strings.TrimPrefix(err.Error(), "json: "))
+	}
+	applyDefaults(&cfg)
+	problems := checkConfig(&cfg)
+	for _, p := range problems {
+		fmt.Printf("%s: %s\n", path, p)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s has %d problem(s)", path, len(problems))
+	}
+	fmt.Printf("%s is valid\n", path)
+	return nil
+}
+
+// runQualityCommand shows or changes the main encoder's framerate and
+// bitrate; the encoder restarts with them and they are saved.
+func runQualityCommand(args []string) error {
+	sub := "show"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		sub, args = args[0], args[1:]
+	}
+	fs := flag.NewFlagSet("quality "+sub, flag.ExitOnError)
+	bitrate := fs.String("bitrate", "", "target bitrate, e.g. 2M or 800k")
+	fps := fs.Int("fps", 0, "frames per second, 1-120")
+	fs.Parse(args)
+	var st pipelineState
+	switch sub {
+	case "show":
+		if err := apiRequest("GET", "/api/v1/pipeline", nil, &st); err != nil {
+			return err
+		}
+	case "set":
+		if *bitrate == "" && *fps == 0 {
+			return fmt.Errorf("usage: remoter quality set [--bitrate 2M] [--fps 30]")
+		}
+		body := map[string]any{"bitrate": *bitrate, "framerate": *fps}
+		if err := apiRequest("PATCH", "/api/v1/pipeline", body, &st); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown quality subcommand %q", sub)
+	}
+	f := st.FFmpeg
+	fmt.Printf("Framerate: %d fps\nBitrate:   %s\n", f.Framerate, f.Bitrate)
+	if f.Running && f.FPS > 0 {
+		fmt.Printf("Encoding:  %.1f fps, %.0f kbit/s\n", f.FPS, f.OutputKbps)
+	}
+	return nil
+}
+
+// runSourceCommand shows or changes what the main encoder captures: a
+// monitor by its XRandR output name, a window, an area or the whole
+// screen.
+func runSourceCommand(args []string) error {
+	sub := "show"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		sub, args = args[0], args[1:]
+	}
+	fs := flag.NewFlagSet("source "+sub, flag.ExitOnError)
+	monitor := fs.String("monitor", "", "XRandR output to capture, e.g. HDMI-1")
+	window := fs.String("window", "", "capture the window whose name matches this regular expression")
+	region := fs.String("region", "", "capture an area, WxH+X+Y")
+	full := fs.Bool("full", false, "capture the whole screen")
+	fs.Parse(args)
+	var st pipelineState
+	switch sub {
+	case "show":
+		if err := apiRequest("GET", "/api/v1/pipeline", nil, &st); err != nil {
+			return err
+		}
+	case "set":
+		n := 0
+		for _, set := range []bool{*monitor != "", *window != "", *region != "", *full} {
+			if set {
+				n++
+			}
+		}
+		if n != 1 {
+			return fmt.Errorf("usage: remoter source set --monitor <output> | --window <name> | --region WxH+X+Y | --full")
+		}
+		var err error
+		switch {
+		case *full:
+			err = apiRequest("DELETE", "/api/v1/pipeline/capture", nil, &st)
+		case *region != "":
+			var r ffmpeg.Region
+			if _, serr := fmt.Sscanf(*region, "%dx%d+%d+%d", &r.W, &r.H, &r.X, &r.Y); serr != nil {
+				return fmt.Errorf("invalid region %q, want WxH+X+Y", *region)
+			}
+			err = apiRequest("PUT", "/api/v1/pipeline/capture", r, &st)
+		default:
+			body := map[string]string{"monitor": *monitor, "window": *window}
+			err = apiRequest("PUT", "/api/v1/pipeline/capture", body, &st)
+		}
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown source subcommand %q", sub)
+	}
+	if c := st.FFmpeg.Capture; c != nil {
+		fmt.Printf("Capturing %dx%d+%d+%d of the screen\n", c.W, c.H, c.X, c.Y)
+	} else {
+		fmt.Println("Capturing the whole screen")
+	}
+	return nil
+}
+
+func runChatCommand(args []string) error {
+	fs := flag.NewFlagSet("chat", flag.ExitOnError)
+	session := fs.String("session", "", "session whose viewers get the message (default: the main display)")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		return fmt.Errorf("usage: remoter chat [--session id] <text>")
+	}
+	body := map[string]string{"session": *session, "text": strings.Join(fs.Args(), " ")}
+	return apiRequest("POST", "/api/v1/chat", body, nil)
+}
+
+// runUserCommand edits the logins in the config file; the server picks
+// them up on its next start.
+func runUserCommand(args []string) error {
+	if len(args) < 2 {
+		printUsage()
+		return fmt.Errorf("usage: remoter user add|delete <name>")
+	}
+	cfg, err := loadOrCreateConfig()
+	if err != nil {
+		return err
+	}
+	path, err := getConfigPath()
+	if err != nil {
+		return err
+	}
+
+	name := args[1]
+	switch args[0] {
+	case "add":
+		fs := flag.NewFlagSet("user add", flag.ExitOnError)
+		passwordStdin := fs.Bool("password-stdin", false, "read the password from stdin instead of prompting")
+		fs.Parse(args[2:])
+		if strings.Contains(name, ":") {
+			return fmt.Errorf("user names cannot contain ':'")
+		}
+
+		if !*passwordStdin {
+			fmt.Fprintf(os.Stderr, "Password for %s: ", name)
+		}
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("failed to read password: %w", err)
+		}
+		password := strings.TrimRight(line, "\r\n")
+		if password == "" {
+			return fmt.Errorf("password cannot be empty")
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return fmt.Errorf("failed to hash password: %w", err)
+		}
+		if cfg.Users == nil {
+			cfg.Users = make(map[string]string)
+		}
+		cfg.Users[name] = string(hash)
+		if err := saveConfig(cfg, path); err != nil {
+			return err
+		}
+		fmt.Printf("Saved login %s; restart remoter to apply\n", name)
+		return nil
+
+	case "delete":
+		if _, ok := cfg.Users[name]; !ok {
+			return fmt.Errorf("no login named %q in %s", name, path)
+		}
+		delete(cfg.Users, name)
+		if err := saveConfig(cfg, path); err != nil {
+			return err
+		}
+		fmt.Printf("Removed login %s; restart remoter to apply\n", name)
+		return nil
+	}
+	return fmt.Errorf("unknown user subcommand %q", args[0])
+}
@@ -0,0 +1,378 @@
+package client
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/nathfavour/remoter/framesum"
+	"github.com/nathfavour/remoter/zst"
+)
+
+// ErrNotConnected is returned when sending on a channel that is down;
+// Run is reconnecting it.
+var ErrNotConnected = errors.New("not connected")
+
+// Options say where a Client connects and how it logs in.
+type Options struct {
+	// Server is the remoter's base URL, e.g. http://host:8080.
+	Server string
+	// Session is the virtual session to watch, empty for the main display.
+	Session string
+	// User and Password log in with basic auth.
+	User     string
+	Password string
+	// Key logs in with a paired key instead (see Pair): every connection
+	// and control message is signed with it.
+	Key ed25519.PrivateKey
+	// Name is how the client is shown to other viewers and controllers.
+	Name string
+	// Quality is the simulcast tier to watch, e.g. "low".
+	Quality string
+	// SkipVideo leaves out the video stream, for bots that only send
+	// input; Video then gets nothing.
+	SkipVideo bool
+	// Checksums has the server checksum every video chunk; corrupt ones
+	// are reported back to it and logged, and still passed on to Video.
+	Checksums bool
+	// Control joins the control channel, to take control and send input.
+	Control bool
+	// Dialer is websocket.DefaultDialer by default.
+	Dialer *websocket.Dialer
+	// Logf reports disconnects and reconnects.
+	Logf func(format string, args ...any)
+}
+
+// Event is a JSON message from the server, or a change in the client's
+// connections.
+type Event struct {
+	// Channel is "video" for notices on the video stream, such as
+	// "resolution", or "control" for the control channel's "control"
+	// state and "error" messages.
+	Channel string
+	// Type is the message's type, or "connected" and "disconnected" when
+	// a channel comes up or goes down.
+	Type string
+	// Data is the whole message.
+	Data json.RawMessage
+	// Err is why a channel went down.
+	Err error
+}
+
+// ControlState is who controls the stream and who asked to.
+type ControlState struct {
+	Stream   string       `json:"stream,omitempty"`
+	Holder   *Controller  `json:"holder"`
+	Requests []Controller `json:"requests"`
+	// You is this client's ID.
+	You string `json:"you,omitempty"`
+}
+
+type Controller struct {
+	ID   string `json:"id"`
+	User string `json:"user,omitempty"`
+	Name string `json:"name"`
+}
+
+// Holding reports whether this client holds control.
+func (s *ControlState) Holding() bool {
+	return s.Holder != nil && s.Holder.ID == s.You
+}
+
+// Control decodes a "control" event.
+func (e Event) Control() (*ControlState, error) {
+	if e.Type != "control" {
+		return nil, fmt.Errorf("%q is not a control event", e.Type)
+	}
+	var s ControlState
+	if err := json.Unmarshal(e.Data, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Client watches a remoter stream over WebSocket and, on the control
+// channel, drives its desktop. Run keeps both connected; video chunks
+// arrive on Video and everything else on Events, and both must be read
+// for the client to keep up.
+type Client struct {
+	opts   Options
+	video  chan []byte
+	events chan Event
+
+	mu      sync.Mutex
+	viewer  *conn
+	control *conn
+	nonce   string
+	seq     uint64
+}
+
+// conn is a WebSocket with writes serialized.
+type conn struct {
+	ws *websocket.Conn
+	// zstd is set when the server agreed to compress large messages.
+	zstd bool
+	mu   sync.Mutex
+}
+
+func (c *conn) writeJSON(v any) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ws.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return zst.WriteJSON(c.ws, v, c.zstd)
+}
+
+func New(opts Options) (*Client, error) {
+	u, err := url.Parse(opts.Server)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("server must be an http:// or https:// URL")
+	}
+	if opts.Key != nil && len(opts.Key) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("key must be a %d-byte Ed25519 private key", ed25519.PrivateKeySize)
+	}
+	if opts.SkipVideo && !opts.Control {
+		return nil, fmt.Errorf("nothing to connect to without video or control")
+	}
+	opts.Server = strings.TrimSuffix(opts.Server, "/")
+	if opts.Dialer == nil {
+		opts.Dialer = websocket.DefaultDialer
+	}
+	return &Client{
+		opts:   opts,
+		video:  make(chan []byte, 16),
+		events: make(chan Event, 16),
+	}, nil
+}
+
+// Video carries the MPEG-1 video stream in the chunks the server sends.
+// After a reconnect it resumes mid-stream, which MPEG-1 decoders recover
+// from at the next picture.
+func (c *Client) Video() <-chan []byte {
+	return c.video
+}
+
+func (c *Client) Events() <-chan Event {
+	return c.events
+}
+
+// Run connects and reconnects with backoff until ctx is done or the
+// server refuses the client's login or session, and then closes Video
+// and Events.
+func (c *Client) Run(ctx context.Context) error {
+	defer close(c.events)
+	defer close(c.video)
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	var wg sync.WaitGroup
+	keep := func(channel string) {
+		defer wg.Done()
+		if err := c.keep(ctx, channel); err != nil {
+			cancel(err)
+		}
+	}
+	if !c.opts.SkipVideo {
+		wg.Add(1)
+		go keep("video")
+	}
+	if c.opts.Control {
+		wg.Add(1)
+		go keep("control")
+	}
+	wg.Wait()
+	if err := context.Cause(ctx); !errors.Is(err, context.Canceled) {
+		return err
+	}
+	return nil
+}
+
+// keep connects a channel over and over, returning an error only when
+// the server refuses it outright.
+func (c *Client) keep(ctx context.Context, channel string) error {
+	backoff := time.Second
+	for {
+		start := time.Now()
+		ws, resp, err := c.dial(ctx, channel)
+		if resp != nil {
+			switch resp.StatusCode {
+			case http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound:
+				return fmt.Errorf("failed to connect the %s channel: %s", channel, resp.Status)
+			}
+		}
+		if err == nil {
+			err = c.serve(ctx, channel, ws, resp.Header.Get("X-Remoter-Compression") == "zstd")
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		if time.Since(start) > time.Minute {
+			backoff = time.Second
+		}
+		c.logf("%s channel: %v; reconnecting in %s", channel, err, backoff)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, 30*time.Second)
+	}
+}
+
+func (c *Client) dial(ctx context.Context, channel string) (*websocket.Conn, *http.Response, error) {
+	path, query := "/control", url.Values{}
+	if channel == "video" {
+		path = "/ws"
+		query.Set("notify", "1")
+		if c.opts.Checksums {
+			query.Set("checksum", "1")
+		}
+		c.mu.Lock()
+		if c.opts.Quality != "" {
+			query.Set("quality", c.opts.Quality)
+		}
+		c.mu.Unlock()
+	} else {
+		// Large control messages come compressed if the server agrees.
+		query.Set("zstd", "1")
+	}
+	if c.opts.Session != "" {
+		path = "/s/" + url.PathEscape(c.opts.Session) + path
+	}
+	if c.opts.Name != "" {
+		query.Set("name", c.opts.Name)
+	}
+	u, _ := url.Parse(c.opts.Server + path)
+	u.RawQuery = query.Encode()
+	u.Scheme = strings.Replace(u.Scheme, "http", "ws", 1)
+
+	header := http.Header{}
+	if c.opts.Key != nil {
+		header.Set("Authorization", signRequest(c.opts.Key, http.MethodGet, u.RequestURI()))
+	} else if c.opts.User != "" {
+		r := &http.Request{Header: header}
+		r.SetBasicAuth(c.opts.User, c.opts.Password)
+	}
+	return c.opts.Dialer.DialContext(ctx, u.String(), header)
+}
+
+// serve reads a channel until it drops. On a control channel using zstd,
+// binary messages are compressed JSON; on the video channel they are
+// video.
+func (c *Client) serve(ctx context.Context, channel string, ws *websocket.Conn, zstd bool) error {
+	defer ws.Close()
+	stop := context.AfterFunc(ctx, func() { ws.Close() })
+	defer stop()
+
+	cn := &conn{ws: ws, zstd: zstd}
+	c.mu.Lock()
+	if channel == "video" {
+		c.viewer = cn
+	} else {
+		c.control, c.nonce, c.seq = cn, "", 0
+	}
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		if channel == "video" {
+			c.viewer = nil
+		} else {
+			c.control = nil
+		}
+		c.mu.Unlock()
+	}()
+
+	c.emit(ctx, Event{Channel: channel, Type: "connected"})
+	var err error
+	for {
+		var typ int
+		var data []byte
+		typ, data, err = ws.ReadMessage()
+		if err != nil {
+			break
+		}
+		if typ == websocket.BinaryMessage && zstd {
+			if data, err = zst.Decode(data); err != nil {
+				break
+			}
+		} else if typ == websocket.BinaryMessage {
+			if c.opts.Checksums {
+				if data, err = c.check(cn, data); err != nil {
+					break
+				}
+			}
+			select {
+			case c.video <- data:
+			case <-ctx.Done():
+			}
+			continue
+		}
+		var msg struct {
+			Type  string `json:"type"`
+			Nonce string `json:"nonce"`
+		}
+		if json.Unmarshal(data, &msg) != nil {
+			continue
+		}
+		if channel == "control" && msg.Type == "key" {
+			c.mu.Lock()
+			c.nonce = msg.Nonce
+			c.mu.Unlock()
+			continue
+		}
+		c.emit(ctx, Event{Channel: channel, Type: msg.Type, Data: data})
+	}
+	if ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	c.emit(ctx, Event{Channel: channel, Type: "disconnected", Err: err})
+	return err
+}
+
+// check opens a checksummed video chunk, reporting it to the server if
+// it arrived corrupt.
+func (c *Client) check(cn *conn, msg []byte) ([]byte, error) {
+	_, payload, err := framesum.Open(msg)
+	var m *framesum.Mismatch
+	if !errors.As(err, &m) {
+		return payload, err
+	}
+	c.logf("video channel: %v", m)
+	if err := cn.writeJSON(map[string]any{"checksum_mismatch": m}); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+func (c *Client) emit(ctx context.Context, e Event) {
+	select {
+	case c.events <- e:
+	case <-ctx.Done():
+	}
+}
+
+// SetQuality switches the video stream to another simulcast tier, now
+// and on reconnects.
+func (c *Client) SetQuality(name string) error {
+	c.mu.Lock()
+	c.opts.Quality = name
+	v := c.viewer
+	c.mu.Unlock()
+	if v == nil {
+		return ErrNotConnected
+	}
+	return v.writeJSON(map[string]string{"quality": name})
+}
+
+func (c *Client) logf(format string, args ...any) {
+	if c.opts.Logf != nil {
+		c.opts.Logf(format, args...)
+	}
+}
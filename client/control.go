@@ -0,0 +1,99 @@
+package client
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+)
+
+// Input is pointer or keyboard input. X and Y are fractions of the
+// frame's width and height.
+type Input struct {
+	Type   string   `json:"type"` // move, down, up, click, key or type
+	X      float64  `json:"x"`
+	Y      float64  `json:"y"`
+	Button int      `json:"button,omitempty"`
+	Keys   []string `json:"keys,omitempty"`
+	Text   string   `json:"text,omitempty"`
+}
+
+type controlRequest struct {
+	Action string `json:"action,omitempty"`
+	To     string `json:"to,omitempty"`
+	Input  *Input `json:"input,omitempty"`
+}
+
+// send writes req on the control channel, signed when the client has a
+// key. Signed requests carry the nonce the server gave the connection
+// and a sequence number, so they can't be replayed.
+func (c *Client) send(req controlRequest) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.control == nil {
+		return ErrNotConnected
+	}
+	if c.opts.Key == nil {
+		return c.control.writeJSON(req)
+	}
+	if c.nonce == "" {
+		return ErrNotConnected
+	}
+	c.seq++
+	signed, err := json.Marshal(struct {
+		Nonce string `json:"nonce"`
+		Seq   uint64 `json:"seq"`
+		controlRequest
+	}{c.nonce, c.seq, req})
+	if err != nil {
+		return err
+	}
+	return c.control.writeJSON(map[string][]byte{
+		"signed": signed,
+		"sig":    ed25519.Sign(c.opts.Key, signed),
+	})
+}
+
+// RequestControl takes control if no one holds it, or else queues for
+// it; a "control" event tells which.
+func (c *Client) RequestControl() error {
+	return c.send(controlRequest{Action: "request"})
+}
+
+// ReleaseControl gives control up, or leaves the queue for it.
+func (c *Client) ReleaseControl() error {
+	return c.send(controlRequest{Action: "release"})
+}
+
+// Grant hands control to the controller with the given ID, and Deny
+// turns its request down; only the holder or an admin may.
+func (c *Client) Grant(id string) error {
+	return c.send(controlRequest{Action: "grant", To: id})
+}
+
+func (c *Client) Deny(id string) error {
+	return c.send(controlRequest{Action: "deny", To: id})
+}
+
+// Input sends input, which the server replays only while the client
+// holds control.
+func (c *Client) Input(in Input) error {
+	return c.send(controlRequest{Input: &in})
+}
+
+func (c *Client) Move(x, y float64) error {
+	return c.Input(Input{Type: "move", X: x, Y: y})
+}
+
+// Click clicks button (1 is left) at x, y.
+func (c *Client) Click(x, y float64, button int) error {
+	return c.Input(Input{Type: "click", X: x, Y: y, Button: button})
+}
+
+// Key presses keys together, e.g. Key("ctrl", "c").
+func (c *Client) Key(keys ...string) error {
+	return c.Input(Input{Type: "key", Keys: keys})
+}
+
+// Type types text.
+func (c *Client) Type(text string) error {
+	return c.Input(Input{Type: "type", Text: text})
+}
@@ -0,0 +1,72 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// GenerateKey makes a key to pair with a remoter. Keep it secret: it
+// logs in as the user it is paired for.
+func GenerateKey() (ed25519.PrivateKey, error) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	return priv, err
+}
+
+// KeyID is the ID a remoter knows a key by.
+func KeyID(key ed25519.PrivateKey) string {
+	sum := sha256.Sum256(key.Public().(ed25519.PublicKey))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Pair registers key with the remoter at server, using a pairing code
+// from `remoter keys pair`, and returns the key's ID.
+func Pair(ctx context.Context, server, code, name string, key ed25519.PrivateKey) (string, error) {
+	code = strings.ToUpper(strings.ReplaceAll(code, "-", ""))
+	body, _ := json.Marshal(map[string]any{
+		"code":       code,
+		"public_key": []byte(key.Public().(ed25519.PublicKey)),
+		"signature":  ed25519.Sign(key, []byte("remoter-pair\n"+code)),
+		"name":       name,
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(server, "/")+"/pair", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to pair: %w", err)
+	}
+	defer resp.Body.Close()
+	var out struct {
+		ID    string `json:"id"`
+		Error string `json:"error"`
+	}
+	json.NewDecoder(resp.Body).Decode(&out)
+	if resp.StatusCode != http.StatusCreated {
+		return "", fmt.Errorf("failed to pair: %s: %s", resp.Status, out.Error)
+	}
+	return out.ID, nil
+}
+
+// SignRequest logs r in with key, for calling the REST API with it. Sign
+// each request anew: the server turns a signature down the second time.
+func SignRequest(r *http.Request, key ed25519.PrivateKey) {
+	r.Header.Set("Authorization", signRequest(key, r.Method, r.URL.RequestURI()))
+}
+
+func signRequest(key ed25519.PrivateKey, method, uri string) string {
+	ts := time.Now().Unix()
+	sig := ed25519.Sign(key, fmt.Appendf(nil, "remoter-key\n%d\n%s\n%s", ts, method, uri))
+	return fmt.Sprintf("Remoter-Key id=%s, ts=%d, sig=%s", KeyID(key), ts, base64.StdEncoding.EncodeToString(sig))
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// tlsInfo identifies the TLS connection a client arrived over.
+type tlsInfo struct {
+	Version     string `json:"version"`
+	CipherSuite string `json:"cipher_suite"`
+	ServerName  string `json:"server_name,omitempty"`
+	// PeerSubject is the subject of the client certificate, if one was
+	// presented.
+	PeerSubject string `json:"peer_subject,omitempty"`
+}
+
+func tlsInfoFor(state *tls.ConnectionState) *tlsInfo {
+	if state == nil {
+		return nil
+	}
+	info := &tlsInfo{
+		Version:     tls.VersionName(state.Version),
+		CipherSuite: tls.CipherSuiteName(state.CipherSuite),
+		ServerName:  state.ServerName,
+	}
+	if len(state.PeerCertificates) > 0 {
+		info.PeerSubject = state.PeerCertificates[0].Subject.String()
+	}
+	return info
+}
+
+// clientCapabilities lists what was negotiated with the client at connect
+// time.
+func clientCapabilities(c *client, r *http.Request) []string {
+	var caps []string
+	if c.transport == transportWebSocket || c.transport == transportWebTransport {
+		caps = append(caps, "control")
+		if c.compressed {
+			caps = append(caps, "permessage-deflate")
+		}
+		if c.notices {
+			caps = append(caps, "notify")
+		}
+		if c.checksums {
+			caps = append(caps, "checksum")
+		}
+	}
+	if r.URL.Query().Get("quality") != "" {
+		caps = append(caps, "quality")
+	}
+	if c.profiled {
+		caps = append(caps, "profile")
+	}
+	return caps
+}
+
+// resolveHostname looks up the client's reverse DNS in the background, so
+// a slow resolver never delays the stream.
+func (c *client) resolveHostname() {
+	host, _, err := net.SplitHostPort(c.remoteAddr)
+	if err != nil {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		names, err := net.DefaultResolver.LookupAddr(ctx, host)
+		if err != nil || len(names) == 0 {
+			return
+		}
+		c.hostname.Store(strings.TrimSuffix(names[0], "."))
+	}()
+}
+
+// describe identifies the client in log lines.
+func (c *client) describe() string {
+	who := c.user
+	if who == "" {
+		who = "anonymous"
+	}
+	desc := fmt.Sprintf("%s %s from %s", c.id, who, c.remoteAddr)
+	if host := c.hostname.Load().(string); host != "" {
+		desc += " (" + host + ")"
+	}
+	if c.tls != nil && c.tls.PeerSubject != "" {
+		desc += " cert=" + c.tls.PeerSubject
+	}
+	return desc
+}
@@ -0,0 +1,525 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/nathfavour/remoter/ffmpeg"
+	"github.com/nathfavour/remoter/framesum"
+	"github.com/quic-go/webtransport-go"
+)
+
+const (
+	transportWebRTC    = "webrtc"
+	transportWebSocket = "websocket"
+	transportHTTP      = "http"
+	// transportWebTransport clients take datagrams over HTTP/3.
+	transportWebTransport = "webtransport"
+	// transportRecord clients write the stream to recording storage.
+	transportRecord = "record"
+	// transportCast clients feed the transcoder of a cast device.
+	transportCast = "cast"
+	// transportGrid clients feed a grid composite with one of its sources.
+	transportGrid = "grid"
+)
+
+// sendQueueSize is how many chunks may wait for a slow viewer before it
+// is dropped.
+const sendQueueSize = 256
+
+var (
+	errClientClosed = errors.New("client closed")
+	errClientBehind = errors.New("client send queue is full")
+	nextClientID    atomic.Uint64
+)
+
+// client is a single viewer receiving the broadcast, over whichever
+// transport it connected with.
+type client struct {
+	id          string
+	transport   string
+	stream      string // session ID, or "" for the main display
+	user        string
+	role        string // view, control or admin; "" when auth is off
+	invite      string
+	remoteAddr  string
+	hostname    atomic.Value // string reverse DNS, once resolved
+	userAgent   string
+	tls         *tlsInfo
+	device      string // remembered browser, see deviceStore
+	profiled    bool   // quality came from the device's profile
+	caps        []string
+	connectedAt time.Time
+	compressed  bool
+	notices     bool // takes JSON text notices alongside the video
+	// checksums puts every video message in a framesum envelope, for
+	// viewers that check what arrives and report mismatches.
+	checksums      bool
+	seq            uint32 // of the next enveloped message or datagram, under mu
+	checksumErrors atomic.Int64
+	bytesSent      atomic.Int64
+	latency        atomic.Int64 // smoothed write latency, in nanoseconds
+	quality        atomic.Value // string tier name; changed only by the hub
+
+	// queue feeds the writer goroutine started by run, so one slow viewer
+	// does not hold up the broadcast to the others.
+	queue chan *chunk
+
+	mu      sync.Mutex
+	closed  bool
+	conn    *websocket.Conn // websocket transport
+	w       io.Writer       // http and record transports
+	flusher http.Flusher
+	done    chan struct{}
+
+	// session is a webtransport client's, and control the stream it
+	// opened for control messages, nil until it does.
+	session      *webtransport.Session
+	control      *webtransport.Stream
+	datagramSize int    // the largest datagram sent to it
+	tsPartial    []byte // the start of a TS packet still to send
+}
+
+func newClient(transport string, r *http.Request) *client {
+	c := &client{
+		id:          strconv.FormatUint(nextClientID.Add(1), 10),
+		transport:   transport,
+		stream:      r.PathValue("session"),
+		user:        requestAuth(r).User,
+		role:        requestAuth(r).Role,
+		invite:      requestAuth(r).Invite,
+		remoteAddr:  r.RemoteAddr,
+		userAgent:   r.UserAgent(),
+		tls:         tlsInfoFor(r.TLS),
+		device:      deviceOf(r),
+		connectedAt: time.Now(),
+		queue:       make(chan *chunk, sendQueueSize),
+		done:        make(chan struct{}),
+	}
+	quality := r.URL.Query().Get("quality")
+	if quality != "" && qualityExists(quality) {
+		viewerDevices.remember(c.device, quality)
+	} else if quality = viewerDevices.preferredQuality(c.device); quality != "" {
+		c.profiled = true
+	} else {
+		quality = ffmpeg.DefaultTier
+	}
+	c.quality.Store(quality)
+	c.hostname.Store("")
+	c.resolveHostname()
+	return c
+}
+
+// streamKey is what the hub publishes a client's stream and tier under.
+func (c *client) streamKey() string {
+	return streamKey(c.stream, c.quality.Load().(string))
+}
+
+func newWebSocketClient(conn *websocket.Conn, r *http.Request) *client {
+	c := newClient(transportWebSocket, r)
+	c.conn = conn
+	c.compressed = upgrader.EnableCompression && r.URL.Query().Get("compress") != "0" &&
+		strings.Contains(r.Header.Get("Sec-WebSocket-Extensions"), "permessage-deflate")
+	c.notices = r.URL.Query().Get("notify") == "1"
+	c.checksums = r.URL.Query().Get("checksum") == "1"
+	c.caps = clientCapabilities(c, r)
+	return c
+}
+
+// pingTimeout is how long a WebSocket viewer may stay silent, pongs
+// included, before it is dropped; set from the config at startup.
+var pingTimeout = 15 * time.Second
+
+// keepAlive pings conn so that viewers which vanished without a close
+// frame fail their next read within pingTimeout. It returns when done is
+// closed.
+func keepAlive(conn *websocket.Conn, done <-chan struct{}) {
+	conn.SetReadDeadline(time.Now().Add(pingTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pingTimeout))
+	})
+
+	go func() {
+		t := time.NewTicker(pingTimeout / 3)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+				// WriteControl may run concurrently with the broadcast's
+				// writes.
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(pingTimeout/3)); err != nil {
+					return
+				}
+			}
+		}
+	}()
+}
+
+// negotiateCompression applies the configured level to a connection that
+// negotiated permessage-deflate; viewers can opt out with ?compress=0.
+func negotiateCompression(conn *websocket.Conn, r *http.Request) {
+	if !upgrader.EnableCompression {
+		return
+	}
+	if r.URL.Query().Get("compress") == "0" {
+		conn.EnableWriteCompression(false)
+		return
+	}
+	_ = conn.SetCompressionLevel(compressionLevel)
+}
+
+// zstdHeader confirms, in the handshake of an auxiliary channel such as
+// /control or /chat, that its client asked for zstd with ?zstd=1: messages
+// of zst.MinSize or more then come as binary messages holding the JSON
+// compressed, and the client may send its own that way.
+const zstdHeader = "X-Remoter-Compression"
+
+// negotiateZstd returns the handshake headers of an auxiliary channel and
+// whether it uses zstd. The media path never does.
+func negotiateZstd(r *http.Request) (http.Header, bool) {
+	if r.URL.Query().Get("zstd") != "1" {
+		return nil, false
+	}
+	return http.Header{zstdHeader: {"zstd"}}, true
+}
+
+// upgradeAux upgrades an auxiliary channel, negotiating compression.
+// Connections using zstd skip permessage-deflate, which would only spend
+// time on what is compressed already or too small to matter.
+func upgradeAux(w http.ResponseWriter, r *http.Request) (*websocket.Conn, bool, error) {
+	header, zstd := negotiateZstd(r)
+	conn, err := upgrader.Upgrade(w, r, header)
+	if err != nil {
+		return nil, false, err
+	}
+	negotiateCompression(conn, r)
+	if zstd {
+		conn.EnableWriteCompression(false)
+	}
+	return conn, zstd, nil
+}
+
+func newHTTPClient(w http.ResponseWriter, r *http.Request) *client {
+	c := newClient(transportHTTP, r)
+	c.w = w
+	c.flusher, _ = w.(http.Flusher)
+	c.caps = clientCapabilities(c, r)
+	return c
+}
+
+// notify sends v as a JSON text message to a WebSocket viewer that asked
+// for notices with ?notify=1; players that only expect video never get
+// one.
+func (c *client) notify(v any) error {
+	if (c.conn == nil && c.session == nil) || !c.notices {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return errClientClosed
+	}
+	if c.session != nil {
+		return c.writeControl(data)
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(pingTimeout))
+	return c.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// notifyViewers sends v to the viewers of stream that take notices.
+func notifyViewers(stream string, v any) {
+	clientsMux.RLock()
+	var viewers []*client
+	for c := range clients {
+		if c.stream == stream {
+			viewers = append(viewers, c)
+		}
+	}
+	clientsMux.RUnlock()
+	for _, c := range viewers {
+		c.notify(v)
+	}
+}
+
+func (c *client) write(data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return errClientClosed
+	}
+
+	if c.conn != nil {
+		// A viewer whose TCP window stays shut is as dead as a silent one.
+		c.conn.SetWriteDeadline(time.Now().Add(pingTimeout))
+		if c.checksums {
+			if err := c.writeEnveloped(data); err != nil {
+				return err
+			}
+		} else if err := c.conn.WriteMessage(websocket.BinaryMessage, data); err != nil {
+			return err
+		}
+	} else if c.session != nil {
+		if err := c.sendDatagrams(data); err != nil {
+			return err
+		}
+	} else {
+		if _, err := c.w.Write(data); err != nil {
+			return err
+		}
+		if c.flusher != nil {
+			c.flusher.Flush()
+		}
+	}
+	c.bytesSent.Add(int64(len(data)))
+	return nil
+}
+
+// writeEnveloped sends data behind its framesum header. c.mu must be held.
+func (c *client) writeEnveloped(data []byte) error {
+	w, err := c.conn.NextWriter(websocket.BinaryMessage)
+	if err != nil {
+		return err
+	}
+	h := framesum.Header(c.seq, data)
+	c.seq++
+	if _, err := w.Write(h[:]); err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// enqueue schedules ch for delivery without blocking.
+func (c *client) enqueue(ch *chunk) error {
+	select {
+	case <-c.done:
+		return errClientClosed
+	case c.queue <- ch:
+		return nil
+	default:
+		return errClientBehind
+	}
+}
+
+// run writes queued data until the client is closed or a write fails.
+func (c *client) run() {
+	for {
+		select {
+		case <-c.done:
+			return
+		case ch := <-c.queue:
+			start := time.Now()
+			span := ch.traceWrite(c)
+			err := c.write(ch.data)
+			span.Fail(err)
+			span.End()
+			ch.release()
+			if err != nil {
+				removeClient(c)
+				c.close()
+				return
+			}
+			// Exponentially weighted, so one stalled write doesn't dominate.
+			took := int64(time.Since(start))
+			c.latency.Store((c.latency.Load()*7 + took) / 8)
+		}
+	}
+}
+
+// behind reports whether the client is falling behind the stream: its
+// queue is filling up or its writes are slow.
+func (c *client) behind() bool {
+	return len(c.queue) > sendQueueSize/4 || time.Duration(c.latency.Load()) > 250*time.Millisecond
+}
+
+// close stops delivery to the client. For HTTP clients it releases the
+// handler goroutine, which must not return while a write is in flight.
+func (c *client) close() {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	c.closed = true
+	if c.conn != nil {
+		c.conn.Close()
+	}
+	if c.session != nil {
+		c.session.CloseWithError(0, "")
+	}
+	close(c.done)
+	c.mu.Unlock()
+
+	quotas.chargeStream(c)
+	if c.transport != transportRecord {
+		emit(eventClientDisconnected, c.info())
+	}
+}
+
+// addClient registers c, starts its writer and subscribes it to its
+// stream, beginning with the cached GOP.
+func addClient(c *client) int {
+	clientsMux.Lock()
+	clients[c] = true
+	total := len(clients)
+	clientsMux.Unlock()
+
+	go c.run()
+	streamHub.join <- c
+	wakeEncoder()
+	if c.transport != transportRecord {
+		emit(eventClientConnected, c.info())
+	}
+	return total
+}
+
+func removeClient(c *client) int {
+	clientsMux.Lock()
+	delete(clients, c)
+	total := len(clients)
+	clientsMux.Unlock()
+
+	streamHub.leave <- c
+	return total
+}
+
+func clientCount() int {
+	clientsMux.RLock()
+	defer clientsMux.RUnlock()
+	return len(clients)
+}
+
+// transportCounts returns the number of connected clients per transport.
+func transportCounts() map[string]int {
+	clientsMux.RLock()
+	defer clientsMux.RUnlock()
+	counts := map[string]int{transportWebSocket: 0, transportWebTransport: 0, transportHTTP: 0}
+	for c := range clients {
+		counts[c.transport]++
+	}
+	return counts
+}
+
+// clientInfo is the JSON shape of a client in the clients API.
+type clientInfo struct {
+	ID          string    `json:"id"`
+	Transport   string    `json:"transport"`
+	Session     string    `json:"session,omitempty"`
+	User        string    `json:"user,omitempty"`
+	Role        string    `json:"role,omitempty"`
+	Invite      string    `json:"invite,omitempty"`
+	RemoteAddr  string    `json:"remote_addr"`
+	Hostname    string    `json:"hostname,omitempty"`
+	UserAgent   string    `json:"user_agent"`
+	TLS         *tlsInfo  `json:"tls,omitempty"`
+	Device      string    `json:"device,omitempty"`
+	Caps        []string  `json:"capabilities"`
+	ConnectedAt time.Time `json:"connected_at"`
+	Compressed  bool      `json:"compressed,omitempty"`
+	Quality     string    `json:"quality"`
+	BytesSent   int64     `json:"bytes_sent"`
+	QueueDepth  int       `json:"queue_depth"`
+	LatencyMs   float64   `json:"write_latency_ms"`
+	// ChecksumErrors counts the corrupt messages the viewer reported.
+	ChecksumErrors int64 `json:"checksum_errors,omitempty"`
+}
+
+func (c *client) info() clientInfo {
+	return clientInfo{
+		ID:          c.id,
+		Transport:   c.transport,
+		Session:     c.stream,
+		User:        c.user,
+		Role:        c.role,
+		Device:      c.device,
+		Invite:      c.invite,
+		RemoteAddr:  c.remoteAddr,
+		Hostname:    c.hostname.Load().(string),
+		UserAgent:   c.userAgent,
+		TLS:         c.tls,
+		Caps:        c.caps,
+		ConnectedAt: c.connectedAt,
+		Compressed:  c.compressed,
+		Quality:     c.quality.Load().(string),
+		BytesSent:   c.bytesSent.Load(),
+		QueueDepth:  len(c.queue),
+		LatencyMs:   float64(c.latency.Load()) / float64(time.Millisecond),
+
+		ChecksumErrors: c.checksumErrors.Load(),
+	}
+}
+
+func listClients() []clientInfo {
+	clientsMux.RLock()
+	defer clientsMux.RUnlock()
+	infos := make([]clientInfo, 0, len(clients))
+	for c := range clients {
+		infos = append(infos, c.info())
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ConnectedAt.Before(infos[j].ConnectedAt)
+	})
+	return infos
+}
+
+// kickClient disconnects the client with the given id.
+func kickClient(id string) bool {
+	clientsMux.Lock()
+	var target *client
+	for c := range clients {
+		if c.id == id {
+			target = c
+			delete(clients, c)
+			break
+		}
+	}
+	clientsMux.Unlock()
+
+	if target == nil {
+		return false
+	}
+	streamHub.leave <- target
+	target.close()
+	return true
+}
+
+// kickStream disconnects every client watching stream, used when a
+// session goes away.
+func kickStream(stream string) {
+	kickWhere(func(c *client) bool { return c.stream == stream })
+}
+
+// kickWhere disconnects every client matching pred.
+func kickWhere(pred func(*client) bool) {
+	clientsMux.Lock()
+	var targets []*client
+	for c := range clients {
+		if pred(c) {
+			targets = append(targets, c)
+			delete(clients, c)
+		}
+	}
+	clientsMux.Unlock()
+
+	for _, c := range targets {
+		streamHub.leave <- c
+		c.close()
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/nathfavour/remoter/ffmpeg"
+	"github.com/nathfavour/remoter/storage"
+)
+
+// maxClipLength keeps clips short enough to share in chat or attach to a
+// bug report.
+const maxClipLength = 2 * time.Minute
+
+// handleExportClip cuts {"start", "duration"} (e.g. "1m30s" and "15s") out
+// of a finished recording, encodes it as {"format"} webm (the default) or
+// gif, optionally {"width"} pixels wide, and stores it beside the
+// recording, where GET /api/v1/recordings/{name} serves it.
+func handleExportClip(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	var req struct {
+		Start    string `json:"start"`
+		Duration string `json:"duration"`
+		Format   string `json:"format"`
+		Width    int    `json:"width"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	var start time.Duration
+	if req.Start != "" {
+		var err error
+		if start, err = time.ParseDuration(req.Start); err != nil || start < 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid start %q", req.Start))
+			return
+		}
+	}
+	length, err := time.ParseDuration(req.Duration)
+	if err != nil || length <= 0 || length > maxClipLength {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("duration must be between 0 and %s", maxClipLength))
+		return
+	}
+	if req.Format == "" {
+		req.Format = ffmpeg.ClipWebM
+	}
+	if req.Format != ffmpeg.ClipWebM && req.Format != ffmpeg.ClipGIF {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unknown clip format %q", req.Format))
+		return
+	}
+	if req.Width < 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid width %d", req.Width))
+		return
+	}
+	if _, ok := activeRecording(name); ok {
+		writeError(w, http.StatusConflict, fmt.Errorf("recording %s is still running", name))
+		return
+	}
+	if path.Ext(name) != ".mpg" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("%s is not a recording", name))
+		return
+	}
+	user := requestAuth(r).User
+	if err := quotas.admitRecording(user); err != nil {
+		writeError(w, http.StatusForbidden, fmt.Errorf("quota exceeded: %w", err))
+		return
+	}
+
+	rd, err := recordingStore.Open(name)
+	if err != nil {
+		status := http.StatusBadGateway
+		if errors.Is(err, storage.ErrNotExist) {
+			status = http.StatusNotFound
+		}
+		writeError(w, status, err)
+		return
+	}
+	defer rd.Close()
+	data, err := ffmpeg.Clip(r.Context(), rd, start, length, req.Format, req.Width)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	clip := fmt.Sprintf("%s-clip-%s-%s.%s", strings.TrimSuffix(name, ".mpg"), clipStamp(start), clipStamp(length), req.Format)
+	cw, err := recordingStore.Create(clip)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, fmt.Errorf("failed to create clip: %w", err))
+		return
+	}
+	if _, err := cw.Write(data); err != nil {
+		cw.Close()
+		writeError(w, http.StatusBadGateway, fmt.Errorf("failed to write clip: %w", err))
+		return
+	}
+	if err := cw.Close(); err != nil {
+		writeError(w, http.StatusBadGateway, fmt.Errorf("failed to save clip: %w", err))
+		return
+	}
+	quotas.chargeRecording(user, clip, int64(len(data)))
+	log.Printf("API: exported %s from %s (%d bytes)", clip, name, len(data))
+	writeJSON(w, http.StatusCreated, storage.Object{Name: clip, Size: int64(len(data)), ModTime: time.Now()})
+}
+
+// clipStamp spells d for a file name, e.g. "1m30s".
+func clipStamp(d time.Duration) string {
+	return strings.ReplaceAll(d.Round(time.Millisecond).String(), ".", "_")
+}
+
+// recordingType is the MIME type recordings and clips are served as.
+func recordingType(name string) string {
+	switch path.Ext(name) {
+	case ".webm":
+		return "video/webm"
+	case ".gif":
+		return "image/gif"
+	case ".json":
+		return "application/json"
+	}
+	return "video/mpeg"
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"regexp"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// CommandConfig is a command the API may run on the host, such as
+// restarting an app shown on the shared screen. Run is the program and its
+// arguments; it is never passed through a shell, and callers can't add
+// arguments of their own.
+type CommandConfig struct {
+	Run         []string `json:"run"`
+	Description string   `json:"description,omitempty"`
+	Dir         string   `json:"dir,omitempty"`
+	Timeout     string   `json:"timeout,omitempty"` // default 1m
+}
+
+var commandNameRe = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// commands is the allowlist, set in main.
+var commands map[string]CommandConfig
+
+func validateCommands(cmds map[string]CommandConfig) error {
+	for name, c := range cmds {
+		if !commandNameRe.MatchString(name) {
+			return fmt.Errorf("invalid command name %q", name)
+		}
+		if len(c.Run) == 0 || c.Run[0] == "" {
+			return fmt.Errorf("command %q has nothing to run", name)
+		}
+		if c.Timeout != "" {
+			if d, err := time.ParseDuration(c.Timeout); err != nil || d <= 0 {
+				return fmt.Errorf("invalid timeout for command %q", name)
+			}
+		}
+	}
+	return nil
+}
+
+func handleListCommands(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	type commandInfo struct {
+		Name        string   `json:"name"`
+		Description string   `json:"description,omitempty"`
+		Run         []string `json:"run"`
+	}
+	list := []commandInfo{}
+	for name, c := range commands {
+		list = append(list, commandInfo{name, c.Description, c.Run})
+	}
+	slices.SortFunc(list, func(a, b commandInfo) int { return cmp.Compare(a.Name, b.Name) })
+	writeJSON(w, http.StatusOK, list)
+}
+
+// commandEvent is one line of a run's output stream: a chunk of stdout or
+// stderr, and finally the result.
+type commandEvent struct {
+	Stream   string  `json:"stream,omitempty"` // "stdout" or "stderr"
+	Data     string  `json:"data,omitempty"`
+	Exit     *int    `json:"exit_code,omitempty"`
+	Error    string  `json:"error,omitempty"`
+	Duration float64 `json:"duration,omitempty"` // seconds
+}
+
+// commandStream writes commandEvents as newline-delimited JSON, flushing
+// each so the caller sees output as it comes.
+type commandStream struct {
+	mu      sync.Mutex
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+func (s *commandStream) send(ev commandEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if json.NewEncoder(s.w).Encode(ev) != nil {
+		return
+	}
+	if s.flusher != nil {
+		s.flusher.Flush()
+	}
+}
+
+// commandOutput is one of a command's output streams.
+type commandOutput struct {
+	s    *commandStream
+	name string
+}
+
+func (o commandOutput) Write(p []byte) (int, error) {
+	o.s.send(commandEvent{Stream: o.name, Data: string(p)})
+	return len(p), nil
+}
+
+// handleRunCommand runs an allowlisted command and streams its output
+// back as newline-delimited JSON. The command is killed, with anything it
+// started, when it times out or the caller goes away.
+func handleRunCommand(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	name := r.PathValue("name")
+	c, ok := commands[name]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no command named %q", name))
+		return
+	}
+	timeout, _ := time.ParseDuration(cmp.Or(c.Timeout, "1m"))
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, c.Run[0], c.Run[1:]...)
+	cmd.Dir = cmp.Or(c.Dir, os.Getenv("HOME"), "/")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	// Don't wait on children that outlive the command holding its output.
+	cmd.WaitDelay = time.Second
+
+	stream := &commandStream{w: w}
+	stream.flusher, _ = w.(http.Flusher)
+	cmd.Stdout = commandOutput{stream, "stdout"}
+	cmd.Stderr = commandOutput{stream, "stderr"}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-cache")
+	if err := cmd.Start(); err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("failed to run %s: %w", name, err))
+		return
+	}
+	who := cmp.Or(requestAuth(r).User, r.RemoteAddr)
+	log.Printf("API: %s ran command %s (pid %d)", who, name, cmd.Process.Pid)
+
+	start := time.Now()
+	err := cmd.Wait()
+	result := commandEvent{Duration: time.Since(start).Seconds()}
+	code := cmd.ProcessState.ExitCode()
+	result.Exit = &code
+	var exitErr *exec.ExitError
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		result.Error = fmt.Sprintf("timed out after %s", timeout)
+	case err != nil && !errors.As(err, &exitErr):
+		result.Error = err.Error()
+	}
+	stream.send(result)
+	log.Printf("Command %s exited with %d after %.1fs", name, code, result.Duration)
+}
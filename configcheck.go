@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/nathfavour/remoter/audio"
+	"github.com/nathfavour/remoter/ffmpeg"
+)
+
+// configProblem is a setting the server would refuse, or trip over at
+// runtime.
+type configProblem struct {
+	Field string
+	Err   error
+}
+
+func (p configProblem) String() string {
+	return p.Field + ": " + p.Err.Error()
+}
+
+var (
+	displayRe = regexp.MustCompile(`^([^:\s]*:\d+(\.\d+)?|wayland-\d+)$`)
+	resRe     = regexp.MustCompile(`^[1-9]\d*x[1-9]\d*(x(8|15|16|24|30|32))?$`)
+)
+
+// checkConfig validates a loaded config, defaults applied, and returns
+// every problem it finds rather than stopping at the first, so one run
+// of `remoter config validate` shows all that needs fixing.
+func checkConfig(cfg *Config) []configProblem {
+	var problems []configProblem
+	check := func(field string, err error) {
+		if err != nil {
+			problems = append(problems, configProblem{field, err})
+		}
+	}
+
+	if cfg.Display != "" && !displayRe.MatchString(cfg.Display) {
+		check("display", fmt.Errorf("%q is not a display; use e.g. \":0\", \":0.0\" or \"wayland-1\"", cfg.Display))
+	}
+	if !resRe.MatchString(cfg.Res) {
+		check("res", fmt.Errorf("%q is not a resolution; use WxH or WxHxDEPTH, e.g. \"1920x1080x24\"", cfg.Res))
+	}
+	if cfg.Port < 1 || cfg.Port > 65535 {
+		check("port", fmt.Errorf("%d is out of range; use 1-65535", cfg.Port))
+	}
+	check("bind/listeners", validateListeners(cfg.Bind, cfg.Port, cfg.Listeners))
+	if cfg.Framerate < 1 || cfg.Framerate > 120 {
+		check("framerate", fmt.Errorf("%d is out of range; use 1-120 frames per second", cfg.Framerate))
+	}
+	if !bitrateRe.MatchString(cfg.Bitrate) {
+		check("bitrate", fmt.Errorf("%q is not a bitrate; use bits per second with an optional k or M, e.g. \"800k\"", cfg.Bitrate))
+	}
+	if cfg.Scale != "" {
+		if _, _, err := ffmpeg.ParseSize(cfg.Scale); err != nil {
+			check("scale", fmt.Errorf("%q is not a size; use WxH, e.g. \"1280x720\"", cfg.Scale))
+		}
+	}
+	for _, r := range []struct {
+		field  string
+		region *ffmpeg.Region
+	}{{"capture", cfg.Capture}, {"roi", cfg.ROI}} {
+		if c := r.region; c != nil && (c.W <= 0 || c.H <= 0 || c.X < 0 || c.Y < 0) {
+			check(r.field, fmt.Errorf("needs a non-negative x and y and a positive w and h"))
+		}
+	}
+	if cfg.CursorRate < 0 {
+		check("cursor_rate", fmt.Errorf("must not be negative"))
+	}
+	if cfg.PingTimeout != "" {
+		if d, err := time.ParseDuration(cfg.PingTimeout); err != nil || d < time.Second {
+			check("ping_timeout", fmt.Errorf("%q is not a duration of a second or more, e.g. \"30s\"", cfg.PingTimeout))
+		}
+	}
+	if cfg.CompressionLevel != 0 && (cfg.CompressionLevel < 1 || cfg.CompressionLevel > 9) {
+		check("compression_level", fmt.Errorf("%d is out of range; use 1 (fast) to 9", cfg.CompressionLevel))
+	}
+
+	check("color", cfg.Color.Validate())
+	check("watermark", cfg.Watermark.Validate())
+	check("placeholder", cfg.Placeholder.validate())
+	check("tiers", ffmpeg.ValidateTiers(cfg.Tiers))
+	for _, t := range cfg.Tiers {
+		if t.Name == audioQuality {
+			check("tiers", fmt.Errorf("%q is reserved for audio", audioQuality))
+		}
+	}
+	if cfg.Audio != nil {
+		check("audio.sources", audio.Validate(cfg.Audio.Sources))
+		check("audio.noise_model", audio.ValidateModel(cfg.Audio.NoiseModel))
+	}
+	check("privacy_masks", validateMasks(cfg.PrivacyMasks))
+	check("isolation", validateIsolation(cfg.Isolation, cfg.Templates))
+	check("grids", validateGrids(cfg.Grids))
+	check("recording_schedules", validateSchedules(cfg.RecordingSchedules))
+	if p := cfg.RecordingRetention; p != nil {
+		check("recording_retention", p.validate())
+	}
+	if c := cfg.Replay; c != nil {
+		check("replay", c.validate())
+	}
+	check("services", validateServices(cfg.Services))
+	check("commands", validateCommands(cfg.Commands))
+	if _, err := newIPFilter(cfg.Allow, cfg.Deny); err != nil {
+		check("allow/deny", err)
+	}
+	return problems
+}
+
+// decodeConfig parses a config file, pointing syntax and type errors at
+// their line and column. Strict rejects fields the server does not know,
+// which it otherwise ignores: usually a misspelled setting.
+func decodeConfig(data []byte, cfg *Config, strict bool) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if strict {
+		dec.DisallowUnknownFields()
+	}
+	err := dec.Decode(cfg)
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		line, col := position(data, syntaxErr.Offset)
+		return fmt.Errorf("line %d, column %d: %v", line, col, syntaxErr)
+	case errors.As(err, &typeErr):
+		line, col := position(data, typeErr.Offset)
+		return fmt.Errorf("line %d, column %d: %s must be %s, not a JSON %s", line, col, typeErr.Field, jsonType(typeErr.Type.Kind().String()), typeErr.Value)
+	}
+	return err
+}
+
+// position turns a byte offset into a 1-based line and column.
+func position(data []byte, offset int64) (line, col int) {
+	offset = min(offset, int64(len(data)))
+	before := data[:offset]
+	line = bytes.Count(before, []byte("\n")) + 1
+	col = int(offset) - bytes.LastIndexByte(before, '\n')
+	return line, col
+}
+
+// jsonType names a Go kind the way a config file author would.
+func jsonType(kind string) string {
+	switch kind {
+	case "string":
+		return "a string"
+	case "bool":
+		return "true or false"
+	case "slice", "array":
+		return "a list"
+	case "map", "struct", "ptr":
+		return "an object"
+	case "float32", "float64":
+		return "a number"
+	}
+	return "a whole number"
+}
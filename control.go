@@ -0,0 +1,562 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/nathfavour/remoter/automation"
+	"github.com/nathfavour/remoter/i18n"
+	"github.com/nathfavour/remoter/zst"
+)
+
+// A viewer drives a stream's desktop only while holding its control, and
+// one viewer at most holds it: they connect to /control (/s/{id}/control
+// for sessions), ask for it, and get it right away if it is free or when
+// the holder or an admin hands it over.
+
+var nextControllerID atomic.Uint64
+
+// controller is a viewer's connection to the control channel of a stream.
+type controller struct {
+	id     string
+	stream string
+	user   string
+	name   string
+	admin  bool // may hand over and revoke control held by others
+
+	// Controllers that connected with a paired key sign each message,
+	// over the nonce they were sent and a sequence number that only
+	// grows, so no message can be replayed or sent by anyone else.
+	keyID string
+	key   []byte
+	nonce string
+	seq   uint64
+
+	mu   sync.Mutex
+	conn *websocket.Conn
+	zstd bool
+
+	// target is used only by the reading goroutine.
+	target inputTarget
+}
+
+const controlSizeTTL = 5 * time.Second
+
+// inputTarget replays input on the display of a stream, whose size it
+// looks up again every controlSizeTTL in case it changed.
+type inputTarget struct {
+	stream  string
+	w, h    int
+	sizedAt time.Time
+}
+
+func (c *controller) send(v any) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(pingTimeout))
+	return zst.WriteJSON(c.conn, v, c.zstd)
+}
+
+// controlFloor is who holds control of a stream and who waits for it, in
+// the order they asked.
+type controlFloor struct {
+	holder   *controller
+	requests []*controller
+	members  map[*controller]bool
+}
+
+var (
+	floors   = make(map[string]*controlFloor)
+	floorMux sync.Mutex
+)
+
+type controllerInfo struct {
+	ID   string `json:"id"`
+	User string `json:"user,omitempty"`
+	Name string `json:"name"`
+}
+
+func (c *controller) info() controllerInfo {
+	return controllerInfo{ID: c.id, User: c.user, Name: c.name}
+}
+
+// controlState is sent to every controller of a stream when its control
+// changes, and returned by the API.
+type controlState struct {
+	Type     string           `json:"type"` // "control"
+	Stream   string           `json:"stream,omitempty"`
+	Holder   *controllerInfo  `json:"holder"`
+	Requests []controllerInfo `json:"requests"`
+	// You is the ID of the controller the state is sent to.
+	You string `json:"you,omitempty"`
+}
+
+// stateLocked describes floor; floorMux must be held.
+func (f *controlFloor) stateLocked(stream string) controlState {
+	s := controlState{Type: "control", Stream: stream, Requests: []controllerInfo{}}
+	if f.holder != nil {
+		info := f.holder.info()
+		s.Holder = &info
+	}
+	for _, c := range f.requests {
+		s.Requests = append(s.Requests, c.info())
+	}
+	return s
+}
+
+// controlStateOf returns the control state of stream.
+func controlStateOf(stream string) controlState {
+	floorMux.Lock()
+	defer floorMux.Unlock()
+	if f := floors[stream]; f != nil {
+		return f.stateLocked(stream)
+	}
+	return controlState{Type: "control", Stream: stream, Requests: []controllerInfo{}}
+}
+
+// broadcastControl sends the control state of stream to its controllers.
+func broadcastControl(stream string) {
+	floorMux.Lock()
+	f := floors[stream]
+	if f == nil {
+		floorMux.Unlock()
+		return
+	}
+	state := f.stateLocked(stream)
+	members := make([]*controller, 0, len(f.members))
+	for c := range f.members {
+		members = append(members, c)
+	}
+	floorMux.Unlock()
+	for _, c := range members {
+		s := state
+		s.You = c.id
+		c.send(s)
+	}
+}
+
+// giveControlLocked makes to the holder of f, or frees it for nil;
+// floorMux must be held. It reports the event to emit, if any.
+func (f *controlFloor) giveControlLocked(stream string, to *controller, by string) (string, map[string]any) {
+	if f.holder == to {
+		return "", nil
+	}
+	prev := f.holder
+	f.holder = to
+	if to == nil {
+		data := map[string]any{"session": stream, "by": by}
+		if prev != nil {
+			data["user"], data["name"] = prev.user, prev.name
+		}
+		return eventControlRevoked, data
+	}
+	f.requests = slices.DeleteFunc(f.requests, func(c *controller) bool { return c == to })
+	return eventControlGranted, map[string]any{"session": stream, "user": to.user, "name": to.name, "by": by}
+}
+
+// controlMayTake reports whether a viewer of role may hold control: control
+// and admin logins, or anyone when auth is off.
+func controlMayTake(role string) bool {
+	return role == "" || roleRank[role] >= roleRank["control"]
+}
+
+// controlRequest is a text message on the control channel: an action on
+// control, or input for the desktop from its holder.
+type controlRequest struct {
+	// Action is "request", "release", "grant" or "deny"; the last two
+	// name the controller in To.
+	Action string        `json:"action,omitempty"`
+	To     string        `json:"to,omitempty"`
+	Input  *controlInput `json:"input,omitempty"`
+	// Signed and Sig are all a controller that connected with a paired
+	// key sends: Signed is a signedControl, Sig its Ed25519 signature.
+	Signed []byte `json:"signed,omitempty"`
+	Sig    []byte `json:"sig,omitempty"`
+}
+
+// signedControl is a request as a controller with a paired key signs it.
+type signedControl struct {
+	Nonce string `json:"nonce"`
+	Seq   uint64 `json:"seq"`
+	controlRequest
+}
+
+// controlInput is pointer or keyboard input. X and Y are fractions of
+// the frame's width and height, so they don't depend on the quality tier
+// or degradation the viewer watches at.
+type controlInput struct {
+	Type   string   `json:"type"` // move, down, up, click, key or type
+	X      float64  `json:"x"`
+	Y      float64  `json:"y"`
+	Button int      `json:"button,omitempty"`
+	Keys   []string `json:"keys,omitempty"`
+	Text   string   `json:"text,omitempty"`
+}
+
+// handleControl connects a viewer to the control channel of a stream. It
+// gets the control state on connecting and on every change, and sends
+// controlRequest messages.
+func handleControl(w http.ResponseWriter, r *http.Request) {
+	stream := r.PathValue("session")
+	if !streamExists(stream) {
+		i18n.Error(w, r, http.StatusNotFound, "no_such_session")
+		return
+	}
+	a := requestAuth(r)
+	if !controlMayTake(a.Role) {
+		i18n.Error(w, r, http.StatusForbidden, "forbidden")
+		return
+	}
+	conn, zstd, err := upgradeAux(w, r)
+	if err != nil {
+		log.Printf("WebSocket upgrade error: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	c := &controller{
+		id:     strconv.FormatUint(nextControllerID.Add(1), 10),
+		stream: stream,
+		user:   a.User,
+		name:   viewerName(r),
+		admin:  a.Role == "" || a.Role == "admin",
+		conn:   conn,
+		zstd:   zstd,
+		target: inputTarget{stream: stream},
+	}
+	if a.Key != "" {
+		k, ok := clientKeys.get(a.Key)
+		if !ok {
+			return
+		}
+		c.keyID, c.key, c.nonce = k.ID, k.PublicKey, newShareSecret()
+		if a.User == "" {
+			c.name = k.Name
+		}
+		c.send(map[string]string{"type": "key", "nonce": c.nonce})
+	}
+	floorMux.Lock()
+	f := floors[stream]
+	if f == nil {
+		f = &controlFloor{members: make(map[*controller]bool)}
+		floors[stream] = f
+	}
+	f.members[c] = true
+	floorMux.Unlock()
+	defer leaveControl(c)
+	broadcastControl(stream)
+
+	gone := make(chan struct{})
+	defer close(gone)
+	keepAlive(conn, gone)
+	for {
+		data, err := zst.ReadMessage(conn, zstd)
+		if err != nil {
+			return
+		}
+		var env controlRequest
+		if err := json.Unmarshal(data, &env); err != nil {
+			log.Printf("Ignoring malformed control request from %s: %v", c.name, err)
+			continue
+		}
+		req, err := c.open(&env)
+		if err != nil {
+			log.Printf("Ignoring control request from %s: %v", c.name, err)
+			c.send(map[string]string{"type": "error", "error": err.Error()})
+			continue
+		}
+		if req.Input != nil {
+			if err := c.input(req.Input); err != nil {
+				c.send(map[string]string{"type": "error", "error": err.Error()})
+				continue
+			}
+			inputAudit.record(auditEntry{
+				Time:      time.Now(),
+				Stream:    c.stream,
+				User:      c.user,
+				Name:      c.name,
+				Key:       c.keyID,
+				PublicKey: c.key,
+				Input:     req.Input,
+				Signed:    env.Signed,
+				Sig:       env.Sig,
+			})
+			continue
+		}
+		if err := c.act(req.Action, req.To); err != nil {
+			c.send(map[string]string{"type": "error", "error": err.Error()})
+		}
+	}
+}
+
+// open returns the request in env, checking the signature of controllers
+// with a paired key, which send nothing unsigned.
+func (c *controller) open(env *controlRequest) (*controlRequest, error) {
+	if c.key == nil {
+		return env, nil
+	}
+	if env.Signed == nil {
+		return nil, fmt.Errorf("requests must be signed with key %s", c.keyID)
+	}
+	if !ed25519.Verify(c.key, env.Signed, env.Sig) {
+		return nil, fmt.Errorf("bad signature for key %s", c.keyID)
+	}
+	var sc signedControl
+	if err := json.Unmarshal(env.Signed, &sc); err != nil {
+		return nil, fmt.Errorf("malformed signed request: %w", err)
+	}
+	if sc.Nonce != c.nonce {
+		return nil, fmt.Errorf("signed request is for another connection")
+	}
+	if sc.Seq <= c.seq {
+		return nil, fmt.Errorf("sequence number %d was already used", sc.Seq)
+	}
+	c.seq = sc.Seq
+	return &sc.controlRequest, nil
+}
+
+// act applies one of c's control actions.
+func (c *controller) act(action, to string) error {
+	floorMux.Lock()
+	f := floors[c.stream]
+	var typ string
+	var data map[string]any
+	switch action {
+	case "request":
+		if f.holder == nil {
+			typ, data = f.giveControlLocked(c.stream, c, c.name)
+		} else if f.holder != c && !slices.Contains(f.requests, c) {
+			f.requests = append(f.requests, c)
+		}
+	case "release":
+		if f.holder == c {
+			typ, data = f.giveControlLocked(c.stream, f.nextLocked(), c.name)
+		} else {
+			f.requests = slices.DeleteFunc(f.requests, func(r *controller) bool { return r == c })
+		}
+	case "grant", "deny":
+		if f.holder != c && !c.admin {
+			floorMux.Unlock()
+			return fmt.Errorf("only the holder of control or an admin can %s it", action)
+		}
+		var target *controller
+		for m := range f.members {
+			if m.id == to {
+				target = m
+			}
+		}
+		if target == nil {
+			floorMux.Unlock()
+			return fmt.Errorf("no controller with id %q", to)
+		}
+		if action == "grant" {
+			typ, data = f.giveControlLocked(c.stream, target, c.name)
+		} else {
+			f.requests = slices.DeleteFunc(f.requests, func(r *controller) bool { return r == target })
+		}
+	default:
+		floorMux.Unlock()
+		return fmt.Errorf("unknown control action %q", action)
+	}
+	floorMux.Unlock()
+	if typ != "" {
+		emit(typ, data)
+	}
+	broadcastControl(c.stream)
+	return nil
+}
+
+// nextLocked is who control passes to when its holder lets go: the
+// longest waiting request, if any. floorMux must be held.
+func (f *controlFloor) nextLocked() *controller {
+	if len(f.requests) == 0 {
+		return nil
+	}
+	return f.requests[0]
+}
+
+// leaveControl drops a disconnected controller, passing on control it held.
+func leaveControl(c *controller) {
+	floorMux.Lock()
+	f := floors[c.stream]
+	delete(f.members, c)
+	f.requests = slices.DeleteFunc(f.requests, func(r *controller) bool { return r == c })
+	var typ string
+	var data map[string]any
+	if f.holder == c {
+		typ, data = f.giveControlLocked(c.stream, f.nextLocked(), "")
+	}
+	if len(f.members) == 0 {
+		delete(floors, c.stream)
+	}
+	floorMux.Unlock()
+	if typ != "" {
+		emit(typ, data)
+	}
+	broadcastControl(c.stream)
+}
+
+// controlHolder returns who holds control of stream, nil when nobody
+// does, for input that comes from outside the floor.
+func controlHolder(stream string) *controllerInfo {
+	floorMux.Lock()
+	defer floorMux.Unlock()
+	f := floors[stream]
+	if f == nil || f.holder == nil {
+		return nil
+	}
+	info := f.holder.info()
+	return &info
+}
+
+// holds reports whether c holds control of its stream.
+func (c *controller) holds() bool {
+	floorMux.Lock()
+	defer floorMux.Unlock()
+	f := floors[c.stream]
+	return f != nil && f.holder == c
+}
+
+// input replays the holder's input on the stream's display.
+func (c *controller) input(in *controlInput) error {
+	if !c.holds() {
+		return fmt.Errorf("you do not hold control")
+	}
+	return c.target.replay(in)
+}
+
+func (t *inputTarget) replay(in *controlInput) error {
+	d, err := controlDriver(t.stream)
+	if err != nil {
+		return err
+	}
+	button := max(in.Button, 1)
+	switch in.Type {
+	case "move", "down", "up", "click":
+		if time.Since(t.sizedAt) > controlSizeTTL {
+			if t.w, t.h, err = d.DisplaySize(); err != nil {
+				return err
+			}
+			t.sizedAt = time.Now()
+		}
+		x := int(min(max(in.X, 0), 1) * float64(t.w-1))
+		y := int(min(max(in.Y, 0), 1) * float64(t.h-1))
+		if err := d.Move(x, y); err != nil {
+			return err
+		}
+		switch in.Type {
+		case "down":
+			return d.Down(button)
+		case "up":
+			return d.Up(button)
+		case "click":
+			return d.Click(button, 1)
+		}
+		return nil
+	case "key":
+		if len(in.Keys) == 0 {
+			return fmt.Errorf("keys are required")
+		}
+		return d.Key(in.Keys...)
+	case "type":
+		return d.Type(in.Text, 0)
+	}
+	return fmt.Errorf("unknown input type %q", in.Type)
+}
+
+// controlDriver injects input into the display of stream.
+func controlDriver(stream string) (*automation.Driver, error) {
+	if stream == "" {
+		if screenLocked.Load() {
+			return nil, errScreenLocked
+		}
+		return automation.New(services.encoder.Settings().Display), nil
+	}
+	info, ok := sessions.Get(stream)
+	if !ok {
+		return nil, fmt.Errorf("no session with id %q", stream)
+	}
+	if info.Backend != "x11" {
+		return nil, fmt.Errorf("input needs an x11 session, %s is %s", stream, info.Backend)
+	}
+	return automation.New(info.Display), nil
+}
+
+func handleGetControl(w http.ResponseWriter, r *http.Request) {
+	stream := r.URL.Query().Get("session")
+	if !streamExists(stream) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no streaming session with id %q", stream))
+		return
+	}
+	writeJSON(w, http.StatusOK, controlStateOf(stream))
+}
+
+// handleGrantControl hands control of a stream to one of its controllers.
+func handleGrantControl(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Session    string `json:"session"`
+		Controller string `json:"controller"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	floorMux.Lock()
+	var target *controller
+	if f := floors[req.Session]; f != nil {
+		for m := range f.members {
+			if m.id == req.Controller {
+				target = m
+			}
+		}
+	}
+	if target == nil {
+		floorMux.Unlock()
+		writeError(w, http.StatusNotFound, fmt.Errorf("no controller with id %q", req.Controller))
+		return
+	}
+	typ, data := floors[req.Session].giveControlLocked(req.Session, target, requestAuth(r).User)
+	floorMux.Unlock()
+	if typ != "" {
+		log.Printf("API: granted control of stream %q to %s", req.Session, target.name)
+		emit(typ, data)
+	}
+	broadcastControl(req.Session)
+	writeJSON(w, http.StatusOK, controlStateOf(req.Session))
+}
+
+// handleRevokeControl takes control of a stream from its holder; it stays
+// free until someone asks for it again.
+func handleRevokeControl(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Session string `json:"session"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+			return
+		}
+	}
+	floorMux.Lock()
+	var typ string
+	var data map[string]any
+	if f := floors[req.Session]; f != nil {
+		typ, data = f.giveControlLocked(req.Session, nil, requestAuth(r).User)
+	}
+	floorMux.Unlock()
+	if typ != "" {
+		log.Printf("API: revoked control of stream %q", req.Session)
+		emit(typ, data)
+	}
+	broadcastControl(req.Session)
+	writeJSON(w, http.StatusOK, controlStateOf(req.Session))
+}
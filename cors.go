@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/nathfavour/remoter/i18n"
+)
+
+// originPolicy decides which browser origins may open WebSockets and call
+// the API cross-origin. Same-origin requests are always allowed; others
+// must match an exact origin ("https://ops.example.com") or a wildcard
+// ("https://*.example.com"), unless allowAll is set for development.
+type originPolicy struct {
+	allowAll bool
+	exact    map[string]bool
+	suffixes []string // "https://" + ".example.com" for https://*.example.com
+}
+
+func newOriginPolicy(allowed []string, allowAll bool) (*originPolicy, error) {
+	p := &originPolicy{allowAll: allowAll, exact: make(map[string]bool)}
+	for _, o := range allowed {
+		o = strings.TrimSuffix(strings.ToLower(o), "/")
+		if o == "*" {
+			return nil, fmt.Errorf("allowed_origins cannot contain \"*\"; set dev_allow_all_origins instead")
+		}
+		u, err := url.Parse(o)
+		if err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" {
+			return nil, fmt.Errorf("invalid allowed origin %q, want scheme://host[:port]", o)
+		}
+		if rest, ok := strings.CutPrefix(u.Host, "*."); ok {
+			p.suffixes = append(p.suffixes, u.Scheme+"://."+rest)
+			continue
+		}
+		p.exact[o] = true
+	}
+	if allowAll {
+		log.Printf("Warning: dev_allow_all_origins is set; any website can connect to this server")
+	}
+	return p, nil
+}
+
+// matches reports whether origin is on the allow list.
+func (p *originPolicy) matches(origin string) bool {
+	if p.allowAll {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	if p.exact[origin] {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	for _, s := range p.suffixes {
+		scheme, suffix, _ := strings.Cut(s, "://")
+		if u.Scheme == scheme && strings.HasSuffix(u.Host, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameOrigin compares the Origin header with the host the browser
+// addressed. Behind the relay that is X-Forwarded-Host, which is only
+// trusted on relayed requests.
+func sameOrigin(r *http.Request, origin string) bool {
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	host := r.Host
+	if fwd := r.Header.Get("X-Forwarded-Host"); fwd != "" {
+		if relayed(r) {
+			host = fwd
+		}
+	}
+	return strings.EqualFold(u.Host, host)
+}
+
+// checkOrigin is the WebSocket upgrader's CheckOrigin.
+func (p *originPolicy) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || sameOrigin(r, origin) || p.matches(origin) {
+		return true
+	}
+	log.Printf("Rejected WebSocket from origin %s (%s)", origin, r.RemoteAddr)
+	return false
+}
+
+// wrap answers CORS preflights and adds CORS headers to API responses for
+// allowed origins; cross-origin API requests from anywhere else fail.
+func (p *originPolicy) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || !strings.HasPrefix(r.URL.Path, "/api/") || sameOrigin(r, origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !p.matches(origin) {
+			// Refuse outright rather than only withholding CORS headers, so
+			// simple requests cannot act on the API through cached logins.
+			log.Printf("Rejected API request from origin %s (%s)", origin, r.RemoteAddr)
+			i18n.Error(w, r, http.StatusForbidden, "forbidden")
+			return
+		}
+
+		h := w.Header()
+		h.Set("Access-Control-Allow-Origin", origin)
+		h.Set("Access-Control-Allow-Credentials", "true")
+		h.Add("Vary", "Origin")
+		if r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != "" {
+			h.Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE")
+			h.Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+			h.Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,143 @@
+package cursor
+
+import (
+	"bufio"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+)
+
+// helper follows the pointer through python-xlib, using XFixes to learn
+// when the cursor image changes.
+//
+//go:embed cursor.py
+var helper string
+
+// Event is a pointer move or a new cursor image.
+type Event struct {
+	Type string `json:"type"` // pos or shape
+	X    int    `json:"x,omitempty"`
+	Y    int    `json:"y,omitempty"`
+
+	// Shape events carry the image as base64 RGBA, straight alpha.
+	W      int    `json:"w,omitempty"`
+	H      int    `json:"h,omitempty"`
+	XHot   int    `json:"xhot,omitempty"`
+	YHot   int    `json:"yhot,omitempty"`
+	Serial uint32 `json:"serial,omitempty"`
+	RGBA   string `json:"rgba,omitempty"`
+}
+
+// Monitor runs the helper while anyone is subscribed and fans its events
+// out to the subscribers.
+type Monitor struct {
+	mu      sync.Mutex
+	display string
+	rate    int
+	cmd     *exec.Cmd
+	subs    map[chan Event]struct{}
+	shape   *Event // latest shape, replayed to new subscribers
+}
+
+// NewMonitor follows the cursor of display, sampling the pointer rate
+// times per second.
+func NewMonitor(display string, rate int) *Monitor {
+	return &Monitor{display: display, rate: rate, subs: make(map[chan Event]struct{})}
+}
+
+// Subscribe returns a channel of events, starting the helper for the
+// first subscriber. The channel is closed when the helper exits; call the
+// returned function to unsubscribe.
+func (m *Monitor) Subscribe() (<-chan Event, func(), error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.cmd == nil {
+		if err := m.start(); err != nil {
+			return nil, nil, err
+		}
+	}
+	ch := make(chan Event, 64)
+	if m.shape != nil {
+		ch <- *m.shape
+	}
+	m.subs[ch] = struct{}{}
+	return ch, func() { m.unsubscribe(ch) }, nil
+}
+
+func (m *Monitor) unsubscribe(ch chan Event) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.subs[ch]; !ok {
+		return
+	}
+	delete(m.subs, ch)
+	close(ch)
+	if len(m.subs) == 0 && m.cmd != nil {
+		m.cmd.Process.Kill()
+		m.cmd = nil
+		m.shape = nil
+	}
+}
+
+func (m *Monitor) start() error {
+	cmd := exec.Command("python3", "-c", helper, strconv.Itoa(m.rate))
+	cmd.Env = append(os.Environ(), "DISPLAY="+m.display)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start cursor helper: %w", err)
+	}
+	m.cmd = cmd
+	fmt.Printf("Started cursor monitor on %s (pid %d)\n", m.display, cmd.Process.Pid)
+
+	go func() {
+		sc := bufio.NewScanner(out)
+		// Shape events carry whole cursor images.
+		sc.Buffer(make([]byte, 64*1024), 4*1024*1024)
+		for sc.Scan() {
+			var ev Event
+			if json.Unmarshal(sc.Bytes(), &ev) != nil {
+				continue
+			}
+			m.publish(ev)
+		}
+		err := cmd.Wait()
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if m.cmd != cmd {
+			return
+		}
+		fmt.Printf("Cursor monitor exited: %v\n", err)
+		m.cmd = nil
+		m.shape = nil
+		for ch := range m.subs {
+			close(ch)
+			delete(m.subs, ch)
+		}
+	}()
+	return nil
+}
+
+// publish delivers ev to every subscriber, dropping it for those that are
+// not keeping up.
+func (m *Monitor) publish(ev Event) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if ev.Type == "shape" {
+		m.shape = &ev
+	}
+	for ch := range m.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
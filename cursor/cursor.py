@@ -0,0 +1,58 @@
+# Prints the X pointer position and cursor image as JSON lines for remoter.
+import base64
+import json
+import sys
+import time
+
+from Xlib import X, display
+from Xlib.ext import xfixes
+
+RATE = float(sys.argv[1]) if len(sys.argv) > 1 else 60.0
+
+def emit(obj):
+    sys.stdout.write(json.dumps(obj) + "\n")
+    sys.stdout.flush()
+
+def shape(d, root):
+    img = d.xfixes_get_cursor_image(root)
+    pixels = bytearray()
+    for argb in img.cursor_image:
+        a = (argb >> 24) & 0xFF
+        r, g, b = (argb >> 16) & 0xFF, (argb >> 8) & 0xFF, argb & 0xFF
+        if a:
+            # XFixes images are premultiplied; canvas ImageData is not.
+            r, g, b = r * 255 // a, g * 255 // a, b * 255 // a
+        pixels += bytes((r, g, b, a))
+    emit({
+        "type": "shape",
+        "w": img.width,
+        "h": img.height,
+        "xhot": img.xhot,
+        "yhot": img.yhot,
+        "serial": img.cursor_serial,
+        "rgba": base64.b64encode(bytes(pixels)).decode(),
+    })
+
+def main():
+    d = display.Display()
+    root = d.screen().root
+    d.xfixes_query_version()
+    d.xfixes_select_cursor_input(root, xfixes.XFixesDisplayCursorNotifyMask)
+    shape(d, root)
+
+    last = None
+    while True:
+        changed = False
+        while d.pending_events():
+            d.next_event()
+            changed = True
+        if changed:
+            shape(d, root)
+        p = root.query_pointer()
+        pos = (p.root_x, p.root_y)
+        if pos != last:
+            last = pos
+            emit({"type": "pos", "x": pos[0], "y": pos[1]})
+        time.sleep(1.0 / RATE)
+
+main()
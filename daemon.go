@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// daemonFlag is --daemon: start the server in the background.
+var daemonFlag bool
+
+// daemonStartTimeout bounds how long --daemon waits for the server to
+// come up; building the web app on a first start takes a while.
+const daemonStartTimeout = 2 * time.Minute
+
+// stopTimeout is how long stop waits for the server to shut down.
+const stopTimeout = 30 * time.Second
+
+// controlSocket serves the control API to local users without a login;
+// the socket is only reachable by the user running remoter.
+var controlSocket net.Listener
+
+// relaySocket serves the relay client's tunnels, so requests over it are
+// known to be relayed viewers rather than local ones.
+var relaySocket net.Listener
+
+// controlSocketPath is the control socket of the instance serving port.
+func controlSocketPath(port int) string {
+	return filepath.Join(instanceDir(), fmt.Sprintf("port-%d.sock", port))
+}
+
+// relaySocketPath is the relay socket of the instance serving port.
+func relaySocketPath(port int) string {
+	return filepath.Join(instanceDir(), fmt.Sprintf("port-%d-relay.sock", port))
+}
+
+// logPath is where a daemon serving port writes its log.
+func logPath(port int) string {
+	return filepath.Join(instanceDir(), fmt.Sprintf("port-%d.log", port))
+}
+
+// serveControlSocket listens on the control socket. The instance lock is
+// held, so a socket left there is a crashed instance's.
+func serveControlSocket(port int, next http.Handler) error {
+	path := controlSocketPath(port)
+	os.Remove(path)
+	// The instance directory is ours alone, so nobody else can connect
+	// in the moment before the chmod.
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("failed to open control socket: %w", err)
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		ln.Close()
+		return fmt.Errorf("failed to open control socket: %w", err)
+	}
+	controlSocket = ln
+	local := "local"
+	if u, err := user.Current(); err == nil {
+		local = u.Username
+	}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, withAuth(r, authInfo{User: local, Role: "admin"}))
+	})
+	go func() {
+		if err := http.Serve(ln, traceRequests(handler)); err != nil && !errors.Is(err, net.ErrClosed) {
+			log.Printf("Control socket error: %v", err)
+		}
+	}()
+	return nil
+}
+
+// serveRelaySocket serves handler to the relay client, marking each
+// request as relayed. It lives in the instance directory, so no other
+// user can pass for the relay.
+func serveRelaySocket(port int, handler http.Handler) error {
+	path := relaySocketPath(port)
+	os.Remove(path)
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("failed to open relay socket: %w", err)
+	}
+	relaySocket = ln
+	srv := &http.Server{
+		Handler: handler,
+		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+			return context.WithValue(ctx, relayedKey{}, true)
+		},
+	}
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, net.ErrClosed) {
+			log.Printf("Relay socket error: %v", err)
+		}
+	}()
+	return nil
+}
+
+// closeLocalSockets stops serving the control and relay sockets and
+// removes them.
+func closeLocalSockets() {
+	for _, ln := range []net.Listener{controlSocket, relaySocket} {
+		if ln != nil {
+			ln.Close()
+		}
+	}
+}
+
+// daemonize starts the server again as a background process detached
+// from the terminal, logging to logPath, and returns once it is serving
+// or has failed to start.
+func daemonize(port int) error {
+	if pid, err := runningInstance(port); err == nil {
+		return fmt.Errorf("remoter is already running (pid %d) on port %d", pid, port)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	var args []string
+	if configFlag != "" {
+		path, err := filepath.Abs(configFlag)
+		if err != nil {
+			return err
+		}
+		args = append(args, "--config", path)
+	}
+	if err := makeInstanceDir(); err != nil {
+		return err
+	}
+	logFile, err := os.OpenFile(logPath(port), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open log: %w", err)
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(exe, args...)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start remoter: %w", err)
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	deadline := time.After(daemonStartTimeout)
+	for {
+		select {
+		case err := <-exited:
+			return fmt.Errorf("remoter exited during startup (%v); see %s", err, logPath(port))
+		case <-deadline:
+			fmt.Printf("remoter (pid %d) is still starting; its log is %s\n", cmd.Process.Pid, logPath(port))
+			return nil
+		case <-time.After(100 * time.Millisecond):
+		}
+		if socketExists(controlSocketPath(port)) {
+			fmt.Printf("remoter started (pid %d) on port %d; its log is %s\n", cmd.Process.Pid, port, logPath(port))
+			return nil
+		}
+	}
+}
+
+// stopInstance asks the instance serving port to shut down and waits for
+// it to go.
+func stopInstance(port int) error {
+	pid, err := runningInstance(port)
+	if err != nil {
+		return err
+	}
+	if err := syscall.Kill(pid, syscall.SIGTERM); err != nil {
+		return fmt.Errorf("failed to stop remoter (pid %d): %w", pid, err)
+	}
+	deadline := time.Now().Add(stopTimeout)
+	for time.Now().Before(deadline) {
+		if _, err := runningInstance(port); err != nil {
+			fmt.Printf("Stopped remoter (pid %d)\n", pid)
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return fmt.Errorf("remoter (pid %d) did not stop within %s", pid, stopTimeout)
+}
+
+// runStatusCommand reports whether an instance is serving the configured
+// port and, if so, what it is running.
+func runStatusCommand() error {
+	cfg, err := loadOrCreateConfig()
+	if err != nil {
+		return err
+	}
+	pid, err := runningInstance(cfg.Port)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("remoter is running (pid %d) on port %d\n", pid, cfg.Port)
+	var st pipelineState
+	if err := apiRequest("GET", "/api/v1/pipeline", nil, &st); err != nil {
+		return err
+	}
+	fmt.Printf("%d viewer(s)\n\n", st.Clients)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SERVICE\tSTATE\tRESTARTS\tLAST ERROR")
+	for _, s := range st.Services {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\n", s.Name, s.State, s.Restarts, s.LastError)
+	}
+	return tw.Flush()
+}
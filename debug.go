@@ -0,0 +1,52 @@
+package main
+
+import (
+	"expvar"
+	"net/http"
+	_ "net/http/pprof" // registers /debug/pprof/ on the default mux
+	"strings"
+
+	"github.com/nathfavour/remoter/i18n"
+)
+
+// debugEndpoints is set from the config: whether /debug/ answers at all.
+// The pprof and expvar packages register there whether or not it does.
+var debugEndpoints bool
+
+func enableDebug() {
+	debugEndpoints = true
+	expvar.Publish("remoter", expvar.Func(func() any {
+		return map[string]any{
+			"clients":         clientCount(),
+			"viewers":         viewerCount(),
+			"transports":      transportCounts(),
+			"checksum_errors": checksumErrors.Load(),
+			"ready":           streamReady(""),
+			"encoder":         services.encoder.Status(),
+		}
+	}))
+}
+
+// guardDebug hides /debug/ unless it is enabled, and then keeps it to
+// admins: profiles show what the host is doing and can be slow to take.
+func guardDebug(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/debug/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !debugEndpoints {
+			http.NotFound(w, r)
+			return
+		}
+		if a := requestAuth(r); a.Role == "" {
+			if ip := remoteIP(r); ip == nil || !ip.IsLoopback() {
+				i18n.Error(w, r, http.StatusForbidden, "forbidden")
+				return
+			}
+		} else if !requireAdmin(w, r) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
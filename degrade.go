@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nathfavour/remoter/ffmpeg"
+)
+
+// DegradeConfig steps the main stream down a ladder of smaller and slower
+// encodes while the host can't keep up — ffmpeg encoding slower than real
+// time, or the CPU close to saturated — and back up once it has headroom
+// again. Each step restarts the encoder.
+type DegradeConfig struct {
+	Enabled  bool    `json:"enabled"`
+	Interval string  `json:"interval,omitempty"`  // default 10s
+	MinSpeed float64 `json:"min_speed,omitempty"` // default 0.95
+	MaxCPU   int     `json:"max_cpu,omitempty"`   // percent busy, default 90
+	// Steps are the rungs below full quality, mildest first; by default
+	// fewer frames, then 75% and 50% of the size.
+	Steps []DegradeStep `json:"steps,omitempty"`
+}
+
+// DegradeStep is one rung of the ladder, in percent of the configured size
+// and framerate.
+type DegradeStep struct {
+	Size      int `json:"size"`
+	Framerate int `json:"framerate"`
+}
+
+var defaultDegradeSteps = []DegradeStep{{100, 66}, {75, 66}, {50, 50}}
+
+const (
+	// degradeRecoverTicks is how many healthy intervals pass before
+	// stepping back up, so the ladder doesn't oscillate.
+	degradeRecoverTicks = 3
+	// degradeHeadroom is how far below MaxCPU, in percent, the CPU must be
+	// to step back up.
+	degradeHeadroom = 20
+)
+
+// degradeState is the ladder's position, as shown in /api/v1/stats.
+type degradeState struct {
+	Level  int         `json:"level"` // 0 is full quality
+	Steps  int         `json:"steps"`
+	Step   DegradeStep `json:"step"`
+	Speed  float64     `json:"speed,omitempty"`
+	CPU    int         `json:"cpu"` // -1 when unknown
+	Reason string      `json:"reason,omitempty"`
+	Since  time.Time   `json:"since,omitempty"`
+}
+
+var (
+	// degradeSteps is set in main when degradation is enabled.
+	degradeSteps []DegradeStep
+	degradeLevel int
+	degradeStat  degradeState
+	degradeMux   sync.Mutex
+)
+
+// degradeStatus returns the ladder's state, or nil when it is disabled.
+func degradeStatus() *degradeState {
+	degradeMux.Lock()
+	defer degradeMux.Unlock()
+	if degradeSteps == nil {
+		return nil
+	}
+	st := degradeStat
+	st.Level, st.Steps = degradeLevel, len(degradeSteps)
+	st.Step = DegradeStep{100, 100}
+	if degradeLevel > 0 {
+		st.Step = degradeSteps[degradeLevel-1]
+	}
+	return &st
+}
+
+// degradeFramerate scales fps to the current step.
+func degradeFramerate(fps int) int {
+	degradeMux.Lock()
+	defer degradeMux.Unlock()
+	if degradeLevel == 0 {
+		return fps
+	}
+	return max(1, fps*degradeSteps[degradeLevel-1].Framerate/100)
+}
+
+// degradeSettings applies the current step to the main encoder's settings.
+func degradeSettings(s *ffmpeg.Settings) {
+	s.Framerate = degradeFramerate(s.Framerate)
+	degradeMux.Lock()
+	size := 100
+	if degradeLevel > 0 {
+		size = degradeSteps[degradeLevel-1].Size
+	}
+	degradeMux.Unlock()
+	if size >= 100 {
+		return
+	}
+	w, h := fullSize(s)
+	if w == 0 {
+		return
+	}
+	s.Scale = fmt.Sprintf("%dx%d", max(2, w*size/100&^1), max(2, h*size/100&^1))
+}
+
+// fullSize is the size the main stream is encoded at undegraded, or zero
+// before the display was first probed.
+func fullSize(s *ffmpeg.Settings) (int, int) {
+	if w, h, err := ffmpeg.ParseSize(s.Scale); err == nil {
+		return w, h
+	}
+	if c := s.Capture; c != nil {
+		return c.W, c.H
+	}
+	var w, h int
+	if _, err := fmt.Sscanf(services.encoder.Status().Res, "%dx%d", &w, &h); err != nil {
+		return 0, 0
+	}
+	return w, h
+}
+
+// cpuSampler measures how busy the host's CPUs were between samples.
+type cpuSampler struct {
+	idle, total uint64
+}
+
+// sample returns the busy percentage since the previous sample, or -1
+// when /proc/stat is unavailable or this is the first sample.
+func (c *cpuSampler) sample() int {
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return -1
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	if !sc.Scan() {
+		return -1
+	}
+	fields := strings.Fields(sc.Text())
+	if len(fields) < 5 || fields[0] != "cpu" {
+		return -1
+	}
+	var idle, total uint64
+	for i, v := range fields[1:] {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return -1
+		}
+		total += n
+		// idle and iowait
+		if i == 3 || i == 4 {
+			idle += n
+		}
+	}
+	prevIdle, prevTotal := c.idle, c.total
+	c.idle, c.total = idle, total
+	if prevTotal == 0 || total <= prevTotal {
+		return -1
+	}
+	return int(100 - (idle-prevIdle)*100/(total-prevTotal))
+}
+
+// degradeUnderLoad runs the degradation ladder until the process exits.
+func degradeUnderLoad(cfg *DegradeConfig) error {
+	interval, err := time.ParseDuration(cmp.Or(cfg.Interval, "10s"))
+	if err != nil {
+		return fmt.Errorf("invalid degrade interval: %w", err)
+	}
+	minSpeed := cmp.Or(cfg.MinSpeed, 0.95)
+	maxCPU := cmp.Or(cfg.MaxCPU, 90)
+	if maxCPU <= degradeHeadroom || maxCPU > 100 {
+		return fmt.Errorf("degrade max_cpu must be between %d and 100", degradeHeadroom+1)
+	}
+	steps := cfg.Steps
+	if len(steps) == 0 {
+		steps = defaultDegradeSteps
+	}
+	for _, s := range steps {
+		if s.Size < 1 || s.Size > 100 || s.Framerate < 1 || s.Framerate > 100 {
+			return fmt.Errorf("degrade steps must be between 1 and 100 percent")
+		}
+	}
+	degradeMux.Lock()
+	degradeSteps = steps
+	degradeMux.Unlock()
+
+	go func() {
+		var cpu cpuSampler
+		healthy := 0
+		for range time.Tick(interval) {
+			busy := cpu.sample()
+			st := services.encoder.Status()
+			degradeMux.Lock()
+			degradeStat.CPU, degradeStat.Speed = busy, st.Speed
+			level := degradeLevel
+			degradeMux.Unlock()
+			// A fresh encoder's speed hasn't settled yet.
+			if !st.Running || streamPaused() || time.Since(st.StartedAt) < interval {
+				continue
+			}
+
+			var reasons []string
+			if st.Speed > 0 && st.Speed < minSpeed {
+				reasons = append(reasons, fmt.Sprintf("encoding at %.2fx", st.Speed))
+			}
+			if busy >= maxCPU {
+				reasons = append(reasons, fmt.Sprintf("CPU %d%% busy", busy))
+			}
+			next := level
+			switch {
+			case len(reasons) > 0:
+				healthy = 0
+				next = min(level+1, len(steps))
+			case level > 0 && busy < maxCPU-degradeHeadroom:
+				if healthy++; healthy >= degradeRecoverTicks {
+					healthy = 0
+					next = level - 1
+					reasons = append(reasons, "headroom returned")
+				}
+			default:
+				healthy = 0
+			}
+			if next != level {
+				setDegradeLevel(next, strings.Join(reasons, ", "))
+			}
+		}
+	}()
+	return nil
+}
+
+// setDegradeLevel moves the ladder to level and restarts the encoder on
+// that step, keeping its running bitrate.
+func setDegradeLevel(level int, reason string) {
+	degradeMux.Lock()
+	degradeLevel = level
+	degradeStat.Reason, degradeStat.Since = reason, time.Now()
+	degradeMux.Unlock()
+
+	bitrate := services.encoder.Settings().Bitrate
+	services.mu.Lock()
+	settings := encoderSettings(services.cfg)
+	services.mu.Unlock()
+	settings.Bitrate = bitrate
+
+	st := degradeStatus()
+	if level == 0 {
+		log.Printf("Degradation: %s, back to full quality", reason)
+		emit(eventStreamRestored, st)
+	} else {
+		log.Printf("Degradation: %s, step %d/%d (%d%% size, %d%% framerate)",
+			reason, level, st.Steps, st.Step.Size, st.Step.Framerate)
+		emit(eventStreamDegraded, st)
+	}
+	if err := services.encoder.Update(settings); err != nil {
+		log.Printf("Degradation: failed to restart encoder: %v", err)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/nathfavour/remoter/ffmpeg"
+	"github.com/nathfavour/remoter/i18n"
+	"github.com/nathfavour/remoter/xshm"
+)
+
+// deltaTileSize is the edge of the tiles /delta sends, in pixels.
+const deltaTileSize = 64
+
+var (
+	// deltaStreams track the damage of each X display area with viewers on
+	// /delta.
+	deltaStreams    = make(map[string]*xshm.DeltaStream)
+	deltaStreamsMux sync.Mutex
+)
+
+func deltaStreamFor(display string, area *ffmpeg.Region) *xshm.DeltaStream {
+	var rect image.Rectangle
+	if area != nil {
+		rect = image.Rect(area.X, area.Y, area.X+area.W, area.Y+area.H)
+	}
+	key := display + " " + rect.String()
+	deltaStreamsMux.Lock()
+	defer deltaStreamsMux.Unlock()
+	s, ok := deltaStreams[key]
+	if !ok {
+		rate := rawCapture.DeltaRate
+		if rate <= 0 {
+			rate = 10
+		}
+		s = xshm.NewDeltaStream(display, rect, rate, deltaTileSize)
+		deltaStreams[key] = s
+	}
+	return s
+}
+
+// encodeTiles packs a batch into one binary message: for each tile its
+// x, y, width and height as big-endian uint16s, the length of its data as
+// a uint32, then the data in the client's encoding.
+func encodeTiles(tiles []*xshm.Tile, encoding string) ([]byte, error) {
+	var msg []byte
+	for _, t := range tiles {
+		data, err := t.Encoded(encoding)
+		if err != nil {
+			return nil, err
+		}
+		msg = binary.BigEndian.AppendUint16(msg, uint16(t.X))
+		msg = binary.BigEndian.AppendUint16(msg, uint16(t.Y))
+		msg = binary.BigEndian.AppendUint16(msg, uint16(t.W))
+		msg = binary.BigEndian.AppendUint16(msg, uint16(t.H))
+		msg = binary.BigEndian.AppendUint32(msg, uint32(len(data)))
+		msg = append(msg, data...)
+	}
+	return msg, nil
+}
+
+// handleDelta sends only the parts of the screen that changed, as tiles
+// over a WebSocket of its own: a {"type": "init", "w", "h", "encoding"}
+// text message, then binary batches of tiles (see encodeTiles), the first
+// of which covers the whole area. Mostly static desktops cost next to no
+// bandwidth this way. Viewers pick the tiles' encoding with ?encoding=
+// png (the default), raw or lz4. The main stream's tiles are held back
+// while it is paused.
+func handleDelta(w http.ResponseWriter, r *http.Request) {
+	stream := r.PathValue("session")
+	if !streamExists(stream) {
+		i18n.Error(w, r, http.StatusNotFound, "no_such_session")
+		return
+	}
+	display, area, ok := cursorSource(stream)
+	if !ok || display == "" {
+		i18n.Error(w, r, http.StatusNotFound, "raw_unavailable")
+		return
+	}
+	encoding := r.URL.Query().Get("encoding")
+	if encoding == "" {
+		encoding = xshm.EncodingPNG
+	}
+	if !xshm.ValidEncoding(encoding) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unknown tile encoding %q", encoding))
+		return
+	}
+	if err := quotas.admitStream(requestAuth(r).User); err != nil {
+		i18n.Error(w, r, http.StatusForbidden, "quota_exceeded", err)
+		return
+	}
+
+	batches, width, height, unsubscribe, err := deltaStreamFor(display, area).Subscribe()
+	if err != nil {
+		log.Printf("Delta updates unavailable: %v", err)
+		i18n.Error(w, r, http.StatusServiceUnavailable, "raw_unavailable")
+		return
+	}
+	defer unsubscribe()
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("WebSocket upgrade error: %v", err)
+		return
+	}
+	defer conn.Close()
+	negotiateCompression(conn, r)
+	if err := conn.WriteJSON(map[string]any{"type": "init", "w": width, "h": height, "encoding": encoding}); err != nil {
+		return
+	}
+	log.Printf("New delta client %s connected to %s", r.RemoteAddr, display)
+	defer log.Printf("Delta client %s disconnected", r.RemoteAddr)
+
+	// The client never sends anything; reading detects when it leaves.
+	gone := make(chan struct{})
+	keepAlive(conn, gone)
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	// held keeps the newest tile of each place while paused, sent on
+	// resume so the viewer's picture stays whole.
+	held := make(map[image.Point]*xshm.Tile)
+	flush := time.NewTicker(time.Second)
+	defer flush.Stop()
+	for {
+		var batch []*xshm.Tile
+		select {
+		case tiles, ok := <-batches:
+			if !ok {
+				return
+			}
+			batch = tiles
+		case <-flush.C:
+		case <-gone:
+			return
+		}
+		if stream == "" && streamPaused() {
+			for _, t := range batch {
+				held[image.Point{t.X, t.Y}] = t
+			}
+			continue
+		}
+		if len(held) > 0 {
+			pending := make([]*xshm.Tile, 0, len(held)+len(batch))
+			for _, t := range held {
+				pending = append(pending, t)
+			}
+			batch = append(pending, batch...)
+			clear(held)
+		}
+		if len(batch) == 0 {
+			continue
+		}
+		msg, err := encodeTiles(batch, encoding)
+		if err != nil {
+			log.Printf("Delta client %s: %v", r.RemoteAddr, err)
+			return
+		}
+		if err := conn.WriteMessage(websocket.BinaryMessage, msg); err != nil {
+			return
+		}
+	}
+}
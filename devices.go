@@ -0,0 +1,211 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nathfavour/remoter/ffmpeg"
+)
+
+// deviceCookie identifies a returning viewer's browser.
+const deviceCookie = "remoter_device"
+
+// deviceMaxAge is how long a device is remembered after its last visit.
+const deviceMaxAge = 90 * 24 * time.Hour
+
+var (
+	deviceIDRe  = regexp.MustCompile(`^[0-9a-f]{16}$`)
+	screenRe    = regexp.MustCompile(`^(\d+)x(\d+)$`)
+	codecRe     = regexp.MustCompile(`^[a-z0-9.-]+$`)
+	resHeightRe = regexp.MustCompile(`^\d+x(\d+)`)
+)
+
+// deviceProfile is what a viewer's device supports, as its last probe
+// reported, and the tier its viewer last picked.
+type deviceProfile struct {
+	Codecs  []string  `json:"codecs,omitempty"`
+	Screen  string    `json:"screen,omitempty"` // WxH in device pixels
+	Quality string    `json:"quality,omitempty"`
+	SeenAt  time.Time `json:"seen_at"`
+}
+
+// deviceStore remembers viewer devices across visits, so returning
+// viewers start on the right tier without probing again.
+type deviceStore struct {
+	mu      sync.Mutex
+	devices map[string]*deviceProfile
+	path    string
+}
+
+var viewerDevices *deviceStore
+
+func newDeviceStore(path string) *deviceStore {
+	s := &deviceStore{devices: make(map[string]*deviceProfile), path: path}
+	if data, err := os.ReadFile(path); err == nil {
+		if err := json.Unmarshal(data, &s.devices); err != nil {
+			log.Printf("Warning: ignoring unreadable devices file %s: %v", path, err)
+		}
+	}
+	for id, p := range s.devices {
+		if time.Since(p.SeenAt) > deviceMaxAge {
+			delete(s.devices, id)
+		}
+	}
+	return s
+}
+
+// save writes the store; s.mu must be held.
+func (s *deviceStore) save() {
+	data, err := json.MarshalIndent(s.devices, "", "  ")
+	if err != nil {
+		return
+	}
+	if err := os.WriteFile(s.path, data, 0600); err != nil {
+		log.Printf("Warning: failed to save devices: %v", err)
+	}
+}
+
+// identify returns the request's device, minting one and adding its
+// cookie to header (the response's, or the WebSocket upgrade's) on a
+// first visit. A probe in the query (?codecs=a,b&screen=WxH) updates the
+// profile.
+func (s *deviceStore) identify(header http.Header, r *http.Request) string {
+	if s == nil {
+		return ""
+	}
+	id := deviceOf(r)
+	if id == "" {
+		b := make([]byte, 8)
+		_, _ = rand.Read(b)
+		id = hex.EncodeToString(b)
+		cookie := &http.Cookie{
+			Name:     deviceCookie,
+			Value:    id,
+			Path:     "/",
+			MaxAge:   int(deviceMaxAge.Seconds()),
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		}
+		header.Add("Set-Cookie", cookie.String())
+		// Clients made from r find it like any returning device's.
+		r.AddCookie(cookie)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.devices[id]
+	if !ok {
+		p = &deviceProfile{}
+		s.devices[id] = p
+		emit(eventDeviceNew, map[string]string{
+			"device":      id,
+			"user":        requestAuth(r).User,
+			"remote_addr": r.RemoteAddr,
+			"user_agent":  r.UserAgent(),
+		})
+	}
+	p.SeenAt = time.Now()
+	q := r.URL.Query()
+	if screen := q.Get("screen"); screenRe.MatchString(screen) {
+		p.Screen = screen
+	}
+	if codecs := q.Get("codecs"); codecs != "" {
+		p.Codecs = nil
+		for _, c := range strings.Split(codecs, ",") {
+			if codecRe.MatchString(c) && len(p.Codecs) < 16 {
+				p.Codecs = append(p.Codecs, c)
+			}
+		}
+	}
+	s.save()
+	return id
+}
+
+func deviceOf(r *http.Request) string {
+	c, err := r.Cookie(deviceCookie)
+	if err != nil || !deviceIDRe.MatchString(c.Value) {
+		return ""
+	}
+	return c.Value
+}
+
+// remember records the tier the device's viewer chose.
+func (s *deviceStore) remember(id, quality string) {
+	if s == nil || id == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.devices[id]
+	if !ok || p.Quality == quality {
+		return
+	}
+	p.Quality = quality
+	s.save()
+}
+
+// preferredQuality picks a known device's tier: the one its viewer last
+// chose, or else the smallest tier still as tall as its screen. It
+// returns "" for unknown devices.
+func (s *deviceStore) preferredQuality(id string) string {
+	if s == nil || id == "" {
+		return ""
+	}
+	s.mu.Lock()
+	p, ok := s.devices[id]
+	var quality, screen string
+	if ok {
+		quality, screen = p.Quality, p.Screen
+	}
+	s.mu.Unlock()
+	if quality != "" && qualityExists(quality) {
+		return quality
+	}
+	m := screenRe.FindStringSubmatch(screen)
+	if m == nil {
+		return ""
+	}
+	height, _ := strconv.Atoi(m[2])
+	return tierForHeight(height)
+}
+
+// tierForHeight returns the tier best suited to a screen height pixels
+// tall: full quality when the source fits, else the smallest tier at
+// least that tall, else the tallest.
+func tierForHeight(height int) string {
+	services.mu.Lock()
+	tiers := services.cfg.Tiers
+	res := mainRes(services.cfg)
+	services.mu.Unlock()
+	if m := resHeightRe.FindStringSubmatch(res); m != nil {
+		if source, _ := strconv.Atoi(m[1]); height >= source {
+			return ffmpeg.DefaultTier
+		}
+	}
+	best := ""
+	bestHeight := 0
+	for _, t := range tiers {
+		fits := t.Height >= height
+		switch {
+		case best == "":
+		case fits && (bestHeight < height || t.Height < bestHeight):
+		case !fits && bestHeight < height && t.Height > bestHeight:
+		default:
+			continue
+		}
+		best, bestHeight = t.Name, t.Height
+	}
+	if best == "" {
+		return ffmpeg.DefaultTier
+	}
+	return best
+}
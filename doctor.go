@@ -0,0 +1,272 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nathfavour/remoter/xshm"
+)
+
+// A diagnosis is one line of the doctor's report.
+type diagnosis struct {
+	level string // "ok", "warn" or "fail"
+	topic string
+	text  string
+}
+
+// doctor collects diagnoses for runDoctorCommand.
+type doctor struct {
+	found []diagnosis
+}
+
+func (d *doctor) ok(topic, format string, args ...any) {
+	d.found = append(d.found, diagnosis{"ok", topic, fmt.Sprintf(format, args...)})
+}
+
+func (d *doctor) warn(topic, format string, args ...any) {
+	d.found = append(d.found, diagnosis{"warn", topic, fmt.Sprintf(format, args...)})
+}
+
+func (d *doctor) fail(topic, format string, args ...any) {
+	d.found = append(d.found, diagnosis{"fail", topic, fmt.Sprintf(format, args...)})
+}
+
+// doctorTools are the programs remoter runs: the required ones stream the
+// main display, the rest serve optional features.
+var doctorTools = []struct {
+	name     string
+	required bool
+	purpose  string
+}{
+	{"ffmpeg", true, "encodes the stream"},
+	{"xdpyinfo", true, "probes the X display's size"},
+	{"x11vnc", false, "serves the VNC service"},
+	{"Xvfb", false, "runs virtual sessions and desktops"},
+	{"xdotool", false, "injects input and finds windows"},
+	{"xrandr", false, "lists monitors and resizes virtual displays"},
+}
+
+// hwEncoderRe matches the hardware H.264/HEVC encoders in ffmpeg
+// -encoders output.
+var hwEncoderRe = regexp.MustCompile(`(?m)^\s*V\S*\s+((?:h264|hevc)_(?:nvenc|vaapi|qsv|amf|v4l2m2m|videotoolbox))\s`)
+
+var dimensionsRe = regexp.MustCompile(`dimensions:\s+(\d+x\d+)`)
+
+// runDoctorCommand checks what streaming needs before the server is
+// started, printing a report; it fails when something would stop the
+// main stream from coming up.
+func runDoctorCommand(args []string) error {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	fs.Parse(args)
+	d := &doctor{}
+	cfg := d.checkConfig()
+	d.checkTools()
+	d.checkDisplay(cfg.Display)
+	d.checkEncoders()
+	d.checkPort("port", cfg.Port)
+	for _, l := range cfg.Listeners {
+		if _, port, err := net.SplitHostPort(l.Addr); err == nil {
+			var n int
+			fmt.Sscan(port, &n)
+			d.checkPort("listener", n)
+		}
+	}
+	if cfg.GRPC != nil && cfg.GRPC.Listen != "" {
+		if _, port, err := net.SplitHostPort(cfg.GRPC.Listen); err == nil {
+			var n int
+			fmt.Sscan(port, &n)
+			d.checkPort("grpc port", n)
+		}
+	}
+	d.checkFirewall(cfg.Port)
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	failed := 0
+	for _, f := range d.found {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", strings.ToUpper(f.level), f.topic, f.text)
+		if f.level == "fail" {
+			failed++
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d check(s) failed", failed)
+	}
+	fmt.Println("\nReady to stream.")
+	return nil
+}
+
+// checkConfig loads the config without creating it, falling back to the
+// defaults the server would write.
+func (d *doctor) checkConfig() *Config {
+	cfg := defaultConfig()
+	path, err := getConfigPath()
+	if err != nil {
+		d.fail("config", "%v", err)
+		return cfg
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		d.ok("config", "%s does not exist yet; the server creates it with defaults", path)
+		return cfg
+	}
+	if err != nil {
+		d.fail("config", "%v", err)
+		return cfg
+	}
+	var loaded Config
+	if err := decodeConfig(data, &loaded, false); err != nil {
+		d.fail("config", "%s: %v", path, err)
+		return cfg
+	}
+	applyDefaults(&loaded)
+	if problems := checkConfig(&loaded); len(problems) > 0 {
+		d.fail("config", "%s has %d problem(s); see remoter config validate", path, len(problems))
+	} else {
+		d.ok("config", "%s", path)
+	}
+	return &loaded
+}
+
+func (d *doctor) checkTools() {
+	for _, t := range doctorTools {
+		path, err := exec.LookPath(t.name)
+		switch {
+		case err == nil:
+			d.ok(t.name, "%s", path)
+		case t.required:
+			d.fail(t.name, "not found; it %s", t.purpose)
+		default:
+			d.warn(t.name, "not found; it %s", t.purpose)
+		}
+	}
+}
+
+// checkDisplay tells an X display from a Wayland one and tries to reach
+// it the way the encoder will.
+func (d *doctor) checkDisplay(display string) {
+	session := os.Getenv("XDG_SESSION_TYPE")
+	if strings.HasPrefix(display, "wayland-") {
+		dir := os.Getenv("XDG_RUNTIME_DIR")
+		if _, err := os.Stat(filepath.Join(dir, display)); dir == "" || err != nil {
+			d.fail("display", "Wayland socket %s not found in $XDG_RUNTIME_DIR (%q)", display, dir)
+			return
+		}
+		d.ok("display", "Wayland compositor at %s; capture needs wlr-screencopy", display)
+		return
+	}
+
+	if session == "wayland" || os.Getenv("WAYLAND_DISPLAY") != "" {
+		d.warn("session", "this is a Wayland session: %s shows XWayland windows only; set display to %q to capture the desktop",
+			display, cmp.Or(os.Getenv("WAYLAND_DISPLAY"), "wayland-0"))
+	} else if session != "" {
+		d.ok("session", "%s", session)
+	}
+
+	if auth := os.Getenv("XAUTHORITY"); auth != "" {
+		if f, err := os.Open(auth); err != nil {
+			d.warn("xauthority", "$XAUTHORITY is set but unreadable: %v", err)
+		} else {
+			f.Close()
+			d.ok("xauthority", "%s", auth)
+		}
+	} else if home, err := os.UserHomeDir(); err == nil {
+		if _, err := os.Stat(filepath.Join(home, ".Xauthority")); err != nil {
+			d.warn("xauthority", "$XAUTHORITY is unset and ~/.Xauthority is missing; a display that needs a cookie will refuse the connection")
+		}
+	}
+
+	if out, err := exec.Command("xdpyinfo", "-display", display).CombinedOutput(); err == nil {
+		if dims := dimensionsRe.FindSubmatch(out); dims != nil {
+			d.ok("display", "X display %s is reachable at %s", display, dims[1])
+		} else {
+			d.ok("display", "X display %s is reachable", display)
+		}
+		return
+	} else if !errors.Is(err, exec.ErrNotFound) {
+		d.fail("display", "cannot open X display %s: %s", display, strings.TrimSpace(string(out)))
+		return
+	}
+	c, err := xshm.Open(display, image.Rectangle{})
+	if err != nil {
+		d.fail("display", "cannot open X display %s: %v", display, err)
+		return
+	}
+	w, h := c.Size()
+	c.Close()
+	d.ok("display", "X display %s is reachable at %dx%d", display, w, h)
+}
+
+// checkEncoders lists the hardware encoders ffmpeg offers. The live
+// stream is MPEG-1 and encoded on the CPU either way.
+func (d *doctor) checkEncoders() {
+	out, err := exec.Command("ffmpeg", "-hide_banner", "-encoders").Output()
+	if err != nil {
+		return
+	}
+	var found []string
+	for _, m := range hwEncoderRe.FindAllStringSubmatch(string(out), -1) {
+		found = append(found, m[1])
+	}
+	nodes, _ := filepath.Glob("/dev/dri/renderD*")
+	switch {
+	case len(found) == 0:
+		d.ok("gpu encoders", "none in this ffmpeg build; encoding runs on the CPU")
+	case len(nodes) == 0:
+		d.warn("gpu encoders", "ffmpeg has %s but no /dev/dri render node is present", strings.Join(found, ", "))
+	default:
+		d.ok("gpu encoders", "%s (%s)", strings.Join(found, ", "), strings.Join(nodes, ", "))
+	}
+}
+
+// checkPort tries to listen on port, telling a running remoter from
+// another program holding it.
+func (d *doctor) checkPort(topic string, port int) {
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err == nil {
+		ln.Close()
+		d.ok(topic, "%d is free", port)
+		return
+	}
+	if pid, perr := runningInstance(port); perr == nil {
+		d.warn(topic, "%d is in use by a remoter (pid %d) that is already running", port, pid)
+		return
+	}
+	resp, herr := (&http.Client{Timeout: 2 * time.Second}).Get(fmt.Sprintf("http://127.0.0.1:%d/healthz", port))
+	if herr == nil {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusServiceUnavailable {
+			d.warn(topic, "%d is in use by a remoter that is already running", port)
+			return
+		}
+	}
+	d.fail(topic, "%d is in use: %v", port, err)
+}
+
+// checkFirewall hints at opening the port when a host firewall is on;
+// it cannot tell whether the port is already allowed without root.
+func (d *doctor) checkFirewall(port int) {
+	if exec.Command("systemctl", "is-active", "--quiet", "ufw").Run() == nil {
+		d.warn("firewall", "ufw is active; let viewers in with: sudo ufw allow %d/tcp", port)
+		return
+	}
+	if out, err := exec.Command("firewall-cmd", "--state").Output(); err == nil && strings.TrimSpace(string(out)) == "running" {
+		d.warn("firewall", "firewalld is running; let viewers in with: sudo firewall-cmd --permanent --add-port=%d/tcp && sudo firewall-cmd --reload", port)
+		return
+	}
+	d.ok("firewall", "no ufw or firewalld found")
+}
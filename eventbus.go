@@ -0,0 +1,53 @@
+package main
+
+import (
+	"github.com/nathfavour/remoter/events"
+)
+
+// Event types published on the bus.
+const (
+	eventClientConnected    = "client.connected"
+	eventClientDisconnected = "client.disconnected"
+	eventDeviceNew          = "device.new"
+	eventAuthFailed         = "auth.failed"
+	eventKeyPaired          = "key.paired"
+	eventKeyRevoked         = "key.revoked"
+	eventViewerHandover     = "viewer.handover"
+	eventControlGranted     = "control.granted"
+	eventControlRevoked     = "control.revoked"
+	eventEncoderStarted     = "encoder.started"
+	eventEncoderStopped     = "encoder.stopped"
+	eventEncoderExited      = "encoder.exited"
+	eventServiceFailed      = "service.failed"
+	eventEncoderIdle        = "encoder.idle"
+	eventEncoderActive      = "encoder.active"
+	eventStreamPaused       = "stream.paused"
+	eventStreamResumed      = "stream.resumed"
+	eventStreamResolution   = "stream.resolution"
+	eventStreamDegraded     = "stream.degraded"
+	eventStreamRestored     = "stream.restored"
+	eventStreamCorrupt      = "stream.corrupt"
+	eventScreenLocked       = "screen.locked"
+	eventScreenUnlocked     = "screen.unlocked"
+	eventDisplayLost        = "display.lost"
+	eventDisplayRestored    = "display.restored"
+	eventSessionCreated     = "session.created"
+	eventSessionDestroyed   = "session.destroyed"
+	eventRecordingStarted   = "recording.started"
+	eventRecordingStopped   = "recording.stopped"
+	eventCastStarted        = "cast.started"
+	eventCastStopped        = "cast.stopped"
+	eventPowerAction        = "power.action"
+	eventSyncUpdated        = "sync.updated"
+	eventChatMessage        = "chat.message"
+)
+
+// bus publishes remoter events to the configured broker, set up in main.
+var bus *events.Bus
+
+func emit(typ string, data any) {
+	markChapters(typ, data)
+	if bus != nil {
+		bus.Publish(typ, data)
+	}
+}
@@ -0,0 +1,76 @@
+package events
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Config selects an external message broker to publish events to.
+type Config struct {
+	Type string `json:"type"` // "nats" or "mqtt"
+	// URL is e.g. nats://host:4222, tls://host:4222, mqtt://host:1883 or
+	// mqtts://host:8883; credentials may be given in it or below.
+	URL      string `json:"url"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	Token    string `json:"token,omitempty"` // NATS only
+	// Prefix is prepended to the event type, "remoter" by default: NATS
+	// subjects look like remoter.client.connected, MQTT topics like
+	// remoter/client/connected.
+	Prefix   string `json:"prefix,omitempty"`
+	ClientID string `json:"client_id,omitempty"` // MQTT only
+}
+
+// NewBroker returns the sink for cfg. A NATS sink connects on first use
+// and an MQTT one at once; both reconnect after failures.
+func NewBroker(cfg Config) (Sink, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("event broker needs a url")
+	}
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid event broker url: %w", err)
+	}
+	if u.User != nil {
+		cfg.Username = u.User.Username()
+		cfg.Password, _ = u.User.Password()
+	}
+	if cfg.Prefix == "" {
+		cfg.Prefix = "remoter"
+	}
+
+	switch cfg.Type {
+	case "nats":
+		return &natsSink{cfg: cfg, addr: u}, nil
+	case "mqtt":
+		s, err := newMQTTSink(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+	return nil, fmt.Errorf("unknown event broker type %q", cfg.Type)
+}
+
+// retryInterval is how long a sink waits before reconnecting to a broker
+// that failed; events in between are dropped.
+const retryInterval = 5 * time.Second
+
+// dialBroker connects to u, over TLS for the schemes in tlsSchemes.
+func dialBroker(u *url.URL, defaultPort string, tlsSchemes ...string) (net.Conn, error) {
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), defaultPort)
+	}
+	d := &net.Dialer{Timeout: 10 * time.Second}
+	for _, s := range tlsSchemes {
+		if strings.EqualFold(u.Scheme, s) {
+			return tls.DialWithDialer(d, "tcp", host, &tls.Config{ServerName: u.Hostname()})
+		}
+	}
+	return d.Dial("tcp", host)
+}
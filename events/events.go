@@ -0,0 +1,110 @@
+package events
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Event is something that happened in remoter, as published to sinks.
+type Event struct {
+	Type string    `json:"type"` // e.g. "client.connected"
+	Time time.Time `json:"time"`
+	Host string    `json:"host"`
+	Data any       `json:"data,omitempty"`
+}
+
+// Sink delivers events somewhere outside the process.
+type Sink interface {
+	Send(ev Event) error
+	Close() error
+}
+
+// queueSize is how many events may wait for a slow sink before new ones
+// are dropped.
+const queueSize = 256
+
+// Bus fans events out to its sinks, each fed by its own queue so a broker
+// that is down does not hold up the others or the caller.
+type Bus struct {
+	host string
+
+	mu     sync.Mutex
+	closed bool
+	queues []chan Event
+	wg     sync.WaitGroup
+}
+
+func NewBus() *Bus {
+	host, _ := os.Hostname()
+	return &Bus{host: host}
+}
+
+// Add starts delivering events to s.
+func (b *Bus) Add(name string, s Sink) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	q := make(chan Event, queueSize)
+	b.queues = append(b.queues, q)
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		defer s.Close()
+		failing := false
+		for ev := range q {
+			err := s.Send(ev)
+			// Report only changes, not every event lost while down.
+			if err != nil && !failing {
+				fmt.Printf("Warning: %s event sink failed, dropping events: %v\n", name, err)
+			} else if err == nil && failing {
+				fmt.Printf("%s event sink recovered\n", name)
+			}
+			failing = err != nil
+		}
+	}()
+}
+
+// Publish sends an event of type typ to every sink without blocking.
+func (b *Bus) Publish(typ string, data any) {
+	ev := Event{Type: typ, Time: time.Now(), Host: b.host, Data: data}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	for _, q := range b.queues {
+		select {
+		case q <- ev:
+		default:
+		}
+	}
+}
+
+// Close delivers the queued events, waiting up to timeout, and closes the
+// sinks.
+func (b *Bus) Close(timeout time.Duration) {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return
+	}
+	b.closed = true
+	for _, q := range b.queues {
+		close(q)
+	}
+	b.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		b.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+	}
+}
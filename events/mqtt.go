@@ -0,0 +1,44 @@
+package events
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/nathfavour/remoter/mqtt"
+)
+
+// mqttSink publishes events as MQTT 3.1.1 QoS 0 messages to
+// <prefix>/<type>, with the dots of the type turned into levels.
+type mqttSink struct {
+	prefix string
+	client *mqtt.Client
+}
+
+// newMQTTSink starts a client for cfg, which stays connected in the
+// background; events sent while it is not are dropped.
+func newMQTTSink(cfg Config) (*mqttSink, error) {
+	client, err := mqtt.New(mqtt.Config{
+		URL:      cfg.URL,
+		Username: cfg.Username,
+		Password: cfg.Password,
+		ClientID: cfg.ClientID,
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	go client.Run()
+	return &mqttSink{prefix: cfg.Prefix, client: client}, nil
+}
+
+func (s *mqttSink) Send(ev Event) error {
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	return s.client.Publish(s.prefix+"/"+strings.ReplaceAll(ev.Type, ".", "/"), payload, false)
+}
+
+func (s *mqttSink) Close() error {
+	s.client.Close()
+	return nil
+}
@@ -0,0 +1,154 @@
+package events
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// natsSink publishes events with the NATS text protocol: each event is a
+// PUB to <prefix>.<type>.
+type natsSink struct {
+	cfg  Config
+	addr *url.URL
+
+	mu       sync.Mutex
+	conn     net.Conn
+	w        *bufio.Writer
+	lastFail time.Time
+}
+
+func (s *natsSink) connect() error {
+	conn, err := dialBroker(s.addr, "4222")
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", s.addr.Host, err)
+	}
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	r := bufio.NewReader(conn)
+	line, err := r.ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "INFO ") {
+		conn.Close()
+		return fmt.Errorf("failed to read server info from %s: %v", s.addr.Host, err)
+	}
+	var info struct {
+		TLSRequired bool `json:"tls_required"`
+	}
+	json.Unmarshal([]byte(strings.TrimPrefix(line, "INFO ")), &info)
+
+	// NATS switches to TLS after the plain INFO line.
+	if info.TLSRequired || s.addr.Scheme == "tls" {
+		tc := tls.Client(conn, &tls.Config{ServerName: s.addr.Hostname()})
+		if err := tc.Handshake(); err != nil {
+			conn.Close()
+			return fmt.Errorf("failed to start TLS with %s: %w", s.addr.Host, err)
+		}
+		conn = tc
+		r = bufio.NewReader(conn)
+	}
+
+	opts := map[string]any{"verbose": false, "pedantic": false, "name": "remoter", "lang": "go", "protocol": 0}
+	if s.cfg.Username != "" {
+		opts["user"], opts["pass"] = s.cfg.Username, s.cfg.Password
+	}
+	if s.cfg.Token != "" {
+		opts["auth_token"] = s.cfg.Token
+	}
+	connect, _ := json.Marshal(opts)
+	// The PONG to our PING confirms the CONNECT, credentials included.
+	if _, err := fmt.Fprintf(conn, "CONNECT %s\r\nPING\r\n", connect); err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to connect to %s: %w", s.addr.Host, err)
+	}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			conn.Close()
+			return fmt.Errorf("failed to connect to %s: %w", s.addr.Host, err)
+		}
+		if strings.HasPrefix(line, "-ERR") {
+			conn.Close()
+			return fmt.Errorf("%s rejected the connection: %s", s.addr.Host, strings.TrimSpace(line))
+		}
+		if strings.HasPrefix(line, "PONG") {
+			break
+		}
+	}
+	conn.SetDeadline(time.Time{})
+
+	s.conn, s.w = conn, bufio.NewWriter(conn)
+	go s.read(conn, r)
+	return nil
+}
+
+// read answers the server's keepalive PINGs until conn fails.
+func (s *natsSink) read(conn net.Conn, r *bufio.Reader) {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			break
+		}
+		if strings.HasPrefix(line, "PING") {
+			s.mu.Lock()
+			if s.conn == conn {
+				s.w.WriteString("PONG\r\n")
+				s.w.Flush()
+			}
+			s.mu.Unlock()
+		}
+	}
+	s.mu.Lock()
+	s.drop(conn)
+	s.mu.Unlock()
+}
+
+// drop forgets conn if it is still current. Callers hold s.mu.
+func (s *natsSink) drop(conn net.Conn) {
+	if s.conn == conn {
+		s.conn.Close()
+		s.conn, s.w = nil, nil
+		s.lastFail = time.Now()
+	}
+}
+
+func (s *natsSink) Send(ev Event) error {
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		if time.Since(s.lastFail) < retryInterval {
+			return fmt.Errorf("not connected to %s", s.addr.Host)
+		}
+		if err := s.connect(); err != nil {
+			s.lastFail = time.Now()
+			return err
+		}
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	fmt.Fprintf(s.w, "PUB %s.%s %d\r\n", s.cfg.Prefix, ev.Type, len(payload))
+	s.w.Write(payload)
+	s.w.WriteString("\r\n")
+	if err := s.w.Flush(); err != nil {
+		s.drop(s.conn)
+		return fmt.Errorf("failed to publish to %s: %w", s.addr.Host, err)
+	}
+	return nil
+}
+
+func (s *natsSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn != nil {
+		s.drop(s.conn)
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package events
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+)
+
+// WebhookConfig is a URL that every event it is interested in is POSTed
+// to as JSON.
+type WebhookConfig struct {
+	URL string `json:"url"`
+	// Events are the event types to send; "client.*" matches every client
+	// event. By default, all of them.
+	Events []string `json:"events,omitempty"`
+	// Secret signs each body with HMAC-SHA256, sent hex-encoded as
+	// X-Remoter-Signature: sha256=<hmac>.
+	Secret  string            `json:"secret,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	// Format "slack" posts {"text": ...} for Slack (or Mattermost)
+	// incoming webhooks instead of the event itself.
+	Format string `json:"format,omitempty"`
+}
+
+// webhookAttempts is how often a delivery is tried before the event is
+// dropped; the wait doubles from a second between attempts.
+const webhookAttempts = 3
+
+type webhookSink struct {
+	cfg    WebhookConfig
+	client *http.Client
+}
+
+// NewWebhook returns the sink for cfg.
+func NewWebhook(cfg WebhookConfig) (Sink, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("webhook needs an http or https url")
+	}
+	for _, p := range cfg.Events {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid event pattern %q", p)
+		}
+	}
+	if cfg.Format != "" && cfg.Format != "slack" {
+		return nil, fmt.Errorf("unknown webhook format %q", cfg.Format)
+	}
+	return &webhookSink{cfg: cfg, client: &http.Client{Timeout: 10 * time.Second}}, nil
+}
+
+func (s *webhookSink) wants(typ string) bool {
+	if len(s.cfg.Events) == 0 {
+		return true
+	}
+	for _, p := range s.cfg.Events {
+		if ok, _ := path.Match(p, typ); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *webhookSink) Send(ev Event) error {
+	if !s.wants(ev.Type) {
+		return nil
+	}
+	var body any = ev
+	if s.cfg.Format == "slack" {
+		body = map[string]string{"text": slackText(ev)}
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	wait := time.Second
+	for attempt := 1; ; attempt++ {
+		retry, err := s.post(data)
+		if err == nil || !retry || attempt == webhookAttempts {
+			return err
+		}
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+// post delivers one body, reporting whether a failure is worth retrying:
+// not when the receiver refused the request itself.
+func (s *webhookSink) post(data []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, s.cfg.URL, bytes.NewReader(data))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "remoter")
+	for k, v := range s.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	if s.cfg.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(s.cfg.Secret))
+		mac.Write(data)
+		req.Header.Set("X-Remoter-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		// The URL may hold a token; name only its host.
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		return true, fmt.Errorf("failed to reach %s: %v", req.URL.Host, err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode/100 != 2 {
+		retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		return retry, fmt.Errorf("%s answered %s", req.URL.Host, resp.Status)
+	}
+	return false, nil
+}
+
+// slackText renders ev as a message: its type, then its data.
+func slackText(ev Event) string {
+	text := fmt.Sprintf("*%s* on %s", ev.Type, ev.Host)
+	if ev.Data != nil {
+		if data, err := json.MarshalIndent(ev.Data, "", "  "); err == nil {
+			text += "\n```\n" + string(data) + "\n```"
+		}
+	}
+	return text
+}
+
+func (s *webhookSink) Close() error {
+	return nil
+}
@@ -0,0 +1,59 @@
+package ffmpeg
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Clip formats.
+const (
+	ClipWebM = "webm"
+	ClipGIF  = "gif"
+)
+
+// Clip cuts length from start out of the MPEG-1 recording read from src
+// and encodes it as format, scaled to width pixels wide (0 keeps the
+// recording's size). GIFs get their own palette and a lower framerate to
+// stay small enough to paste into chat.
+func Clip(ctx context.Context, src io.Reader, start, length time.Duration, format string, width int) ([]byte, error) {
+	scale := "null"
+	if width > 0 {
+		scale = "scale=" + strconv.Itoa(width) + ":-2"
+	}
+	args := []string{"-loglevel", "error", "-fflags", "+genpts", "-f", "mpegvideo", "-i", "pipe:0",
+		"-ss", seconds(start), "-t", seconds(length)}
+	switch format {
+	case ClipWebM:
+		args = append(args, "-vf", scale,
+			"-c:v", "libvpx", "-deadline", "good", "-cpu-used", "4", "-crf", "10", "-b:v", "1M",
+			"-f", "webm", "pipe:1")
+	case ClipGIF:
+		args = append(args, "-filter_complex", "fps=10,"+scale+",split[a][b];[a]palettegen[p];[b][p]paletteuse",
+			"-loop", "0", "-f", "gif", "pipe:1")
+	default:
+		return nil, fmt.Errorf("unknown clip format %q", format)
+	}
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	cmd.Stdin = src
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	// ffmpeg stops reading once the clip is cut; the rest of src is moot.
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("failed to encode clip: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if stdout.Len() == 0 {
+		return nil, fmt.Errorf("failed to encode clip: nothing in range")
+	}
+	return stdout.Bytes(), nil
+}
+
+func seconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
@@ -0,0 +1,80 @@
+package ffmpeg
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Color describes the source's color encoding where it cannot be probed.
+// X11 only reports bit depth, so HDR transfer functions and wide gamuts
+// have to be configured; 10-bit (depth 30) screens are detected.
+type Color struct {
+	Transfer  string `json:"transfer,omitempty"`  // "sdr" (default), "pq" or "hlg"
+	Primaries string `json:"primaries,omitempty"` // "bt709" (default), "bt2020" or "p3"
+	Tonemap   string `json:"tonemap,omitempty"`   // "hable" (default), "reinhard", "mobius" or "clip"
+	PeakNits  int    `json:"peak_nits,omitempty"` // HDR reference white, default 100
+}
+
+var (
+	zimgTransfer  = map[string]string{"pq": "smpte2084", "hlg": "arib-std-b67"}
+	zimgPrimaries = map[string]string{"bt709": "bt709", "bt2020": "bt2020", "p3": "smpte432"}
+	tonemappers   = map[string]bool{"hable": true, "reinhard": true, "mobius": true, "clip": true}
+)
+
+// Validate checks the configured values.
+func (c *Color) Validate() error {
+	if c == nil {
+		return nil
+	}
+	if c.Transfer != "" && c.Transfer != "sdr" && zimgTransfer[c.Transfer] == "" {
+		return fmt.Errorf("unknown transfer %q", c.Transfer)
+	}
+	if c.Primaries != "" && zimgPrimaries[c.Primaries] == "" {
+		return fmt.Errorf("unknown primaries %q", c.Primaries)
+	}
+	if c.Tonemap != "" && !tonemappers[c.Tonemap] {
+		return fmt.Errorf("unknown tonemap %q", c.Tonemap)
+	}
+	return nil
+}
+
+// colorFilter returns the filter chain converting the capture to 8-bit
+// BT.709 without washing out HDR or banding 10-bit gradients, or "" when
+// the default conversion is fine.
+func colorFilter(c *Color, depth string) string {
+	var cfg Color
+	if c != nil {
+		cfg = *c
+	}
+	primaries := zimgPrimaries[cfg.Primaries]
+	if primaries == "" {
+		primaries = "bt709"
+	}
+
+	if transfer := zimgTransfer[cfg.Transfer]; transfer != "" {
+		tonemap := cfg.Tonemap
+		if tonemap == "" {
+			tonemap = "hable"
+		}
+		peak := cfg.PeakNits
+		if peak <= 0 {
+			peak = 100
+		}
+		return strings.Join([]string{
+			fmt.Sprintf("zscale=tin=%s:pin=%s:t=linear:npl=%d", transfer, primaries, peak),
+			"format=gbrpf32le",
+			"zscale=p=bt709",
+			fmt.Sprintf("tonemap=tonemap=%s:desat=0", tonemap),
+			"zscale=t=bt709:m=bt709:r=tv",
+			"format=yuv420p",
+		}, ",")
+	}
+	if primaries != "bt709" {
+		return fmt.Sprintf("zscale=pin=%s:p=bt709:m=bt709:r=tv:d=error_diffusion,format=yuv420p", primaries)
+	}
+	if depth == "30" {
+		// Error diffusion hides the banding of a plain 10-to-8-bit cut.
+		return "scale=sws_dither=ed:out_color_matrix=bt709:out_range=tv,format=yuv420p"
+	}
+	return ""
+}
@@ -1,22 +1,163 @@
 package ffmpeg
 
 import (
-	"encoding/json"
+	"bufio"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
-	"os/user"
-	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
-type Config struct {
-	VNC       bool   `json:"vnc"`
-	FFmpeg    bool   `json:"ffmpeg"`
-	Display   string `json:"display"`
-	Res       string `json:"res"`
-	Framerate int    `json:"framerate"` // New field
+// Settings describes the capture and encode parameters of a pipeline.
+type Settings struct {
+	Display   string
+	Res       string
+	Framerate int
+	Bitrate   string
+	// RuntimeDir is the XDG_RUNTIME_DIR holding Display's socket when
+	// Display names a Wayland socket (e.g. "wayland-1").
+	RuntimeDir string
+	// Stream names the session the output is published as, "" for the
+	// main stream.
+	Stream string
+	// Ingest receives each output, MPEG-1 at quality "" or a tier's
+	// name, from a pipe ffmpeg inherits; it returns when r ends. Nothing
+	// goes over the network or into the command line others can read.
+	Ingest func(stream, quality string, r io.Reader)
+	// Capture restricts capture to a rectangle of the screen.
+	Capture *Region
+	// ROI is encoded at higher quality than the rest of the frame. Only
+	// X11 capture applies it. It is relative to Capture.
+	ROI *Region
+	// Masks are hidden from the stream before anything else sees the
+	// frame.
+	Masks []Mask
+	// Overlay draws live files over the screen, before any scaling.
+	Overlay *Overlay
+	// Scale resizes the encoded picture to "WxH", whatever the capture
+	// size.
+	Scale string
+	// Color overrides what is known about the source's color encoding.
+	Color *Color
+	// HideCursor leaves the pointer out of X11 captures.
+	HideCursor bool
+	// AlignRefresh adjusts Framerate to a whole fraction of the X
+	// display's refresh rate.
+	AlignRefresh bool
+	// Tiers are lower quality renditions posted alongside the full one
+	// with &quality=<name>. Only X11 capture produces them.
+	Tiers []Tier
+	// Grid, when set, composites its sources instead of capturing
+	// Display.
+	Grid *Grid
+	// Watermark burns the time and host into every frame, after scaling.
+	// Grids don't draw it.
+	Watermark *Watermark
+	// Stats names a file of live statistics drawn in the top-right
+	// corner after scaling and re-read every frame; it may use drawtext's
+	// %{...} expansions. Grids don't draw it.
+	Stats string
+}
+
+// Region is a rectangle of the captured screen.
+type Region struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+	W int `json:"w"`
+	H int `json:"h"`
+}
+
+// clip restricts r to a screen of size res ("WxH").
+func (r Region) clip(res string) (Region, bool) {
+	var w, h int
+	if _, err := fmt.Sscanf(res, "%dx%d", &w, &h); err != nil {
+		return r, true
+	}
+	r.X, r.Y = max(r.X, 0), max(r.Y, 0)
+	r.W, r.H = min(r.W, w-r.X), min(r.H, h-r.Y)
+	return r, r.W > 0 && r.H > 0
+}
+
+// roiFilter boosts r for MPEG-1, which has no QP maps: softening the rest
+// of the frame makes it cheap to code, leaving the rate control's bits to
+// the region.
+func (r Region) roiFilter() string {
+	return fmt.Sprintf("split[bg][fg];[bg]boxblur=6:2[soft];[fg]crop=%d:%d:%d:%d[roi];[soft][roi]overlay=%d:%d",
+		r.W, r.H, r.X, r.Y, r.X, r.Y)
+}
+
+// ParseSize parses a "WxH" resolution, which MPEG-1 needs to be even.
+func ParseSize(res string) (int, int, error) {
+	var w, h int
+	if _, err := fmt.Sscanf(res, "%dx%d", &w, &h); err != nil || fmt.Sprintf("%dx%d", w, h) != res {
+		return 0, 0, fmt.Errorf("invalid resolution %q, expected WxH", res)
+	}
+	if w < 16 || h < 16 || w > 8192 || h > 8192 || w%2 != 0 || h%2 != 0 {
+		return 0, 0, fmt.Errorf("resolution %q must be even and between 16x16 and 8192x8192", res)
+	}
+	return w, h, nil
+}
+
+func scaleFilter(res string) string {
+	if res == "" {
+		return ""
+	}
+	w, h, err := ParseSize(res)
+	if err != nil {
+		fmt.Printf("Warning: %v, not scaling\n", err)
+		return ""
+	}
+	return fmt.Sprintf("scale=%d:%d", w, h)
+}
+
+// Status is a snapshot of the pipeline state.
+type Status struct {
+	Running   bool      `json:"running"`
+	PID       int       `json:"pid,omitempty"`
+	Display   string    `json:"display"`
+	Res       string    `json:"res"`
+	Depth     string    `json:"depth"`
+	Framerate int       `json:"framerate"`
+	Bitrate   string    `json:"bitrate"`
+	Capture   *Region   `json:"capture,omitempty"`
+	ROI       *Region   `json:"roi,omitempty"`
+	Masks     []Mask    `json:"masks,omitempty"`
+	Scale     string    `json:"scale,omitempty"`
+	StartedAt time.Time `json:"started_at,omitempty"`
+	LastError string    `json:"last_error,omitempty"`
+
+	// Refresh and PacedFramerate are set when the framerate was aligned
+	// to the display.
+	Refresh        float64 `json:"refresh_hz,omitempty"`
+	PacedFramerate int     `json:"paced_framerate,omitempty"`
+
+	// Speed is how fast ffmpeg encodes relative to real time, as it last
+	// reported; below 1 it is falling behind the screen.
+	Speed float64 `json:"speed,omitempty"`
+	// FPS and OutputKbps are the frame rate and bitrate, across all
+	// outputs, that ffmpeg last reported.
+	FPS        float64 `json:"fps,omitempty"`
+	OutputKbps float64 `json:"output_kbps,omitempty"`
+}
+
+// Encoder supervises a single ffmpeg process that captures the X display
+// and posts the encoded stream to the local /stream endpoint.
+type Encoder struct {
+	mu       sync.Mutex
+	settings Settings
+	cmd      *exec.Cmd
+	done     chan struct{}
+	status   Status
+	onExit   func(error)
+}
+
+func NewEncoder(s Settings) *Encoder {
+	return &Encoder{settings: s}
 }
 
 func getScreenInfo(display string) (string, string, error) {
@@ -48,47 +189,23 @@ func getScreenInfo(display string) (string, string, error) {
 	return res, depth, nil
 }
 
-func configPath() (string, error) {
-	usr, err := user.Current()
-	if err != nil {
-		return "", err
-	}
-	return filepath.Join(usr.HomeDir, ".remoter.json"), nil
+// isWayland reports whether display names a Wayland socket rather than an
+// X display.
+func isWayland(display string) bool {
+	return strings.HasPrefix(display, "wayland-")
 }
 
-func loadConfig() (*Config, error) {
-	path, err := configPath()
-	if err != nil {
-		return nil, err
-	}
-	f, err := os.Open(path)
-	if err != nil {
-		return nil, err
-	}
-	defer f.Close()
-	var cfg Config
-	if err := json.NewDecoder(f).Decode(&cfg); err != nil {
-		return nil, err
-	}
-	// Set default framerate if missing or zero
-	if cfg.Framerate == 0 {
-		cfg.Framerate = 25
-		b, _ := json.MarshalIndent(cfg, "", "  ")
-		_ = os.WriteFile(path, b, 0644)
-	}
-	return &cfg, nil
-}
-
-func saveConfig(cfg *Config) error {
-	path, err := configPath()
-	if err != nil {
-		return err
+// probe resolves the display and capture size to use, falling back to the
+// configured resolution when the X server cannot be queried.
+func probe(display, res string) (string, string, string) {
+	if isWayland(display) {
+		// wlr-screencopy captures the whole output at its own size.
+		if parts := strings.Split(res, "x"); len(parts) >= 2 {
+			res = fmt.Sprintf("%sx%s", parts[0], parts[1])
+		}
+		return display, res, "24"
 	}
-	b, _ := json.MarshalIndent(cfg, "", "  ")
-	return os.WriteFile(path, b, 0644)
-}
 
-func StartFFmpeg(display, res string, port int) error {
 	// For real display, try :0.0 first, then fall back to config
 	if display == ":0.0" {
 		// Check if we can access the real display
@@ -99,69 +216,392 @@ func StartFFmpeg(display, res string, port int) error {
 		}
 	}
 
-	// Get actual screen info
 	actualRes, depth, err := getScreenInfo(display)
 	if err != nil {
 		fmt.Printf("Warning: %v. Using config values.\n", err)
-		// Parse resolution from config
-		if strings.Contains(res, "x") {
-			parts := strings.Split(res, "x")
-			if len(parts) >= 2 {
-				actualRes = fmt.Sprintf("%sx%s", parts[0], parts[1])
-			}
-		} else {
-			actualRes = "1366x768" // fallback
+		actualRes = "1366x768" // fallback
+		if parts := strings.Split(res, "x"); len(parts) >= 2 {
+			actualRes = fmt.Sprintf("%sx%s", parts[0], parts[1])
 		}
 		depth = "24"
 	}
+	return display, actualRes, depth
+}
+
+// OnExit registers fn to be called when ffmpeg exits without being
+// stopped, with the error it exited with, if any.
+func (e *Encoder) OnExit(fn func(error)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.onExit = fn
+}
+
+// Settings returns the settings the next start will use.
+func (e *Encoder) Settings() Settings {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.settings
+}
+
+// Status returns a snapshot of the pipeline state.
+func (e *Encoder) Status() Status {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	st := e.status
+	st.Framerate = e.settings.Framerate
+	st.Bitrate = e.settings.Bitrate
+	st.Capture = e.settings.Capture
+	st.ROI = e.settings.ROI
+	st.Masks = e.settings.Masks
+	st.Scale = e.settings.Scale
+	return st
+}
+
+// Start probes the display and launches ffmpeg in the background.
+func (e *Encoder) Start() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.cmd != nil {
+		return fmt.Errorf("ffmpeg is already running")
+	}
+
+	var display, actualRes, depth string
+	if g := e.settings.Grid; g != nil {
+		actualRes, depth = g.size(), "24"
+	} else {
+		display, actualRes, depth = probe(e.settings.Display, e.settings.Res)
+	}
+	fps := e.settings.Framerate
+	var refresh float64
+	if e.settings.AlignRefresh && display != "" && !isWayland(display) {
+		if refresh = refreshRate(display); refresh > 0 {
+			fps = pacedFramerate(fps, refresh)
+		}
+	}
+
+	cmd, outputs := e.captureCommand(display, actualRes, depth, fps)
+	var readers []*os.File
+	for range outputs {
+		pr, pw, err := os.Pipe()
+		if err != nil {
+			closeAll(readers)
+			closeAll(cmd.ExtraFiles)
+			return fmt.Errorf("failed to start ffmpeg: %w", err)
+		}
+		readers = append(readers, pr)
+		cmd.ExtraFiles = append(cmd.ExtraFiles, pw)
+	}
+	var progress *io.PipeWriter
+	if cmd.Args[0] == "ffmpeg" {
+		// encodeCommand asked for -progress on stdout.
+		var pr *io.PipeReader
+		pr, progress = io.Pipe()
+		cmd.Stdout = progress
+		go e.readProgress(cmd, pr)
+	} else {
+		cmd.Stdout = os.Stdout
+	}
+	cmd.Stderr = os.Stderr
+	err := cmd.Start()
+	// The child holds its own copies.
+	closeAll(cmd.ExtraFiles)
+	if err != nil {
+		if progress != nil {
+			progress.Close()
+		}
+		closeAll(readers)
+		e.status.LastError = err.Error()
+		return fmt.Errorf("failed to start ffmpeg: %w", err)
+	}
+	ingest, stream := e.settings.Ingest, e.settings.Stream
+	for i, quality := range outputs {
+		go func(r *os.File) {
+			defer r.Close()
+			if ingest == nil {
+				io.Copy(io.Discard, r)
+				return
+			}
+			ingest(stream, quality, r)
+		}(readers[i])
+	}
 
-	// Load config to get framerate
-	cfg, err := loadConfig()
-	framerate := 25
-	if err == nil {
-		framerate = cfg.Framerate
+	done := make(chan struct{})
+	e.cmd = cmd
+	e.done = done
+	e.status = Status{
+		Running:   true,
+		PID:       cmd.Process.Pid,
+		Display:   display,
+		Res:       actualRes,
+		Depth:     depth,
+		StartedAt: time.Now(),
+	}
+	if refresh > 0 {
+		e.status.Refresh = refresh
+		e.status.PacedFramerate = fps
 	}
 
-	// Update config if needed
-	if err == nil {
-		updated := false
-		if cfg.Res != fmt.Sprintf("%sx%s", strings.Split(actualRes, "x")[0], strings.Split(actualRes, "x")[1])+"x"+depth {
-			cfg.Res = fmt.Sprintf("%sx%sx%s", strings.Split(actualRes, "x")[0], strings.Split(actualRes, "x")[1], depth)
-			updated = true
+	go func() {
+		err := cmd.Wait()
+		if progress != nil {
+			progress.Close()
+		}
+		var onExit func(error)
+		e.mu.Lock()
+		if e.cmd == cmd {
+			onExit = e.onExit
+			e.cmd = nil
+			e.status.Running = false
+			e.status.PID = 0
+			if err != nil {
+				e.status.LastError = err.Error()
+			}
+		}
+		e.mu.Unlock()
+		if err != nil {
+			fmt.Printf("FFmpeg exited with error: %v\n", err)
+		}
+		close(done)
+		if onExit != nil {
+			onExit(err)
+		}
+	}()
+
+	return nil
+}
+
+// readProgress follows ffmpeg's -progress key=value lines for its speed,
+// frame rate and bitrate.
+func (e *Encoder) readProgress(cmd *exec.Cmd, progress io.Reader) {
+	sc := bufio.NewScanner(progress)
+	for sc.Scan() {
+		key, v, ok := strings.Cut(sc.Text(), "=")
+		if !ok {
+			continue
 		}
-		if cfg.Display != display {
-			cfg.Display = display
-			updated = true
+		var field *float64
+		switch key {
+		case "speed":
+			field, v = &e.status.Speed, strings.TrimSuffix(v, "x")
+		case "fps":
+			field = &e.status.FPS
+		case "bitrate":
+			field, v = &e.status.OutputKbps, strings.TrimSuffix(v, "kbits/s")
+		default:
+			continue
 		}
-		if updated {
-			_ = saveConfig(cfg)
+		n, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			continue // "N/A" until the first frames are out
 		}
+		e.mu.Lock()
+		if e.cmd == cmd {
+			*field = n
+		}
+		e.mu.Unlock()
 	}
+	// Never leave ffmpeg blocked writing to the pipe.
+	io.Copy(io.Discard, progress)
+}
 
-	// The display argument is already configurable via config and passed to FFmpeg.
+// captureCommand builds the process that captures display, or composites
+// the grid, and writes MPEG-1 to the pipes Start hands it, one per output
+// quality it returns: ffmpeg's x11grab for X displays, and wf-recorder
+// (wlr-screencopy) for headless wlroots compositors.
+func (e *Encoder) captureCommand(display, res, depth string, fps int) (*exec.Cmd, []string) {
+	if e.settings.Grid != nil {
+		return e.encodeCommand(e.gridCommand(fps), "[v0]", fps)
+	}
+	color := colorFilter(e.settings.Color, depth)
+	var capture *Region
+	if e.settings.Capture != nil {
+		if c, ok := e.settings.Capture.clip(res); ok {
+			// yuv420p needs even dimensions.
+			c.W, c.H = c.W&^1, c.H&^1
+			capture, res = &c, fmt.Sprintf("%dx%d", c.W, c.H)
+		} else {
+			fmt.Printf("Warning: capture area %+v is outside the %s screen, capturing all of it\n", *e.settings.Capture, res)
+		}
+	}
+	masks := maskFilter(e.settings.Masks, capture, res)
+	scale := scaleFilter(e.settings.Scale)
+	var watermark string
+	if wm := e.settings.Watermark; wm != nil {
+		var err error
+		if watermark, err = wm.filter(e.settings.Stream); err != nil {
+			fmt.Printf("Warning: %v, streaming without the watermark\n", err)
+		}
+	}
+	stats := statsFilter(e.settings.Stats)
+	if isWayland(display) {
+		args := []string{
+			"-c", "mpeg1video",
+			"-m", "mpeg1video",
+			"-r", fmt.Sprintf("%d", fps),
+			"-p", "b=" + e.settings.Bitrate,
+		}
+		if vf := strings.Trim(strings.Join([]string{color, masks, scale, watermark, stats}, ","), ","); vf != "" {
+			args = append(args, "-F", vf)
+		}
+		if capture != nil {
+			args = append(args, "-g", fmt.Sprintf("%d,%d %dx%d", capture.X, capture.Y, capture.W, capture.H))
+		}
+		args = append(args, "-f", outputPipe(0))
+		if e.settings.Overlay != nil {
+			fmt.Printf("Warning: input visualization is not supported on Wayland\n")
+		}
+		if len(e.settings.Tiers) > 0 {
+			fmt.Printf("Warning: quality tiers are not supported on Wayland, streaming %s only\n", DefaultTier)
+		}
+		fmt.Printf("Starting wf-recorder: wf-recorder %s\n", strings.Join(args, " "))
+		cmd := exec.Command("wf-recorder", args...)
+		cmd.Env = append(os.Environ(), "WAYLAND_DISPLAY="+display)
+		if e.settings.RuntimeDir != "" {
+			cmd.Env = append(cmd.Env, "XDG_RUNTIME_DIR="+e.settings.RuntimeDir)
+		}
+		return cmd, []string{""}
+	}
 
-	// Compose ffmpeg command with configurable framerate
-	url := fmt.Sprintf("http://localhost:%d/stream", port)
+	input := display
+	if capture != nil {
+		input = fmt.Sprintf("%s+%d,%d", display, capture.X, capture.Y)
+	}
 	ffmpegArgs := []string{
-		"-video_size", actualRes,
-		"-framerate", fmt.Sprintf("%d", framerate),
+		"-video_size", res,
+		"-framerate", fmt.Sprintf("%d", fps),
 		"-f", "x11grab",
-		"-i", display,
-		"-vcodec", "mpeg1video",
-		"-b:v", "800k",
-		"-f", "mpeg1video",
-		url,
 	}
+	if e.settings.HideCursor {
+		ffmpegArgs = append(ffmpegArgs, "-draw_mouse", "0")
+	}
+	ffmpegArgs = append(ffmpegArgs, "-i", input)
+	if o := e.settings.Overlay; o != nil && o.Image != "" {
+		// image2 reopens the file for every frame it loops over.
+		ffmpegArgs = append(ffmpegArgs, "-f", "image2", "-loop", "1", "-framerate", fmt.Sprintf("%d", fps), "-i", o.Image)
+	}
+	filters := []string{"[0:v]null"}
+	if color != "" {
+		filters = append(filters, color)
+	}
+	if masks != "" {
+		filters = append(filters, masks)
+	}
+	if o := e.settings.Overlay; o != nil {
+		filters = append(filters, o.filters(capture)...)
+	}
+	if e.settings.ROI != nil {
+		if roi, ok := e.settings.ROI.clip(res); ok {
+			filters = append(filters, roi.roiFilter())
+		} else {
+			fmt.Printf("Warning: region of interest %+v is outside the %s screen, ignoring it\n", *e.settings.ROI, res)
+		}
+	}
+	if scale != "" {
+		filters = append(filters, scale)
+	}
+	if watermark != "" {
+		filters = append(filters, watermark)
+	}
+	if stats != "" {
+		filters = append(filters, stats)
+	}
+	// Without filters or tiers ffmpeg maps the capture to the one output.
+	label := ""
+	if len(filters) > 1 || len(e.settings.Tiers) > 0 {
+		ffmpegArgs = append(ffmpegArgs, "-filter_complex", tierGraph(strings.Join(filters, ","), e.settings.Tiers))
+		label = "[v0]"
+	}
+
+	return e.encodeCommand(ffmpegArgs, label, fps)
+}
+
+// outputPipe is where output i goes: ExtraFiles start at descriptor 3.
+func outputPipe(i int) string {
+	return fmt.Sprintf("pipe:%d", 3+i)
+}
+
+// encodeCommand completes the inputs and filters in ffmpegArgs with the
+// MPEG-1 outputs: label (or the only input when empty) at full quality,
+// and [v1] onwards for the tiers. It returns the outputs' qualities.
+func (e *Encoder) encodeCommand(ffmpegArgs []string, label string, fps int) (*exec.Cmd, []string) {
+	output := func(label, bitrate, url string) {
+		if label != "" {
+			ffmpegArgs = append(ffmpegArgs, "-map", label)
+		}
+		ffmpegArgs = append(ffmpegArgs,
+			// x11grab stamps frames with the wall clock, so scheduling
+			// jitter shows up as uneven timestamps; a constant-rate output
+			// evens the cadence by duplicating or dropping the odd frame.
+			"-fps_mode", "cfr",
+			"-r", fmt.Sprintf("%d", fps),
+			"-vcodec", "mpeg1video",
+			"-b:v", bitrate,
+			"-f", "mpeg1video",
+			url,
+		)
+	}
+	outputs := []string{""}
+	output(label, e.settings.Bitrate, outputPipe(0))
+	for i, t := range e.settings.Tiers {
+		output(fmt.Sprintf("[v%d]", i+1), t.Bitrate, outputPipe(i+1))
+		outputs = append(outputs, t.Name)
+	}
+	// Progress reports on stdout let Start track the encoding speed.
+	ffmpegArgs = append([]string{"-progress", "pipe:1"}, ffmpegArgs...)
 	fmt.Printf("Starting FFmpeg: ffmpeg %s\n", strings.Join(ffmpegArgs, " "))
+	return exec.Command("ffmpeg", ffmpegArgs...), outputs
+}
 
-	cmd := exec.Command("ffmpeg", ffmpegArgs...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+func closeAll(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
 
-	// Print error if FFmpeg fails to start
-	err = cmd.Run()
-	if err != nil {
-		fmt.Printf("FFmpeg exited with error: %v\n", err)
+// Stop terminates the running ffmpeg process and waits for it to exit.
+func (e *Encoder) Stop() error {
+	e.mu.Lock()
+	cmd, done := e.cmd, e.done
+	if cmd == nil {
+		e.mu.Unlock()
+		return fmt.Errorf("ffmpeg is not running")
+	}
+	e.cmd = nil
+	e.status.Running = false
+	e.status.PID = 0
+	e.mu.Unlock()
+
+	// ffmpeg finalizes its output cleanly on SIGINT.
+	_ = cmd.Process.Signal(os.Interrupt)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		_ = cmd.Process.Kill()
+		<-done
+	}
+	return nil
+}
+
+// Restart stops ffmpeg if it is running and starts it again.
+func (e *Encoder) Restart() error {
+	if e.Status().Running {
+		if err := e.Stop(); err != nil {
+			return err
+		}
+	}
+	return e.Start()
+}
+
+// Update replaces the pipeline settings, restarting ffmpeg if it is running
+// so the new values take effect.
+func (e *Encoder) Update(s Settings) error {
+	e.mu.Lock()
+	e.settings = s
+	running := e.cmd != nil
+	e.mu.Unlock()
+
+	if running {
+		return e.Restart()
 	}
-	return err
+	return nil
 }
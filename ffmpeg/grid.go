@@ -0,0 +1,130 @@
+package ffmpeg
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+)
+
+// Grid composites several sources into one picture, Columns cells wide,
+// for wall displays watching many screens at once.
+type Grid struct {
+	Sources []GridSource
+	// Columns defaults to the smallest square that fits every source.
+	Columns int
+	// Cell is the "WxH" each source is scaled into, 640x360 by default.
+	Cell string
+}
+
+// GridSource is one cell: a region of an X display, or a stream ffmpeg
+// can read, such as another remoter's /live.
+type GridSource struct {
+	Label   string
+	Display string
+	Capture *Region
+	URL     string
+}
+
+var gridLabelRe = regexp.MustCompile(`^[\pL\pN _.#-]*$`)
+
+// ValidateGrid checks g can be built into a filter graph.
+func ValidateGrid(g Grid) error {
+	if len(g.Sources) == 0 {
+		return fmt.Errorf("grid has no sources")
+	}
+	if g.Columns < 0 {
+		return fmt.Errorf("invalid grid columns %d", g.Columns)
+	}
+	if g.Cell != "" {
+		if _, _, err := ParseSize(g.Cell); err != nil {
+			return err
+		}
+	}
+	for i, s := range g.Sources {
+		if (s.Display == "") == (s.URL == "") {
+			return fmt.Errorf("grid source %d needs exactly one of a display or a URL", i)
+		}
+		if isWayland(s.Display) {
+			return fmt.Errorf("grid source %d: Wayland displays cannot be composited", i)
+		}
+		if !gridLabelRe.MatchString(s.Label) {
+			return fmt.Errorf("grid source %d: label %q may only hold letters, digits, spaces and ._#-", i, s.Label)
+		}
+	}
+	return nil
+}
+
+func (g Grid) layout() (cols, rows, w, h int) {
+	w, h = 640, 360
+	if g.Cell != "" {
+		if cw, ch, err := ParseSize(g.Cell); err == nil {
+			w, h = cw, ch
+		}
+	}
+	n := len(g.Sources)
+	cols = g.Columns
+	if cols <= 0 {
+		cols = int(math.Ceil(math.Sqrt(float64(n))))
+	}
+	cols = max(min(cols, n), 1)
+	rows = (n + cols - 1) / cols
+	return cols, rows, w, h
+}
+
+// size is the composite's resolution.
+func (g Grid) size() string {
+	cols, rows, w, h := g.layout()
+	return fmt.Sprintf("%dx%d", cols*w, rows*h)
+}
+
+// gridCommand returns the inputs and filter graph reading every source
+// and stacking them into the grid, labelled [v0] onwards like a capture's.
+func (e *Encoder) gridCommand(fps int) []string {
+	g := e.settings.Grid
+	cols, _, w, h := g.layout()
+	var args, cells, layout []string
+	for i, s := range g.Sources {
+		if s.URL != "" {
+			args = append(args, "-thread_queue_size", "512", "-i", s.URL)
+		} else {
+			display, res, _ := probe(s.Display, "")
+			input := display
+			if s.Capture != nil {
+				if c, ok := s.Capture.clip(res); ok {
+					input = fmt.Sprintf("%s+%d,%d", display, c.X, c.Y)
+					res = fmt.Sprintf("%dx%d", c.W, c.H)
+				}
+			}
+			args = append(args, "-video_size", res, "-framerate", fmt.Sprintf("%d", fps), "-f", "x11grab")
+			if e.settings.HideCursor {
+				args = append(args, "-draw_mouse", "0")
+			}
+			args = append(args, "-i", input)
+		}
+
+		// Letterbox each source into its cell.
+		cell := fmt.Sprintf("[%d:v]scale=%d:%d:force_original_aspect_ratio=decrease,pad=%d:%d:(ow-iw)/2:(oh-ih)/2,setsar=1,fps=%d",
+			i, w, h, w, h, fps)
+		if s.Label != "" {
+			cell += fmt.Sprintf(",drawtext=text='%s':expansion=none:x=8:y=8:fontsize=20:fontcolor=white:box=1:boxcolor=black@0.6:boxborderw=4", s.Label)
+		}
+		cells = append(cells, fmt.Sprintf("%s[c%d]", cell, i))
+		layout = append(layout, fmt.Sprintf("%d_%d", (i%cols)*w, (i/cols)*h))
+	}
+
+	var inputs strings.Builder
+	for i := range g.Sources {
+		fmt.Fprintf(&inputs, "[c%d]", i)
+	}
+	chain := fmt.Sprintf("%sxstack=inputs=%d:layout=%s:fill=black", inputs.String(), len(g.Sources), strings.Join(layout, "|"))
+	if len(g.Sources) == 1 {
+		chain = "[c0]null"
+	}
+	if scale := scaleFilter(e.settings.Scale); scale != "" {
+		chain += "," + scale
+	}
+	graph := strings.Join(cells, ";") + ";" + tierGraph(chain, e.settings.Tiers)
+	args = append(args, "-filter_complex", graph)
+	return args
+}
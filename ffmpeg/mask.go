@@ -0,0 +1,50 @@
+package ffmpeg
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Mask hides a rectangle of the screen, in screen coordinates, from the
+// stream: filled black, or blurred past recognition when Blur is set.
+type Mask struct {
+	Region
+	Blur bool `json:"blur,omitempty"`
+}
+
+// maskFilter returns the filter chain applying masks to a capture of
+// size res taken at the capture offset, or "" when none of them is on it.
+func maskFilter(masks []Mask, capture *Region, res string) string {
+	var parts []string
+	for i, m := range masks {
+		r := m.Region
+		if capture != nil {
+			r.X, r.Y = r.X-capture.X, r.Y-capture.Y
+		}
+		if r.X < 0 {
+			r.W, r.X = r.W+r.X, 0
+		}
+		if r.Y < 0 {
+			r.H, r.Y = r.H+r.Y, 0
+		}
+		if r.W <= 0 || r.H <= 0 {
+			continue
+		}
+		// Cover the whole chroma block of odd edges rather than leave a
+		// sliver of the masked area visible.
+		r.W, r.H = (r.W+r.X&1+1)&^1, (r.H+r.Y&1+1)&^1
+		r.X, r.Y = r.X&^1, r.Y&^1
+		r, ok := r.clip(res)
+		if !ok {
+			continue
+		}
+		if !m.Blur {
+			parts = append(parts, fmt.Sprintf("drawbox=x=%d:y=%d:w=%d:h=%d:color=black:t=fill", r.X, r.Y, r.W, r.H))
+			continue
+		}
+		parts = append(parts, fmt.Sprintf(
+			"split[pm%d][pmfg%d];[pmfg%d]crop=%d:%d:%d:%d,boxblur=lr=min(w\\,h)/4:lp=4:cr=min(cw\\,ch)/4:cp=4[pmb%d];[pm%d][pmb%d]overlay=%d:%d",
+			i, i, i, r.W, r.H, r.X, r.Y, i, i, i, r.X, r.Y))
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,35 @@
+package ffmpeg
+
+import "fmt"
+
+// Overlay names files drawn over the capture and re-read while streaming,
+// such as click ripples and typed keys for tutorials. Both are optional.
+type Overlay struct {
+	// Image is a transparent PNG of the screen's size, in screen
+	// coordinates.
+	Image string
+	// Text is a line drawn at the bottom of the picture. Neither path may
+	// contain a quote.
+	Text string
+}
+
+// filters returns the filters drawing o onto the chain; the image is the
+// capture's second input. capture shifts screen coordinates onto the
+// captured area.
+func (o *Overlay) filters(capture *Region) []string {
+	var filters []string
+	if o.Image != "" {
+		x, y := 0, 0
+		if capture != nil {
+			x, y = -capture.X, -capture.Y
+		}
+		// Close the chain so far to overlay the image onto it.
+		filters = append(filters, fmt.Sprintf("null[pre];[pre][1:v]overlay=%d:%d:format=auto", x, y))
+	}
+	if o.Text != "" {
+		filters = append(filters, fmt.Sprintf(
+			"drawtext=textfile='%s':reload=1:expansion=none:fontcolor=white:fontsize=h/22:borderw=3:bordercolor=black@0.8:x=(w-text_w)/2:y=h-text_h-h/10",
+			o.Text))
+	}
+	return filters
+}
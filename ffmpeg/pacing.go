@@ -0,0 +1,36 @@
+package ffmpeg
+
+import (
+	"math"
+	"os/exec"
+	"regexp"
+	"strconv"
+)
+
+var currentModeRe = regexp.MustCompile(`\s([\d.]+)\*`)
+
+// refreshRate returns the refresh rate of display's current mode, or 0 if
+// xrandr cannot tell.
+func refreshRate(display string) float64 {
+	out, err := exec.Command("xrandr", "--display", display, "--current").Output()
+	if err != nil {
+		return 0
+	}
+	m := currentModeRe.FindSubmatch(out)
+	if m == nil {
+		return 0
+	}
+	hz, _ := strconv.ParseFloat(string(m[1]), 64)
+	return hz
+}
+
+// pacedFramerate picks the whole fraction of refresh closest to fps, so
+// every captured frame spans the same number of screen refreshes instead
+// of the periodic double frame a mismatched rate (25 on 60Hz) produces.
+func pacedFramerate(fps int, refresh float64) int {
+	if refresh <= 0 || fps <= 0 {
+		return fps
+	}
+	n := max(math.Round(refresh/float64(fps)), 1)
+	return max(int(math.Round(refresh/n)), 1)
+}
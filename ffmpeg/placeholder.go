@@ -0,0 +1,80 @@
+package ffmpeg
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Slate is what a placeholder looks like.
+type Slate struct {
+	// Image is a picture shown in the middle, scaled down to fit.
+	Image string `json:"image,omitempty"`
+	// Background and TextColor are ffmpeg colors, "0x202020" and "white"
+	// by default.
+	Background string `json:"background,omitempty"`
+	TextColor  string `json:"text_color,omitempty"`
+}
+
+// Validate checks that the slate's image can be read.
+func (s *Slate) Validate() error {
+	if s == nil || s.Image == "" {
+		return nil
+	}
+	if _, err := os.Stat(s.Image); err != nil {
+		return fmt.Errorf("failed to read placeholder image: %w", err)
+	}
+	return nil
+}
+
+// Placeholder encodes a still MPEG-1 picture of size res ("WxH") showing
+// text on slate, which may be nil, for viewers of a paused stream. The
+// text is left out when ffmpeg was built without drawtext.
+func Placeholder(res, text string, slate *Slate) ([]byte, error) {
+	if slate == nil {
+		slate = &Slate{}
+	}
+	args := []string{"-loglevel", "error",
+		"-f", "lavfi", "-i", "color=c=" + cmp.Or(slate.Background, "0x202020") + ":s=" + res + ":r=1"}
+	base, y := "[0:v]", "(h-text_h)/2"
+	if slate.Image != "" {
+		w, h, _ := strings.Cut(res, "x")
+		args = append(args, "-loop", "1", "-i", slate.Image)
+		base = fmt.Sprintf("[1:v]scale=%s:%s:force_original_aspect_ratio=decrease[img];[0:v][img]overlay=(W-w)/2:(H-h)/2:shortest=1,", w, h)
+		if text != "" {
+			y = "h-2*text_h"
+		}
+	}
+
+	filters := []string{base + "null"}
+	if text != "" {
+		text = strings.NewReplacer(`\`, `\\`, `'`, "’", `:`, `\:`, `%`, `\%`).Replace(text)
+		drawtext := fmt.Sprintf("drawtext=text='%s':fontcolor=%s:fontsize=h/14:x=(w-text_w)/2:y=%s",
+			text, cmp.Or(slate.TextColor, "white"), y)
+		filters = []string{base + drawtext, base + "null"}
+	}
+
+	var lastErr error
+	for _, filter := range filters {
+		// Two intra pictures and an end code, so decoders that wait for
+		// the next start code show the first one right away.
+		cmd := exec.Command("ffmpeg", append(args,
+			"-filter_complex", filter,
+			"-frames:v", "2", "-g", "1",
+			"-vcodec", "mpeg1video", "-f", "mpeg1video", "-")...)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err == nil && len(out) > 0 {
+			if !bytes.HasSuffix(out, []byte{0, 0, 1, 0xB7}) {
+				out = append(out, 0, 0, 1, 0xB7)
+			}
+			return out, nil
+		}
+		lastErr = fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil, fmt.Errorf("failed to encode placeholder: %w", lastErr)
+}
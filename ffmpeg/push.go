@@ -0,0 +1,22 @@
+package ffmpeg
+
+import (
+	"os/exec"
+)
+
+// PushCommand transcodes the MPEG-1 stream it reads on stdin to H.264 and
+// publishes it to an RTMP(S) url, e.g. a YouTube or Twitch ingest. A
+// silent audio track is added, as ingests commonly refuse video alone.
+func PushCommand(url, bitrate string) *exec.Cmd {
+	args := []string{
+		"-loglevel", "error", "-fflags", "+genpts",
+		"-f", "mpegvideo", "-i", "pipe:0",
+		"-f", "lavfi", "-i", "anullsrc=channel_layout=stereo:sample_rate=44100",
+		"-map", "0:v", "-map", "1:a",
+		"-c:v", "libx264", "-preset", "veryfast", "-tune", "zerolatency", "-pix_fmt", "yuv420p",
+		"-b:v", bitrate, "-maxrate", bitrate, "-bufsize", bitrate, "-g", "60",
+		"-c:a", "aac", "-b:a", "64k", "-shortest",
+		"-f", "flv", url,
+	}
+	return exec.Command("ffmpeg", args...)
+}
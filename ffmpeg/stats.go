@@ -0,0 +1,13 @@
+package ffmpeg
+
+import "fmt"
+
+// statsFilter returns the drawtext filter showing the statistics in path,
+// or nothing when path is empty. The file is replaced, never rewritten in
+// place, so a frame never catches it half written.
+func statsFilter(path string) string {
+	if path == "" {
+		return ""
+	}
+	return fmt.Sprintf("drawtext=textfile='%s':reload=1:fontcolor=yellow:fontsize=h/40:box=1:boxcolor=black@0.6:boxborderw=4:x=w-text_w-8:y=8", path)
+}
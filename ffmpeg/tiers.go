@@ -0,0 +1,55 @@
+package ffmpeg
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// DefaultTier names the full-quality output, encoded at the pipeline's own
+// resolution and bitrate.
+const DefaultTier = "high"
+
+// Tier is an extra rendition encoded from the same capture, so viewers on
+// slow links can pick a smaller stream.
+type Tier struct {
+	Name    string `json:"name"`
+	Height  int    `json:"height"`
+	Bitrate string `json:"bitrate"`
+}
+
+var tierNameRe = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// ValidateTiers checks tier names are usable in URLs and unique.
+func ValidateTiers(tiers []Tier) error {
+	seen := map[string]bool{DefaultTier: true}
+	for _, t := range tiers {
+		if !tierNameRe.MatchString(t.Name) {
+			return fmt.Errorf("invalid tier name %q", t.Name)
+		}
+		if seen[t.Name] {
+			return fmt.Errorf("duplicate tier %q", t.Name)
+		}
+		seen[t.Name] = true
+		if t.Height <= 0 || t.Bitrate == "" {
+			return fmt.Errorf("tier %q needs a height and a bitrate", t.Name)
+		}
+	}
+	return nil
+}
+
+// tierGraph extends a filter chain ending in the processed capture with
+// one scaled branch per tier, labelled [v0] (full quality) to [vN].
+func tierGraph(chain string, tiers []Tier) string {
+	if len(tiers) == 0 {
+		return chain + "[v0]"
+	}
+	graph := fmt.Sprintf("%s,split=%d", chain, len(tiers)+1)
+	for i := 0; i <= len(tiers); i++ {
+		graph += fmt.Sprintf("[s%d]", i)
+	}
+	graph += ";[s0]null[v0]"
+	for i, t := range tiers {
+		graph += fmt.Sprintf(";[s%d]scale=-2:%d[v%d]", i+1, t.Height, i+1)
+	}
+	return graph
+}
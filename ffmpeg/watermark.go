@@ -0,0 +1,97 @@
+package ffmpeg
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Watermark burns a line of text into every frame, with the wall-clock
+// time the frame was encoded at, for footage kept as evidence or for
+// monitoring. The time is expanded per frame as it passes the filter
+// graph, right after capture, rather than drawn from a clock that ticks
+// separately.
+type Watermark struct {
+	// Text is what is drawn, "{time} {host}" by default: {time} is the
+	// time in TimeFormat, {host} the host's name and {frame} the number
+	// of the frame since the encoder started.
+	Text string `json:"text,omitempty"`
+	// TimeFormat is a strftime format, "%Y-%m-%d %H:%M:%S" by default.
+	TimeFormat string `json:"time_format,omitempty"`
+	// UTC shows the time in UTC rather than the host's time zone.
+	UTC bool `json:"utc,omitempty"`
+	// Position is the corner the text is drawn in: "top-left" (the
+	// default), "top-right", "bottom-left" or "bottom-right".
+	Position string `json:"position,omitempty"`
+	// FontSize is in pixels; by default 1/36 of the picture's height.
+	FontSize int `json:"font_size,omitempty"`
+}
+
+var watermarkPositions = map[string]string{
+	"":             "x=8:y=8",
+	"top-left":     "x=8:y=8",
+	"top-right":    "x=w-text_w-8:y=8",
+	"bottom-left":  "x=8:y=h-text_h-8",
+	"bottom-right": "x=w-text_w-8:y=h-text_h-8",
+}
+
+// Validate checks that w can be drawn.
+func (w *Watermark) Validate() error {
+	if w == nil {
+		return nil
+	}
+	if _, ok := watermarkPositions[w.Position]; !ok {
+		return fmt.Errorf("unknown watermark position %q", w.Position)
+	}
+	if w.FontSize < 0 {
+		return fmt.Errorf("watermark font size must be positive")
+	}
+	if strings.ContainsAny(w.Text+w.TimeFormat, "\n\r") {
+		return fmt.Errorf("watermark text must be a single line")
+	}
+	return nil
+}
+
+// expand turns w's text into drawtext's expansion syntax: text and the
+// time format have their backslashes and percent signs escaped, and the
+// format its colons and braces too, as they end the function's argument.
+func (w *Watermark) expand() string {
+	text := w.Text
+	if text == "" {
+		text = "{time} {host}"
+	}
+	format := w.TimeFormat
+	if format == "" {
+		format = "%Y-%m-%d %H:%M:%S"
+	}
+	fn := "localtime"
+	if w.UTC {
+		fn = "gmtime"
+	}
+	host, _ := os.Hostname()
+	literal := strings.NewReplacer(`\`, `\\`, `%`, `\%`)
+	arg := strings.NewReplacer(`\`, `\\`, `:`, `\:`, `{`, `\{`, `}`, `\}`)
+	return strings.NewReplacer(
+		`\`, `\\`, `%`, `\%`,
+		"{time}", "%{"+fn+":"+arg.Replace(format)+"}",
+		"{host}", literal.Replace(host),
+		"{frame}", "%{frame_num}",
+	).Replace(text)
+}
+
+// filter returns the drawtext filter for w, writing its text to a file
+// named after stream so that no filter graph escaping is needed.
+func (w *Watermark) filter(stream string) (string, error) {
+	path := filepath.Join(os.TempDir(), fmt.Sprintf("remoter-watermark-%s-%d.txt", cmp.Or(stream, "main"), os.Getuid()))
+	if err := os.WriteFile(path, []byte(w.expand()), 0600); err != nil {
+		return "", fmt.Errorf("failed to write watermark text: %w", err)
+	}
+	size := "h/36"
+	if w.FontSize > 0 {
+		size = fmt.Sprint(w.FontSize)
+	}
+	return fmt.Sprintf("drawtext=textfile='%s':fontcolor=white:fontsize=%s:box=1:boxcolor=black@0.5:boxborderw=4:%s",
+		path, size, watermarkPositions[w.Position]), nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/nathfavour/remoter/filesync"
+	"github.com/nathfavour/remoter/zst"
+)
+
+// SyncConfig shares a folder of the host that viewers keep in step with
+// one of their own through "remoter sync".
+type SyncConfig struct {
+	Enabled       bool   `json:"enabled"`
+	Dir           string `json:"dir,omitempty"`             // default Sync/remoter next to the config
+	MaxFileBytes  int64  `json:"max_file_bytes,omitempty"`  // default 100 MiB
+	MaxTotalBytes int64  `json:"max_total_bytes,omitempty"` // default 1 GiB
+}
+
+// syncFolder is set in main when the synced folder is enabled.
+var syncFolder *filesync.Folder
+
+func handleSyncManifest(w http.ResponseWriter, r *http.Request) {
+	if !syncAllowed(w, r) {
+		return
+	}
+	entries, err := syncFolder.Manifest()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if entries == nil {
+		entries = []filesync.Entry{}
+	}
+	// Tells clients they may compress uploads.
+	w.Header().Set("Accept-Encoding", "zstd")
+	writeJSON(w, http.StatusOK, entries)
+}
+
+// handleSyncDownload serves a synced file, with ranges for resuming, or
+// whole and compressed with zstd to clients that accept it.
+func handleSyncDownload(w http.ResponseWriter, r *http.Request) {
+	if !syncAllowed(w, r) {
+		return
+	}
+	p, err := syncFolder.Path(r.PathValue("path"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no synced file %q", r.PathValue("path")))
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no synced file %q", r.PathValue("path")))
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Add("Vary", "Accept-Encoding")
+	if !zst.Accepts(r.Header.Get("Accept-Encoding")) || r.Header.Get("Range") != "" {
+		http.ServeContent(w, r, "", info.ModTime(), f)
+		return
+	}
+	w.Header().Set("Content-Encoding", "zstd")
+	w.Header().Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
+	zw, err := zst.NewWriter(w)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if _, err := io.Copy(zw, f); err != nil {
+		return
+	}
+	zw.Close()
+}
+
+// handleSyncUpload stores one chunk of an upload, the body at ?offset= of
+// a file ?size= bytes long, made from the version whose hash is ?base=
+// ("new" for a new file). The body may be compressed with zstd, with
+// Content-Encoding saying so; ?size= and ?offset= count the file's own
+// bytes either way. It answers with the path the file was stored
+// at once complete, which differs from the one asked for on a conflict.
+func handleSyncUpload(w http.ResponseWriter, r *http.Request) {
+	if !syncAllowed(w, r) {
+		return
+	}
+	q := r.URL.Query()
+	offset, err1 := strconv.ParseInt(q.Get("offset"), 10, 64)
+	size, err2 := strconv.ParseInt(q.Get("size"), 10, 64)
+	if err1 != nil || err2 != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("offset and size are required"))
+		return
+	}
+	var body io.Reader = r.Body
+	switch enc := r.Header.Get("Content-Encoding"); enc {
+	case "", "identity":
+	case "zstd":
+		zr, err := zst.NewReader(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		defer zr.Close()
+		body = zr
+	default:
+		w.Header().Set("Accept-Encoding", "zstd")
+		writeError(w, http.StatusUnsupportedMediaType, fmt.Errorf("unsupported content encoding %q", enc))
+		return
+	}
+	rel := r.PathValue("path")
+	stored, err := syncFolder.WriteChunk(rel, q.Get("base"), offset, size, body)
+	var offErr *filesync.OffsetError
+	switch {
+	case errors.As(err, &offErr):
+		writeJSON(w, http.StatusConflict, map[string]any{"error": err.Error(), "offset": offErr.Offset})
+		return
+	case errors.Is(err, filesync.ErrTooLarge):
+		writeError(w, http.StatusRequestEntityTooLarge, err)
+		return
+	case errors.Is(err, filesync.ErrInvalidPath):
+		writeError(w, http.StatusBadRequest, err)
+		return
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if stored != "" {
+		who := cmp.Or(requestAuth(r).User, r.RemoteAddr)
+		if stored != rel {
+			log.Printf("Sync: %s uploaded %s, which changed on both sides, as %s", who, rel, stored)
+		} else {
+			log.Printf("Sync: %s uploaded %s", who, stored)
+		}
+		emit(eventSyncUpdated, map[string]string{"path": stored, "user": requestAuth(r).User})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"path": stored, "complete": stored != ""})
+}
+
+// handleSyncDelete deletes a synced file if it is still the version whose
+// hash is ?base=.
+func handleSyncDelete(w http.ResponseWriter, r *http.Request) {
+	if !syncAllowed(w, r) {
+		return
+	}
+	rel := r.PathValue("path")
+	err := syncFolder.Remove(rel, r.URL.Query().Get("base"))
+	var conflict *filesync.ConflictError
+	switch {
+	case errors.As(err, &conflict):
+		writeError(w, http.StatusConflict, err)
+		return
+	case errors.Is(err, filesync.ErrInvalidPath):
+		writeError(w, http.StatusBadRequest, err)
+		return
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	log.Printf("Sync: %s deleted %s", cmp.Or(requestAuth(r).User, r.RemoteAddr), rel)
+	emit(eventSyncUpdated, map[string]string{"path": rel, "user": requestAuth(r).User, "deleted": "true"})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// syncAllowed answers requests while the synced folder is disabled, or
+// from anyone but an admin.
+func syncAllowed(w http.ResponseWriter, r *http.Request) bool {
+	if syncFolder == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("the synced folder is not enabled"))
+		return false
+	}
+	return requireAdmin(w, r)
+}
@@ -0,0 +1,341 @@
+package filesync
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nathfavour/remoter/zst"
+)
+
+// ChunkSize is how much of a file one upload request carries.
+const ChunkSize = 4 << 20
+
+// stateFile records, inside the local folder, the hash of every file as
+// last synced: the base that tells which side changed it since.
+const stateFile = ".remoter-sync.json"
+
+// Client is the viewer side of a synced folder: it keeps a local
+// directory in step with a remoter's through its API.
+type Client struct {
+	Server   string // e.g. http://host:8080
+	User     string
+	Password string
+	Dir      string
+	HTTP     *http.Client
+	// Logf reports what each round did.
+	Logf func(format string, args ...any)
+
+	cache map[string]hashed
+	// zstd is set once the server has said it takes compressed uploads.
+	zstd bool
+}
+
+// Sync runs one round: files changed on one side since the last round are
+// copied to the other, and deletions follow likewise. A file changed on
+// both sides keeps the host's version under its name, and the local one
+// is uploaded beside it under a conflict name.
+func (c *Client) Sync() error {
+	if c.cache == nil {
+		c.cache = make(map[string]hashed)
+	}
+	if err := os.MkdirAll(c.Dir, 0700); err != nil {
+		return err
+	}
+	state := map[string]string{}
+	statePath := filepath.Join(c.Dir, stateFile)
+	if data, err := os.ReadFile(statePath); err == nil {
+		if err := json.Unmarshal(data, &state); err != nil {
+			return fmt.Errorf("failed to read %s: %w", statePath, err)
+		}
+	}
+
+	localList, err := scan(c.Dir, c.cache)
+	if err != nil {
+		return fmt.Errorf("failed to scan %s: %w", c.Dir, err)
+	}
+	var remoteList []Entry
+	if err := c.call(http.MethodGet, "/api/v1/sync", nil, "", nil, &remoteList); err != nil {
+		return err
+	}
+	local := make(map[string]string)
+	remote := make(map[string]string)
+	paths := make(map[string]bool)
+	for _, e := range localList {
+		local[e.Path] = e.Hash
+		paths[e.Path] = true
+	}
+	for _, e := range remoteList {
+		remote[e.Path] = e.Hash
+		paths[e.Path] = true
+	}
+	for p := range state {
+		paths[p] = true
+	}
+	sorted := make([]string, 0, len(paths))
+	for p := range paths {
+		sorted = append(sorted, p)
+	}
+	sort.Strings(sorted)
+
+	var errs []string
+	for _, p := range sorted {
+		if err := c.syncFile(p, local[p], remote[p], state); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err == nil {
+		err = os.WriteFile(statePath, data, 0600)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save sync state: %w", err)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// syncFile reconciles one path given its local, remote and base hashes,
+// "" where it doesn't exist.
+func (c *Client) syncFile(p, l, r string, state map[string]string) error {
+	if _, err := resolve(c.Dir, p); err != nil {
+		return nil
+	}
+	base, known := state[p]
+	uploadBase := base
+	if !known {
+		uploadBase = NewBase
+	}
+	switch {
+	case l == r:
+	case l == base && known || l == "" && !known:
+		// Changed on the host only.
+		if r == "" {
+			if err := os.Remove(filepath.Join(c.Dir, filepath.FromSlash(p))); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			c.logf("Deleted %s", p)
+		} else if err := c.download(p, r); err != nil {
+			return err
+		}
+	case r == base && known || r == "" && !known:
+		// Changed here only.
+		if l == "" {
+			if err := c.call(http.MethodDelete, c.filePath(p), url.Values{"base": {base}}, "", nil, nil); err != nil {
+				return err
+			}
+			c.logf("Deleted %s on the host", p)
+		} else if err := c.upload(p, uploadBase); err != nil {
+			return err
+		}
+	case l == "":
+		// Deleted here, changed on the host: keep the change.
+		if err := c.download(p, r); err != nil {
+			return err
+		}
+	case r == "":
+		// Changed here, deleted on the host: keep the change.
+		if err := c.upload(p, NewBase); err != nil {
+			return err
+		}
+	default:
+		// Both changed: the host's version wins the name.
+		if err := c.upload(p, uploadBase); err != nil {
+			return err
+		}
+		if err := c.download(p, r); err != nil {
+			return err
+		}
+	}
+	// The local file is now what both sides agree on, or what the next
+	// round will find changed here.
+	if h := c.current(p); h != "" {
+		state[p] = h
+	} else {
+		delete(state, p)
+	}
+	return nil
+}
+
+func (c *Client) logf(format string, args ...any) {
+	if c.Logf != nil {
+		c.Logf(format, args...)
+	}
+}
+
+// current is the local hash of p, "" if it doesn't exist.
+func (c *Client) current(p string) string {
+	h, err := hashFile(filepath.Join(c.Dir, filepath.FromSlash(p)))
+	if err != nil {
+		return ""
+	}
+	return h
+}
+
+func (c *Client) filePath(p string) string {
+	parts := strings.Split(p, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return "/api/v1/sync/files/" + strings.Join(parts, "/")
+}
+
+// download fetches p, expected to hash to want, into the local folder.
+func (c *Client) download(p, want string) error {
+	dst := filepath.Join(c.Dir, filepath.FromSlash(p))
+	tmp := filepath.Join(c.Dir, stagingDir, filepath.Base(dst)+".download")
+	if err := os.MkdirAll(filepath.Dir(tmp), 0700); err != nil {
+		return err
+	}
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	err = c.call(http.MethodGet, c.filePath(p), nil, "", nil, file)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if h, err := hashFile(tmp); err != nil || h != want {
+		// Changed again while downloading; the next round gets it.
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		return err
+	}
+	c.logf("Downloaded %s", p)
+	return nil
+}
+
+// upload sends p in chunks, made from the version hashing to base.
+func (c *Client) upload(p, base string) error {
+	file, err := os.Open(filepath.Join(c.Dir, filepath.FromSlash(p)))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	total := info.Size()
+	buf := make([]byte, ChunkSize)
+	var offset int64
+	for {
+		n, err := io.ReadFull(file, buf)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return err
+		}
+		q := url.Values{
+			"offset": {strconv.FormatInt(offset, 10)},
+			"size":   {strconv.FormatInt(total, 10)},
+			"base":   {base},
+		}
+		var resp struct {
+			Path string `json:"path"`
+		}
+		body, encoding := c.chunkBody(buf[:n])
+		if err := c.call(http.MethodPut, c.filePath(p), q, encoding, body, &resp); err != nil {
+			return err
+		}
+		offset += int64(n)
+		if resp.Path != "" {
+			if resp.Path != p {
+				c.logf("Conflict: %s changed on both sides, uploaded this side's as %s", p, resp.Path)
+			} else {
+				c.logf("Uploaded %s", p)
+			}
+			return nil
+		}
+		if n == 0 || offset >= total {
+			return fmt.Errorf("%s changed while uploading", p)
+		}
+	}
+}
+
+// chunkBody returns the body of an upload request carrying chunk, and its
+// Content-Encoding: compressed with zstd when the server takes that and
+// it saves anything.
+func (c *Client) chunkBody(chunk []byte) (io.Reader, string) {
+	if c.zstd && len(chunk) >= zst.MinSize {
+		if z := zst.Encode(chunk); len(z) < len(chunk) {
+			return bytes.NewReader(z), "zstd"
+		}
+	}
+	return bytes.NewReader(chunk), ""
+}
+
+// call makes an API request; out is decoded from JSON, or receives the
+// body if it is a writer.
+func (c *Client) call(method, p string, q url.Values, encoding string, body io.Reader, out any) error {
+	u := strings.TrimSuffix(c.Server, "/") + p
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return err
+	}
+	if c.User != "" {
+		req.SetBasicAuth(c.User, c.Password)
+	}
+	if encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
+	}
+	req.Header.Set("Accept-Encoding", "zstd")
+	httpClient := c.HTTP
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach %s: %w", c.Server, err)
+	}
+	defer resp.Body.Close()
+	if zst.Accepts(resp.Header.Get("Accept-Encoding")) {
+		c.zstd = true
+	}
+	var respBody io.Reader = resp.Body
+	if resp.Header.Get("Content-Encoding") == "zstd" {
+		zr, err := zst.NewReader(resp.Body)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		respBody = zr
+	}
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(respBody).Decode(&apiErr) == nil && apiErr.Error != "" {
+			return fmt.Errorf("%s %s: %s", method, p, apiErr.Error)
+		}
+		return fmt.Errorf("%s %s: %s", method, p, resp.Status)
+	}
+	switch out := out.(type) {
+	case nil:
+	case io.Writer:
+		_, err = io.Copy(out, respBody)
+	default:
+		err = json.NewDecoder(respBody).Decode(out)
+	}
+	return err
+}
@@ -0,0 +1,320 @@
+package filesync
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// stagingDir holds partial uploads inside the folder; it and anything
+// else named .remoter-* is never synced.
+const stagingDir = ".remoter-parts"
+
+// NewBase is the base of an upload that creates a file.
+const NewBase = "new"
+
+var (
+	ErrInvalidPath = errors.New("invalid path")
+	ErrTooLarge    = errors.New("file exceeds the sync size limits")
+)
+
+// OffsetError is returned for a chunk that doesn't continue the partial
+// upload; the upload resumes at Offset.
+type OffsetError struct {
+	Offset int64
+}
+
+func (e *OffsetError) Error() string {
+	return fmt.Sprintf("upload continues at byte %d", e.Offset)
+}
+
+// ConflictError is returned when a change was based on a version of the
+// file other than the folder's.
+type ConflictError struct {
+	Path string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%s changed on both sides", e.Path)
+}
+
+// Entry is a file in a synced folder. Paths are slash-separated and
+// relative to the folder.
+type Entry struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Hash    string    `json:"hash"` // SHA-256, hex
+}
+
+type hashed struct {
+	size    int64
+	modTime time.Time
+	hash    string
+}
+
+// Folder is the host side of a synced folder.
+type Folder struct {
+	dir      string
+	maxFile  int64
+	maxTotal int64
+
+	mu     sync.Mutex
+	hashes map[string]hashed // by path, while size and mtime stay the same
+}
+
+// NewFolder serves dir, creating it if needed. maxFile and maxTotal limit
+// a file's size and the folder's; zero means unlimited.
+func NewFolder(dir string, maxFile, maxTotal int64) (*Folder, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create sync folder: %w", err)
+	}
+	return &Folder{dir: dir, maxFile: maxFile, maxTotal: maxTotal, hashes: make(map[string]hashed)}, nil
+}
+
+func (f *Folder) Dir() string {
+	return f.dir
+}
+
+// ignored reports whether rel is left out of syncing.
+func ignored(rel string) bool {
+	for _, part := range strings.Split(rel, "/") {
+		if strings.HasPrefix(part, ".remoter-") {
+			return true
+		}
+	}
+	return false
+}
+
+// Path returns the file rel names, refusing paths that leave the folder or
+// aren't synced.
+func (f *Folder) Path(rel string) (string, error) {
+	return resolve(f.dir, rel)
+}
+
+func resolve(dir, rel string) (string, error) {
+	if rel == "" || strings.Contains(rel, "\\") || path.IsAbs(rel) || path.Clean(rel) != rel ||
+		rel == ".." || strings.HasPrefix(rel, "../") || ignored(rel) {
+		return "", ErrInvalidPath
+	}
+	return filepath.Join(dir, filepath.FromSlash(rel)), nil
+}
+
+// Manifest lists the folder's files.
+func (f *Folder) Manifest() ([]Entry, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	entries, err := scan(f.dir, f.hashes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan sync folder: %w", err)
+	}
+	return entries, nil
+}
+
+// scan lists the regular files under dir, hashing those not in cache with
+// the same size and mtime. Symlinks are skipped.
+func scan(dir string, cache map[string]hashed) ([]Entry, error) {
+	var entries []Entry
+	seen := make(map[string]bool)
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, p)
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			return nil
+		}
+		if ignored(rel) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil // removed meanwhile
+		}
+		h, ok := cache[rel]
+		if !ok || h.size != info.Size() || !h.modTime.Equal(info.ModTime()) {
+			sum, err := hashFile(p)
+			if err != nil {
+				return nil
+			}
+			h = hashed{info.Size(), info.ModTime(), sum}
+			cache[rel] = h
+		}
+		seen[rel] = true
+		entries = append(entries, Entry{Path: rel, Size: h.size, ModTime: h.modTime, Hash: h.hash})
+		return nil
+	})
+	for rel := range cache {
+		if !seen[rel] {
+			delete(cache, rel)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	return entries, err
+}
+
+func hashFile(p string) (string, error) {
+	file, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// current returns the hash of rel as it is now, "" if it doesn't exist.
+// f.mu must be held.
+func (f *Folder) current(rel string) (string, error) {
+	p, err := f.Path(rel)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(p); errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	return hashFile(p)
+}
+
+// partPath is where the partial upload of rel is staged.
+func (f *Folder) partPath(rel string) string {
+	sum := sha256.Sum256([]byte(rel))
+	return filepath.Join(f.dir, stagingDir, hex.EncodeToString(sum[:8])+".part")
+}
+
+// WriteChunk adds the bytes of r at offset to the upload of rel, which is
+// total bytes long. Chunks must come in order; offset 0 starts over. Once
+// complete, the file replaces rel if base, the hash of the version the
+// upload was made from (NewBase for a new file, "" for any), still
+// matches; otherwise it is kept beside rel under a conflict name. It
+// returns the path the file was stored at, or "" while incomplete.
+func (f *Folder) WriteChunk(rel, base string, offset, total int64, r io.Reader) (string, error) {
+	dst, err := f.Path(rel)
+	if err != nil {
+		return "", err
+	}
+	if total < 0 || offset < 0 || offset > total {
+		return "", fmt.Errorf("invalid range")
+	}
+	if f.maxFile > 0 && total > f.maxFile {
+		return "", ErrTooLarge
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.maxTotal > 0 && offset == 0 {
+		if _, err := scan(f.dir, f.hashes); err != nil {
+			return "", fmt.Errorf("failed to scan sync folder: %w", err)
+		}
+		var used int64
+		for p, h := range f.hashes {
+			if p != rel {
+				used += h.size
+			}
+		}
+		if used+total > f.maxTotal {
+			return "", ErrTooLarge
+		}
+	}
+
+	part := f.partPath(rel)
+	if err := os.MkdirAll(filepath.Dir(part), 0700); err != nil {
+		return "", err
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if offset == 0 {
+		flags |= os.O_TRUNC
+	} else if info, err := os.Stat(part); err != nil || info.Size() != offset {
+		var size int64
+		if err == nil {
+			size = info.Size()
+		}
+		return "", &OffsetError{Offset: size}
+	}
+	file, err := os.OpenFile(part, flags, 0600)
+	if err != nil {
+		return "", err
+	}
+	n, err := io.Copy(file, io.LimitReader(r, total-offset))
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to store chunk: %w", err)
+	}
+	if offset+n < total {
+		return "", nil
+	}
+
+	if base != "" {
+		have, err := f.current(rel)
+		if err != nil {
+			return "", err
+		}
+		if (base == NewBase && have != "") || (base != NewBase && have != base) {
+			rel = conflictName(rel, time.Now())
+			if dst, err = f.Path(rel); err != nil {
+				return "", err
+			}
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+		return "", err
+	}
+	if err := os.Rename(part, dst); err != nil {
+		return "", fmt.Errorf("failed to store %s: %w", rel, err)
+	}
+	return rel, nil
+}
+
+// Remove deletes rel if base is still its hash.
+func (f *Folder) Remove(rel, base string) error {
+	p, err := f.Path(rel)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	have, err := f.current(rel)
+	if err != nil {
+		return err
+	}
+	if have == "" {
+		return nil
+	}
+	if base != "" && have != base {
+		return &ConflictError{Path: rel}
+	}
+	if err := os.Remove(p); err != nil {
+		return fmt.Errorf("failed to delete %s: %w", rel, err)
+	}
+	delete(f.hashes, rel)
+	return nil
+}
+
+// conflictName is where a conflicting version of rel is kept: beside it,
+// e.g. "notes (conflict 2006-01-02 150405).txt".
+func conflictName(rel string, t time.Time) string {
+	ext := path.Ext(rel)
+	return fmt.Sprintf("%s (conflict %s)%s", strings.TrimSuffix(rel, ext), t.Format("2006-01-02 150405"), ext)
+}
@@ -0,0 +1,57 @@
+// Package framesum puts video messages in a small envelope carrying
+// their sequence number and checksum, so that viewers can tell when a
+// proxy, relay or the packetization layer mangled what the server sent.
+package framesum
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+)
+
+// HeaderSize is how many bytes the envelope adds in front of every video
+// message: its sequence number and the CRC-32C of the payload, both big
+// endian.
+const HeaderSize = 8
+
+var table = crc32.MakeTable(crc32.Castagnoli)
+
+// Sum is the CRC-32C of payload.
+func Sum(payload []byte) uint32 {
+	return crc32.Checksum(payload, table)
+}
+
+// Header returns the envelope of payload, the seq'th message of a stream.
+func Header(seq uint32, payload []byte) [HeaderSize]byte {
+	var h [HeaderSize]byte
+	binary.BigEndian.PutUint32(h[:4], seq)
+	binary.BigEndian.PutUint32(h[4:], Sum(payload))
+	return h
+}
+
+// Mismatch describes a message whose payload does not match its envelope.
+type Mismatch struct {
+	Seq      uint32 `json:"seq"`
+	Sent     uint32 `json:"sent"`
+	Computed uint32 `json:"computed"`
+	Size     int    `json:"size"`
+}
+
+func (m *Mismatch) Error() string {
+	return fmt.Sprintf("message %d of %d bytes has checksum %08x, sent as %08x", m.Seq, m.Size, m.Computed, m.Sent)
+}
+
+// Open splits a message into its sequence number and payload, checking
+// the payload. A corrupt message returns its payload with a *Mismatch.
+func Open(msg []byte) (uint32, []byte, error) {
+	if len(msg) < HeaderSize {
+		return 0, nil, fmt.Errorf("message of %d bytes is too short for its envelope", len(msg))
+	}
+	seq := binary.BigEndian.Uint32(msg[:4])
+	sent := binary.BigEndian.Uint32(msg[4:HeaderSize])
+	payload := msg[HeaderSize:]
+	if sum := Sum(payload); sum != sent {
+		return seq, payload, &Mismatch{Seq: seq, Sent: sent, Computed: sum, Size: len(payload)}
+	}
+	return seq, payload, nil
+}
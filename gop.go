@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+)
+
+// maxGOPBytes bounds the cached group of pictures. A GOP that outgrows it
+// is dropped and the cache waits for the next keyframe.
+const maxGOPBytes = 4 << 20
+
+// mpegSequenceHeader starts every keyframe ffmpeg's mpeg1video encoder
+// emits, so decoding can begin there.
+var mpegSequenceHeader = []byte{0x00, 0x00, 0x01, 0xB3}
+
+// gopCache holds a stream's data from its latest keyframe onward, replayed
+// to new viewers so they don't wait for the next keyframe to see video.
+// It is owned by the hub goroutine.
+type gopCache struct {
+	buf    []byte
+	synced bool   // buf starts at a sequence header
+	ready  bool   // a keyframe arrived since the last reset
+	tail   []byte // last bytes of the previous chunk, for split start codes
+	edge   []byte // scratch space joining tail to the next chunk's head
+}
+
+// add appends chunk, restarting the cache at the last keyframe in it.
+func (g *gopCache) add(chunk []byte) {
+	keep := len(mpegSequenceHeader) - 1
+	g.edge = append(append(g.edge[:0], g.tail...), chunk[:min(len(chunk), keep)]...)
+
+	if i := bytes.LastIndex(chunk, mpegSequenceHeader); i >= 0 {
+		g.buf = append(g.buf[:0], chunk[i:]...)
+		g.synced = true
+	} else if i := bytes.LastIndex(g.edge, mpegSequenceHeader); i >= 0 {
+		g.buf = append(append(g.buf[:0], g.tail[i:]...), chunk...)
+		g.synced = true
+	} else if g.synced {
+		g.buf = append(g.buf, chunk...)
+	}
+	g.ready = g.ready || g.synced
+	if len(g.buf) > maxGOPBytes {
+		g.buf, g.synced = g.buf[:0], false
+	}
+
+	if len(chunk) >= keep {
+		g.tail = append(g.tail[:0], chunk[len(chunk)-keep:]...)
+	} else {
+		g.tail = append(g.tail[:0], g.edge[max(len(g.edge)-keep, 0):]...)
+	}
+}
+
+// reset forgets the cached GOP, e.g. when the encoder restarts with
+// different settings.
+func (g *gopCache) reset() {
+	g.buf, g.synced, g.ready, g.tail = g.buf[:0], false, false, g.tail[:0]
+}
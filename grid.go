@@ -0,0 +1,213 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nathfavour/remoter/ffmpeg"
+	"github.com/nathfavour/remoter/i18n"
+)
+
+// GridConfig composites several screens into one stream, watched like a
+// session at /s/{name}/, for NOC-style wall displays.
+type GridConfig struct {
+	Name    string             `json:"name"`
+	Columns int                `json:"columns,omitempty"`
+	Cell    string             `json:"cell,omitempty"` // "WxH", default 640x360
+	Sources []GridSourceConfig `json:"sources"`
+}
+
+// GridSourceConfig is one cell of a grid: a monitor (a region of an X
+// display), a stream of this remoter ("main" or a session ID), or the URL
+// of one elsewhere, e.g. another remoter's /live.
+type GridSourceConfig struct {
+	Label   string         `json:"label,omitempty"`
+	Display string         `json:"display,omitempty"`
+	Capture *ffmpeg.Region `json:"capture,omitempty"`
+	Stream  string         `json:"stream,omitempty"`
+	URL     string         `json:"url,omitempty"`
+}
+
+// gridRetryDelay is how long a grid waits to restart after ffmpeg exits,
+// e.g. because a session or remote stream it shows went away.
+const gridRetryDelay = 5 * time.Second
+
+var (
+	gridEncoders    = make(map[string]*ffmpeg.Encoder)
+	gridEncodersMux sync.Mutex
+	// gridToken admits the grid encoders to the streams they composite,
+	// like a cast device to its stream.
+	gridToken string
+)
+
+var gridNameRe = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+func validateGrids(grids []GridConfig) error {
+	seen := make(map[string]bool)
+	for _, g := range grids {
+		if !gridNameRe.MatchString(g.Name) || g.Name == "main" {
+			return fmt.Errorf("invalid grid name %q", g.Name)
+		}
+		if seen[g.Name] {
+			return fmt.Errorf("duplicate grid %q", g.Name)
+		}
+		seen[g.Name] = true
+		for i, s := range g.Sources {
+			n := 0
+			for _, set := range []bool{s.Display != "", s.Stream != "", s.URL != ""} {
+				if set {
+					n++
+				}
+			}
+			if n != 1 {
+				return fmt.Errorf("grid %s: source %d needs exactly one of a display, stream or URL", g.Name, i)
+			}
+		}
+		if err := ffmpeg.ValidateGrid(gridSettings(g, 0)); err != nil {
+			return fmt.Errorf("grid %s: %w", g.Name, err)
+		}
+	}
+	return nil
+}
+
+// gridSettings resolves streams of this remoter to the feed URLs the
+// encoder reads them from.
+func gridSettings(g GridConfig, port int) ffmpeg.Grid {
+	grid := ffmpeg.Grid{Columns: g.Columns, Cell: g.Cell}
+	for _, s := range g.Sources {
+		src := ffmpeg.GridSource{Label: s.Label, Display: s.Display, Capture: s.Capture, URL: s.URL}
+		if s.Stream != "" {
+			src.URL = fmt.Sprintf("http://127.0.0.1:%d/grid/%s/%s", port, gridToken, s.Stream)
+		}
+		grid.Sources = append(grid.Sources, src)
+	}
+	return grid
+}
+
+func startGrids(grids []GridConfig, port int) {
+	if len(grids) == 0 {
+		return
+	}
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		log.Printf("Warning: grids unavailable: %v", err)
+		return
+	}
+	gridToken = hex.EncodeToString(token)
+
+	base := services.encoder.Settings()
+	for _, g := range grids {
+		grid := gridSettings(g, port)
+		enc := ffmpeg.NewEncoder(ffmpeg.Settings{
+			Ingest:     base.Ingest,
+			Framerate:  base.Framerate,
+			Bitrate:    base.Bitrate,
+			HideCursor: base.HideCursor,
+			Stream:     g.Name,
+			Grid:       &grid,
+		})
+		name := g.Name
+		enc.OnExit(func(err error) {
+			log.Printf("Grid %s stopped (%v), restarting in %s", name, err, gridRetryDelay)
+			time.AfterFunc(gridRetryDelay, func() {
+				gridEncodersMux.Lock()
+				_, ok := gridEncoders[name]
+				gridEncodersMux.Unlock()
+				if !ok {
+					return
+				}
+				if err := enc.Start(); err != nil {
+					log.Printf("Failed to restart grid %s: %v", name, err)
+				}
+			})
+		})
+		gridEncodersMux.Lock()
+		gridEncoders[name] = enc
+		gridEncodersMux.Unlock()
+		if err := enc.Start(); err != nil {
+			log.Printf("Failed to start grid %s: %v", name, err)
+			continue
+		}
+		log.Printf("Grid %s composites %d sources at /s/%s/", name, len(g.Sources), name)
+	}
+}
+
+func stopGrids() {
+	gridEncodersMux.Lock()
+	encoders := gridEncoders
+	gridEncoders = make(map[string]*ffmpeg.Encoder)
+	gridEncodersMux.Unlock()
+	for name, enc := range encoders {
+		_ = enc.Stop()
+		kickStream(name)
+	}
+}
+
+func gridExists(name string) bool {
+	gridEncodersMux.Lock()
+	defer gridEncodersMux.Unlock()
+	_, ok := gridEncoders[name]
+	return ok
+}
+
+func gridNames() []string {
+	gridEncodersMux.Lock()
+	defer gridEncodersMux.Unlock()
+	names := make([]string, 0, len(gridEncoders))
+	for name := range gridEncoders {
+		names = append(names, name)
+	}
+	return names
+}
+
+// gridAccess admits a grid encoder fetching one of its sources.
+func gridAccess(r *http.Request) bool {
+	rest, ok := strings.CutPrefix(r.URL.Path, "/grid/")
+	if !ok || gridToken == "" {
+		return false
+	}
+	token, _, _ := strings.Cut(rest, "/")
+	return token == gridToken
+}
+
+// handleGridFeed serves a stream to the grid encoder compositing it.
+func handleGridFeed(w http.ResponseWriter, r *http.Request) {
+	if !gridAccess(r) {
+		http.NotFound(w, r)
+		return
+	}
+	stream := r.PathValue("stream")
+	if stream == "main" {
+		stream = ""
+	}
+	if !streamExists(stream) {
+		i18n.Error(w, r, http.StatusNotFound, "no_such_session")
+		return
+	}
+
+	w.Header().Set("Content-Type", "video/mpeg")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	c := newHTTPClient(w, r)
+	c.transport = transportGrid
+	c.stream = stream
+	totalClients := addClient(c)
+	log.Printf("Grid feed of %s connected. Total clients: %d", c.describe(), totalClients)
+
+	select {
+	case <-r.Context().Done():
+		c.close()
+	case <-c.done:
+	}
+
+	totalClients = removeClient(c)
+	log.Printf("Grid feed of %s disconnected. Total clients: %d", c.describe(), totalClients)
+}
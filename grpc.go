@@ -0,0 +1,335 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/nathfavour/remoter/grpcapi"
+	"github.com/nathfavour/remoter/session"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// GRPCConfig serves the gRPC API described in grpcapi/remoter.proto.
+type GRPCConfig struct {
+	// Listen is the address to serve on, e.g. "127.0.0.1:9090".
+	Listen string `json:"listen"`
+	// CertFile and KeyFile serve over TLS; without them calls, and the
+	// passwords they carry, travel in the clear.
+	CertFile string `json:"cert_file,omitempty"`
+	KeyFile  string `json:"key_file,omitempty"`
+}
+
+// grpcAuthKey holds the authInfo of a call in its context.
+type grpcAuthKey struct{}
+
+// serveGRPC starts the gRPC API, behind the same IP filter, API rate
+// limit and logins as the REST API.
+func serveGRPC(gc *GRPCConfig, auth *authenticator, filter *ipFilter, limits *rateLimits) error {
+	var opts []grpc.ServerOption
+	if gc.CertFile != "" || gc.KeyFile != "" {
+		creds, err := credentials.NewServerTLSFromFile(gc.CertFile, gc.KeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load gRPC certificate: %w", err)
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+	opts = append(opts,
+		grpc.UnaryInterceptor(func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			if err := admitGRPC(ctx, filter, limits); err != nil {
+				return nil, err
+			}
+			a, err := auth.checkGRPC(ctx, info.FullMethod)
+			if err != nil {
+				return nil, err
+			}
+			return handler(context.WithValue(ctx, grpcAuthKey{}, a), req)
+		}),
+		grpc.StreamInterceptor(func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := admitGRPC(ss.Context(), filter, limits); err != nil {
+				return err
+			}
+			a, err := auth.checkGRPC(ss.Context(), info.FullMethod)
+			if err != nil {
+				return err
+			}
+			return handler(srv, &authedStream{ss, context.WithValue(ss.Context(), grpcAuthKey{}, a)})
+		}),
+	)
+
+	ln, err := net.Listen("tcp", gc.Listen)
+	if err != nil {
+		return fmt.Errorf("failed to listen for gRPC: %w", err)
+	}
+	srv := grpc.NewServer(opts...)
+	grpcapi.RegisterRemoterServer(srv, &grpcServer{})
+	log.Printf("Serving the gRPC API on %s", ln.Addr())
+	go func() {
+		if err := srv.Serve(ln); err != nil {
+			log.Printf("gRPC server error: %v", err)
+		}
+	}()
+	return nil
+}
+
+// admitGRPC applies the IP filter and the API rate limit to a call
+// before its credentials are looked at, as for REST requests.
+func admitGRPC(ctx context.Context, filter *ipFilter, limits *rateLimits) error {
+	ip := remoteIP(&http.Request{RemoteAddr: grpcPeer(ctx)})
+	if ip == nil || !filter.permits(ip) {
+		log.Printf("Rejected gRPC call from %s", grpcPeer(ctx))
+		return status.Error(codes.PermissionDenied, "forbidden")
+	}
+	if limits.api != nil {
+		if ok, wait := limits.api.allow(ip.String()); !ok {
+			log.Printf("Rate limited %s on gRPC", ip)
+			return status.Errorf(codes.ResourceExhausted, "too many requests, retry in %s", wait.Round(time.Second))
+		}
+	}
+	return nil
+}
+
+type authedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authedStream) Context() context.Context {
+	return s.ctx
+}
+
+// checkGRPC authenticates a call by its "authorization" metadata, which
+// holds what the header of a REST request would. Only admins may call.
+func (a *authenticator) checkGRPC(ctx context.Context, method string) (authInfo, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	r := &http.Request{
+		Method:     http.MethodPost,
+		URL:        &url.URL{Path: method},
+		Header:     http.Header{},
+		RemoteAddr: grpcPeer(ctx),
+	}
+	if v := md.Get("authorization"); len(v) > 0 {
+		r.Header.Set("Authorization", v[0])
+	}
+
+	var info authInfo
+	if k, err := clientKeys.authenticate(r); err != nil {
+		log.Printf("Rejected key signature on gRPC from %s: %v", r.RemoteAddr, err)
+		emit(eventAuthFailed, map[string]any{"method": "key", "remote_addr": r.RemoteAddr, "error": err.Error()})
+		return info, status.Error(codes.Unauthenticated, "bad key signature")
+	} else if k != nil {
+		info = authInfo{User: k.User, Role: k.Role, Key: k.ID}
+	} else if a.off() {
+		return info, nil
+	} else if username, password, ok := r.BasicAuth(); ok && a.check(username, password) {
+		info = authInfo{User: username, Role: basicAuthRole(username)}
+	} else {
+		if ok {
+			log.Printf("Rejected gRPC credentials for %q from %s", username, r.RemoteAddr)
+			emit(eventAuthFailed, map[string]any{"user": username, "method": "grpc", "remote_addr": r.RemoteAddr})
+		}
+		return info, status.Error(codes.Unauthenticated, "a login is required")
+	}
+	if info.Role != "admin" {
+		return info, status.Error(codes.PermissionDenied, "only admins may use the gRPC API")
+	}
+	return info, nil
+}
+
+func grpcPeer(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok {
+		return p.Addr.String()
+	}
+	return ""
+}
+
+func grpcAuth(ctx context.Context) authInfo {
+	a, _ := ctx.Value(grpcAuthKey{}).(authInfo)
+	return a
+}
+
+type grpcServer struct {
+	grpcapi.UnimplementedRemoterServer
+}
+
+func grpcStatus() *grpcapi.Status {
+	pub := currentPublicStatus()
+	st := services.encoder.Status()
+	return &grpcapi.Status{
+		State:         pub.State,
+		Viewers:       int32(pub.Viewers),
+		UptimeSeconds: pub.Uptime,
+		Display:       st.Display,
+		Res:           st.Res,
+		Framerate:     int32(st.Framerate),
+		Bitrate:       st.Bitrate,
+		Speed:         st.Speed,
+		LastError:     st.LastError,
+	}
+}
+
+func (s *grpcServer) GetStatus(ctx context.Context, req *grpcapi.GetStatusRequest) (*grpcapi.Status, error) {
+	return grpcStatus(), nil
+}
+
+func (s *grpcServer) PauseStream(ctx context.Context, req *grpcapi.PauseStreamRequest) (*grpcapi.Status, error) {
+	pauseStream("stream_paused")
+	return grpcStatus(), nil
+}
+
+func (s *grpcServer) ResumeStream(ctx context.Context, req *grpcapi.ResumeStreamRequest) (*grpcapi.Status, error) {
+	if screenLocked.Load() {
+		return nil, status.Error(codes.FailedPrecondition, "the screen is locked; the stream resumes when it is unlocked")
+	}
+	resumeStream()
+	return grpcStatus(), nil
+}
+
+func grpcSession(info session.Info) *grpcapi.Session {
+	s := &grpcapi.Session{
+		Id:          info.ID,
+		Template:    info.Template,
+		Title:       info.Title,
+		Description: info.Description,
+		Backend:     info.Backend,
+		Display:     info.Display,
+		Res:         info.Res,
+		User:        info.User,
+		CreatedAt:   timestamppb.New(info.CreatedAt),
+	}
+	if !info.ExpiresAt.IsZero() {
+		s.ExpiresAt = timestamppb.New(info.ExpiresAt)
+	}
+	return s
+}
+
+func (s *grpcServer) ListSessions(ctx context.Context, req *grpcapi.ListSessionsRequest) (*grpcapi.ListSessionsResponse, error) {
+	resp := &grpcapi.ListSessionsResponse{}
+	for _, info := range sessions.List() {
+		resp.Sessions = append(resp.Sessions, grpcSession(info))
+	}
+	return resp, nil
+}
+
+func (s *grpcServer) CreateSession(ctx context.Context, req *grpcapi.CreateSessionRequest) (*grpcapi.Session, error) {
+	if req.Template == "" {
+		return nil, status.Error(codes.InvalidArgument, "template is required")
+	}
+	owner := grpcAuth(ctx).User
+	if err := quotas.admitSession(owner); err != nil {
+		return nil, status.Errorf(codes.ResourceExhausted, "quota exceeded: %v", err)
+	}
+	var title, description *string
+	if req.Title != "" {
+		title = &req.Title
+	}
+	if req.Description != "" {
+		description = &req.Description
+	}
+	info, err := createSession(owner, req.Template, req.User, req.Password, title, description)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return grpcSession(info), nil
+}
+
+func (s *grpcServer) DestroySession(ctx context.Context, req *grpcapi.DestroySessionRequest) (*grpcapi.DestroySessionResponse, error) {
+	if err := destroySession(req.Id); err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	return &grpcapi.DestroySessionResponse{}, nil
+}
+
+func grpcStats() *grpcapi.Stats {
+	stats := &grpcapi.Stats{
+		Time:         timestamppb.Now(),
+		Clients:      int32(clientCount()),
+		Transports:   make(map[string]int32),
+		Fallbacks:    make(map[string]int32),
+		EncoderSpeed: services.encoder.Status().Speed,
+		Paused:       streamPaused(),
+	}
+	for k, v := range transportCounts() {
+		stats.Transports[k] = int32(v)
+	}
+	fallbacksMux.Lock()
+	for k, v := range fallbacks {
+		stats.Fallbacks[k] = int32(v)
+	}
+	fallbacksMux.Unlock()
+	if st := degradeStatus(); st != nil {
+		stats.DegradationLevel = int32(st.Level)
+	}
+	return stats
+}
+
+func (s *grpcServer) WatchStats(req *grpcapi.WatchStatsRequest, stream grpc.ServerStreamingServer[grpcapi.Stats]) error {
+	interval := time.Second
+	if req.IntervalMs != 0 {
+		interval = max(time.Duration(req.IntervalMs)*time.Millisecond, 100*time.Millisecond)
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		if err := stream.Send(grpcStats()); err != nil {
+			return err
+		}
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-t.C:
+		}
+	}
+}
+
+func (s *grpcServer) InjectInput(stream grpc.ClientStreamingServer[grpcapi.InputEvent, grpcapi.InjectInputResponse]) error {
+	a := grpcAuth(stream.Context())
+	name := "gRPC " + grpcPeer(stream.Context())
+	targets := make(map[string]*inputTarget)
+	var n int64
+	for {
+		ev, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return stream.SendAndClose(&grpcapi.InjectInputResponse{Events: n})
+		}
+		if err != nil {
+			return err
+		}
+		if !streamExists(ev.Session) {
+			return status.Errorf(codes.NotFound, "no streaming session with id %q after %d events", ev.Session, n)
+		}
+		if h := controlHolder(ev.Session); h != nil {
+			return status.Errorf(codes.FailedPrecondition, "%s holds control of the stream after %d events", h.Name, n)
+		}
+		t := targets[ev.Session]
+		if t == nil {
+			t = &inputTarget{stream: ev.Session}
+			targets[ev.Session] = t
+		}
+		in := &controlInput{
+			Type:   ev.Type,
+			X:      ev.X,
+			Y:      ev.Y,
+			Button: int(ev.Button),
+			Keys:   ev.Keys,
+			Text:   ev.Text,
+		}
+		if err := t.replay(in); err != nil {
+			return status.Errorf(codes.FailedPrecondition, "event %d: %v", n+1, err)
+		}
+		inputAudit.record(auditEntry{Time: time.Now(), Stream: ev.Session, User: a.User, Name: name, Key: a.Key, Input: in})
+		n++
+	}
+}
@@ -0,0 +1,1054 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: remoter.proto
+
+package grpcapi
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type GetStatusRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetStatusRequest) Reset() {
+	*x = GetStatusRequest{}
+	mi := &file_remoter_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetStatusRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetStatusRequest) ProtoMessage() {}
+
+func (x *GetStatusRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_remoter_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetStatusRequest.ProtoReflect.Descriptor instead.
+func (*GetStatusRequest) Descriptor() ([]byte, []int) {
+	return file_remoter_proto_rawDescGZIP(), []int{0}
+}
+
+type PauseStreamRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PauseStreamRequest) Reset() {
+	*x = PauseStreamRequest{}
+	mi := &file_remoter_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PauseStreamRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PauseStreamRequest) ProtoMessage() {}
+
+func (x *PauseStreamRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_remoter_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PauseStreamRequest.ProtoReflect.Descriptor instead.
+func (*PauseStreamRequest) Descriptor() ([]byte, []int) {
+	return file_remoter_proto_rawDescGZIP(), []int{1}
+}
+
+type ResumeStreamRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ResumeStreamRequest) Reset() {
+	*x = ResumeStreamRequest{}
+	mi := &file_remoter_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ResumeStreamRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ResumeStreamRequest) ProtoMessage() {}
+
+func (x *ResumeStreamRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_remoter_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ResumeStreamRequest.ProtoReflect.Descriptor instead.
+func (*ResumeStreamRequest) Descriptor() ([]byte, []int) {
+	return file_remoter_proto_rawDescGZIP(), []int{2}
+}
+
+type Status struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// State is "live", "idle", "paused" or "down".
+	State   string `protobuf:"bytes,1,opt,name=state,proto3" json:"state,omitempty"`
+	Viewers int32  `protobuf:"varint,2,opt,name=viewers,proto3" json:"viewers,omitempty"`
+	// Uptime of the stream, zero while it is down.
+	UptimeSeconds int64  `protobuf:"varint,3,opt,name=uptime_seconds,json=uptimeSeconds,proto3" json:"uptime_seconds,omitempty"`
+	Display       string `protobuf:"bytes,4,opt,name=display,proto3" json:"display,omitempty"`
+	Res           string `protobuf:"bytes,5,opt,name=res,proto3" json:"res,omitempty"`
+	Framerate     int32  `protobuf:"varint,6,opt,name=framerate,proto3" json:"framerate,omitempty"`
+	Bitrate       string `protobuf:"bytes,7,opt,name=bitrate,proto3" json:"bitrate,omitempty"`
+	// Speed is how fast the encoder keeps up with the screen; below 1 it
+	// falls behind.
+	Speed         float64 `protobuf:"fixed64,8,opt,name=speed,proto3" json:"speed,omitempty"`
+	LastError     string  `protobuf:"bytes,9,opt,name=last_error,json=lastError,proto3" json:"last_error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Status) Reset() {
+	*x = Status{}
+	mi := &file_remoter_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Status) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Status) ProtoMessage() {}
+
+func (x *Status) ProtoReflect() protoreflect.Message {
+	mi := &file_remoter_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Status.ProtoReflect.Descriptor instead.
+func (*Status) Descriptor() ([]byte, []int) {
+	return file_remoter_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *Status) GetState() string {
+	if x != nil {
+		return x.State
+	}
+	return ""
+}
+
+func (x *Status) GetViewers() int32 {
+	if x != nil {
+		return x.Viewers
+	}
+	return 0
+}
+
+func (x *Status) GetUptimeSeconds() int64 {
+	if x != nil {
+		return x.UptimeSeconds
+	}
+	return 0
+}
+
+func (x *Status) GetDisplay() string {
+	if x != nil {
+		return x.Display
+	}
+	return ""
+}
+
+func (x *Status) GetRes() string {
+	if x != nil {
+		return x.Res
+	}
+	return ""
+}
+
+func (x *Status) GetFramerate() int32 {
+	if x != nil {
+		return x.Framerate
+	}
+	return 0
+}
+
+func (x *Status) GetBitrate() string {
+	if x != nil {
+		return x.Bitrate
+	}
+	return ""
+}
+
+func (x *Status) GetSpeed() float64 {
+	if x != nil {
+		return x.Speed
+	}
+	return 0
+}
+
+func (x *Status) GetLastError() string {
+	if x != nil {
+		return x.LastError
+	}
+	return ""
+}
+
+type Session struct {
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	Id          string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Template    string                 `protobuf:"bytes,2,opt,name=template,proto3" json:"template,omitempty"`
+	Title       string                 `protobuf:"bytes,3,opt,name=title,proto3" json:"title,omitempty"`
+	Description string                 `protobuf:"bytes,4,opt,name=description,proto3" json:"description,omitempty"`
+	// Backend is "x11" or "wayland".
+	Backend   string                 `protobuf:"bytes,5,opt,name=backend,proto3" json:"backend,omitempty"`
+	Display   string                 `protobuf:"bytes,6,opt,name=display,proto3" json:"display,omitempty"`
+	Res       string                 `protobuf:"bytes,7,opt,name=res,proto3" json:"res,omitempty"`
+	User      string                 `protobuf:"bytes,8,opt,name=user,proto3" json:"user,omitempty"`
+	CreatedAt *timestamppb.Timestamp `protobuf:"bytes,9,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	// Unset when the session does not expire.
+	ExpiresAt     *timestamppb.Timestamp `protobuf:"bytes,10,opt,name=expires_at,json=expiresAt,proto3" json:"expires_at,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Session) Reset() {
+	*x = Session{}
+	mi := &file_remoter_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Session) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Session) ProtoMessage() {}
+
+func (x *Session) ProtoReflect() protoreflect.Message {
+	mi := &file_remoter_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Session.ProtoReflect.Descriptor instead.
+func (*Session) Descriptor() ([]byte, []int) {
+	return file_remoter_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *Session) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Session) GetTemplate() string {
+	if x != nil {
+		return x.Template
+	}
+	return ""
+}
+
+func (x *Session) GetTitle() string {
+	if x != nil {
+		return x.Title
+	}
+	return ""
+}
+
+func (x *Session) GetDescription() string {
+	if x != nil {
+		return x.Description
+	}
+	return ""
+}
+
+func (x *Session) GetBackend() string {
+	if x != nil {
+		return x.Backend
+	}
+	return ""
+}
+
+func (x *Session) GetDisplay() string {
+	if x != nil {
+		return x.Display
+	}
+	return ""
+}
+
+func (x *Session) GetRes() string {
+	if x != nil {
+		return x.Res
+	}
+	return ""
+}
+
+func (x *Session) GetUser() string {
+	if x != nil {
+		return x.User
+	}
+	return ""
+}
+
+func (x *Session) GetCreatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CreatedAt
+	}
+	return nil
+}
+
+func (x *Session) GetExpiresAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ExpiresAt
+	}
+	return nil
+}
+
+type ListSessionsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListSessionsRequest) Reset() {
+	*x = ListSessionsRequest{}
+	mi := &file_remoter_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListSessionsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListSessionsRequest) ProtoMessage() {}
+
+func (x *ListSessionsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_remoter_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListSessionsRequest.ProtoReflect.Descriptor instead.
+func (*ListSessionsRequest) Descriptor() ([]byte, []int) {
+	return file_remoter_proto_rawDescGZIP(), []int{5}
+}
+
+type ListSessionsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Sessions      []*Session             `protobuf:"bytes,1,rep,name=sessions,proto3" json:"sessions,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListSessionsResponse) Reset() {
+	*x = ListSessionsResponse{}
+	mi := &file_remoter_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListSessionsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListSessionsResponse) ProtoMessage() {}
+
+func (x *ListSessionsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_remoter_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListSessionsResponse.ProtoReflect.Descriptor instead.
+func (*ListSessionsResponse) Descriptor() ([]byte, []int) {
+	return file_remoter_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *ListSessionsResponse) GetSessions() []*Session {
+	if x != nil {
+		return x.Sessions
+	}
+	return nil
+}
+
+type CreateSessionRequest struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	Template string                 `protobuf:"bytes,1,opt,name=template,proto3" json:"template,omitempty"`
+	// User runs the session as a system user rather than as remoter's.
+	User          string `protobuf:"bytes,2,opt,name=user,proto3" json:"user,omitempty"`
+	Password      string `protobuf:"bytes,3,opt,name=password,proto3" json:"password,omitempty"`
+	Title         string `protobuf:"bytes,4,opt,name=title,proto3" json:"title,omitempty"`
+	Description   string `protobuf:"bytes,5,opt,name=description,proto3" json:"description,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CreateSessionRequest) Reset() {
+	*x = CreateSessionRequest{}
+	mi := &file_remoter_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CreateSessionRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CreateSessionRequest) ProtoMessage() {}
+
+func (x *CreateSessionRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_remoter_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CreateSessionRequest.ProtoReflect.Descriptor instead.
+func (*CreateSessionRequest) Descriptor() ([]byte, []int) {
+	return file_remoter_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *CreateSessionRequest) GetTemplate() string {
+	if x != nil {
+		return x.Template
+	}
+	return ""
+}
+
+func (x *CreateSessionRequest) GetUser() string {
+	if x != nil {
+		return x.User
+	}
+	return ""
+}
+
+func (x *CreateSessionRequest) GetPassword() string {
+	if x != nil {
+		return x.Password
+	}
+	return ""
+}
+
+func (x *CreateSessionRequest) GetTitle() string {
+	if x != nil {
+		return x.Title
+	}
+	return ""
+}
+
+func (x *CreateSessionRequest) GetDescription() string {
+	if x != nil {
+		return x.Description
+	}
+	return ""
+}
+
+type DestroySessionRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DestroySessionRequest) Reset() {
+	*x = DestroySessionRequest{}
+	mi := &file_remoter_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DestroySessionRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DestroySessionRequest) ProtoMessage() {}
+
+func (x *DestroySessionRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_remoter_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DestroySessionRequest.ProtoReflect.Descriptor instead.
+func (*DestroySessionRequest) Descriptor() ([]byte, []int) {
+	return file_remoter_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *DestroySessionRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+type DestroySessionResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DestroySessionResponse) Reset() {
+	*x = DestroySessionResponse{}
+	mi := &file_remoter_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DestroySessionResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DestroySessionResponse) ProtoMessage() {}
+
+func (x *DestroySessionResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_remoter_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DestroySessionResponse.ProtoReflect.Descriptor instead.
+func (*DestroySessionResponse) Descriptor() ([]byte, []int) {
+	return file_remoter_proto_rawDescGZIP(), []int{9}
+}
+
+type WatchStatsRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Interval between updates in milliseconds; 1000 by default and at
+	// least 100.
+	IntervalMs    int32 `protobuf:"varint,1,opt,name=interval_ms,json=intervalMs,proto3" json:"interval_ms,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WatchStatsRequest) Reset() {
+	*x = WatchStatsRequest{}
+	mi := &file_remoter_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatchStatsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchStatsRequest) ProtoMessage() {}
+
+func (x *WatchStatsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_remoter_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchStatsRequest.ProtoReflect.Descriptor instead.
+func (*WatchStatsRequest) Descriptor() ([]byte, []int) {
+	return file_remoter_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *WatchStatsRequest) GetIntervalMs() int32 {
+	if x != nil {
+		return x.IntervalMs
+	}
+	return 0
+}
+
+type Stats struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	Time    *timestamppb.Timestamp `protobuf:"bytes,1,opt,name=time,proto3" json:"time,omitempty"`
+	Clients int32                  `protobuf:"varint,2,opt,name=clients,proto3" json:"clients,omitempty"`
+	// Clients by transport, e.g. "websocket" or "webrtc".
+	Transports map[string]int32 `protobuf:"bytes,3,rep,name=transports,proto3" json:"transports,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"varint,2,opt,name=value"`
+	// How often clients fell back from each transport.
+	Fallbacks    map[string]int32 `protobuf:"bytes,4,rep,name=fallbacks,proto3" json:"fallbacks,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"varint,2,opt,name=value"`
+	EncoderSpeed float64          `protobuf:"fixed64,5,opt,name=encoder_speed,json=encoderSpeed,proto3" json:"encoder_speed,omitempty"`
+	// Degradation level, 0 at full quality.
+	DegradationLevel int32 `protobuf:"varint,6,opt,name=degradation_level,json=degradationLevel,proto3" json:"degradation_level,omitempty"`
+	Paused           bool  `protobuf:"varint,7,opt,name=paused,proto3" json:"paused,omitempty"`
+	unknownFields    protoimpl.UnknownFields
+	sizeCache        protoimpl.SizeCache
+}
+
+func (x *Stats) Reset() {
+	*x = Stats{}
+	mi := &file_remoter_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Stats) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Stats) ProtoMessage() {}
+
+func (x *Stats) ProtoReflect() protoreflect.Message {
+	mi := &file_remoter_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Stats.ProtoReflect.Descriptor instead.
+func (*Stats) Descriptor() ([]byte, []int) {
+	return file_remoter_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *Stats) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
+func (x *Stats) GetClients() int32 {
+	if x != nil {
+		return x.Clients
+	}
+	return 0
+}
+
+func (x *Stats) GetTransports() map[string]int32 {
+	if x != nil {
+		return x.Transports
+	}
+	return nil
+}
+
+func (x *Stats) GetFallbacks() map[string]int32 {
+	if x != nil {
+		return x.Fallbacks
+	}
+	return nil
+}
+
+func (x *Stats) GetEncoderSpeed() float64 {
+	if x != nil {
+		return x.EncoderSpeed
+	}
+	return 0
+}
+
+func (x *Stats) GetDegradationLevel() int32 {
+	if x != nil {
+		return x.DegradationLevel
+	}
+	return 0
+}
+
+func (x *Stats) GetPaused() bool {
+	if x != nil {
+		return x.Paused
+	}
+	return false
+}
+
+type InputEvent struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Session is the stream whose display gets the input, empty for the
+	// main display.
+	Session string `protobuf:"bytes,1,opt,name=session,proto3" json:"session,omitempty"`
+	// Type is "move", "down", "up", "click", "key" or "type".
+	Type string `protobuf:"bytes,2,opt,name=type,proto3" json:"type,omitempty"`
+	// X and Y are fractions of the display's width and height.
+	X float64 `protobuf:"fixed64,3,opt,name=x,proto3" json:"x,omitempty"`
+	Y float64 `protobuf:"fixed64,4,opt,name=y,proto3" json:"y,omitempty"`
+	// Button is 1 (left) by default.
+	Button int32 `protobuf:"varint,5,opt,name=button,proto3" json:"button,omitempty"`
+	// Keys are pressed together, e.g. ["ctrl", "c"].
+	Keys          []string `protobuf:"bytes,6,rep,name=keys,proto3" json:"keys,omitempty"`
+	Text          string   `protobuf:"bytes,7,opt,name=text,proto3" json:"text,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *InputEvent) Reset() {
+	*x = InputEvent{}
+	mi := &file_remoter_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *InputEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InputEvent) ProtoMessage() {}
+
+func (x *InputEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_remoter_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InputEvent.ProtoReflect.Descriptor instead.
+func (*InputEvent) Descriptor() ([]byte, []int) {
+	return file_remoter_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *InputEvent) GetSession() string {
+	if x != nil {
+		return x.Session
+	}
+	return ""
+}
+
+func (x *InputEvent) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *InputEvent) GetX() float64 {
+	if x != nil {
+		return x.X
+	}
+	return 0
+}
+
+func (x *InputEvent) GetY() float64 {
+	if x != nil {
+		return x.Y
+	}
+	return 0
+}
+
+func (x *InputEvent) GetButton() int32 {
+	if x != nil {
+		return x.Button
+	}
+	return 0
+}
+
+func (x *InputEvent) GetKeys() []string {
+	if x != nil {
+		return x.Keys
+	}
+	return nil
+}
+
+func (x *InputEvent) GetText() string {
+	if x != nil {
+		return x.Text
+	}
+	return ""
+}
+
+type InjectInputResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Events        int64                  `protobuf:"varint,1,opt,name=events,proto3" json:"events,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *InjectInputResponse) Reset() {
+	*x = InjectInputResponse{}
+	mi := &file_remoter_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *InjectInputResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InjectInputResponse) ProtoMessage() {}
+
+func (x *InjectInputResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_remoter_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InjectInputResponse.ProtoReflect.Descriptor instead.
+func (*InjectInputResponse) Descriptor() ([]byte, []int) {
+	return file_remoter_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *InjectInputResponse) GetEvents() int64 {
+	if x != nil {
+		return x.Events
+	}
+	return 0
+}
+
+var File_remoter_proto protoreflect.FileDescriptor
+
+const file_remoter_proto_rawDesc = "" +
+	"\n" +
+	"\rremoter.proto\x12\n" +
+	"remoter.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"\x12\n" +
+	"\x10GetStatusRequest\"\x14\n" +
+	"\x12PauseStreamRequest\"\x15\n" +
+	"\x13ResumeStreamRequest\"\xf8\x01\n" +
+	"\x06Status\x12\x14\n" +
+	"\x05state\x18\x01 \x01(\tR\x05state\x12\x18\n" +
+	"\aviewers\x18\x02 \x01(\x05R\aviewers\x12%\n" +
+	"\x0euptime_seconds\x18\x03 \x01(\x03R\ruptimeSeconds\x12\x18\n" +
+	"\adisplay\x18\x04 \x01(\tR\adisplay\x12\x10\n" +
+	"\x03res\x18\x05 \x01(\tR\x03res\x12\x1c\n" +
+	"\tframerate\x18\x06 \x01(\x05R\tframerate\x12\x18\n" +
+	"\abitrate\x18\a \x01(\tR\abitrate\x12\x14\n" +
+	"\x05speed\x18\b \x01(\x01R\x05speed\x12\x1d\n" +
+	"\n" +
+	"last_error\x18\t \x01(\tR\tlastError\"\xbd\x02\n" +
+	"\aSession\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x1a\n" +
+	"\btemplate\x18\x02 \x01(\tR\btemplate\x12\x14\n" +
+	"\x05title\x18\x03 \x01(\tR\x05title\x12 \n" +
+	"\vdescription\x18\x04 \x01(\tR\vdescription\x12\x18\n" +
+	"\abackend\x18\x05 \x01(\tR\abackend\x12\x18\n" +
+	"\adisplay\x18\x06 \x01(\tR\adisplay\x12\x10\n" +
+	"\x03res\x18\a \x01(\tR\x03res\x12\x12\n" +
+	"\x04user\x18\b \x01(\tR\x04user\x129\n" +
+	"\n" +
+	"created_at\x18\t \x01(\v2\x1a.google.protobuf.TimestampR\tcreatedAt\x129\n" +
+	"\n" +
+	"expires_at\x18\n" +
+	" \x01(\v2\x1a.google.protobuf.TimestampR\texpiresAt\"\x15\n" +
+	"\x13ListSessionsRequest\"G\n" +
+	"\x14ListSessionsResponse\x12/\n" +
+	"\bsessions\x18\x01 \x03(\v2\x13.remoter.v1.SessionR\bsessions\"\x9a\x01\n" +
+	"\x14CreateSessionRequest\x12\x1a\n" +
+	"\btemplate\x18\x01 \x01(\tR\btemplate\x12\x12\n" +
+	"\x04user\x18\x02 \x01(\tR\x04user\x12\x1a\n" +
+	"\bpassword\x18\x03 \x01(\tR\bpassword\x12\x14\n" +
+	"\x05title\x18\x04 \x01(\tR\x05title\x12 \n" +
+	"\vdescription\x18\x05 \x01(\tR\vdescription\"'\n" +
+	"\x15DestroySessionRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\"\x18\n" +
+	"\x16DestroySessionResponse\"4\n" +
+	"\x11WatchStatsRequest\x12\x1f\n" +
+	"\vinterval_ms\x18\x01 \x01(\x05R\n" +
+	"intervalMs\"\xbb\x03\n" +
+	"\x05Stats\x12.\n" +
+	"\x04time\x18\x01 \x01(\v2\x1a.google.protobuf.TimestampR\x04time\x12\x18\n" +
+	"\aclients\x18\x02 \x01(\x05R\aclients\x12A\n" +
+	"\n" +
+	"transports\x18\x03 \x03(\v2!.remoter.v1.Stats.TransportsEntryR\n" +
+	"transports\x12>\n" +
+	"\tfallbacks\x18\x04 \x03(\v2 .remoter.v1.Stats.FallbacksEntryR\tfallbacks\x12#\n" +
+	"\rencoder_speed\x18\x05 \x01(\x01R\fencoderSpeed\x12+\n" +
+	"\x11degradation_level\x18\x06 \x01(\x05R\x10degradationLevel\x12\x16\n" +
+	"\x06paused\x18\a \x01(\bR\x06paused\x1a=\n" +
+	"\x0fTransportsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\x05R\x05value:\x028\x01\x1a<\n" +
+	"\x0eFallbacksEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\x05R\x05value:\x028\x01\"\x96\x01\n" +
+	"\n" +
+	"InputEvent\x12\x18\n" +
+	"\asession\x18\x01 \x01(\tR\asession\x12\x12\n" +
+	"\x04type\x18\x02 \x01(\tR\x04type\x12\f\n" +
+	"\x01x\x18\x03 \x01(\x01R\x01x\x12\f\n" +
+	"\x01y\x18\x04 \x01(\x01R\x01y\x12\x16\n" +
+	"\x06button\x18\x05 \x01(\x05R\x06button\x12\x12\n" +
+	"\x04keys\x18\x06 \x03(\tR\x04keys\x12\x12\n" +
+	"\x04text\x18\a \x01(\tR\x04text\"-\n" +
+	"\x13InjectInputResponse\x12\x16\n" +
+	"\x06events\x18\x01 \x01(\x03R\x06events2\xd0\x04\n" +
+	"\aRemoter\x12=\n" +
+	"\tGetStatus\x12\x1c.remoter.v1.GetStatusRequest\x1a\x12.remoter.v1.Status\x12A\n" +
+	"\vPauseStream\x12\x1e.remoter.v1.PauseStreamRequest\x1a\x12.remoter.v1.Status\x12C\n" +
+	"\fResumeStream\x12\x1f.remoter.v1.ResumeStreamRequest\x1a\x12.remoter.v1.Status\x12Q\n" +
+	"\fListSessions\x12\x1f.remoter.v1.ListSessionsRequest\x1a .remoter.v1.ListSessionsResponse\x12F\n" +
+	"\rCreateSession\x12 .remoter.v1.CreateSessionRequest\x1a\x13.remoter.v1.Session\x12W\n" +
+	"\x0eDestroySession\x12!.remoter.v1.DestroySessionRequest\x1a\".remoter.v1.DestroySessionResponse\x12@\n" +
+	"\n" +
+	"WatchStats\x12\x1d.remoter.v1.WatchStatsRequest\x1a\x11.remoter.v1.Stats0\x01\x12H\n" +
+	"\vInjectInput\x12\x16.remoter.v1.InputEvent\x1a\x1f.remoter.v1.InjectInputResponse(\x01B'Z%github.com/nathfavour/remoter/grpcapib\x06proto3"
+
+var (
+	file_remoter_proto_rawDescOnce sync.Once
+	file_remoter_proto_rawDescData []byte
+)
+
+func file_remoter_proto_rawDescGZIP() []byte {
+	file_remoter_proto_rawDescOnce.Do(func() {
+		file_remoter_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_remoter_proto_rawDesc), len(file_remoter_proto_rawDesc)))
+	})
+	return file_remoter_proto_rawDescData
+}
+
+var file_remoter_proto_msgTypes = make([]protoimpl.MessageInfo, 16)
+var file_remoter_proto_goTypes = []any{
+	(*GetStatusRequest)(nil),       // 0: remoter.v1.GetStatusRequest
+	(*PauseStreamRequest)(nil),     // 1: remoter.v1.PauseStreamRequest
+	(*ResumeStreamRequest)(nil),    // 2: remoter.v1.ResumeStreamRequest
+	(*Status)(nil),                 // 3: remoter.v1.Status
+	(*Session)(nil),                // 4: remoter.v1.Session
+	(*ListSessionsRequest)(nil),    // 5: remoter.v1.ListSessionsRequest
+	(*ListSessionsResponse)(nil),   // 6: remoter.v1.ListSessionsResponse
+	(*CreateSessionRequest)(nil),   // 7: remoter.v1.CreateSessionRequest
+	(*DestroySessionRequest)(nil),  // 8: remoter.v1.DestroySessionRequest
+	(*DestroySessionResponse)(nil), // 9: remoter.v1.DestroySessionResponse
+	(*WatchStatsRequest)(nil),      // 10: remoter.v1.WatchStatsRequest
+	(*Stats)(nil),                  // 11: remoter.v1.Stats
+	(*InputEvent)(nil),             // 12: remoter.v1.InputEvent
+	(*InjectInputResponse)(nil),    // 13: remoter.v1.InjectInputResponse
+	nil,                            // 14: remoter.v1.Stats.TransportsEntry
+	nil,                            // 15: remoter.v1.Stats.FallbacksEntry
+	(*timestamppb.Timestamp)(nil),  // 16: google.protobuf.Timestamp
+}
+var file_remoter_proto_depIdxs = []int32{
+	16, // 0: remoter.v1.Session.created_at:type_name -> google.protobuf.Timestamp
+	16, // 1: remoter.v1.Session.expires_at:type_name -> google.protobuf.Timestamp
+	4,  // 2: remoter.v1.ListSessionsResponse.sessions:type_name -> remoter.v1.Session
+	16, // 3: remoter.v1.Stats.time:type_name -> google.protobuf.Timestamp
+	14, // 4: remoter.v1.Stats.transports:type_name -> remoter.v1.Stats.TransportsEntry
+	15, // 5: remoter.v1.Stats.fallbacks:type_name -> remoter.v1.Stats.FallbacksEntry
+	0,  // 6: remoter.v1.Remoter.GetStatus:input_type -> remoter.v1.GetStatusRequest
+	1,  // 7: remoter.v1.Remoter.PauseStream:input_type -> remoter.v1.PauseStreamRequest
+	2,  // 8: remoter.v1.Remoter.ResumeStream:input_type -> remoter.v1.ResumeStreamRequest
+	5,  // 9: remoter.v1.Remoter.ListSessions:input_type -> remoter.v1.ListSessionsRequest
+	7,  // 10: remoter.v1.Remoter.CreateSession:input_type -> remoter.v1.CreateSessionRequest
+	8,  // 11: remoter.v1.Remoter.DestroySession:input_type -> remoter.v1.DestroySessionRequest
+	10, // 12: remoter.v1.Remoter.WatchStats:input_type -> remoter.v1.WatchStatsRequest
+	12, // 13: remoter.v1.Remoter.InjectInput:input_type -> remoter.v1.InputEvent
+	3,  // 14: remoter.v1.Remoter.GetStatus:output_type -> remoter.v1.Status
+	3,  // 15: remoter.v1.Remoter.PauseStream:output_type -> remoter.v1.Status
+	3,  // 16: remoter.v1.Remoter.ResumeStream:output_type -> remoter.v1.Status
+	6,  // 17: remoter.v1.Remoter.ListSessions:output_type -> remoter.v1.ListSessionsResponse
+	4,  // 18: remoter.v1.Remoter.CreateSession:output_type -> remoter.v1.Session
+	9,  // 19: remoter.v1.Remoter.DestroySession:output_type -> remoter.v1.DestroySessionResponse
+	11, // 20: remoter.v1.Remoter.WatchStats:output_type -> remoter.v1.Stats
+	13, // 21: remoter.v1.Remoter.InjectInput:output_type -> remoter.v1.InjectInputResponse
+	14, // [14:22] is the sub-list for method output_type
+	6,  // [6:14] is the sub-list for method input_type
+	6,  // [6:6] is the sub-list for extension type_name
+	6,  // [6:6] is the sub-list for extension extendee
+	0,  // [0:6] is the sub-list for field type_name
+}
+
+func init() { file_remoter_proto_init() }
+func file_remoter_proto_init() {
+	if File_remoter_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_remoter_proto_rawDesc), len(file_remoter_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   16,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_remoter_proto_goTypes,
+		DependencyIndexes: file_remoter_proto_depIdxs,
+		MessageInfos:      file_remoter_proto_msgTypes,
+	}.Build()
+	File_remoter_proto = out.File
+	file_remoter_proto_goTypes = nil
+	file_remoter_proto_depIdxs = nil
+}
@@ -0,0 +1,422 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             (unknown)
+// source: remoter.proto
+
+package grpcapi
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Remoter_GetStatus_FullMethodName      = "/remoter.v1.Remoter/GetStatus"
+	Remoter_PauseStream_FullMethodName    = "/remoter.v1.Remoter/PauseStream"
+	Remoter_ResumeStream_FullMethodName   = "/remoter.v1.Remoter/ResumeStream"
+	Remoter_ListSessions_FullMethodName   = "/remoter.v1.Remoter/ListSessions"
+	Remoter_CreateSession_FullMethodName  = "/remoter.v1.Remoter/CreateSession"
+	Remoter_DestroySession_FullMethodName = "/remoter.v1.Remoter/DestroySession"
+	Remoter_WatchStats_FullMethodName     = "/remoter.v1.Remoter/WatchStats"
+	Remoter_InjectInput_FullMethodName    = "/remoter.v1.Remoter/InjectInput"
+)
+
+// RemoterClient is the client API for Remoter service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Remoter controls a running instance, like the REST API under /api/v1,
+// with stats pushed rather than polled and input streamed.
+//
+// Calls authenticate like the REST API: "authorization" metadata of
+// "Basic <base64 user:password>" for a login, or a request signed with a
+// paired key ("Remoter-Key id=..., ts=..., sig=...", signing POST and the
+// full method name, e.g. "/remoter.v1.Remoter/GetStatus"). Only admins may
+// call.
+type RemoterClient interface {
+	// GetStatus returns the state of the main stream.
+	GetStatus(ctx context.Context, in *GetStatusRequest, opts ...grpc.CallOption) (*Status, error)
+	// PauseStream freezes the main stream on a placeholder.
+	PauseStream(ctx context.Context, in *PauseStreamRequest, opts ...grpc.CallOption) (*Status, error)
+	// ResumeStream resumes the main stream; it fails while the screen is
+	// locked.
+	ResumeStream(ctx context.Context, in *ResumeStreamRequest, opts ...grpc.CallOption) (*Status, error)
+	ListSessions(ctx context.Context, in *ListSessionsRequest, opts ...grpc.CallOption) (*ListSessionsResponse, error)
+	// CreateSession starts a virtual session from a template and streams it.
+	CreateSession(ctx context.Context, in *CreateSessionRequest, opts ...grpc.CallOption) (*Session, error)
+	DestroySession(ctx context.Context, in *DestroySessionRequest, opts ...grpc.CallOption) (*DestroySessionResponse, error)
+	// WatchStats sends the stats right away and then every interval, until
+	// the call is cancelled.
+	WatchStats(ctx context.Context, in *WatchStatsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Stats], error)
+	// InjectInput replays pointer and keyboard input on the display of a
+	// stream, in the order it is sent. It ends at the first event that
+	// fails, with the number of events replayed before it.
+	InjectInput(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[InputEvent, InjectInputResponse], error)
+}
+
+type remoterClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewRemoterClient(cc grpc.ClientConnInterface) RemoterClient {
+	return &remoterClient{cc}
+}
+
+func (c *remoterClient) GetStatus(ctx context.Context, in *GetStatusRequest, opts ...grpc.CallOption) (*Status, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Status)
+	err := c.cc.Invoke(ctx, Remoter_GetStatus_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *remoterClient) PauseStream(ctx context.Context, in *PauseStreamRequest, opts ...grpc.CallOption) (*Status, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Status)
+	err := c.cc.Invoke(ctx, Remoter_PauseStream_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *remoterClient) ResumeStream(ctx context.Context, in *ResumeStreamRequest, opts ...grpc.CallOption) (*Status, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Status)
+	err := c.cc.Invoke(ctx, Remoter_ResumeStream_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *remoterClient) ListSessions(ctx context.Context, in *ListSessionsRequest, opts ...grpc.CallOption) (*ListSessionsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListSessionsResponse)
+	err := c.cc.Invoke(ctx, Remoter_ListSessions_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *remoterClient) CreateSession(ctx context.Context, in *CreateSessionRequest, opts ...grpc.CallOption) (*Session, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Session)
+	err := c.cc.Invoke(ctx, Remoter_CreateSession_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *remoterClient) DestroySession(ctx context.Context, in *DestroySessionRequest, opts ...grpc.CallOption) (*DestroySessionResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DestroySessionResponse)
+	err := c.cc.Invoke(ctx, Remoter_DestroySession_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *remoterClient) WatchStats(ctx context.Context, in *WatchStatsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Stats], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Remoter_ServiceDesc.Streams[0], Remoter_WatchStats_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WatchStatsRequest, Stats]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Remoter_WatchStatsClient = grpc.ServerStreamingClient[Stats]
+
+func (c *remoterClient) InjectInput(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[InputEvent, InjectInputResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Remoter_ServiceDesc.Streams[1], Remoter_InjectInput_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[InputEvent, InjectInputResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Remoter_InjectInputClient = grpc.ClientStreamingClient[InputEvent, InjectInputResponse]
+
+// RemoterServer is the server API for Remoter service.
+// All implementations must embed UnimplementedRemoterServer
+// for forward compatibility.
+//
+// Remoter controls a running instance, like the REST API under /api/v1,
+// with stats pushed rather than polled and input streamed.
+//
+// Calls authenticate like the REST API: "authorization" metadata of
+// "Basic <base64 user:password>" for a login, or a request signed with a
+// paired key ("Remoter-Key id=..., ts=..., sig=...", signing POST and the
+// full method name, e.g. "/remoter.v1.Remoter/GetStatus"). Only admins may
+// call.
+type RemoterServer interface {
+	// GetStatus returns the state of the main stream.
+	GetStatus(context.Context, *GetStatusRequest) (*Status, error)
+	// PauseStream freezes the main stream on a placeholder.
+	PauseStream(context.Context, *PauseStreamRequest) (*Status, error)
+	// ResumeStream resumes the main stream; it fails while the screen is
+	// locked.
+	ResumeStream(context.Context, *ResumeStreamRequest) (*Status, error)
+	ListSessions(context.Context, *ListSessionsRequest) (*ListSessionsResponse, error)
+	// CreateSession starts a virtual session from a template and streams it.
+	CreateSession(context.Context, *CreateSessionRequest) (*Session, error)
+	DestroySession(context.Context, *DestroySessionRequest) (*DestroySessionResponse, error)
+	// WatchStats sends the stats right away and then every interval, until
+	// the call is cancelled.
+	WatchStats(*WatchStatsRequest, grpc.ServerStreamingServer[Stats]) error
+	// InjectInput replays pointer and keyboard input on the display of a
+	// stream, in the order it is sent. It ends at the first event that
+	// fails, with the number of events replayed before it.
+	InjectInput(grpc.ClientStreamingServer[InputEvent, InjectInputResponse]) error
+	mustEmbedUnimplementedRemoterServer()
+}
+
+// UnimplementedRemoterServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedRemoterServer struct{}
+
+func (UnimplementedRemoterServer) GetStatus(context.Context, *GetStatusRequest) (*Status, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetStatus not implemented")
+}
+func (UnimplementedRemoterServer) PauseStream(context.Context, *PauseStreamRequest) (*Status, error) {
+	return nil, status.Error(codes.Unimplemented, "method PauseStream not implemented")
+}
+func (UnimplementedRemoterServer) ResumeStream(context.Context, *ResumeStreamRequest) (*Status, error) {
+	return nil, status.Error(codes.Unimplemented, "method ResumeStream not implemented")
+}
+func (UnimplementedRemoterServer) ListSessions(context.Context, *ListSessionsRequest) (*ListSessionsResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method ListSessions not implemented")
+}
+func (UnimplementedRemoterServer) CreateSession(context.Context, *CreateSessionRequest) (*Session, error) {
+	return nil, status.Error(codes.Unimplemented, "method CreateSession not implemented")
+}
+func (UnimplementedRemoterServer) DestroySession(context.Context, *DestroySessionRequest) (*DestroySessionResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method DestroySession not implemented")
+}
+func (UnimplementedRemoterServer) WatchStats(*WatchStatsRequest, grpc.ServerStreamingServer[Stats]) error {
+	return status.Error(codes.Unimplemented, "method WatchStats not implemented")
+}
+func (UnimplementedRemoterServer) InjectInput(grpc.ClientStreamingServer[InputEvent, InjectInputResponse]) error {
+	return status.Error(codes.Unimplemented, "method InjectInput not implemented")
+}
+func (UnimplementedRemoterServer) mustEmbedUnimplementedRemoterServer() {}
+func (UnimplementedRemoterServer) testEmbeddedByValue()                 {}
+
+// UnsafeRemoterServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to RemoterServer will
+// result in compilation errors.
+type UnsafeRemoterServer interface {
+	mustEmbedUnimplementedRemoterServer()
+}
+
+func RegisterRemoterServer(s grpc.ServiceRegistrar, srv RemoterServer) {
+	// If the following call panics, it indicates UnimplementedRemoterServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Remoter_ServiceDesc, srv)
+}
+
+func _Remoter_GetStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetStatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RemoterServer).GetStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Remoter_GetStatus_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RemoterServer).GetStatus(ctx, req.(*GetStatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Remoter_PauseStream_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PauseStreamRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RemoterServer).PauseStream(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Remoter_PauseStream_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RemoterServer).PauseStream(ctx, req.(*PauseStreamRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Remoter_ResumeStream_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ResumeStreamRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RemoterServer).ResumeStream(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Remoter_ResumeStream_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RemoterServer).ResumeStream(ctx, req.(*ResumeStreamRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Remoter_ListSessions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListSessionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RemoterServer).ListSessions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Remoter_ListSessions_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RemoterServer).ListSessions(ctx, req.(*ListSessionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Remoter_CreateSession_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateSessionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RemoterServer).CreateSession(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Remoter_CreateSession_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RemoterServer).CreateSession(ctx, req.(*CreateSessionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Remoter_DestroySession_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DestroySessionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RemoterServer).DestroySession(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Remoter_DestroySession_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RemoterServer).DestroySession(ctx, req.(*DestroySessionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Remoter_WatchStats_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchStatsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(RemoterServer).WatchStats(m, &grpc.GenericServerStream[WatchStatsRequest, Stats]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Remoter_WatchStatsServer = grpc.ServerStreamingServer[Stats]
+
+func _Remoter_InjectInput_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(RemoterServer).InjectInput(&grpc.GenericServerStream[InputEvent, InjectInputResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Remoter_InjectInputServer = grpc.ClientStreamingServer[InputEvent, InjectInputResponse]
+
+// Remoter_ServiceDesc is the grpc.ServiceDesc for Remoter service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Remoter_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "remoter.v1.Remoter",
+	HandlerType: (*RemoterServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetStatus",
+			Handler:    _Remoter_GetStatus_Handler,
+		},
+		{
+			MethodName: "PauseStream",
+			Handler:    _Remoter_PauseStream_Handler,
+		},
+		{
+			MethodName: "ResumeStream",
+			Handler:    _Remoter_ResumeStream_Handler,
+		},
+		{
+			MethodName: "ListSessions",
+			Handler:    _Remoter_ListSessions_Handler,
+		},
+		{
+			MethodName: "CreateSession",
+			Handler:    _Remoter_CreateSession_Handler,
+		},
+		{
+			MethodName: "DestroySession",
+			Handler:    _Remoter_DestroySession_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchStats",
+			Handler:       _Remoter_WatchStats_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "InjectInput",
+			Handler:       _Remoter_InjectInput_Handler,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "remoter.proto",
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"cmp"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nathfavour/remoter/ffmpeg"
+	"github.com/nathfavour/remoter/i18n"
+)
+
+const (
+	// handoverTTL is how long a transfer code can be redeemed.
+	handoverTTL = 2 * time.Minute
+	// handoverGrantTTL is how long the new device keeps access, unless the
+	// share link the old one came with expires sooner.
+	handoverGrantTTL = 12 * time.Hour
+	// handoverAlphabet leaves out letters and digits easily confused when
+	// read off one screen and typed into another.
+	handoverAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	handoverCodeLen  = 6
+)
+
+// handover is a viewer's session waiting for the device it moves to.
+type handover struct {
+	grant    shareGrant
+	device   string // the device handing over
+	quality  string
+	position float64 // seconds into the stream, as the old device reported
+	expires  time.Time
+}
+
+var (
+	handovers   = make(map[string]*handover)
+	handoverMux sync.Mutex
+)
+
+func newHandoverCode() string {
+	b := make([]byte, handoverCodeLen)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	for i := range b {
+		b[i] = handoverAlphabet[int(b[i])%len(handoverAlphabet)]
+	}
+	return string(b)
+}
+
+// handleStartHandover issues a transfer code that moves the caller's
+// session to another device: the stream it watches, its role (and with
+// it control), quality tier and the position the page reports.
+func handleStartHandover(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Session  string  `json:"session"`
+		Position float64 `json:"position"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	a := requestAuth(r)
+	if (a.Session != "" && req.Session != a.Session) || (isolated(a) && !ownsSession(a.User, req.Session)) {
+		i18n.Error(w, r, http.StatusForbidden, "forbidden")
+		return
+	}
+	if !streamExists(req.Session) {
+		i18n.Error(w, r, http.StatusNotFound, "no_such_session")
+		return
+	}
+
+	// Shares carry view or control, so an admin's access moves as control.
+	role := a.Role
+	if role != "view" {
+		role = "control"
+	}
+	h := &handover{
+		grant: shareGrant{
+			Session: req.Session,
+			Role:    role,
+			User:    a.User,
+			Expires: time.Now().Add(handoverGrantTTL).Unix(),
+		},
+		device:   deviceOf(r),
+		position: max(req.Position, 0),
+		expires:  time.Now().Add(handoverTTL),
+	}
+	if c, err := r.Cookie(shareCookie); err == nil && a.User == "" {
+		// Access from a share link lasts no longer on the new device.
+		if g, err := verifyShare(c.Value); err == nil {
+			h.grant.Expires = min(h.grant.Expires, g.Expires)
+		}
+	}
+	if a.Invite != "" {
+		// Revoking the invite still cuts the session off.
+		h.grant.Invite, h.grant.Bound = a.Invite, true
+	}
+	clientsMux.RLock()
+	for c := range clients {
+		if h.device != "" && c.device == h.device && c.stream == req.Session {
+			h.quality = c.quality.Load().(string)
+		}
+	}
+	clientsMux.RUnlock()
+
+	handoverMux.Lock()
+	for code, old := range handovers {
+		if time.Now().After(old.expires) {
+			delete(handovers, code)
+		}
+	}
+	code := newHandoverCode()
+	for handovers[code] != nil {
+		code = newHandoverCode()
+	}
+	handovers[code] = h
+	handoverMux.Unlock()
+
+	path := "/handover/" + code
+	writeJSON(w, http.StatusCreated, map[string]any{
+		"code":       code,
+		"path":       path,
+		"url":        absoluteURL(r, path),
+		"expires_at": h.expires,
+	})
+}
+
+// handleRedeemHandover moves a session to the device opening its code:
+// it gets the old device's access without logging in, the old device is
+// disconnected, and the viewer opens where the old one was.
+func handleRedeemHandover(w http.ResponseWriter, r *http.Request) {
+	code := strings.ToUpper(strings.ReplaceAll(r.PathValue("code"), "-", ""))
+	handoverMux.Lock()
+	h, ok := handovers[code]
+	delete(handovers, code)
+	handoverMux.Unlock()
+	if !ok || time.Now().After(h.expires) || (h.grant.Invite != "" && !invites.active(h.grant.Invite)) {
+		i18n.Error(w, r, http.StatusNotFound, "handover_invalid")
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     shareCookie,
+		Value:    signToken(tokenShare, h.grant),
+		Path:     "/",
+		Expires:  time.Unix(h.grant.Expires, 0),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	if h.device != "" && h.device != deviceOf(r) {
+		kickWhere(func(c *client) bool { return c.device == h.device && c.stream == h.grant.Session })
+	}
+	log.Printf("Handover: %s moved to %s", cmp.Or(h.grant.User, "a viewer"), r.RemoteAddr)
+	emit(eventViewerHandover, map[string]any{
+		"session":     h.grant.Session,
+		"user":        h.grant.User,
+		"role":        h.grant.Role,
+		"from_device": h.device,
+		"remote_addr": r.RemoteAddr,
+	})
+
+	target := "/"
+	if h.grant.Session != "" {
+		target = "/s/" + h.grant.Session + "/view"
+	}
+	q := url.Values{}
+	if h.quality != "" && h.quality != ffmpeg.DefaultTier {
+		q.Set("quality", h.quality)
+	}
+	if h.position > 0 {
+		q.Set("t", strconv.FormatFloat(h.position, 'f', 1, 64))
+	}
+	if len(q) > 0 {
+		target += "?" + q.Encode()
+	}
+	http.Redirect(w, r, target, http.StatusFound)
+}
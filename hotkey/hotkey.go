@@ -0,0 +1,44 @@
+package hotkey
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// helper grabs the key combination through python-xlib.
+//
+//go:embed hotkey.py
+var helper string
+
+// Grab grabs combo (e.g. "ctrl+alt+p") on the X display and calls fn on
+// every press, until the helper exits.
+func Grab(display, combo string, fn func()) error {
+	if combo == "" {
+		return fmt.Errorf("empty hotkey")
+	}
+	cmd := exec.Command("python3", "-c", helper, combo)
+	cmd.Env = append(os.Environ(), "DISPLAY="+display)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start hotkey helper: %w", err)
+	}
+	fmt.Printf("Grabbed hotkey %s on %s (pid %d)\n", combo, display, cmd.Process.Pid)
+
+	go func() {
+		sc := bufio.NewScanner(out)
+		for sc.Scan() {
+			if sc.Text() == "press" {
+				fn()
+			}
+		}
+		fmt.Printf("Hotkey helper for %s exited: %v\n", combo, cmd.Wait())
+	}()
+	return nil
+}
@@ -0,0 +1,45 @@
+# Grabs a global X key combination and prints "press" each time it is hit.
+import sys
+
+from Xlib import X, XK, display, error
+
+MODIFIERS = {
+    "ctrl": X.ControlMask,
+    "control": X.ControlMask,
+    "alt": X.Mod1Mask,
+    "shift": X.ShiftMask,
+    "super": X.Mod4Mask,
+    "win": X.Mod4Mask,
+}
+
+def main():
+    d = display.Display()
+    root = d.screen().root
+
+    mods = 0
+    *names, key = sys.argv[1].lower().split("+")
+    for name in names:
+        if name not in MODIFIERS:
+            sys.exit("unknown modifier %r" % name)
+        mods |= MODIFIERS[name]
+    keysym = XK.string_to_keysym(key) or XK.string_to_keysym(key.capitalize())
+    keycode = d.keysym_to_keycode(keysym)
+    if not keycode:
+        sys.exit("unknown key %r" % key)
+
+    # The grab must not depend on NumLock or CapsLock.
+    catch = error.CatchError(error.BadAccess)
+    for extra in (0, X.LockMask, X.Mod2Mask, X.LockMask | X.Mod2Mask):
+        root.grab_key(keycode, mods | extra, True, X.GrabModeAsync, X.GrabModeAsync, onerror=catch)
+    d.sync()
+    if catch.get_error():
+        sys.exit("%s is already grabbed by another client" % sys.argv[1])
+
+    while True:
+        ev = d.next_event()
+        if ev.type == X.KeyPress:
+            sys.stdout.write("press\n")
+            sys.stdout.flush()
+
+if __name__ == "__main__":
+    main()
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nathfavour/remoter/tracing"
+)
+
+// hubQueueSize bounds the chunks waiting for the hub across all streams;
+// beyond it publishers block, pushing back on the encoders.
+const hubQueueSize = 64
+
+// chunk is a piece of a stream shared by every viewer's queue. It goes
+// back to the pool once the last of them has written it.
+type chunk struct {
+	data   []byte
+	refs   atomic.Int32
+	pooled bool
+	// span follows the odd chunk from the encoder to the viewers, ending
+	// once the last of them has written it; fanned is when the hub queued
+	// it for them.
+	span   *tracing.Span
+	fanned time.Time
+}
+
+var chunkPool = sync.Pool{
+	New: func() any { return &chunk{data: make([]byte, 0, 4096), pooled: true} },
+}
+
+func (ch *chunk) release() {
+	if ch.refs.Add(-1) != 0 {
+		return
+	}
+	if ch.span != nil {
+		ch.span.End()
+		ch.span = nil
+	}
+	if ch.pooled {
+		ch.data = ch.data[:0]
+		chunkPool.Put(ch)
+	}
+}
+
+// traceWrite times c's write of ch, from when the hub queued it, under
+// the chunk's span if it has one.
+func (ch *chunk) traceWrite(c *client) *tracing.Span {
+	if ch.span == nil {
+		return nil
+	}
+	span := tracer.StartAt(tracing.ContextWith(context.Background(), ch.span), "client.write", tracing.KindConsumer, ch.fanned)
+	span.Event("dequeued")
+	span.Set("remoter.client", c.id)
+	span.Set("remoter.transport", c.transport)
+	span.Set("remoter.queue_depth", len(c.queue))
+	return span
+}
+
+type hubMsg struct {
+	stream string // see streamKey
+	chunk  *chunk // nil resets the stream's GOP cache
+}
+
+// hub fans stream data out to the viewers' writer goroutines. A single
+// goroutine owns the subscriptions and GOP caches, so publishing takes no
+// locks and a join is ordered exactly between two chunks.
+type hub struct {
+	in     chan hubMsg
+	join   chan *client
+	leave  chan *client
+	retune chan retune
+
+	streams map[string]map[*client]struct{}
+	// warming holds when each stream without a keyframe was reset, to
+	// time how long its encoder takes to produce one.
+	warming map[string]time.Time
+	// waiting holds viewers that joined a stream before it had a keyframe
+	// to start them on; they get the cached GOP once one arrives rather
+	// than data their players can't decode.
+	waiting map[string]map[*client]struct{}
+	gops    map[string]*gopCache
+}
+
+// streamHub is the process-wide hub, set up in main.
+var streamHub *hub
+
+// readyStreams holds the keys of streams that have had a keyframe since
+// their encoder started, kept by the hub for readers outside it.
+var readyStreams sync.Map
+
+// streamReady reports whether new viewers of the stream see a picture
+// right away.
+func streamReady(key string) bool {
+	_, ok := readyStreams.Load(key)
+	return ok
+}
+
+// retune moves a client to another quality tier of its stream.
+type retune struct {
+	c       *client
+	quality string
+}
+
+func newHub() *hub {
+	h := &hub{
+		in:      make(chan hubMsg, hubQueueSize),
+		join:    make(chan *client),
+		leave:   make(chan *client),
+		retune:  make(chan retune),
+		streams: make(map[string]map[*client]struct{}),
+		waiting: make(map[string]map[*client]struct{}),
+		warming: make(map[string]time.Time),
+		gops:    make(map[string]*gopCache),
+	}
+	go h.run()
+	return h
+}
+
+func (h *hub) run() {
+	for {
+		select {
+		case c := <-h.join:
+			h.subscribe(c)
+		case c := <-h.leave:
+			h.unsubscribe(c)
+		case rt := <-h.retune:
+			if h.subscribed(rt.c) {
+				h.unsubscribe(rt.c)
+				rt.c.quality.Store(rt.quality)
+				h.subscribe(rt.c)
+			}
+		case m := <-h.in:
+			h.fanOut(m)
+		}
+	}
+}
+
+func (h *hub) subscribe(c *client) {
+	key := c.streamKey()
+	if g := h.gops[key]; g != nil && g.synced {
+		h.admit(key, g, map[*client]struct{}{c: {}})
+		return
+	}
+	addSubscriber(h.waiting, key, c)
+}
+
+// admit starts viewers on the cached GOP and then the live stream.
+func (h *hub) admit(key string, g *gopCache, viewers map[*client]struct{}) {
+	replay := &chunk{data: bytes.Clone(g.buf)}
+	replay.refs.Store(int32(len(viewers)))
+	for c := range viewers {
+		if c.enqueue(replay) != nil {
+			replay.release()
+		}
+		addSubscriber(h.streams, key, c)
+	}
+}
+
+func (h *hub) subscribed(c *client) bool {
+	key := c.streamKey()
+	_, live := h.streams[key][c]
+	_, waiting := h.waiting[key][c]
+	return live || waiting
+}
+
+func (h *hub) unsubscribe(c *client) {
+	key := c.streamKey()
+	removeSubscriber(h.streams, key, c)
+	removeSubscriber(h.waiting, key, c)
+}
+
+func addSubscriber(m map[string]map[*client]struct{}, key string, c *client) {
+	subs := m[key]
+	if subs == nil {
+		subs = make(map[*client]struct{})
+		m[key] = subs
+	}
+	subs[c] = struct{}{}
+}
+
+func removeSubscriber(m map[string]map[*client]struct{}, key string, c *client) {
+	subs := m[key]
+	delete(subs, c)
+	if len(subs) == 0 {
+		delete(m, key)
+	}
+}
+
+func (h *hub) fanOut(m hubMsg) {
+	g := h.gops[m.stream]
+	if g == nil {
+		g = &gopCache{}
+		h.gops[m.stream] = g
+	}
+	if m.chunk == nil {
+		g.reset()
+		readyStreams.Delete(m.stream)
+		h.warming[m.stream] = time.Now()
+		return
+	}
+	wasReady := g.ready
+	g.add(m.chunk.data)
+	if g.ready && !wasReady {
+		readyStreams.Store(m.stream, struct{}{})
+		if since, ok := h.warming[m.stream]; ok {
+			span := tracer.StartAt(context.Background(), "stream.warmup", tracing.KindInternal, since)
+			span.Set("remoter.stream", streamLabel(m.stream))
+			span.End()
+			delete(h.warming, m.stream)
+		}
+	}
+
+	subs := h.streams[m.stream]
+	if m.chunk.span != nil {
+		m.chunk.fanned = time.Now()
+		m.chunk.span.Event("fanned out", "viewers", len(subs))
+	}
+	m.chunk.refs.Add(int32(len(subs)))
+	for c := range subs {
+		if err := c.enqueue(m.chunk); err != nil {
+			m.chunk.release()
+			h.unsubscribe(c)
+			// Closing waits for an in-flight write; don't stall the hub.
+			go func() {
+				removeClient(c)
+				c.close()
+				if err == errClientBehind {
+					log.Printf("Dropped client %s: %v", c.describe(), err)
+				}
+			}()
+		}
+	}
+	// The publisher's reference.
+	m.chunk.release()
+
+	// The GOP already holds this chunk for viewers starting on it.
+	if waiting := h.waiting[m.stream]; len(waiting) > 0 && g.synced {
+		delete(h.waiting, m.stream)
+		h.admit(m.stream, g, waiting)
+	}
+}
+
+// publish copies data into a pooled chunk and queues it for the stream's
+// viewers; the caller may reuse data once it returns.
+func publish(stream string, data []byte) {
+	ch := chunkPool.Get().(*chunk)
+	ch.data = append(ch.data, data...)
+	ch.refs.Store(1)
+	ch.span = traceChunk(stream, len(data))
+	streamHub.in <- hubMsg{stream: stream, chunk: ch}
+}
+
+// resetStream forgets the stream's cached GOP, in order with its data.
+func resetStream(stream string) {
+	streamHub.in <- hubMsg{stream: stream}
+}
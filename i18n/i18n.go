@@ -0,0 +1,175 @@
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// builtin is the catalog of user-facing server messages, one file per
+// locale.
+//
+//go:embed locales/*.json
+var builtin embed.FS
+
+var (
+	mu       sync.RWMutex
+	catalogs = make(map[string]map[string]string)
+	fallback = "en"
+)
+
+func init() {
+	entries, _ := builtin.ReadDir("locales")
+	for _, e := range entries {
+		data, err := builtin.ReadFile("locales/" + e.Name())
+		if err != nil {
+			panic(err)
+		}
+		if err := add(strings.TrimSuffix(e.Name(), ".json"), data); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func add(locale string, data []byte) error {
+	var msgs map[string]string
+	if err := json.Unmarshal(data, &msgs); err != nil {
+		return fmt.Errorf("failed to parse %s messages: %w", locale, err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if catalogs[locale] == nil {
+		catalogs[locale] = make(map[string]string)
+	}
+	for k, v := range msgs {
+		catalogs[locale][k] = v
+	}
+	return nil
+}
+
+// LoadDir merges <locale>.json files from dir over the built-in catalog,
+// adding locales or overriding individual messages.
+func LoadDir(dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", f, err)
+		}
+		if err := add(strings.TrimSuffix(filepath.Base(f), ".json"), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetDefault sets the locale used when a request matches none of the
+// catalogs.
+func SetDefault(locale string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := catalogs[locale]; !ok {
+		return fmt.Errorf("no messages for locale %q", locale)
+	}
+	fallback = locale
+	return nil
+}
+
+// Locales lists the available locales.
+func Locales() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(catalogs))
+	for l := range catalogs {
+		names = append(names, l)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Negotiate picks the best available locale for an Accept-Language
+// header, matching "pt-BR" against "pt" when there is no exact catalog.
+func Negotiate(accept string) string {
+	type pref struct {
+		tag string
+		q   float64
+	}
+	var prefs []pref
+	for _, part := range strings.Split(accept, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if tag == "" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		prefs = append(prefs, pref{strings.ToLower(tag), q})
+	}
+	sort.SliceStable(prefs, func(i, j int) bool { return prefs[i].q > prefs[j].q })
+
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, p := range prefs {
+		if p.q <= 0 {
+			continue
+		}
+		if _, ok := catalogs[p.tag]; ok {
+			return p.tag
+		}
+		base, _, _ := strings.Cut(p.tag, "-")
+		if _, ok := catalogs[base]; ok {
+			return base
+		}
+	}
+	return fallback
+}
+
+// Locale returns the locale for r: an explicit ?lang= wins over the
+// Accept-Language header.
+func Locale(r *http.Request) string {
+	if lang := r.URL.Query().Get("lang"); lang != "" {
+		return Negotiate(lang)
+	}
+	return Negotiate(r.Header.Get("Accept-Language"))
+}
+
+// T formats message key in locale, falling back to the default locale and
+// then English when a translation is missing.
+func T(locale, key string, args ...any) string {
+	mu.RLock()
+	msg, ok := catalogs[locale][key]
+	if !ok {
+		msg, ok = catalogs[fallback][key]
+	}
+	if !ok {
+		msg, ok = catalogs["en"][key]
+	}
+	mu.RUnlock()
+	if !ok {
+		msg = key
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(msg, args...)
+	}
+	return msg
+}
+
+// Error replies to r with the localized message key and status code.
+func Error(w http.ResponseWriter, r *http.Request, status int, key string, args ...any) {
+	locale := Locale(r)
+	w.Header().Set("Content-Language", locale)
+	http.Error(w, T(locale, key, args...), status)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/nathfavour/remoter/idle"
+)
+
+// IdleConfig slows the main encoder down while nobody is watching and
+// nobody has touched the desktop for After, and brings it back as soon as
+// either changes.
+type IdleConfig struct {
+	Enabled   bool   `json:"enabled"`
+	After     string `json:"after,omitempty"`     // default 5m
+	Framerate int    `json:"framerate,omitempty"` // while idle, default 1
+	// Stop stops the encoder altogether instead; the first viewer then
+	// waits for it to start.
+	Stop bool `json:"stop,omitempty"`
+}
+
+// idleCheckInterval is how often the desktop's idle time is checked.
+const idleCheckInterval = 10 * time.Second
+
+var (
+	// encoderIdle is set while the encoder runs at idleFramerate, and
+	// idleStopped while it is stopped for being idle.
+	encoderIdle   bool
+	idleStopped   bool
+	idleFramerate int
+	idleMux       sync.Mutex
+
+	// idleWake prompts an immediate check, when a viewer arrives.
+	idleWake = make(chan struct{}, 1)
+)
+
+// encoderFramerate is the framerate the main encoder should run at.
+func encoderFramerate(cfg *Config) int {
+	idleMux.Lock()
+	defer idleMux.Unlock()
+	if encoderIdle {
+		return idleFramerate
+	}
+	return cfg.Framerate
+}
+
+// wakeEncoder asks the idle watcher to check again now.
+func wakeEncoder() {
+	select {
+	case idleWake <- struct{}{}:
+	default:
+	}
+}
+
+func watchIdle(cfg *IdleConfig) error {
+	after, err := time.ParseDuration(cmp.Or(cfg.After, "5m"))
+	if err != nil {
+		return fmt.Errorf("invalid idle timeout: %w", err)
+	}
+	idleFramerate = cmp.Or(cfg.Framerate, 1)
+
+	go func() {
+		t := time.NewTicker(idleCheckInterval)
+		defer t.Stop()
+		warned := false
+		for {
+			select {
+			case <-t.C:
+			case <-idleWake:
+			}
+
+			idleMux.Lock()
+			current := encoderIdle || idleStopped
+			idleMux.Unlock()
+
+			want := false
+			if clientCount() == 0 {
+				idleFor, err := idle.Duration(services.encoder.Settings().Display)
+				if err != nil && !warned {
+					log.Printf("Warning: idle detection unavailable: %v", err)
+				}
+				warned = err != nil
+				want = err == nil && idleFor >= after
+			}
+			if want == current {
+				continue
+			}
+			if want {
+				enterIdle(cfg.Stop)
+			} else {
+				leaveIdle()
+			}
+		}
+	}()
+	return nil
+}
+
+func enterIdle(stop bool) {
+	if !services.encoder.Status().Running {
+		return
+	}
+	if stop {
+		if err := services.stop("ffmpeg"); err != nil {
+			log.Printf("Warning: failed to stop idle encoder: %v", err)
+			return
+		}
+		idleMux.Lock()
+		idleStopped = true
+		idleMux.Unlock()
+		log.Printf("Desktop idle and unwatched, encoder stopped")
+	} else {
+		idleMux.Lock()
+		encoderIdle = true
+		idleMux.Unlock()
+		settings := services.encoder.Settings()
+		settings.Framerate = idleFramerate
+		if err := services.encoder.Update(settings); err != nil {
+			log.Printf("Warning: failed to slow down idle encoder: %v", err)
+		}
+		log.Printf("Desktop idle and unwatched, encoding at %d fps", idleFramerate)
+	}
+	emit(eventEncoderIdle, nil)
+}
+
+func leaveIdle() {
+	idleMux.Lock()
+	stopped := idleStopped
+	encoderIdle, idleStopped = false, false
+	idleMux.Unlock()
+
+	if stopped {
+		if err := services.start("ffmpeg"); err != nil {
+			log.Printf("Warning: failed to restart encoder: %v", err)
+		}
+	} else {
+		// Keep the rest of the running settings, such as an adapted bitrate.
+		settings := services.encoder.Settings()
+		services.mu.Lock()
+		settings.Framerate = degradeFramerate(services.cfg.Framerate)
+		services.mu.Unlock()
+		if err := services.encoder.Update(settings); err != nil {
+			log.Printf("Warning: failed to restore encoder framerate: %v", err)
+		}
+	}
+	log.Printf("Desktop active or watched, encoder back to full rate")
+	emit(eventEncoderActive, nil)
+}
@@ -0,0 +1,40 @@
+package idle
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// query prints the XScreenSaver idle time in milliseconds, for hosts
+// without xprintidle.
+const query = `from Xlib import display
+d = display.Display()
+print(d.screen().root.screensaver_query_info().idle)`
+
+// Duration returns how long the X display has gone without keyboard or
+// pointer input, from the XScreenSaver extension.
+func Duration(display string) (time.Duration, error) {
+	var cmd *exec.Cmd
+	if _, err := exec.LookPath("xprintidle"); err == nil {
+		cmd = exec.Command("xprintidle")
+	} else {
+		cmd = exec.Command("python3", "-c", query)
+	}
+	cmd.Env = append(os.Environ(), "DISPLAY="+display)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return 0, fmt.Errorf("failed to query idle time of %s: %v: %s", display, err, strings.TrimSpace(stderr.String()))
+	}
+	ms, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected idle time %q", strings.TrimSpace(string(out)))
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
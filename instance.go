@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// instanceLock keeps a second remoter off the port and display this one
+// serves. Each is an flock'd file holding the PID, so a crashed instance
+// leaves no lock behind and stop and status can find a running one.
+type instanceLock struct {
+	files []*os.File
+}
+
+// instanceDir holds the PID files: $XDG_RUNTIME_DIR/remoter, or a
+// per-user directory under the temp dir.
+func instanceDir() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "remoter")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("remoter-%d", os.Getuid()))
+}
+
+// makeInstanceDir creates instanceDir, or checks the one there is ours
+// alone: under /tmp its name is predictable, and whoever owns it could
+// stand in for the control socket.
+func makeInstanceDir() error {
+	dir := instanceDir()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	return checkInstanceDir()
+}
+
+// checkInstanceDir reports an instanceDir that is not a directory owned
+// by us with mode 0700.
+func checkInstanceDir() error {
+	dir := instanceDir()
+	fi, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !fi.IsDir() || !ok || int(st.Uid) != os.Getuid() || fi.Mode().Perm() != 0700 {
+		return fmt.Errorf("refusing to use %s: it must be a directory owned by uid %d with mode 0700", dir, os.Getuid())
+	}
+	return nil
+}
+
+// pidPath is the PID file of the instance serving port.
+func pidPath(port int) string {
+	return filepath.Join(instanceDir(), fmt.Sprintf("port-%d.pid", port))
+}
+
+// displayLockPath is the lock of the instance streaming display.
+func displayLockPath(display string) string {
+	return filepath.Join(instanceDir(), "display-"+strings.NewReplacer(":", "", "/", "_").Replace(display)+".pid")
+}
+
+// lockInstance claims port and, unless empty, display for this process.
+func lockInstance(port int, display string) (*instanceLock, error) {
+	if err := makeInstanceDir(); err != nil {
+		return nil, err
+	}
+	l := &instanceLock{}
+	claims := []struct{ path, what string }{{pidPath(port), fmt.Sprintf("port %d", port)}}
+	if display != "" {
+		claims = append(claims, struct{ path, what string }{displayLockPath(display), "display " + display})
+	}
+	for _, c := range claims {
+		f, err := lockPIDFile(c.path)
+		if err != nil {
+			l.release()
+			if pid, perr := readPID(c.path); perr == nil {
+				return nil, fmt.Errorf("another remoter (pid %d) is already serving %s", pid, c.what)
+			}
+			return nil, fmt.Errorf("failed to lock %s: %w", c.path, err)
+		}
+		l.files = append(l.files, f)
+	}
+	return l, nil
+}
+
+// lockPIDFile takes the lock on path without blocking and writes our PID
+// into it.
+func lockPIDFile(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Truncate(0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// release drops the locks and removes the PID files.
+func (l *instanceLock) release() {
+	if l == nil {
+		return
+	}
+	for _, f := range l.files {
+		os.Remove(f.Name())
+		f.Close()
+	}
+	l.files = nil
+}
+
+func readPID(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
+
+// runningInstance returns the PID of the remoter serving port, or an
+// error when none is: a PID file whose lock nobody holds is stale.
+func runningInstance(port int) (int, error) {
+	path := pidPath(port)
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("no remoter is running on port %d", port)
+	}
+	defer f.Close()
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_SH|syscall.LOCK_NB); err == nil {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		return 0, fmt.Errorf("no remoter is running on port %d", port)
+	}
+	return readPID(path)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// invite is a single-use share link. Invites live in memory, so a restart
+// revokes all of them.
+type invite struct {
+	ID         string    `json:"id"`
+	Session    string    `json:"session,omitempty"`
+	Role       string    `json:"role"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	RedeemedAt time.Time `json:"redeemed_at,omitempty"`
+	RedeemedBy string    `json:"redeemed_by,omitempty"`
+	Revoked    bool      `json:"revoked,omitempty"`
+}
+
+type inviteStore struct {
+	mu      sync.Mutex
+	invites map[string]*invite
+}
+
+var invites = &inviteStore{invites: make(map[string]*invite)}
+
+func (s *inviteStore) create(session, role string, ttl time.Duration) *invite {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	now := time.Now()
+	inv := &invite{
+		ID:        hex.EncodeToString(b),
+		Session:   session,
+		Role:      role,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+	s.mu.Lock()
+	s.invites[inv.ID] = inv
+	s.mu.Unlock()
+	return inv
+}
+
+// redeem uses up the invite, reporting whether it was still unused.
+func (s *inviteStore) redeem(id, remoteAddr string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	inv, ok := s.invites[id]
+	if !ok || inv.Revoked || !inv.RedeemedAt.IsZero() || time.Now().After(inv.ExpiresAt) {
+		return false
+	}
+	inv.RedeemedAt = time.Now()
+	inv.RedeemedBy = remoteAddr
+	log.Printf("Invite %s redeemed by %s", id, remoteAddr)
+	return true
+}
+
+// active reports whether a redeemed invite still grants access.
+func (s *inviteStore) active(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	inv, ok := s.invites[id]
+	return ok && !inv.Revoked && time.Now().Before(inv.ExpiresAt)
+}
+
+func (s *inviteStore) revoke(id string) bool {
+	s.mu.Lock()
+	inv, ok := s.invites[id]
+	if ok {
+		inv.Revoked = true
+	}
+	s.mu.Unlock()
+	if ok {
+		kickWhere(func(c *client) bool { return c.invite == id })
+	}
+	return ok
+}
+
+// list returns the invites, dropping those expired for more than a day.
+func (s *inviteStore) list() []invite {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cutoff := time.Now().Add(-24 * time.Hour)
+	list := make([]invite, 0, len(s.invites))
+	for id, inv := range s.invites {
+		if inv.ExpiresAt.Before(cutoff) {
+			delete(s.invites, id)
+			continue
+		}
+		list = append(list, *inv)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	return list
+}
+
+func handleCreateInvite(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		Session string `json:"session"`
+		Role    string `json:"role"`
+		TTL     string `json:"ttl"`
+	}{Role: "view", TTL: "1h"}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+			return
+		}
+	}
+	if req.Role != "view" && req.Role != "control" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("role must be view or control"))
+		return
+	}
+	ttl, err := time.ParseDuration(req.TTL)
+	if err != nil || ttl <= 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid ttl %q", req.TTL))
+		return
+	}
+	if !streamExists(req.Session) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no streaming session with id %q", req.Session))
+		return
+	}
+
+	inv := invites.create(req.Session, req.Role, ttl)
+	path := shareLink(shareGrant{
+		Session: inv.Session,
+		Role:    inv.Role,
+		Expires: inv.ExpiresAt.Unix(),
+		Invite:  inv.ID,
+	})
+	log.Printf("API: created %s invite %s, valid until %s", inv.Role, inv.ID, inv.ExpiresAt.Format(time.RFC3339))
+	if inv.Role == "control" {
+		emit(eventControlGranted, map[string]any{
+			"session":    inv.Session,
+			"invite":     inv.ID,
+			"by":         requestAuth(r).User,
+			"expires_at": inv.ExpiresAt,
+		})
+	}
+	writeJSON(w, http.StatusCreated, map[string]any{
+		"invite": inv,
+		"path":   path,
+		"url":    absoluteURL(r, path),
+	})
+}
+
+func handleListInvites(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"invites": invites.list()})
+}
+
+func handleRevokeInvite(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !invites.revoke(id) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no invite with id %q", id))
+		return
+	}
+	log.Printf("API: revoked invite %s", id)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/nathfavour/remoter/i18n"
+	"github.com/nathfavour/remoter/session"
+)
+
+// IsolationConfig turns remoter into a multi-user workstation server:
+// every authenticated user who isn't an admin gets a virtual desktop of
+// their own from Template at /my, and can reach nothing else — not the
+// main display, nor anyone else's sessions, nor the control API beyond
+// their own sessions.
+type IsolationConfig struct {
+	Enabled  bool   `json:"enabled"`
+	Template string `json:"template"`
+	// Admins are the basic auth users who keep full access; the others
+	// are isolated. OIDC users are isolated unless their role is admin.
+	Admins []string `json:"admins,omitempty"`
+}
+
+var (
+	// isolation is set in main when enabled.
+	isolation *IsolationConfig
+	// myDesktopMux serializes /my, so a user opening it in two tabs gets
+	// one desktop.
+	myDesktopMux sync.Mutex
+)
+
+func validateIsolation(cfg *IsolationConfig, templates map[string]session.Template) error {
+	if cfg == nil || !cfg.Enabled {
+		return nil
+	}
+	if _, ok := templates[cfg.Template]; !ok {
+		return fmt.Errorf("isolation template %q is not configured", cfg.Template)
+	}
+	return nil
+}
+
+// basicAuthRole is the role of a basic auth user: everyone is an admin
+// unless isolation says otherwise.
+func basicAuthRole(username string) string {
+	if isolation != nil && !slices.Contains(isolation.Admins, username) {
+		return "control"
+	}
+	return "admin"
+}
+
+// isolated reports whether a is confined to their own sessions.
+func isolated(a authInfo) bool {
+	return isolation != nil && a.User != "" && a.Role != "admin"
+}
+
+// ownsSession reports whether the session belongs to user.
+func ownsSession(user, id string) bool {
+	info, ok := sessions.Get(id)
+	return ok && info.User == user
+}
+
+// isolationPermits reports whether an isolated user may reach path: their
+// own sessions' endpoints, the sessions API (whose handlers only show them
+// their own), /my and the UI's static assets.
+func isolationPermits(user, path string) bool {
+	if rest, ok := strings.CutPrefix(path, "/s/"); ok {
+		id, _, _ := strings.Cut(rest, "/")
+		return ownsSession(user, id)
+	}
+	if strings.HasPrefix(path, "/api/") {
+		return strings.HasPrefix(path, "/api/v1/transports") ||
+			(strings.HasPrefix(path, "/api/v1/sessions") && !strings.HasPrefix(path, "/api/v1/sessions/kiosk"))
+	}
+	for _, p := range []string{"/ws", "/wt", "/live", "/stream", "/meta", "/a11y", "/cursor", "/chat", "/control", "/audio", "/mjpeg", "/thumbnail", "/delta", "/cast", "/grid", "/debug"} {
+		if path == p || strings.HasPrefix(path, p+"/") {
+			return false
+		}
+	}
+	return true
+}
+
+// guardIsolated answers requests an isolated user may not make, sending
+// them from the main viewer to their own desktop. It reports whether it
+// answered.
+func guardIsolated(w http.ResponseWriter, r *http.Request, a authInfo) bool {
+	if !isolated(a) {
+		return false
+	}
+	if r.URL.Path == "/" {
+		http.Redirect(w, r, "/my", http.StatusFound)
+		return true
+	}
+	if !isolationPermits(a.User, r.URL.Path) {
+		i18n.Error(w, r, http.StatusForbidden, "forbidden")
+		return true
+	}
+	return false
+}
+
+// handleMyDesktop sends the user to their desktop, creating it from the
+// isolation template on their first visit.
+func handleMyDesktop(w http.ResponseWriter, r *http.Request) {
+	a := requestAuth(r)
+	if isolation == nil || a.User == "" {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+	myDesktopMux.Lock()
+	defer myDesktopMux.Unlock()
+	for _, info := range sessions.List() {
+		if info.User == a.User && info.Template == isolation.Template {
+			http.Redirect(w, r, "/s/"+info.ID+"/view", http.StatusFound)
+			return
+		}
+	}
+	if err := quotas.admitSession(a.User); err != nil {
+		i18n.Error(w, r, http.StatusForbidden, "quota_exceeded", err)
+		return
+	}
+	info, err := sessions.Create(isolation.Template, a.User, "")
+	if err != nil {
+		log.Printf("Failed to create desktop for %s: %v", a.User, err)
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	quotas.ownSession(info.ID, a.User)
+	log.Printf("Created desktop %s for %s on %s", info.ID, a.User, info.Display)
+	emit(eventSessionCreated, info)
+	if err := startSessionStream(info); err != nil {
+		log.Printf("Warning: failed to start stream for session %s: %v", info.ID, err)
+	}
+	http.Redirect(w, r, "/s/"+info.ID+"/view", http.StatusFound)
+}
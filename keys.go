@@ -0,0 +1,322 @@
+package main
+
+import (
+	"cmp"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A client can prove who it is with an Ed25519 key instead of a
+// password. The key is registered once, by pairing: an admin mints a
+// pairing code for a user and role, and the client sends the code with
+// its public key to POST /pair. From then on it signs its requests (see
+// authenticate) and, on the control channel, every message it sends, so
+// the input audit log holds input only the key's owner could have sent.
+
+const (
+	// keyAuthScheme is the Authorization scheme of signed requests:
+	//
+	//	Authorization: Remoter-Key id=<key id>, ts=<unix time>, sig=<base64>
+	//
+	// where sig signs "remoter-key\n<ts>\n<method>\n<request URI>".
+	keyAuthScheme = "Remoter-Key"
+	// keyClockSkew is how far the time of a signed request may be off.
+	keyClockSkew = time.Minute
+	// pairingTTL is how long a pairing code can be redeemed.
+	pairingTTL = 10 * time.Minute
+)
+
+// clientKey is a paired client's public key and what it may do.
+type clientKey struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	User      string    `json:"user,omitempty"`
+	Role      string    `json:"role"`
+	PublicKey []byte    `json:"public_key"`
+	PairedAt  time.Time `json:"paired_at"`
+	LastUsed  time.Time `json:"last_used,omitempty"`
+}
+
+// keyStore holds the paired keys, saved next to the config.
+type keyStore struct {
+	mu   sync.Mutex
+	keys map[string]*clientKey
+	path string
+	// seen are the signatures of recent requests, until they are too old
+	// to pass anyway, so none is accepted twice.
+	seen map[string]time.Time
+}
+
+var clientKeys *keyStore
+
+// keyID is the fingerprint a key is known by.
+func keyID(pub []byte) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+func newKeyStore(path string) *keyStore {
+	s := &keyStore{keys: make(map[string]*clientKey), path: path, seen: make(map[string]time.Time)}
+	if data, err := os.ReadFile(path); err == nil {
+		if err := json.Unmarshal(data, &s.keys); err != nil {
+			log.Printf("Warning: ignoring unreadable keys file %s: %v", path, err)
+		}
+	}
+	return s
+}
+
+// save writes the store; s.mu must be held.
+func (s *keyStore) save() {
+	data, err := json.MarshalIndent(s.keys, "", "  ")
+	if err != nil {
+		return
+	}
+	if err := os.WriteFile(s.path, data, 0600); err != nil {
+		log.Printf("Warning: failed to save keys: %v", err)
+	}
+}
+
+func (s *keyStore) get(id string) (clientKey, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.keys[id]
+	if !ok {
+		return clientKey{}, false
+	}
+	return *k, true
+}
+
+func (s *keyStore) add(k *clientKey) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.keys[k.ID] != nil {
+		return false
+	}
+	s.keys[k.ID] = k
+	s.save()
+	return true
+}
+
+func (s *keyStore) remove(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.keys[id] == nil {
+		return false
+	}
+	delete(s.keys, id)
+	s.save()
+	return true
+}
+
+func (s *keyStore) list() []clientKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]clientKey, 0, len(s.keys))
+	for _, k := range s.keys {
+		list = append(list, *k)
+	}
+	slices.SortFunc(list, func(a, b clientKey) int { return a.PairedAt.Compare(b.PairedAt) })
+	return list
+}
+
+// authenticate checks a request signed with a paired key. It returns nil
+// and no error for requests that are not signed.
+func (s *keyStore) authenticate(r *http.Request) (*clientKey, error) {
+	scheme, params, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, keyAuthScheme) || s == nil {
+		return nil, nil
+	}
+	fields := make(map[string]string)
+	for _, p := range strings.Split(params, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(p), "=")
+		fields[k] = strings.Trim(v, `"`)
+	}
+	sig, err := base64.StdEncoding.DecodeString(fields["sig"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature encoding")
+	}
+	ts, err := strconv.ParseInt(fields["ts"], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timestamp")
+	}
+	if d := time.Since(time.Unix(ts, 0)); d > keyClockSkew || d < -keyClockSkew {
+		return nil, fmt.Errorf("timestamp is off by %s", d.Round(time.Second))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := s.keys[fields["id"]]
+	if k == nil {
+		return nil, fmt.Errorf("unknown key %q", fields["id"])
+	}
+	msg := fmt.Sprintf("remoter-key\n%d\n%s\n%s", ts, r.Method, r.URL.RequestURI())
+	if !ed25519.Verify(k.PublicKey, []byte(msg), sig) {
+		return nil, fmt.Errorf("bad signature for key %s", k.ID)
+	}
+	for seen, at := range s.seen {
+		if time.Since(at) > 2*keyClockSkew {
+			delete(s.seen, seen)
+		}
+	}
+	if _, replayed := s.seen[string(sig)]; replayed {
+		return nil, fmt.Errorf("replayed signature for key %s", k.ID)
+	}
+	s.seen[string(sig)] = time.Now()
+	// Saving on every request would rewrite the file for each asset.
+	if time.Since(k.LastUsed) > time.Hour {
+		k.LastUsed = time.Now()
+		s.save()
+	}
+	key := *k
+	return &key, nil
+}
+
+// pairing is a code waiting for the key of the client it pairs.
+type pairing struct {
+	user, role, name string
+	expires          time.Time
+}
+
+var (
+	pairings   = make(map[string]*pairing)
+	pairingMux sync.Mutex
+)
+
+// handleCreatePairing mints a pairing code for a user and role, "control"
+// by default.
+func handleCreatePairing(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		User string `json:"user"`
+		Role string `json:"role"`
+		Name string `json:"name"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+			return
+		}
+	}
+	if req.Role == "" {
+		req.Role = "control"
+	}
+	if roleRank[req.Role] == 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unknown role %q", req.Role))
+		return
+	}
+	if req.User == "" {
+		req.User = requestAuth(r).User
+	}
+
+	p := &pairing{user: req.User, role: req.Role, name: req.Name, expires: time.Now().Add(pairingTTL)}
+	pairingMux.Lock()
+	for code, old := range pairings {
+		if time.Now().After(old.expires) {
+			delete(pairings, code)
+		}
+	}
+	code := newHandoverCode()
+	for pairings[code] != nil {
+		code = newHandoverCode()
+	}
+	pairings[code] = p
+	pairingMux.Unlock()
+
+	log.Printf("API: created %s pairing code for %q, valid until %s", p.role, p.user, p.expires.Format(time.RFC3339))
+	writeJSON(w, http.StatusCreated, map[string]any{
+		"code":       code,
+		"user":       p.user,
+		"role":       p.role,
+		"expires_at": p.expires,
+	})
+}
+
+// handlePair registers a client's public key with a pairing code. The
+// client signs "remoter-pair\n<code>" to show it holds the private key.
+func handlePair(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Code      string `json:"code"`
+		PublicKey []byte `json:"public_key"`
+		Signature []byte `json:"signature"`
+		Name      string `json:"name"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16<<10)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	if len(req.PublicKey) != ed25519.PublicKeySize {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("public_key must be a %d-byte Ed25519 key", ed25519.PublicKeySize))
+		return
+	}
+	code := strings.ToUpper(strings.ReplaceAll(req.Code, "-", ""))
+	if !ed25519.Verify(req.PublicKey, []byte("remoter-pair\n"+code), req.Signature) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("signature does not match public_key"))
+		return
+	}
+	pairingMux.Lock()
+	p, ok := pairings[code]
+	delete(pairings, code)
+	pairingMux.Unlock()
+	if !ok || time.Now().After(p.expires) {
+		log.Printf("Rejected pairing code from %s", r.RemoteAddr)
+		emit(eventAuthFailed, map[string]any{"method": "pairing", "remote_addr": r.RemoteAddr})
+		writeError(w, http.StatusNotFound, fmt.Errorf("pairing code is invalid or has expired"))
+		return
+	}
+
+	name := p.name
+	if name == "" {
+		name, _ = chatText(req.Name, maxChatName)
+	}
+	k := &clientKey{
+		ID:        keyID(req.PublicKey),
+		Name:      cmp.Or(name, "Key from "+remoteIP(r).String()),
+		User:      p.user,
+		Role:      p.role,
+		PublicKey: req.PublicKey,
+		PairedAt:  time.Now(),
+	}
+	if !clientKeys.add(k) {
+		writeError(w, http.StatusConflict, fmt.Errorf("key %s is already paired", k.ID))
+		return
+	}
+	log.Printf("Paired key %s (%s) for %q as %s from %s", k.ID, k.Name, k.User, k.Role, r.RemoteAddr)
+	emit(eventKeyPaired, map[string]any{"key": k.ID, "name": k.Name, "user": k.User, "role": k.Role})
+	writeJSON(w, http.StatusCreated, k)
+}
+
+func handleListKeys(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, clientKeys.list())
+}
+
+// handleRevokeKey forgets a key and cuts off its control channels.
+func handleRevokeKey(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !clientKeys.remove(id) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no key with id %q", id))
+		return
+	}
+	floorMux.Lock()
+	for _, f := range floors {
+		for c := range f.members {
+			if c.keyID == id {
+				c.conn.Close()
+			}
+		}
+	}
+	floorMux.Unlock()
+	log.Printf("API: revoked key %s", id)
+	emit(eventKeyRevoked, map[string]string{"key": id})
+	w.WriteHeader(http.StatusNoContent)
+}
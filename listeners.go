@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/quic-go/webtransport-go"
+)
+
+// ListenerConfig is an extra address the server is served on.
+type ListenerConfig struct {
+	Addr string `json:"addr"` // "host:port"
+	// CertFile and KeyFile serve HTTPS; without them the listener is
+	// plain HTTP.
+	CertFile string `json:"cert_file,omitempty"`
+	KeyFile  string `json:"key_file,omitempty"`
+	// HTTP3 serves an HTTPS listener over QUIC too, on the same UDP port,
+	// and advertises it with Alt-Svc. Streams fetched over HTTP, such as
+	// /live, then stop stalling behind lost packets on lossy Wi-Fi;
+	// browsers keep WebSockets on TCP. It also serves WebTransport
+	// viewers on /wt.
+	HTTP3 bool `json:"http3,omitempty"`
+}
+
+func (l ListenerConfig) tls() bool {
+	return l.CertFile != ""
+}
+
+func validateListeners(bind string, port int, listeners []ListenerConfig) error {
+	if bind != "" && net.ParseIP(bind) == nil {
+		return fmt.Errorf("bind %q is not an IP address", bind)
+	}
+	seen := map[string]bool{net.JoinHostPort(bind, strconv.Itoa(port)): true}
+	for i, l := range listeners {
+		host, p, err := net.SplitHostPort(l.Addr)
+		if err != nil {
+			return fmt.Errorf("listener %d: address %q is not host:port", i, l.Addr)
+		}
+		if n, err := strconv.Atoi(p); err != nil || n < 1 || n > 65535 {
+			return fmt.Errorf("listener %d: invalid port in %q", i, l.Addr)
+		}
+		if host != "" && net.ParseIP(host) == nil {
+			return fmt.Errorf("listener %d: %q is not an IP address", i, host)
+		}
+		if (l.CertFile == "") != (l.KeyFile == "") {
+			return fmt.Errorf("listener %d: HTTPS needs both cert_file and key_file", i)
+		}
+		if l.HTTP3 && !l.tls() {
+			return fmt.Errorf("listener %d: HTTP/3 needs a cert_file and key_file", i)
+		}
+		if seen[l.Addr] {
+			return fmt.Errorf("listener %d: %s is listened on twice", i, l.Addr)
+		}
+		seen[l.Addr] = true
+	}
+	return nil
+}
+
+// serveListeners serves handler on host:port, on 127.0.0.1 too when host
+// is a single other address since the encoder and CLI connect there, and
+// on the extra listeners. Every address is bound before any is served, so
+// a taken one fails the start.
+func serveListeners(host string, port int, extra []ListenerConfig, handler http.Handler) error {
+	all := []ListenerConfig{{Addr: net.JoinHostPort(host, strconv.Itoa(port))}}
+	if ip := net.ParseIP(host); ip == nil || !(ip.IsUnspecified() || ip.Equal(net.IPv4(127, 0, 0, 1))) {
+		all = append(all, ListenerConfig{Addr: net.JoinHostPort("127.0.0.1", strconv.Itoa(port))})
+	}
+	all = append(all, extra...)
+
+	var closers []io.Closer
+	fail := func(err error) error {
+		for _, c := range closers {
+			c.Close()
+		}
+		return err
+	}
+	lns := make([]net.Listener, len(all))
+	h3s := make([]*h3Listener, len(all))
+	for i, l := range all {
+		ln, err := net.Listen("tcp", l.Addr)
+		if err != nil {
+			return fail(fmt.Errorf("failed to listen on %s: %w", l.Addr, err))
+		}
+		lns[i] = ln
+		closers = append(closers, ln)
+		if l.HTTP3 {
+			if h3s[i], err = listenHTTP3(l, handler); err != nil {
+				return fail(err)
+			}
+			closers = append(closers, h3s[i].conn)
+		}
+	}
+	for i, l := range all {
+		ln, h3, h := lns[i], h3s[i], handler
+		scheme := "http"
+		if l.tls() {
+			scheme = "https"
+		}
+		if h3 != nil {
+			h = h3.advertise(handler)
+			log.Printf("Starting screen share server on %s://%s, with HTTP/3", scheme, l.Addr)
+			go func() {
+				log.Fatalf("HTTP/3 server error on %s: %v", l.Addr, h3.server.Serve(h3.conn))
+			}()
+		} else {
+			log.Printf("Starting screen share server on %s://%s", scheme, l.Addr)
+		}
+		go func() {
+			var err error
+			if l.tls() {
+				err = http.ServeTLS(ln, h, l.CertFile, l.KeyFile)
+			} else {
+				err = http.Serve(ln, h)
+			}
+			log.Fatalf("Server error on %s: %v", l.Addr, err)
+		}()
+	}
+	return nil
+}
+
+// h3Listener is the QUIC side of an HTTPS listener.
+type h3Listener struct {
+	conn   net.PacketConn
+	server *webtransport.Server
+	altSvc string
+}
+
+func listenHTTP3(l ListenerConfig, handler http.Handler) (*h3Listener, error) {
+	cert, err := tls.LoadX509KeyPair(l.CertFile, l.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("listener %s: %w", l.Addr, err)
+	}
+	conn, err := net.ListenPacket("udp", l.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on udp %s: %w", l.Addr, err)
+	}
+	_, port, _ := net.SplitHostPort(l.Addr)
+	h3 := &http3.Server{
+		Handler:   handler,
+		TLSConfig: http3.ConfigureTLSConfig(&tls.Config{Certificates: []tls.Certificate{cert}}),
+		// Viewers that vanish are dropped like silent WebSocket ones.
+		QUICConfig: &quic.Config{KeepAlivePeriod: pingTimeout / 3, MaxIdleTimeout: pingTimeout},
+	}
+	wt := &webtransport.Server{H3: h3, CheckOrigin: upgrader.CheckOrigin}
+	h3.ConnContext = webTransportContext(wt)
+	webtransport.ConfigureHTTP3Server(h3)
+	return &h3Listener{conn: conn, server: wt, altSvc: fmt.Sprintf(`h3=":%s"; ma=86400`, port)}, nil
+}
+
+// advertise tells clients of the TCP listener about HTTP/3 so they can
+// switch on their next requests.
+func (h *h3Listener) advertise(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Alt-Svc", h.altSvc)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,411 @@
+package loadtest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// UserAgent marks the synthetic viewers, so their server-side figures can
+// be told apart in the clients API.
+const UserAgent = "remoter-loadtest"
+
+// skewWindow is how long a message is remembered to match its copies sent
+// to the other viewers.
+const skewWindow = 10 * time.Second
+
+type Options struct {
+	URL     string // e.g. ws://host:8080/ws
+	Clients int
+	// ReadRate caps how fast each viewer reads, in bytes per second; 0
+	// reads as fast as the server sends.
+	ReadRate int64
+	// Slow viewers read at SlowRate instead, to see how the broadcaster
+	// treats them and the others.
+	Slow     int
+	SlowRate int64
+	// Ramp is the time between two viewers connecting.
+	Ramp     time.Duration
+	Duration time.Duration
+	User     string
+	Password string
+	// API is the server's clients API, polled for its side of the
+	// figures; "" skips it.
+	API string
+	// Interval is how often Logf reports progress; 0 never does.
+	Interval time.Duration
+	Logf     func(format string, args ...any)
+}
+
+// Report sums up a run.
+type Report struct {
+	Clients   int         `json:"clients"`
+	Connected int         `json:"connected"`
+	Failed    int         `json:"failed"`  // never connected
+	Dropped   int         `json:"dropped"` // disconnected by the server
+	SlowDrops int         `json:"slow_dropped"`
+	Seconds   float64     `json:"seconds"`
+	Bytes     int64       `json:"bytes"`
+	Messages  int64       `json:"messages"`
+	FirstByte Percentiles `json:"first_byte_ms"`
+	// Skew is how much later each viewer got a message than the first
+	// one that got it: the spread of the broadcast's fan-out.
+	Skew   Percentiles    `json:"broadcast_skew_ms"`
+	Server *Server        `json:"server,omitempty"`
+	Errors map[string]int `json:"errors,omitempty"`
+}
+
+// Server is what the server reported about the synthetic viewers.
+type Server struct {
+	Polls         int         `json:"polls"`
+	WriteLatency  Percentiles `json:"write_latency_ms"`
+	QueueDepth    Percentiles `json:"queue_depth"`
+	MaxQueueDepth int         `json:"max_queue_depth"`
+	// Dropped counts the viewers that left the server's list during the
+	// run. A slow viewer may still be reading what its socket buffered
+	// long after the server dropped it, so this is the truer count.
+	Dropped int    `json:"dropped"`
+	Error   string `json:"error,omitempty"`
+}
+
+type Percentiles struct {
+	Samples int     `json:"samples"`
+	P50     float64 `json:"p50"`
+	P95     float64 `json:"p95"`
+	P99     float64 `json:"p99"`
+	Max     float64 `json:"max"`
+}
+
+func percentiles(samples []float64) Percentiles {
+	if len(samples) == 0 {
+		return Percentiles{}
+	}
+	slices.Sort(samples)
+	at := func(q float64) float64 { return samples[int(q*float64(len(samples)-1))] }
+	return Percentiles{Samples: len(samples), P50: at(0.5), P95: at(0.95), P99: at(0.99), Max: samples[len(samples)-1]}
+}
+
+type viewer struct {
+	slow     bool
+	rate     int64
+	start    time.Time // when it connected
+	bytes    int64
+	messages int64
+	dropped  bool
+}
+
+type run struct {
+	opts Options
+
+	mu        sync.Mutex
+	firstSeen map[uint64]time.Time
+	skew      []float64
+	firstByte []float64
+	errors    map[string]int
+	viewers   []*viewer
+	bytes     int64
+	messages  int64
+}
+
+// Run connects the viewers, reads the stream for Duration or until ctx is
+// done, and reports.
+func Run(ctx context.Context, opts Options) (*Report, error) {
+	u, err := url.Parse(opts.URL)
+	if err != nil || (u.Scheme != "ws" && u.Scheme != "wss") {
+		return nil, fmt.Errorf("url must be a ws:// or wss:// URL")
+	}
+	if opts.Clients <= 0 || opts.Slow < 0 || opts.Slow > opts.Clients {
+		return nil, fmt.Errorf("invalid client counts")
+	}
+	if opts.Duration <= 0 {
+		return nil, fmt.Errorf("invalid duration")
+	}
+	ctx, cancel := context.WithTimeout(ctx, opts.Duration)
+	defer cancel()
+
+	rn := &run{opts: opts, firstSeen: make(map[uint64]time.Time), errors: make(map[string]int)}
+	start := time.Now()
+
+	var srv *Server
+	polled := make(chan struct{})
+	if opts.API != "" {
+		srv = &Server{}
+		go func() {
+			defer close(polled)
+			rn.poll(ctx, srv)
+		}()
+	} else {
+		close(polled)
+	}
+	if opts.Interval > 0 && opts.Logf != nil {
+		go rn.progress(ctx, start)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Clients; i++ {
+		v := &viewer{rate: opts.ReadRate}
+		// Spread the slow viewers among the others.
+		if step := opts.Clients / max(opts.Slow, 1); opts.Slow > 0 && i%step == 0 && i/step < opts.Slow {
+			v.slow, v.rate = true, opts.SlowRate
+		}
+		rn.mu.Lock()
+		rn.viewers = append(rn.viewers, v)
+		rn.mu.Unlock()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rn.view(ctx, v)
+		}()
+		if opts.Ramp > 0 {
+			select {
+			case <-time.After(opts.Ramp):
+			case <-ctx.Done():
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	wg.Wait()
+	<-polled
+
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+	rep := &Report{
+		Clients:   len(rn.viewers),
+		Seconds:   time.Since(start).Seconds(),
+		Bytes:     rn.bytes,
+		Messages:  rn.messages,
+		FirstByte: percentiles(rn.firstByte),
+		Skew:      percentiles(rn.skew),
+		Server:    srv,
+	}
+	for _, v := range rn.viewers {
+		switch {
+		case v.start.IsZero():
+			rep.Failed++
+		case v.dropped:
+			rep.Connected++
+			rep.Dropped++
+			if v.slow {
+				rep.SlowDrops++
+			}
+		default:
+			rep.Connected++
+		}
+	}
+	if len(rn.errors) > 0 {
+		rep.Errors = rn.errors
+	}
+	return rep, nil
+}
+
+func (rn *run) fail(err error) {
+	msg := err.Error()
+	// Keep the counts readable: addresses differ between viewers.
+	if i := strings.LastIndex(msg, ": "); i >= 0 {
+		msg = msg[i+2:]
+	}
+	rn.mu.Lock()
+	rn.errors[msg]++
+	rn.mu.Unlock()
+}
+
+// view is one synthetic viewer.
+func (rn *run) view(ctx context.Context, v *viewer) {
+	header := http.Header{"User-Agent": {UserAgent}}
+	if rn.opts.User != "" {
+		r := &http.Request{Header: header}
+		r.SetBasicAuth(rn.opts.User, rn.opts.Password)
+	}
+	dialer := websocket.Dialer{HandshakeTimeout: 10 * time.Second}
+	dialStart := time.Now()
+	conn, resp, err := dialer.DialContext(ctx, rn.opts.URL, header)
+	if err != nil {
+		if ctx.Err() == nil {
+			if resp != nil {
+				err = fmt.Errorf("handshake: %s", resp.Status)
+			}
+			rn.fail(err)
+		}
+		return
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	rn.mu.Lock()
+	v.start = time.Now()
+	rn.mu.Unlock()
+
+	var read int64
+	for {
+		_, data, err := conn.ReadMessage()
+		now := time.Now()
+		if err != nil {
+			if ctx.Err() == nil {
+				rn.mu.Lock()
+				v.dropped = true
+				rn.mu.Unlock()
+				rn.fail(err)
+			}
+			return
+		}
+		rn.received(v, data, now, dialStart)
+		read += int64(len(data))
+		if v.rate > 0 {
+			// Hold off reading until the rate allows what was read so
+			// far, so the server sees the socket back up.
+			due := dialStart.Add(time.Duration(float64(read) / float64(v.rate) * float64(time.Second)))
+			select {
+			case <-time.After(time.Until(due)):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+func (rn *run) received(v *viewer, data []byte, now, dialStart time.Time) {
+	h := fnv.New64a()
+	h.Write(data)
+	key := h.Sum64()
+
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+	if v.messages == 0 {
+		rn.firstByte = append(rn.firstByte, float64(now.Sub(dialStart))/float64(time.Millisecond))
+	}
+	v.messages++
+	v.bytes += int64(len(data))
+	rn.messages++
+	rn.bytes += int64(len(data))
+	// Slow viewers lag by design; only the others measure the broadcast.
+	if v.slow {
+		return
+	}
+	if first, ok := rn.firstSeen[key]; ok {
+		rn.skew = append(rn.skew, float64(now.Sub(first))/float64(time.Millisecond))
+		return
+	}
+	rn.firstSeen[key] = now
+	if len(rn.firstSeen)%1024 == 0 {
+		for k, t := range rn.firstSeen {
+			if now.Sub(t) > skewWindow {
+				delete(rn.firstSeen, k)
+			}
+		}
+	}
+}
+
+// progress logs how the run goes every Interval.
+func (rn *run) progress(ctx context.Context, start time.Time) {
+	t := time.NewTicker(rn.opts.Interval)
+	defer t.Stop()
+	var lastBytes int64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		rn.mu.Lock()
+		connected, dropped := 0, 0
+		for _, v := range rn.viewers {
+			if v.dropped {
+				dropped++
+			} else if !v.start.IsZero() {
+				connected++
+			}
+		}
+		rate := float64(rn.bytes-lastBytes) / rn.opts.Interval.Seconds()
+		lastBytes = rn.bytes
+		rn.mu.Unlock()
+		rn.opts.Logf("%s: %d viewers connected, %d dropped, receiving %.1f MB/s in total",
+			time.Since(start).Round(time.Second), connected, dropped, rate/1e6)
+	}
+}
+
+// poll samples the server's view of the synthetic viewers every second.
+func (rn *run) poll(ctx context.Context, srv *Server) {
+	var latencies, depths []float64
+	seen := make(map[string]bool)
+	defer func() {
+		srv.WriteLatency = percentiles(latencies)
+		srv.QueueDepth = percentiles(depths)
+	}()
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		clients, err := rn.fetchClients(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				srv.Error = err.Error()
+			}
+			return
+		}
+		srv.Polls++
+		listed := make(map[string]bool)
+		for _, c := range clients {
+			if c.UserAgent != UserAgent {
+				continue
+			}
+			listed[c.ID] = true
+			seen[c.ID] = true
+			latencies = append(latencies, c.LatencyMs)
+			depths = append(depths, float64(c.QueueDepth))
+			srv.MaxQueueDepth = max(srv.MaxQueueDepth, c.QueueDepth)
+		}
+		for id := range seen {
+			if !listed[id] {
+				delete(seen, id)
+				srv.Dropped++
+			}
+		}
+	}
+}
+
+type clientInfo struct {
+	ID         string  `json:"id"`
+	UserAgent  string  `json:"user_agent"`
+	QueueDepth int     `json:"queue_depth"`
+	LatencyMs  float64 `json:"write_latency_ms"`
+}
+
+func (rn *run) fetchClients(ctx context.Context) ([]clientInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rn.opts.API, nil)
+	if err != nil {
+		return nil, err
+	}
+	if rn.opts.User != "" {
+		req.SetBasicAuth(rn.opts.User, rn.opts.Password)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach the clients API: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("clients API: %s", resp.Status)
+	}
+	var list struct {
+		Clients []clientInfo `json:"clients"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("failed to decode clients: %w", err)
+	}
+	return list.Clients, nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/nathfavour/remoter/screenlock"
+)
+
+// lockCheckInterval is how often the screen lock is checked; the lock
+// screen can reach viewers for up to this long.
+const lockCheckInterval = time.Second
+
+var (
+	// screenLocked is set while the main display is locked. The stream is
+	// paused and input injection refused until it is unlocked.
+	screenLocked atomic.Bool
+	// lockPaused is set when the lock, not a user, paused the stream.
+	lockPaused bool
+
+	errScreenLocked = errors.New("the main display is locked")
+)
+
+func watchScreenLock(display string) {
+	err := screenlock.Watch(display, lockCheckInterval, func(locked bool) {
+		screenLocked.Store(locked)
+		if locked {
+			log.Printf("Screen locked, pausing the stream")
+			if !streamPaused() {
+				lockPaused = true
+				pauseStream("screen_locked")
+			}
+			emit(eventScreenLocked, nil)
+			return
+		}
+		log.Printf("Screen unlocked")
+		if lockPaused {
+			lockPaused = false
+			resumeStream()
+		}
+		emit(eventScreenUnlocked, nil)
+	})
+	if err != nil {
+		log.Printf("Warning: screen lock awareness unavailable: %v", err)
+	}
+}
+
+// refuseWhileLocked answers requests that would drive or look at the
+// locked main display.
+func refuseWhileLocked(w http.ResponseWriter) bool {
+	if !screenLocked.Load() {
+		return false
+	}
+	writeError(w, http.StatusLocked, errScreenLocked)
+	return true
+}
@@ -0,0 +1,70 @@
+package lz4
+
+import "encoding/binary"
+
+const (
+	minMatch = 4
+	// The last match must start 12 bytes before the end, and the last 5
+	// bytes are always literals.
+	mfLimit      = 12
+	lastLiterals = 5
+	hashLog      = 14
+	maxOffset    = 65535
+)
+
+// CompressBlock compresses src as a single LZ4 block, without the frame
+// format's headers; the reader must know the uncompressed size.
+func CompressBlock(src []byte) []byte {
+	dst := make([]byte, 0, len(src)+len(src)/255+16)
+	anchor := 0
+	if len(src) > mfLimit {
+		var table [1 << hashLog]int32 // positions + 1, 0 for none
+		limit := len(src) - mfLimit
+		for i := 0; i < limit; {
+			seq := binary.LittleEndian.Uint32(src[i:])
+			h := (seq * 2654435761) >> (32 - hashLog)
+			ref := int(table[h]) - 1
+			table[h] = int32(i + 1)
+			if ref < 0 || i-ref > maxOffset || binary.LittleEndian.Uint32(src[ref:]) != seq {
+				i++
+				continue
+			}
+			n := minMatch
+			for i+n < len(src)-lastLiterals && src[ref+n] == src[i+n] {
+				n++
+			}
+			dst = appendSequence(dst, src[anchor:i], i-ref, n)
+			i += n
+			anchor = i
+		}
+	}
+	return appendSequence(dst, src[anchor:], 0, 0)
+}
+
+// appendSequence writes literals followed by a match of n bytes at offset
+// back; the block's final sequence has no match.
+func appendSequence(dst, literals []byte, offset, n int) []byte {
+	token := byte(min(len(literals), 15)) << 4
+	if n > 0 {
+		token |= byte(min(n-minMatch, 15))
+	}
+	dst = append(dst, token)
+	dst = appendLength(dst, len(literals))
+	dst = append(dst, literals...)
+	if n > 0 {
+		dst = binary.LittleEndian.AppendUint16(dst, uint16(offset))
+		dst = appendLength(dst, n-minMatch)
+	}
+	return dst
+}
+
+// appendLength continues a token's length nibble when it overflowed.
+func appendLength(dst []byte, n int) []byte {
+	if n < 15 {
+		return dst
+	}
+	for n -= 15; n >= 255; n -= 255 {
+		dst = append(dst, 255)
+	}
+	return append(dst, byte(n))
+}
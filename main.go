@@ -1,57 +1,366 @@
 package main
 
 import (
+	"cmp"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"io/fs"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
+	"os/signal"
 	"os/user"
 	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/nathfavour/remoter/a11y"
+	"github.com/nathfavour/remoter/events"
 	"github.com/nathfavour/remoter/ffmpeg"
+	"github.com/nathfavour/remoter/filesync"
+	"github.com/nathfavour/remoter/i18n"
+	"github.com/nathfavour/remoter/notify"
+	"github.com/nathfavour/remoter/relay"
+	"github.com/nathfavour/remoter/sdnotify"
+	"github.com/nathfavour/remoter/session"
+	"github.com/nathfavour/remoter/storage"
+	"github.com/nathfavour/remoter/tracing"
+	"github.com/nathfavour/remoter/vdisplay"
 	"github.com/nathfavour/remoter/vnc"
+	"github.com/nathfavour/remoter/xshm"
 )
 
 type Config struct {
-	VNC       bool   `json:"vnc"`
-	FFmpeg    bool   `json:"ffmpeg"`
+	// Title and Description label the main stream in listings and the
+	// viewer's tab title.
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+
+	// Services are what the server runs and supervises, e.g.
+	// [{"type": "stream"}, {"type": "rtmp-push", "url":
+	// "rtmp://a.rtmp.youtube.com/live2/KEY"}].
+	Services []ServiceConfig `json:"services,omitempty"`
+	// VNC and FFmpeg are the toggles of older configs, moved into Services
+	// on load.
+	VNC       bool   `json:"vnc,omitempty"`
+	FFmpeg    bool   `json:"ffmpeg,omitempty"`
 	Display   string `json:"display"`
 	Res       string `json:"res"`
 	Port      int    `json:"port"`
 	Framerate int    `json:"framerate"`
+	Bitrate   string `json:"bitrate"`
 	WebDir    string `json:"webdir"` // New field for React project directory
+
+	// Bind is the IP address served on Port, by default all of them, e.g.
+	// "127.0.0.1" to keep the server to this machine. The encoder and CLI
+	// reach it on loopback whatever it is.
+	Bind string `json:"bind,omitempty"`
+	// Listeners serve the server on more addresses, over HTTPS when they
+	// have a certificate, e.g. [{"addr": "192.168.1.5:8443", "cert_file":
+	// "...", "key_file": "..."}] next to plain HTTP on loopback.
+	Listeners []ListenerConfig `json:"listeners,omitempty"`
+
+	// AlignRefresh rounds Framerate to a whole fraction of the display's
+	// refresh rate (e.g. 25 becomes 30 on a 60Hz screen) to avoid stutter.
+	AlignRefresh bool `json:"align_refresh,omitempty"`
+
+	// Tiers are extra, smaller renditions encoded from the same capture,
+	// e.g. {"name": "low", "height": 360, "bitrate": "250k"}. Viewers pick
+	// one with /ws?quality=low or switch by sending {"quality": "low"}.
+	Tiers []ffmpeg.Tier `json:"tiers,omitempty"`
+
+	// Capture limits the stream to a rectangle of the screen; the rest of
+	// the desktop is never captured.
+	Capture *ffmpeg.Region `json:"capture,omitempty"`
+	// ROI is a region encoded at higher quality than the rest of the frame,
+	// relative to Capture when both are set.
+	ROI *ffmpeg.Region `json:"roi,omitempty"`
+	// Scale resizes the main stream to "WxH" (e.g. "1280x720") whatever the
+	// screen's size; set at runtime with PUT /api/v1/pipeline/resolution.
+	Scale string `json:"scale,omitempty"`
+	// PrivacyMasks black out or blur rectangles of the screen, or windows
+	// whose title matches a pattern (e.g. a password manager), before the
+	// frame is encoded. Rectangles are in screen coordinates.
+	PrivacyMasks []PrivacyMask `json:"privacy_masks,omitempty"`
+	// Color declares HDR or wide-gamut sources so they are tonemapped to
+	// the SDR stream instead of looking washed out.
+	Color *ffmpeg.Color `json:"color,omitempty"`
+
+	// Audio streams sound from PulseAudio/PipeWire sources to viewers on
+	// /audio, e.g. {"enabled": true, "sources": [{"name":
+	// "@DEFAULT_MONITOR@"}, {"name": "alsa_input.usb-mic", "gain_db": -6}]}.
+	// {"app": "libreoffice"} captures only that application's sound, so
+	// notifications and music elsewhere on the host stay private. Sources
+	// with "denoise" and "agc" are cleaned up for voice.
+	Audio *AudioConfig `json:"audio,omitempty"`
+
+	// HideCursor leaves the pointer out of the video, for viewers drawing
+	// it from the /cursor channel; CursorRate is how often (per second)
+	// that channel samples the pointer, 60 by default.
+	HideCursor bool `json:"hide_cursor,omitempty"`
+	CursorRate int  `json:"cursor_rate,omitempty"`
+
+	// Watermark burns the wall-clock time and the host's name into every
+	// frame of the main stream, e.g. for monitoring footage kept as
+	// evidence.
+	Watermark *ffmpeg.Watermark `json:"watermark,omitempty"`
+	// StatsOverlay draws the encoder's frame rate and bitrate, the number
+	// of viewers and the time into the main stream, for diagnosing quality
+	// from a viewer's screen.
+	StatsOverlay bool `json:"stats_overlay,omitempty"`
+
+	// Placeholder is what viewers see instead of the main stream while
+	// it is paused, the screen is locked or the encoder is down.
+	Placeholder *PlaceholderConfig `json:"placeholder,omitempty"`
+
+	// ShowInput draws clicks and keystrokes into the main stream.
+	ShowInput *ShowInputConfig `json:"show_input,omitempty"`
+
+	// RawCapture tunes /mjpeg, the X display grabbed in-process over MIT-SHM
+	// as JPEG frames, /thumbnail, a small preview of it at a frame a second,
+	// and /delta, which sends only the changed tiles; none needs ffmpeg.
+	RawCapture *RawCaptureConfig `json:"raw_capture,omitempty"`
+
+	// PauseHotkey, e.g. "ctrl+alt+p", pauses and resumes the main stream
+	// from the X display's keyboard; needs python3 with python-xlib.
+	PauseHotkey string `json:"pause_hotkey,omitempty"`
+
+	// IgnoreScreenLock keeps streaming and accepting input while the
+	// desktop is locked; by default the stream pauses until it is unlocked.
+	IgnoreScreenLock bool `json:"ignore_screen_lock,omitempty"`
+
+	// CastTo names a Chromecast or DLNA renderer (e.g. a meeting-room
+	// TV) that is sent the main stream on startup.
+	CastTo string `json:"cast_to,omitempty"`
+
+	// Accessibility serves AT-SPI events (focus, value and text changes)
+	// of the main display on /a11y; needs python3 with pyatspi.
+	Accessibility bool `json:"accessibility,omitempty"`
+	// InputAudit logs the input viewers send on the control channel, but
+	// pointer moves, to .remoter-audit.log next to this file, along with
+	// the signatures of paired keys. Typed text, passwords too, ends up in
+	// it.
+	InputAudit bool `json:"input_audit,omitempty"`
+	// StatusPage serves /status to anyone, without a login: whether the
+	// stream is live, its uptime and how many watch it, but no video.
+	StatusPage bool `json:"status_page,omitempty"`
+	// Tracing sends OpenTelemetry spans of requests, the encoder's
+	// starts and stops and chunks' way from the encoder to the viewers to
+	// an OTLP/HTTP collector.
+	Tracing *tracing.Config `json:"tracing,omitempty"`
+	// Debug serves Go's profiles at /debug/pprof/ and runtime and stream
+	// counters at /debug/vars, to admins only, or only over loopback
+	// when there is no login.
+	Debug bool `json:"debug,omitempty"`
+	// Terminal serves a shell on the host at /terminal, to admins only.
+	Terminal *TerminalConfig `json:"terminal,omitempty"`
+	// PowerActions lets admins lock or log out of the desktop, and
+	// suspend or reboot the host, through the API; not when auth is off,
+	// except over the control socket.
+	PowerActions bool `json:"power_actions,omitempty"`
+	// Sync shares a folder that viewers keep in step with their own.
+	Sync *SyncConfig `json:"sync,omitempty"`
+	// Chat relays text messages between viewers and the host.
+	Chat *ChatConfig `json:"chat,omitempty"`
+	// Commands are the only commands the API runs on the host, by name.
+	Commands map[string]CommandConfig `json:"commands,omitempty"`
+	// DesktopApps are the only apps VNC desktops created through the API
+	// run, shell commands by name, e.g. {"wm": "openbox", "browser":
+	// "firefox"}.
+	DesktopApps map[string]string `json:"desktop_apps,omitempty"`
+
+	// VirtualDisplay streams a headless display instead of Display, for
+	// servers without a monitor: an Xvfb of its own, or a mode on a
+	// virtual XRandR output of Display.
+	VirtualDisplay *vdisplay.Config `json:"virtual_display,omitempty"`
+
+	// Grids composite several monitors or streams into one stream each,
+	// e.g. {"name": "wall", "sources": [{"display": ":0", "capture": {...},
+	// "label": "left"}, {"stream": "main"}, {"url": "http://agent:8080/live"}]}.
+	Grids []GridConfig `json:"grids,omitempty"`
+
+	// RuntimeDir locates the socket when Display is a Wayland one such as
+	// "wayland-1"; see the runtime_dir of a wayland session.
+	RuntimeDir string `json:"runtime_dir,omitempty"`
+
+	Templates  map[string]session.Template `json:"templates,omitempty"`
+	CgroupRoot string                      `json:"cgroup_root,omitempty"`
+	PAMService string                      `json:"pam_service,omitempty"`
+
+	Relay     *RelayConfig     `json:"relay,omitempty"`
+	Tailscale *TailscaleConfig `json:"tailscale,omitempty"`
+
+	PortMapping *PortMappingConfig `json:"port_mapping,omitempty"`
+
+	// Allow and Deny are CIDRs (or bare IPs) checked against every request.
+	// Deny wins; a non-empty Allow rejects everything it does not list.
+	// Viewers arriving through the relay are checked by the address the
+	// relay server saw them at; the CLI uses the control socket.
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+
+	RateLimit *RateLimitConfig `json:"rate_limit,omitempty"`
+
+	// AllowedOrigins lists other origins (exact, or https://*.example.com)
+	// whose pages may open the stream or call the API. Same-origin always
+	// works; DevAllowAllOrigins accepts any origin and is meant for local
+	// UI development only.
+	AllowedOrigins     []string `json:"allowed_origins,omitempty"`
+	DevAllowAllOrigins bool     `json:"dev_allow_all_origins,omitempty"`
+
+	// Compression offers permessage-deflate to WebSocket viewers. It costs
+	// CPU per client and mostly helps control and text frames; MPEG-1 is
+	// already compressed. CompressionLevel is flate's 1 (fast) to 9.
+	Compression      bool `json:"compression,omitempty"`
+	CompressionLevel int  `json:"compression_level,omitempty"`
+
+	// PingTimeout is how long a silent WebSocket viewer is kept before it
+	// is considered gone (default 15s); pings go out at a third of it.
+	PingTimeout string `json:"ping_timeout,omitempty"`
+
+	AdaptiveBitrate *AdaptiveBitrateConfig `json:"adaptive_bitrate,omitempty"`
+	Idle            *IdleConfig            `json:"idle,omitempty"`
+	Degrade         *DegradeConfig         `json:"degrade,omitempty"`
+
+	// Users maps usernames to bcrypt hashes; when it or UsersFile (lines of
+	// "user:hash") lists anyone, every endpoint requires basic auth. Add
+	// entries with "remoter user add <name>".
+	Users     map[string]string `json:"users,omitempty"`
+	UsersFile string            `json:"users_file,omitempty"`
+	OIDC      *OIDCConfig       `json:"oidc,omitempty"`
+	// Isolation gives each non-admin user a desktop of their own and
+	// nothing else.
+	Isolation *IsolationConfig `json:"isolation,omitempty"`
+
+	// Locale is the language of server messages for viewers whose
+	// Accept-Language matches no catalog; MessagesDir holds <locale>.json
+	// files that add languages or override built-in messages.
+	Locale      string `json:"locale,omitempty"`
+	MessagesDir string `json:"messages_dir,omitempty"`
+
+	// Recordings selects where recordings are stored: a local directory
+	// (~/Videos/remoter by default), S3, WebDAV or SFTP.
+	Recordings *storage.Config `json:"recordings,omitempty"`
+	// RecordingSchedules record streams unattended at cron times, also
+	// managed through /api/v1/schedules.
+	RecordingSchedules []RecordingSchedule `json:"recording_schedules,omitempty"`
+	// RecordingRetention prunes old recordings to bound their age and the
+	// space they take.
+	RecordingRetention *RetentionPolicy `json:"recording_retention,omitempty"`
+	// Replay keeps the last minutes of a stream to export after the fact.
+	Replay *ReplayConfig `json:"replay,omitempty"`
+
+	// Quotas limit each authenticated user, keyed by name; "*" applies to
+	// users without an entry of their own.
+	Quotas map[string]QuotaConfig `json:"quotas,omitempty"`
+
+	// Events publishes connects, control grants, encoder state changes,
+	// sessions and recordings to a NATS or MQTT broker.
+	Events *events.Config `json:"events,omitempty"`
+	// Notify sends chosen events to people, by mail, Telegram, ntfy or
+	// Gotify.
+	Notify []notify.Config `json:"notify,omitempty"`
+	// Webhooks are URLs each event is POSTed to as JSON, e.g. for alerting
+	// or a Slack channel.
+	Webhooks []events.WebhookConfig `json:"webhooks,omitempty"`
+	// GRPC serves the control API over gRPC too, for services that want
+	// typed calls and streamed stats and input.
+	GRPC *GRPCConfig `json:"grpc,omitempty"`
+	// MQTT keeps the stream's state on a broker, retained, and takes
+	// pause and resume commands from it, e.g. for Home Assistant.
+	MQTT *MQTTConfig `json:"mqtt,omitempty"`
+
+	// ShareSecret signs session share links and login cookies; generated
+	// on first start. Changing it revokes every outstanding link and login.
+	ShareSecret string `json:"share_secret,omitempty"`
+}
+
+// PortMappingConfig asks the local router to forward a port to remoter via
+// UPnP IGD or NAT-PMP.
+type PortMappingConfig struct {
+	Enabled      bool   `json:"enabled"`
+	Method       string `json:"method,omitempty"`        // "auto", "upnp" or "natpmp"
+	ExternalPort int    `json:"external_port,omitempty"` // defaults to port
+}
+
+// RelayConfig points the host at a relay server so viewers can reach it
+// without port forwarding.
+type RelayConfig struct {
+	URL    string `json:"url"` // e.g. wss://relay.example.com
+	ID     string `json:"id"`
+	Secret string `json:"secret"`
 }
 
 var (
-	upgrader = websocket.Upgrader{
-		CheckOrigin: func(r *http.Request) bool { return true },
-	}
-	clients    = make(map[*websocket.Conn]bool)
-	clientsMux sync.RWMutex
+	// upgrader's CheckOrigin and compression are set from the config at
+	// startup.
+	upgrader         = websocket.Upgrader{}
+	compressionLevel = 1
+	clients          = make(map[*client]bool)
+	clientsMux       sync.RWMutex
 )
 
 func defaultConfig() *Config {
 	return &Config{
-		VNC:       false,
-		FFmpeg:    true,
+		Services:  []ServiceConfig{{Type: serviceStream}},
 		Display:   ":0.0",
 		Res:       "1920x1080x24",
 		Port:      8081,
 		Framerate: 25,
+		Bitrate:   "800k",
 		WebDir:    "web", // Default React project directory
 	}
 }
 
+// configFlag is the config file given with --config, overriding the
+// default location.
+var configFlag string
+
+// getConfigPath returns the config file: the one given with --config,
+// else $XDG_CONFIG_HOME/remoter/config.json, unless only the legacy
+// ~/.remoter.json exists. State files such as the paired keys live next
+// to it.
 func getConfigPath() (string, error) {
+	if configFlag != "" {
+		return filepath.Abs(configFlag)
+	}
 	usr, err := user.Current()
 	if err != nil {
 		return "", fmt.Errorf("failed to get current user: %w", err)
 	}
-	return filepath.Join(usr.HomeDir, ".remoter.json"), nil
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = filepath.Join(usr.HomeDir, ".config")
+	}
+	path := filepath.Join(dir, "remoter", "config.json")
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	legacy := filepath.Join(usr.HomeDir, ".remoter.json")
+	if _, err := os.Stat(legacy); err == nil {
+		return legacy, nil
+	}
+	return path, nil
+}
+
+// parseGlobalFlags takes the flags before the subcommand, if any, off
+// args.
+func parseGlobalFlags(args []string) []string {
+	fs := flag.NewFlagSet("remoter", flag.ExitOnError)
+	fs.Usage = printUsage
+	fs.StringVar(&configFlag, "config", "", "config file (default $XDG_CONFIG_HOME/remoter/config.json)")
+	fs.BoolVar(&daemonFlag, "daemon", false, "start the server in the background")
+	fs.Parse(args)
+	return fs.Args()
 }
 
 func loadOrCreateConfig() (*Config, error) {
@@ -64,6 +373,7 @@ func loadOrCreateConfig() (*Config, error) {
 	if err != nil {
 		if os.IsNotExist(err) {
 			cfg := defaultConfig()
+			cfg.ShareSecret = newShareSecret()
 			if err := saveConfig(cfg, path); err != nil {
 				return nil, fmt.Errorf("failed to create default config: %w", err)
 			}
@@ -74,12 +384,28 @@ func loadOrCreateConfig() (*Config, error) {
 	}
 	defer f.Close()
 
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
 	var cfg Config
-	if err := json.NewDecoder(f).Decode(&cfg); err != nil {
+	if err := decodeConfig(data, &cfg, false); err != nil {
 		return nil, fmt.Errorf("failed to parse config file: %w", err)
 	}
 
-	updated := false
+	if applyDefaults(&cfg) {
+		if err := saveConfig(&cfg, path); err != nil {
+			log.Printf("Warning: failed to update config file: %v", err)
+		}
+	}
+
+	return &cfg, nil
+}
+
+// applyDefaults fills in the settings older config files lack, reporting
+// whether it changed anything.
+func applyDefaults(cfg *Config) bool {
+	updated := migrateServices(cfg)
 	if cfg.Port == 0 {
 		cfg.Port = 8081
 		updated = true
@@ -88,18 +414,19 @@ func loadOrCreateConfig() (*Config, error) {
 		cfg.Framerate = 25
 		updated = true
 	}
+	if cfg.Bitrate == "" {
+		cfg.Bitrate = "800k"
+		updated = true
+	}
 	if cfg.WebDir == "" {
 		cfg.WebDir = "web"
 		updated = true
 	}
-
-	if updated {
-		if err := saveConfig(&cfg, path); err != nil {
-			log.Printf("Warning: failed to update config file: %v", err)
-		}
+	if cfg.ShareSecret == "" {
+		cfg.ShareSecret = newShareSecret()
+		updated = true
 	}
-
-	return &cfg, nil
+	return updated
 }
 
 func saveConfig(cfg *Config, path string) error {
@@ -107,96 +434,111 @@ func saveConfig(cfg *Config, path string) error {
 	if err != nil {
 		return fmt.Errorf("failed to marshal config: %w", err)
 	}
-	if err := os.WriteFile(path, data, 0644); err != nil {
+	// It holds the share secret and password hashes: for our eyes only.
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+	// WriteFile keeps the mode of a file that already exists.
+	if err := os.Chmod(path, 0600); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to restrict config file: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
 		return fmt.Errorf("failed to write config file: %w", err)
 	}
 	return nil
 }
 
-func broadcast(data []byte) {
-	clientsMux.RLock()
-	defer clientsMux.RUnlock()
-
-	var disconnected []*websocket.Conn
-	for client := range clients {
-		if err := client.WriteMessage(websocket.BinaryMessage, data); err != nil {
-			disconnected = append(disconnected, client)
-		}
+func handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	if !streamExists(r.PathValue("session")) {
+		i18n.Error(w, r, http.StatusNotFound, "no_such_session")
+		return
 	}
-
-	if len(disconnected) > 0 {
-		clientsMux.RUnlock()
-		clientsMux.Lock()
-		for _, client := range disconnected {
-			client.Close()
-			delete(clients, client)
-		}
-		clientsMux.Unlock()
-		clientsMux.RLock()
+	if err := quotas.admitStream(requestAuth(r).User); err != nil {
+		i18n.Error(w, r, http.StatusForbidden, "quota_exceeded", err)
+		return
 	}
-}
-
-func handleWebSocket(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
+	meta, _ := streamMetaFor(r.PathValue("session"))
+	header := meta.header()
+	viewerDevices.identify(header, r)
+	conn, err := upgrader.Upgrade(w, r, header)
 	if err != nil {
 		log.Printf("WebSocket upgrade error: %v", err)
 		return
 	}
 
-	clientsMux.Lock()
-	clients[conn] = true
-	totalClients := len(clients)
-	clientsMux.Unlock()
+	negotiateCompression(conn, r)
+	c := newWebSocketClient(conn, r)
+	totalClients := addClient(c)
+	recordFallback(r, transportWebSocket)
 
-	log.Printf("New WebSocket client connected. Total clients: %d", totalClients)
+	log.Printf("New WebSocket client %s connected. Total clients: %d", c.describe(), totalClients)
+	if c.stream == "" {
+		greetPlaceholder(c)
+	}
 
+	keepAlive(conn, c.done)
 	conn.SetCloseHandler(func(code int, text string) error {
-		clientsMux.Lock()
-		delete(clients, conn)
-		totalClients := len(clients)
-		clientsMux.Unlock()
-		log.Printf("Client disconnected. Total clients: %d", totalClients)
+		totalClients := removeClient(c)
+		log.Printf("Client %s disconnected. Total clients: %d", c.describe(), totalClients)
 		return nil
 	})
 
 	for {
-		_, _, err := conn.ReadMessage()
+		typ, data, err := conn.ReadMessage()
+		if typ == websocket.TextMessage {
+			handleControlMessage(c, data)
+		}
 		if err != nil {
-			clientsMux.Lock()
-			delete(clients, conn)
-			totalClients := len(clients)
-			clientsMux.Unlock()
-			log.Printf("Client disconnected due to read error: %v. Total clients: %d", err, totalClients)
+			totalClients := removeClient(c)
+			c.close()
+			log.Printf("Client %s disconnected due to read error: %v. Total clients: %d", c.describe(), err, totalClients)
 			break
 		}
 	}
 }
 
+// handleStream takes MPEG-1 posted by an encoder outside remoter; its
+// own encoders hand their output to ingestStream over pipes.
 func handleStream(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" && r.Method != "PUT" {
-		http.Error(w, "Only POST/PUT methods allowed", http.StatusMethodNotAllowed)
+		i18n.Error(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "POST/PUT")
 		return
 	}
+	if !requireAdmin(w, r) {
+		return
+	}
+	ingestStream(r.PathValue("session"), r.URL.Query().Get("quality"), r.Body)
+}
 
+// ingestStream publishes an encoder's output for session ("" for main) at
+// quality until body ends.
+func ingestStream(session, quality string, body io.Reader) {
+	stream := streamKey(session, quality)
 	log.Printf("FFmpeg stream connected")
 	defer log.Printf("FFmpeg stream disconnected")
+	if session == "" {
+		feedConnected(stream)
+		defer feedDisconnected(stream)
+	}
+	// A new encoder may use different settings than the cached GOP.
+	resetStream(stream)
+	defer resetStream(stream)
 
 	buf := make([]byte, 4096)
 	totalBytes := 0
 	frameCount := 0
 
 	for {
-		n, err := r.Body.Read(buf)
-		if n > 0 {
+		n, err := body.Read(buf)
+		// A paused main stream keeps its encoder but shows viewers the
+		// placeholder instead.
+		if n > 0 && !(session == "" && streamPaused()) {
 			totalBytes += n
-			broadcast(buf[:n])
+			publish(stream, buf[:n])
 			frameCount++
 
 			if frameCount%100 == 0 {
-				clientsMux.RLock()
-				clientCount := len(clients)
-				clientsMux.RUnlock()
-				log.Printf("Streamed %d bytes, %d frames to %d clients", totalBytes, frameCount, clientCount)
+				log.Printf("Streamed %d bytes, %d frames to %d clients", totalBytes, frameCount, clientCount())
 			}
 		}
 		if err != nil {
@@ -222,7 +564,8 @@ func buildReactApp(webDir string) error {
 	return nil
 }
 
-func startScreenShareServer(port int, webDir string) error {
+func startScreenShareServer(cfg *Config) error {
+	port, webDir := cfg.Port, cfg.WebDir
 	if err := buildReactApp(webDir); err != nil {
 		return err
 	}
@@ -237,46 +580,156 @@ func startScreenShareServer(port int, webDir string) error {
 
 	http.HandleFunc("/ws", handleWebSocket)
 	http.HandleFunc("/stream", handleStream)
-
-	addr := fmt.Sprintf("0.0.0.0:%d", port)
-	log.Printf("Starting screen share server on %s", addr)
-
-	go func() {
-		if err := http.ListenAndServe(addr, nil); err != nil {
-			log.Fatalf("Server error: %v", err)
+	http.HandleFunc("/live", handleLive)
+	http.HandleFunc("/stream/{session}", handleStream)
+	http.HandleFunc("/s/{session}/ws", handleWebSocket)
+	http.HandleFunc("/s/{session}/live", handleLive)
+	http.HandleFunc("/wt", handleWebTransport)
+	http.HandleFunc("/s/{session}/wt", handleWebTransport)
+	http.HandleFunc("/a11y", handleA11y)
+	http.HandleFunc("/terminal", handleTerminal)
+	http.HandleFunc("/cursor", handleCursor)
+	http.HandleFunc("GET /audio", handleAudio)
+	http.HandleFunc("GET /mjpeg", handleMJPEG)
+	http.HandleFunc("GET /s/{session}/mjpeg", handleMJPEG)
+	http.HandleFunc("GET /thumbnail", handleThumbnail)
+	http.HandleFunc("GET /s/{session}/thumbnail", handleThumbnail)
+	http.HandleFunc("/delta", handleDelta)
+	http.HandleFunc("/s/{session}/delta", handleDelta)
+	http.HandleFunc("/s/{session}/cursor", handleCursor)
+	http.HandleFunc("/control", handleControl)
+	http.HandleFunc("/s/{session}/control", handleControl)
+	http.HandleFunc("/chat", handleChat)
+	http.HandleFunc("/s/{session}/chat", handleChat)
+	http.HandleFunc("GET /cast/{id}", handleCastMedia)
+	http.HandleFunc("GET /grid/{token}/{stream}", handleGridFeed)
+	http.HandleFunc("GET /my", handleMyDesktop)
+	http.HandleFunc("GET /status", handlePublicStatus)
+	http.HandleFunc("GET /healthz", handleHealth)
+	http.HandleFunc("GET /placeholder", handlePlaceholderPage)
+	http.HandleFunc("POST /handover", handleStartHandover)
+	http.HandleFunc("GET /handover/{code}", handleRedeemHandover)
+	http.HandleFunc("POST /pair", handlePair)
+	http.HandleFunc("GET /meta", handleStreamMeta)
+	http.HandleFunc("GET /s/{session}/meta", handleStreamMeta)
+	http.HandleFunc("GET /s/{session}/view", func(w http.ResponseWriter, r *http.Request) {
+		if !streamExists(r.PathValue("session")) {
+			i18n.Error(w, r, http.StatusNotFound, "no_such_session")
+			return
 		}
-	}()
+		// The UI derives the stream endpoints from this path.
+		http.ServeFile(w, r, filepath.Join(buildDir, "index.html"))
+	})
+	registerAPI(http.DefaultServeMux)
 
-	return nil
-}
+	filter, err := newIPFilter(cfg.Allow, cfg.Deny)
+	if err != nil {
+		return err
+	}
+	auth, err := newAuthenticator(cfg.Users, cfg.UsersFile, cfg.OIDC)
+	if err != nil {
+		return err
+	}
+	auth.register(http.DefaultServeMux)
+	authOff = auth.off()
+	limits := newRateLimits(cfg.RateLimit)
+	if cfg.GRPC != nil && cfg.GRPC.Listen != "" {
+		if err := serveGRPC(cfg.GRPC, auth, filter, limits); err != nil {
+			return err
+		}
+	}
+	origins, err := newOriginPolicy(cfg.AllowedOrigins, cfg.DevAllowAllOrigins)
+	if err != nil {
+		return err
+	}
+	upgrader.CheckOrigin = origins.checkOrigin
+	upgrader.EnableCompression = cfg.Compression
+	if cfg.PingTimeout != "" {
+		d, err := time.ParseDuration(cfg.PingTimeout)
+		if err != nil || d < time.Second {
+			return fmt.Errorf("invalid ping_timeout %q", cfg.PingTimeout)
+		}
+		pingTimeout = d
+	}
+	if cfg.CompressionLevel != 0 {
+		if cfg.CompressionLevel < 1 || cfg.CompressionLevel > 9 {
+			return fmt.Errorf("compression_level must be between 1 and 9")
+		}
+		compressionLevel = cfg.CompressionLevel
+	}
+	if cfg.CursorRate > 0 {
+		cursorRate = cfg.CursorRate
+	}
+	if cfg.AdaptiveBitrate != nil && cfg.AdaptiveBitrate.Enabled {
+		if err := adaptBitrate(cfg.AdaptiveBitrate); err != nil {
+			return err
+		}
+	}
+	if cfg.StatsOverlay {
+		if err := drawStats(); err != nil {
+			return err
+		}
+	}
+	if cfg.Idle != nil && cfg.Idle.Enabled {
+		if err := watchIdle(cfg.Idle); err != nil {
+			return err
+		}
+	}
+	if cfg.Degrade != nil && cfg.Degrade.Enabled {
+		if err := degradeUnderLoad(cfg.Degrade); err != nil {
+			return err
+		}
+	}
+	if !cfg.IgnoreScreenLock {
+		go watchScreenLock(mainDisplay(cfg))
+	}
+	go watchIdleGaps(mainDisplay(cfg))
+	go watchDisplay(mainDisplay(cfg))
+	if cfg.PauseHotkey != "" {
+		if err := grabHotkey(mainDisplay(cfg), cfg.PauseHotkey, togglePause); err != nil {
+			log.Printf("Warning: pause hotkey unavailable: %v", err)
+		}
+	}
 
-func startServices(cfg *Config) error {
-	servicesStarted := 0
+	// Limits apply before authentication so floods cannot burn bcrypt, and
+	// CORS preflights carry no credentials.
+	handler := traceRequests(filter.wrap(limits.wrap(origins.wrap(auth.wrap(guardDebug(http.DefaultServeMux))))))
 
-	if cfg.FFmpeg {
-		if err := startScreenShareServer(cfg.Port, cfg.WebDir); err != nil {
-			return fmt.Errorf("failed to start screen share server: %w", err)
+	if slices.ContainsFunc(cfg.Listeners, func(l ListenerConfig) bool { return l.HTTP3 }) {
+		transportEndpoints[transportWebTransport] = "/wt"
+	}
+	host := cmp.Or(cfg.Bind, "0.0.0.0")
+	if ts := cfg.Tailscale; ts != nil && ts.Enabled {
+		if ts.Only {
+			// Keep a loopback listener for local browsers.
+			host = "127.0.0.1"
 		}
-
 		go func() {
-			log.Printf("Starting FFmpeg service...")
-			if err := ffmpeg.StartFFmpeg(cfg.Display, cfg.Res, cfg.Port); err != nil {
-				log.Fatalf("FFmpeg error: %v", err)
+			if err := serveTailnet(ts, handler); err != nil {
+				log.Printf("Tailscale error: %v", err)
 			}
 		}()
-		servicesStarted++
-		log.Printf("FFmpeg service configured")
 	}
 
-	if cfg.VNC {
-		go func() {
-			log.Printf("Starting VNC service...")
-			if err := vnc.StartVNC(cfg.Display, cfg.Res); err != nil {
-				log.Fatalf("VNC error: %v", err)
-			}
-		}()
-		servicesStarted++
-		log.Printf("VNC service configured")
+	if err := serveListeners(host, port, cfg.Listeners, handler); err != nil {
+		return err
+	}
+	if cfg.Relay != nil && cfg.Relay.URL != "" {
+		if err := serveRelaySocket(port, handler); err != nil {
+			return err
+		}
+	}
+	return serveControlSocket(port, guardDebug(http.DefaultServeMux))
+}
+
+func startServices(cfg *Config) error {
+	servicesStarted := startDeclared()
+
+	if cfg.Audio != nil && cfg.Audio.Enabled {
+		log.Printf("Starting audio service...")
+		if err := services.start("audio"); err != nil {
+			log.Printf("Audio error: %v", err)
+		}
 	}
 
 	if servicesStarted == 0 {
@@ -288,6 +741,26 @@ func startServices(cfg *Config) error {
 }
 
 func main() {
+	args := parseGlobalFlags(os.Args[1:])
+	if len(args) > 0 {
+		if err := runCommand(args); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if daemonFlag {
+		cfg, err := loadOrCreateConfig()
+		if err == nil {
+			err = daemonize(cfg.Port)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	log.Printf("Starting Remoter v1.0")
 
 	cfg, err := loadOrCreateConfig()
@@ -295,22 +768,233 @@ func main() {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
-	log.Printf("Configuration loaded: Display=%s, Port=%d, VNC=%t, FFmpeg=%t",
-		cfg.Display, cfg.Port, cfg.VNC, cfg.FFmpeg)
+	if problems := checkConfig(cfg); len(problems) > 0 {
+		for _, p := range problems {
+			log.Printf("Invalid configuration: %s", p)
+		}
+		log.Fatalf("Refusing to start; `remoter config validate` checks the file")
+	}
+	placeholder = cfg.Placeholder
+	if cfg.Isolation != nil && cfg.Isolation.Enabled {
+		isolation = cfg.Isolation
+	}
+	declareServices(cfg.Services)
+
+	var names []string
+	for _, s := range cfg.Services {
+		names = append(names, s.Name)
+	}
+	log.Printf("Configuration loaded: Display=%s, Port=%d, Services=%s",
+		cfg.Display, cfg.Port, strings.Join(names, ","))
+
+	path, err := getConfigPath()
+	if err != nil {
+		log.Fatalf("Failed to resolve configuration path: %v", err)
+	}
+	// A virtual display is a free one of its own.
+	lockDisplay := cfg.Display
+	if vd := cfg.VirtualDisplay; vd != nil && vd.Enabled {
+		lockDisplay = ""
+	}
+	lock, err := lockInstance(cfg.Port, lockDisplay)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if vd := cfg.VirtualDisplay; vd != nil && vd.Enabled {
+		if virtualDisplay, err = vdisplay.Start(*vd, cfg.Display); err != nil {
+			log.Fatalf("Failed to create virtual display: %v", err)
+		}
+		log.Printf("Streaming virtual display %s (%s, %s)", virtualDisplay.Name, virtualDisplay.Method, virtualDisplay.Res)
+	}
+	if si := cfg.ShowInput; si != nil && (si.Clicks || si.Keys) {
+		if err := startInputVisualizer(si, mainDisplay(cfg)); err != nil {
+			log.Printf("Warning: input visualization unavailable: %v", err)
+		}
+	}
+	if cfg.RawCapture != nil {
+		rawCapture = *cfg.RawCapture
+	}
+	// In-process captures hide what the encoder's mask filter does.
+	xshm.Masks = screenMasks
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		log.Printf("ffmpeg not found; /mjpeg, /thumbnail and /delta still serve the X display without it")
+	}
+	services = newServiceManager(cfg, path)
+	sessions = session.NewManager(session.Config{
+		Templates:  cfg.Templates,
+		CgroupRoot: cfg.CgroupRoot,
+		PAMService: cfg.PAMService,
+	})
+	desktops = vnc.NewManager()
+	shareSecret = []byte(cfg.ShareSecret)
+	streamHub = newHub()
+	bus = events.NewBus()
+	if cfg.Events != nil {
+		sink, err := events.NewBroker(*cfg.Events)
+		if err != nil {
+			log.Fatalf("Invalid event broker: %v", err)
+		}
+		bus.Add(cfg.Events.Type, sink)
+	}
+	for _, n := range cfg.Notify {
+		sink, err := notify.New(n)
+		if err != nil {
+			log.Fatalf("Invalid notifier: %v", err)
+		}
+		bus.Add(n.Type+" notifier", sink)
+	}
+	for _, wh := range cfg.Webhooks {
+		sink, err := events.NewWebhook(wh)
+		if err != nil {
+			log.Fatalf("Invalid webhook: %v", err)
+		}
+		name := "webhook"
+		if u, err := url.Parse(wh.URL); err == nil {
+			name += " " + u.Host
+		}
+		bus.Add(name, sink)
+	}
+	if cfg.MQTT != nil {
+		if mqttState, err = startMQTT(cfg.MQTT); err != nil {
+			log.Fatalf("Invalid mqtt configuration: %v", err)
+		}
+	}
+	quotas = newQuotaTracker(cfg.Quotas, filepath.Join(filepath.Dir(path), ".remoter-usage.json"))
+	viewerDevices = newDeviceStore(filepath.Join(filepath.Dir(path), ".remoter-devices.json"))
+	clientKeys = newKeyStore(filepath.Join(filepath.Dir(path), ".remoter-keys.json"))
+	if cfg.InputAudit {
+		if inputAudit, err = openAuditLog(filepath.Join(filepath.Dir(path), ".remoter-audit.log")); err != nil {
+			log.Fatalf("Failed to start the input audit: %v", err)
+		}
+	}
+	var storeCfg storage.Config
+	if cfg.Recordings != nil {
+		storeCfg = *cfg.Recordings
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home = filepath.Dir(path)
+	}
+	if (storeCfg.Type == "" || storeCfg.Type == "local") && storeCfg.Dir == "" {
+		storeCfg.Dir = filepath.Join(home, "Videos", "remoter")
+	}
+	if recordingStore, err = storage.New(storeCfg); err != nil {
+		log.Fatalf("Invalid recording storage: %v", err)
+	}
+	if s := cfg.Sync; s != nil && s.Enabled {
+		dir := cmp.Or(s.Dir, filepath.Join(home, "Sync", "remoter"))
+		if syncFolder, err = filesync.NewFolder(dir, cmp.Or(s.MaxFileBytes, 100<<20), cmp.Or(s.MaxTotalBytes, 1<<30)); err != nil {
+			log.Fatalf("Invalid sync folder: %v", err)
+		}
+		log.Printf("Syncing folder %s", dir)
+	}
+	if cfg.Chat != nil && cfg.Chat.Enabled {
+		chat = newChatRoom(cfg.Chat)
+	}
+	statusPage = cfg.StatusPage
+	if cfg.Tracing != nil {
+		if tracer, err = tracing.New(*cfg.Tracing); err != nil {
+			log.Fatalf("Invalid tracing configuration: %v", err)
+		}
+		log.Printf("Sending traces to %s", cfg.Tracing.Endpoint)
+	}
+	if cfg.Debug {
+		enableDebug()
+	}
+	if cfg.Accessibility {
+		a11yMonitor = a11y.NewMonitor(mainDisplay(cfg))
+	}
+	if cfg.Terminal != nil && cfg.Terminal.Enabled {
+		terminalConfig = cfg.Terminal
+	}
+	commands = cfg.Commands
+	powerActions = cfg.PowerActions
+	desktopApps = cfg.DesktopApps
+	if cfg.MessagesDir != "" {
+		if err := i18n.LoadDir(cfg.MessagesDir); err != nil {
+			log.Fatalf("Failed to load messages: %v", err)
+		}
+	}
+	if cfg.Locale != "" {
+		if err := i18n.SetDefault(cfg.Locale); err != nil {
+			log.Fatalf("Invalid locale: %v", err)
+		}
+	}
+	go reapLoop(30 * time.Second)
+	go watchMaskedWindows()
+
+	if err := startScreenShareServer(cfg); err != nil {
+		log.Fatalf("Failed to start screen share server: %v", err)
+	}
 
 	if err := startServices(cfg); err != nil {
-		log.Printf("No screen sharing services enabled.")
-		log.Printf("Edit ~/.remoter.json to enable VNC and/or FFmpeg.")
+		log.Printf("No screen sharing services enabled: %v", err)
+		log.Printf("Declare services in %s, or start them at", path)
+		log.Printf("runtime with POST /api/v1/services/{ffmpeg,vnc}/start.")
 		log.Printf("Example configuration:")
 		example := defaultConfig()
-		example.FFmpeg = true
 		data, _ := json.MarshalIndent(example, "", "  ")
 		log.Printf("\n%s", string(data))
-		return
+	}
+	notifySystemd(cfg.Port)
+
+	startGrids(cfg.Grids, cfg.Port)
+	startSchedules(cfg.RecordingSchedules)
+	startRetention(cfg.RecordingRetention)
+	if c := cfg.Replay; c != nil && c.Enabled {
+		if err := startReplay(c); err != nil {
+			log.Printf("Warning: replay buffer unavailable: %v", err)
+		}
+	}
+
+	if cfg.CastTo != "" {
+		go func() {
+			if _, err := startCast(cfg.CastTo, "", ""); err != nil {
+				log.Printf("Warning: failed to cast to %s: %v", cfg.CastTo, err)
+			}
+		}()
+	}
+
+	if cfg.Relay != nil && cfg.Relay.URL != "" {
+		go relay.NewClient(cfg.Relay.URL, cfg.Relay.ID, cfg.Relay.Secret, "unix", relaySocketPath(cfg.Port)).Run()
+	}
+
+	if pm := cfg.PortMapping; pm != nil && pm.Enabled {
+		go mapPort(pm, cfg.Port)
 	}
 
 	log.Printf("Remoter is running. Visit http://localhost:%d to view the stream.", cfg.Port)
 	log.Printf("Press Ctrl+C to stop.")
 
-	select {}
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	<-sig
+
+	log.Printf("Shutting down...")
+	sdnotify.Notify("STOPPING=1")
+	stopDeclared()
+	stopCasts()
+	stopSchedules()
+	stopRecordings()
+	stopReplay()
+	stopGrids()
+	stopSessionStreams()
+	sessions.DestroyAll()
+	desktops.DestroyAll()
+	services.stopAll()
+	if inputVisualizer != nil {
+		inputVisualizer.Close()
+	}
+	if virtualDisplay != nil {
+		virtualDisplay.Close()
+	}
+	unmapPort()
+	if mqttState != nil {
+		mqttState.stop()
+	}
+	inputAudit.Close()
+	bus.Close(2 * time.Second)
+	tracer.Close(2 * time.Second)
+	closeLocalSockets()
+	lock.release()
 }
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nathfavour/remoter/i18n"
+)
+
+// ipFilter rejects requests whose source address is denied or, when an
+// allowlist is configured, not allowed.
+type ipFilter struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+func parseCIDRs(entries []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(entries))
+	for _, e := range entries {
+		if !strings.Contains(e, "/") {
+			ip := net.ParseIP(e)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %q", e)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				bits = 32
+			}
+			e = fmt.Sprintf("%s/%d", e, bits)
+		}
+		_, n, err := net.ParseCIDR(e)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", e, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func newIPFilter(allow, deny []string) (*ipFilter, error) {
+	a, err := parseCIDRs(allow)
+	if err != nil {
+		return nil, fmt.Errorf("invalid allow list: %w", err)
+	}
+	d, err := parseCIDRs(deny)
+	if err != nil {
+		return nil, fmt.Errorf("invalid deny list: %w", err)
+	}
+	return &ipFilter{allow: a, deny: d}, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *ipFilter) permits(ip net.IP) bool {
+	if containsIP(f.deny, ip) {
+		return false
+	}
+	return len(f.allow) == 0 || containsIP(f.allow, ip)
+}
+
+// relayedKey marks the context of connections the relay client bridges
+// in over the relay socket.
+type relayedKey struct{}
+
+// relayed reports whether r came through the relay rather than a local
+// or direct connection.
+func relayed(r *http.Request) bool {
+	v, _ := r.Context().Value(relayedKey{}).(bool)
+	return v
+}
+
+// remoteIP returns the connection's peer address or, for relayed
+// requests, the viewer's as the relay server saw it. Forwarding headers
+// are otherwise ignored since any client can set them.
+func remoteIP(r *http.Request) net.IP {
+	if relayed(r) {
+		// The relay server appends the address it saw last.
+		fwd := r.Header.Get("X-Forwarded-For")
+		return net.ParseIP(strings.TrimSpace(fwd[strings.LastIndex(fwd, ",")+1:]))
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+func (f *ipFilter) wrap(next http.Handler) http.Handler {
+	if len(f.allow) == 0 && len(f.deny) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ip := remoteIP(r); ip == nil || !f.permits(ip) {
+			log.Printf("Rejected request from %s to %s", r.RemoteAddr, r.URL.Path)
+			i18n.Error(w, r, http.StatusForbidden, "forbidden")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RateLimitConfig caps, per source IP, how fast stream connections
+// (WebSocket upgrades and /live) and control API requests may arrive.
+// Requests over the limit get 429. Viewers arriving through the relay are
+// limited by the address the relay server saw them at.
+type RateLimitConfig struct {
+	ConnectionsPerMinute float64 `json:"connections_per_minute,omitempty"`
+	ConnectionBurst      int     `json:"connection_burst,omitempty"`
+	APIPerMinute         float64 `json:"api_per_minute,omitempty"`
+	APIBurst             int     `json:"api_burst,omitempty"`
+}
+
+// bucket is a token bucket refilled continuously at rate tokens/second.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// limiter keeps one bucket per IP for a class of requests.
+type limiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*bucket
+}
+
+func newLimiter(perMinute float64, burst int) *limiter {
+	if perMinute <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = int(math.Max(1, perMinute/6))
+	}
+	return &limiter{rate: perMinute / 60, burst: float64(burst), buckets: make(map[string]*bucket)}
+}
+
+// allow takes a token for ip, or reports how long until one is available.
+func (l *limiter) allow(ip string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	b, ok := l.buckets[ip]
+	if !ok {
+		if len(l.buckets) > 10000 {
+			l.prune(now)
+		}
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[ip] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
+
+// prune drops buckets that have refilled completely, which are
+// indistinguishable from new ones.
+func (l *limiter) prune(now time.Time) {
+	for ip, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, ip)
+		}
+	}
+}
+
+type rateLimits struct {
+	conns *limiter
+	api   *limiter
+}
+
+func newRateLimits(cfg *RateLimitConfig) *rateLimits {
+	if cfg == nil {
+		return &rateLimits{}
+	}
+	return &rateLimits{
+		conns: newLimiter(cfg.ConnectionsPerMinute, cfg.ConnectionBurst),
+		api:   newLimiter(cfg.APIPerMinute, cfg.APIBurst),
+	}
+}
+
+func (rl *rateLimits) wrap(next http.Handler) http.Handler {
+	if rl.conns == nil && rl.api == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var l *limiter
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/api/"):
+			l = rl.api
+		case websocketRequest(r) || webTransportRequest(r) || r.URL.Path == "/live" || strings.HasSuffix(r.URL.Path, "/live"):
+			l = rl.conns
+		}
+		ip := remoteIP(r)
+		if l == nil || ip == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if ok, wait := l.allow(ip.String()); !ok {
+			log.Printf("Rate limited %s on %s", ip, r.URL.Path)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			i18n.Error(w, r, http.StatusTooManyRequests, "rate_limited")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
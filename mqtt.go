@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nathfavour/remoter/mqtt"
+)
+
+// MQTTConfig publishes the stream's state, retained, to an MQTT broker
+// and takes pause and resume commands from it, e.g. for Home Assistant.
+type MQTTConfig struct {
+	// URL is e.g. mqtt://host:1883 or mqtts://host:8883.
+	URL      string `json:"url"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	ClientID string `json:"client_id,omitempty"`
+	// Topic prefixes the state and command topics, remoter/<hostname> by
+	// default:
+	//
+	//	<topic>/availability  online or offline
+	//	<topic>/state         live, starting, idle, paused or down
+	//	<topic>/streaming     ON or OFF
+	//	<topic>/paused        ON or OFF
+	//	<topic>/viewers       the number of viewers
+	//	<topic>/command       send pause or resume
+	Topic string `json:"topic,omitempty"`
+	// Discovery announces the entities to Home Assistant, under
+	// DiscoveryPrefix ("homeassistant" by default).
+	Discovery       bool   `json:"discovery,omitempty"`
+	DiscoveryPrefix string `json:"discovery_prefix,omitempty"`
+}
+
+// mqttPollInterval is how often the state is checked for changes.
+const mqttPollInterval = 2 * time.Second
+
+// mqttPublisher keeps the broker's retained state in step with the
+// stream.
+type mqttPublisher struct {
+	cfg    MQTTConfig
+	client *mqtt.Client
+	// resend asks for every topic to be published again, after a
+	// reconnect.
+	resend chan struct{}
+	// poke asks for the state to be checked now rather than at the next
+	// tick.
+	poke chan struct{}
+	done chan struct{}
+}
+
+var mqttState *mqttPublisher
+
+func startMQTT(c *MQTTConfig) (*mqttPublisher, error) {
+	cfg := *c
+	if cfg.Topic == "" {
+		host, _ := os.Hostname()
+		cfg.Topic = "remoter/" + mqttID(host)
+	}
+	cfg.Topic = strings.TrimSuffix(cfg.Topic, "/")
+	if strings.ContainsAny(cfg.Topic, "+#") {
+		return nil, fmt.Errorf("mqtt topic %q may not hold wildcards", cfg.Topic)
+	}
+	if cfg.DiscoveryPrefix == "" {
+		cfg.DiscoveryPrefix = "homeassistant"
+	}
+	p := &mqttPublisher{cfg: cfg, resend: make(chan struct{}, 1), poke: make(chan struct{}, 1), done: make(chan struct{})}
+	client, err := mqtt.New(mqtt.Config{
+		URL:         cfg.URL,
+		Username:    cfg.Username,
+		Password:    cfg.Password,
+		ClientID:    cfg.ClientID,
+		WillTopic:   cfg.Topic + "/availability",
+		WillPayload: "offline",
+	}, p.command)
+	if err != nil {
+		return nil, err
+	}
+	p.client = client
+	client.Subscribe(cfg.Topic + "/command")
+	client.OnConnect(func() {
+		select {
+		case p.resend <- struct{}{}:
+		default:
+		}
+	})
+	go client.Run()
+	go p.run()
+	return p, nil
+}
+
+var mqttUnsafe = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// mqttID makes s fit in a topic level and a Home Assistant object id.
+func mqttID(s string) string {
+	s = mqttUnsafe.ReplaceAllString(s, "_")
+	if strings.Trim(s, "_") == "" {
+		return "remoter"
+	}
+	return s
+}
+
+// mqttValues are the retained state topics, relative to the prefix.
+func mqttValues() map[string]string {
+	s := currentPublicStatus()
+	onOff := func(b bool) string {
+		if b {
+			return "ON"
+		}
+		return "OFF"
+	}
+	return map[string]string{
+		"state":     s.State,
+		"streaming": onOff(s.Live),
+		"paused":    onOff(s.State == "paused"),
+		"viewers":   strconv.Itoa(s.Viewers),
+	}
+}
+
+func (p *mqttPublisher) run() {
+	t := time.NewTicker(mqttPollInterval)
+	defer t.Stop()
+	sent := map[string]string{}
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-p.resend:
+			sent = map[string]string{}
+			p.publish("availability", "online")
+			if p.cfg.Discovery {
+				p.announce()
+			}
+		case <-p.poke:
+		case <-t.C:
+		}
+		for k, v := range mqttValues() {
+			if sent[k] == v {
+				continue
+			}
+			if p.publish(k, v) {
+				sent[k] = v
+			}
+		}
+	}
+}
+
+func (p *mqttPublisher) publish(topic, value string) bool {
+	if err := p.client.Publish(p.cfg.Topic+"/"+topic, []byte(value), true); err != nil {
+		return false
+	}
+	return true
+}
+
+// announce publishes Home Assistant discovery configs: a switch to pause
+// the stream, and sensors for its state and viewers.
+func (p *mqttPublisher) announce() {
+	node := mqttID(strings.ReplaceAll(p.cfg.Topic, "/", "_"))
+	device := map[string]any{
+		"identifiers":  []string{node},
+		"name":         "remoter " + strings.TrimPrefix(p.cfg.Topic, "remoter/"),
+		"manufacturer": "remoter",
+	}
+	entities := []struct {
+		component, id string
+		config        map[string]any
+	}{
+		{"binary_sensor", "streaming", map[string]any{
+			"name": "Streaming", "state_topic": p.cfg.Topic + "/streaming",
+			"device_class": "running",
+		}},
+		{"sensor", "viewers", map[string]any{
+			"name": "Viewers", "state_topic": p.cfg.Topic + "/viewers",
+			"state_class": "measurement", "icon": "mdi:eye",
+		}},
+		{"sensor", "state", map[string]any{
+			"name": "State", "state_topic": p.cfg.Topic + "/state",
+		}},
+		{"switch", "paused", map[string]any{
+			"name": "Paused", "state_topic": p.cfg.Topic + "/paused",
+			"command_topic": p.cfg.Topic + "/command",
+			"payload_on":    "pause", "payload_off": "resume",
+			"state_on": "ON", "state_off": "OFF", "icon": "mdi:pause",
+		}},
+	}
+	for _, e := range entities {
+		e.config["unique_id"] = node + "_" + e.id
+		e.config["availability_topic"] = p.cfg.Topic + "/availability"
+		e.config["device"] = device
+		data, err := json.Marshal(e.config)
+		if err != nil {
+			continue
+		}
+		topic := fmt.Sprintf("%s/%s/%s/%s/config", p.cfg.DiscoveryPrefix, e.component, node, e.id)
+		p.client.Publish(topic, data, true)
+	}
+}
+
+// command runs what arrives on <topic>/command.
+func (p *mqttPublisher) command(topic string, payload []byte) {
+	switch cmd := strings.ToLower(strings.TrimSpace(string(payload))); cmd {
+	case "pause":
+		log.Printf("MQTT: pausing the stream")
+		pauseStream("stream_paused")
+	case "resume":
+		if screenLocked.Load() {
+			log.Printf("MQTT: not resuming, the screen is locked")
+			return
+		}
+		log.Printf("MQTT: resuming the stream")
+		resumeStream()
+	default:
+		log.Printf("MQTT: ignoring unknown command %q", cmd)
+		return
+	}
+	select {
+	case p.poke <- struct{}{}:
+	default:
+	}
+}
+
+// stop marks the host offline and disconnects.
+func (p *mqttPublisher) stop() {
+	close(p.done)
+	p.publish("availability", "offline")
+	p.client.Close()
+}
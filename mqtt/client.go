@@ -0,0 +1,380 @@
+package mqtt
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// keepAlive is the keepalive announced to the broker; a PINGREQ goes out
+// at half of it.
+const keepAlive = 60 * time.Second
+
+// Config is an MQTT 3.1.1 broker to stay connected to.
+type Config struct {
+	// URL is e.g. mqtt://host:1883 or mqtts://host:8883; credentials may
+	// be given in it or below.
+	URL      string
+	Username string
+	Password string
+	ClientID string
+	// WillTopic is published retained with WillPayload by the broker when
+	// the connection is lost without a goodbye.
+	WillTopic   string
+	WillPayload string
+}
+
+// Client keeps a connection to a broker, reconnecting with backoff,
+// resubscribing and calling OnConnect after every connect. Everything is
+// QoS 0.
+type Client struct {
+	cfg       Config
+	addr      *url.URL
+	subs      []string
+	handler   func(topic string, payload []byte)
+	onConnect func()
+
+	mu     sync.Mutex
+	conn   net.Conn
+	closed bool
+	done   chan struct{}
+}
+
+// New returns a client for cfg that passes messages on the topics it
+// subscribes to to handler. It does not connect until Run.
+func New(cfg Config, handler func(topic string, payload []byte)) (*Client, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("mqtt needs a url")
+	}
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mqtt url: %w", err)
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "mqtt", "tcp", "mqtts", "ssl", "tls":
+	default:
+		return nil, fmt.Errorf("mqtt url needs an mqtt:// or mqtts:// scheme")
+	}
+	if u.User != nil {
+		cfg.Username = u.User.Username()
+		cfg.Password, _ = u.User.Password()
+	}
+	if cfg.ClientID == "" {
+		cfg.ClientID = fmt.Sprintf("remoter-%d", time.Now().UnixNano()%1e9)
+	}
+	return &Client{cfg: cfg, addr: u, handler: handler, done: make(chan struct{})}, nil
+}
+
+// Subscribe adds topics, which may hold + and # wildcards, to subscribe
+// to on every connect. Call it before Run.
+func (c *Client) Subscribe(topics ...string) {
+	c.subs = append(c.subs, topics...)
+}
+
+// OnConnect sets f to run after every connect, e.g. to republish retained
+// state. Call it before Run.
+func (c *Client) OnConnect(f func()) {
+	c.onConnect = f
+}
+
+// Run connects and stays connected until Close.
+func (c *Client) Run() {
+	wait := time.Second
+	for {
+		start := time.Now()
+		err := c.session()
+		if c.isClosed() {
+			return
+		}
+		if time.Since(start) > time.Minute {
+			wait = time.Second
+		}
+		fmt.Printf("Warning: mqtt %s: %v; reconnecting in %s\n", c.addr.Host, err, wait)
+		select {
+		case <-c.done:
+			return
+		case <-time.After(wait):
+		}
+		wait = min(wait*2, time.Minute)
+	}
+}
+
+func (c *Client) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+// session connects and reads until the connection fails.
+func (c *Client) session() error {
+	conn, r, err := c.connect()
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		conn.Close()
+		return nil
+	}
+	c.conn = conn
+	c.mu.Unlock()
+	defer c.drop(conn)
+
+	if len(c.subs) > 0 {
+		body := binary.BigEndian.AppendUint16(nil, 1)
+		for _, t := range c.subs {
+			body = appendString(body, t)
+			body = append(body, 0)
+		}
+		if err := c.write(conn, packet(0x82, body)); err != nil {
+			return err
+		}
+	}
+	if c.onConnect != nil {
+		go c.onConnect()
+	}
+	go c.ping(conn)
+
+	for {
+		header, body, err := readPacket(r)
+		if err != nil {
+			return err
+		}
+		if header>>4 != 3 { // only PUBLISH matters; SUBACK, PINGRESP
+			continue
+		}
+		if len(body) < 2 {
+			return errors.New("malformed publish")
+		}
+		n := int(binary.BigEndian.Uint16(body))
+		if len(body) < 2+n {
+			return errors.New("malformed publish")
+		}
+		topic, payload := string(body[2:2+n]), body[2+n:]
+		if qos := header >> 1 & 3; qos > 0 {
+			if len(payload) < 2 {
+				return errors.New("malformed publish")
+			}
+			id := payload[:2]
+			payload = payload[2:]
+			if qos == 1 {
+				c.write(conn, packet(0x40, id))
+			}
+		}
+		if c.handler != nil {
+			c.handler(topic, payload)
+		}
+	}
+}
+
+func (c *Client) connect() (net.Conn, *bufio.Reader, error) {
+	host := c.addr.Host
+	if c.addr.Port() == "" {
+		port := "1883"
+		if c.tls() {
+			port = "8883"
+		}
+		host = net.JoinHostPort(c.addr.Hostname(), port)
+	}
+	d := &net.Dialer{Timeout: 10 * time.Second}
+	var conn net.Conn
+	var err error
+	if c.tls() {
+		conn, err = tls.DialWithDialer(d, "tcp", host, &tls.Config{ServerName: c.addr.Hostname()})
+	} else {
+		conn, err = d.Dial("tcp", host)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect: %w", err)
+	}
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	flags := byte(0x02) // clean session
+	if c.cfg.WillTopic != "" {
+		flags |= 0x04 | 0x20 // will, retained
+	}
+	if c.cfg.Username != "" {
+		flags |= 0x80
+		if c.cfg.Password != "" {
+			flags |= 0x40
+		}
+	}
+	body := appendString(nil, "MQTT")
+	body = append(body, 4, flags)
+	body = binary.BigEndian.AppendUint16(body, uint16(keepAlive/time.Second))
+	body = appendString(body, c.cfg.ClientID)
+	if flags&0x04 != 0 {
+		body = appendString(body, c.cfg.WillTopic)
+		body = appendString(body, c.cfg.WillPayload)
+	}
+	if flags&0x80 != 0 {
+		body = appendString(body, c.cfg.Username)
+	}
+	if flags&0x40 != 0 {
+		body = appendString(body, c.cfg.Password)
+	}
+	if _, err := conn.Write(packet(0x10, body)); err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("failed to connect: %w", err)
+	}
+
+	r := bufio.NewReader(conn)
+	header, ack, err := readPacket(r)
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("failed to connect: %w", err)
+	}
+	if header != 0x20 || len(ack) != 2 || ack[1] != 0 {
+		conn.Close()
+		code := 0
+		if len(ack) == 2 {
+			code = int(ack[1])
+		}
+		return nil, nil, fmt.Errorf("broker refused the connection (code %d)", code)
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, r, nil
+}
+
+func (c *Client) tls() bool {
+	switch strings.ToLower(c.addr.Scheme) {
+	case "mqtts", "ssl", "tls":
+		return true
+	}
+	return false
+}
+
+// ping keeps conn alive while it is current.
+func (c *Client) ping(conn net.Conn) {
+	t := time.NewTicker(keepAlive / 2)
+	defer t.Stop()
+	for range t.C {
+		c.mu.Lock()
+		current := c.conn == conn
+		c.mu.Unlock()
+		if !current || c.write(conn, []byte{0xC0, 0}) != nil {
+			return
+		}
+	}
+}
+
+func (c *Client) write(conn net.Conn, pkt []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_, err := conn.Write(pkt)
+	return err
+}
+
+// drop forgets conn if it is still current.
+func (c *Client) drop(conn net.Conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == conn {
+		c.conn = nil
+	}
+	conn.Close()
+}
+
+// Publish sends payload to topic; it fails while disconnected.
+func (c *Client) Publish(topic string, payload []byte, retain bool) error {
+	header := byte(0x30)
+	if retain {
+		header |= 0x01
+	}
+	pkt := packet(header, append(appendString(nil, topic), payload...))
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+	if conn == nil {
+		return fmt.Errorf("not connected to %s", c.addr.Host)
+	}
+	if err := c.write(conn, pkt); err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to publish to %s: %w", c.addr.Host, err)
+	}
+	return nil
+}
+
+// Close says goodbye to the broker, so the will is not published, and
+// stops Run.
+func (c *Client) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	close(c.done)
+	if c.conn != nil {
+		c.conn.SetWriteDeadline(time.Now().Add(2 * time.Second))
+		c.conn.Write([]byte{0xE0, 0})
+		c.conn.Close()
+		c.conn = nil
+	}
+}
+
+// packet frames an MQTT control packet.
+func packet(header byte, body []byte) []byte {
+	pkt := []byte{header}
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		pkt = append(pkt, b)
+		if n == 0 {
+			break
+		}
+	}
+	return append(pkt, body...)
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+// maxPacket bounds what is read from the broker.
+const maxPacket = 1 << 20
+
+func readPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	n, shift := 0, 0
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		n |= int(b&0x7F) << shift
+		if b&0x80 == 0 {
+			break
+		}
+		shift += 7
+		if shift > 21 {
+			return 0, nil, errors.New("malformed packet length")
+		}
+	}
+	if n > maxPacket {
+		return 0, nil, fmt.Errorf("packet of %d bytes is too large", n)
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return header, body, nil
+}
@@ -0,0 +1,159 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/nathfavour/remoter/events"
+)
+
+// Config is a notification channel that tells people about the events
+// they care about, as opposed to the machine-readable event brokers.
+type Config struct {
+	Type string `json:"type"` // "smtp", "telegram", "ntfy" or "gotify"
+	// Events are the event types to notify about; "client.*" matches every
+	// client event. By default, connections from unknown devices and
+	// encoder failures.
+	Events []string `json:"events,omitempty"`
+
+	// URL is the SMTP server's host:port, the ntfy topic's URL (e.g.
+	// https://ntfy.sh/mytopic) or the Gotify server's.
+	URL string `json:"url,omitempty"`
+	// Token is the Telegram bot's, Gotify application's or ntfy access
+	// token.
+	Token  string `json:"token,omitempty"`
+	ChatID string `json:"chat_id,omitempty"` // Telegram only
+
+	// SMTP only; the server must offer STARTTLS for the password to be
+	// sent.
+	Username string   `json:"username,omitempty"`
+	Password string   `json:"password,omitempty"`
+	From     string   `json:"from,omitempty"`
+	To       []string `json:"to,omitempty"`
+}
+
+// DefaultEvents are notified about when a channel lists none.
+var DefaultEvents = []string{"device.new", "encoder.exited"}
+
+// sender delivers one notification.
+type sender interface {
+	send(title, body string) error
+}
+
+// notifier is a Sink that formats the events it is interested in for
+// people and hands them to its channel.
+type notifier struct {
+	events []string
+	sender sender
+}
+
+// New returns the sink for cfg.
+func New(cfg Config) (events.Sink, error) {
+	var s sender
+	switch cfg.Type {
+	case "smtp":
+		if cfg.URL == "" || cfg.From == "" || len(cfg.To) == 0 {
+			return nil, fmt.Errorf("smtp notifier needs a url, from and to")
+		}
+		s = &smtpSender{cfg: cfg}
+	case "telegram":
+		if cfg.Token == "" || cfg.ChatID == "" {
+			return nil, fmt.Errorf("telegram notifier needs a token and chat_id")
+		}
+		s = &telegramSender{cfg: cfg}
+	case "ntfy":
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("ntfy notifier needs the topic url")
+		}
+		s = &ntfySender{cfg: cfg}
+	case "gotify":
+		if cfg.URL == "" || cfg.Token == "" {
+			return nil, fmt.Errorf("gotify notifier needs a url and token")
+		}
+		s = &gotifySender{cfg: cfg}
+	default:
+		return nil, fmt.Errorf("unknown notifier type %q", cfg.Type)
+	}
+	patterns := cfg.Events
+	if len(patterns) == 0 {
+		patterns = DefaultEvents
+	}
+	for _, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid event pattern %q", p)
+		}
+	}
+	return &notifier{events: patterns, sender: s}, nil
+}
+
+func (n *notifier) wants(typ string) bool {
+	for _, p := range n.events {
+		if ok, _ := path.Match(p, typ); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *notifier) Send(ev events.Event) error {
+	if !n.wants(ev.Type) {
+		return nil
+	}
+	title, body := format(ev)
+	return n.sender.send(title, body)
+}
+
+func (n *notifier) Close() error {
+	return nil
+}
+
+// format renders an event as a title and a plain text body listing its
+// details.
+func format(ev events.Event) (string, string) {
+	title := fmt.Sprintf("remoter on %s: %s", ev.Host, ev.Type)
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s at %s\n", ev.Type, ev.Time.Format(time.RFC1123))
+	if ev.Data != nil {
+		if data, err := json.MarshalIndent(ev.Data, "", "  "); err == nil {
+			b.WriteString("\n")
+			b.Write(data)
+			b.WriteString("\n")
+		}
+	}
+	return title, b.String()
+}
+
+var httpClient = &http.Client{Timeout: 15 * time.Second}
+
+// post sends a request and fails on any response but a 2xx.
+func post(req *http.Request) error {
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+func postJSON(url string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return post(req)
+}
@@ -0,0 +1,91 @@
+package notify
+
+import (
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+type smtpSender struct {
+	cfg Config
+}
+
+func (s *smtpSender) send(title, body string) error {
+	host, _, err := net.SplitHostPort(s.cfg.URL)
+	if err != nil {
+		return fmt.Errorf("invalid smtp server %q: %w", s.cfg.URL, err)
+	}
+	var auth smtp.Auth
+	if s.cfg.Username != "" {
+		auth = smtp.PlainAuth("", s.cfg.Username, s.cfg.Password, host)
+	}
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", s.cfg.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(s.cfg.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", title))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	if err := smtp.SendMail(s.cfg.URL, auth, s.cfg.From, s.cfg.To, []byte(msg.String())); err != nil {
+		return fmt.Errorf("failed to send mail: %w", err)
+	}
+	return nil
+}
+
+type telegramSender struct {
+	cfg Config
+}
+
+func (s *telegramSender) send(title, body string) error {
+	url := "https://api.telegram.org/bot" + s.cfg.Token + "/sendMessage"
+	err := postJSON(url, map[string]string{
+		"chat_id": s.cfg.ChatID,
+		"text":    title + "\n\n" + body,
+	})
+	if err != nil {
+		// Keep the bot token out of logs.
+		return fmt.Errorf("failed to send telegram message: %s", strings.ReplaceAll(err.Error(), s.cfg.Token, "***"))
+	}
+	return nil
+}
+
+type ntfySender struct {
+	cfg Config
+}
+
+func (s *ntfySender) send(title, body string) error {
+	req, err := http.NewRequest(http.MethodPost, s.cfg.URL, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Title", title)
+	req.Header.Set("Tags", "desktop_computer")
+	if s.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.cfg.Token)
+	}
+	if err := post(req); err != nil {
+		return fmt.Errorf("failed to publish to ntfy: %w", err)
+	}
+	return nil
+}
+
+type gotifySender struct {
+	cfg Config
+}
+
+func (s *gotifySender) send(title, body string) error {
+	url := strings.TrimSuffix(s.cfg.URL, "/") + "/message?token=" + s.cfg.Token
+	err := postJSON(url, map[string]any{
+		"title":    title,
+		"message":  body,
+		"priority": 8,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send gotify message: %s", strings.ReplaceAll(err.Error(), s.cfg.Token, "***"))
+	}
+	return nil
+}
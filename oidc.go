@@ -0,0 +1,506 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nathfavour/remoter/i18n"
+)
+
+// OIDCConfig sends viewers without a login to an OpenID Connect provider
+// (Google, Keycloak, Authentik, ...) and maps a claim of their ID token to
+// a remoter role.
+type OIDCConfig struct {
+	Issuer       string   `json:"issuer"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret"`
+	RedirectURL  string   `json:"redirect_url"` // e.g. https://remoter.example.com/auth/callback
+	Scopes       []string `json:"scopes,omitempty"`
+
+	// RoleClaim names the claim matched against Roles, "groups" by default;
+	// it may hold a string or a list. The strongest matching role wins and
+	// DefaultRole applies when none match; leave it empty to refuse them.
+	RoleClaim   string            `json:"role_claim,omitempty"`
+	Roles       map[string]string `json:"roles,omitempty"`
+	DefaultRole string            `json:"default_role,omitempty"`
+
+	SessionTTL string `json:"session_ttl,omitempty"` // login lifetime, default 12h
+}
+
+const (
+	loginCookie = "remoter_login"
+	oidcCookie  = "remoter_oidc"
+)
+
+// roleRank orders roles so the strongest grant can be picked.
+var roleRank = map[string]int{"view": 1, "control": 2, "admin": 3}
+
+// loginGrant is the content of the login cookie.
+type loginGrant struct {
+	User    string `json:"u"`
+	Role    string `json:"r"`
+	Expires int64  `json:"e"`
+}
+
+// oidcState round-trips through the provider in a short-lived cookie.
+type oidcState struct {
+	State    string `json:"s"`
+	Verifier string `json:"v"`
+	Nonce    string `json:"n"`
+	Next     string `json:"x"`
+	Expires  int64  `json:"e"`
+}
+
+type oidcProvider struct {
+	cfg        *OIDCConfig
+	issuer     string
+	authURL    string
+	tokenURL   string
+	jwksURL    string
+	sessionTTL time.Duration
+	secure     bool
+
+	mu   sync.Mutex
+	keys map[string]crypto.PublicKey
+}
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+func newOIDCProvider(cfg *OIDCConfig) (*oidcProvider, error) {
+	if cfg.Issuer == "" || cfg.ClientID == "" || cfg.RedirectURL == "" {
+		return nil, fmt.Errorf("oidc needs issuer, client_id and redirect_url")
+	}
+	for claim, role := range cfg.Roles {
+		if roleRank[role] == 0 {
+			return nil, fmt.Errorf("oidc: unknown role %q for %q", role, claim)
+		}
+	}
+	if cfg.DefaultRole != "" && roleRank[cfg.DefaultRole] == 0 {
+		return nil, fmt.Errorf("oidc: unknown default role %q", cfg.DefaultRole)
+	}
+	ttl := 12 * time.Hour
+	if cfg.SessionTTL != "" {
+		d, err := time.ParseDuration(cfg.SessionTTL)
+		if err != nil {
+			return nil, fmt.Errorf("oidc: invalid session_ttl: %w", err)
+		}
+		ttl = d
+	}
+
+	wellKnown := strings.TrimSuffix(cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	resp, err := httpClient.Get(wellKnown)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch OIDC discovery document: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch OIDC discovery document: %s", resp.Status)
+	}
+	var doc struct {
+		Issuer   string `json:"issuer"`
+		AuthURL  string `json:"authorization_endpoint"`
+		TokenURL string `json:"token_endpoint"`
+		JWKSURL  string `json:"jwks_uri"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to parse OIDC discovery document: %w", err)
+	}
+
+	return &oidcProvider{
+		cfg:        cfg,
+		issuer:     doc.Issuer,
+		authURL:    doc.AuthURL,
+		tokenURL:   doc.TokenURL,
+		jwksURL:    doc.JWKSURL,
+		sessionTTL: ttl,
+		secure:     strings.HasPrefix(cfg.RedirectURL, "https://"),
+		keys:       make(map[string]crypto.PublicKey),
+	}, nil
+}
+
+func randomString() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (p *oidcProvider) register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /auth/login", p.handleLogin)
+	mux.HandleFunc("GET /auth/callback", p.handleCallback)
+	mux.HandleFunc("/auth/logout", p.handleLogout)
+}
+
+// authenticate returns the login carried by the request's cookie.
+func (p *oidcProvider) authenticate(r *http.Request) (authInfo, bool) {
+	c, err := r.Cookie(loginCookie)
+	if err != nil {
+		return authInfo{}, false
+	}
+	var g loginGrant
+	if err := verifyToken(tokenLogin, c.Value, &g); err != nil || time.Now().Unix() > g.Expires {
+		return authInfo{}, false
+	}
+	return authInfo{User: g.User, Role: g.Role}, true
+}
+
+// redirectToLogin sends a browser to the provider, or answers 401 for API
+// and stream requests that cannot follow a redirect.
+func (p *oidcProvider) redirectToLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" || strings.HasPrefix(r.URL.Path, "/api/") || websocketRequest(r) {
+		i18n.Error(w, r, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	http.Redirect(w, r, "/auth/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+}
+
+// localPath reports whether next is a path on this server, safe to
+// redirect to after login. Browsers read backslashes as slashes, so
+// "/\evil.com" would leave it.
+func localPath(next string) bool {
+	if !strings.HasPrefix(next, "/") || strings.ContainsAny(next, "\\\r\n") {
+		return false
+	}
+	u, err := url.Parse(next)
+	return err == nil && u.Scheme == "" && u.Host == "" && !strings.HasPrefix(next, "//")
+}
+
+func emailVerified(claims map[string]any) bool {
+	switch v := claims["email_verified"].(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	}
+	return false
+}
+
+func websocketRequest(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+}
+
+func (p *oidcProvider) setCookie(w http.ResponseWriter, name, value string, expires time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   p.secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (p *oidcProvider) handleLogin(w http.ResponseWriter, r *http.Request) {
+	next := r.URL.Query().Get("next")
+	if !localPath(next) {
+		next = "/"
+	}
+	st := oidcState{
+		State:    randomString(),
+		Verifier: randomString(),
+		Nonce:    randomString(),
+		Next:     next,
+		Expires:  time.Now().Add(10 * time.Minute).Unix(),
+	}
+	p.setCookie(w, oidcCookie, signToken(tokenOIDCState, st), time.Unix(st.Expires, 0))
+
+	challenge := sha256.Sum256([]byte(st.Verifier))
+	scopes := p.cfg.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email", "profile"}
+	}
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.cfg.ClientID},
+		"redirect_uri":          {p.cfg.RedirectURL},
+		"scope":                 {strings.Join(scopes, " ")},
+		"state":                 {st.State},
+		"nonce":                 {st.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(p.authURL, "?") {
+		sep = "&"
+	}
+	http.Redirect(w, r, p.authURL+sep+q.Encode(), http.StatusFound)
+}
+
+func (p *oidcProvider) handleCallback(w http.ResponseWriter, r *http.Request) {
+	c, err := r.Cookie(oidcCookie)
+	var st oidcState
+	if err != nil || verifyToken(tokenOIDCState, c.Value, &st) != nil || time.Now().Unix() > st.Expires {
+		i18n.Error(w, r, http.StatusBadRequest, "login_expired")
+		return
+	}
+	p.setCookie(w, oidcCookie, "", time.Unix(0, 0))
+
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		i18n.Error(w, r, http.StatusUnauthorized, "login_failed_reason", e)
+		return
+	}
+	if q.Get("state") != st.State {
+		i18n.Error(w, r, http.StatusBadRequest, "login_state_mismatch")
+		return
+	}
+
+	claims, err := p.exchange(q.Get("code"), st)
+	if err != nil {
+		log.Printf("OIDC login failed: %v", err)
+		emit(eventAuthFailed, map[string]any{"method": "oidc", "remote_addr": r.RemoteAddr, "error": err.Error()})
+		i18n.Error(w, r, http.StatusUnauthorized, "login_failed")
+		return
+	}
+
+	// Isolation trusts the identity with sessions, so an email counts
+	// only once the provider verified it.
+	user, _ := claims["sub"].(string)
+	if email, _ := claims["email"].(string); email != "" && emailVerified(claims) {
+		user = email
+	}
+	role := p.role(claims)
+	if role == "" {
+		log.Printf("OIDC login refused for %s: no role matches", user)
+		emit(eventAuthFailed, map[string]any{"user": user, "method": "oidc", "remote_addr": r.RemoteAddr, "error": "no role matches"})
+		i18n.Error(w, r, http.StatusForbidden, "no_access")
+		return
+	}
+
+	g := loginGrant{User: user, Role: role, Expires: time.Now().Add(p.sessionTTL).Unix()}
+	p.setCookie(w, loginCookie, signToken(tokenLogin, g), time.Unix(g.Expires, 0))
+	log.Printf("OIDC login: %s as %s", user, role)
+	http.Redirect(w, r, st.Next, http.StatusFound)
+}
+
+func (p *oidcProvider) handleLogout(w http.ResponseWriter, r *http.Request) {
+	p.setCookie(w, loginCookie, "", time.Unix(0, 0))
+	w.Write([]byte(i18n.T(i18n.Locale(r), "logged_out") + "\n"))
+}
+
+// role maps the configured claim to the strongest matching role.
+func (p *oidcProvider) role(claims map[string]any) string {
+	name := p.cfg.RoleClaim
+	if name == "" {
+		name = "groups"
+	}
+	var values []string
+	switch v := claims[name].(type) {
+	case string:
+		values = []string{v}
+	case []any:
+		for _, e := range v {
+			if s, ok := e.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+
+	best := p.cfg.DefaultRole
+	for _, v := range values {
+		if r := p.cfg.Roles[v]; roleRank[r] > roleRank[best] {
+			best = r
+		}
+	}
+	return best
+}
+
+// exchange redeems the authorization code and returns the verified
+// claims of the ID token.
+func (p *oidcProvider) exchange(code string, st oidcState) (map[string]any, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.cfg.RedirectURL},
+		"client_id":     {p.cfg.ClientID},
+		"code_verifier": {st.Verifier},
+	}
+	if p.cfg.ClientSecret != "" {
+		form.Set("client_secret", p.cfg.ClientSecret)
+	}
+	resp, err := httpClient.PostForm(p.tokenURL, form)
+	if err != nil {
+		return nil, fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token request failed: %s", resp.Status)
+	}
+	var tok struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		return nil, fmt.Errorf("failed to parse token response: %w", err)
+	}
+	if tok.IDToken == "" {
+		return nil, fmt.Errorf("token response has no id_token")
+	}
+
+	claims, err := p.verifyIDToken(tok.IDToken)
+	if err != nil {
+		return nil, err
+	}
+	if claims["nonce"] != st.Nonce {
+		return nil, fmt.Errorf("id token nonce mismatch")
+	}
+	return claims, nil
+}
+
+// verifyIDToken checks the JWT signature against the provider's keys and
+// validates issuer, audience and expiry.
+func (p *oidcProvider) verifyIDToken(token string) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed id token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed id token header: %w", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed id token signature: %w", err)
+	}
+
+	key, err := p.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if header.Alg != "RS256" {
+			return nil, fmt.Errorf("unsupported id token algorithm %q", header.Alg)
+		}
+		if err := rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig); err != nil {
+			return nil, fmt.Errorf("invalid id token signature")
+		}
+	case *ecdsa.PublicKey:
+		if header.Alg != "ES256" || len(sig) != 64 {
+			return nil, fmt.Errorf("unsupported id token algorithm %q", header.Alg)
+		}
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(k, digest[:], r, s) {
+			return nil, fmt.Errorf("invalid id token signature")
+		}
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed id token claims: %w", err)
+	}
+	if claims["iss"] != p.issuer {
+		return nil, fmt.Errorf("id token issuer %v does not match %s", claims["iss"], p.issuer)
+	}
+	if !audienceContains(claims["aud"], p.cfg.ClientID) {
+		return nil, fmt.Errorf("id token is not for client %s", p.cfg.ClientID)
+	}
+	exp, _ := claims["exp"].(float64)
+	if time.Now().After(time.Unix(int64(exp), 0).Add(time.Minute)) {
+		return nil, fmt.Errorf("id token expired")
+	}
+	return claims, nil
+}
+
+func decodeSegment(seg string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func audienceContains(aud any, clientID string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == clientID
+	case []any:
+		for _, a := range v {
+			if a == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// key returns the provider's signing key with the given id, refetching
+// the key set once when it is unknown so rotations are picked up.
+func (p *oidcProvider) key(kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if k, ok := p.keys[kid]; ok {
+		return k, nil
+	}
+	keys, err := fetchJWKS(p.jwksURL)
+	if err != nil {
+		return nil, err
+	}
+	p.keys = keys
+	if k, ok := keys[kid]; ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("no signing key with id %q", kid)
+}
+
+func fetchJWKS(jwksURL string) (map[string]crypto.PublicKey, error) {
+	resp, err := httpClient.Get(jwksURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch signing keys: %w", err)
+	}
+	defer resp.Body.Close()
+	var set struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			Crv string `json:"crv"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("failed to parse signing keys: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		switch {
+		case k.Kty == "RSA":
+			n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+			e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case k.Kty == "EC" && k.Crv == "P-256":
+			x, err1 := base64.RawURLEncoding.DecodeString(k.X)
+			y, err2 := base64.RawURLEncoding.DecodeString(k.Y)
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		}
+	}
+	return keys, nil
+}
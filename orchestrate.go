@@ -0,0 +1,456 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nathfavour/remoter/ffmpeg"
+)
+
+// ServiceConfig declares a service the server runs and keeps running,
+// restarting it when it fails:
+//
+//	stream     the encoder of the main display, which viewers watch
+//	vnc        the VNC desktop
+//	recorder   records Source to the recording storage
+//	rtmp-push  publishes Source to an RTMP server, e.g. a YouTube ingest
+type ServiceConfig struct {
+	// Name identifies the service in the API; default its type, numbered
+	// when the type repeats. stream and vnc default to "ffmpeg" and "vnc",
+	// the names the API has always used.
+	Name string `json:"name,omitempty"`
+	Type string `json:"type"`
+	// Source is the stream a recorder or rtmp-push takes: a session ID, or
+	// "" for the main display.
+	Source  string `json:"source,omitempty"`
+	Quality string `json:"quality,omitempty"` // recorder and rtmp-push
+	URL     string `json:"url,omitempty"`     // rtmp-push, rtmp:// or rtmps://
+	Bitrate string `json:"bitrate,omitempty"` // rtmp-push, default the config's
+	// Segment starts a new recording file every so long (e.g. "1h"); by
+	// default a recorder writes one file for as long as it runs.
+	Segment string `json:"segment,omitempty"`
+	// Disabled services are only started through the API.
+	Disabled bool `json:"disabled,omitempty"`
+}
+
+const (
+	serviceStream   = "stream"
+	serviceVNC      = "vnc"
+	serviceRecorder = "recorder"
+	serviceRTMPPush = "rtmp-push"
+)
+
+// transportPush clients feed an rtmp-push service.
+const transportPush = "push"
+
+// migrateServices turns the ffmpeg and vnc toggles of older configs into
+// the services list, reporting whether it changed cfg.
+func migrateServices(cfg *Config) bool {
+	if cfg.Services != nil || (!cfg.FFmpeg && !cfg.VNC) {
+		return false
+	}
+	cfg.Services = []ServiceConfig{}
+	if cfg.FFmpeg {
+		cfg.Services = append(cfg.Services, ServiceConfig{Type: serviceStream})
+	}
+	if cfg.VNC {
+		cfg.Services = append(cfg.Services, ServiceConfig{Type: serviceVNC})
+	}
+	cfg.FFmpeg, cfg.VNC = false, false
+	return true
+}
+
+// validateServices checks the declared services and fills in their
+// names.
+func validateServices(list []ServiceConfig) error {
+	names := make(map[string]bool)
+	types := make(map[string]int)
+	for i := range list {
+		s := &list[i]
+		types[s.Type]++
+		switch s.Type {
+		case serviceStream, serviceVNC:
+			if types[s.Type] > 1 {
+				return fmt.Errorf("only one %s service can be declared", s.Type)
+			}
+			if s.Source != "" {
+				return fmt.Errorf("%s service serves the main display and takes no source", s.Type)
+			}
+		case serviceRecorder:
+			if s.Segment != "" {
+				if d, err := time.ParseDuration(s.Segment); err != nil || d < time.Minute {
+					return fmt.Errorf("invalid segment %q of %s: at least 1m is needed", s.Segment, s.Type)
+				}
+			}
+		case serviceRTMPPush:
+			u, err := url.Parse(s.URL)
+			if err != nil || (u.Scheme != "rtmp" && u.Scheme != "rtmps") || u.Host == "" {
+				return fmt.Errorf("rtmp-push service needs an rtmp:// or rtmps:// url")
+			}
+		default:
+			return fmt.Errorf("unknown service type %q", s.Type)
+		}
+		if s.Name == "" {
+			s.Name = s.Type
+			switch s.Type {
+			case serviceStream:
+				s.Name = "ffmpeg"
+			case serviceVNC:
+				s.Name = "vnc"
+			}
+			if types[s.Type] > 1 {
+				s.Name += "-" + strconv.Itoa(types[s.Type])
+			}
+		}
+		if names[s.Name] || s.Name == "audio" {
+			return fmt.Errorf("duplicate service name %q", s.Name)
+		}
+		names[s.Name] = true
+	}
+	return nil
+}
+
+// supervised is a declared service under supervision.
+type supervised struct {
+	cfg ServiceConfig
+
+	mu        sync.Mutex
+	state     string // running, restarting or stopped
+	startedAt time.Time
+	restarts  int
+	lastError string
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// serviceStatus is the JSON shape of a declared service in the API.
+type serviceStatus struct {
+	ServiceConfig
+	State     string     `json:"state"`
+	StartedAt *time.Time `json:"started_at,omitempty"`
+	Restarts  int        `json:"restarts"`
+	LastError string     `json:"last_error,omitempty"`
+}
+
+var (
+	// declared are the services of the config, in its order; set in main.
+	declared []*supervised
+)
+
+// declareServices sets up the supervision of list, already validated.
+func declareServices(list []ServiceConfig) {
+	for _, cfg := range list {
+		declared = append(declared, &supervised{cfg: cfg, state: "stopped"})
+	}
+}
+
+// startDeclared starts the services not disabled, reporting how many.
+func startDeclared() int {
+	n := 0
+	for _, s := range declared {
+		if s.cfg.Disabled {
+			continue
+		}
+		log.Printf("Starting %s service %s...", s.cfg.Type, s.cfg.Name)
+		s.start()
+		n++
+	}
+	return n
+}
+
+// stopDeclared stops every declared service, used on shutdown.
+func stopDeclared() {
+	for _, s := range declared {
+		s.halt()
+	}
+}
+
+func declaredService(name string) *supervised {
+	for _, s := range declared {
+		if s.cfg.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+func (s *supervised) status() serviceStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := serviceStatus{ServiceConfig: s.cfg, State: s.state, Restarts: s.restarts, LastError: s.lastError}
+	if s.state == "running" {
+		t := s.startedAt
+		st.StartedAt = &t
+	}
+	if st.URL != "" {
+		// Stream keys are part of ingest URLs.
+		if u, err := url.Parse(st.URL); err == nil {
+			st.URL = u.Scheme + "://" + u.Host + "/…"
+		}
+	}
+	return st
+}
+
+// start runs the service until stopped, restarting it whenever it fails.
+func (s *supervised) start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stop != nil {
+		return fmt.Errorf("%s is already running", s.cfg.Name)
+	}
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+	s.restarts, s.lastError = 0, ""
+	go s.supervise(s.stop, s.done)
+	return nil
+}
+
+// halt stops the service and waits for it to wind down.
+func (s *supervised) halt() error {
+	s.mu.Lock()
+	stop, done := s.stop, s.done
+	s.stop = nil
+	s.mu.Unlock()
+	if stop == nil {
+		return fmt.Errorf("%s is not running", s.cfg.Name)
+	}
+	close(stop)
+	<-done
+	return nil
+}
+
+func (s *supervised) restart() error {
+	if err := s.halt(); err != nil && !s.stopped() {
+		return err
+	}
+	return s.start()
+}
+
+func (s *supervised) stopped() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stop == nil
+}
+
+func (s *supervised) setState(state string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state = state
+	if state == "running" {
+		s.startedAt = time.Now()
+	}
+	if err != nil {
+		s.lastError = err.Error()
+	}
+}
+
+func (s *supervised) supervise(stop, done chan struct{}) {
+	defer close(done)
+	backoff := time.Second
+	for {
+		s.setState("running", nil)
+		started := time.Now()
+		err := s.run(stop)
+		select {
+		case <-stop:
+			s.setState("stopped", nil)
+			return
+		default:
+		}
+		if err == nil {
+			err = errors.New("exited")
+		}
+		if time.Since(started) > time.Minute {
+			backoff = time.Second
+		}
+		log.Printf("Service %s failed: %v; restarting in %s", s.cfg.Name, err, backoff)
+		emit(eventServiceFailed, map[string]any{"name": s.cfg.Name, "type": s.cfg.Type, "error": err.Error()})
+		s.setState("restarting", err)
+		select {
+		case <-time.After(backoff):
+		case <-stop:
+			s.setState("stopped", nil)
+			return
+		}
+		backoff = min(backoff*2, time.Minute)
+		s.mu.Lock()
+		s.restarts++
+		s.mu.Unlock()
+	}
+}
+
+// run runs the service once, until it fails or stop is closed.
+func (s *supervised) run(stop <-chan struct{}) error {
+	switch s.cfg.Type {
+	case serviceStream:
+		return runStream(stop)
+	case serviceVNC:
+		return runVNC(stop)
+	case serviceRecorder:
+		return runRecorder(s.cfg, stop)
+	case serviceRTMPPush:
+		return runPush(s.cfg, stop)
+	}
+	return fmt.Errorf("unknown service type %q", s.cfg.Type)
+}
+
+// runStream keeps the main encoder up. Its deliberate stops and restarts,
+// such as while idle or for new settings, don't count as failures.
+func runStream(stop <-chan struct{}) error {
+	// Drop a crash reported before this run.
+	select {
+	case <-services.encoderExited:
+	default:
+	}
+	if !services.encoder.Status().Running {
+		if err := services.start("ffmpeg"); err != nil {
+			return err
+		}
+	}
+	select {
+	case <-stop:
+		if services.encoder.Status().Running {
+			return services.stop("ffmpeg")
+		}
+		return nil
+	case err := <-services.encoderExited:
+		if err == nil {
+			err = errors.New("ffmpeg exited")
+		}
+		return err
+	}
+}
+
+func runVNC(stop <-chan struct{}) error {
+	if !services.vnc.Status().Running {
+		if err := services.start("vnc"); err != nil {
+			return err
+		}
+	}
+	t := time.NewTicker(5 * time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return services.stop("vnc")
+		case <-t.C:
+			// reapLoop tears the server down when x11vnc or Xvfb dies.
+			if !services.vnc.Status().Running {
+				return errors.New("VNC server exited")
+			}
+		}
+	}
+}
+
+// runRecorder records its source, a file per segment.
+func runRecorder(cfg ServiceConfig, stop <-chan struct{}) error {
+	segment, _ := time.ParseDuration(cfg.Segment)
+	for {
+		// Service recordings belong to no user, so no quota applies.
+		r, _ := http.NewRequest(http.MethodPost, "/", nil)
+		rec, err := startRecording(r, cfg.Source, cfg.Quality, cfg.Name)
+		if err != nil {
+			return err
+		}
+		var next <-chan time.Time
+		if segment > 0 {
+			next = time.After(segment)
+		}
+		select {
+		case <-stop:
+			rec.stop()
+			return nil
+		case <-rec.finished:
+			return fmt.Errorf("recording %s ended", rec.Name)
+		case <-next:
+			rec.stop()
+		}
+	}
+}
+
+// runPush feeds its source through an RTMP publishing ffmpeg.
+func runPush(cfg ServiceConfig, stop <-chan struct{}) error {
+	if !streamExists(cfg.Source) {
+		return fmt.Errorf("no such stream %q", cfg.Source)
+	}
+	quality := cfg.Quality
+	if quality == "" {
+		quality = ffmpeg.DefaultTier
+	}
+	if !qualityExists(quality) {
+		return fmt.Errorf("unknown quality %q", quality)
+	}
+	services.mu.Lock()
+	bitrate := services.cfg.Bitrate
+	services.mu.Unlock()
+	if cfg.Bitrate != "" {
+		bitrate = cfg.Bitrate
+	}
+	cmd := ffmpeg.PushCommand(cfg.URL, bitrate)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stderr := &tailWriter{}
+	cmd.Stderr = stderr
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start ffmpeg: %w", err)
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	r, _ := http.NewRequest(http.MethodPost, "/", nil)
+	c := newClient(transportPush, r)
+	c.stream = cfg.Source
+	c.quality.Store(quality)
+	c.w = stdin
+	addClient(c)
+	log.Printf("Service %s publishing %s", cfg.Name, cmp.Or(streamKey(cfg.Source, quality), "the main display"))
+
+	var result error
+	select {
+	case <-stop:
+	case <-c.done:
+		result = errors.New("fell behind the stream")
+	case err := <-exited:
+		result = fmt.Errorf("ffmpeg exited: %v: %s", err, stderr.String())
+		exited <- err
+	}
+	// Killing ffmpeg first unblocks a write stuck on its stdin.
+	cmd.Process.Kill()
+	removeClient(c)
+	c.close()
+	stdin.Close()
+	<-exited
+	return result
+}
+
+// tailWriter keeps the last line or so a process wrote, for errors.
+type tailWriter struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (t *tailWriter) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > 512 {
+		t.buf = t.buf[len(t.buf)-512:]
+	}
+	return len(p), nil
+}
+
+func (t *tailWriter) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return strings.TrimSpace(string(t.buf))
+}
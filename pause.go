@@ -0,0 +1,303 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/nathfavour/remoter/ffmpeg"
+	"github.com/nathfavour/remoter/i18n"
+)
+
+var (
+	// pausedAt is when the main stream was paused, zero while it streams.
+	// The encoder keeps running so resuming is instant.
+	pausedAt time.Time
+	pauseMux sync.Mutex
+)
+
+// PlaceholderConfig customizes the picture viewers of the main stream
+// are shown while it is paused, the screen is locked or no encoder feeds
+// it.
+type PlaceholderConfig struct {
+	ffmpeg.Slate
+	// Messages replace the text shown for a reason: "stream_paused",
+	// "screen_locked" or "no_source". An empty message shows none.
+	Messages map[string]string `json:"messages,omitempty"`
+	// HTML is a page served at /placeholder, for viewers to overlay while
+	// the placeholder shows; those connected with ?notify=1 are told when.
+	HTML string `json:"html,omitempty"`
+}
+
+var placeholder *PlaceholderConfig
+
+func (p *PlaceholderConfig) validate() error {
+	if p == nil {
+		return nil
+	}
+	if err := p.Slate.Validate(); err != nil {
+		return err
+	}
+	for reason := range p.Messages {
+		switch reason {
+		case "stream_paused", "screen_locked", "no_source":
+		default:
+			return fmt.Errorf("unknown placeholder reason %q", reason)
+		}
+	}
+	if p.HTML != "" {
+		if _, err := os.Stat(p.HTML); err != nil {
+			return fmt.Errorf("failed to read placeholder page: %w", err)
+		}
+	}
+	return nil
+}
+
+var (
+	// feeds counts the encoders feeding each tier of the main stream.
+	feeds   = make(map[string]int)
+	feedMux sync.Mutex
+
+	// shownReason is why viewers of the main stream are shown the
+	// placeholder, empty while they aren't.
+	shownReason string
+
+	// lastPlaceholder keeps the last picture encoded, so an encoder that
+	// keeps failing doesn't have it encoded over and over.
+	lastPlaceholder struct {
+		key   string
+		frame []byte
+	}
+	placeholderMux sync.Mutex
+)
+
+// placeholderFrame encodes the placeholder shown for reason.
+func placeholderFrame(reason string) ([]byte, error) {
+	text := i18n.T("", reason)
+	var slate *ffmpeg.Slate
+	if p := placeholder; p != nil {
+		if msg, ok := p.Messages[reason]; ok {
+			text = msg
+		}
+		slate = &p.Slate
+	}
+	res := placeholderRes()
+
+	placeholderMux.Lock()
+	defer placeholderMux.Unlock()
+	if key := res + "\n" + text; lastPlaceholder.key != key {
+		frame, err := ffmpeg.Placeholder(res, text, slate)
+		if err != nil {
+			return nil, err
+		}
+		lastPlaceholder.key, lastPlaceholder.frame = key, frame
+	}
+	return lastPlaceholder.frame, nil
+}
+
+// placeholderNotice tells viewers why they are shown the placeholder, or
+// that they no longer are.
+func placeholderNotice(reason string) map[string]any {
+	n := map[string]any{"type": "placeholder", "shown": reason != ""}
+	if reason != "" {
+		n["reason"] = reason
+		if placeholder != nil && placeholder.HTML != "" {
+			n["html"] = "/placeholder"
+		}
+	}
+	return n
+}
+
+func showPlaceholder(reason string) {
+	feedMux.Lock()
+	changed := shownReason != reason
+	shownReason = reason
+	feedMux.Unlock()
+	if changed {
+		notifyViewers("", placeholderNotice(reason))
+	}
+}
+
+// greetPlaceholder tells a new viewer of the main stream about the
+// placeholder it is shown.
+func greetPlaceholder(c *client) {
+	feedMux.Lock()
+	reason := shownReason
+	feedMux.Unlock()
+	if reason != "" {
+		c.notify(placeholderNotice(reason))
+	}
+}
+
+// feedConnected and feedDisconnected track an encoder feeding key, a tier
+// of the main stream. When the last one goes, viewers are shown the
+// "no_source" placeholder rather than a frozen frame, until another
+// connects.
+func feedConnected(key string) {
+	feedMux.Lock()
+	feeds[key]++
+	feedMux.Unlock()
+	if key == streamKey("", ffmpeg.DefaultTier) && !streamPaused() {
+		showPlaceholder("")
+	}
+}
+
+func feedDisconnected(key string) {
+	feedMux.Lock()
+	feeds[key]--
+	feedMux.Unlock()
+	go showSourceLost(key)
+}
+
+// showSourceLost shows the "no_source" placeholder on key if nothing
+// feeds it and the stream isn't paused.
+func showSourceLost(key string) {
+	if streamPaused() {
+		return
+	}
+	feedMux.Lock()
+	fed := feeds[key] > 0
+	feedMux.Unlock()
+	if fed {
+		return
+	}
+	frame, err := placeholderFrame("no_source")
+	if err != nil {
+		log.Printf("Warning: %v; viewers keep the last frame", err)
+		return
+	}
+	feedMux.Lock()
+	if feeds[key] > 0 || streamPaused() {
+		feedMux.Unlock()
+		return
+	}
+	resetStream(key)
+	publish(key, frame)
+	feedMux.Unlock()
+	if key == streamKey("", ffmpeg.DefaultTier) {
+		showPlaceholder("no_source")
+	}
+}
+
+func handlePlaceholderPage(w http.ResponseWriter, r *http.Request) {
+	if placeholder == nil || placeholder.HTML == "" {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeFile(w, r, placeholder.HTML)
+}
+
+func streamPaused() bool {
+	pauseMux.Lock()
+	defer pauseMux.Unlock()
+	return !pausedAt.IsZero()
+}
+
+// mainStreamKeys are the hub keys of every tier of the main display.
+func mainStreamKeys() []string {
+	keys := []string{streamKey("", ffmpeg.DefaultTier)}
+	for _, t := range services.encoder.Settings().Tiers {
+		keys = append(keys, streamKey("", t.Name))
+	}
+	return keys
+}
+
+// placeholderRes is the size of the picture shown while paused: that of
+// the stream, so viewers' players need not resize.
+func placeholderRes() string {
+	settings := services.encoder.Settings()
+	if settings.Scale != "" {
+		return settings.Scale
+	}
+	if c := settings.Capture; c != nil {
+		return fmt.Sprintf("%dx%d", c.W&^1, c.H&^1)
+	}
+	var w, h int
+	if _, err := fmt.Sscanf(services.encoder.Status().Res, "%dx%d", &w, &h); err == nil && w > 0 && h > 0 {
+		return fmt.Sprintf("%dx%d", w, h)
+	}
+	return "1280x720"
+}
+
+// pauseStream freezes the main stream on a picture showing the message
+// reason; viewers stay connected and new ones are shown the picture too.
+func pauseStream(reason string) {
+	pauseMux.Lock()
+	if !pausedAt.IsZero() {
+		pauseMux.Unlock()
+		return
+	}
+	pausedAt = time.Now()
+	pauseMux.Unlock()
+
+	// Encoding the picture takes long enough for data already read from
+	// the encoder to drain before the GOP cache is replaced.
+	frame, err := placeholderFrame(reason)
+	if err != nil {
+		log.Printf("Warning: %v; viewers keep the last frame", err)
+	}
+	if !streamPaused() {
+		return
+	}
+	for _, key := range mainStreamKeys() {
+		resetStream(key)
+		if frame != nil {
+			publish(key, frame)
+		}
+	}
+	showPlaceholder(reason)
+	log.Printf("Stream paused (%s)", reason)
+	emit(eventStreamPaused, map[string]string{"reason": reason})
+}
+
+// resumeStream lets the encoder's output through again.
+func resumeStream() {
+	pauseMux.Lock()
+	if pausedAt.IsZero() {
+		pauseMux.Unlock()
+		return
+	}
+	paused := time.Since(pausedAt)
+	pausedAt = time.Time{}
+	pauseMux.Unlock()
+
+	// Don't greet new viewers with the placeholder.
+	for _, key := range mainStreamKeys() {
+		resetStream(key)
+	}
+	showPlaceholder("")
+	for _, key := range mainStreamKeys() {
+		go showSourceLost(key)
+	}
+	log.Printf("Stream resumed after %s", paused.Round(time.Second))
+	emit(eventStreamResumed, map[string]float64{"paused_seconds": paused.Seconds()})
+}
+
+// togglePause is bound to the pause hotkey.
+func togglePause() {
+	if screenLocked.Load() {
+		return
+	}
+	if streamPaused() {
+		resumeStream()
+	} else {
+		pauseStream("stream_paused")
+	}
+}
+
+func handlePauseStream(w http.ResponseWriter, r *http.Request) {
+	pauseStream("stream_paused")
+	writeJSON(w, http.StatusOK, services.state())
+}
+
+func handleResumeStream(w http.ResponseWriter, r *http.Request) {
+	if screenLocked.Load() {
+		writeError(w, http.StatusConflict, fmt.Errorf("the screen is locked; the stream resumes when it is unlocked"))
+		return
+	}
+	resumeStream()
+	writeJSON(w, http.StatusOK, services.state())
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/nathfavour/remoter/cursor"
+	"github.com/nathfavour/remoter/ffmpeg"
+	"github.com/nathfavour/remoter/i18n"
+	"github.com/nathfavour/remoter/zst"
+)
+
+var (
+	// cursorMonitors follow the pointer of each X display with viewers on
+	// its cursor channel.
+	cursorMonitors    = make(map[string]*cursor.Monitor)
+	cursorMonitorsMux sync.Mutex
+	// cursorRate is how many times a second the pointer is sampled.
+	cursorRate = 60
+)
+
+func cursorMonitorFor(display string) *cursor.Monitor {
+	cursorMonitorsMux.Lock()
+	defer cursorMonitorsMux.Unlock()
+	m, ok := cursorMonitors[display]
+	if !ok {
+		m = cursor.NewMonitor(display, cursorRate)
+		cursorMonitors[display] = m
+	}
+	return m
+}
+
+// cursorSource returns the X display behind stream and the capture area
+// the stream shows of it.
+func cursorSource(stream string) (display string, area *ffmpeg.Region, ok bool) {
+	if stream == "" {
+		s := services.encoder.Settings()
+		return services.encoder.Status().Display, s.Capture, true
+	}
+	info, found := sessions.Get(stream)
+	if !found || info.Backend == "wayland" {
+		return "", nil, false
+	}
+	return info.Display, nil, true
+}
+
+// handleCursor sends the pointer position and cursor image as JSON text
+// messages over a WebSocket of their own, so the viewer can draw a crisp
+// local cursor without waiting for the video; /ws stays a pure video
+// stream.
+func handleCursor(w http.ResponseWriter, r *http.Request) {
+	stream := r.PathValue("session")
+	if !streamExists(stream) {
+		i18n.Error(w, r, http.StatusNotFound, "no_such_session")
+		return
+	}
+	display, area, ok := cursorSource(stream)
+	if !ok || display == "" {
+		i18n.Error(w, r, http.StatusNotFound, "cursor_unavailable")
+		return
+	}
+
+	conn, zstd, err := upgradeAux(w, r)
+	if err != nil {
+		log.Printf("WebSocket upgrade error: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	events, unsubscribe, err := cursorMonitorFor(display).Subscribe()
+	if err != nil {
+		log.Printf("Cursor channel unavailable: %v", err)
+		return
+	}
+	defer unsubscribe()
+
+	// The client never sends anything; reading detects when it leaves.
+	gone := make(chan struct{})
+	keepAlive(conn, gone)
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			if ev.Type == "pos" && area != nil {
+				// Positions are relative to what the stream shows.
+				ev.X -= area.X
+				ev.Y -= area.Y
+			}
+			if err := zst.WriteJSON(conn, ev, zstd); err != nil {
+				return
+			}
+		case <-gone:
+			return
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package portmap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+const natpmpPort = 5351
+
+// defaultGateway reads the IPv4 default route from /proc/net/route.
+func defaultGateway() (net.IP, error) {
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 3 || fields[1] != "00000000" {
+			continue
+		}
+		b, err := hex.DecodeString(fields[2])
+		if err != nil || len(b) != 4 {
+			continue
+		}
+		// The kernel prints the address in host (little-endian) order.
+		return net.IPv4(b[3], b[2], b[1], b[0]), nil
+	}
+	return nil, fmt.Errorf("no default route found")
+}
+
+// natpmpCall sends req to the gateway and returns a response of at least
+// size bytes, retrying with the RFC 6886 backoff.
+func natpmpCall(gw net.IP, req []byte, size int) ([]byte, error) {
+	conn, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: gw, Port: natpmpPort})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	buf := make([]byte, 16)
+	wait := 250 * time.Millisecond
+	for attempt := 0; attempt < 4; attempt++ {
+		if _, err := conn.Write(req); err != nil {
+			return nil, err
+		}
+		conn.SetReadDeadline(time.Now().Add(wait))
+		n, err := conn.Read(buf)
+		if err != nil {
+			wait *= 2
+			continue
+		}
+		if n < size || buf[1] != req[1]+128 {
+			continue
+		}
+		if code := binary.BigEndian.Uint16(buf[2:4]); code != 0 {
+			return nil, fmt.Errorf("NAT-PMP gateway returned result code %d", code)
+		}
+		return buf[:n], nil
+	}
+	return nil, fmt.Errorf("no NAT-PMP response from %s", gw)
+}
+
+type natpmp struct {
+	gw net.IP
+}
+
+func newNATPMP() (*natpmp, error) {
+	gw, err := defaultGateway()
+	if err != nil {
+		return nil, err
+	}
+	return &natpmp{gw: gw}, nil
+}
+
+func (n *natpmp) externalIP() (string, error) {
+	resp, err := natpmpCall(n.gw, []byte{0, 0}, 12)
+	if err != nil {
+		return "", err
+	}
+	return net.IP(resp[8:12]).String(), nil
+}
+
+// addMapping maps a TCP port and returns the external port the gateway
+// actually assigned.
+func (n *natpmp) addMapping(internal, external int, lifetime time.Duration) (int, error) {
+	req := make([]byte, 12)
+	req[1] = 2 // map TCP
+	binary.BigEndian.PutUint16(req[4:6], uint16(internal))
+	binary.BigEndian.PutUint16(req[6:8], uint16(external))
+	binary.BigEndian.PutUint32(req[8:12], uint32(lifetime/time.Second))
+	resp, err := natpmpCall(n.gw, req, 16)
+	if err != nil {
+		return 0, err
+	}
+	return int(binary.BigEndian.Uint16(resp[10:12])), nil
+}
+
+func (n *natpmp) deleteMapping(internal, external int) error {
+	_, err := n.addMapping(internal, 0, 0)
+	return err
+}
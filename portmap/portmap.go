@@ -0,0 +1,132 @@
+package portmap
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// lifetime is the lease requested from the gateway; mappings are renewed
+// at half of it.
+const lifetime = time.Hour
+
+type gateway interface {
+	externalIP() (string, error)
+	addMapping(internal, external int, lifetime time.Duration) (int, error)
+	deleteMapping(internal, external int) error
+}
+
+// Status describes an active port mapping.
+type Status struct {
+	Method       string    `json:"method"`
+	ExternalIP   string    `json:"external_ip"`
+	ExternalPort int       `json:"external_port"`
+	InternalPort int       `json:"internal_port"`
+	RenewedAt    time.Time `json:"renewed_at"`
+}
+
+// ExternalAddr returns the address viewers outside the LAN connect to.
+func (s Status) ExternalAddr() string {
+	return fmt.Sprintf("%s:%d", s.ExternalIP, s.ExternalPort)
+}
+
+// Mapping is a TCP port forwarded by the local router.
+type Mapping struct {
+	gw   gateway
+	mu   sync.Mutex
+	st   Status
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Map asks the router to forward external to internal using method
+// ("upnp", "natpmp" or "auto", which tries UPnP first). An external port
+// of 0 means the same as internal.
+func Map(method string, internal, external int) (*Mapping, error) {
+	if external == 0 {
+		external = internal
+	}
+
+	var gw gateway
+	var err error
+	switch method {
+	case "upnp":
+		gw, err = newUPnP()
+	case "natpmp":
+		gw, err = newNATPMP()
+	case "", "auto":
+		method = "upnp"
+		if gw, err = newUPnP(); err != nil {
+			log.Printf("UPnP unavailable (%v), trying NAT-PMP", err)
+			method = "natpmp"
+			gw, err = newNATPMP()
+		}
+	default:
+		return nil, fmt.Errorf("unknown port mapping method %q", method)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	assigned, err := gw.addMapping(internal, external, lifetime)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add %s port mapping: %w", method, err)
+	}
+	ip, err := gw.externalIP()
+	if err != nil {
+		log.Printf("Warning: could not determine external IP: %v", err)
+	}
+
+	m := &Mapping{
+		gw: gw,
+		st: Status{
+			Method:       method,
+			ExternalIP:   ip,
+			ExternalPort: assigned,
+			InternalPort: internal,
+			RenewedAt:    time.Now(),
+		},
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go m.renew()
+	return m, nil
+}
+
+func (m *Mapping) renew() {
+	defer close(m.done)
+	t := time.NewTicker(lifetime / 2)
+	defer t.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-t.C:
+		}
+		m.mu.Lock()
+		_, err := m.gw.addMapping(m.st.InternalPort, m.st.ExternalPort, lifetime)
+		if err == nil {
+			m.st.RenewedAt = time.Now()
+		}
+		m.mu.Unlock()
+		if err != nil {
+			log.Printf("Warning: failed to renew port mapping: %v", err)
+		}
+	}
+}
+
+func (m *Mapping) Status() Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.st
+}
+
+// Close removes the mapping from the router.
+func (m *Mapping) Close() error {
+	close(m.stop)
+	<-m.done
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.gw.deleteMapping(m.st.InternalPort, m.st.ExternalPort)
+}
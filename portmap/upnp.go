@@ -0,0 +1,193 @@
+package portmap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const ssdpSearch = "M-SEARCH * HTTP/1.1\r\n" +
+	"HOST: 239.255.255.250:1900\r\n" +
+	"MAN: \"ssdp:discover\"\r\n" +
+	"MX: 2\r\n" +
+	"ST: urn:schemas-upnp-org:device:InternetGatewayDevice:1\r\n\r\n"
+
+type upnp struct {
+	controlURL  string
+	serviceType string
+	localIP     string
+}
+
+// discoverLocation sends an SSDP search and returns the LOCATION of the
+// first internet gateway device that answers.
+func discoverLocation() (string, error) {
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	dst := &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: 1900}
+	if _, err := conn.WriteTo([]byte(ssdpSearch), dst); err != nil {
+		return "", err
+	}
+
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return "", fmt.Errorf("no UPnP gateway found: %w", err)
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if err != nil {
+			continue
+		}
+		if loc := resp.Header.Get("Location"); loc != "" {
+			return loc, nil
+		}
+	}
+}
+
+type upnpDevice struct {
+	Services []struct {
+		ServiceType string `xml:"serviceType"`
+		ControlURL  string `xml:"controlURL"`
+	} `xml:"serviceList>service"`
+	Devices []upnpDevice `xml:"deviceList>device"`
+}
+
+func (d upnpDevice) findWANService() (string, string) {
+	for _, s := range d.Services {
+		if strings.Contains(s.ServiceType, "WANIPConnection") || strings.Contains(s.ServiceType, "WANPPPConnection") {
+			return s.ServiceType, s.ControlURL
+		}
+	}
+	for _, child := range d.Devices {
+		if st, cu := child.findWANService(); cu != "" {
+			return st, cu
+		}
+	}
+	return "", ""
+}
+
+func newUPnP() (*upnp, error) {
+	loc, err := discoverLocation()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := (&http.Client{Timeout: 5 * time.Second}).Get(loc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch gateway description: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var root struct {
+		Device upnpDevice `xml:"device"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&root); err != nil {
+		return nil, fmt.Errorf("failed to parse gateway description: %w", err)
+	}
+	serviceType, control := root.Device.findWANService()
+	if control == "" {
+		return nil, fmt.Errorf("gateway has no WAN connection service")
+	}
+
+	base, _ := url.Parse(loc)
+	ref, err := url.Parse(control)
+	if err != nil {
+		return nil, fmt.Errorf("invalid control URL %q: %w", control, err)
+	}
+
+	// The local address used to reach the gateway is the one it should
+	// forward to.
+	c, err := net.Dial("udp4", base.Host)
+	if err != nil {
+		return nil, err
+	}
+	localIP := c.LocalAddr().(*net.UDPAddr).IP.String()
+	c.Close()
+
+	return &upnp{
+		controlURL:  base.ResolveReference(ref).String(),
+		serviceType: serviceType,
+		localIP:     localIP,
+	}, nil
+}
+
+// soap invokes action on the WAN connection service and returns the raw
+// response body.
+func (u *upnp) soap(action string, args [][2]string) ([]byte, error) {
+	var body strings.Builder
+	body.WriteString(`<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body>`)
+	fmt.Fprintf(&body, `<u:%s xmlns:u="%s">`, action, u.serviceType)
+	for _, a := range args {
+		body.WriteString("<" + a[0] + ">")
+		xml.EscapeText(&body, []byte(a[1]))
+		body.WriteString("</" + a[0] + ">")
+	}
+	fmt.Fprintf(&body, `</u:%s></s:Body></s:Envelope>`, action)
+
+	req, err := http.NewRequest("POST", u.controlURL, strings.NewReader(body.String()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", fmt.Sprintf(`"%s#%s"`, u.serviceType, action))
+
+	resp, err := (&http.Client{Timeout: 5 * time.Second}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("UPnP %s failed: %s", action, resp.Status)
+	}
+	return data, nil
+}
+
+func (u *upnp) externalIP() (string, error) {
+	data, err := u.soap("GetExternalIPAddress", nil)
+	if err != nil {
+		return "", err
+	}
+	var env struct {
+		IP string `xml:"Body>GetExternalIPAddressResponse>NewExternalIPAddress"`
+	}
+	if err := xml.Unmarshal(data, &env); err != nil {
+		return "", err
+	}
+	return env.IP, nil
+}
+
+func (u *upnp) addMapping(internal, external int, lifetime time.Duration) (int, error) {
+	_, err := u.soap("AddPortMapping", [][2]string{
+		{"NewRemoteHost", ""},
+		{"NewExternalPort", fmt.Sprint(external)},
+		{"NewProtocol", "TCP"},
+		{"NewInternalPort", fmt.Sprint(internal)},
+		{"NewInternalClient", u.localIP},
+		{"NewEnabled", "1"},
+		{"NewPortMappingDescription", "remoter"},
+		{"NewLeaseDuration", fmt.Sprint(int(lifetime / time.Second))},
+	})
+	return external, err
+}
+
+func (u *upnp) deleteMapping(internal, external int) error {
+	_, err := u.soap("DeletePortMapping", [][2]string{
+		{"NewRemoteHost", ""},
+		{"NewExternalPort", fmt.Sprint(external)},
+		{"NewProtocol", "TCP"},
+	})
+	return err
+}
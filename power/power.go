@@ -0,0 +1,78 @@
+package power
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/nathfavour/remoter/screenlock"
+)
+
+// Actions are what Do accepts.
+var Actions = []string{"lock", "logout", "suspend", "reboot"}
+
+// Do locks or ends the desktop session showing display, or suspends or
+// reboots the machine, through logind.
+func Do(action, display string) error {
+	switch action {
+	case "lock":
+		return Lock(display)
+	case "logout":
+		return Logout(display)
+	case "suspend":
+		return run(display, "systemctl", "suspend")
+	case "reboot":
+		return run(display, "systemctl", "reboot")
+	}
+	return fmt.Errorf("unknown power action %q", action)
+}
+
+// Lock locks the screen of display: through its logind session, or else
+// by asking the desktop's screensaver.
+func Lock(display string) error {
+	id, err := screenlock.Session(display)
+	if err == nil {
+		err = run(display, "loginctl", "lock-session", id)
+	}
+	if err == nil {
+		return nil
+	}
+	errs := []string{err.Error()}
+	for _, args := range [][]string{
+		{"xdg-screensaver", "lock"},
+		{"xscreensaver-command", "-lock"},
+		{"dbus-send", "--session", "--dest=org.freedesktop.ScreenSaver", "--type=method_call",
+			"/org/freedesktop/ScreenSaver", "org.freedesktop.ScreenSaver.Lock"},
+	} {
+		err := run(display, args[0], args[1:]...)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, err.Error())
+	}
+	return fmt.Errorf("failed to lock %s: %s", display, strings.Join(errs, "; "))
+}
+
+// Logout ends the logind session showing display, and with it every
+// program of the desktop.
+func Logout(display string) error {
+	id, err := screenlock.Session(display)
+	if err != nil {
+		return fmt.Errorf("failed to log out of %s: %w", display, err)
+	}
+	return run(display, "loginctl", "terminate-session", id)
+}
+
+func run(display, name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Env = append(os.Environ(), "DISPLAY="+display)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %v: %s", name, err, strings.TrimSpace(out.String()))
+	}
+	return nil
+}
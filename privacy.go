@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/nathfavour/remoter/automation"
+	"github.com/nathfavour/remoter/ffmpeg"
+)
+
+// maskPollInterval is how often windows named by privacy masks are looked
+// up. A matching window that appears is visible in the stream for up to
+// this long, plus an encoder restart.
+const maskPollInterval = time.Second
+
+// PrivacyMask hides a rectangle of the screen, or the current bounds of
+// the first visible window whose title matches Window, from the stream.
+type PrivacyMask struct {
+	ffmpeg.Region
+	Window string `json:"window,omitempty"`
+	Style  string `json:"style,omitempty"` // "black" (default) or "blur"
+}
+
+func validateMasks(masks []PrivacyMask) error {
+	for i, m := range masks {
+		if m.Style != "" && m.Style != "black" && m.Style != "blur" {
+			return fmt.Errorf("mask %d: unknown style %q", i, m.Style)
+		}
+		if m.Window != "" {
+			if _, err := regexp.Compile(m.Window); err != nil {
+				return fmt.Errorf("mask %d: invalid window pattern: %w", i, err)
+			}
+			continue
+		}
+		if m.W <= 0 || m.H <= 0 || m.X < 0 || m.Y < 0 {
+			return fmt.Errorf("mask %d needs a window or a non-negative position and a positive size", i)
+		}
+	}
+	return nil
+}
+
+var (
+	// windowMasks are the resolved bounds of the windows named by
+	// privacy masks, kept current by watchMaskedWindows.
+	windowMasks    []ffmpeg.Mask
+	windowMasksMux sync.Mutex
+)
+
+// encoderMasks returns the masks the encoder should apply: the fixed
+// regions of cfg and the windows last found on screen.
+func encoderMasks(cfg *Config) []ffmpeg.Mask {
+	var masks []ffmpeg.Mask
+	for _, m := range cfg.PrivacyMasks {
+		if m.Window == "" {
+			masks = append(masks, ffmpeg.Mask{Region: m.Region, Blur: m.Style == "blur"})
+		}
+	}
+	windowMasksMux.Lock()
+	defer windowMasksMux.Unlock()
+	return append(masks, windowMasks...)
+}
+
+// screenMasks returns the rectangles of display the encoder masks, for
+// frames taken without it: /mjpeg, /thumbnail, /delta and screenshots.
+// Those black out blurred masks too.
+func screenMasks(display string) []image.Rectangle {
+	settings := services.encoder.Settings()
+	if display != settings.Display {
+		return nil
+	}
+	rects := make([]image.Rectangle, 0, len(settings.Masks))
+	for _, m := range settings.Masks {
+		rects = append(rects, image.Rect(m.X, m.Y, m.X+m.W, m.Y+m.H))
+	}
+	return rects
+}
+
+// maskPNG blacks out masks of a PNG screenshot.
+func maskPNG(data []byte, masks []image.Rectangle) ([]byte, error) {
+	src, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode screenshot: %w", err)
+	}
+	img := image.NewRGBA(src.Bounds())
+	draw.Draw(img, img.Rect, src, src.Bounds().Min, draw.Src)
+	for _, m := range masks {
+		draw.Draw(img, m, image.Black, image.Point{}, draw.Src)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// watchMaskedWindows follows the windows named by privacy masks, and
+// restarts the encoder with their new bounds whenever one appears, moves
+// or goes away. Window lookups need an X11 display.
+func watchMaskedWindows() {
+	for range time.Tick(maskPollInterval) {
+		services.mu.Lock()
+		rules := slices.Clone(services.cfg.PrivacyMasks)
+		services.mu.Unlock()
+
+		var found []ffmpeg.Mask
+		driver := automation.New(services.encoder.Settings().Display)
+		for _, m := range rules {
+			if m.Window == "" {
+				continue
+			}
+			// No match is the common case: the window is not open.
+			x, y, w, h, err := driver.WindowGeometry(m.Window)
+			if err != nil {
+				continue
+			}
+			found = append(found, ffmpeg.Mask{
+				Region: ffmpeg.Region{X: x, Y: y, W: w, H: h},
+				Blur:   m.Style == "blur",
+			})
+		}
+
+		windowMasksMux.Lock()
+		changed := !slices.Equal(found, windowMasks)
+		windowMasks = found
+		windowMasksMux.Unlock()
+		if !changed {
+			continue
+		}
+
+		services.mu.Lock()
+		masks := encoderMasks(services.cfg)
+		services.mu.Unlock()
+		// Keep the rest of the running settings, such as an adapted bitrate.
+		settings := services.encoder.Settings()
+		settings.Masks = masks
+		if err := services.encoder.Update(settings); err != nil {
+			log.Printf("Warning: failed to apply privacy masks: %v", err)
+		}
+	}
+}
+
+func handleGetMasks(w http.ResponseWriter, r *http.Request) {
+	services.mu.Lock()
+	masks := services.cfg.PrivacyMasks
+	services.mu.Unlock()
+	if masks == nil {
+		masks = []PrivacyMask{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"masks": masks, "active": services.encoder.Settings().Masks})
+}
+
+// handleSetMasks replaces the privacy masks.
+func handleSetMasks(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Masks []PrivacyMask `json:"masks"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	if err := validateMasks(req.Masks); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := services.setMasks(req.Masks); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	log.Printf("API: %d privacy mask(s) set", len(req.Masks))
+	writeJSON(w, http.StatusOK, services.state())
+}
+
+func handleClearMasks(w http.ResponseWriter, r *http.Request) {
+	if err := services.setMasks(nil); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	log.Printf("API: privacy masks cleared")
+	writeJSON(w, http.StatusOK, services.state())
+}
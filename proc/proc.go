@@ -0,0 +1,92 @@
+package proc
+
+import (
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// Group tracks a set of child processes that are torn down together.
+// It is not safe for concurrent use; callers hold their own lock.
+type Group struct {
+	procs []*Proc
+}
+
+// Proc is a started child process.
+type Proc struct {
+	Cmd  *exec.Cmd
+	done chan struct{}
+}
+
+// Done is closed once the process has exited.
+func (p *Proc) Done() <-chan struct{} {
+	return p.done
+}
+
+// Exited reports whether the process has exited.
+func (p *Proc) Exited() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Spawn starts cmd in a process group of its own, so what it forks goes
+// down with it, and adds it to the group.
+func (g *Group) Spawn(cmd *exec.Cmd) (*Proc, error) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	p := &Proc{Cmd: cmd, done: make(chan struct{})}
+	go func() {
+		cmd.Wait()
+		close(p.done)
+	}()
+	g.procs = append(g.procs, p)
+	return p, nil
+}
+
+// Terminate sends SIGTERM to the group of every process still running,
+// newest first, so X servers and VNC servers can remove their lock files,
+// and kills whatever is still running after timeout or was left behind
+// by a process that exited meanwhile. Groups of processes that had
+// exited before are left alone: their IDs may have been reused.
+func (g *Group) Terminate(timeout time.Duration) {
+	live := make([]bool, len(g.procs))
+	for i := len(g.procs) - 1; i >= 0; i-- {
+		if live[i] = !g.procs[i].Exited(); live[i] {
+			_ = syscall.Kill(-g.procs[i].Cmd.Process.Pid, syscall.SIGTERM)
+		}
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	expired := false
+	for i, p := range g.procs {
+		if !live[i] {
+			continue
+		}
+		if !expired {
+			select {
+			case <-p.done:
+				continue
+			case <-timer.C:
+				expired = true
+			}
+		}
+		_ = syscall.Kill(-p.Cmd.Process.Pid, syscall.SIGKILL)
+		<-p.done
+	}
+	// A group with members left keeps its ID from being reused.
+	for i, p := range g.procs {
+		if live[i] && syscall.Kill(-p.Cmd.Process.Pid, 0) == nil {
+			_ = syscall.Kill(-p.Cmd.Process.Pid, syscall.SIGKILL)
+		}
+	}
+	g.procs = nil
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// QuotaConfig limits what one identity may use. Zero means unlimited.
+type QuotaConfig struct {
+	MaxStreams        int   `json:"max_streams,omitempty"`  // concurrent viewer connections
+	MaxSessions       int   `json:"max_sessions,omitempty"` // live virtual sessions created
+	MaxRecordingBytes int64 `json:"max_recording_bytes,omitempty"`
+	MonthlyBandwidth  int64 `json:"monthly_bandwidth_bytes,omitempty"`
+}
+
+// usageRecord is what an identity has used in the current month, and the
+// recordings it owns.
+type usageRecord struct {
+	Month          string           `json:"month"` // YYYY-MM
+	BandwidthBytes int64            `json:"bandwidth_bytes"`
+	Recordings     map[string]int64 `json:"recordings,omitempty"` // name -> size
+}
+
+func (u *usageRecord) recordingBytes() int64 {
+	var n int64
+	for _, size := range u.Recordings {
+		n += size
+	}
+	return n
+}
+
+// quotaTracker enforces per-identity quotas and persists usage, keyed by
+// the authenticated user. Anonymous viewers (auth off) are not limited.
+type quotaTracker struct {
+	mu     sync.Mutex
+	limits map[string]QuotaConfig // by user, "*" for everyone else
+	usage  map[string]*usageRecord
+	owners map[string]string // session ID -> user who created it
+	path   string
+}
+
+var quotas *quotaTracker
+
+func newQuotaTracker(limits map[string]QuotaConfig, path string) *quotaTracker {
+	q := &quotaTracker{
+		limits: limits,
+		usage:  make(map[string]*usageRecord),
+		owners: make(map[string]string),
+		path:   path,
+	}
+	if data, err := os.ReadFile(path); err == nil {
+		if err := json.Unmarshal(data, &q.usage); err != nil {
+			log.Printf("Warning: ignoring unreadable usage file %s: %v", path, err)
+		}
+	}
+	return q
+}
+
+func (q *quotaTracker) limitsFor(user string) QuotaConfig {
+	if l, ok := q.limits[user]; ok {
+		return l
+	}
+	return q.limits["*"]
+}
+
+// record returns user's usage for the current month, starting afresh when
+// the month has turned. q.mu must be held.
+func (q *quotaTracker) record(user string) *usageRecord {
+	month := time.Now().Format("2006-01")
+	u, ok := q.usage[user]
+	if ok && u.Month == month {
+		return u
+	}
+	fresh := &usageRecord{Month: month}
+	if ok {
+		// Recordings outlive the month they were made in.
+		fresh.Recordings = u.Recordings
+	}
+	q.usage[user] = fresh
+	return fresh
+}
+
+func (q *quotaTracker) save() {
+	data, err := json.MarshalIndent(q.usage, "", "  ")
+	if err != nil {
+		return
+	}
+	if err := os.WriteFile(q.path, data, 0600); err != nil {
+		log.Printf("Warning: failed to save usage: %v", err)
+	}
+}
+
+// liveBandwidth sums what user's connected viewers have been sent so far;
+// it is folded into the monthly total when they disconnect.
+func liveBandwidth(user string) (bytes int64, streams int) {
+	clientsMux.RLock()
+	defer clientsMux.RUnlock()
+	for c := range clients {
+		if c.user == user {
+			bytes += c.bytesSent.Load()
+			streams++
+		}
+	}
+	return bytes, streams
+}
+
+// admitStream checks that user may open another viewer connection.
+func (q *quotaTracker) admitStream(user string) error {
+	if q == nil || user == "" {
+		return nil
+	}
+	live, streams := liveBandwidth(user)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	l := q.limitsFor(user)
+	if l.MaxStreams > 0 && streams >= l.MaxStreams {
+		return fmt.Errorf("%d concurrent streams", l.MaxStreams)
+	}
+	if l.MonthlyBandwidth > 0 && q.record(user).BandwidthBytes+live >= l.MonthlyBandwidth {
+		return fmt.Errorf("%d bytes of bandwidth this month", l.MonthlyBandwidth)
+	}
+	return nil
+}
+
+// chargeStream folds a disconnected client's traffic into its user's
+// monthly usage.
+func (q *quotaTracker) chargeStream(c *client) {
+	if q == nil || c.user == "" || c.transport == transportRecord {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.record(c.user).BandwidthBytes += c.bytesSent.Load()
+	q.save()
+}
+
+// sessionsOf counts the live sessions user created. q.mu must be held.
+func (q *quotaTracker) sessionsOf(user string) int {
+	n := 0
+	for id, owner := range q.owners {
+		if _, ok := sessions.Get(id); !ok {
+			delete(q.owners, id)
+			continue
+		}
+		if owner == user {
+			n++
+		}
+	}
+	return n
+}
+
+// admitSession checks that user may create another virtual session.
+func (q *quotaTracker) admitSession(user string) error {
+	if q == nil || user == "" {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if l := q.limitsFor(user); l.MaxSessions > 0 && q.sessionsOf(user) >= l.MaxSessions {
+		return fmt.Errorf("%d concurrent sessions", l.MaxSessions)
+	}
+	return nil
+}
+
+// ownSession records that user created session id.
+func (q *quotaTracker) ownSession(id, user string) {
+	if q == nil || user == "" {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.owners[id] = user
+}
+
+// admitRecording checks that user has recording storage left.
+func (q *quotaTracker) admitRecording(user string) error {
+	if q == nil || user == "" {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if l := q.limitsFor(user); l.MaxRecordingBytes > 0 && q.record(user).recordingBytes() >= l.MaxRecordingBytes {
+		return fmt.Errorf("%d bytes of recordings", l.MaxRecordingBytes)
+	}
+	return nil
+}
+
+// chargeRecording accounts a finished recording to user.
+func (q *quotaTracker) chargeRecording(user, name string, size int64) {
+	if q == nil || user == "" {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	u := q.record(user)
+	if u.Recordings == nil {
+		u.Recordings = make(map[string]int64)
+	}
+	u.Recordings[name] = size
+	q.save()
+}
+
+// releaseRecording credits a deleted recording back to its owner.
+func (q *quotaTracker) releaseRecording(name string) {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, u := range q.usage {
+		if _, ok := u.Recordings[name]; ok {
+			delete(u.Recordings, name)
+			q.save()
+			return
+		}
+	}
+}
+
+// usageInfo is the JSON shape of an identity in the usage API.
+type usageInfo struct {
+	User           string      `json:"user"`
+	Month          string      `json:"month"`
+	BandwidthBytes int64       `json:"bandwidth_bytes"`
+	RecordingBytes int64       `json:"recording_bytes"`
+	Streams        int         `json:"streams"`
+	Sessions       int         `json:"sessions"`
+	Limits         QuotaConfig `json:"limits"`
+}
+
+func (q *quotaTracker) usageOf(user string) usageInfo {
+	live, streams := liveBandwidth(user)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	u := q.record(user)
+	return usageInfo{
+		User:           user,
+		Month:          u.Month,
+		BandwidthBytes: u.BandwidthBytes + live,
+		RecordingBytes: u.recordingBytes(),
+		Streams:        streams,
+		Sessions:       q.sessionsOf(user),
+		Limits:         q.limitsFor(user),
+	}
+}
+
+// handleUsage lists the usage of every known identity: those with quotas,
+// recorded usage or connected viewers.
+func handleUsage(w http.ResponseWriter, r *http.Request) {
+	known := make(map[string]bool)
+	quotas.mu.Lock()
+	for user := range quotas.usage {
+		known[user] = true
+	}
+	for user := range quotas.limits {
+		if user != "*" {
+			known[user] = true
+		}
+	}
+	quotas.mu.Unlock()
+	clientsMux.RLock()
+	for c := range clients {
+		if c.user != "" {
+			known[c.user] = true
+		}
+	}
+	clientsMux.RUnlock()
+
+	users := make([]string, 0, len(known))
+	for user := range known {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+	infos := make([]usageInfo, 0, len(users))
+	for _, user := range users {
+		infos = append(infos, quotas.usageOf(user))
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"usage": infos})
+}
+
+func handleUserUsage(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, quotas.usageOf(r.PathValue("user")))
+}
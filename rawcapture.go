@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/nathfavour/remoter/ffmpeg"
+	"github.com/nathfavour/remoter/i18n"
+	"github.com/nathfavour/remoter/xshm"
+)
+
+// RawCaptureConfig sets the frame rate and quality of /mjpeg, how often
+// /delta sends changed tiles and how wide /thumbnail's frames are.
+type RawCaptureConfig struct {
+	Framerate      int `json:"framerate,omitempty"`       // default 5
+	Quality        int `json:"quality,omitempty"`         // JPEG quality, default 70
+	DeltaRate      int `json:"delta_rate,omitempty"`      // default 10
+	ThumbnailWidth int `json:"thumbnail_width,omitempty"` // default 320
+}
+
+// thumbnailRate is how many frames a second /thumbnail sends.
+const thumbnailRate = 1
+
+var (
+	// rawStreams capture each X display area with viewers on /mjpeg.
+	rawStreams    = make(map[string]*xshm.Stream)
+	rawStreamsMux sync.Mutex
+	// thumbStreams do the same, smaller and slower, for /thumbnail.
+	thumbStreams = make(map[string]*xshm.Stream)
+	rawCapture   RawCaptureConfig
+)
+
+func rawStreamFor(display string, area *ffmpeg.Region) *xshm.Stream {
+	var rect image.Rectangle
+	if area != nil {
+		rect = image.Rect(area.X, area.Y, area.X+area.W, area.Y+area.H)
+	}
+	key := display + " " + rect.String()
+	rawStreamsMux.Lock()
+	defer rawStreamsMux.Unlock()
+	s, ok := rawStreams[key]
+	if !ok {
+		rate, quality := rawCapture.Framerate, rawCapture.Quality
+		if rate <= 0 {
+			rate = 5
+		}
+		if quality <= 0 || quality > 100 {
+			quality = 70
+		}
+		s = xshm.NewStream(display, rect, rate, quality)
+		rawStreams[key] = s
+	}
+	return s
+}
+
+func thumbStreamFor(display string, area *ffmpeg.Region) *xshm.Stream {
+	var rect image.Rectangle
+	if area != nil {
+		rect = image.Rect(area.X, area.Y, area.X+area.W, area.Y+area.H)
+	}
+	key := display + " " + rect.String()
+	rawStreamsMux.Lock()
+	defer rawStreamsMux.Unlock()
+	s, ok := thumbStreams[key]
+	if !ok {
+		width, quality := rawCapture.ThumbnailWidth, rawCapture.Quality
+		if width <= 0 {
+			width = 320
+		}
+		if quality <= 0 || quality > 100 {
+			quality = 70
+		}
+		s = xshm.NewScaledStream(display, rect, thumbnailRate, quality, width)
+		thumbStreams[key] = s
+	}
+	return s
+}
+
+// handleMJPEG serves the display as multipart JPEG frames, which an <img>
+// tag plays natively. The main stream's frames are withheld while it is
+// paused.
+func handleMJPEG(w http.ResponseWriter, r *http.Request) {
+	stream := r.PathValue("session")
+	if !streamExists(stream) {
+		i18n.Error(w, r, http.StatusNotFound, "no_such_session")
+		return
+	}
+	display, area, ok := cursorSource(stream)
+	if !ok || display == "" {
+		i18n.Error(w, r, http.StatusNotFound, "raw_unavailable")
+		return
+	}
+	if err := quotas.admitStream(requestAuth(r).User); err != nil {
+		i18n.Error(w, r, http.StatusForbidden, "quota_exceeded", err)
+		return
+	}
+
+	frames, unsubscribe, err := rawStreamFor(display, area).Subscribe()
+	if err != nil {
+		log.Printf("Raw capture unavailable: %v", err)
+		i18n.Error(w, r, http.StatusServiceUnavailable, "raw_unavailable")
+		return
+	}
+	defer unsubscribe()
+
+	log.Printf("New MJPEG client %s connected to %s", r.RemoteAddr, display)
+	defer log.Printf("MJPEG client %s disconnected", r.RemoteAddr)
+	writeMJPEG(w, r, stream, frames)
+}
+
+// handleThumbnail serves the display at a frame a second, scaled down,
+// for dashboards previewing many hosts: as multipart JPEG like /mjpeg,
+// or with ?once=1 as a single JPEG. Thumbnails are too small to count
+// against a user's stream quota.
+func handleThumbnail(w http.ResponseWriter, r *http.Request) {
+	stream := r.PathValue("session")
+	if !streamExists(stream) {
+		i18n.Error(w, r, http.StatusNotFound, "no_such_session")
+		return
+	}
+	display, area, ok := cursorSource(stream)
+	if !ok || display == "" {
+		i18n.Error(w, r, http.StatusNotFound, "raw_unavailable")
+		return
+	}
+
+	frames, unsubscribe, err := thumbStreamFor(display, area).Subscribe()
+	if err != nil {
+		log.Printf("Raw capture unavailable: %v", err)
+		i18n.Error(w, r, http.StatusServiceUnavailable, "raw_unavailable")
+		return
+	}
+	defer unsubscribe()
+
+	if r.URL.Query().Get("once") != "1" {
+		writeMJPEG(w, r, stream, frames)
+		return
+	}
+	if stream == "" && streamPaused() {
+		i18n.Error(w, r, http.StatusServiceUnavailable, "stream_paused")
+		return
+	}
+	select {
+	case <-r.Context().Done():
+	case frame, ok := <-frames:
+		if !ok {
+			i18n.Error(w, r, http.StatusServiceUnavailable, "raw_unavailable")
+			return
+		}
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Write(frame)
+	}
+}
+
+// writeMJPEG sends frames as multipart JPEG until the viewer leaves or
+// capture stops, withholding the main stream's while it is paused.
+func writeMJPEG(w http.ResponseWriter, r *http.Request, stream string, frames <-chan []byte) {
+	const boundary = "remoterframe"
+	w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary="+boundary)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case frame, ok := <-frames:
+			if !ok {
+				return
+			}
+			if stream == "" && streamPaused() {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "--%s\r\nContent-Type: image/jpeg\r\nContent-Length: %d\r\n\r\n", boundary, len(frame)); err != nil {
+				return
+			}
+			// Frames are shared between viewers, so never append to one.
+			if _, err := w.Write(frame); err != nil {
+				return
+			}
+			if _, err := w.Write([]byte("\r\n")); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+}
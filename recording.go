@@ -0,0 +1,254 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nathfavour/remoter/ffmpeg"
+	"github.com/nathfavour/remoter/storage"
+)
+
+var (
+	// recordingStore holds finished recordings, set up in main.
+	recordingStore storage.Backend
+
+	activeRecordings    = make(map[string]*recording)
+	activeRecordingsMux sync.Mutex
+)
+
+// recording is a stream being written to recordingStore. It joins the hub
+// like a viewer, so it starts at the cached keyframe.
+type recording struct {
+	Name      string    `json:"name"`
+	Stream    string    `json:"stream,omitempty"`
+	Quality   string    `json:"quality"`
+	User      string    `json:"user,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+
+	c        *client
+	finished chan struct{}
+
+	chapters   []Chapter
+	chaptersMu sync.Mutex
+}
+
+// startRecording records stream at quality on behalf of r's user. The
+// file is named after prefix, or the stream when prefix is empty, and the
+// time.
+func startRecording(r *http.Request, stream, quality, prefix string) (*recording, error) {
+	if !streamExists(stream) {
+		return nil, fmt.Errorf("no such stream %q", stream)
+	}
+	if quality == "" {
+		quality = ffmpeg.DefaultTier
+	}
+	if !qualityExists(quality) {
+		return nil, fmt.Errorf("unknown quality %q", quality)
+	}
+	user := requestAuth(r).User
+	if err := quotas.admitRecording(user); err != nil {
+		return nil, fmt.Errorf("quota exceeded: %w", err)
+	}
+
+	now := time.Now()
+	name := fmt.Sprintf("%s-%s.mpg", cmp.Or(stream, "main"), now.Format("20060102-150405"))
+	if prefix != "" {
+		name = fmt.Sprintf("%s-%s.mpg", prefix, now.Format("20060102-150405"))
+	} else if quality != ffmpeg.DefaultTier {
+		name = fmt.Sprintf("%s-%s-%s.mpg", cmp.Or(stream, "main"), quality, now.Format("20060102-150405"))
+	}
+	activeRecordingsMux.Lock()
+	defer activeRecordingsMux.Unlock()
+	if _, ok := activeRecordings[name]; ok {
+		return nil, fmt.Errorf("recording %s is already running", name)
+	}
+	w, err := recordingStore.Create(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create recording: %w", err)
+	}
+
+	c := newClient(transportRecord, r)
+	c.stream = stream
+	c.quality.Store(quality)
+	c.w = w
+	rec := &recording{
+		Name:      name,
+		Stream:    stream,
+		Quality:   quality,
+		User:      user,
+		StartedAt: now,
+		c:         c,
+		finished:  make(chan struct{}),
+	}
+	rec.addChapter(now, "Start", eventRecordingStarted)
+	activeRecordings[name] = rec
+	addClient(c)
+	log.Printf("Recording %s started", name)
+	emit(eventRecordingStarted, rec)
+
+	go func() {
+		<-c.done
+		defer close(rec.finished)
+		activeRecordingsMux.Lock()
+		delete(activeRecordings, name)
+		activeRecordingsMux.Unlock()
+
+		if err := w.Close(); err != nil {
+			log.Printf("Failed to save recording %s: %v", name, err)
+			return
+		}
+		if err := rec.saveChapters(); err != nil {
+			log.Printf("Warning: failed to save chapters of %s: %v", name, err)
+		}
+		size := c.bytesSent.Load()
+		quotas.chargeRecording(user, name, size)
+		log.Printf("Recording %s saved (%d bytes)", name, size)
+		emit(eventRecordingStopped, map[string]any{"name": name, "stream": stream, "size": size})
+		applyRetention()
+	}()
+	return rec, nil
+}
+
+// stop ends the recording and waits until it is stored.
+func (rec *recording) stop() {
+	removeClient(rec.c)
+	rec.c.close()
+	<-rec.finished
+}
+
+func activeRecording(name string) (*recording, bool) {
+	activeRecordingsMux.Lock()
+	defer activeRecordingsMux.Unlock()
+	rec, ok := activeRecordings[name]
+	return rec, ok
+}
+
+// stopRecordings finishes every running recording, used on shutdown.
+func stopRecordings() {
+	activeRecordingsMux.Lock()
+	recs := make([]*recording, 0, len(activeRecordings))
+	for _, rec := range activeRecordings {
+		recs = append(recs, rec)
+	}
+	activeRecordingsMux.Unlock()
+	for _, rec := range recs {
+		rec.stop()
+	}
+}
+
+// recordingInfo is the JSON shape of a recording in the recordings API.
+type recordingInfo struct {
+	storage.Object
+	Active    bool       `json:"active,omitempty"`
+	StartedAt *time.Time `json:"started_at,omitempty"`
+}
+
+func handleListRecordings(w http.ResponseWriter, r *http.Request) {
+	objs, err := recordingStore.List()
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	infos := make([]recordingInfo, 0, len(objs))
+	for _, obj := range objs {
+		if strings.HasSuffix(obj.Name, chaptersSuffix) {
+			continue
+		}
+		infos = append(infos, recordingInfo{Object: obj})
+	}
+	activeRecordingsMux.Lock()
+	for _, rec := range activeRecordings {
+		infos = append(infos, recordingInfo{
+			Object:    storage.Object{Name: rec.Name, Size: rec.c.bytesSent.Load(), ModTime: time.Now()},
+			Active:    true,
+			StartedAt: &rec.StartedAt,
+		})
+	}
+	activeRecordingsMux.Unlock()
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	writeJSON(w, http.StatusOK, map[string]any{"recordings": infos})
+}
+
+func handleStartRecording(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Stream  string `json:"stream"`
+		Quality string `json:"quality"`
+	}
+	// An empty body records the main display.
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	rec, err := startRecording(r, req.Stream, req.Quality, "")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, rec)
+}
+
+func handleStopRecording(w http.ResponseWriter, r *http.Request) {
+	rec, ok := activeRecording(r.PathValue("name"))
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no running recording %q", r.PathValue("name")))
+		return
+	}
+	rec.stop()
+	obj, err := recordingStore.Stat(rec.Name)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, recordingInfo{Object: obj})
+}
+
+// handlePlayRecording serves a recording with range support, so players
+// can seek wherever it is stored.
+func handlePlayRecording(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	obj, err := recordingStore.Stat(name)
+	if err != nil {
+		status := http.StatusBadGateway
+		if errors.Is(err, storage.ErrNotExist) {
+			status = http.StatusNotFound
+		}
+		writeError(w, status, err)
+		return
+	}
+	rd, err := recordingStore.Open(name)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	defer rd.Close()
+	w.Header().Set("Content-Type", recordingType(name))
+	http.ServeContent(w, r, name, obj.ModTime, rd)
+}
+
+func handleDeleteRecording(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if rec, ok := activeRecording(name); ok {
+		rec.stop()
+	}
+	if err := recordingStore.Delete(name); err != nil {
+		status := http.StatusBadGateway
+		if errors.Is(err, storage.ErrNotExist) {
+			status = http.StatusNotFound
+		}
+		writeError(w, status, err)
+		return
+	}
+	deleteChapters(name)
+	quotas.releaseRecording(name)
+	log.Printf("API: deleted recording %s", name)
+	w.WriteHeader(http.StatusNoContent)
+}
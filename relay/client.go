@@ -0,0 +1,105 @@
+package relay
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Client keeps a host registered with a relay server and bridges each
+// tunnel the relay requests to the local remoter listener.
+type Client struct {
+	relayURL string // ws:// or wss:// base URL of the relay
+	id       string
+	secret   string
+	network  string
+	local    string // local address viewers are bridged to
+}
+
+func NewClient(relayURL, id, secret, network, local string) *Client {
+	return &Client{
+		relayURL: strings.TrimSuffix(relayURL, "/"),
+		id:       id,
+		secret:   secret,
+		network:  network,
+		local:    local,
+	}
+}
+
+func (c *Client) endpoint(path string, params url.Values) string {
+	params.Set("secret", c.secret)
+	return c.relayURL + path + "?" + params.Encode()
+}
+
+// Run registers with the relay and serves tunnel requests, reconnecting
+// with backoff whenever the control connection drops. It never returns.
+func (c *Client) Run() {
+	backoff := time.Second
+	for {
+		start := time.Now()
+		err := c.serve()
+		if time.Since(start) > time.Minute {
+			backoff = time.Second
+		}
+		log.Printf("Relay: connection to %s lost: %v; retrying in %s", c.relayURL, err, backoff)
+		time.Sleep(backoff)
+		if backoff < time.Minute {
+			backoff *= 2
+		}
+	}
+}
+
+func (c *Client) serve() error {
+	ws, _, err := websocket.DefaultDialer.Dial(c.endpoint("/_relay/register", url.Values{"id": {c.id}}), nil)
+	if err != nil {
+		return fmt.Errorf("failed to register: %w", err)
+	}
+	defer ws.Close()
+	log.Printf("Relay: registered as %q at %s", c.id, c.relayURL)
+
+	for {
+		_, msg, err := ws.ReadMessage()
+		if err != nil {
+			return err
+		}
+		if connID, ok := strings.CutPrefix(string(msg), "open "); ok {
+			go c.bridge(connID)
+		}
+	}
+}
+
+// bridge dials the tunnel for connID back to the relay and pipes it to the
+// local listener.
+func (c *Client) bridge(connID string) {
+	ws, _, err := websocket.DefaultDialer.Dial(c.endpoint("/_relay/tunnel", url.Values{"conn": {connID}}), nil)
+	if err != nil {
+		log.Printf("Relay: failed to open tunnel %s: %v", connID, err)
+		return
+	}
+	remote := newWSConn(ws)
+	defer remote.Close()
+
+	local, err := net.Dial(c.network, c.local)
+	if err != nil {
+		log.Printf("Relay: failed to reach local server at %s: %v", c.local, err)
+		return
+	}
+	defer local.Close()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(local, remote)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(remote, local)
+		done <- struct{}{}
+	}()
+	<-done
+}
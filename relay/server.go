@@ -0,0 +1,219 @@
+package relay
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Server is the public rendezvous point. Hosts behind NAT keep a control
+// WebSocket open to it; viewers request /h/{host}/... and the server asks
+// the host to dial back a data WebSocket for each tunnelled connection.
+// Visiting /h/{host}/ also sets a cookie so the web UI's absolute paths
+// (/ws, /static/...) reach the same host.
+type Server struct {
+	secret   string
+	upgrader websocket.Upgrader
+
+	proxy *httputil.ReverseProxy
+
+	mu      sync.Mutex
+	hosts   map[string]*hostConn
+	pending map[string]chan net.Conn
+}
+
+type hostConn struct {
+	ws  *websocket.Conn
+	wmu sync.Mutex
+}
+
+func (h *hostConn) send(msg string) error {
+	h.wmu.Lock()
+	defer h.wmu.Unlock()
+	return h.ws.WriteMessage(websocket.TextMessage, []byte(msg))
+}
+
+// NewServer returns a relay that accepts hosts presenting secret.
+func NewServer(secret string) *Server {
+	s := &Server{
+		secret:   secret,
+		upgrader: websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }},
+		hosts:    make(map[string]*hostConn),
+		pending:  make(map[string]chan net.Conn),
+	}
+	s.proxy = &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			t := pr.In.Context().Value(targetKey{}).(target)
+			pr.SetURL(&url.URL{Scheme: "http", Host: "remoter"})
+			pr.Out.URL.Path = "/" + t.path
+			pr.Out.URL.RawPath = ""
+			pr.SetXForwarded()
+		},
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return s.dialHost(ctx, ctx.Value(targetKey{}).(target).host)
+			},
+			DisableKeepAlives: true,
+		},
+		FlushInterval: -1,
+	}
+	return s
+}
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/_relay/register", s.handleRegister)
+	mux.HandleFunc("/_relay/tunnel", s.handleTunnel)
+	mux.HandleFunc("/", s.handleViewer)
+	return mux
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	token := r.URL.Query().Get("secret")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.secret)) == 1
+}
+
+// handleRegister accepts a host's control connection.
+func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id := r.URL.Query().Get("id")
+	if id == "" || strings.Contains(id, "/") {
+		http.Error(w, "Invalid host id", http.StatusBadRequest)
+		return
+	}
+
+	ws, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("Relay: register upgrade error: %v", err)
+		return
+	}
+	h := &hostConn{ws: ws}
+
+	s.mu.Lock()
+	if old, ok := s.hosts[id]; ok {
+		old.ws.Close()
+	}
+	s.hosts[id] = h
+	s.mu.Unlock()
+	log.Printf("Relay: host %q registered from %s", id, r.RemoteAddr)
+
+	// The control connection only carries server-to-host messages; reading
+	// detects when the host goes away.
+	for {
+		if _, _, err := ws.ReadMessage(); err != nil {
+			break
+		}
+	}
+
+	s.mu.Lock()
+	if s.hosts[id] == h {
+		delete(s.hosts, id)
+	}
+	s.mu.Unlock()
+	ws.Close()
+	log.Printf("Relay: host %q disconnected", id)
+}
+
+// handleTunnel accepts the data connection a host dials back in answer to
+// an open request.
+func (s *Server) handleTunnel(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	connID := r.URL.Query().Get("conn")
+
+	s.mu.Lock()
+	ch, ok := s.pending[connID]
+	delete(s.pending, connID)
+	s.mu.Unlock()
+	if !ok {
+		http.Error(w, "Unknown connection", http.StatusNotFound)
+		return
+	}
+
+	ws, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("Relay: tunnel upgrade error: %v", err)
+		return
+	}
+	ch <- newWSConn(ws)
+}
+
+// dialHost asks the host to open a tunnel and waits for it to arrive.
+func (s *Server) dialHost(ctx context.Context, id string) (net.Conn, error) {
+	s.mu.Lock()
+	h, ok := s.hosts[id]
+	if !ok {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("host %q is not connected", id)
+	}
+	b := make([]byte, 12)
+	_, _ = rand.Read(b)
+	connID := hex.EncodeToString(b)
+	ch := make(chan net.Conn, 1)
+	s.pending[connID] = ch
+	s.mu.Unlock()
+
+	if err := h.send("open " + connID); err != nil {
+		s.mu.Lock()
+		delete(s.pending, connID)
+		s.mu.Unlock()
+		return nil, fmt.Errorf("failed to signal host %q: %w", id, err)
+	}
+
+	select {
+	case conn := <-ch:
+		return conn, nil
+	case <-time.After(10 * time.Second):
+	case <-ctx.Done():
+	}
+	s.mu.Lock()
+	delete(s.pending, connID)
+	s.mu.Unlock()
+	return nil, fmt.Errorf("host %q did not open a tunnel in time", id)
+}
+
+type targetKey struct{}
+
+type target struct {
+	host string
+	path string
+}
+
+const hostCookie = "remoter_relay_host"
+
+// handleViewer reverse-proxies /h/{host}/path, or any path when the host
+// cookie is set, to the host over a tunnel. WebSocket upgrades are passed
+// through by the proxy.
+func (s *Server) handleViewer(w http.ResponseWriter, r *http.Request) {
+	var id, path string
+	if rest, ok := strings.CutPrefix(r.URL.Path, "/h/"); ok {
+		id, path, _ = strings.Cut(rest, "/")
+		http.SetCookie(w, &http.Cookie{Name: hostCookie, Value: id, Path: "/", HttpOnly: true})
+	} else if c, err := r.Cookie(hostCookie); err == nil {
+		id, path = c.Value, strings.TrimPrefix(r.URL.Path, "/")
+	}
+	if id == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), targetKey{}, target{host: id, path: path})
+	s.proxy.ServeHTTP(w, r.WithContext(ctx))
+}
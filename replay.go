@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/nathfavour/remoter/ffmpeg"
+	"github.com/nathfavour/remoter/replay"
+	"github.com/nathfavour/remoter/storage"
+)
+
+// ReplayConfig keeps the last Window of a stream, so that what just
+// happened on screen can be saved after the fact with POST
+// /api/v1/replay/export. The buffer watches the stream like a recording
+// does, so the encoder never stops for being idle while it runs.
+type ReplayConfig struct {
+	Enabled  bool   `json:"enabled"`
+	Window   string `json:"window,omitempty"` // default "5m"
+	Stream   string `json:"stream,omitempty"`
+	Quality  string `json:"quality,omitempty"`
+	MaxBytes int64  `json:"max_bytes,omitempty"` // default 512 MiB
+	// Dir keeps the buffer on disk there instead of in memory; it is
+	// emptied on startup.
+	Dir string `json:"dir,omitempty"`
+}
+
+const defaultReplayMaxBytes = 512 << 20
+
+func (c *ReplayConfig) window() (time.Duration, error) {
+	if c.Window == "" {
+		return 5 * time.Minute, nil
+	}
+	d, err := time.ParseDuration(c.Window)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid window %q", c.Window)
+	}
+	return d, nil
+}
+
+func (c *ReplayConfig) validate() error {
+	if _, err := c.window(); err != nil {
+		return err
+	}
+	if c.MaxBytes < 0 {
+		return fmt.Errorf("invalid max_bytes %d", c.MaxBytes)
+	}
+	return nil
+}
+
+// replayBuffer is set in main when the replay buffer is enabled.
+var (
+	replayBuffer *replay.Buffer
+	replayClient *client
+	replayWindow time.Duration
+	// replayStopping is set on shutdown.
+	replayStopping atomic.Bool
+)
+
+func startReplay(cfg *ReplayConfig) error {
+	window, _ := cfg.window()
+	quality := cmp.Or(cfg.Quality, ffmpeg.DefaultTier)
+	if !qualityExists(quality) {
+		return fmt.Errorf("unknown quality %q", quality)
+	}
+	buf, err := replay.New(replay.Options{
+		Window:   window,
+		MaxBytes: cmp.Or(cfg.MaxBytes, defaultReplayMaxBytes),
+		Dir:      cfg.Dir,
+	})
+	if err != nil {
+		return err
+	}
+
+	r, _ := http.NewRequest(http.MethodGet, "/", nil)
+	c := newClient(transportRecord, r)
+	c.stream = cfg.Stream
+	c.quality.Store(quality)
+	c.w = buf
+	replayBuffer, replayClient, replayWindow = buf, c, window
+	addClient(c)
+	go func() {
+		<-c.done
+		// Only a failed write ends it before shutdown.
+		if !replayStopping.Load() {
+			log.Printf("Warning: the replay buffer stopped; restart remoter to resume it")
+		}
+	}()
+	log.Printf("Keeping the last %s of %s for replay", window, cmp.Or(cfg.Stream, "the main display"))
+	return nil
+}
+
+func stopReplay() {
+	if replayClient == nil {
+		return
+	}
+	replayStopping.Store(true)
+	removeClient(replayClient)
+	replayClient.close()
+	replayBuffer.Close()
+}
+
+func handleReplayStatus(w http.ResponseWriter, r *http.Request) {
+	if replayBuffer == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("the replay buffer is not enabled"))
+		return
+	}
+	st := replayBuffer.Stats()
+	resp := map[string]any{
+		"window":   replayWindow.String(),
+		"stream":   replayClient.stream,
+		"quality":  replayClient.quality.Load(),
+		"segments": st.Segments,
+		"bytes":    st.Bytes,
+	}
+	if st.Oldest != nil {
+		resp["oldest"] = st.Oldest
+		resp["available_seconds"] = time.Since(*st.Oldest).Seconds()
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleExportReplay saves the last {"duration"} of the replay buffer,
+// e.g. "2m", among the recordings: as it was streamed with {"format"}
+// "mpg" (the default), or encoded like a clip as "webm" or "gif",
+// optionally {"width"} pixels wide. An mpg export starts at the keyframe
+// before, so it may run a few seconds longer.
+func handleExportReplay(w http.ResponseWriter, r *http.Request) {
+	if replayBuffer == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("the replay buffer is not enabled"))
+		return
+	}
+	var req struct {
+		Duration string `json:"duration"`
+		Format   string `json:"format"`
+		Width    int    `json:"width"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	length := replayWindow
+	if req.Duration != "" {
+		var err error
+		if length, err = time.ParseDuration(req.Duration); err != nil || length <= 0 || length > replayWindow {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("duration must be between 0 and %s", replayWindow))
+			return
+		}
+	}
+	format := cmp.Or(req.Format, "mpg")
+	switch format {
+	case "mpg":
+	case ffmpeg.ClipWebM, ffmpeg.ClipGIF:
+		if length > maxClipLength {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("%s exports are limited to %s", format, maxClipLength))
+			return
+		}
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unknown format %q", format))
+		return
+	}
+	if req.Width < 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid width %d", req.Width))
+		return
+	}
+	user := requestAuth(r).User
+	if err := quotas.admitRecording(user); err != nil {
+		writeError(w, http.StatusForbidden, fmt.Errorf("quota exceeded: %w", err))
+		return
+	}
+
+	clip, err := replayBuffer.Export(length)
+	if err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	defer clip.Close()
+	var src io.Reader = clip
+	if format != "mpg" {
+		data, err := ffmpeg.Clip(r.Context(), clip, clip.Offset, length, format, req.Width)
+		if err != nil {
+			writeError(w, http.StatusUnprocessableEntity, err)
+			return
+		}
+		src = bytes.NewReader(data)
+	}
+
+	name := fmt.Sprintf("replay-%s-%s-%s.%s", cmp.Or(replayClient.stream, "main"),
+		time.Now().Format("20060102-150405"), clipStamp(length), format)
+	cw, err := recordingStore.Create(name)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, fmt.Errorf("failed to create replay export: %w", err))
+		return
+	}
+	size, err := io.Copy(cw, src)
+	if err != nil {
+		cw.Close()
+		writeError(w, http.StatusBadGateway, fmt.Errorf("failed to write replay export: %w", err))
+		return
+	}
+	if err := cw.Close(); err != nil {
+		writeError(w, http.StatusBadGateway, fmt.Errorf("failed to save replay export: %w", err))
+		return
+	}
+	quotas.chargeRecording(user, name, size)
+	log.Printf("API: exported the last %s of the replay buffer to %s (%d bytes)", length, name, size)
+	applyRetention()
+	writeJSON(w, http.StatusCreated, storage.Object{Name: name, Size: size, ModTime: time.Now()})
+}
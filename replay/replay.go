@@ -0,0 +1,245 @@
+package replay
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DefaultSegment is how long a segment runs at least before the next
+// keyframe starts a new one; the buffer is trimmed a segment at a time.
+const DefaultSegment = 5 * time.Second
+
+// sequenceHeader starts every keyframe of ffmpeg's mpeg1video encoder.
+var sequenceHeader = []byte{0x00, 0x00, 0x01, 0xB3}
+
+type Options struct {
+	Window   time.Duration
+	MaxBytes int64 // 0 for no limit beyond Window
+	// Dir keeps segments in files there rather than in memory.
+	Dir     string
+	Segment time.Duration // default DefaultSegment
+}
+
+// Buffer keeps the last Window of an MPEG-1 video stream written to it,
+// in segments that each start at a keyframe, so that any of them begins a
+// clip that decodes. A keyframe whose start code is split between two
+// writes is missed as a cut point, which only makes a segment longer.
+type Buffer struct {
+	opts Options
+
+	mu     sync.Mutex
+	segs   []*segment
+	total  int64
+	seq    int
+	closed bool
+}
+
+type segment struct {
+	start time.Time
+	size  int64
+	data  []byte   // in memory
+	file  *os.File // on disk
+}
+
+// Stats describes what a buffer holds.
+type Stats struct {
+	Segments int        `json:"segments"`
+	Bytes    int64      `json:"bytes"`
+	Oldest   *time.Time `json:"oldest,omitempty"`
+}
+
+func New(opts Options) (*Buffer, error) {
+	if opts.Window <= 0 {
+		return nil, fmt.Errorf("window must be positive")
+	}
+	if opts.Segment <= 0 {
+		opts.Segment = DefaultSegment
+	}
+	if opts.Dir != "" {
+		// Segments of an earlier run are of no use: their times are lost.
+		if err := os.RemoveAll(opts.Dir); err != nil {
+			return nil, fmt.Errorf("failed to clear %s: %w", opts.Dir, err)
+		}
+		if err := os.MkdirAll(opts.Dir, 0700); err != nil {
+			return nil, fmt.Errorf("failed to create %s: %w", opts.Dir, err)
+		}
+	}
+	return &Buffer{opts: opts}, nil
+}
+
+// Write adds stream data, received now. Data before the first keyframe is
+// dropped, as nothing could decode it.
+func (b *Buffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return 0, os.ErrClosed
+	}
+	now := time.Now()
+	rest := p
+	var cur *segment
+	if len(b.segs) > 0 {
+		cur = b.segs[len(b.segs)-1]
+	}
+	if i := bytes.Index(rest, sequenceHeader); i >= 0 && (cur == nil || now.Sub(cur.start) >= b.opts.Segment) {
+		if cur != nil {
+			if err := b.append(cur, rest[:i]); err != nil {
+				return 0, err
+			}
+		}
+		rest = rest[i:]
+		var err error
+		if cur, err = b.newSegment(now); err != nil {
+			return 0, err
+		}
+		b.segs = append(b.segs, cur)
+		b.trim(now)
+	}
+	if cur == nil {
+		return len(p), nil
+	}
+	if err := b.append(cur, rest); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (b *Buffer) newSegment(now time.Time) (*segment, error) {
+	s := &segment{start: now}
+	if b.opts.Dir != "" {
+		b.seq++
+		f, err := os.Create(filepath.Join(b.opts.Dir, fmt.Sprintf("segment-%06d.mpg", b.seq)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create replay segment: %w", err)
+		}
+		s.file = f
+	}
+	return s, nil
+}
+
+func (b *Buffer) append(s *segment, p []byte) error {
+	if s.file != nil {
+		if _, err := s.file.Write(p); err != nil {
+			return fmt.Errorf("failed to write replay segment: %w", err)
+		}
+	} else {
+		s.data = append(s.data, p...)
+	}
+	s.size += int64(len(p))
+	b.total += int64(len(p))
+	return nil
+}
+
+// trim drops the oldest segments while the next one still reaches back
+// Window, or while the buffer holds more than MaxBytes.
+func (b *Buffer) trim(now time.Time) {
+	for len(b.segs) > 1 {
+		over := b.opts.MaxBytes > 0 && b.total > b.opts.MaxBytes
+		if !over && now.Sub(b.segs[1].start) < b.opts.Window {
+			return
+		}
+		b.drop(b.segs[0])
+		b.segs = b.segs[1:]
+	}
+}
+
+func (b *Buffer) drop(s *segment) {
+	b.total -= s.size
+	if s.file != nil {
+		s.file.Close()
+		os.Remove(s.file.Name())
+	}
+}
+
+// Clip is exported stream data; Offset is how far into it the time asked
+// for begins, as it starts at the keyframe before.
+type Clip struct {
+	io.ReadCloser
+	Start  time.Time
+	Offset time.Duration
+	Size   int64
+}
+
+// Export returns the last d of the stream, or as much of it as the buffer
+// holds. It stays readable as the buffer moves on.
+func (b *Buffer) Export(d time.Duration) (*Clip, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.segs) == 0 {
+		return nil, fmt.Errorf("the replay buffer is empty")
+	}
+	from := time.Now().Add(-d)
+	first := 0
+	for i, s := range b.segs {
+		if s.start.After(from) {
+			break
+		}
+		first = i
+	}
+
+	clip := &Clip{Start: b.segs[first].start, Offset: max(from.Sub(b.segs[first].start), 0)}
+	var readers []io.Reader
+	var files []*os.File
+	for _, s := range b.segs[first:] {
+		if s.file == nil {
+			// Segments only grow past the length taken here.
+			readers = append(readers, bytes.NewReader(s.data[:s.size]))
+		} else {
+			// Reopened, so trimming may delete it while it is read.
+			f, err := os.Open(s.file.Name())
+			if err != nil {
+				closeAll(files)
+				return nil, fmt.Errorf("failed to open replay segment: %w", err)
+			}
+			files = append(files, f)
+			readers = append(readers, io.NewSectionReader(f, 0, s.size))
+		}
+		clip.Size += s.size
+	}
+	clip.ReadCloser = &multiReadCloser{Reader: io.MultiReader(readers...), files: files}
+	return clip, nil
+}
+
+type multiReadCloser struct {
+	io.Reader
+	files []*os.File
+}
+
+func (m *multiReadCloser) Close() error {
+	closeAll(m.files)
+	return nil
+}
+
+func closeAll(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
+
+func (b *Buffer) Stats() Stats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	st := Stats{Segments: len(b.segs), Bytes: b.total}
+	if len(b.segs) > 0 {
+		oldest := b.segs[0].start
+		st.Oldest = &oldest
+	}
+	return st
+}
+
+// Close empties the buffer, deleting its files.
+func (b *Buffer) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for _, s := range b.segs {
+		b.drop(s)
+	}
+	b.segs = nil
+	return nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nathfavour/remoter/storage"
+)
+
+// RetentionPolicy prunes finished recordings of every kind, scheduled or
+// not: those older than MaxAge, then the oldest until the rest fit in
+// MaxTotalBytes. Running recordings are never touched.
+type RetentionPolicy struct {
+	MaxTotalBytes int64  `json:"max_total_bytes,omitempty"`
+	MaxAge        string `json:"max_age,omitempty"` // e.g. "720h"
+}
+
+// retentionInterval is how often the policy is applied besides after
+// every recording.
+const retentionInterval = time.Hour
+
+var (
+	retention    RetentionPolicy
+	retentionAge time.Duration
+	retentionMux sync.Mutex
+)
+
+func (p RetentionPolicy) maxAge() (time.Duration, error) {
+	if p.MaxAge == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(p.MaxAge)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid max_age %q", p.MaxAge)
+	}
+	return d, nil
+}
+
+func (p RetentionPolicy) validate() error {
+	if p.MaxTotalBytes < 0 {
+		return fmt.Errorf("invalid max_total_bytes %d", p.MaxTotalBytes)
+	}
+	_, err := p.maxAge()
+	return err
+}
+
+func setRetention(p RetentionPolicy) {
+	maxAge, _ := p.maxAge()
+	retentionMux.Lock()
+	retention, retentionAge = p, maxAge
+	retentionMux.Unlock()
+}
+
+func startRetention(p *RetentionPolicy) {
+	if p != nil {
+		setRetention(*p)
+	}
+	go func() {
+		for range time.Tick(retentionInterval) {
+			applyRetention()
+		}
+	}()
+}
+
+// applyRetention deletes the recordings the policy no longer keeps, and
+// returns their names.
+func applyRetention() []string {
+	retentionMux.Lock()
+	defer retentionMux.Unlock()
+	deleted := []string{}
+	if retention.MaxTotalBytes == 0 && retentionAge == 0 {
+		return deleted
+	}
+	objs, err := recordingStore.List()
+	if err != nil {
+		log.Printf("Retention failed to list recordings: %v", err)
+		return deleted
+	}
+	var kept []storage.Object
+	var total int64
+	for _, obj := range objs {
+		if strings.HasSuffix(obj.Name, chaptersSuffix) {
+			continue
+		}
+		if _, ok := activeRecording(obj.Name); ok {
+			continue
+		}
+		kept = append(kept, obj)
+		total += obj.Size
+	}
+	sort.Slice(kept, func(i, j int) bool { return kept[i].ModTime.Before(kept[j].ModTime) })
+
+	for _, obj := range kept {
+		tooOld := retentionAge > 0 && time.Since(obj.ModTime) > retentionAge
+		tooBig := retention.MaxTotalBytes > 0 && total > retention.MaxTotalBytes
+		if !tooOld && !tooBig {
+			continue
+		}
+		if err := recordingStore.Delete(obj.Name); err != nil {
+			log.Printf("Retention failed to delete %s: %v", obj.Name, err)
+			continue
+		}
+		deleteChapters(obj.Name)
+		quotas.releaseRecording(obj.Name)
+		total -= obj.Size
+		deleted = append(deleted, obj.Name)
+		log.Printf("Retention deleted recording %s (%d bytes, from %s)", obj.Name, obj.Size, obj.ModTime.Format(time.DateTime))
+	}
+	return deleted
+}
+
+func handleGetRetention(w http.ResponseWriter, r *http.Request) {
+	retentionMux.Lock()
+	p := retention
+	retentionMux.Unlock()
+	writeJSON(w, http.StatusOK, p)
+}
+
+// handleSetRetention replaces the policy, saves it to the config and
+// applies it right away.
+func handleSetRetention(w http.ResponseWriter, r *http.Request) {
+	var p RetentionPolicy
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	if err := p.validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	setRetention(p)
+	services.updateRetention(p)
+	log.Printf("API: recording retention set to max_total_bytes=%d, max_age=%q", p.MaxTotalBytes, p.MaxAge)
+	deleted := applyRetention()
+	writeJSON(w, http.StatusOK, map[string]any{"retention": p, "deleted": deleted})
+}
+
+func handleApplyRetention(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"deleted": applyRetention()})
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nathfavour/remoter/schedule"
+)
+
+// RecordingSchedule records a stream unattended, for Duration from every
+// time Cron matches (in the server's local time), e.g. "0 9 * * mon-fri"
+// for 8h to record working hours. Keep and MaxAge prune the schedule's
+// older recordings; other recordings are never touched.
+type RecordingSchedule struct {
+	Name     string `json:"name"`
+	Cron     string `json:"cron"`     // e.g. "0 14 * * tue" or "@daily"
+	Duration string `json:"duration"` // e.g. "1h30m"
+	Stream   string `json:"stream,omitempty"`
+	Quality  string `json:"quality,omitempty"`
+	Keep     int    `json:"keep,omitempty"`    // newest recordings to keep
+	MaxAge   string `json:"max_age,omitempty"` // e.g. "720h"
+	// CatchUp starts recording right away when the schedule starts, or
+	// remoter does, within Duration of a time Cron matched, for the rest
+	// of that window.
+	CatchUp bool `json:"catch_up,omitempty"`
+}
+
+// scheduledJob is a running schedule, in the shape the schedules API
+// returns.
+type scheduledJob struct {
+	RecordingSchedule
+	Next      *time.Time `json:"next,omitempty"`
+	Recording string     `json:"recording,omitempty"` // while one runs
+
+	spec     *schedule.Spec
+	duration time.Duration
+	maxAge   time.Duration
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+var (
+	scheduledJobs    = make(map[string]*scheduledJob)
+	scheduledJobsMux sync.Mutex
+)
+
+var scheduleNameRe = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// newScheduledJob validates s.
+func newScheduledJob(s RecordingSchedule) (*scheduledJob, error) {
+	if !scheduleNameRe.MatchString(s.Name) {
+		return nil, fmt.Errorf("invalid schedule name %q", s.Name)
+	}
+	spec, err := schedule.Parse(s.Cron)
+	if err != nil {
+		return nil, err
+	}
+	duration, err := time.ParseDuration(s.Duration)
+	if err != nil || duration <= 0 {
+		return nil, fmt.Errorf("invalid duration %q", s.Duration)
+	}
+	var maxAge time.Duration
+	if s.MaxAge != "" {
+		if maxAge, err = time.ParseDuration(s.MaxAge); err != nil || maxAge <= 0 {
+			return nil, fmt.Errorf("invalid max_age %q", s.MaxAge)
+		}
+	}
+	if s.Keep < 0 {
+		return nil, fmt.Errorf("invalid keep %d", s.Keep)
+	}
+	return &scheduledJob{RecordingSchedule: s, spec: spec, duration: duration, maxAge: maxAge}, nil
+}
+
+func validateSchedules(schedules []RecordingSchedule) error {
+	seen := make(map[string]bool)
+	for _, s := range schedules {
+		if _, err := newScheduledJob(s); err != nil {
+			return fmt.Errorf("schedule %s: %w", s.Name, err)
+		}
+		if seen[s.Name] {
+			return fmt.Errorf("duplicate schedule %q", s.Name)
+		}
+		seen[s.Name] = true
+	}
+	return nil
+}
+
+func startSchedules(schedules []RecordingSchedule) {
+	for _, s := range schedules {
+		job, err := newScheduledJob(s)
+		if err != nil {
+			log.Printf("Warning: skipping schedule %s: %v", s.Name, err)
+			continue
+		}
+		job.start()
+	}
+}
+
+func (job *scheduledJob) start() {
+	job.stop = make(chan struct{})
+	job.done = make(chan struct{})
+	if next := job.spec.Next(time.Now()); !next.IsZero() {
+		job.Next = &next
+	}
+	scheduledJobsMux.Lock()
+	scheduledJobs[job.Name] = job
+	scheduledJobsMux.Unlock()
+	go job.run()
+}
+
+// prefix starts the names of the schedule's recordings.
+func (job *scheduledJob) prefix() string {
+	return "scheduled-" + job.Name
+}
+
+func (job *scheduledJob) run() {
+	defer close(job.done)
+	catchUp := job.CatchUp
+	for {
+		now := time.Now()
+		next, length := job.spec.Next(now), job.duration
+		if catchUp {
+			if start := job.spec.Next(now.Add(-job.duration)); !start.IsZero() && start.Before(now) {
+				next, length = now, start.Add(job.duration).Sub(now)
+				log.Printf("Schedule %s catches up on the window that started at %s", job.Name, start.Format(time.Kitchen))
+			}
+			catchUp = false
+		}
+		if next.IsZero() {
+			log.Printf("Schedule %s never runs again", job.Name)
+			return
+		}
+		scheduledJobsMux.Lock()
+		job.Next = &next
+		scheduledJobsMux.Unlock()
+
+		select {
+		case <-time.After(time.Until(next)):
+		case <-job.stop:
+			return
+		}
+
+		// Scheduled recordings belong to no user, so no quota applies.
+		r, _ := http.NewRequest(http.MethodPost, "/", nil)
+		rec, err := startRecording(r, job.Stream, job.Quality, job.prefix())
+		if err != nil {
+			log.Printf("Schedule %s failed to start recording: %v", job.Name, err)
+			continue
+		}
+		scheduledJobsMux.Lock()
+		job.Recording = rec.Name
+		scheduledJobsMux.Unlock()
+
+		stopped := false
+		select {
+		case <-time.After(length):
+			rec.stop()
+		case <-rec.finished:
+			// Stopped through the API.
+		case <-job.stop:
+			rec.stop()
+			stopped = true
+		}
+		scheduledJobsMux.Lock()
+		job.Recording = ""
+		scheduledJobsMux.Unlock()
+		job.prune()
+		if stopped {
+			return
+		}
+	}
+}
+
+// prune deletes the schedule's recordings beyond Keep or older than
+// MaxAge.
+func (job *scheduledJob) prune() {
+	if job.Keep == 0 && job.maxAge == 0 {
+		return
+	}
+	objs, err := recordingStore.List()
+	if err != nil {
+		log.Printf("Schedule %s failed to list recordings: %v", job.Name, err)
+		return
+	}
+	var names []string
+	for _, obj := range objs {
+		stamp, ok := strings.CutPrefix(obj.Name, job.prefix()+"-")
+		if !ok {
+			continue
+		}
+		// Another schedule's name may extend this one's.
+		if _, err := time.Parse("20060102-150405.mpg", stamp); err != nil {
+			continue
+		}
+		if job.maxAge > 0 && time.Since(obj.ModTime) > job.maxAge {
+			job.deleteRecording(obj.Name)
+			continue
+		}
+		names = append(names, obj.Name)
+	}
+	if job.Keep == 0 || len(names) <= job.Keep {
+		return
+	}
+	// Timestamps sort oldest first.
+	sort.Strings(names)
+	for _, name := range names[:len(names)-job.Keep] {
+		job.deleteRecording(name)
+	}
+}
+
+func (job *scheduledJob) deleteRecording(name string) {
+	if err := recordingStore.Delete(name); err != nil {
+		log.Printf("Schedule %s failed to delete %s: %v", job.Name, name, err)
+		return
+	}
+	deleteChapters(name)
+	quotas.releaseRecording(name)
+	log.Printf("Schedule %s deleted old recording %s", job.Name, name)
+}
+
+// stopSchedule ends a schedule, finishing its running recording.
+func stopSchedule(name string) bool {
+	scheduledJobsMux.Lock()
+	job, ok := scheduledJobs[name]
+	delete(scheduledJobs, name)
+	scheduledJobsMux.Unlock()
+	if !ok {
+		return false
+	}
+	close(job.stop)
+	<-job.done
+	return true
+}
+
+// stopSchedules ends every schedule, used on shutdown before the
+// recordings are stopped.
+func stopSchedules() {
+	scheduledJobsMux.Lock()
+	names := make([]string, 0, len(scheduledJobs))
+	for name := range scheduledJobs {
+		names = append(names, name)
+	}
+	scheduledJobsMux.Unlock()
+	for _, name := range names {
+		stopSchedule(name)
+	}
+}
+
+func handleListSchedules(w http.ResponseWriter, r *http.Request) {
+	scheduledJobsMux.Lock()
+	jobs := make([]scheduledJob, 0, len(scheduledJobs))
+	for _, job := range scheduledJobs {
+		jobs = append(jobs, *job)
+	}
+	scheduledJobsMux.Unlock()
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Name < jobs[j].Name })
+	writeJSON(w, http.StatusOK, map[string]any{"schedules": jobs})
+}
+
+// handleAddSchedule adds a schedule, or replaces the one of the same name,
+// and saves it to the config.
+func handleAddSchedule(w http.ResponseWriter, r *http.Request) {
+	var s RecordingSchedule
+	if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	job, err := newScheduledJob(s)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if !streamExists(s.Stream) {
+		// Sessions come and go, so only warn.
+		log.Printf("Warning: schedule %s records stream %q, which is not running", s.Name, s.Stream)
+	}
+	stopSchedule(s.Name)
+	services.updateSchedules(func(schedules []RecordingSchedule) []RecordingSchedule {
+		schedules = slices.DeleteFunc(schedules, func(o RecordingSchedule) bool { return o.Name == s.Name })
+		return append(schedules, s)
+	})
+	job.start()
+	log.Printf("API: schedule %s records %q at %q for %s", s.Name, s.Stream, s.Cron, s.Duration)
+
+	scheduledJobsMux.Lock()
+	resp := *job
+	scheduledJobsMux.Unlock()
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+func handleDeleteSchedule(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !stopSchedule(name) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no schedule %q", name))
+		return
+	}
+	services.updateSchedules(func(schedules []RecordingSchedule) []RecordingSchedule {
+		return slices.DeleteFunc(schedules, func(o RecordingSchedule) bool { return o.Name == name })
+	})
+	log.Printf("API: deleted schedule %s", name)
+	w.WriteHeader(http.StatusNoContent)
+}
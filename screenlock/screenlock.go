@@ -0,0 +1,135 @@
+package screenlock
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// method reports whether display is locked, or an error when it cannot
+// tell.
+type method struct {
+	name   string
+	locked func(display string) (bool, error)
+}
+
+var methods = []method{
+	{"logind", logindLocked},
+	{"xscreensaver", xscreensaverLocked},
+	{"org.freedesktop.ScreenSaver", freedesktopLocked},
+}
+
+func output(display string, name string, args ...string) (string, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Env = append(os.Environ(), "DISPLAY="+display)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%s: %v: %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+	return string(out), nil
+}
+
+// Session returns the ID of the logind session showing display.
+func Session(display string) (string, error) {
+	id, _, err := logindSession(display)
+	return id, err
+}
+
+// logindSession finds the logind session showing display, with its
+// LockedHint property.
+func logindSession(display string) (string, string, error) {
+	list, err := output(display, "loginctl", "list-sessions", "--no-legend")
+	if err != nil {
+		return "", "", err
+	}
+	sc := bufio.NewScanner(strings.NewReader(list))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		props, err := output(display, "loginctl", "show-session", fields[0], "-p", "Display", "-p", "LockedHint")
+		if err != nil {
+			continue
+		}
+		if !strings.Contains(props, "Display="+display+"\n") {
+			continue
+		}
+		return fields[0], props, nil
+	}
+	return "", "", fmt.Errorf("no logind session shows %s", display)
+}
+
+// logindLocked reads the LockedHint that lock screens set on the logind
+// session showing display.
+func logindLocked(display string) (bool, error) {
+	_, props, err := logindSession(display)
+	if err != nil {
+		return false, err
+	}
+	return strings.Contains(props, "LockedHint=yes"), nil
+}
+
+func xscreensaverLocked(display string) (bool, error) {
+	out, err := output(display, "xscreensaver-command", "-time")
+	if err != nil {
+		return false, err
+	}
+	return strings.Contains(out, "locked"), nil
+}
+
+// freedesktopLocked asks the desktop's screensaver service, as GNOME,
+// KDE and XFCE provide it, whether it is active.
+func freedesktopLocked(display string) (bool, error) {
+	out, err := output(display, "dbus-send", "--session", "--print-reply",
+		"--dest=org.freedesktop.ScreenSaver", "/org/freedesktop/ScreenSaver",
+		"org.freedesktop.ScreenSaver.GetActive")
+	if err != nil {
+		return false, err
+	}
+	return strings.Contains(out, "boolean true"), nil
+}
+
+// Watch calls fn whenever display is locked or unlocked, checking every
+// interval with the first method that works on this host; fn is called
+// right away if it is locked already. It fails if no method works.
+func Watch(display string, interval time.Duration, fn func(locked bool)) error {
+	var (
+		m      method
+		locked bool
+		errs   []string
+	)
+	for _, candidate := range methods {
+		l, err := candidate.locked(display)
+		if err == nil {
+			m, locked = candidate, l
+			break
+		}
+		errs = append(errs, err.Error())
+	}
+	if m.locked == nil {
+		return fmt.Errorf("no way to detect the screen lock of %s: %s", display, strings.Join(errs, "; "))
+	}
+	fmt.Printf("Watching the screen lock of %s through %s\n", display, m.name)
+	if locked {
+		fn(true)
+	}
+
+	go func() {
+		for range time.Tick(interval) {
+			l, err := m.locked(display)
+			if err != nil || l == locked {
+				continue
+			}
+			locked = l
+			fn(locked)
+		}
+	}()
+	return nil
+}
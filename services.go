@@ -0,0 +1,418 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/nathfavour/remoter/audio"
+	"github.com/nathfavour/remoter/ffmpeg"
+	"github.com/nathfavour/remoter/portmap"
+	"github.com/nathfavour/remoter/session"
+	"github.com/nathfavour/remoter/vnc"
+)
+
+var (
+	// services is the process-wide service manager, set up in main.
+	services *serviceManager
+	// sessions manages the virtual desktops created from templates.
+	sessions *session.Manager
+	// desktops are the VNC desktops created through the API, apart from
+	// the VNC service.
+	desktops *vnc.Manager
+
+	// portMapping is the router port forward, when enabled.
+	portMapping    *portmap.Mapping
+	portMappingMux sync.Mutex
+)
+
+// serviceManager owns the FFmpeg, audio and VNC services and applies runtime
+// changes to them on behalf of the control API.
+type serviceManager struct {
+	mu      sync.Mutex
+	cfg     *Config
+	cfgPath string
+	encoder *ffmpeg.Encoder
+	audio   *audio.Encoder
+	vnc     *vnc.Server
+
+	// encoderExited gets the error of the encoder's last unplanned exit.
+	encoderExited chan error
+}
+
+func newServiceManager(cfg *Config, cfgPath string) *serviceManager {
+	m := &serviceManager{
+		cfg:     cfg,
+		cfgPath: cfgPath,
+		encoder: ffmpeg.NewEncoder(encoderSettings(cfg)),
+		audio:   audio.NewEncoder(audioSettings(cfg)),
+		vnc:     vnc.NewServer(cfg.Display, cfg.Res),
+
+		encoderExited: make(chan error, 1),
+	}
+	m.encoder.OnExit(func(err error) {
+		data := map[string]any{"status": m.encoder.Status()}
+		if err != nil {
+			data["error"] = err.Error()
+		}
+		emit(eventEncoderExited, data)
+		select {
+		case m.encoderExited <- err:
+		default:
+		}
+	})
+	return m
+}
+
+func encoderSettings(cfg *Config) ffmpeg.Settings {
+	s := ffmpeg.Settings{
+		Display:    mainDisplay(cfg),
+		Res:        mainRes(cfg),
+		RuntimeDir: cfg.RuntimeDir,
+		Ingest:     ingestStream,
+		Framerate:  encoderFramerate(cfg),
+		Bitrate:    cfg.Bitrate,
+		Capture:    mainCapture(cfg),
+		ROI:        cfg.ROI,
+		Masks:      encoderMasks(cfg),
+		Overlay:    encoderOverlay(),
+		Scale:      cfg.Scale,
+		Color:      cfg.Color,
+		Watermark:  cfg.Watermark,
+
+		AlignRefresh: cfg.AlignRefresh,
+		HideCursor:   cfg.HideCursor,
+		Tiers:        cfg.Tiers,
+	}
+	if cfg.StatsOverlay {
+		s.Stats = statsOverlayPath
+	}
+	degradeSettings(&s)
+	return s
+}
+
+func (m *serviceManager) start(name string) error {
+	return traceService("start", name, func() error { return m.startService(name) })
+}
+
+func (m *serviceManager) startService(name string) error {
+	switch name {
+	case "ffmpeg":
+		if err := m.encoder.Start(); err != nil {
+			return err
+		}
+		m.recordProbe()
+		emit(eventEncoderStarted, m.encoder.Status())
+		return nil
+	case "audio":
+		return m.audio.Start()
+	case "vnc":
+		return m.vnc.Start()
+	}
+	return fmt.Errorf("unknown service %q", name)
+}
+
+func (m *serviceManager) stop(name string) error {
+	return traceService("stop", name, func() error { return m.stopService(name) })
+}
+
+func (m *serviceManager) stopService(name string) error {
+	switch name {
+	case "ffmpeg":
+		if err := m.encoder.Stop(); err != nil {
+			return err
+		}
+		emit(eventEncoderStopped, m.encoder.Status())
+		return nil
+	case "audio":
+		return m.audio.Stop()
+	case "vnc":
+		return m.vnc.Stop()
+	}
+	return fmt.Errorf("unknown service %q", name)
+}
+
+func (m *serviceManager) restart(name string) error {
+	return traceService("restart", name, func() error { return m.restartService(name) })
+}
+
+func (m *serviceManager) restartService(name string) error {
+	switch name {
+	case "ffmpeg":
+		if err := m.encoder.Restart(); err != nil {
+			return err
+		}
+		m.recordProbe()
+		emit(eventEncoderStarted, m.encoder.Status())
+		return nil
+	case "audio":
+		return m.audio.Restart()
+	case "vnc":
+		return m.vnc.Restart()
+	}
+	return fmt.Errorf("unknown service %q", name)
+}
+
+// stopAll stops every running service, used on shutdown.
+func (m *serviceManager) stopAll() {
+	for _, name := range []string{"ffmpeg", "audio", "vnc"} {
+		_ = m.stop(name)
+	}
+}
+
+// reapLoop periodically tears down expired, idle and orphaned sessions and
+// orphaned VNC desktops.
+func reapLoop(interval time.Duration) {
+	for range time.Tick(interval) {
+		for id, reason := range sessions.Reap() {
+			emit(eventSessionDestroyed, map[string]string{"id": id, "reason": reason})
+		}
+		pruneSessionStreams()
+		if services.vnc.Reap() {
+			log.Printf("VNC service was orphaned and has been stopped")
+		}
+		for _, id := range desktops.Reap() {
+			log.Printf("VNC desktop %s was orphaned and has been stopped", id)
+		}
+	}
+}
+
+// recordProbe persists the display and resolution ffmpeg actually captured,
+// so the config reflects the real screen on the next start.
+func (m *serviceManager) recordProbe() {
+	st := m.encoder.Status()
+	if !st.Running || virtualDisplay != nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	res := st.Res + "x" + st.Depth
+	if m.cfg.Res == res && m.cfg.Display == st.Display {
+		return
+	}
+	m.cfg.Res = res
+	m.cfg.Display = st.Display
+	if err := saveConfig(m.cfg, m.cfgPath); err != nil {
+		log.Printf("Warning: failed to update config file: %v", err)
+	}
+}
+
+// updatePipeline changes the framerate and/or bitrate, persists them and
+// restarts the encoder if it is running.
+func (m *serviceManager) updatePipeline(framerate int, bitrate string) error {
+	m.mu.Lock()
+	if framerate > 0 {
+		m.cfg.Framerate = framerate
+	}
+	if bitrate != "" {
+		m.cfg.Bitrate = bitrate
+	}
+	if err := saveConfig(m.cfg, m.cfgPath); err != nil {
+		log.Printf("Warning: failed to update config file: %v", err)
+	}
+	settings := encoderSettings(m.cfg)
+	m.mu.Unlock()
+
+	if err := m.encoder.Update(settings); err != nil {
+		return err
+	}
+	m.recordProbe()
+	return nil
+}
+
+// setROI changes the region encoded at higher quality, nil for none,
+// persists it and restarts the encoder if it is running.
+func (m *serviceManager) setROI(roi *ffmpeg.Region) error {
+	m.mu.Lock()
+	m.cfg.ROI = roi
+	if err := saveConfig(m.cfg, m.cfgPath); err != nil {
+		log.Printf("Warning: failed to update config file: %v", err)
+	}
+	settings := encoderSettings(m.cfg)
+	m.mu.Unlock()
+
+	return m.encoder.Update(settings)
+}
+
+// setCapture restricts capture to a region of the screen, nil for all of
+// it, persists it and restarts the encoder if it is running.
+func (m *serviceManager) setCapture(region *ffmpeg.Region) error {
+	m.mu.Lock()
+	m.cfg.Capture = region
+	if err := saveConfig(m.cfg, m.cfgPath); err != nil {
+		log.Printf("Warning: failed to update config file: %v", err)
+	}
+	settings := encoderSettings(m.cfg)
+	m.mu.Unlock()
+
+	return m.encoder.Update(settings)
+}
+
+// setMasks replaces the privacy masks, persists them and restarts the
+// encoder if it is running.
+func (m *serviceManager) setMasks(masks []PrivacyMask) error {
+	m.mu.Lock()
+	m.cfg.PrivacyMasks = masks
+	if err := saveConfig(m.cfg, m.cfgPath); err != nil {
+		log.Printf("Warning: failed to update config file: %v", err)
+	}
+	settings := encoderSettings(m.cfg)
+	m.mu.Unlock()
+
+	return m.encoder.Update(settings)
+}
+
+// setAudioSources replaces the mixed audio sources, persists them and
+// restarts the audio encoder if it is running.
+func (m *serviceManager) setAudioSources(sources []audio.Source) error {
+	m.mu.Lock()
+	if m.cfg.Audio == nil {
+		m.cfg.Audio = &AudioConfig{}
+	}
+	m.cfg.Audio.Sources = sources
+	if err := saveConfig(m.cfg, m.cfgPath); err != nil {
+		log.Printf("Warning: failed to update config file: %v", err)
+	}
+	settings := audioSettings(m.cfg)
+	m.mu.Unlock()
+
+	return m.audio.Update(settings)
+}
+
+// setResolution changes the resolution of the main stream, persists it
+// and restarts the encoder if it is running: a virtual display is resized
+// to res, a real one is scaled to it.
+func (m *serviceManager) setResolution(res string) error {
+	m.mu.Lock()
+	if virtualDisplay != nil && res != "" {
+		w, h, err := ffmpeg.ParseSize(res)
+		if err == nil {
+			err = virtualDisplay.Resize(w, h)
+		}
+		if err != nil {
+			m.mu.Unlock()
+			return fmt.Errorf("failed to resize virtual display: %w", err)
+		}
+		m.cfg.VirtualDisplay.Res = res
+	} else {
+		m.cfg.Scale = res
+	}
+	if err := saveConfig(m.cfg, m.cfgPath); err != nil {
+		log.Printf("Warning: failed to update config file: %v", err)
+	}
+	settings := encoderSettings(m.cfg)
+	m.mu.Unlock()
+
+	return m.encoder.Update(settings)
+}
+
+// setMeta updates the main stream's title and description and persists
+// them; nil leaves a field unchanged.
+func (m *serviceManager) setMeta(title, description *string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if title != nil {
+		m.cfg.Title = *title
+	}
+	if description != nil {
+		m.cfg.Description = *description
+	}
+	if err := saveConfig(m.cfg, m.cfgPath); err != nil {
+		log.Printf("Warning: failed to update config file: %v", err)
+	}
+}
+
+// updateSchedules replaces the configured recording schedules with what
+// update returns.
+func (m *serviceManager) updateSchedules(update func([]RecordingSchedule) []RecordingSchedule) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cfg.RecordingSchedules = update(m.cfg.RecordingSchedules)
+	if err := saveConfig(m.cfg, m.cfgPath); err != nil {
+		log.Printf("Warning: failed to update config file: %v", err)
+	}
+}
+
+func (m *serviceManager) updateRetention(p RetentionPolicy) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cfg.RecordingRetention = &p
+	if err := saveConfig(m.cfg, m.cfgPath); err != nil {
+		log.Printf("Warning: failed to update config file: %v", err)
+	}
+}
+
+// mapPort requests a router port forward for the server port and logs the
+// resulting external address.
+func mapPort(cfg *PortMappingConfig, port int) {
+	m, err := portmap.Map(cfg.Method, port, cfg.ExternalPort)
+	if err != nil {
+		log.Printf("Port mapping failed: %v", err)
+		return
+	}
+	st := m.Status()
+	log.Printf("Port mapping via %s active: reachable at http://%s", st.Method, st.ExternalAddr())
+
+	portMappingMux.Lock()
+	portMapping = m
+	portMappingMux.Unlock()
+}
+
+// unmapPort removes the router port forward, if one was made.
+func unmapPort() {
+	portMappingMux.Lock()
+	defer portMappingMux.Unlock()
+	if portMapping == nil {
+		return
+	}
+	if err := portMapping.Close(); err != nil {
+		log.Printf("Warning: failed to remove port mapping: %v", err)
+	}
+	portMapping = nil
+}
+
+// pipelineState is the JSON shape returned by the status endpoints.
+type pipelineState struct {
+	FFmpeg  ffmpeg.Status `json:"ffmpeg"`
+	Audio   audio.Status  `json:"audio"`
+	VNC     vnc.Status    `json:"vnc"`
+	Clients int           `json:"clients"`
+	// Ready is set once the main stream has a first frame for viewers.
+	Ready       bool            `json:"ready"`
+	PortMapping *portmap.Status `json:"port_mapping,omitempty"`
+	PausedSince *time.Time      `json:"paused_since,omitempty"`
+	// DisplayLost is when the main display's X server went away, while
+	// it is down.
+	DisplayLost *time.Time      `json:"display_lost,omitempty"`
+	Services    []serviceStatus `json:"services,omitempty"`
+}
+
+func (m *serviceManager) state() pipelineState {
+	st := pipelineState{
+		FFmpeg:  m.encoder.Status(),
+		Audio:   m.audio.Status(),
+		VNC:     m.vnc.Status(),
+		Clients: clientCount(),
+		Ready:   streamReady(""),
+
+		DisplayLost: displayLost(),
+	}
+	for _, s := range declared {
+		st.Services = append(st.Services, s.status())
+	}
+	pauseMux.Lock()
+	if !pausedAt.IsZero() {
+		since := pausedAt
+		st.PausedSince = &since
+	}
+	pauseMux.Unlock()
+	portMappingMux.Lock()
+	if portMapping != nil {
+		pm := portMapping.Status()
+		st.PortMapping = &pm
+	}
+	portMappingMux.Unlock()
+	return st
+}
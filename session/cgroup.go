@@ -0,0 +1,142 @@
+package session
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Limits caps the resources available to a session's whole process tree.
+type Limits struct {
+	CPU    string `json:"cpu,omitempty"`    // share of one core, e.g. "150%"
+	Memory string `json:"memory,omitempty"` // bytes with optional K/M/G suffix
+}
+
+func (l Limits) empty() bool {
+	return l.CPU == "" && l.Memory == ""
+}
+
+// cgroup is a cgroup v2 directory holding one session's processes.
+type cgroup struct {
+	path string
+	fd   int
+}
+
+const cpuPeriod = 100000
+
+func parseCPU(s string) (int, error) {
+	pct, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+	if err != nil || pct <= 0 {
+		return 0, fmt.Errorf("invalid cpu limit %q", s)
+	}
+	return int(pct / 100 * cpuPeriod), nil
+}
+
+func parseMemory(s string) (int64, error) {
+	mult := int64(1)
+	switch strings.ToUpper(s[len(s)-1:]) {
+	case "K":
+		mult = 1 << 10
+	case "M":
+		mult = 1 << 20
+	case "G":
+		mult = 1 << 30
+	}
+	if mult > 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid memory limit %q", s)
+	}
+	return n * mult, nil
+}
+
+// newCgroup creates a child cgroup named name under root with the given
+// limits. root must be a delegated cgroup v2 directory writable by remoter,
+// e.g. one created by a systemd unit with Delegate=yes.
+func newCgroup(root, name string, l Limits) (*cgroup, error) {
+	var cpuMax string
+	if l.CPU != "" {
+		quota, err := parseCPU(l.CPU)
+		if err != nil {
+			return nil, err
+		}
+		cpuMax = fmt.Sprintf("%d %d", quota, cpuPeriod)
+	}
+	var memMax string
+	if l.Memory != "" {
+		n, err := parseMemory(l.Memory)
+		if err != nil {
+			return nil, err
+		}
+		memMax = strconv.FormatInt(n, 10)
+	}
+
+	if err := os.WriteFile(filepath.Join(root, "cgroup.subtree_control"), []byte("+cpu +memory"), 0644); err != nil {
+		return nil, fmt.Errorf("failed to enable cpu/memory controllers in %s: %w", root, err)
+	}
+
+	path := filepath.Join(root, name)
+	if err := os.Mkdir(path, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create cgroup: %w", err)
+	}
+	cg := &cgroup{path: path, fd: -1}
+
+	if cpuMax != "" {
+		if err := os.WriteFile(filepath.Join(path, "cpu.max"), []byte(cpuMax), 0644); err != nil {
+			cg.remove()
+			return nil, fmt.Errorf("failed to set cpu limit: %w", err)
+		}
+	}
+	if memMax != "" {
+		if err := os.WriteFile(filepath.Join(path, "memory.max"), []byte(memMax), 0644); err != nil {
+			cg.remove()
+			return nil, fmt.Errorf("failed to set memory limit: %w", err)
+		}
+	}
+
+	fd, err := syscall.Open(path, syscall.O_RDONLY|syscall.O_DIRECTORY, 0)
+	if err != nil {
+		cg.remove()
+		return nil, fmt.Errorf("failed to open cgroup: %w", err)
+	}
+	cg.fd = fd
+	return cg, nil
+}
+
+// apply makes cmd start directly inside the cgroup, so nothing it forks
+// can escape the limits.
+func (cg *cgroup) apply(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = cg.fd
+}
+
+// remove kills anything left in the cgroup, including processes that
+// detached from the session's process tree, and deletes it.
+func (cg *cgroup) remove() {
+	if cg.fd >= 0 {
+		syscall.Close(cg.fd)
+		cg.fd = -1
+	}
+	_ = os.WriteFile(filepath.Join(cg.path, "cgroup.kill"), []byte("1"), 0644)
+
+	// cgroup.kill is asynchronous; the directory stays busy until the
+	// last process is gone.
+	var err error
+	for i := 0; i < 20; i++ {
+		if err = os.Remove(cg.path); err == nil || os.IsNotExist(err) {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	fmt.Printf("Warning: failed to remove cgroup %s: %v\n", cg.path, err)
+}
@@ -0,0 +1,102 @@
+package session
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// GPU selects hardware-accelerated OpenGL for a session instead of the
+// llvmpipe software renderer Xvfb provides.
+//
+//   - "virtualgl" keeps Xvfb but runs the desktop under vglrun, so every
+//     application it launches renders on Device (an X display such as ":0"
+//     or an EGL device such as "/dev/dri/card0") and reads back into Xvfb.
+//   - "xorg" replaces Xvfb with a headless Xorg server on the GPU at BusID
+//     (e.g. "PCI:1:0:0") using Driver (default "nvidia"). This usually
+//     requires remoter to run as root or Xorg to be setuid.
+type GPU struct {
+	Mode   string `json:"mode,omitempty"`
+	Device string `json:"device,omitempty"`
+	BusID  string `json:"bus_id,omitempty"`
+	Driver string `json:"driver,omitempty"`
+}
+
+func (g GPU) validate() error {
+	switch g.Mode {
+	case "", "virtualgl":
+		return nil
+	case "xorg":
+		if g.BusID == "" {
+			return fmt.Errorf("gpu mode xorg requires bus_id")
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown gpu mode %q", g.Mode)
+}
+
+// desktopCommand returns the command that runs the desktop shell command,
+// wrapped in vglrun when VirtualGL is enabled.
+func (g GPU) desktopCommand(desktop string) *exec.Cmd {
+	if g.Mode != "virtualgl" {
+		return exec.Command("sh", "-c", desktop)
+	}
+	args := []string{}
+	if g.Device != "" {
+		args = append(args, "-d", g.Device)
+	}
+	args = append(args, "sh", "-c", desktop)
+	return exec.Command("vglrun", args...)
+}
+
+// xorgConfig renders a headless Xorg configuration with a virtual screen
+// of the session's resolution.
+func (g GPU) xorgConfig(res string) string {
+	width, height, depth := "1920", "1080", "24"
+	if parts := strings.Split(res, "x"); len(parts) >= 2 {
+		width, height = parts[0], parts[1]
+		if len(parts) >= 3 {
+			depth = parts[2]
+		}
+	}
+	driver := g.Driver
+	if driver == "" {
+		driver = "nvidia"
+	}
+
+	return fmt.Sprintf(`Section "ServerLayout"
+    Identifier "remoter"
+    Screen 0 "Screen0"
+EndSection
+
+Section "Device"
+    Identifier "Device0"
+    Driver "%s"
+    BusID "%s"
+    Option "AllowEmptyInitialConfiguration" "true"
+EndSection
+
+Section "Screen"
+    Identifier "Screen0"
+    Device "Device0"
+    DefaultDepth %s
+    SubSection "Display"
+        Depth %s
+        Virtual %s %s
+    EndSubSection
+EndSection
+`, driver, g.BusID, depth, depth, width, height)
+}
+
+// serverCommand returns the X server command for the session: Xvfb, or a
+// headless Xorg whose configuration is written to configPath.
+func (g GPU) serverCommand(display, res, configPath string) (*exec.Cmd, error) {
+	if g.Mode != "xorg" {
+		return exec.Command("Xvfb", display, "-screen", "0", res), nil
+	}
+	if err := os.WriteFile(configPath, []byte(g.xorgConfig(res)), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write Xorg config: %w", err)
+	}
+	return exec.Command("Xorg", display, "-config", configPath, "-noreset", "-nolisten", "tcp"), nil
+}
@@ -0,0 +1,81 @@
+package session
+
+import (
+	"fmt"
+	"net/url"
+	"os/exec"
+	"slices"
+	"strings"
+)
+
+// kioskBrowsers are the browsers a kiosk may run, tried in order when
+// none is named.
+var kioskBrowsers = []string{"chromium", "chromium-browser", "google-chrome", "firefox"}
+
+// shellQuote quotes s for use in a sh -c command line.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func digits(s string) bool {
+	return s != "" && strings.Trim(s, "0123456789") == ""
+}
+
+// KioskTemplate returns a template whose desktop is only a full-screen
+// browser showing pageURL, an http or https URL, for demoing web apps
+// and dashboards; browser is one of kioskBrowsers. Each session gets a
+// throwaway browser profile.
+func KioskTemplate(pageURL, res, browser string) (Template, error) {
+	// file:// would show the files of the user running remoter.
+	u, err := url.Parse(pageURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return Template{}, fmt.Errorf("invalid kiosk url %q; use an http or https url", pageURL)
+	}
+	if res == "" {
+		res = "1920x1080x24"
+	}
+	// The width and height go into the browser's command line.
+	parts := strings.Split(res, "x")
+	if len(parts) < 2 || !digits(parts[0]) || !digits(parts[1]) {
+		return Template{}, fmt.Errorf("invalid resolution %q", res)
+	}
+	if len(parts) == 2 {
+		res += "x24"
+	}
+
+	if browser != "" {
+		if !slices.Contains(kioskBrowsers, browser) {
+			return Template{}, fmt.Errorf("unknown browser %q; use one of %s", browser, strings.Join(kioskBrowsers, ", "))
+		}
+		if _, err := exec.LookPath(browser); err != nil {
+			return Template{}, fmt.Errorf("browser %s is not installed", browser)
+		}
+	} else {
+		for _, b := range kioskBrowsers {
+			if _, err := exec.LookPath(b); err == nil {
+				browser = b
+				break
+			}
+		}
+		if browser == "" {
+			return Template{}, fmt.Errorf("no browser found; install one of %s", strings.Join(kioskBrowsers, ", "))
+		}
+	}
+
+	// In the session's TMPDIR, so the profile goes with the session.
+	profile := `"$(mktemp -d "${TMPDIR:-/tmp}/remoter-kiosk-XXXXXX")"`
+	var desktop string
+	if strings.Contains(browser, "firefox") {
+		desktop = fmt.Sprintf("exec %s --kiosk --no-remote --profile %s --width %s --height %s %s",
+			shellQuote(browser), profile, parts[0], parts[1], shellQuote(pageURL))
+	} else {
+		desktop = fmt.Sprintf("exec %s --kiosk --no-first-run --noerrdialogs --disable-infobars --disable-session-crashed-bubble --window-position=0,0 --window-size=%s,%s --user-data-dir=%s %s",
+			shellQuote(browser), parts[0], parts[1], profile, shellQuote(pageURL))
+	}
+
+	return Template{
+		Title:   u.Host,
+		Res:     res,
+		Desktop: desktop,
+	}, nil
+}
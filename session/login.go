@@ -0,0 +1,43 @@
+package session
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// userSession is a PAM login for a local account that a virtual desktop
+// runs under. PAM's session modules (pam_systemd in particular) register
+// it with logind and provide XDG_RUNTIME_DIR and friends in env.
+type userSession struct {
+	username string
+	home     string
+	cred     *syscall.Credential
+	env      []string
+	end      func()
+}
+
+// apply makes cmd run as the logged-in user with the PAM environment.
+func (u *userSession) apply(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Credential = u.cred
+	cmd.Dir = u.home
+
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	env = append(env, "USER="+u.username, "LOGNAME="+u.username, "HOME="+u.home)
+	cmd.Env = append(env, u.env...)
+}
+
+// close ends the PAM session, which also lets logind clean up the
+// user's runtime directory once their last session is gone.
+func (u *userSession) close() {
+	if u.end != nil {
+		u.end()
+		u.end = nil
+	}
+}
@@ -0,0 +1,92 @@
+//go:build pam
+
+package session
+
+import (
+	"errors"
+	"fmt"
+	"os/user"
+	"strconv"
+	"syscall"
+
+	"github.com/msteinert/pam/v2"
+)
+
+// openUserSession authenticates username against the PAM service and
+// opens a session for display, returning what is needed to run the
+// desktop as that user.
+func openUserSession(service, username, password, display string) (*userSession, error) {
+	usr, err := user.Lookup(username)
+	if err != nil {
+		return nil, fmt.Errorf("unknown user %q: %w", username, err)
+	}
+	uid, _ := strconv.ParseUint(usr.Uid, 10, 32)
+	gid, _ := strconv.ParseUint(usr.Gid, 10, 32)
+	var groups []uint32
+	if ids, err := usr.GroupIds(); err == nil {
+		for _, id := range ids {
+			if g, err := strconv.ParseUint(id, 10, 32); err == nil {
+				groups = append(groups, uint32(g))
+			}
+		}
+	}
+
+	tx, err := pam.StartFunc(service, username, func(s pam.Style, msg string) (string, error) {
+		switch s {
+		case pam.PromptEchoOff:
+			return password, nil
+		case pam.PromptEchoOn:
+			return username, nil
+		case pam.ErrorMsg, pam.TextInfo:
+			return "", nil
+		}
+		return "", errors.New("unsupported PAM conversation style")
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to start PAM transaction: %w", err)
+	}
+
+	fail := func(step string, err error) (*userSession, error) {
+		tx.End()
+		return nil, fmt.Errorf("PAM %s failed for %q: %w", step, username, err)
+	}
+	if err := tx.Authenticate(0); err != nil {
+		return fail("authentication", err)
+	}
+	if err := tx.AcctMgmt(0); err != nil {
+		return fail("account check", err)
+	}
+
+	// pam_systemd treats a PAM_TTY that looks like an X display as a
+	// graphical seatless session.
+	_ = tx.SetItem(pam.Tty, display)
+	_ = tx.PutEnv("XDG_SESSION_TYPE=x11")
+	_ = tx.PutEnv("XDG_SESSION_CLASS=user")
+
+	if err := tx.SetCred(pam.EstablishCred); err != nil {
+		return fail("credential setup", err)
+	}
+	if err := tx.OpenSession(0); err != nil {
+		tx.SetCred(pam.DeleteCred)
+		return fail("session open", err)
+	}
+
+	var env []string
+	if vars, err := tx.GetEnvList(); err == nil {
+		for k, v := range vars {
+			env = append(env, k+"="+v)
+		}
+	}
+
+	return &userSession{
+		username: username,
+		home:     usr.HomeDir,
+		cred:     &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid), Groups: groups},
+		env:      env,
+		end: func() {
+			tx.CloseSession(0)
+			tx.SetCred(pam.DeleteCred)
+			tx.End()
+		},
+	}, nil
+}
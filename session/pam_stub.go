@@ -0,0 +1,9 @@
+//go:build !pam
+
+package session
+
+import "fmt"
+
+func openUserSession(service, username, password, display string) (*userSession, error) {
+	return nil, fmt.Errorf("this build does not include PAM support; rebuild with -tags pam")
+}
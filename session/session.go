@@ -0,0 +1,542 @@
+package session
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/nathfavour/remoter/proc"
+)
+
+// Template describes a reproducible virtual desktop that can be
+// instantiated on demand. Desktop is a shell command that must stay in the
+// foreground for the life of the session; the session is torn down when it
+// exits.
+type Template struct {
+	// Title and Description label the session's stream; both can be
+	// overridden per session.
+	Title        string   `json:"title,omitempty"`
+	Description  string   `json:"description,omitempty"`
+	Res          string   `json:"res"`
+	Desktop      string   `json:"desktop"`
+	Lifetime     string   `json:"lifetime,omitempty"`
+	IdleTimeout  string   `json:"idle_timeout,omitempty"`
+	IdlePolicy   string   `json:"idle_policy,omitempty"` // "terminate" or "keep"
+	AllowedUsers []string `json:"allowed_users,omitempty"`
+	Limits       Limits   `json:"limits,omitempty"`
+	GPU          GPU      `json:"gpu,omitempty"`
+	// Backend is "x11" (Xvfb, the default) or "wayland", which runs a
+	// headless Compositor ("sway" or "cage") instead.
+	Backend    string `json:"backend,omitempty"`
+	Compositor string `json:"compositor,omitempty"`
+	// Login requires the requesting user to authenticate against PAM, and
+	// runs the session's processes as that user.
+	Login bool `json:"login,omitempty"`
+}
+
+// Config configures a Manager.
+type Config struct {
+	Templates map[string]Template
+	// CgroupRoot is a delegated cgroup v2 directory under which per-session
+	// cgroups are created. Required for templates that set Limits.
+	CgroupRoot string
+	// PAMService is the /etc/pam.d service used for Login templates.
+	PAMService string
+}
+
+// Info is a snapshot of a running session. Display is the X display for
+// x11 sessions and the socket name inside RuntimeDir for wayland ones.
+type Info struct {
+	ID          string    `json:"id"`
+	Template    string    `json:"template"`
+	Title       string    `json:"title,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Backend     string    `json:"backend"`
+	Display     string    `json:"display"`
+	RuntimeDir  string    `json:"runtime_dir,omitempty"`
+	Res         string    `json:"res"`
+	User        string    `json:"user,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	ExpiresAt   time.Time `json:"expires_at,omitempty"`
+}
+
+type session struct {
+	Info
+	template    Template
+	idleTimeout time.Duration
+	procs       proc.Group
+	xorgConfig  string // headless Xorg config written for GPU sessions
+	tmpDir      string // the session's TMPDIR, removed with it
+	xvfb        *proc.Proc
+	desktop     *proc.Proc
+	cgroup      *cgroup
+	login       *userSession
+}
+
+// Manager creates and tracks virtual desktop sessions.
+type Manager struct {
+	mu         sync.Mutex
+	templates  map[string]Template
+	cgroupRoot string
+	pamService string
+	sessions   map[string]*session
+	starting   map[string]bool // displays of sessions still starting
+}
+
+// firstDisplay is the lowest X display number handed out to sessions,
+// leaving the usual :0/:1 to the physical and VNC displays.
+const firstDisplay = 10
+
+func NewManager(cfg Config) *Manager {
+	templates := cfg.Templates
+	if templates == nil {
+		templates = make(map[string]Template)
+	}
+	pamService := cfg.PAMService
+	if pamService == "" {
+		pamService = "login"
+	}
+	return &Manager{
+		templates:  templates,
+		cgroupRoot: cfg.CgroupRoot,
+		pamService: pamService,
+		sessions:   make(map[string]*session),
+		starting:   make(map[string]bool),
+	}
+}
+
+// Templates returns the configured templates by name.
+func (m *Manager) Templates() map[string]Template {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make(map[string]Template, len(m.templates))
+	for name, t := range m.templates {
+		out[name] = t
+	}
+	return out
+}
+
+func newID() string {
+	b := make([]byte, 6)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// displayInUse reports whether an X server already owns display n. Lock
+// files left behind by a server that no longer exists are removed.
+func displayInUse(n int) bool {
+	lock := fmt.Sprintf("/tmp/.X%d-lock", n)
+	sock := fmt.Sprintf("/tmp/.X11-unix/X%d", n)
+
+	data, err := os.ReadFile(lock)
+	if err != nil {
+		_, err := os.Stat(sock)
+		return err == nil
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err == nil && syscall.Kill(pid, 0) == syscall.ESRCH {
+		fmt.Printf("Removing stale lock for display :%d (pid %d)\n", n, pid)
+		os.Remove(lock)
+		os.Remove(sock)
+		return false
+	}
+	return true
+}
+
+func (m *Manager) allocDisplay() string {
+	used := maps.Clone(m.starting)
+	for _, s := range m.sessions {
+		used[s.Display] = true
+	}
+	for n := firstDisplay; ; n++ {
+		d := fmt.Sprintf(":%d", n)
+		if !used[d] && !displayInUse(n) {
+			return d
+		}
+	}
+}
+
+// waitForDisplay blocks until the X socket for display appears.
+func waitForDisplay(display string, timeout time.Duration) error {
+	sock := "/tmp/.X11-unix/X" + display[1:]
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if _, err := os.Stat(sock); err == nil {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return fmt.Errorf("display %s did not come up within %s", display, timeout)
+}
+
+// Create instantiates the named template on a fresh display on behalf of
+// user. password is only used by templates that require a PAM login.
+func (m *Manager) Create(templateName, user, password string) (Info, error) {
+	m.mu.Lock()
+	t, ok := m.templates[templateName]
+	m.mu.Unlock()
+	if !ok {
+		return Info{}, fmt.Errorf("unknown template %q", templateName)
+	}
+	return m.CreateFrom(templateName, t, user, password)
+}
+
+// CreateFrom starts a session from a template that need not be configured,
+// such as a preset; templateName is only used to label it. m.mu is only
+// held to reserve a display and to add the session, not through the PAM
+// login and the wait for the X server.
+func (m *Manager) CreateFrom(templateName string, t Template, user, password string) (Info, error) {
+	if len(t.AllowedUsers) > 0 && !slices.Contains(t.AllowedUsers, user) {
+		return Info{}, fmt.Errorf("user %q is not allowed to use template %q", user, templateName)
+	}
+
+	if err := t.GPU.validate(); err != nil {
+		return Info{}, fmt.Errorf("invalid gpu settings in template %q: %w", templateName, err)
+	}
+	backend := t.Backend
+	switch backend {
+	case "":
+		backend = "x11"
+	case "x11":
+	case "wayland":
+		if t.GPU.Mode != "" {
+			return Info{}, fmt.Errorf("template %q: gpu settings only apply to x11 sessions", templateName)
+		}
+	default:
+		return Info{}, fmt.Errorf("template %q: unknown backend %q", templateName, backend)
+	}
+	if !t.Limits.empty() && m.cgroupRoot == "" {
+		return Info{}, fmt.Errorf("template %q sets resource limits but no cgroup_root is configured", templateName)
+	}
+
+	var lifetime, idleTimeout time.Duration
+	if t.Lifetime != "" {
+		d, err := time.ParseDuration(t.Lifetime)
+		if err != nil {
+			return Info{}, fmt.Errorf("invalid lifetime in template %q: %w", templateName, err)
+		}
+		lifetime = d
+	}
+	if t.IdleTimeout != "" {
+		d, err := time.ParseDuration(t.IdleTimeout)
+		if err != nil {
+			return Info{}, fmt.Errorf("invalid idle_timeout in template %q: %w", templateName, err)
+		}
+		idleTimeout = d
+	}
+
+	res := t.Res
+	if res == "" {
+		res = "1920x1080x24"
+	}
+	desktop := t.Desktop
+	if desktop == "" {
+		desktop = "xterm"
+	}
+
+	s := &session{
+		Info: Info{
+			ID:          newID(),
+			Template:    templateName,
+			Title:       t.Title,
+			Description: t.Description,
+			Backend:     backend,
+			Res:         res,
+			User:        user,
+			CreatedAt:   time.Now(),
+		},
+		template:    t,
+		idleTimeout: idleTimeout,
+	}
+	if lifetime > 0 {
+		s.ExpiresAt = s.CreatedAt.Add(lifetime)
+	}
+	if backend == "x11" {
+		m.mu.Lock()
+		s.Display = m.allocDisplay()
+		m.starting[s.Display] = true
+		m.mu.Unlock()
+		defer func() {
+			m.mu.Lock()
+			delete(m.starting, s.Display)
+			m.mu.Unlock()
+		}()
+	}
+
+	if t.Login {
+		login, err := openUserSession(m.pamService, user, password, s.Display)
+		if err != nil {
+			return Info{}, err
+		}
+		s.login = login
+	}
+
+	if err := s.makeTempDir(); err != nil {
+		s.kill()
+		return Info{}, err
+	}
+
+	if !t.Limits.empty() {
+		cg, err := newCgroup(m.cgroupRoot, "session-"+s.ID, t.Limits)
+		if err != nil {
+			s.kill()
+			return Info{}, err
+		}
+		s.cgroup = cg
+	}
+
+	start := s.startX11
+	if backend == "wayland" {
+		start = s.startWayland
+	}
+	if err := start(desktop); err != nil {
+		s.kill()
+		return Info{}, err
+	}
+
+	m.mu.Lock()
+	m.sessions[s.ID] = s
+	m.mu.Unlock()
+	return s.Info, nil
+}
+
+// startX11 runs the session's X server and then its desktop on it.
+func (s *session) startX11(desktop string) error {
+	t := s.template
+	fmt.Printf("Starting X server for session %s on %s...\n", s.ID, s.Display)
+	if t.GPU.Mode == "xorg" {
+		s.xorgConfig = filepath.Join(s.tmpDir, "xorg.conf")
+	}
+	xvfbCmd, err := t.GPU.serverCommand(s.Display, s.Res, s.xorgConfig)
+	if err != nil {
+		return err
+	}
+	s.prepare(xvfbCmd)
+	xvfb, err := s.procs.Spawn(xvfbCmd)
+	if err != nil {
+		return fmt.Errorf("failed to start X server: %w", err)
+	}
+	s.xvfb = xvfb
+	if err := waitForDisplay(s.Display, 5*time.Second); err != nil {
+		return err
+	}
+
+	fmt.Printf("Starting desktop for session %s: %s\n", s.ID, desktop)
+	cmd := t.GPU.desktopCommand(desktop)
+	cmd.Env = append(os.Environ(), "DISPLAY="+s.Display)
+	s.prepare(cmd)
+	desktopProc, err := s.procs.Spawn(cmd)
+	if err != nil {
+		return fmt.Errorf("failed to start desktop: %w", err)
+	}
+	s.desktop = desktopProc
+	return nil
+}
+
+// makeTempDir creates the session's private temporary directory, owned
+// by the logged-in user when there is one.
+func (s *session) makeTempDir() error {
+	dir, err := os.MkdirTemp("", "remoter-tmp-"+s.ID+"-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary dir: %w", err)
+	}
+	s.tmpDir = dir
+	if s.login != nil {
+		if err := os.Chown(dir, int(s.login.cred.Uid), int(s.login.cred.Gid)); err != nil {
+			return fmt.Errorf("failed to hand temporary dir to %s: %w", s.login.username, err)
+		}
+	}
+	return nil
+}
+
+// prepare places cmd in the session's cgroup, runs it as the logged-in
+// user, when those are configured, and points its TMPDIR at the
+// session's own.
+func (s *session) prepare(cmd *exec.Cmd) {
+	if s.login != nil {
+		s.login.apply(cmd)
+	}
+	if s.tmpDir != "" {
+		if cmd.Env == nil {
+			cmd.Env = os.Environ()
+		}
+		cmd.Env = append(cmd.Env, "TMPDIR="+s.tmpDir)
+	}
+	if s.cgroup != nil {
+		s.cgroup.apply(cmd)
+	}
+}
+
+// kill terminates the session's processes, newest first, and removes its
+// cgroup along with anything still running inside it, and its temporary
+// files.
+func (s *session) kill() {
+	s.procs.Terminate(3 * time.Second)
+	if s.cgroup != nil {
+		s.cgroup.remove()
+		s.cgroup = nil
+	}
+	if s.tmpDir != "" {
+		os.RemoveAll(s.tmpDir)
+	}
+	if s.RuntimeDir != "" {
+		os.RemoveAll(s.RuntimeDir)
+	}
+	if s.login != nil {
+		s.login.close()
+		s.login = nil
+	}
+}
+
+// idleTime returns how long the session's display has seen no input,
+// using xprintidle against the session's X server.
+func (s *session) idleTime() (time.Duration, error) {
+	cmd := exec.Command("xprintidle")
+	cmd.Env = append(os.Environ(), "DISPLAY="+s.Display)
+	out, err := cmd.Output()
+	if err != nil {
+		return 0, fmt.Errorf("failed to run xprintidle: %w", err)
+	}
+	ms, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse xprintidle output: %w", err)
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+// reapReason returns why the session should be torn down, or "" if it
+// should be kept.
+func (s *session) reapReason(now time.Time) string {
+	switch {
+	case s.xvfb != nil && s.xvfb.Exited():
+		return "X server exited"
+	case s.desktop != nil && s.desktop.Exited():
+		return "desktop exited"
+	case !s.ExpiresAt.IsZero() && now.After(s.ExpiresAt):
+		return "lifetime exceeded"
+	}
+
+	// xprintidle needs an X server; wayland sessions only honour lifetime.
+	if s.idleTimeout > 0 && s.template.IdlePolicy != "keep" && s.Backend == "x11" {
+		idle, err := s.idleTime()
+		if err != nil {
+			fmt.Printf("Warning: cannot check idle time of session %s: %v\n", s.ID, err)
+		} else if idle > s.idleTimeout {
+			return fmt.Sprintf("idle for %s", idle.Round(time.Second))
+		}
+	}
+	return ""
+}
+
+// Reap tears down sessions that outlived their lifetime, sat idle past
+// their idle timeout, or lost their X server or desktop. xprintidle and
+// the teardown run without m.mu held, so they don't stall the API. It
+// returns why each was torn down, by id.
+func (m *Manager) Reap() map[string]string {
+	m.mu.Lock()
+	all := make([]*session, 0, len(m.sessions))
+	for _, s := range m.sessions {
+		all = append(all, s)
+	}
+	m.mu.Unlock()
+
+	now := time.Now()
+	reaped := make(map[string]string)
+	for _, s := range all {
+		reason := s.reapReason(now)
+		if reason == "" {
+			continue
+		}
+		m.mu.Lock()
+		current := m.sessions[s.ID] == s
+		if current {
+			delete(m.sessions, s.ID)
+		}
+		m.mu.Unlock()
+		if !current {
+			continue // destroyed meanwhile
+		}
+		fmt.Printf("Reaping session %s on %s: %s\n", s.ID, s.Display, reason)
+		s.kill()
+		reaped[s.ID] = reason
+	}
+	return reaped
+}
+
+// List returns all sessions ordered by creation time.
+func (m *Manager) List() []Info {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	infos := make([]Info, 0, len(m.sessions))
+	for _, s := range m.sessions {
+		infos = append(infos, s.Info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].CreatedAt.Before(infos[j].CreatedAt)
+	})
+	return infos
+}
+
+// Get returns the session with the given id.
+func (m *Manager) Get(id string) (Info, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return Info{}, false
+	}
+	return s.Info, true
+}
+
+// SetMeta changes the session's title and description; nil leaves a
+// field as it is.
+func (m *Manager) SetMeta(id string, title, description *string) (Info, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return Info{}, fmt.Errorf("no session with id %q", id)
+	}
+	if title != nil {
+		s.Title = *title
+	}
+	if description != nil {
+		s.Description = *description
+	}
+	return s.Info, nil
+}
+
+// Destroy tears down the session with the given id.
+func (m *Manager) Destroy(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return fmt.Errorf("no session with id %q", id)
+	}
+	fmt.Printf("Destroying session %s on %s...\n", s.ID, s.Display)
+	s.kill()
+	delete(m.sessions, id)
+	return nil
+}
+
+// DestroyAll tears down every session, used on shutdown.
+func (m *Manager) DestroyAll() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, s := range m.sessions {
+		s.kill()
+		delete(m.sessions, id)
+	}
+}
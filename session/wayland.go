@@ -0,0 +1,83 @@
+package session
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// startWayland runs a headless wlroots compositor (sway or cage) for the
+// session. Each session gets its own XDG_RUNTIME_DIR so its socket is
+// always wayland-1 there; the compositor doubles as the session's desktop
+// process, exiting when the desktop does.
+func (s *session) startWayland(desktop string) error {
+	dir, err := os.MkdirTemp("", "remoter-session-"+s.ID+"-")
+	if err != nil {
+		return fmt.Errorf("failed to create runtime dir: %w", err)
+	}
+	s.RuntimeDir = dir
+	s.Display = "wayland-1"
+	if s.login != nil {
+		if err := os.Chown(dir, int(s.login.cred.Uid), int(s.login.cred.Gid)); err != nil {
+			return fmt.Errorf("failed to hand runtime dir to %s: %w", s.login.username, err)
+		}
+	}
+
+	width, height := "1920", "1080"
+	if parts := strings.Split(s.Res, "x"); len(parts) >= 2 {
+		width, height = parts[0], parts[1]
+	}
+
+	var cmd *exec.Cmd
+	switch s.template.Compositor {
+	case "", "sway":
+		conf := filepath.Join(dir, "sway.conf")
+		script := fmt.Sprintf("output HEADLESS-1 resolution %sx%s\nexec %s\n", width, height, desktop)
+		if err := os.WriteFile(conf, []byte(script), 0644); err != nil {
+			return fmt.Errorf("failed to write sway config: %w", err)
+		}
+		cmd = exec.Command("sway", "-c", conf)
+	case "cage":
+		cmd = exec.Command("cage", "--", "sh", "-c", desktop)
+	default:
+		return fmt.Errorf("unknown compositor %q", s.template.Compositor)
+	}
+
+	cmd.Env = append(os.Environ(), "WLR_BACKENDS=headless", "WLR_LIBINPUT_NO_DEVICES=1")
+	s.prepare(cmd)
+	// The login environment carries the user's own runtime dir; the
+	// session's must win so the socket lands where capture looks for it.
+	cmd.Env = append(cmd.Env, "XDG_RUNTIME_DIR="+dir)
+
+	fmt.Printf("Starting %s compositor for session %s...\n", cmd.Path, s.ID)
+	p, err := s.procs.Spawn(cmd)
+	if err != nil {
+		return fmt.Errorf("failed to start compositor: %w", err)
+	}
+	s.desktop = p
+
+	sock := filepath.Join(dir, s.Display)
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if _, err := os.Stat(sock); err == nil {
+			break
+		}
+		if p.Exited() || time.Now().After(deadline) {
+			return fmt.Errorf("compositor did not create %s", sock)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if s.template.Compositor == "cage" {
+		// cage has no config file; size its headless output afterwards.
+		randr := exec.Command("wlr-randr", "--output", "HEADLESS-1", "--custom-mode", width+"x"+height)
+		randr.Env = append(os.Environ(), "XDG_RUNTIME_DIR="+dir, "WAYLAND_DISPLAY="+s.Display)
+		if err := randr.Run(); err != nil {
+			fmt.Printf("Warning: failed to set resolution of session %s: %v\n", s.ID, err)
+		}
+	}
+	return nil
+}
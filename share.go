@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// shareSecret signs share links and login cookies; set from the config
+// in main.
+var shareSecret []byte
+
+const shareCookie = "remoter_share"
+
+// shareGrant is what a share link carries: which session it opens ("" for
+// the main display), with which role, until when. Invite links also name
+// their single-use invite; Bound marks the cookie issued when one is
+// redeemed, which keeps working for that browser only. User is set on
+// grants handed over from a logged in viewer.
+type shareGrant struct {
+	Session string `json:"s"`
+	Role    string `json:"r"`
+	Expires int64  `json:"e"`
+	Invite  string `json:"i,omitempty"`
+	Bound   bool   `json:"b,omitempty"`
+	User    string `json:"u,omitempty"`
+}
+
+// authInfo is who a request was authenticated as. Session is set when a
+// share link restricts it to one session, Invite when it came through an
+// invite.
+type authInfo struct {
+	User    string
+	Role    string
+	Session string
+	Invite  string
+	Key     string // the paired key that signed the request
+}
+
+type authContextKey struct{}
+
+func newShareSecret() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Token purposes, signed into every token so one kind is never accepted
+// as another.
+const (
+	tokenShare     = "share"
+	tokenLogin     = "login"
+	tokenOIDCState = "oidc-state"
+)
+
+// signToken encodes v as a URL-safe token for purpose, authenticated with
+// shareSecret.
+func signToken(purpose string, v any) string {
+	payload, _ := json.Marshal(v)
+	p := base64.RawURLEncoding.EncodeToString(payload)
+	return p + "." + base64.RawURLEncoding.EncodeToString(tokenMAC(purpose, p))
+}
+
+func tokenMAC(purpose, payload string) []byte {
+	mac := hmac.New(sha256.New, shareSecret)
+	mac.Write([]byte(purpose + "\x00" + payload))
+	return mac.Sum(nil)
+}
+
+// verifyToken checks a token signToken made for purpose and decodes it
+// into v.
+func verifyToken(purpose, token string, v any) error {
+	p, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return fmt.Errorf("malformed token")
+	}
+	got, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return fmt.Errorf("malformed token")
+	}
+	if !hmac.Equal(got, tokenMAC(purpose, p)) {
+		return fmt.Errorf("invalid token signature")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(p)
+	if err != nil {
+		return fmt.Errorf("malformed token")
+	}
+	if err := json.Unmarshal(payload, v); err != nil {
+		return fmt.Errorf("malformed token")
+	}
+	return nil
+}
+
+func verifyShare(token string) (shareGrant, error) {
+	var g shareGrant
+	if err := verifyToken(tokenShare, token, &g); err != nil {
+		return shareGrant{}, err
+	}
+	if time.Now().Unix() > g.Expires {
+		return shareGrant{}, fmt.Errorf("share token expired")
+	}
+	if g.Role != "view" && g.Role != "control" {
+		return shareGrant{}, fmt.Errorf("share token has role %q", g.Role)
+	}
+	return g, nil
+}
+
+// shareViewerPaths are the main display's viewer endpoints, which a
+// grant for it opens.
+var shareViewerPaths = []string{"/ws", "/wt", "/live", "/meta", "/a11y", "/cursor", "/audio", "/mjpeg", "/thumbnail", "/delta", "/chat", "/control"}
+
+// sharePermits reports whether a grant for session covers r: the
+// session's own endpoints, or the main display's for a grant of it, plus
+// handovers and the UI's static assets. Anything else, routes added later
+// included, stays closed.
+func sharePermits(session string, r *http.Request) bool {
+	path := r.URL.Path
+	if strings.HasPrefix(path, "/s/") {
+		return session != "" && strings.HasPrefix(path, "/s/"+session+"/")
+	}
+	if session == "" && slices.Contains(shareViewerPaths, path) {
+		return true
+	}
+	if path == "/placeholder" || path == "/handover" || strings.HasPrefix(path, "/handover/") {
+		return true
+	}
+	// Static assets are whatever only the web app's file server matches.
+	_, pattern := http.DefaultServeMux.Handler(r)
+	return pattern == "/"
+}
+
+// shareAccess checks the request for a share token, from ?share= or the
+// cookie set when a link is first opened, that covers its path.
+func shareAccess(w http.ResponseWriter, r *http.Request) (shareGrant, bool) {
+	if token := r.URL.Query().Get("share"); token != "" {
+		if g, ok := redeemShare(w, r, token); ok {
+			return g, true
+		}
+	}
+	c, err := r.Cookie(shareCookie)
+	if err != nil {
+		return shareGrant{}, false
+	}
+	g, err := verifyShare(c.Value)
+	if err != nil || !sharePermits(g.Session, r) {
+		return shareGrant{}, false
+	}
+	if g.Invite != "" && (!g.Bound || !invites.active(g.Invite)) {
+		return shareGrant{}, false
+	}
+	return g, true
+}
+
+// redeemShare accepts a link's token, using up invites, and sets the
+// cookie that asset requests the page makes afterwards carry.
+func redeemShare(w http.ResponseWriter, r *http.Request, token string) (shareGrant, bool) {
+	g, err := verifyShare(token)
+	if err != nil || g.Bound || !sharePermits(g.Session, r) {
+		return shareGrant{}, false
+	}
+	if g.Invite != "" {
+		if !invites.redeem(g.Invite, r.RemoteAddr) {
+			return shareGrant{}, false
+		}
+		g.Bound = true
+		token = signToken(tokenShare, g)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     shareCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  time.Unix(g.Expires, 0),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return g, true
+}
+
+func withAuth(r *http.Request, a authInfo) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), authContextKey{}, a))
+}
+
+// requestAuth returns who the request was authenticated as; the zero
+// value when authentication is off.
+func requestAuth(r *http.Request) authInfo {
+	a, _ := r.Context().Value(authContextKey{}).(authInfo)
+	return a
+}
+
+// handleShareSession issues a share link for a session.
+func handleShareSession(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if a := requestAuth(r); isolated(a) && !ownsSession(a.User, id) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no streaming session with id %q", id))
+		return
+	}
+	if !streamExists(id) || id == "" {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no streaming session with id %q", id))
+		return
+	}
+
+	req := struct {
+		Role string `json:"role"`
+		TTL  string `json:"ttl"`
+	}{Role: "view", TTL: "24h"}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+			return
+		}
+	}
+	if req.Role != "view" && req.Role != "control" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("role must be view or control"))
+		return
+	}
+	ttl, err := time.ParseDuration(req.TTL)
+	if err != nil || ttl <= 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid ttl %q", req.TTL))
+		return
+	}
+
+	g := shareGrant{Session: id, Role: req.Role, Expires: time.Now().Add(ttl).Unix()}
+	path := shareLink(g)
+	if g.Role == "control" {
+		emit(eventControlGranted, map[string]any{
+			"session":    id,
+			"by":         requestAuth(r).User,
+			"expires_at": time.Unix(g.Expires, 0),
+		})
+	}
+	writeJSON(w, http.StatusCreated, map[string]any{
+		"session":    id,
+		"role":       g.Role,
+		"path":       path,
+		"url":        absoluteURL(r, path),
+		"expires_at": time.Unix(g.Expires, 0),
+	})
+}
+
+// shareLink returns the viewer path that redeems g.
+func shareLink(g shareGrant) string {
+	if g.Session == "" {
+		return "/?share=" + signToken(tokenShare, g)
+	}
+	return fmt.Sprintf("/s/%s/view?share=%s", g.Session, signToken(tokenShare, g))
+}
+
+// absoluteURL resolves path against the host the request was made to.
+func absoluteURL(r *http.Request, path string) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + path
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/nathfavour/remoter/ffmpeg"
+	"github.com/nathfavour/remoter/showinput"
+)
+
+// ShowInputConfig draws click ripples and typed keys into the main stream,
+// for recording tutorials; needs python3 with python-xlib and an ffmpeg
+// with drawtext for keys.
+type ShowInputConfig struct {
+	Clicks bool `json:"clicks"`
+	Keys   bool `json:"keys"`
+	// SuppressHotkey, e.g. "ctrl+alt+h", hides keystrokes until it is
+	// pressed again, so passwords typed in between never show.
+	SuppressHotkey string `json:"suppress_hotkey,omitempty"`
+}
+
+// inputVisualizer is set while clicks or keys are drawn into the stream.
+var inputVisualizer *showinput.Visualizer
+
+func encoderOverlay() *ffmpeg.Overlay {
+	if inputVisualizer == nil {
+		return nil
+	}
+	return &ffmpeg.Overlay{Image: inputVisualizer.ImagePath(), Text: inputVisualizer.TextPath()}
+}
+
+func startInputVisualizer(cfg *ShowInputConfig, display string) error {
+	dir := filepath.Join(os.TempDir(), fmt.Sprintf("remoter-input-%d", os.Getpid()))
+	v := showinput.New(display, dir, showinput.Options{Clicks: cfg.Clicks, Keys: cfg.Keys})
+	if err := v.Start(); err != nil {
+		return err
+	}
+	inputVisualizer = v
+	onDisplayRestored(func() {
+		if err := v.Reconnect(); err != nil {
+			log.Printf("Warning: input visualization unavailable: %v", err)
+		}
+	})
+	if cfg.Keys && cfg.SuppressHotkey != "" {
+		err := grabHotkey(display, cfg.SuppressHotkey, func() {
+			if v.ToggleSuppressed() {
+				log.Printf("Keystroke display hidden")
+			} else {
+				log.Printf("Keystroke display shown")
+			}
+		})
+		if err != nil {
+			log.Printf("Warning: keystroke suppression hotkey unavailable: %v", err)
+		}
+	}
+	return nil
+}
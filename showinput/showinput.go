@@ -0,0 +1,361 @@
+package showinput
+
+import (
+	"bufio"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// helper reports clicks and key presses through python-xlib's RECORD
+// support.
+//
+//go:embed showinput.py
+var helper string
+
+const (
+	rippleDuration = 600 * time.Millisecond
+	// keysLinger is how long typed keys stay on screen after the last one.
+	keysLinger = 2 * time.Second
+	// maxKeysText bounds the keystroke line, dropping the oldest keys.
+	maxKeysText    = 40
+	renderInterval = 50 * time.Millisecond
+)
+
+// Options selects what is drawn.
+type Options struct {
+	Clicks bool
+	Keys   bool
+}
+
+type ripple struct {
+	x, y   int
+	button int
+	at     time.Time
+}
+
+type helperEvent struct {
+	Type   string   `json:"type"`
+	X      int      `json:"x"`
+	Y      int      `json:"y"`
+	W      int      `json:"w"`
+	H      int      `json:"h"`
+	Button int      `json:"button"`
+	Key    string   `json:"key"`
+	Mods   []string `json:"mods"`
+}
+
+// Visualizer follows the X display's input and keeps two files up to date
+// for ffmpeg to draw over the capture: a transparent PNG of the screen's
+// size with click ripples, and a line of recent keystrokes.
+type Visualizer struct {
+	display string
+	opts    Options
+	dir     string
+
+	mu         sync.Mutex
+	cmd        *exec.Cmd
+	w, h       int
+	ripples    []ripple
+	keys       []string
+	lastKey    time.Time
+	suppressed bool
+	done       chan struct{}
+}
+
+// New prepares a visualizer for display writing its files to dir.
+func New(display, dir string, opts Options) *Visualizer {
+	return &Visualizer{display: display, dir: dir, opts: opts, done: make(chan struct{})}
+}
+
+// ImagePath is the PNG with click ripples, when clicks are shown.
+func (v *Visualizer) ImagePath() string {
+	if !v.opts.Clicks {
+		return ""
+	}
+	return filepath.Join(v.dir, "clicks.png")
+}
+
+// TextPath is the file with recent keystrokes, when keys are shown.
+func (v *Visualizer) TextPath() string {
+	if !v.opts.Keys {
+		return ""
+	}
+	return filepath.Join(v.dir, "keys.txt")
+}
+
+// Start runs the helper and writes the initial, empty files, so ffmpeg
+// can open them as soon as Start returns.
+func (v *Visualizer) Start() error {
+	if err := os.MkdirAll(v.dir, 0700); err != nil {
+		return fmt.Errorf("failed to create overlay directory: %w", err)
+	}
+	if err := v.runHelper(); err != nil {
+		return err
+	}
+	if err := v.writeImage(time.Now()); err != nil {
+		v.kill()
+		return err
+	}
+	if err := v.writeText(""); err != nil {
+		v.kill()
+		return err
+	}
+	go v.renderLoop()
+	return nil
+}
+
+// Reconnect runs the helper anew, for an X server that restarted and
+// took the old one's connection with it.
+func (v *Visualizer) Reconnect() error {
+	v.kill()
+	if err := v.runHelper(); err != nil {
+		return err
+	}
+	return v.writeImage(time.Now())
+}
+
+func (v *Visualizer) runHelper() error {
+	cmd := exec.Command("python3", "-c", helper)
+	cmd.Env = append(os.Environ(), "DISPLAY="+v.display)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start input helper: %w", err)
+	}
+
+	sc := bufio.NewScanner(out)
+	// The helper reports the screen size first.
+	w, h := 1920, 1080
+	if sc.Scan() {
+		var ev helperEvent
+		if json.Unmarshal(sc.Bytes(), &ev) == nil && ev.Type == "screen" && ev.W > 0 && ev.H > 0 {
+			w, h = ev.W, ev.H
+		}
+	}
+	v.mu.Lock()
+	v.cmd, v.w, v.h = cmd, w, h
+	v.mu.Unlock()
+
+	go func() {
+		for sc.Scan() {
+			var ev helperEvent
+			if json.Unmarshal(sc.Bytes(), &ev) == nil {
+				v.handle(ev)
+			}
+		}
+		fmt.Printf("Input visualizer for %s exited: %v\n", v.display, cmd.Wait())
+	}()
+	return nil
+}
+
+func (v *Visualizer) kill() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.cmd != nil && v.cmd.Process != nil {
+		v.cmd.Process.Kill()
+	}
+}
+
+func (v *Visualizer) handle(ev helperEvent) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	switch ev.Type {
+	case "click":
+		if v.opts.Clicks {
+			v.ripples = append(v.ripples, ripple{x: ev.X, y: ev.Y, button: ev.Button, at: time.Now()})
+		}
+	case "key":
+		if v.opts.Keys && !v.suppressed {
+			v.addKey(ev.Key, ev.Mods)
+		}
+	}
+}
+
+// keyLabels spell out the keysym names of keys worth showing.
+var keyLabels = map[string]string{
+	"space": " ", "Return": " ⏎ ", "BackSpace": "⌫", "Tab": " ⇥ ", "Escape": " Esc ",
+	"Left": "←", "Right": "→", "Up": "↑", "Down": "↓", "Delete": " Del ",
+	"comma": ",", "period": ".", "slash": "/", "minus": "-", "equal": "=",
+	"semicolon": ";", "apostrophe": "'", "bracketleft": "[", "bracketright": "]",
+	"backslash": `\`, "grave": "`", "exclam": "!", "at": "@", "numbersign": "#",
+	"dollar": "$", "percent": "%", "asciicircum": "^", "ampersand": "&",
+	"asterisk": "*", "parenleft": "(", "parenright": ")", "underscore": "_",
+	"plus": "+", "colon": ":", "quotedbl": `"`, "less": "<", "greater": ">",
+	"question": "?", "braceleft": "{", "braceright": "}", "bar": "|",
+	"asciitilde": "~",
+}
+
+// addKey appends a key to the keystroke line: typed characters run
+// together, shortcuts stand apart.
+func (v *Visualizer) addKey(key string, mods []string) {
+	label, ok := keyLabels[key]
+	if !ok {
+		label = key
+		if len([]rune(key)) > 1 {
+			label = " " + key + " "
+		}
+	}
+	if len(mods) > 0 {
+		if len([]rune(key)) == 1 {
+			key = strings.ToUpper(key)
+		}
+		label = " " + strings.Join(append(mods, strings.TrimSpace(key)), "+") + " "
+	}
+	if time.Since(v.lastKey) > keysLinger {
+		v.keys = v.keys[:0]
+	}
+	v.keys = append(v.keys, label)
+	for len([]rune(strings.Join(v.keys, ""))) > maxKeysText && len(v.keys) > 1 {
+		v.keys = v.keys[1:]
+	}
+	v.lastKey = time.Now()
+}
+
+// ToggleSuppressed hides keystrokes until toggled again, e.g. while a
+// password is typed, and reports whether they are now hidden.
+func (v *Visualizer) ToggleSuppressed() bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.suppressed = !v.suppressed
+	if v.suppressed {
+		v.keys = v.keys[:0]
+	}
+	return v.suppressed
+}
+
+func (v *Visualizer) renderLoop() {
+	t := time.NewTicker(renderInterval)
+	defer t.Stop()
+	blank, text := true, ""
+	for {
+		select {
+		case <-v.done:
+			return
+		case now := <-t.C:
+			v.mu.Lock()
+			live := v.ripples[:0]
+			for _, r := range v.ripples {
+				if now.Sub(r.at) < rippleDuration {
+					live = append(live, r)
+				}
+			}
+			v.ripples = live
+			if now.Sub(v.lastKey) > keysLinger {
+				v.keys = v.keys[:0]
+			}
+			nextText := strings.Join(v.keys, "")
+			drawing := len(v.ripples) > 0
+			v.mu.Unlock()
+
+			if v.opts.Clicks && (drawing || !blank) {
+				if err := v.writeImage(now); err != nil {
+					fmt.Printf("Warning: %v\n", err)
+				}
+				blank = !drawing
+			}
+			if v.opts.Keys && nextText != text {
+				if err := v.writeText(nextText); err != nil {
+					fmt.Printf("Warning: %v\n", err)
+				}
+				text = nextText
+			}
+		}
+	}
+}
+
+var rippleColors = map[int]color.NRGBA{
+	1: {255, 210, 0, 255},
+	2: {80, 160, 255, 255},
+	3: {255, 70, 70, 255},
+}
+
+// writeImage draws the ripples alive at now and replaces the PNG in one
+// rename, so ffmpeg never reads half a file.
+func (v *Visualizer) writeImage(now time.Time) error {
+	if !v.opts.Clicks {
+		return nil
+	}
+	v.mu.Lock()
+	ripples := append([]ripple(nil), v.ripples...)
+	w, h := v.w, v.h
+	v.mu.Unlock()
+
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for _, r := range ripples {
+		progress := float64(now.Sub(r.at)) / float64(rippleDuration)
+		if progress < 0 || progress >= 1 {
+			continue
+		}
+		radius := 8 + 32*progress
+		c := rippleColors[r.button]
+		c.A = uint8(255 * (1 - progress))
+		reach := int(radius) + 3
+		for y := r.y - reach; y <= r.y+reach; y++ {
+			for x := r.x - reach; x <= r.x+reach; x++ {
+				if !(image.Point{x, y}.In(img.Rect)) {
+					continue
+				}
+				d := math.Hypot(float64(x-r.x), float64(y-r.y))
+				if math.Abs(d-radius) <= 2 {
+					img.SetNRGBA(x, y, c)
+				}
+			}
+		}
+	}
+	return replaceFile(filepath.Join(v.dir, "clicks.png"), func(f *os.File) error {
+		return (&png.Encoder{CompressionLevel: png.BestSpeed}).Encode(f, img)
+	})
+}
+
+func (v *Visualizer) writeText(text string) error {
+	if !v.opts.Keys {
+		return nil
+	}
+	// drawtext refuses an empty file.
+	if text == "" {
+		text = " "
+	}
+	return replaceFile(filepath.Join(v.dir, "keys.txt"), func(f *os.File) error {
+		_, err := f.WriteString(text)
+		return err
+	})
+}
+
+func replaceFile(path string, write func(*os.File) error) error {
+	f, err := os.CreateTemp(filepath.Dir(path), ".overlay-*")
+	if err != nil {
+		return fmt.Errorf("failed to write overlay: %w", err)
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return fmt.Errorf("failed to write overlay: %w", err)
+	}
+	f.Close()
+	if err := os.Rename(f.Name(), path); err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("failed to write overlay: %w", err)
+	}
+	return nil
+}
+
+// Close stops the helper and removes the overlay files.
+func (v *Visualizer) Close() {
+	close(v.done)
+	v.kill()
+	os.RemoveAll(v.dir)
+}
@@ -0,0 +1,62 @@
+# Reports clicks and key presses on the X display as JSON lines, using the
+# RECORD extension so nothing is grabbed from other clients.
+import json
+import sys
+
+from Xlib import X, XK, display
+from Xlib.ext import record
+from Xlib.protocol import rq
+
+MODIFIERS = [
+    (X.ControlMask, "Ctrl"),
+    (X.Mod1Mask, "Alt"),
+    (X.Mod4Mask, "Super"),
+]
+SKIP = {"Shift_L", "Shift_R", "Control_L", "Control_R", "Alt_L", "Alt_R",
+        "Super_L", "Super_R", "Meta_L", "Meta_R", "Caps_Lock", "Num_Lock",
+        "ISO_Level3_Shift"}
+
+def emit(ev):
+    sys.stdout.write(json.dumps(ev) + "\n")
+    sys.stdout.flush()
+
+def main():
+    local = display.Display()
+    rec = display.Display()
+    if not rec.has_extension("RECORD"):
+        sys.exit("X server has no RECORD extension")
+    screen = local.screen()
+    emit({"type": "screen", "w": screen.width_in_pixels, "h": screen.height_in_pixels})
+
+    def handle(reply):
+        if reply.category != record.FromServer or reply.client_swapped:
+            return
+        data = reply.data
+        while len(data):
+            ev, data = rq.EventField(None).parse_binary_value(data, rec.display, None, None)
+            if ev.type == X.ButtonPress and ev.detail in (1, 2, 3):
+                emit({"type": "click", "x": ev.root_x, "y": ev.root_y, "button": ev.detail})
+            elif ev.type == X.KeyPress:
+                shift = 1 if ev.state & X.ShiftMask else 0
+                keysym = local.keycode_to_keysym(ev.detail, shift) or local.keycode_to_keysym(ev.detail, 0)
+                name = XK.keysym_to_string(keysym) if keysym else None
+                if not name or name in SKIP:
+                    continue
+                mods = [label for mask, label in MODIFIERS if ev.state & mask]
+                emit({"type": "key", "key": name, "mods": mods})
+
+    ctx = rec.record_create_context(0, [record.AllClients], [{
+        "core_requests": (0, 0),
+        "core_replies": (0, 0),
+        "ext_requests": (0, 0, 0, 0),
+        "ext_replies": (0, 0, 0, 0),
+        "delivered_events": (0, 0),
+        "device_events": (X.KeyPress, X.ButtonPress),
+        "errors": (0, 0),
+        "client_started": False,
+        "client_died": False,
+    }])
+    rec.record_enable_context(ctx, handle)
+
+if __name__ == "__main__":
+    main()
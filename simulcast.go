@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sync/atomic"
+
+	"github.com/nathfavour/remoter/ffmpeg"
+	"github.com/nathfavour/remoter/framesum"
+)
+
+// qualityExists reports whether quality names the full-quality stream or
+// one of the configured tiers.
+func qualityExists(quality string) bool {
+	if quality == ffmpeg.DefaultTier {
+		return true
+	}
+	services.mu.Lock()
+	defer services.mu.Unlock()
+	for _, t := range services.cfg.Tiers {
+		if t.Name == quality {
+			return true
+		}
+	}
+	return false
+}
+
+// streamKey names a stream's rendition; the full-quality one keeps the
+// plain stream name.
+func streamKey(stream, quality string) string {
+	if quality == "" || quality == ffmpeg.DefaultTier {
+		return stream
+	}
+	return stream + "@" + quality
+}
+
+// controlMessage is a text frame a WebSocket viewer sends to steer its
+// stream; the video itself stays binary.
+type controlMessage struct {
+	Quality string `json:"quality,omitempty"`
+	// ChecksumMismatch reports a video message that arrived corrupt, from
+	// viewers that asked for checksums with ?checksum=1.
+	ChecksumMismatch *framesum.Mismatch `json:"checksum_mismatch,omitempty"`
+}
+
+// handleControlMessage applies a viewer's control message.
+func handleControlMessage(c *client, data []byte) {
+	var msg controlMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		log.Printf("Ignoring malformed control message from client %s: %v", c.id, err)
+		return
+	}
+	if msg.Quality != "" {
+		if !qualityExists(msg.Quality) {
+			log.Printf("Client %s asked for unknown quality %q", c.id, msg.Quality)
+			return
+		}
+		streamHub.retune <- retune{c, msg.Quality}
+		viewerDevices.remember(c.device, msg.Quality)
+	}
+	if m := msg.ChecksumMismatch; m != nil && c.checksums {
+		reportChecksumMismatch(c, m)
+	}
+}
+
+// checksumErrors counts the corrupt messages all viewers reported.
+var checksumErrors atomic.Int64
+
+// reportChecksumMismatch records a viewer's report of a corrupt message.
+// Corruption tends to come in bursts, so only the first few of a viewer's
+// reports and every hundredth after are logged and published.
+func reportChecksumMismatch(c *client, m *framesum.Mismatch) {
+	checksumErrors.Add(1)
+	n := c.checksumErrors.Add(1)
+	if n > 5 && n%100 != 0 {
+		return
+	}
+	log.Printf("Warning: client %s received a corrupt message (%d so far): %v", c.describe(), n, m)
+	emit(eventStreamCorrupt, map[string]any{
+		"client":      c.id,
+		"stream":      c.stream,
+		"remote_addr": c.remoteAddr,
+		"mismatch":    m,
+		"count":       n,
+	})
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// statsOverlayInterval is how often the statistics drawn into the main
+// stream are updated; the time in them is drawn per frame.
+const statsOverlayInterval = time.Second
+
+// statsOverlayPath is the file the main encoder draws the statistics from.
+var statsOverlayPath = filepath.Join(os.TempDir(), fmt.Sprintf("remoter-stats-%d.txt", os.Getpid()))
+
+// drawStats writes the statistics for the encoder to draw, before it
+// starts, and keeps them up to date.
+func drawStats() error {
+	if err := writeStats(); err != nil {
+		return err
+	}
+	go func() {
+		t := time.NewTicker(statsOverlayInterval)
+		defer t.Stop()
+		failing := false
+		for range t.C {
+			err := writeStats()
+			if err != nil && !failing {
+				log.Printf("Warning: stats overlay not updated: %v", err)
+			}
+			failing = err != nil
+		}
+	}()
+	return nil
+}
+
+// writeStats replaces the statistics file; drawtext would otherwise
+// catch a half-written one now and then.
+func writeStats() error {
+	st := services.encoder.Status()
+	text := fmt.Sprintf("%.0f fps  %.0f kb/s  %d viewers  %%{localtime}", st.FPS, st.OutputKbps, viewerCount())
+	if !st.Running {
+		text = fmt.Sprintf("encoder stopped  %d viewers  %%{localtime}", viewerCount())
+	}
+	tmp := statsOverlayPath + ".tmp"
+	if err := os.WriteFile(tmp, []byte(text), 0600); err != nil {
+		return fmt.Errorf("failed to write stats overlay: %w", err)
+	}
+	if err := os.Rename(tmp, statsOverlayPath); err != nil {
+		return fmt.Errorf("failed to write stats overlay: %w", err)
+	}
+	return nil
+}
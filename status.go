@@ -0,0 +1,126 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// serverStarted is when this instance came up.
+var serverStarted = time.Now()
+
+// statusPage is set from the config: whether /status answers everyone.
+var statusPage bool
+
+// publicStatus is what /status tells anyone who asks: nothing about the
+// screen beyond whether it is being streamed.
+type publicStatus struct {
+	// State is "live", "starting" (encoding but without a first frame
+	// yet), "idle" (slowed or stopped while nobody watches), "paused" or
+	// "down".
+	State string `json:"state"`
+	Live  bool   `json:"live"`
+	// Uptime is how long the stream has been running, in seconds.
+	Uptime       int64 `json:"uptime_seconds"`
+	ServerUptime int64 `json:"server_uptime_seconds"`
+	Viewers      int   `json:"viewers"`
+}
+
+func currentPublicStatus() publicStatus {
+	st := services.encoder.Status()
+	s := publicStatus{ServerUptime: int64(time.Since(serverStarted).Seconds())}
+	idleMux.Lock()
+	idle := encoderIdle || idleStopped
+	idleMux.Unlock()
+	switch {
+	case streamPaused():
+		s.State = "paused"
+	case idle:
+		s.State = "idle"
+	case st.Running && !streamReady(""):
+		s.State = "starting"
+	case st.Running:
+		s.State = "live"
+	default:
+		s.State = "down"
+	}
+	s.Live = s.State == "live"
+	if st.Running {
+		s.Uptime = int64(time.Since(st.StartedAt).Seconds())
+	}
+	s.Viewers = viewerCount()
+	return s
+}
+
+// viewerCount is how many people are watching: clients other than
+// recordings, casts, grids and pushes.
+func viewerCount() int {
+	clientsMux.RLock()
+	defer clientsMux.RUnlock()
+	n := 0
+	for c := range clients {
+		switch c.transport {
+		case transportRecord, transportCast, transportGrid, transportPush:
+		default:
+			n++
+		}
+	}
+	return n
+}
+
+var statusTemplate = template.Must(template.New("status").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="30">
+<title>remoter: {{.State}}</title>
+<style>
+body { font-family: sans-serif; background: #111; color: #eee; margin: 2em; }
+.state { font-size: 2em; font-weight: bold; }
+.live { color: #4c4; } .starting, .idle, .paused { color: #cc4; } .down { color: #c44; }
+</style>
+</head>
+<body>
+<div class="state {{.State}}">{{.State}}</div>
+<p>Stream up for {{.StreamUptime}}, server up for {{.Uptime}}</p>
+<p>{{.Viewers}} viewer{{if ne .Viewers 1}}s{{end}}</p>
+</body>
+</html>
+`))
+
+// handlePublicStatus serves whether the stream is up, to dashboards
+// without a login: JSON for ?format=json or an Accept of
+// application/json, a page otherwise.
+func handlePublicStatus(w http.ResponseWriter, r *http.Request) {
+	if !statusPage {
+		http.NotFound(w, r)
+		return
+	}
+	s := currentPublicStatus()
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+		writeJSON(w, http.StatusOK, s)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	statusTemplate.Execute(w, map[string]any{
+		"State":        s.State,
+		"Viewers":      s.Viewers,
+		"StreamUptime": (time.Duration(s.Uptime) * time.Second).String(),
+		"Uptime":       (time.Duration(s.ServerUptime) * time.Second).String(),
+	})
+}
+
+// handleHealth answers load balancers and orchestrators without a login:
+// 200 once the main stream has a picture for new viewers, 503 until then.
+func handleHealth(w http.ResponseWriter, r *http.Request) {
+	ready := streamReady("")
+	w.Header().Set("Cache-Control", "no-store")
+	code := http.StatusOK
+	if !ready {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, map[string]bool{"ready": ready})
+}
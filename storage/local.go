@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Local keeps recordings in a directory.
+type Local struct {
+	dir string
+}
+
+func NewLocal(dir string) (*Local, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("local storage needs a dir")
+	}
+	return &Local{dir: dir}, nil
+}
+
+// localWriter writes to a hidden temporary file renamed into place on
+// Close, so readers never see a partial recording under its final name.
+type localWriter struct {
+	*os.File
+	final string
+}
+
+func (w *localWriter) Close() error {
+	if err := w.File.Close(); err != nil {
+		os.Remove(w.Name())
+		return err
+	}
+	return os.Rename(w.Name(), w.final)
+}
+
+func (l *Local) Create(name string) (io.WriteCloser, error) {
+	if err := validName(name); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(l.dir, 0750); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", l.dir, err)
+	}
+	f, err := os.CreateTemp(l.dir, ".partial-*")
+	if err != nil {
+		return nil, err
+	}
+	return &localWriter{File: f, final: filepath.Join(l.dir, name)}, nil
+}
+
+func (l *Local) Open(name string) (io.ReadSeekCloser, error) {
+	if err := validName(name); err != nil {
+		return nil, err
+	}
+	return os.Open(filepath.Join(l.dir, name))
+}
+
+func (l *Local) Stat(name string) (Object, error) {
+	if err := validName(name); err != nil {
+		return Object{}, err
+	}
+	fi, err := os.Stat(filepath.Join(l.dir, name))
+	if err != nil {
+		return Object{}, err
+	}
+	return Object{Name: name, Size: fi.Size(), ModTime: fi.ModTime()}, nil
+}
+
+func (l *Local) List() ([]Object, error) {
+	entries, err := os.ReadDir(l.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var objs []Object
+	for _, e := range entries {
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			continue
+		}
+		objs = append(objs, Object{Name: e.Name(), Size: fi.Size(), ModTime: fi.ModTime()})
+	}
+	return objs, nil
+}
+
+func (l *Local) Delete(name string) error {
+	if err := validName(name); err != nil {
+		return err
+	}
+	return os.Remove(filepath.Join(l.dir, name))
+}
@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+)
+
+// spoolWriter buffers a recording in a temporary file and uploads it on
+// Close, since remote stores want the length up front.
+type spoolWriter struct {
+	*os.File
+	upload func(f *os.File, size int64) error
+}
+
+func newSpoolWriter(upload func(f *os.File, size int64) error) (*spoolWriter, error) {
+	f, err := os.CreateTemp("", "remoter-upload-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create spool file: %w", err)
+	}
+	return &spoolWriter{File: f, upload: upload}, nil
+}
+
+func (w *spoolWriter) Close() error {
+	defer os.Remove(w.Name())
+	defer w.File.Close()
+	size, err := w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return w.upload(w.File, size)
+}
+
+// rangeReader reads an HTTP object, issuing one ranged GET per seek
+// rather than per read.
+type rangeReader struct {
+	get  func(offset int64) (*http.Response, error)
+	size int64
+	off  int64
+	body io.ReadCloser
+}
+
+func (r *rangeReader) Read(p []byte) (int, error) {
+	if r.off >= r.size {
+		return 0, io.EOF
+	}
+	if r.body == nil {
+		resp, err := r.get(r.off)
+		if err != nil {
+			return 0, err
+		}
+		if resp.StatusCode != http.StatusPartialContent && resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return 0, fmt.Errorf("failed to read object: %s", resp.Status)
+		}
+		if resp.StatusCode == http.StatusOK && r.off > 0 {
+			// The server ignored the range; skip to the offset.
+			if _, err := io.CopyN(io.Discard, resp.Body, r.off); err != nil {
+				resp.Body.Close()
+				return 0, err
+			}
+		}
+		r.body = resp.Body
+	}
+	n, err := r.body.Read(p)
+	r.off += int64(n)
+	return n, err
+}
+
+func (r *rangeReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.off
+	case io.SeekEnd:
+		offset += r.size
+	}
+	if offset < 0 {
+		return 0, errors.New("negative seek offset")
+	}
+	if offset != r.off && r.body != nil {
+		r.body.Close()
+		r.body = nil
+	}
+	r.off = offset
+	return offset, nil
+}
+
+func (r *rangeReader) Close() error {
+	if r.body != nil {
+		return r.body.Close()
+	}
+	return nil
+}
+
+// checkStatus turns unexpected HTTP statuses into errors, mapping 404 to
+// ErrNotExist.
+func checkStatus(resp *http.Response, op string, ok ...int) error {
+	for _, code := range ok {
+		if resp.StatusCode == code {
+			return nil
+		}
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotExist
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("failed to %s: %s %s", op, resp.Status, msg)
+}
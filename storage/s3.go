@@ -0,0 +1,247 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3 stores recordings in an S3-compatible bucket (AWS, MinIO, R2...),
+// addressed path-style and signed with SigV4. Single PUTs cap recordings
+// at 5 GiB.
+type S3 struct {
+	endpoint *url.URL
+	bucket   string
+	prefix   string
+	region   string
+	access   string
+	secret   string
+	client   *http.Client
+}
+
+func newS3(cfg Config) (*S3, error) {
+	if cfg.Bucket == "" || cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return nil, fmt.Errorf("s3 storage needs a bucket, access_key and secret_key")
+	}
+	region := cfg.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	endpoint := cfg.URL
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", region)
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid s3 url: %w", err)
+	}
+	return &S3{
+		endpoint: u,
+		bucket:   cfg.Bucket,
+		prefix:   cfg.Prefix,
+		region:   region,
+		access:   cfg.AccessKey,
+		secret:   cfg.SecretKey,
+		client:   &http.Client{},
+	}, nil
+}
+
+func (s *S3) objectURL(name string) *url.URL {
+	u := *s.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.bucket + "/" + s.prefix + name
+	return &u
+}
+
+// s3Escape is SigV4's URI encoding: everything but unreserved characters.
+func s3Escape(s string, keepSlash bool) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && keepSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// sign adds SigV4 headers to req. Payloads are not hashed; TLS protects
+// them in transit.
+func (s *S3) sign(req *http.Request) {
+	now := time.Now().UTC()
+	date := now.Format("20060102")
+	stamp := now.Format("20060102T150405Z")
+	req.Header.Set("x-amz-date", stamp)
+	req.Header.Set("x-amz-content-sha256", "UNSIGNED-PAYLOAD")
+
+	query := req.URL.Query()
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var params []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			params = append(params, s3Escape(k, false)+"="+s3Escape(v, false))
+		}
+	}
+	req.URL.RawQuery = strings.Join(params, "&")
+	// Send the path exactly as it is signed.
+	req.URL.RawPath = s3Escape(req.URL.Path, true)
+
+	signed := "host;x-amz-content-sha256;x-amz-date"
+	canonical := strings.Join([]string{
+		req.Method,
+		s3Escape(req.URL.Path, true),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host + "\n" +
+			"x-amz-content-sha256:UNSIGNED-PAYLOAD\n" +
+			"x-amz-date:" + stamp + "\n",
+		signed,
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	sum := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + stamp + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.secret), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.access, scope, signed, hex.EncodeToString(hmacSHA256(key, toSign))))
+}
+
+func (s *S3) do(method string, u *url.URL, body io.Reader, size int64, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	s.sign(req)
+	return s.client.Do(req)
+}
+
+func (s *S3) Create(name string) (io.WriteCloser, error) {
+	if err := validName(name); err != nil {
+		return nil, err
+	}
+	return newSpoolWriter(func(f *os.File, size int64) error {
+		resp, err := s.do("PUT", s.objectURL(name), f, size, http.Header{"Content-Type": {"video/mpeg"}})
+		if err != nil {
+			return fmt.Errorf("failed to upload %s: %w", name, err)
+		}
+		defer resp.Body.Close()
+		return checkStatus(resp, "upload "+name, http.StatusOK)
+	})
+}
+
+func (s *S3) Open(name string) (io.ReadSeekCloser, error) {
+	obj, err := s.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	return &rangeReader{size: obj.Size, get: func(off int64) (*http.Response, error) {
+		return s.do("GET", s.objectURL(name), nil, 0, http.Header{"Range": {fmt.Sprintf("bytes=%d-", off)}})
+	}}, nil
+}
+
+func (s *S3) Stat(name string) (Object, error) {
+	if err := validName(name); err != nil {
+		return Object{}, err
+	}
+	resp, err := s.do("HEAD", s.objectURL(name), nil, 0, nil)
+	if err != nil {
+		return Object{}, err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp, "stat "+name, http.StatusOK); err != nil {
+		return Object{}, err
+	}
+	mod, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return Object{Name: name, Size: resp.ContentLength, ModTime: mod}, nil
+}
+
+func (s *S3) List() ([]Object, error) {
+	var objs []Object
+	token := ""
+	for {
+		u := *s.endpoint
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.bucket
+		q := url.Values{"list-type": {"2"}, "prefix": {s.prefix}}
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		u.RawQuery = q.Encode()
+
+		resp, err := s.do("GET", &u, nil, 0, nil)
+		if err != nil {
+			return nil, err
+		}
+		var result struct {
+			Contents []struct {
+				Key          string
+				Size         string
+				LastModified time.Time
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		err = checkStatus(resp, "list bucket", http.StatusOK)
+		if err == nil {
+			err = xml.NewDecoder(resp.Body).Decode(&result)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range result.Contents {
+			name := strings.TrimPrefix(c.Key, s.prefix)
+			if validName(name) != nil {
+				continue
+			}
+			size, _ := strconv.ParseInt(c.Size, 10, 64)
+			objs = append(objs, Object{Name: name, Size: size, ModTime: c.LastModified})
+		}
+		if !result.IsTruncated {
+			return objs, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (s *S3) Delete(name string) error {
+	if err := validName(name); err != nil {
+		return err
+	}
+	resp, err := s.do("DELETE", s.objectURL(name), nil, 0, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkStatus(resp, "delete "+name, http.StatusNoContent, http.StatusOK)
+}
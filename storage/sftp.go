@@ -0,0 +1,533 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// SFTP stores recordings in a directory on an SSH server, speaking just
+// enough of SFTP version 3 for that. Each operation uses its own
+// connection.
+type SFTP struct {
+	addr   string
+	dir    string
+	config *ssh.ClientConfig
+}
+
+func newSFTP(cfg Config) (*SFTP, error) {
+	if cfg.Host == "" || cfg.Username == "" {
+		return nil, fmt.Errorf("sftp storage needs a host and username")
+	}
+	addr := cfg.Host
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "22")
+	}
+
+	var auth []ssh.AuthMethod
+	if cfg.KeyFile != "" {
+		key, err := os.ReadFile(cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ssh key: %w", err)
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse ssh key: %w", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if cfg.Password != "" {
+		auth = append(auth, ssh.Password(cfg.Password))
+	}
+
+	var hostKey ssh.HostKeyCallback
+	if cfg.HostKey != "" {
+		hostKey = func(_ string, _ net.Addr, key ssh.PublicKey) error {
+			if fp := ssh.FingerprintSHA256(key); fp != cfg.HostKey {
+				return fmt.Errorf("sftp host key %s does not match %s", fp, cfg.HostKey)
+			}
+			return nil
+		}
+	} else {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		hostKey, err = knownhosts.New(filepath.Join(home, ".ssh", "known_hosts"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load known_hosts (or set host_key): %w", err)
+		}
+	}
+
+	dir := cfg.Dir
+	if dir == "" {
+		dir = "."
+	}
+	return &SFTP{addr: addr, dir: dir, config: &ssh.ClientConfig{
+		User:            cfg.Username,
+		Auth:            auth,
+		HostKeyCallback: hostKey,
+		Timeout:         15 * time.Second,
+	}}, nil
+}
+
+// SFTP v3 packet types and flags (draft-ietf-secsh-filexfer-02).
+const (
+	fxpInit     = 1
+	fxpVersion  = 2
+	fxpOpen     = 3
+	fxpClose    = 4
+	fxpRead     = 5
+	fxpWrite    = 6
+	fxpFstat    = 8
+	fxpOpendir  = 11
+	fxpReaddir  = 12
+	fxpRemove   = 13
+	fxpMkdir    = 14
+	fxpStat     = 17
+	fxpRename   = 18
+	fxpStatus   = 101
+	fxpHandle   = 102
+	fxpData     = 103
+	fxpName     = 104
+	fxpAttrs    = 105
+	fxfRead     = 0x01
+	fxfWrite    = 0x02
+	fxfCreat    = 0x08
+	fxfTrunc    = 0x10
+	fxEOF       = 1
+	fxNoSuch    = 2
+	attrSize    = 0x01
+	attrUIDGID  = 0x02
+	attrPerm    = 0x04
+	attrTime    = 0x08
+	attrExtend  = 0x80000000
+	sftpMaxData = 32 * 1024
+)
+
+type sftpConn struct {
+	mu     sync.Mutex
+	client *ssh.Client
+	in     io.Reader
+	out    io.WriteCloser
+	id     uint32
+}
+
+func (s *SFTP) dial() (*sftpConn, error) {
+	client, err := ssh.Dial("tcp", s.addr, s.config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", s.addr, err)
+	}
+	sess, err := client.NewSession()
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	out, err := sess.StdinPipe()
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	in, err := sess.StdoutPipe()
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	if err := sess.RequestSubsystem("sftp"); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("server has no sftp subsystem: %w", err)
+	}
+	c := &sftpConn{client: client, in: in, out: out}
+
+	init := binary.BigEndian.AppendUint32([]byte{fxpInit}, 3)
+	if err := c.send(init); err != nil {
+		c.close()
+		return nil, err
+	}
+	if typ, _, err := c.recv(); err != nil || typ != fxpVersion {
+		c.close()
+		return nil, fmt.Errorf("sftp handshake failed: %v", err)
+	}
+	return c, nil
+}
+
+func (c *sftpConn) close() error {
+	c.out.Close()
+	return c.client.Close()
+}
+
+func (c *sftpConn) send(payload []byte) error {
+	pkt := binary.BigEndian.AppendUint32(nil, uint32(len(payload)))
+	_, err := c.out.Write(append(pkt, payload...))
+	return err
+}
+
+func (c *sftpConn) recv() (byte, []byte, error) {
+	var hdr [5]byte
+	if _, err := io.ReadFull(c.in, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	n := binary.BigEndian.Uint32(hdr[:4])
+	if n == 0 || n > 1<<20 {
+		return 0, nil, fmt.Errorf("bad sftp packet length %d", n)
+	}
+	body := make([]byte, n-1)
+	if _, err := io.ReadFull(c.in, body); err != nil {
+		return 0, nil, err
+	}
+	return hdr[4], body, nil
+}
+
+// call sends a request and returns the reply's type and its payload after
+// the request id.
+func (c *sftpConn) call(typ byte, args ...[]byte) (byte, []byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.id++
+	req := binary.BigEndian.AppendUint32([]byte{typ}, c.id)
+	for _, a := range args {
+		req = append(req, a...)
+	}
+	if err := c.send(req); err != nil {
+		return 0, nil, err
+	}
+	rtyp, body, err := c.recv()
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(body) < 4 || binary.BigEndian.Uint32(body) != c.id {
+		return 0, nil, errors.New("unexpected sftp reply")
+	}
+	return rtyp, body[4:], nil
+}
+
+func sftpString(s string) []byte {
+	return append(binary.BigEndian.AppendUint32(nil, uint32(len(s))), s...)
+}
+
+func sftpUint32(v uint32) []byte { return binary.BigEndian.AppendUint32(nil, v) }
+func sftpUint64(v uint64) []byte { return binary.BigEndian.AppendUint64(nil, v) }
+
+// reader decodes SFTP fields, remembering the first error.
+type sftpReader struct {
+	b   []byte
+	err error
+}
+
+func (r *sftpReader) uint32() uint32 {
+	if len(r.b) < 4 {
+		r.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	v := binary.BigEndian.Uint32(r.b)
+	r.b = r.b[4:]
+	return v
+}
+
+func (r *sftpReader) uint64() uint64 {
+	return uint64(r.uint32())<<32 | uint64(r.uint32())
+}
+
+func (r *sftpReader) string() string {
+	n := int(r.uint32())
+	if r.err != nil || len(r.b) < n {
+		r.err = io.ErrUnexpectedEOF
+		return ""
+	}
+	s := string(r.b[:n])
+	r.b = r.b[n:]
+	return s
+}
+
+// attrs decodes an ATTRS block into size and modification time.
+func (r *sftpReader) attrs() (size int64, mtime time.Time) {
+	flags := r.uint32()
+	if flags&attrSize != 0 {
+		size = int64(r.uint64())
+	}
+	if flags&attrUIDGID != 0 {
+		r.uint32()
+		r.uint32()
+	}
+	if flags&attrPerm != 0 {
+		r.uint32()
+	}
+	if flags&attrTime != 0 {
+		r.uint32()
+		mtime = time.Unix(int64(r.uint32()), 0)
+	}
+	if flags&attrExtend != 0 {
+		for n := r.uint32(); n > 0 && r.err == nil; n-- {
+			r.string()
+			r.string()
+		}
+	}
+	return size, mtime
+}
+
+// statusError converts an SSH_FXP_STATUS reply; nil for SSH_FX_OK.
+func statusError(body []byte, op string) error {
+	r := &sftpReader{b: body}
+	code, msg := r.uint32(), r.string()
+	switch code {
+	case 0:
+		return nil
+	case fxEOF:
+		return io.EOF
+	case fxNoSuch:
+		return ErrNotExist
+	}
+	return fmt.Errorf("failed to %s: %s (sftp status %d)", op, msg, code)
+}
+
+// expect checks a reply is of type want, turning status replies into
+// errors.
+func expect(typ byte, body []byte, err error, want byte, op string) ([]byte, error) {
+	if err != nil {
+		return nil, fmt.Errorf("failed to %s: %w", op, err)
+	}
+	if typ == fxpStatus {
+		if err := statusError(body, op); err != nil {
+			return nil, err
+		}
+		if want == fxpStatus {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to %s: unexpected sftp status", op)
+	}
+	if typ != want {
+		return nil, fmt.Errorf("failed to %s: unexpected sftp reply %d", op, typ)
+	}
+	return body, nil
+}
+
+func (c *sftpConn) open(name string, flags uint32) (string, error) {
+	typ, body, err := c.call(fxpOpen, sftpString(name), sftpUint32(flags), sftpUint32(0))
+	body, err = expect(typ, body, err, fxpHandle, "open "+name)
+	if err != nil {
+		return "", err
+	}
+	r := &sftpReader{b: body}
+	return r.string(), r.err
+}
+
+func (c *sftpConn) closeHandle(h string) error {
+	typ, body, err := c.call(fxpClose, sftpString(h))
+	_, err = expect(typ, body, err, fxpStatus, "close file")
+	return err
+}
+
+func (s *SFTP) path(name string) string {
+	return path.Join(s.dir, name)
+}
+
+// sftpWriter uploads to a temporary name, renamed into place on Close.
+type sftpWriter struct {
+	c          *sftpConn
+	handle     string
+	off        uint64
+	tmp, final string
+}
+
+func (w *sftpWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), sftpMaxData)
+		typ, body, err := w.c.call(fxpWrite, sftpString(w.handle), sftpUint64(w.off), sftpString(string(p[:n])))
+		if _, err := expect(typ, body, err, fxpStatus, "write "+w.final); err != nil {
+			return written, err
+		}
+		w.off += uint64(n)
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (w *sftpWriter) Close() error {
+	defer w.c.close()
+	if err := w.c.closeHandle(w.handle); err != nil {
+		return err
+	}
+	// SFTP v3 rename fails if the target exists.
+	w.c.call(fxpRemove, sftpString(w.final))
+	typ, body, err := w.c.call(fxpRename, sftpString(w.tmp), sftpString(w.final))
+	_, err = expect(typ, body, err, fxpStatus, "rename "+w.tmp)
+	return err
+}
+
+func (s *SFTP) Create(name string) (io.WriteCloser, error) {
+	if err := validName(name); err != nil {
+		return nil, err
+	}
+	c, err := s.dial()
+	if err != nil {
+		return nil, err
+	}
+	// Best effort; fails harmlessly when the directory exists.
+	c.call(fxpMkdir, sftpString(s.dir), sftpUint32(0))
+
+	tmp := s.path(".partial-" + name)
+	h, err := c.open(tmp, fxfWrite|fxfCreat|fxfTrunc)
+	if err != nil {
+		c.close()
+		return nil, err
+	}
+	return &sftpWriter{c: c, handle: h, tmp: tmp, final: s.path(name)}, nil
+}
+
+type sftpFile struct {
+	c      *sftpConn
+	handle string
+	name   string
+	size   int64
+	off    int64
+}
+
+func (f *sftpFile) Read(p []byte) (int, error) {
+	if f.off >= f.size {
+		return 0, io.EOF
+	}
+	n := min(len(p), sftpMaxData)
+	typ, body, err := f.c.call(fxpRead, sftpString(f.handle), sftpUint64(uint64(f.off)), sftpUint32(uint32(n)))
+	body, err = expect(typ, body, err, fxpData, "read "+f.name)
+	if err != nil {
+		return 0, err
+	}
+	r := &sftpReader{b: body}
+	data := r.string()
+	if r.err != nil {
+		return 0, r.err
+	}
+	copy(p, data)
+	f.off += int64(len(data))
+	return len(data), nil
+}
+
+func (f *sftpFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.off
+	case io.SeekEnd:
+		offset += f.size
+	}
+	if offset < 0 {
+		return 0, errors.New("negative seek offset")
+	}
+	f.off = offset
+	return offset, nil
+}
+
+func (f *sftpFile) Close() error {
+	defer f.c.close()
+	return f.c.closeHandle(f.handle)
+}
+
+func (s *SFTP) Open(name string) (io.ReadSeekCloser, error) {
+	if err := validName(name); err != nil {
+		return nil, err
+	}
+	c, err := s.dial()
+	if err != nil {
+		return nil, err
+	}
+	h, err := c.open(s.path(name), fxfRead)
+	if err != nil {
+		c.close()
+		return nil, err
+	}
+	typ, body, err := c.call(fxpFstat, sftpString(h))
+	body, err = expect(typ, body, err, fxpAttrs, "stat "+name)
+	if err != nil {
+		c.closeHandle(h)
+		c.close()
+		return nil, err
+	}
+	size, _ := (&sftpReader{b: body}).attrs()
+	return &sftpFile{c: c, handle: h, name: name, size: size}, nil
+}
+
+func (s *SFTP) Stat(name string) (Object, error) {
+	if err := validName(name); err != nil {
+		return Object{}, err
+	}
+	c, err := s.dial()
+	if err != nil {
+		return Object{}, err
+	}
+	defer c.close()
+	typ, body, err := c.call(fxpStat, sftpString(s.path(name)))
+	body, err = expect(typ, body, err, fxpAttrs, "stat "+name)
+	if err != nil {
+		return Object{}, err
+	}
+	size, mtime := (&sftpReader{b: body}).attrs()
+	return Object{Name: name, Size: size, ModTime: mtime}, nil
+}
+
+func (s *SFTP) List() ([]Object, error) {
+	c, err := s.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer c.close()
+	typ, body, err := c.call(fxpOpendir, sftpString(s.dir))
+	body, err = expect(typ, body, err, fxpHandle, "open "+s.dir)
+	if err != nil {
+		if errors.Is(err, ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	h := (&sftpReader{b: body}).string()
+	defer c.closeHandle(h)
+
+	var objs []Object
+	for {
+		typ, body, err := c.call(fxpReaddir, sftpString(h))
+		body, err = expect(typ, body, err, fxpName, "list "+s.dir)
+		if err == io.EOF {
+			return objs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		r := &sftpReader{b: body}
+		for n := r.uint32(); n > 0 && r.err == nil; n-- {
+			name, long := r.string(), r.string()
+			size, mtime := r.attrs()
+			if validName(name) != nil || strings.HasPrefix(long, "d") {
+				continue
+			}
+			objs = append(objs, Object{Name: name, Size: size, ModTime: mtime})
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+	}
+}
+
+func (s *SFTP) Delete(name string) error {
+	if err := validName(name); err != nil {
+		return err
+	}
+	c, err := s.dial()
+	if err != nil {
+		return err
+	}
+	defer c.close()
+	typ, body, err := c.call(fxpRemove, sftpString(s.path(name)))
+	_, err = expect(typ, body, err, fxpStatus, "delete "+name)
+	return err
+}
@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// Backend stores recordings by name. Names are flat: no directories.
+type Backend interface {
+	// Create starts writing name; the object appears once the writer is
+	// closed without error.
+	Create(name string) (io.WriteCloser, error)
+	// Open reads name, seekable so playback can serve ranges.
+	Open(name string) (io.ReadSeekCloser, error)
+	Stat(name string) (Object, error)
+	List() ([]Object, error)
+	Delete(name string) error
+}
+
+// Object describes a stored recording.
+type Object struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// ErrNotExist is returned for names the backend does not hold.
+var ErrNotExist = os.ErrNotExist
+
+// Config selects and configures a backend.
+type Config struct {
+	Type string `json:"type"` // "local" (default), "s3", "webdav" or "sftp"
+
+	// Dir is the local directory, or the path on the SFTP server.
+	Dir string `json:"dir,omitempty"`
+
+	// URL is the WebDAV collection, or the S3 endpoint (defaults to AWS
+	// for Region).
+	URL      string `json:"url,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+
+	Bucket    string `json:"bucket,omitempty"`
+	Region    string `json:"region,omitempty"`
+	Prefix    string `json:"prefix,omitempty"`
+	AccessKey string `json:"access_key,omitempty"`
+	SecretKey string `json:"secret_key,omitempty"`
+
+	// Host is the SFTP server as host[:port]; KeyFile an SSH private key
+	// (Password is used otherwise) and HostKey the server key's SHA256
+	// fingerprint, checked against ~/.ssh/known_hosts when empty.
+	Host    string `json:"host,omitempty"`
+	KeyFile string `json:"key_file,omitempty"`
+	HostKey string `json:"host_key,omitempty"`
+}
+
+// New returns the backend cfg describes.
+func New(cfg Config) (Backend, error) {
+	switch cfg.Type {
+	case "", "local":
+		return NewLocal(cfg.Dir)
+	case "s3":
+		return newS3(cfg)
+	case "webdav":
+		return newWebDAV(cfg)
+	case "sftp":
+		return newSFTP(cfg)
+	}
+	return nil, fmt.Errorf("unknown storage type %q", cfg.Type)
+}
+
+// validName rejects names that could escape the backend's root.
+func validName(name string) error {
+	if name == "" || name != path.Base(name) || strings.HasPrefix(name, ".") || strings.ContainsAny(name, `\/`) {
+		return fmt.Errorf("invalid object name %q", name)
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package storage
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// WebDAV stores recordings in a WebDAV collection (Nextcloud, Apache
+// mod_dav, rclone serve...).
+type WebDAV struct {
+	base     *url.URL
+	username string
+	password string
+	client   *http.Client
+}
+
+func newWebDAV(cfg Config) (*WebDAV, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("webdav storage needs a url")
+	}
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid webdav url: %w", err)
+	}
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	d := &WebDAV{base: u, username: cfg.Username, password: cfg.Password, client: &http.Client{}}
+	// Make sure the collection exists; servers answer 405 if it does.
+	if resp, err := d.do("MKCOL", "", nil, 0, nil); err == nil {
+		resp.Body.Close()
+	}
+	return d, nil
+}
+
+func (d *WebDAV) do(method, name string, body io.Reader, size int64, header http.Header) (*http.Response, error) {
+	u := d.base.JoinPath(name)
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if d.username != "" {
+		req.SetBasicAuth(d.username, d.password)
+	}
+	return d.client.Do(req)
+}
+
+func (d *WebDAV) Create(name string) (io.WriteCloser, error) {
+	if err := validName(name); err != nil {
+		return nil, err
+	}
+	return newSpoolWriter(func(f *os.File, size int64) error {
+		resp, err := d.do("PUT", name, f, size, http.Header{"Content-Type": {"video/mpeg"}})
+		if err != nil {
+			return fmt.Errorf("failed to upload %s: %w", name, err)
+		}
+		defer resp.Body.Close()
+		return checkStatus(resp, "upload "+name, http.StatusCreated, http.StatusNoContent, http.StatusOK)
+	})
+}
+
+func (d *WebDAV) Open(name string) (io.ReadSeekCloser, error) {
+	obj, err := d.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	return &rangeReader{size: obj.Size, get: func(off int64) (*http.Response, error) {
+		return d.do("GET", name, nil, 0, http.Header{"Range": {fmt.Sprintf("bytes=%d-", off)}})
+	}}, nil
+}
+
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:"><d:prop><d:resourcetype/><d:getcontentlength/><d:getlastmodified/></d:prop></d:propfind>`
+
+type multistatus struct {
+	Responses []struct {
+		Href  string `xml:"href"`
+		Props []struct {
+			Prop struct {
+				ResourceType struct {
+					Collection *struct{} `xml:"collection"`
+				} `xml:"resourcetype"`
+				Length       string `xml:"getcontentlength"`
+				LastModified string `xml:"getlastmodified"`
+			} `xml:"prop"`
+			Status string `xml:"status"`
+		} `xml:"propstat"`
+	} `xml:"response"`
+}
+
+// propfind lists name (depth 0) or the collection (depth 1).
+func (d *WebDAV) propfind(name, depth string) ([]Object, error) {
+	resp, err := d.do("PROPFIND", name, strings.NewReader(propfindBody), int64(len(propfindBody)),
+		http.Header{"Depth": {depth}, "Content-Type": {"application/xml"}})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp, "list "+d.base.String(), http.StatusMultiStatus); err != nil {
+		return nil, err
+	}
+	var ms multistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, fmt.Errorf("failed to parse PROPFIND response: %w", err)
+	}
+
+	var objs []Object
+	for _, r := range ms.Responses {
+		href, err := url.PathUnescape(r.Href)
+		if err != nil {
+			href = r.Href
+		}
+		obj := Object{Name: path.Base(strings.TrimSuffix(href, "/"))}
+		isDir := false
+		for _, ps := range r.Props {
+			if !strings.Contains(ps.Status, " 200 ") {
+				continue
+			}
+			if ps.Prop.ResourceType.Collection != nil {
+				isDir = true
+			}
+			if ps.Prop.Length != "" {
+				obj.Size, _ = strconv.ParseInt(ps.Prop.Length, 10, 64)
+			}
+			if ps.Prop.LastModified != "" {
+				obj.ModTime, _ = http.ParseTime(ps.Prop.LastModified)
+			}
+		}
+		if isDir || validName(obj.Name) != nil {
+			continue
+		}
+		objs = append(objs, obj)
+	}
+	return objs, nil
+}
+
+func (d *WebDAV) Stat(name string) (Object, error) {
+	if err := validName(name); err != nil {
+		return Object{}, err
+	}
+	objs, err := d.propfind(name, "0")
+	if err != nil {
+		return Object{}, err
+	}
+	if len(objs) == 0 {
+		return Object{}, ErrNotExist
+	}
+	return objs[0], nil
+}
+
+func (d *WebDAV) List() ([]Object, error) {
+	return d.propfind("", "1")
+}
+
+func (d *WebDAV) Delete(name string) error {
+	if err := validName(name); err != nil {
+		return err
+	}
+	resp, err := d.do("DELETE", name, nil, 0, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkStatus(resp, "delete "+name, http.StatusNoContent, http.StatusOK)
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/nathfavour/remoter/ffmpeg"
+	"github.com/nathfavour/remoter/session"
+)
+
+// sessionEncoders capture each virtual session into its own stream, which
+// viewers reach under /s/{session}/.
+var (
+	sessionEncoders    = make(map[string]*ffmpeg.Encoder)
+	sessionEncodersMux sync.Mutex
+)
+
+// streamExists reports whether stream can be watched: "" is the main
+// display, anything else must be a live session.
+func streamExists(stream string) bool {
+	if stream == "" || gridExists(stream) {
+		return true
+	}
+	sessionEncodersMux.Lock()
+	defer sessionEncodersMux.Unlock()
+	_, ok := sessionEncoders[stream]
+	return ok
+}
+
+// startSessionStream launches an encoder for the session using the main
+// pipeline's port, framerate and bitrate.
+func startSessionStream(info session.Info) error {
+	s := services.encoder.Settings()
+	s.Display = info.Display
+	s.Res = info.Res
+	s.RuntimeDir = info.RuntimeDir
+	s.Stream = info.ID
+	// Areas of the main display don't apply to the session's screen.
+	s.Capture, s.ROI = nil, nil
+
+	enc := ffmpeg.NewEncoder(s)
+	if err := enc.Start(); err != nil {
+		return err
+	}
+	sessionEncodersMux.Lock()
+	sessionEncoders[info.ID] = enc
+	sessionEncodersMux.Unlock()
+	return nil
+}
+
+// stopSessionStream stops the session's encoder and disconnects its
+// viewers.
+func stopSessionStream(id string) {
+	sessionEncodersMux.Lock()
+	enc, ok := sessionEncoders[id]
+	delete(sessionEncoders, id)
+	sessionEncodersMux.Unlock()
+	if !ok {
+		return
+	}
+	_ = enc.Stop()
+	kickStream(id)
+}
+
+// pruneSessionStreams stops the streams of sessions that have been reaped.
+func pruneSessionStreams() {
+	live := make(map[string]bool)
+	for _, info := range sessions.List() {
+		live[info.ID] = true
+	}
+
+	sessionEncodersMux.Lock()
+	var stale []string
+	for id := range sessionEncoders {
+		if !live[id] {
+			stale = append(stale, id)
+		}
+	}
+	sessionEncodersMux.Unlock()
+
+	for _, id := range stale {
+		log.Printf("Stopping stream of reaped session %s", id)
+		stopSessionStream(id)
+	}
+}
+
+func stopSessionStreams() {
+	sessionEncodersMux.Lock()
+	ids := make([]string, 0, len(sessionEncoders))
+	for id := range sessionEncoders {
+		ids = append(ids, id)
+	}
+	sessionEncodersMux.Unlock()
+
+	for _, id := range ids {
+		stopSessionStream(id)
+	}
+}
+
+// streamMeta describes a watchable stream. ID is "main" for the main
+// display and the session ID otherwise.
+type streamMeta struct {
+	ID          string `json:"id"`
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Viewers     int    `json:"viewers"`
+	// Ready is unset until the stream's encoder produces its first frame;
+	// viewers connecting before then wait for it.
+	Ready bool `json:"ready"`
+}
+
+// header carries the metadata in the WebSocket handshake response for
+// clients that cannot fetch /meta first.
+func (m streamMeta) header() http.Header {
+	h := http.Header{}
+	h.Set("X-Remoter-Stream", m.ID)
+	h.Set("X-Remoter-Title", url.QueryEscape(m.Title))
+	return h
+}
+
+func streamViewers(stream string) int {
+	clientsMux.RLock()
+	defer clientsMux.RUnlock()
+	n := 0
+	for c := range clients {
+		if c.stream == stream {
+			n++
+		}
+	}
+	return n
+}
+
+func streamMetaFor(stream string) (streamMeta, bool) {
+	if stream == "" {
+		services.mu.Lock()
+		m := streamMeta{ID: "main", Title: services.cfg.Title, Description: services.cfg.Description}
+		services.mu.Unlock()
+		if m.Title == "" {
+			m.Title = "remoter"
+		}
+		m.Viewers = streamViewers("")
+		m.Ready = streamReady("")
+		return m, true
+	}
+	if !streamExists(stream) {
+		return streamMeta{}, false
+	}
+	if gridExists(stream) {
+		return streamMeta{ID: stream, Title: stream, Viewers: streamViewers(stream), Ready: streamReady(stream)}, true
+	}
+	info, ok := sessions.Get(stream)
+	if !ok {
+		return streamMeta{}, false
+	}
+	m := streamMeta{ID: info.ID, Title: info.Title, Description: info.Description, Viewers: streamViewers(stream), Ready: streamReady(stream)}
+	if m.Title == "" {
+		m.Title = fmt.Sprintf("%s (%s)", info.Template, info.ID)
+	}
+	return m, true
+}
+
+func listStreams() []streamMeta {
+	main, _ := streamMetaFor("")
+	streams := []streamMeta{main}
+	for _, info := range sessions.List() {
+		if m, ok := streamMetaFor(info.ID); ok {
+			streams = append(streams, m)
+		}
+	}
+	for _, name := range gridNames() {
+		if m, ok := streamMetaFor(name); ok {
+			streams = append(streams, m)
+		}
+	}
+	return streams
+}
+
+// handleStreamMeta serves /meta and /s/{session}/meta to viewers.
+func handleStreamMeta(w http.ResponseWriter, r *http.Request) {
+	m, ok := streamMetaFor(r.PathValue("session"))
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no such stream"))
+		return
+	}
+	writeJSON(w, http.StatusOK, m)
+}
+
+func handleListStreams(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"streams": listStreams()})
+}
+
+// handleUpdateStream sets the title and/or description of a stream. The
+// main stream's are saved to the config.
+func handleUpdateStream(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Title       *string `json:"title"`
+		Description *string `json:"description"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+
+	id := r.PathValue("id")
+	if id == "main" {
+		services.setMeta(req.Title, req.Description)
+		id = ""
+	} else if _, err := sessions.SetMeta(id, req.Title, req.Description); err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	m, _ := streamMetaFor(id)
+	log.Printf("API: stream %s is now titled %q", m.ID, m.Title)
+	writeJSON(w, http.StatusOK, m)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nathfavour/remoter/sdnotify"
+)
+
+// systemdReadyTimeout is how long readiness waits for the main stream's
+// first picture before telling systemd the server is up without it, so a
+// missing display does not fail the unit's start.
+const systemdReadyTimeout = 60 * time.Second
+
+// notifySystemd reports readiness to systemd once the main stream has a
+// picture, keeps its status line current and, when the unit has a
+// watchdog, pings it as long as the server answers on its control
+// socket. It does nothing outside a Type=notify unit.
+func notifySystemd(port int) {
+	if !sdnotify.Enabled() {
+		return
+	}
+	go func() {
+		deadline := time.Now().Add(systemdReadyTimeout)
+		for !streamReady("") && time.Now().Before(deadline) {
+			time.Sleep(500 * time.Millisecond)
+		}
+		ready := streamReady("")
+		if err := sdnotify.Notify("READY=1\nSTATUS=" + systemdStatus(ready)); err != nil {
+			log.Printf("Warning: failed to notify systemd: %v", err)
+			return
+		}
+		for range time.Tick(time.Second) {
+			if now := streamReady(""); now != ready {
+				ready = now
+				sdnotify.Notify("STATUS=" + systemdStatus(ready))
+			}
+		}
+	}()
+
+	interval := sdnotify.WatchdogInterval()
+	if interval == 0 {
+		return
+	}
+	client := &http.Client{
+		Timeout: interval / 2,
+		Transport: &http.Transport{
+			Dial: func(_, _ string) (net.Conn, error) {
+				return net.Dial("unix", controlSocketPath(port))
+			},
+		},
+	}
+	go func() {
+		for range time.Tick(interval / 2) {
+			resp, err := client.Get("http://remoter/healthz")
+			if err != nil {
+				log.Printf("Watchdog: server not answering: %v", err)
+				continue
+			}
+			resp.Body.Close()
+			sdnotify.Notify("WATCHDOG=1")
+		}
+	}()
+}
+
+func systemdStatus(ready bool) string {
+	if ready {
+		return fmt.Sprintf("Streaming to %d viewer(s)", clientCount())
+	}
+	return "Serving; waiting for the main stream's first picture"
+}
+
+// runServiceCommand installs or removes a systemd unit running this
+// binary with the current config.
+func runServiceCommand(args []string) error {
+	if len(args) == 0 || (args[0] != "install" && args[0] != "uninstall") {
+		return fmt.Errorf("usage: remoter service install|uninstall [--user] [--name remoter]")
+	}
+	fs := flag.NewFlagSet("service "+args[0], flag.ExitOnError)
+	userUnit := fs.Bool("user", false, "a unit of the user's service manager, started with their session")
+	name := fs.String("name", "remoter", "unit name, for instances with different configs")
+	fs.Parse(args[1:])
+
+	dir := "/etc/systemd/system"
+	systemctl := []string{}
+	if *userUnit {
+		cfgDir, err := os.UserConfigDir()
+		if err != nil {
+			return err
+		}
+		dir = filepath.Join(cfgDir, "systemd", "user")
+		systemctl = append(systemctl, "--user")
+	}
+	path := filepath.Join(dir, *name+".service")
+
+	if args[0] == "uninstall" {
+		exec.Command("systemctl", append(systemctl, "disable", "--now", *name)...).Run()
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		exec.Command("systemctl", append(systemctl, "daemon-reload")...).Run()
+		fmt.Printf("Removed %s\n", path)
+		return nil
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cfgPath, err := getConfigPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, []byte(systemdUnit(exe, cfgPath, *userUnit)), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if out, err := exec.Command("systemctl", append(systemctl, "daemon-reload")...).CombinedOutput(); err != nil {
+		fmt.Printf("Warning: systemctl daemon-reload: %v: %s\n", err, strings.TrimSpace(string(out)))
+	}
+	fmt.Printf("Wrote %s\nStart it now and on every boot with: systemctl %s\n",
+		path, strings.Join(append(systemctl, "enable", "--now", *name), " "))
+	return nil
+}
+
+// systemdUnit is a Type=notify unit for the server, restarted when it
+// fails or stops answering the watchdog.
+func systemdUnit(exe, cfgPath string, userUnit bool) string {
+	var b strings.Builder
+	b.WriteString("[Unit]\nDescription=remoter screen sharing server\n")
+	if userUnit {
+		b.WriteString("After=graphical-session.target\nPartOf=graphical-session.target\n")
+	} else {
+		b.WriteString("After=network-online.target\nWants=network-online.target\n")
+	}
+	b.WriteString("\n[Service]\nType=notify\nNotifyAccess=main\n")
+	fmt.Fprintf(&b, "ExecStart=%s --config %s\n", systemdQuote(exe), systemdQuote(cfgPath))
+	// Building the web app on a first start takes a while.
+	b.WriteString("TimeoutStartSec=180\nWatchdogSec=30\nRestart=on-failure\nRestartSec=5\n")
+	if xauth := os.Getenv("XAUTHORITY"); xauth != "" {
+		fmt.Fprintf(&b, "Environment=%s\n", systemdQuote("XAUTHORITY="+xauth))
+	}
+	b.WriteString("\n[Install]\n")
+	if userUnit {
+		b.WriteString("WantedBy=graphical-session.target\n")
+	} else {
+		b.WriteString("WantedBy=multi-user.target\n")
+	}
+	return b.String()
+}
+
+// systemdQuote escapes specifiers in a word of a unit file line and
+// quotes it when it needs it.
+func systemdQuote(s string) string {
+	s = strings.ReplaceAll(s, "%", "%%")
+	if !strings.ContainsAny(s, " \t\"'\\") {
+		return s
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -0,0 +1,12 @@
+package main
+
+// TailscaleConfig serves remoter on a tailnet through an embedded tsnet
+// node, reachable at https://<hostname>.<tailnet>.ts.net when HTTPS is set.
+type TailscaleConfig struct {
+	Enabled  bool   `json:"enabled"`
+	Hostname string `json:"hostname"`
+	AuthKey  string `json:"authkey,omitempty"`   // falls back to $TS_AUTHKEY
+	StateDir string `json:"state_dir,omitempty"` // defaults to the tsnet user config dir
+	HTTPS    bool   `json:"https"`               // serve :443 with a MagicDNS certificate
+	Only     bool   `json:"only"`                // bind the regular listener to loopback
+}
@@ -0,0 +1,12 @@
+//go:build !tsnet
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+func serveTailnet(tc *TailscaleConfig, handler http.Handler) error {
+	return fmt.Errorf("this build does not include Tailscale support; rebuild with -tags tsnet")
+}
@@ -0,0 +1,39 @@
+//go:build tsnet
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+
+	"tailscale.com/tsnet"
+)
+
+func serveTailnet(tc *TailscaleConfig, handler http.Handler) error {
+	hostname := tc.Hostname
+	if hostname == "" {
+		hostname = "remoter"
+	}
+	srv := &tsnet.Server{
+		Hostname: hostname,
+		AuthKey:  tc.AuthKey,
+		Dir:      tc.StateDir,
+		Logf:     func(string, ...any) {},
+	}
+
+	var ln net.Listener
+	var err error
+	if tc.HTTPS {
+		ln, err = srv.ListenTLS("tcp", ":443")
+	} else {
+		ln, err = srv.Listen("tcp", ":80")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to listen on tailnet: %w", err)
+	}
+
+	log.Printf("Serving on tailnet as %s", hostname)
+	return http.Serve(ln, handler)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"syscall"
+
+	"github.com/creack/pty"
+	"github.com/gorilla/websocket"
+	"github.com/nathfavour/remoter/i18n"
+)
+
+// TerminalConfig enables /terminal, a shell on the host for admins.
+type TerminalConfig struct {
+	Enabled bool   `json:"enabled"`
+	Shell   string `json:"shell,omitempty"` // default $SHELL, else /bin/bash
+	Dir     string `json:"dir,omitempty"`   // default the server's home
+}
+
+// terminalConfig is set in main when the terminal is enabled.
+var terminalConfig *TerminalConfig
+
+var termRe = regexp.MustCompile(`^[a-z0-9.+-]+$`)
+
+// terminalResize is the one text message a terminal client sends; all
+// else is keystrokes, as binary messages.
+type terminalResize struct {
+	Type string `json:"type"` // "resize"
+	Cols uint16 `json:"cols"`
+	Rows uint16 `json:"rows"`
+}
+
+// handleTerminal bridges a shell in a PTY over a WebSocket of its own:
+// binary messages carry the terminal's input and output, and text
+// messages {"type": "resize", "cols", "rows"} follow the viewer's window.
+// ?term= sets TERM (default xterm-256color) and ?cols=&rows= the initial
+// size. Only admins get a shell, or anyone when auth is off.
+func handleTerminal(w http.ResponseWriter, r *http.Request) {
+	if terminalConfig == nil {
+		i18n.Error(w, r, http.StatusNotFound, "terminal_disabled")
+		return
+	}
+	if a := requestAuth(r); a.Role != "admin" && !(a.Role == "" && authOff) {
+		i18n.Error(w, r, http.StatusForbidden, "forbidden")
+		return
+	}
+	q := r.URL.Query()
+	term := cmp.Or(q.Get("term"), "xterm-256color")
+	if !termRe.MatchString(term) {
+		term = "xterm-256color"
+	}
+	size := &pty.Winsize{Cols: 80, Rows: 24}
+	if n, err := strconv.ParseUint(q.Get("cols"), 10, 16); err == nil && n > 0 {
+		size.Cols = uint16(n)
+	}
+	if n, err := strconv.ParseUint(q.Get("rows"), 10, 16); err == nil && n > 0 {
+		size.Rows = uint16(n)
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("WebSocket upgrade error: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	shell := cmp.Or(terminalConfig.Shell, os.Getenv("SHELL"), "/bin/bash")
+	cmd := exec.Command(shell, "-l")
+	cmd.Dir = cmp.Or(terminalConfig.Dir, os.Getenv("HOME"), "/")
+	cmd.Env = append(os.Environ(), "TERM="+term)
+	ptmx, err := pty.StartWithSize(cmd, size)
+	if err != nil {
+		log.Printf("Failed to start terminal: %v", err)
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseInternalServerErr, "failed to start shell"))
+		return
+	}
+	who := cmp.Or(requestAuth(r).User, r.RemoteAddr)
+	log.Printf("Terminal opened for %s (%s, pid %d)", who, shell, cmd.Process.Pid)
+	defer func() {
+		ptmx.Close()
+		cmd.Process.Signal(syscall.SIGHUP)
+		cmd.Wait()
+		log.Printf("Terminal closed for %s", who)
+	}()
+
+	// The shell's output; it ends when the shell exits.
+	done := make(chan struct{})
+	keepAlive(conn, done)
+	go func() {
+		defer close(done)
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := ptmx.Read(buf)
+			if n > 0 {
+				if conn.WriteMessage(websocket.BinaryMessage, buf[:n]) != nil {
+					return
+				}
+			}
+			if err != nil {
+				conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "shell exited"))
+				return
+			}
+		}
+	}()
+
+	go func() {
+		<-done
+		// Unblock the read below once the shell is gone.
+		conn.Close()
+	}()
+	for {
+		typ, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if typ == websocket.TextMessage {
+			var msg terminalResize
+			if json.Unmarshal(data, &msg) == nil && msg.Type == "resize" && msg.Cols > 0 && msg.Rows > 0 {
+				pty.Setsize(ptmx, &pty.Winsize{Cols: msg.Cols, Rows: msg.Rows})
+			}
+			continue
+		}
+		if _, err := ptmx.Write(data); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nathfavour/remoter/tracing"
+)
+
+// tracer exports spans to the collector in Config.Tracing; it is nil, and
+// records nothing, without one.
+var tracer *tracing.Tracer
+
+// chunkTraceInterval is how often a chunk of each stream is followed from
+// the encoder to the last viewer it reaches; tracing every chunk would
+// swamp the collector.
+const chunkTraceInterval = time.Second
+
+var (
+	chunkTraced    = make(map[string]time.Time)
+	chunkTracedMux sync.Mutex
+)
+
+// traceChunk starts the span of a chunk of stream just read from its
+// encoder, if one is due.
+func traceChunk(stream string, size int) *tracing.Span {
+	if tracer == nil {
+		return nil
+	}
+	now := time.Now()
+	chunkTracedMux.Lock()
+	due := now.Sub(chunkTraced[stream]) >= chunkTraceInterval
+	if due {
+		chunkTraced[stream] = now
+	}
+	chunkTracedMux.Unlock()
+	if !due {
+		return nil
+	}
+	span := tracer.StartAt(context.Background(), "stream.chunk", tracing.KindProducer, now)
+	span.Set("remoter.stream", streamLabel(stream))
+	span.Set("remoter.chunk.bytes", size)
+	return span
+}
+
+// streamLabel names a hub key in spans, "main" for the main display.
+func streamLabel(key string) string {
+	if key == "" || strings.HasPrefix(key, "@") {
+		return "main" + key
+	}
+	return key
+}
+
+// traceRequests puts every request in a server span named after the
+// route it matched, continuing the caller's trace if it sent one.
+// WebSocket and streaming requests last as long as their connection.
+func traceRequests(next http.Handler) http.Handler {
+	if tracer == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, pattern := http.DefaultServeMux.Handler(r)
+		route := pattern
+		if _, path, ok := strings.Cut(pattern, " "); ok {
+			route = path
+		}
+		if route == "" {
+			route = r.URL.Path
+		}
+		ctx, span := tracer.Start(tracing.Extract(r.Context(), r.Header), r.Method+" "+route, tracing.KindServer)
+		span.Set("http.request.method", r.Method)
+		span.Set("http.route", route)
+		span.Set("url.path", r.URL.Path)
+		span.Set("client.address", r.RemoteAddr)
+		span.Set("user_agent.original", r.UserAgent())
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+		span.Set("http.response.status_code", rec.status)
+		if rec.status >= 500 {
+			span.Fail(fmt.Errorf("%d %s", rec.status, http.StatusText(rec.status)))
+		}
+		span.End()
+	})
+}
+
+// statusRecorder notes the status a handler answered with, passing
+// flushes and hijacks through for streams and WebSockets.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(r.ResponseWriter).Hijack()
+	if err == nil {
+		r.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// traceService times a start, stop or restart of one of the services.
+func traceService(action, name string, fn func() error) error {
+	_, span := tracer.Start(context.Background(), "service."+action, tracing.KindInternal)
+	span.Set("remoter.service", name)
+	err := fn()
+	span.Fail(err)
+	if name == "ffmpeg" && err == nil && span.Recording() {
+		st := services.encoder.Status()
+		span.Set("remoter.encoder.display", st.Display)
+		span.Set("remoter.encoder.res", st.Res)
+		span.Set("remoter.encoder.pid", st.PID)
+	}
+	span.End()
+	return err
+}
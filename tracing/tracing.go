@@ -0,0 +1,472 @@
+// Package tracing records OpenTelemetry spans and exports them in batches
+// to an OTLP/HTTP collector, encoded as JSON. It propagates W3C trace
+// context so requests can be followed in from a proxy or client.
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config is the collector spans are sent to.
+type Config struct {
+	// Endpoint is the collector's OTLP/HTTP base URL, e.g.
+	// http://localhost:4318; spans go to /v1/traces under it.
+	Endpoint string `json:"endpoint"`
+	// ServiceName is "remoter" by default.
+	ServiceName string `json:"service_name,omitempty"`
+	// Headers are sent with every export, e.g. an API key.
+	Headers map[string]string `json:"headers,omitempty"`
+	// SampleRatio is the share of traces started here that are recorded,
+	// 1 (all of them) by default; traces started upstream follow the
+	// caller's decision.
+	SampleRatio *float64 `json:"sample_ratio,omitempty"`
+}
+
+// Kind says how a span relates to others, as in OTLP.
+type Kind int
+
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+	KindClient   Kind = 3
+	KindProducer Kind = 4
+	KindConsumer Kind = 5
+)
+
+const (
+	// batchSize spans are sent at once, or whatever is pending every
+	// batchInterval.
+	batchSize     = 512
+	batchInterval = 5 * time.Second
+	// maxPending bounds the spans kept while the collector is unreachable;
+	// beyond it new ones are dropped.
+	maxPending = 8192
+)
+
+// Tracer starts spans and exports the finished ones. A nil *Tracer
+// records nothing, so callers need not check whether tracing is on.
+type Tracer struct {
+	url     string
+	cfg     Config
+	ratio   float64
+	client  *http.Client
+	mu      sync.Mutex
+	pending []*Span
+	dropped int
+	kick    chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// New validates cfg and starts exporting.
+func New(cfg Config) (*Tracer, error) {
+	u, err := url.Parse(cfg.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("tracing needs an http or https endpoint")
+	}
+	ratio := 1.0
+	if cfg.SampleRatio != nil {
+		ratio = *cfg.SampleRatio
+	}
+	if ratio < 0 || ratio > 1 {
+		return nil, fmt.Errorf("sample ratio must be between 0 and 1")
+	}
+	if cfg.ServiceName == "" {
+		cfg.ServiceName = "remoter"
+	}
+	t := &Tracer{
+		url:     strings.TrimSuffix(cfg.Endpoint, "/") + "/v1/traces",
+		cfg:     cfg,
+		ratio:   ratio,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		kick:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go t.run()
+	return t, nil
+}
+
+// Close sends what is pending, waiting at most timeout.
+func (t *Tracer) Close(timeout time.Duration) {
+	if t == nil {
+		return
+	}
+	close(t.done)
+	select {
+	case <-t.stopped:
+	case <-time.After(timeout):
+	}
+}
+
+// SpanContext identifies a span across process boundaries.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+func (sc SpanContext) valid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+type ctxKey struct{}
+
+// FromContext returns the span ctx carries, or nil.
+func FromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(ctxKey{}).(*Span)
+	return s
+}
+
+// ContextWith returns ctx carrying s, for starting its children.
+func ContextWith(ctx context.Context, s *Span) context.Context {
+	if s == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, ctxKey{}, s)
+}
+
+// Span is an operation being timed. A nil *Span ignores everything, and
+// one that was not sampled is only kept to hand its context on.
+type Span struct {
+	tracer *Tracer
+	sc     SpanContext
+	parent [8]byte
+	name   string
+	kind   Kind
+	start  time.Time
+
+	mu     sync.Mutex
+	end    time.Time
+	attrs  map[string]any
+	events []event
+	err    string
+}
+
+type event struct {
+	name  string
+	at    time.Time
+	attrs map[string]any
+}
+
+// Start begins a span as a child of the one in ctx, or of the remote
+// parent Extract put there, returning a context carrying it.
+func (t *Tracer) Start(ctx context.Context, name string, kind Kind) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	s := t.StartAt(ctx, name, kind, time.Now())
+	return context.WithValue(ctx, ctxKey{}, s), s
+}
+
+// StartAt is Start for an operation that began at start, without
+// deriving a context.
+func (t *Tracer) StartAt(ctx context.Context, name string, kind Kind, start time.Time) *Span {
+	if t == nil {
+		return nil
+	}
+	s := &Span{tracer: t, name: name, kind: kind, start: start}
+	if p := FromContext(ctx); p != nil {
+		s.sc.TraceID, s.sc.Sampled, s.parent = p.sc.TraceID, p.sc.Sampled, p.sc.SpanID
+	} else if rc, ok := ctx.Value(remoteKey{}).(SpanContext); ok {
+		s.sc.TraceID, s.sc.Sampled, s.parent = rc.TraceID, rc.Sampled, rc.SpanID
+	} else {
+		rand.Read(s.sc.TraceID[:])
+		s.sc.Sampled = t.sample(s.sc.TraceID)
+	}
+	rand.Read(s.sc.SpanID[:])
+	return s
+}
+
+// sample decides from the trace ID, so that every span of a trace
+// started here agrees.
+func (t *Tracer) sample(id [16]byte) bool {
+	if t.ratio >= 1 {
+		return true
+	}
+	return float64(binary.BigEndian.Uint64(id[8:])) < t.ratio*math.MaxUint64
+}
+
+// Context returns the span's identity, for Inject.
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+// Recording reports whether the span will be exported, so callers can
+// skip gathering attributes that would be thrown away.
+func (s *Span) Recording() bool {
+	return s != nil && s.sc.Sampled
+}
+
+// Set records an attribute: a string, bool, integer or float.
+func (s *Span) Set(key string, value any) {
+	if !s.Recording() {
+		return
+	}
+	s.mu.Lock()
+	if s.attrs == nil {
+		s.attrs = make(map[string]any)
+	}
+	s.attrs[key] = value
+	s.mu.Unlock()
+}
+
+// Event marks a moment in the span, with optional attributes as
+// key, value pairs.
+func (s *Span) Event(name string, kv ...any) {
+	if !s.Recording() {
+		return
+	}
+	e := event{name: name, at: time.Now()}
+	for i := 0; i+1 < len(kv); i += 2 {
+		if e.attrs == nil {
+			e.attrs = make(map[string]any)
+		}
+		e.attrs[fmt.Sprint(kv[i])] = kv[i+1]
+	}
+	s.mu.Lock()
+	s.events = append(s.events, e)
+	s.mu.Unlock()
+}
+
+// Fail marks the span as failed with err, if there is one.
+func (s *Span) Fail(err error) {
+	if err == nil || !s.Recording() {
+		return
+	}
+	s.mu.Lock()
+	s.err = err.Error()
+	s.mu.Unlock()
+}
+
+// End finishes the span and queues it for export. Only the first call
+// counts.
+func (s *Span) End() {
+	if !s.Recording() {
+		return
+	}
+	s.mu.Lock()
+	if !s.end.IsZero() {
+		s.mu.Unlock()
+		return
+	}
+	s.end = time.Now()
+	s.mu.Unlock()
+	s.tracer.queue(s)
+}
+
+func (t *Tracer) queue(s *Span) {
+	t.mu.Lock()
+	if len(t.pending) >= maxPending {
+		t.dropped++
+		t.mu.Unlock()
+		return
+	}
+	t.pending = append(t.pending, s)
+	full := len(t.pending) >= batchSize
+	t.mu.Unlock()
+	if full {
+		select {
+		case t.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (t *Tracer) run() {
+	defer close(t.stopped)
+	tick := time.NewTicker(batchInterval)
+	defer tick.Stop()
+	failing := false
+	for {
+		select {
+		case <-t.done:
+			t.flush()
+			return
+		case <-tick.C:
+		case <-t.kick:
+		}
+		err := t.flush()
+		if err != nil && !failing {
+			log.Printf("Warning: failed to export traces: %v", err)
+		} else if err == nil && failing {
+			log.Printf("Exporting traces again")
+		}
+		failing = err != nil
+	}
+}
+
+// flush sends the pending spans a batch at a time, keeping a batch the
+// collector did not take for the next try.
+func (t *Tracer) flush() error {
+	for {
+		t.mu.Lock()
+		n := min(len(t.pending), batchSize)
+		batch := t.pending[:n:n]
+		dropped := t.dropped
+		t.dropped = 0
+		t.mu.Unlock()
+		if dropped > 0 {
+			log.Printf("Warning: dropped %d spans the collector could not keep up with", dropped)
+		}
+		if n == 0 {
+			return nil
+		}
+		if err := t.export(batch); err != nil {
+			return err
+		}
+		t.mu.Lock()
+		t.pending = t.pending[n:]
+		t.mu.Unlock()
+	}
+}
+
+func (t *Tracer) export(batch []*Span) error {
+	spans := make([]map[string]any, 0, len(batch))
+	for _, s := range batch {
+		spans = append(spans, s.otlp())
+	}
+	body, err := json.Marshal(map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{"attributes": attributes(map[string]any{"service.name": t.cfg.ServiceName})},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": "github.com/nathfavour/remoter"},
+				"spans": spans,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range t.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector answered %s", resp.Status)
+	}
+	return nil
+}
+
+// otlp encodes the span as OTLP JSON: IDs in hex and times as strings of
+// Unix nanoseconds.
+func (s *Span) otlp() map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := map[string]any{
+		"traceId":           hex.EncodeToString(s.sc.TraceID[:]),
+		"spanId":            hex.EncodeToString(s.sc.SpanID[:]),
+		"name":              s.name,
+		"kind":              int(s.kind),
+		"startTimeUnixNano": fmt.Sprint(s.start.UnixNano()),
+		"endTimeUnixNano":   fmt.Sprint(s.end.UnixNano()),
+		"attributes":        attributes(s.attrs),
+	}
+	if s.parent != [8]byte{} {
+		m["parentSpanId"] = hex.EncodeToString(s.parent[:])
+	}
+	if len(s.events) > 0 {
+		events := make([]any, 0, len(s.events))
+		for _, e := range s.events {
+			events = append(events, map[string]any{
+				"name":         e.name,
+				"timeUnixNano": fmt.Sprint(e.at.UnixNano()),
+				"attributes":   attributes(e.attrs),
+			})
+		}
+		m["events"] = events
+	}
+	if s.err != "" {
+		m["status"] = map[string]any{"code": 2, "message": s.err}
+	}
+	return m
+}
+
+func attributes(attrs map[string]any) []any {
+	out := make([]any, 0, len(attrs))
+	for k, v := range attrs {
+		var value map[string]any
+		switch v := v.(type) {
+		case string:
+			value = map[string]any{"stringValue": v}
+		case bool:
+			value = map[string]any{"boolValue": v}
+		case int:
+			value = map[string]any{"intValue": fmt.Sprint(v)}
+		case int64:
+			value = map[string]any{"intValue": fmt.Sprint(v)}
+		case float64:
+			value = map[string]any{"doubleValue": v}
+		default:
+			value = map[string]any{"stringValue": fmt.Sprint(v)}
+		}
+		out = append(out, map[string]any{"key": k, "value": value})
+	}
+	return out
+}
+
+type remoteKey struct{}
+
+// Extract returns ctx carrying the parent named by the request's
+// traceparent header, if it has a valid one.
+func Extract(ctx context.Context, h http.Header) context.Context {
+	parts := strings.Split(strings.TrimSpace(h.Get("Traceparent")), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return ctx
+	}
+	var sc SpanContext
+	trace, err1 := hex.DecodeString(parts[1])
+	span, err2 := hex.DecodeString(parts[2])
+	flags, err3 := hex.DecodeString(parts[3])
+	if err1 != nil || err2 != nil || err3 != nil || len(trace) != 16 || len(span) != 8 || len(flags) != 1 {
+		return ctx
+	}
+	copy(sc.TraceID[:], trace)
+	copy(sc.SpanID[:], span)
+	sc.Sampled = flags[0]&1 == 1
+	if !sc.valid() {
+		return ctx
+	}
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// Inject sets the traceparent header naming the span in ctx, for a
+// request made on its behalf.
+func Inject(ctx context.Context, h http.Header) {
+	s := FromContext(ctx)
+	if s == nil {
+		return
+	}
+	flags := "00"
+	if s.sc.Sampled {
+		flags = "01"
+	}
+	h.Set("Traceparent", fmt.Sprintf("00-%x-%x-%s", s.sc.TraceID, s.sc.SpanID, flags))
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+
+	"github.com/nathfavour/remoter/i18n"
+)
+
+// transportOrder is the preference order clients walk when a transport
+// fails. WebRTC is listed so clients can negotiate it once the server
+// supports it; until then it is reported as unavailable and skipped, as
+// WebTransport is without an HTTP/3 listener.
+var transportOrder = []string{transportWebRTC, transportWebTransport, transportWebSocket, transportHTTP}
+
+var transportEndpoints = map[string]string{
+	transportWebSocket: "/ws",
+	transportHTTP:      "/live",
+}
+
+var (
+	fallbacks    = make(map[string]int)
+	fallbacksMux sync.Mutex
+)
+
+type transportInfo struct {
+	Name      string `json:"name"`
+	Available bool   `json:"available"`
+	Endpoint  string `json:"endpoint,omitempty"`
+}
+
+func availableTransports() []transportInfo {
+	infos := make([]transportInfo, 0, len(transportOrder))
+	for _, name := range transportOrder {
+		endpoint, ok := transportEndpoints[name]
+		infos = append(infos, transportInfo{Name: name, Available: ok, Endpoint: endpoint})
+	}
+	return infos
+}
+
+// nextTransport returns the first available transport after from in the
+// preference order.
+func nextTransport(from string) (transportInfo, bool) {
+	seen := from == ""
+	for _, t := range availableTransports() {
+		if !seen {
+			seen = t.Name == from
+			continue
+		}
+		if t.Available {
+			return t, true
+		}
+	}
+	return transportInfo{}, false
+}
+
+// recordFallback counts a connection that arrived after the client gave up
+// on another transport, signalled by ?fallback_from=<transport>. Names
+// that are not transports are ignored, so clients can't grow the counts
+// without bound.
+func recordFallback(r *http.Request, to string) {
+	from := r.URL.Query().Get("fallback_from")
+	if !slices.Contains(transportOrder, from) {
+		return
+	}
+	fallbacksMux.Lock()
+	fallbacks[from+"->"+to]++
+	fallbacksMux.Unlock()
+	log.Printf("Client %s fell back from %s to %s", r.RemoteAddr, from, to)
+}
+
+// handleLive streams the broadcast as a chunked HTTP response, the last
+// resort for clients that cannot use WebRTC or WebSocket.
+func handleLive(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		i18n.Error(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "GET")
+		return
+	}
+	if !streamExists(r.PathValue("session")) {
+		i18n.Error(w, r, http.StatusNotFound, "no_such_session")
+		return
+	}
+	if err := quotas.admitStream(requestAuth(r).User); err != nil {
+		i18n.Error(w, r, http.StatusForbidden, "quota_exceeded", err)
+		return
+	}
+
+	viewerDevices.identify(w.Header(), r)
+	w.Header().Set("Content-Type", "video/mpeg")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	c := newHTTPClient(w, r)
+	totalClients := addClient(c)
+	recordFallback(r, transportHTTP)
+	log.Printf("New HTTP stream client %s connected. Total clients: %d", c.describe(), totalClients)
+
+	select {
+	case <-r.Context().Done():
+		c.close()
+	case <-c.done:
+	}
+
+	totalClients = removeClient(c)
+	log.Printf("HTTP stream client %s disconnected. Total clients: %d", c.describe(), totalClients)
+}
+
+func handleTransports(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"transports": availableTransports()})
+}
+
+// handleTransportFallback is called by a client whose current transport
+// failed; it answers with the next transport to try.
+func handleTransportFallback(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		From   string `json:"from"`
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+
+	next, ok := nextTransport(req.From)
+	if !ok {
+		writeError(w, http.StatusServiceUnavailable, fmt.Errorf("no transport available after %q", req.From))
+		return
+	}
+	log.Printf("Client %s reported %s failure (%s), directing to %s", r.RemoteAddr, req.From, req.Reason, next.Name)
+	writeJSON(w, http.StatusOK, next)
+}
+
+func handleStats(w http.ResponseWriter, r *http.Request) {
+	fallbacksMux.Lock()
+	fb := make(map[string]int, len(fallbacks))
+	for k, v := range fallbacks {
+		fb[k] = v
+	}
+	fallbacksMux.Unlock()
+
+	stats := map[string]any{
+		"clients":    clientCount(),
+		"transports": transportCounts(),
+		"fallbacks":  fb,
+	}
+	if n := checksumErrors.Load(); n > 0 {
+		stats["checksum_errors"] = n
+	}
+	if st := degradeStatus(); st != nil {
+		stats["degradation"] = st
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
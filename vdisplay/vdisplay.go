@@ -0,0 +1,262 @@
+package vdisplay
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/nathfavour/remoter/proc"
+)
+
+// Config describes a headless display for servers without a monitor.
+type Config struct {
+	Enabled bool `json:"enabled"`
+	// Method is "xvfb" (default), which runs a display of its own, or
+	// "xrandr", which adds a mode to a virtual output of the running X
+	// server (the dummy driver's, or Intel's VIRTUAL1).
+	Method string `json:"method,omitempty"`
+	// Display is the display Xvfb creates, by default the first free one
+	// from :99.
+	Display string `json:"display,omitempty"`
+	Res     string `json:"res,omitempty"` // default 1920x1080
+	// Output is the XRandR output to use, by default the first VIRTUAL or
+	// DUMMY one.
+	Output string `json:"output,omitempty"`
+	// Desktop is a shell command run on an Xvfb display, e.g. "openbox".
+	Desktop string `json:"desktop,omitempty"`
+}
+
+// Display is a running virtual display. X and Y place an XRandR output
+// within its X screen; they are 0 for Xvfb.
+type Display struct {
+	Name   string `json:"display"`
+	Method string `json:"method"`
+	Res    string `json:"res"`
+	X      int    `json:"x"`
+	Y      int    `json:"y"`
+	Output string `json:"output,omitempty"`
+
+	procs proc.Group
+	mode  string // the XRandR mode added, removed by Close
+}
+
+// Start creates the display. main is the X display an XRandR output is
+// added to when the config names none.
+func Start(cfg Config, main string) (*Display, error) {
+	res := cfg.Res
+	if res == "" {
+		res = "1920x1080"
+	}
+	var w, h int
+	if _, err := fmt.Sscanf(res, "%dx%d", &w, &h); err != nil || w <= 0 || h <= 0 {
+		return nil, fmt.Errorf("invalid virtual display resolution %q", res)
+	}
+	res = fmt.Sprintf("%dx%d", w, h)
+
+	switch cfg.Method {
+	case "", "xvfb":
+		return startXvfb(cfg, res)
+	case "xrandr":
+		display := cfg.Display
+		if display == "" {
+			display = main
+		}
+		return startOutput(display, cfg.Output, w, h)
+	}
+	return nil, fmt.Errorf("unknown virtual display method %q", cfg.Method)
+}
+
+func displayInUse(display string) bool {
+	for _, p := range []string{"/tmp/.X" + display[1:] + "-lock", "/tmp/.X11-unix/X" + display[1:]} {
+		if _, err := os.Stat(p); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func startXvfb(cfg Config, res string) (*Display, error) {
+	display := cfg.Display
+	if display == "" {
+		for n := 99; ; n++ {
+			if display = fmt.Sprintf(":%d", n); !displayInUse(display) {
+				break
+			}
+		}
+	} else if !strings.HasPrefix(display, ":") {
+		return nil, fmt.Errorf("invalid X display %q", display)
+	} else if displayInUse(display) {
+		return nil, fmt.Errorf("display %s is already in use", display)
+	}
+
+	d := &Display{Name: display, Method: "xvfb", Res: res}
+	fmt.Printf("Starting Xvfb on %s at %s...\n", display, res)
+	if _, err := d.procs.Spawn(exec.Command("Xvfb", display, "-screen", "0", res+"x24", "-nolisten", "tcp")); err != nil {
+		return nil, fmt.Errorf("failed to start Xvfb: %w", err)
+	}
+	if err := waitForDisplay(display, 5*time.Second); err != nil {
+		d.Close()
+		return nil, err
+	}
+	if cfg.Desktop != "" {
+		cmd := exec.Command("sh", "-c", cfg.Desktop)
+		cmd.Env = append(os.Environ(), "DISPLAY="+display)
+		if _, err := d.procs.Spawn(cmd); err != nil {
+			fmt.Printf("Warning: failed to start desktop on %s: %v\n", display, err)
+		}
+	}
+	return d, nil
+}
+
+// waitForDisplay blocks until the X socket for display appears.
+func waitForDisplay(display string, timeout time.Duration) error {
+	sock := "/tmp/.X11-unix/X" + display[1:]
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if _, err := os.Stat(sock); err == nil {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return fmt.Errorf("display %s did not come up within %s", display, timeout)
+}
+
+func xrandr(display string, args ...string) (string, error) {
+	out, err := exec.Command("xrandr", append([]string{"-display", display}, args...)...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("xrandr %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return string(out), nil
+}
+
+// outputRe matches an output line of xrandr --query, with its geometry
+// when it is on.
+var outputRe = regexp.MustCompile(`(?m)^(\S+) (?:connected|disconnected)(?: primary)?(?: (\d+)x(\d+)\+(\d+)\+(\d+))?`)
+
+// OutputGeometry returns where an XRandR output, e.g. "HDMI-1", sits
+// within the X screen of display, for capturing one monitor of several.
+func OutputGeometry(display, output string) (x, y, w, h int, err error) {
+	query, err := xrandr(display, "--query")
+	if err != nil {
+		return 0, 0, 0, 0, err
+	}
+	for _, m := range outputRe.FindAllStringSubmatch(query, -1) {
+		if m[1] != output {
+			continue
+		}
+		if m[2] == "" {
+			return 0, 0, 0, 0, fmt.Errorf("output %s is off", output)
+		}
+		fmt.Sscan(m[2], &w)
+		fmt.Sscan(m[3], &h)
+		fmt.Sscan(m[4], &x)
+		fmt.Sscan(m[5], &y)
+		return x, y, w, h, nil
+	}
+	return 0, 0, 0, 0, fmt.Errorf("display %s has no output %q", display, output)
+}
+
+func startOutput(display, output string, w, h int) (*Display, error) {
+	query, err := xrandr(display, "--query")
+	if err != nil {
+		return nil, err
+	}
+	var others []string
+	for _, m := range outputRe.FindAllStringSubmatch(query, -1) {
+		virtual := strings.HasPrefix(m[1], "VIRTUAL") || strings.HasPrefix(m[1], "DUMMY")
+		if output == "" && virtual {
+			output = m[1]
+		} else if m[1] != output && m[2] != "" {
+			others = append(others, m[1])
+		}
+	}
+	if output == "" {
+		return nil, fmt.Errorf("display %s has no virtual output; use the dummy driver or name an output", display)
+	}
+
+	out, err := exec.Command("cvt", fmt.Sprint(w), fmt.Sprint(h), "60").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute a modeline: %w", err)
+	}
+	_, modeline, ok := strings.Cut(string(out), "Modeline ")
+	fields := strings.Fields(modeline)
+	if !ok || len(fields) < 2 {
+		return nil, fmt.Errorf("failed to parse cvt output")
+	}
+	mode := fmt.Sprintf("remoter-%dx%d", w, h)
+	// The mode may be left over from an earlier run.
+	xrandr(display, append([]string{"--newmode", mode}, fields[1:]...)...)
+	if _, err := xrandr(display, "--addmode", output, mode); err != nil {
+		return nil, err
+	}
+	args := []string{"--output", output, "--mode", mode}
+	if len(others) > 0 {
+		// Keep it from covering a real screen.
+		args = append(args, "--right-of", others[0])
+	}
+	d := &Display{Name: display, Method: "xrandr", Res: fmt.Sprintf("%dx%d", w, h), Output: output, mode: mode}
+	if _, err := xrandr(display, args...); err != nil {
+		d.Close()
+		return nil, err
+	}
+
+	query, err = xrandr(display, "--query")
+	if err != nil {
+		d.Close()
+		return nil, err
+	}
+	for _, m := range outputRe.FindAllStringSubmatch(query, -1) {
+		if m[1] == output && m[2] != "" {
+			fmt.Sscan(m[4], &d.X)
+			fmt.Sscan(m[5], &d.Y)
+			fmt.Printf("Virtual output %s on %s at %s+%d+%d\n", output, display, d.Res, d.X, d.Y)
+			return d, nil
+		}
+	}
+	d.Close()
+	return nil, fmt.Errorf("output %s did not come on", output)
+}
+
+// Resize changes the display's resolution in place: the Xvfb screen
+// through RandR, or the output's mode.
+func (d *Display) Resize(w, h int) error {
+	res := fmt.Sprintf("%dx%d", w, h)
+	if d.mode == "" {
+		if _, err := xrandr(d.Name, "--fb", res); err != nil {
+			return err
+		}
+		d.Res = res
+		return nil
+	}
+
+	next, err := startOutput(d.Name, d.Output, w, h)
+	if err != nil {
+		return err
+	}
+	if next.mode != d.mode {
+		xrandr(d.Name, "--delmode", d.Output, d.mode)
+		xrandr(d.Name, "--rmmode", d.mode)
+	}
+	d.Res, d.X, d.Y, d.mode = next.Res, next.X, next.Y, next.mode
+	return nil
+}
+
+// Close stops Xvfb, or turns the XRandR output off again.
+func (d *Display) Close() {
+	d.procs.Terminate(3 * time.Second)
+	if d.mode == "" {
+		return
+	}
+	for _, args := range [][]string{
+		{"--output", d.Output, "--off"},
+		{"--delmode", d.Output, d.mode},
+		{"--rmmode", d.mode},
+	} {
+		if _, err := xrandr(d.Name, args...); err != nil {
+			fmt.Printf("Warning: %v\n", err)
+		}
+	}
+}
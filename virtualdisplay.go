@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/nathfavour/remoter/ffmpeg"
+	"github.com/nathfavour/remoter/vdisplay"
+)
+
+// virtualDisplay is the headless display the main stream shows, when one
+// is configured. It overrides the configured display without replacing it
+// in the config file.
+var virtualDisplay *vdisplay.Display
+
+// mainDisplay is the X display the main stream shows.
+func mainDisplay(cfg *Config) string {
+	if virtualDisplay != nil {
+		return virtualDisplay.Name
+	}
+	return cfg.Display
+}
+
+// mainRes is the resolution captured for the main stream.
+func mainRes(cfg *Config) string {
+	if virtualDisplay != nil {
+		return virtualDisplay.Res + "x24"
+	}
+	return cfg.Res
+}
+
+// mainCapture limits capture to the virtual output on a shared X screen;
+// a configured capture area is then relative to that output.
+func mainCapture(cfg *Config) *ffmpeg.Region {
+	vd := virtualDisplay
+	if vd == nil || vd.Method != "xrandr" {
+		return cfg.Capture
+	}
+	var w, h int
+	if _, err := fmt.Sscanf(vd.Res, "%dx%d", &w, &h); err != nil {
+		return cfg.Capture
+	}
+	area := ffmpeg.Region{X: vd.X, Y: vd.Y, W: w, H: h}
+	if c := cfg.Capture; c != nil {
+		area = ffmpeg.Region{X: vd.X + c.X, Y: vd.Y + c.Y, W: min(c.W, w-c.X), H: min(c.H, h-c.Y)}
+	}
+	return &area
+}
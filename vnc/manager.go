@@ -0,0 +1,147 @@
+package vnc
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// firstDisplay is the lowest X display number handed out to desktops,
+// leaving :0 and :1 to the physical display and the VNC service.
+const firstDisplay = 2
+
+// basePort is x11vnc's usual port for display :0; each desktop listens on
+// basePort plus its display number.
+const basePort = 5900
+
+var resRe = regexp.MustCompile(`^\d+x\d+(x\d+)?$`)
+
+// Desktop is a snapshot of one of a Manager's desktops.
+type Desktop struct {
+	ID string `json:"id"`
+	Status
+}
+
+// Manager runs any number of independent VNC desktops, each on its own
+// Xvfb display and x11vnc port.
+type Manager struct {
+	mu       sync.Mutex
+	desktops map[string]*Server
+}
+
+func NewManager() *Manager {
+	return &Manager{desktops: make(map[string]*Server)}
+}
+
+// displayInUse reports whether an X server already owns display n,
+// ignoring lock files of servers that are gone.
+func displayInUse(n int) bool {
+	data, err := os.ReadFile(fmt.Sprintf("/tmp/.X%d-lock", n))
+	if err != nil {
+		_, err := os.Stat(fmt.Sprintf("/tmp/.X11-unix/X%d", n))
+		return err == nil
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	return err != nil || syscall.Kill(pid, 0) != syscall.ESRCH
+}
+
+func (m *Manager) allocDisplay() int {
+	used := make(map[string]bool, len(m.desktops))
+	for _, s := range m.desktops {
+		used[s.display] = true
+	}
+	for n := firstDisplay; ; n++ {
+		if !used[":"+strconv.Itoa(n)] && !displayInUse(n) {
+			return n
+		}
+	}
+}
+
+// Create starts a desktop at res (such as "1280x720x24") running apps,
+// shell commands the caller trusts, or the default openbox desktop when
+// apps is empty. Its x11vnc listens on loopback only, with a password.
+func (m *Manager) Create(res string, apps []string) (Desktop, error) {
+	if res == "" {
+		res = "1920x1080x24"
+	}
+	if !resRe.MatchString(res) {
+		return Desktop{}, fmt.Errorf("invalid resolution %q", res)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := m.allocDisplay()
+	s := &Server{display: ":" + strconv.Itoa(n), res: res, apps: apps, port: basePort + n}
+	if err := s.Start(); err != nil {
+		return Desktop{}, err
+	}
+	b := make([]byte, 6)
+	_, _ = rand.Read(b)
+	id := hex.EncodeToString(b)
+	m.desktops[id] = s
+	return Desktop{ID: id, Status: s.Status()}, nil
+}
+
+// List returns every desktop, oldest first.
+func (m *Manager) List() []Desktop {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]Desktop, 0, len(m.desktops))
+	for id, s := range m.desktops {
+		out = append(out, Desktop{ID: id, Status: s.Status()})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].StartedAt.Before(out[j].StartedAt) })
+	return out
+}
+
+func (m *Manager) Get(id string) (Desktop, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.desktops[id]
+	if !ok {
+		return Desktop{}, false
+	}
+	return Desktop{ID: id, Status: s.Status()}, true
+}
+
+// Destroy stops the desktop with the given id.
+func (m *Manager) Destroy(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.desktops[id]
+	if !ok {
+		return fmt.Errorf("no desktop with id %q", id)
+	}
+	delete(m.desktops, id)
+	return s.Stop()
+}
+
+// DestroyAll stops every desktop, used on shutdown.
+func (m *Manager) DestroyAll() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, s := range m.desktops {
+		_ = s.Stop()
+		delete(m.desktops, id)
+	}
+}
+
+// Reap forgets desktops whose Xvfb or x11vnc exited, returning their ids.
+func (m *Manager) Reap() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var reaped []string
+	for id, s := range m.desktops {
+		if s.Reap() {
+			delete(m.desktops, id)
+			reaped = append(reaped, id)
+		}
+	}
+	return reaped
+}
@@ -1,13 +1,93 @@
 package vnc
 
 import (
+	"crypto/des"
+	"crypto/rand"
+	"encoding/base64"
 	"fmt"
-	"log"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
 	"time"
+
+	"github.com/nathfavour/remoter/proc"
 )
 
+// Status is a snapshot of the VNC service state.
+type Status struct {
+	Running   bool      `json:"running"`
+	Display   string    `json:"display"`
+	Res       string    `json:"res"`
+	Port      int       `json:"port,omitempty"`
+	Password  string    `json:"password,omitempty"`
+	Apps      []string  `json:"apps,omitempty"`
+	StartedAt time.Time `json:"started_at,omitempty"`
+}
+
+// Server manages the Xvfb display, desktop applications and x11vnc
+// processes that make up the VNC service.
+type Server struct {
+	mu        sync.Mutex
+	display   string
+	res       string
+	apps      []string // nil for the default desktop
+	port      int      // 0 lets x11vnc pick
+	procs     proc.Group
+	xvfb      *proc.Proc // nil when Xvfb was already running
+	x11vnc    *proc.Proc
+	running   bool
+	startedAt time.Time
+	// tmpDir holds x11vnc's password file and the default desktop's
+	// helper scripts while it runs.
+	tmpDir   string
+	password string
+}
+
+func NewServer(display, res string) *Server {
+	return &Server{display: display, res: res}
+}
+
+// profilePath and xtermPath are the helper scripts of the default desktop
+// on the server's display.
+func (s *Server) profilePath() string {
+	return filepath.Join(s.tmpDir, "profile")
+}
+
+func (s *Server) xtermPath() string {
+	return filepath.Join(s.tmpDir, "xterm.sh")
+}
+
+func (s *Server) passwdPath() string {
+	return filepath.Join(s.tmpDir, "passwd")
+}
+
+// vncKey is the fixed DES key VNC password files are obscured with, its
+// bits in the order crypto/des expects.
+var vncKey = []byte{0xe8, 0x4a, 0xd6, 0x60, 0xc4, 0x72, 0x1a, 0xe0}
+
+// writePasswd picks a random password of the 8 characters VNC reads and
+// stores it at path for x11vnc's -rfbauth.
+func writePasswd(path string) (string, error) {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	password := base64.RawURLEncoding.EncodeToString(b)
+	block, err := des.NewCipher(vncKey)
+	if err != nil {
+		return "", err
+	}
+	obscured := make([]byte, des.BlockSize)
+	block.Encrypt(obscured, []byte(password))
+	if err := os.WriteFile(path, obscured, 0600); err != nil {
+		return "", err
+	}
+	return password, nil
+}
+
 func ensureInstalled(pkg string) error {
 	cmd := exec.Command("which", pkg)
 	if err := cmd.Run(); err != nil {
@@ -20,43 +100,85 @@ func ensureInstalled(pkg string) error {
 	return nil
 }
 
-func startXvfb(display, res string) error {
-	cmd := exec.Command("pgrep", "-f", "Xvfb "+display)
+func (s *Server) spawn(cmd *exec.Cmd) error {
+	_, err := s.procs.Spawn(cmd)
+	return err
+}
+
+func (s *Server) startXvfb(display, res string) error {
+	// Anchored, so that :1 doesn't match an Xvfb on :10.
+	cmd := exec.Command("pgrep", "-f", "Xvfb "+regexp.QuoteMeta(display)+"( |$)")
 	if err := cmd.Run(); err != nil {
 		fmt.Println("Starting Xvfb...")
-		return exec.Command("Xvfb", display, "-screen", "0", res).Start()
+		p, err := s.procs.Spawn(exec.Command("Xvfb", display, "-screen", "0", res))
+		s.xvfb = p
+		return err
 	}
 	return nil
 }
 
-func startX11vnc(display string) error {
+func (s *Server) startX11vnc(display string) error {
 	fmt.Println("Starting x11vnc...")
-	return exec.Command("x11vnc", "-display", display, "-forever").Start()
+	// Only over loopback and with a password: the VNC port must not be a
+	// way around remoter's own login.
+	password, err := writePasswd(s.passwdPath())
+	if err != nil {
+		return fmt.Errorf("failed to write password file: %w", err)
+	}
+	s.password = password
+	args := []string{"-display", display, "-forever", "-localhost", "-rfbauth", s.passwdPath()}
+	if s.port > 0 {
+		args = append(args, "-rfbport", strconv.Itoa(s.port))
+	}
+	p, err := s.procs.Spawn(exec.Command("x11vnc", args...))
+	s.x11vnc = p
+	return err
 }
 
-func startDesktop(display string) error {
+// startApps runs the server's own desktop apps, each a shell command from
+// the config. The first, usually the window manager, must start.
+func (s *Server) startApps(display string) error {
+	fmt.Println("Starting desktop apps...")
+	for i, app := range s.apps {
+		cmd := exec.Command("sh", "-c", app)
+		cmd.Env = append(os.Environ(), "DISPLAY="+display)
+		if err := s.spawn(cmd); err != nil {
+			if i == 0 {
+				return err
+			}
+			fmt.Printf("Warning: Failed to start %q: %v\n", app, err)
+		}
+		if i == 0 {
+			time.Sleep(1 * time.Second)
+		}
+	}
+	return nil
+}
+
+func (s *Server) startDesktop(display string) error {
+	if len(s.apps) > 0 {
+		return s.startApps(display)
+	}
 	fmt.Println("Starting desktop environment...")
 
 	profileScript := `export DISPLAY=` + display + `
 export XAUTHORITY=/tmp/.X` + display[1:] + `-auth
 `
-	profilePath := "/tmp/vnc_profile"
-	if err := os.WriteFile(profilePath, []byte(profileScript), 0644); err != nil {
+	if err := os.WriteFile(s.profilePath(), []byte(profileScript), 0600); err != nil {
 		return err
 	}
 
 	xtermScript := `#!/bin/bash
-source /tmp/vnc_profile
-exec xterm -e "bash --rcfile /tmp/vnc_profile"
+source ` + s.profilePath() + `
+exec xterm -e "bash --rcfile ` + s.profilePath() + `"
 `
-	xtermPath := "/tmp/vnc_xterm.sh"
-	if err := os.WriteFile(xtermPath, []byte(xtermScript), 0755); err != nil {
+	if err := os.WriteFile(s.xtermPath(), []byte(xtermScript), 0700); err != nil {
 		return err
 	}
 
 	cmd1 := exec.Command("openbox")
 	cmd1.Env = append(os.Environ(), "DISPLAY="+display)
-	if err := cmd1.Start(); err != nil {
+	if err := s.spawn(cmd1); err != nil {
 		return err
 	}
 
@@ -64,45 +186,145 @@ exec xterm -e "bash --rcfile /tmp/vnc_profile"
 
 	cmd2 := exec.Command("pcmanfm", "--desktop")
 	cmd2.Env = append(os.Environ(), "DISPLAY="+display)
-	if err := cmd2.Start(); err != nil {
+	if err := s.spawn(cmd2); err != nil {
 		fmt.Printf("Warning: Failed to start file manager: %v\n", err)
 	}
 
 	cmd3 := exec.Command("tint2")
 	cmd3.Env = append(os.Environ(), "DISPLAY="+display)
-	if err := cmd3.Start(); err != nil {
+	if err := s.spawn(cmd3); err != nil {
 		fmt.Printf("Warning: Failed to start panel: %v\n", err)
 	}
 
-	cmd4 := exec.Command(xtermPath)
+	cmd4 := exec.Command(s.xtermPath())
 	cmd4.Env = append(os.Environ(), "DISPLAY="+display)
-	if err := cmd4.Start(); err != nil {
+	if err := s.spawn(cmd4); err != nil {
 		fmt.Printf("Warning: Failed to start terminal: %v\n", err)
 	}
 
 	return nil
 }
 
-func StartVNC(display, res string) error {
-	for _, pkg := range []string{"x11vnc", "xvfb", "openbox", "pcmanfm", "xterm", "tint2"} {
+// Start installs missing packages and launches Xvfb, the desktop and x11vnc.
+func (s *Server) Start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running {
+		return fmt.Errorf("VNC is already running")
+	}
+
+	pkgs := []string{"x11vnc", "xvfb"}
+	if len(s.apps) == 0 {
+		pkgs = append(pkgs, "openbox", "pcmanfm", "xterm", "tint2")
+	}
+	for _, pkg := range pkgs {
 		if err := ensureInstalled(pkg); err != nil {
-			log.Fatalf("Failed to install %s: %v", pkg, err)
+			return fmt.Errorf("Failed to install %s: %w", pkg, err)
 		}
 	}
 
-	if err := startXvfb(display, res); err != nil {
+	// A directory of its own, rather than fixed names in /tmp that anyone
+	// could have planted a symlink at.
+	dir, err := os.MkdirTemp("", "remoter-vnc-"+s.display[1:]+"-")
+	if err != nil {
+		return fmt.Errorf("Failed to create scripts directory: %w", err)
+	}
+	s.tmpDir = dir
+
+	if err := s.startXvfb(s.display, s.res); err != nil {
+		s.kill()
 		return fmt.Errorf("Failed to start Xvfb: %w", err)
 	}
 	time.Sleep(2 * time.Second)
 
-	if err := startDesktop(display); err != nil {
+	if err := s.startDesktop(s.display); err != nil {
+		s.kill()
 		return fmt.Errorf("Failed to start desktop: %w", err)
 	}
 	time.Sleep(2 * time.Second)
 
-	if err := startX11vnc(display); err != nil {
+	if err := s.startX11vnc(s.display); err != nil {
+		s.kill()
 		return fmt.Errorf("Failed to start x11vnc: %w", err)
 	}
 
+	s.running = true
+	s.startedAt = time.Now()
+	return nil
+}
+
+// kill terminates every process started by this server and removes the
+// helper scripts written for the desktop. Xvfb instances that were already
+// running are left alone.
+func (s *Server) kill() {
+	s.procs.Terminate(3 * time.Second)
+	s.xvfb = nil
+	s.x11vnc = nil
+	s.password = ""
+	if s.tmpDir != "" {
+		if err := os.RemoveAll(s.tmpDir); err != nil {
+			fmt.Printf("Warning: failed to remove %s: %v\n", s.tmpDir, err)
+		}
+		s.tmpDir = ""
+	}
+}
+
+// Stop terminates the processes started by Start.
+func (s *Server) Stop() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.running {
+		return fmt.Errorf("VNC is not running")
+	}
+	fmt.Println("Stopping VNC service...")
+	s.kill()
+	s.running = false
 	return nil
 }
+
+// Reap tears the service down if it has been orphaned: x11vnc exited while
+// the display is still up, or the Xvfb it started went away underneath the
+// desktop. It reports whether a teardown happened.
+func (s *Server) Reap() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.running {
+		return false
+	}
+
+	var reason string
+	switch {
+	case s.x11vnc != nil && s.x11vnc.Exited():
+		reason = "x11vnc exited"
+	case s.xvfb != nil && s.xvfb.Exited():
+		reason = "Xvfb exited"
+	default:
+		return false
+	}
+
+	fmt.Printf("VNC service orphaned (%s), tearing down...\n", reason)
+	s.kill()
+	s.running = false
+	return true
+}
+
+// Restart stops the service if it is running and starts it again.
+func (s *Server) Restart() error {
+	if s.Status().Running {
+		if err := s.Stop(); err != nil {
+			return err
+		}
+	}
+	return s.Start()
+}
+
+func (s *Server) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := Status{Running: s.running, Display: s.display, Res: s.res, Port: s.port, Apps: s.apps}
+	if s.running {
+		st.StartedAt = s.startedAt
+		st.Password = s.password
+	}
+	return st
+}
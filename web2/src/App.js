@@ -1,16 +1,344 @@
 import React, { useEffect, useRef, useState } from "react";
 import JSMpeg from 'jsmpeg';
 
+// lz4Block decompresses one LZ4 block of known size.
+function lz4Block(src, size) {
+  const dst = new Uint8Array(size);
+  let i = 0;
+  let o = 0;
+  const length = (n) => {
+    if (n === 15) {
+      let b;
+      do {
+        b = src[i++];
+        n += b;
+      } while (b === 255);
+    }
+    return n;
+  };
+  while (i < src.length) {
+    const token = src[i++];
+    const literals = length(token >> 4);
+    dst.set(src.subarray(i, i + literals), o);
+    i += literals;
+    o += literals;
+    if (i >= src.length) {
+      break;
+    }
+    const offset = src[i] | (src[i + 1] << 8);
+    i += 2;
+    const n = length(token & 15) + 4;
+    for (let k = 0; k < n; k++, o++) {
+      dst[o] = dst[o - offset];
+    }
+  }
+  return dst;
+}
+
+// rawPixels turns a raw tile (RGB, or a palette and indices) into RGBA.
+function rawPixels(data, w, h) {
+  const rgba = new Uint8ClampedArray(w * h * 4);
+  if (data[0] === 1) {
+    const colors = data[1] + 1;
+    const indices = 2 + colors * 3;
+    for (let p = 0; p < w * h; p++) {
+      const c = 2 + data[indices + p] * 3;
+      rgba.set([data[c], data[c + 1], data[c + 2], 255], p * 4);
+    }
+  } else {
+    for (let p = 0; p < w * h; p++) {
+      rgba.set([data[1 + p * 3], data[2 + p * 3], data[3 + p * 3], 255], p * 4);
+    }
+  }
+  return new ImageData(rgba, w, h);
+}
+
 function App() {
   const canvasRef = useRef(null);
+  const cursorRef = useRef(null);
   const [status, setStatus] = useState("Connecting...");
+  const [handover, setHandover] = useState(null);
+  const [chat, setChat] = useState(null);
+  const [draft, setDraft] = useState("");
+  const chatSocket = useRef(null);
+  const [control, setControl] = useState(null);
+  const controlSocket = useRef(null);
+
+  // handOver asks for a code that moves this viewer, control included, to
+  // another device, which opens /handover/{code} within two minutes.
+  const handOver = () => {
+    const match = window.location.pathname.match(/^\/s\/([^/]+)\//);
+    fetch("/handover", {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify({ session: match ? match[1] : "" }),
+    })
+      .then((res) => (res.ok ? res.json() : Promise.reject(res.statusText)))
+      .then(setHandover)
+      .catch(() => setHandover({ error: true }));
+  };
+
+  // The chat panel shows once the server accepts the chat socket, which
+  // it only does when chat is enabled.
+  useEffect(() => {
+    const match = window.location.pathname.match(/^\/s\/([^/]+)\//);
+    const scheme = window.location.protocol === "https:" ? "wss" : "ws";
+    const name = new URLSearchParams(window.location.search).get("name");
+    const query = name ? `?name=${encodeURIComponent(name)}` : "";
+    const socket = new WebSocket(`${scheme}://${window.location.host}${match ? `/s/${match[1]}/chat` : "/chat"}${query}`);
+    socket.onopen = () => setChat([]);
+    socket.onmessage = (msg) => {
+      const m = JSON.parse(msg.data);
+      setChat((prev) => [...(prev || []), m].slice(-50));
+    };
+    chatSocket.current = socket;
+    return () => socket.close();
+  }, []);
+
+  // One viewer at a time holds control: only their pointer and keys reach
+  // the desktop. Viewers without the control role are refused the socket
+  // and never see the buttons.
+  useEffect(() => {
+    const match = window.location.pathname.match(/^\/s\/([^/]+)\//);
+    const scheme = window.location.protocol === "https:" ? "wss" : "ws";
+    const name = new URLSearchParams(window.location.search).get("name");
+    const query = name ? `?name=${encodeURIComponent(name)}` : "";
+    const socket = new WebSocket(`${scheme}://${window.location.host}${match ? `/s/${match[1]}/control` : "/control"}${query}`);
+    socket.onmessage = (msg) => {
+      const m = JSON.parse(msg.data);
+      if (m.type === "control") {
+        setControl(m);
+      }
+    };
+    controlSocket.current = socket;
+    return () => socket.close();
+  }, []);
+
+  const holding = control && control.holder && control.holder.id === control.you;
+  const sendControl = (msg) => {
+    if (controlSocket.current && controlSocket.current.readyState === WebSocket.OPEN) {
+      controlSocket.current.send(JSON.stringify(msg));
+    }
+  };
+
+  // Pointer positions go as fractions of the frame, which the server maps
+  // to the desktop whatever size the stream is scaled to.
+  const lastMove = useRef(0);
+  const pointerInput = (type) => (e) => {
+    if (!holding) {
+      return;
+    }
+    const now = Date.now();
+    if (type === "move" && now - lastMove.current < 50) {
+      return;
+    }
+    lastMove.current = now;
+    const rect = e.currentTarget.getBoundingClientRect();
+    sendControl({
+      input: {
+        type,
+        x: (e.clientX - rect.left) / rect.width,
+        y: (e.clientY - rect.top) / rect.height,
+        button: e.button === 2 ? 3 : e.button === 1 ? 2 : 1,
+      },
+    });
+  };
+
+  useEffect(() => {
+    if (!holding) {
+      return undefined;
+    }
+    const keys = { Enter: "Return", Backspace: "BackSpace", Escape: "Escape", Tab: "Tab", ArrowUp: "Up", ArrowDown: "Down", ArrowLeft: "Left", ArrowRight: "Right", Delete: "Delete", Home: "Home", End: "End", PageUp: "Prior", PageDown: "Next" };
+    const onKey = (e) => {
+      if (e.target.tagName === "INPUT") {
+        return;
+      }
+      const mods = [e.ctrlKey && "ctrl", e.altKey && "alt", e.metaKey && "super"].filter(Boolean);
+      if (keys[e.key] || mods.length) {
+        const key = keys[e.key] || (e.key.length === 1 ? e.key.toLowerCase() : null);
+        if (key) {
+          sendControl({ input: { type: "key", keys: [[...mods, key].join("+")] } });
+        }
+      } else if (e.key.length === 1) {
+        sendControl({ input: { type: "type", text: e.key } });
+      } else {
+        return;
+      }
+      e.preventDefault();
+    };
+    window.addEventListener("keydown", onKey);
+    return () => window.removeEventListener("keydown", onKey);
+  }, [holding]);
+
+  const sendChat = (e) => {
+    e.preventDefault();
+    if (draft.trim() && chatSocket.current) {
+      chatSocket.current.send(JSON.stringify({ text: draft }));
+      setDraft("");
+    }
+  };
 
   useEffect(() => {
     let player = null;
+    let cursorSocket = null;
+    let sound = null;
+    let rawImage = null;
+    let deltaSocket = null;
 
     const initializePlayer = () => {
       try {
-        const url = `ws://${window.location.host}/ws`;
+        // Session share links open /s/{session}/view; watch that
+        // session's stream instead of the main display.
+        const match = window.location.pathname.match(/^\/s\/([^/]+)\//);
+        const path = match ? `/s/${match[1]}/ws` : "/ws";
+        const scheme = window.location.protocol === "https:" ? "wss" : "ws";
+        // ?quality=low on the page picks a lower quality tier.
+        const quality = new URLSearchParams(window.location.search).get("quality");
+        const probe = new URLSearchParams();
+        if (quality) {
+          probe.set("quality", quality);
+        }
+        // The server remembers this browser's screen and codecs and picks
+        // its tier from them, so they are only reported on the first visit.
+        if (!window.localStorage.getItem("remoter-probed")) {
+          const ratio = window.devicePixelRatio || 1;
+          probe.set("screen", `${Math.round(window.screen.width * ratio)}x${Math.round(window.screen.height * ratio)}`);
+          const codecs = ["mpeg1"];
+          if (window.MediaSource) {
+            for (const [name, type] of [["vp8", 'video/webm; codecs="vp8"'], ["vp9", 'video/webm; codecs="vp9"'], ["h264", 'video/mp4; codecs="avc1.42E01E"']]) {
+              if (window.MediaSource.isTypeSupported(type)) {
+                codecs.push(name);
+              }
+            }
+          }
+          probe.set("codecs", codecs.join(","));
+          window.localStorage.setItem("remoter-probed", "1");
+        }
+        const query = probe.toString() ? `?${probe}` : "";
+        const url = `${scheme}://${window.location.host}${path}${query}`;
+
+        // A stream whose encoder has not produced a frame yet holds new
+        // viewers back until it does; say so instead of a black screen.
+        let starting = false;
+        let decoded = false;
+        const metaPath = match ? `/s/${match[1]}/meta` : "/meta";
+        fetch(metaPath)
+          .then((res) => (res.ok ? res.json() : null))
+          .then((meta) => {
+            if (meta && meta.title) {
+              document.title = meta.title;
+            }
+            if (meta && meta.ready === false && !decoded) {
+              starting = true;
+              setStatus("Stream starting, waiting for the first frame...");
+            }
+          })
+          .catch(() => {});
+        // ?cursor=local draws the pointer from the cursor channel, for
+        // servers configured with hide_cursor.
+        const params = new URLSearchParams(window.location.search);
+        if (params.get("cursor") === "local") {
+          const cursorPath = match ? `/s/${match[1]}/cursor` : "/cursor";
+          cursorSocket = new WebSocket(`${scheme}://${window.location.host}${cursorPath}`);
+          let hot = { x: 0, y: 0 };
+          cursorSocket.onmessage = (msg) => {
+            const ev = JSON.parse(msg.data);
+            const overlay = cursorRef.current;
+            const video = canvasRef.current;
+            if (!overlay || !video) {
+              return;
+            }
+            if (ev.type === "shape") {
+              const bytes = Uint8ClampedArray.from(atob(ev.rgba), (c) => c.charCodeAt(0));
+              overlay.width = ev.w;
+              overlay.height = ev.h;
+              overlay.getContext("2d").putImageData(new ImageData(bytes, ev.w, ev.h), 0, 0);
+              hot = { x: ev.xhot || 0, y: ev.yhot || 0 };
+            } else if (ev.type === "pos" && video.width) {
+              const scale = video.clientWidth / video.width;
+              overlay.style.transform = `scale(${scale})`;
+              overlay.style.left = `${video.offsetLeft + ((ev.x || 0) - hot.x) * scale}px`;
+              overlay.style.top = `${video.offsetTop + ((ev.y || 0) - hot.y) * scale}px`;
+            }
+          };
+        }
+
+        // ?audio=1 plays the main display's sound. Browsers only start
+        // audible playback after a user gesture, so retry on the first click.
+        if (!match && params.get("audio") === "1") {
+          sound = new Audio("/audio");
+          sound.play().catch(() => {
+            document.addEventListener("click", () => sound && sound.play(), { once: true });
+          });
+        }
+
+        // ?raw=1 shows the JPEG frames of /mjpeg, for servers without
+        // ffmpeg.
+        if (params.get("raw") === "1") {
+          const raw = document.createElement("img");
+          raw.src = match ? `/s/${match[1]}/mjpeg` : "/mjpeg";
+          raw.style.maxWidth = "100%";
+          raw.style.maxHeight = "100%";
+          raw.onload = () => setStatus(`Live (raw) - ${raw.naturalWidth}x${raw.naturalHeight}`);
+          raw.onerror = () => setStatus("Raw capture unavailable");
+          canvasRef.current.replaceWith(raw);
+          rawImage = raw;
+          return;
+        }
+
+        // ?delta=1 paints only the tiles that changed, from /delta;
+        // &encoding=lz4 (or raw) keeps them lossless without PNG decoding.
+        if (params.get("delta") === "1") {
+          const deltaPath = match ? `/s/${match[1]}/delta` : "/delta";
+          const encoding = params.get("encoding") || "png";
+          deltaSocket = new WebSocket(`${scheme}://${window.location.host}${deltaPath}?encoding=${encodeURIComponent(encoding)}`);
+          deltaSocket.binaryType = "arraybuffer";
+          let painting = Promise.resolve();
+          deltaSocket.onmessage = (msg) => {
+            const canvas = canvasRef.current;
+            if (!canvas) {
+              return;
+            }
+            if (typeof msg.data === "string") {
+              const init = JSON.parse(msg.data);
+              canvas.width = init.w;
+              canvas.height = init.h;
+              setStatus(`Live (delta) - ${init.w}x${init.h}`);
+              return;
+            }
+            // Each tile is x, y, w, h (uint16), a data length (uint32) and
+            // the data.
+            const view = new DataView(msg.data);
+            const ctx = canvas.getContext("2d");
+            for (let off = 0; off + 12 <= view.byteLength; ) {
+              const x = view.getUint16(off);
+              const y = view.getUint16(off + 2);
+              const w = view.getUint16(off + 4);
+              const h = view.getUint16(off + 6);
+              const len = view.getUint32(off + 8);
+              if (encoding !== "png") {
+                let data = new Uint8Array(msg.data, off + 12, len);
+                if (encoding === "lz4") {
+                  data = lz4Block(data.subarray(4), new DataView(msg.data, off + 12, 4).getUint32(0));
+                }
+                const pixels = rawPixels(data, w, h);
+                painting = painting.then(() => ctx.putImageData(pixels, x, y));
+                off += 12 + len;
+                continue;
+              }
+              const png = new Blob([msg.data.slice(off + 12, off + 12 + len)], { type: "image/png" });
+              // Decoding is asynchronous; keep tiles in order.
+              painting = painting
+                .then(() => createImageBitmap(png))
+                .then((bitmap) => ctx.drawImage(bitmap, x, y))
+                .catch(() => {});
+              off += 12 + len;
+            }
+          };
+          deltaSocket.onerror = () => setStatus("Delta updates unavailable");
+          return;
+        }
+
         console.log("Connecting to:", url);
         setStatus(`Connecting to ${url}`);
 
@@ -20,10 +348,11 @@ function App() {
           autoplay: true,
           audio: false,
           onVideoDecode: function(decoder, time) {
+            decoded = true;
             setStatus(`Live - ${decoder.width}x${decoder.height}`);
           },
           onSourceEstablished: function() {
-            setStatus("Connected, waiting for video...");
+            setStatus(starting ? "Stream starting, waiting for the first frame..." : "Connected, waiting for video...");
           },
           onSourceError: function() {
             setStatus("WebSocket connection failed");
@@ -41,6 +370,20 @@ function App() {
       if (player && typeof player.destroy === "function") {
         player.destroy();
       }
+      if (cursorSocket) {
+        cursorSocket.close();
+      }
+      if (deltaSocket) {
+        deltaSocket.close();
+      }
+      if (rawImage) {
+        rawImage.src = "";
+      }
+      if (sound) {
+        sound.pause();
+        sound.src = "";
+        sound = null;
+      }
     };
   }, []);
 
@@ -64,9 +407,80 @@ function App() {
         fontFamily: "monospace"
       }}>
         {status}
+        <button onClick={handOver} style={{ marginLeft: "10px", fontFamily: "monospace" }}>
+          Move to another device
+        </button>
+        {control && (
+          <div>
+            {holding
+              ? "You have control"
+              : control.holder
+                ? `${control.holder.name} has control`
+                : "Nobody has control"}
+            <button
+              onClick={() => sendControl({ action: holding || control.requests.some((r) => r.id === control.you) ? "release" : "request" })}
+              style={{ marginLeft: "10px", fontFamily: "monospace" }}
+            >
+              {holding ? "Release control" : control.requests.some((r) => r.id === control.you) ? "Cancel request" : "Request control"}
+            </button>
+            {holding && control.requests.map((r) => (
+              <div key={r.id}>
+                {r.name} asks for control
+                <button onClick={() => sendControl({ action: "grant", to: r.id })} style={{ marginLeft: "10px", fontFamily: "monospace" }}>
+                  Grant
+                </button>
+                <button onClick={() => sendControl({ action: "deny", to: r.id })} style={{ marginLeft: "5px", fontFamily: "monospace" }}>
+                  Deny
+                </button>
+              </div>
+            ))}
+          </div>
+        )}
+        {handover && (
+          <div>
+            {handover.error
+              ? "Handover unavailable"
+              : `On the other device, open ${handover.url} (code ${handover.code})`}
+          </div>
+        )}
       </div>
+      {chat && (
+        <div style={{
+          color: "white",
+          position: "absolute",
+          bottom: "10px",
+          right: "10px",
+          width: "300px",
+          zIndex: 1000,
+          fontSize: "13px",
+          fontFamily: "monospace",
+          background: "rgba(0, 0, 0, 0.7)",
+          padding: "6px"
+        }}>
+          <div style={{ maxHeight: "200px", overflowY: "auto" }}>
+            {chat.map((m, i) => (
+              <div key={i}>
+                <b style={{ color: m.host ? "#fc6" : "#6cf" }}>{m.from}:</b> {m.text}
+              </div>
+            ))}
+          </div>
+          <form onSubmit={sendChat}>
+            <input
+              value={draft}
+              onChange={(e) => setDraft(e.target.value)}
+              maxLength={500}
+              placeholder="Message"
+              style={{ width: "100%", fontFamily: "monospace", boxSizing: "border-box" }}
+            />
+          </form>
+        </div>
+      )}
       <canvas
         ref={canvasRef}
+        onMouseMove={pointerInput("move")}
+        onMouseDown={pointerInput("down")}
+        onMouseUp={pointerInput("up")}
+        onContextMenu={(e) => holding && e.preventDefault()}
         style={{
           maxWidth: "100%",
           maxHeight: "100%",
@@ -74,6 +488,17 @@ function App() {
           border: "1px solid #333"
         }}
       />
+      <canvas
+        ref={cursorRef}
+        width={0}
+        height={0}
+        style={{
+          position: "absolute",
+          pointerEvents: "none",
+          transformOrigin: "0 0",
+          zIndex: 999
+        }}
+      />
     </div>
   );
 }
@@ -0,0 +1,133 @@
+import React, { useEffect, useRef, useState } from "react";
+
+// keySequences are what a terminal sends for keys that aren't text.
+const keySequences = {
+  Enter: "\r",
+  Backspace: "\x7f",
+  Tab: "\t",
+  Escape: "\x1b",
+  ArrowUp: "\x1b[A",
+  ArrowDown: "\x1b[B",
+  ArrowRight: "\x1b[C",
+  ArrowLeft: "\x1b[D",
+  Home: "\x1b[H",
+  End: "\x1b[F",
+  Delete: "\x1b[3~",
+};
+
+// plainText applies a dumb terminal's output to text: carriage returns,
+// backspaces and bells are handled, escape sequences dropped.
+function plainText(text, out) {
+  out = out.replace(/\x1b\][^\x07]*\x07|\x1b\[[0-9;?]*[ -/]*[@-~]|\x1b[()][0-9A-B]|\x1b[=>]/g, "");
+  for (const ch of out) {
+    if (ch === "\b") {
+      text = text.slice(0, -1);
+    } else if (ch === "\r" || ch === "\x07") {
+      continue;
+    } else {
+      text += ch;
+    }
+  }
+  // Keep the scrollback bounded.
+  return text.length > 200000 ? text.slice(-100000) : text;
+}
+
+// Terminal is a shell on the host, from /terminal. It runs the shell as a
+// dumb terminal, enough for commands and line editing without a full
+// terminal emulator.
+function Terminal() {
+  const screenRef = useRef(null);
+  const [text, setText] = useState("");
+  const [status, setStatus] = useState("Connecting...");
+
+  useEffect(() => {
+    const scheme = window.location.protocol === "https:" ? "wss" : "ws";
+    const size = () => {
+      const el = screenRef.current;
+      return {
+        cols: Math.max(20, Math.floor(el.clientWidth / 8.4)),
+        rows: Math.max(5, Math.floor(el.clientHeight / 17)),
+      };
+    };
+    const { cols, rows } = size();
+    const socket = new WebSocket(`${scheme}://${window.location.host}/terminal?term=dumb&cols=${cols}&rows=${rows}`);
+    socket.binaryType = "arraybuffer";
+    const decoder = new TextDecoder();
+    const encoder = new TextEncoder();
+    socket.onopen = () => setStatus("");
+    socket.onmessage = (msg) => {
+      const out = decoder.decode(msg.data, { stream: true });
+      setText((prev) => plainText(prev, out));
+    };
+    socket.onclose = (ev) => setStatus(`Disconnected${ev.reason ? `: ${ev.reason}` : ""}`);
+
+    const send = (s) => socket.readyState === WebSocket.OPEN && socket.send(encoder.encode(s));
+    const onKey = (ev) => {
+      if (ev.metaKey) {
+        return;
+      }
+      let seq = keySequences[ev.key];
+      if (!seq && ev.ctrlKey && ev.key.length === 1) {
+        const code = ev.key.toUpperCase().charCodeAt(0);
+        if (code >= 64 && code < 96) {
+          seq = String.fromCharCode(code - 64);
+        }
+      } else if (!seq && ev.key.length === 1) {
+        seq = ev.key;
+      }
+      if (seq) {
+        ev.preventDefault();
+        send(seq);
+      }
+    };
+    const onPaste = (ev) => {
+      ev.preventDefault();
+      send(ev.clipboardData.getData("text"));
+    };
+    const onResize = () => {
+      if (socket.readyState === WebSocket.OPEN) {
+        socket.send(JSON.stringify({ type: "resize", ...size() }));
+      }
+    };
+    window.addEventListener("keydown", onKey);
+    window.addEventListener("paste", onPaste);
+    window.addEventListener("resize", onResize);
+    return () => {
+      window.removeEventListener("keydown", onKey);
+      window.removeEventListener("paste", onPaste);
+      window.removeEventListener("resize", onResize);
+      socket.close();
+    };
+  }, []);
+
+  useEffect(() => {
+    const el = screenRef.current;
+    el.scrollTop = el.scrollHeight;
+  }, [text]);
+
+  return (
+    <pre
+      ref={screenRef}
+      style={{
+        background: "#000",
+        color: "#ddd",
+        height: "100vh",
+        margin: 0,
+        padding: "8px",
+        boxSizing: "border-box",
+        overflowY: "auto",
+        whiteSpace: "pre-wrap",
+        wordBreak: "break-all",
+        fontFamily: "monospace",
+        fontSize: "14px",
+        lineHeight: "17px",
+      }}
+    >
+      {text}
+      <span style={{ background: "#ddd" }}> </span>
+      {status && <div style={{ color: "#f88" }}>{status}</div>}
+    </pre>
+  );
+}
+
+export default Terminal;
@@ -2,12 +2,14 @@ import React from 'react';
 import ReactDOM from 'react-dom/client';
 import './index.css';
 import App from './App';
+import Terminal from './Terminal';
 import reportWebVitals from './reportWebVitals';
 
 const root = ReactDOM.createRoot(document.getElementById('root'));
 root.render(
   <React.StrictMode>
-    <App />
+    {/* ?terminal=1 opens a shell on the host instead of the screen. */}
+    {new URLSearchParams(window.location.search).get('terminal') === '1' ? <Terminal /> : <App />}
   </React.StrictMode>
 );
 
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/nathfavour/remoter/i18n"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/webtransport-go"
+)
+
+// WebTransport viewers connect to /wt on a listener with http3 set. The
+// video comes as datagrams, each a big-endian uint32 sequence number and
+// the next whole MPEG-TS packets of the stream, so a lost datagram costs
+// a glitch instead of stalling everything behind it and the next one
+// still starts on a packet. Control messages, the same JSON the
+// WebSocket takes, go on the first bidirectional stream the viewer opens,
+// one per line, and notices come back on it the same way.
+
+// tsPacketSize is the size of an MPEG-TS packet, each starting with
+// tsSyncByte.
+const (
+	tsPacketSize = 188
+	tsSyncByte   = 0x47
+)
+
+// wtDatagramSize is the largest datagram sent, header included: five TS
+// packets fit the smallest packets QUIC allows.
+const wtDatagramSize = 4 + 5*tsPacketSize
+
+// webTransportKey carries the WebTransport server of an HTTP/3 listener
+// in the context of its requests.
+type webTransportKey struct{}
+
+func webTransportRequest(r *http.Request) bool {
+	return r.Method == http.MethodConnect && r.Proto == "webtransport"
+}
+
+func handleWebTransport(w http.ResponseWriter, r *http.Request) {
+	wt, ok := r.Context().Value(webTransportKey{}).(*webtransport.Server)
+	if !ok || !webTransportRequest(r) {
+		i18n.Error(w, r, http.StatusBadRequest, "webtransport_unavailable")
+		return
+	}
+	if !streamExists(r.PathValue("session")) {
+		i18n.Error(w, r, http.StatusNotFound, "no_such_session")
+		return
+	}
+	if err := quotas.admitStream(requestAuth(r).User); err != nil {
+		i18n.Error(w, r, http.StatusForbidden, "quota_exceeded", err)
+		return
+	}
+	meta, _ := streamMetaFor(r.PathValue("session"))
+	for k, v := range meta.header() {
+		w.Header()[k] = v
+	}
+	viewerDevices.identify(w.Header(), r)
+	// The upgrade needs the HTTP/3 writer itself, not the middleware's.
+	for {
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			break
+		}
+		w = u.Unwrap()
+	}
+	sess, err := wt.Upgrade(w, r)
+	if err != nil {
+		log.Printf("WebTransport upgrade error: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	c := newWebTransportClient(sess, r)
+	totalClients := addClient(c)
+	recordFallback(r, transportWebTransport)
+	log.Printf("New WebTransport client %s connected. Total clients: %d", c.describe(), totalClients)
+	go c.readControl()
+
+	select {
+	case <-sess.Context().Done():
+		c.close()
+	case <-c.done:
+	}
+
+	totalClients = removeClient(c)
+	log.Printf("WebTransport client %s disconnected. Total clients: %d", c.describe(), totalClients)
+}
+
+func newWebTransportClient(sess *webtransport.Session, r *http.Request) *client {
+	c := newClient(transportWebTransport, r)
+	c.session = sess
+	c.datagramSize = wtDatagramSize
+	c.notices = r.URL.Query().Get("notify") == "1"
+	c.caps = clientCapabilities(c, r)
+	return c
+}
+
+// readControl takes the viewer's control stream and handles what arrives
+// on it until the viewer closes it.
+func (c *client) readControl() {
+	str, err := c.session.AcceptStream(c.session.Context())
+	if err != nil {
+		return
+	}
+	c.mu.Lock()
+	c.control = str
+	c.mu.Unlock()
+	if c.stream == "" {
+		greetPlaceholder(c)
+	}
+	sc := bufio.NewScanner(str)
+	for sc.Scan() {
+		handleControlMessage(c, sc.Bytes())
+	}
+}
+
+// sendDatagrams sends data in numbered datagrams of whole TS packets,
+// keeping a packet cut short by the end of data for the next call. A
+// full send queue blocks, which the hub sees as a viewer falling behind.
+// c.mu must be held.
+func (c *client) sendDatagrams(data []byte) error {
+	if len(c.tsPartial) > 0 {
+		data = append(c.tsPartial, data...)
+	}
+	buf := make([]byte, 4, c.datagramSize)
+	for len(data) >= tsPacketSize {
+		if i := tsSync(data); i != 0 {
+			// A new viewer starts at the cached keyframe, and an encoder
+			// restart anywhere, rather than on a packet.
+			if i < 0 {
+				data = nil
+				break
+			}
+			data = data[i:]
+			continue
+		}
+		n := min(len(data), c.datagramSize-4) / tsPacketSize * tsPacketSize
+		binary.BigEndian.PutUint32(buf, c.seq)
+		err := c.session.SendDatagram(append(buf[:4], data[:n]...))
+		var tooLarge *quic.DatagramTooLargeError
+		if errors.As(err, &tooLarge) {
+			// The path is narrower than assumed; the session's stream ID
+			// takes up to 8 more bytes of each datagram.
+			packets := (int(tooLarge.MaxDatagramPayloadSize) - 8 - 4) / tsPacketSize
+			if size := 4 + packets*tsPacketSize; packets > 0 && size < c.datagramSize {
+				c.datagramSize = size
+				continue
+			}
+		}
+		if err != nil {
+			return err
+		}
+		c.seq++
+		data = data[n:]
+	}
+	c.tsPartial = append(c.tsPartial[:0], data...)
+	return nil
+}
+
+// tsSync returns where the first TS packet in data starts, judged by a
+// sync byte with another a packet later when data is that long, or -1.
+func tsSync(data []byte) int {
+	for i, b := range data {
+		if b == tsSyncByte && (len(data) <= i+tsPacketSize || data[i+tsPacketSize] == tsSyncByte) {
+			return i
+		}
+	}
+	return -1
+}
+
+// writeControl sends a notice line on the control stream, once the
+// viewer has opened it. c.mu must be held.
+func (c *client) writeControl(data []byte) error {
+	if c.control == nil {
+		return nil
+	}
+	c.control.SetWriteDeadline(time.Now().Add(pingTimeout))
+	_, err := c.control.Write(append(data, '\n'))
+	return err
+}
+
+// webTransportContext lets handleWebTransport find wt.
+func webTransportContext(wt *webtransport.Server) func(context.Context, *quic.Conn) context.Context {
+	return func(ctx context.Context, _ *quic.Conn) context.Context {
+		return context.WithValue(ctx, webTransportKey{}, wt)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nathfavour/remoter/hotkey"
+	"github.com/nathfavour/remoter/xshm"
+)
+
+// displayCheckInterval is how often a display that went away is tried
+// again.
+const displayCheckInterval = 2 * time.Second
+
+var (
+	// displayLostAt is when the X server of the main display went away,
+	// zero while it is up.
+	displayLostAt time.Time
+	// reconnectors run when the X server comes back, to reconnect what
+	// held a connection to the old one.
+	reconnectors []func()
+	displayMux   sync.Mutex
+)
+
+// onDisplayRestored registers fn to run whenever the X server of the main
+// display comes back after going away.
+func onDisplayRestored(fn func()) {
+	displayMux.Lock()
+	defer displayMux.Unlock()
+	reconnectors = append(reconnectors, fn)
+}
+
+// watchDisplay follows the X server of the main display, so that when it
+// exits or restarts, e.g. as the user logs out and in again, what depends
+// on it is brought back as soon as it is up rather than at the end of a
+// service's backoff, or never, for the helpers nothing supervises.
+func watchDisplay(display string) {
+	if strings.HasPrefix(display, "wayland-") {
+		return
+	}
+	xshm.Watch(display, displayCheckInterval, nil, func(up bool) {
+		displayMux.Lock()
+		lostAt := displayLostAt
+		if up {
+			displayLostAt = time.Time{}
+		} else {
+			displayLostAt = time.Now()
+		}
+		fns := append([]func(){}, reconnectors...)
+		displayMux.Unlock()
+
+		if !up {
+			log.Printf("Warning: X display %s is unreachable", display)
+			emit(eventDisplayLost, map[string]string{"display": display})
+			return
+		}
+		down := time.Since(lostAt)
+		log.Printf("X display %s is back after %s; reconnecting", display, down.Round(time.Second))
+		emit(eventDisplayRestored, map[string]any{"display": display, "down_seconds": down.Seconds()})
+		restartStreamServices()
+		for _, fn := range fns {
+			fn()
+		}
+	})
+}
+
+// restartStreamServices restarts the main encoder's services, which are
+// likely waiting out a backoff after failing to capture the display. An
+// encoder stopped for being idle stays stopped.
+func restartStreamServices() {
+	idleMux.Lock()
+	stopped := idleStopped
+	idleMux.Unlock()
+	if stopped {
+		return
+	}
+	for _, s := range declared {
+		if s.cfg.Type != serviceStream || s.stopped() {
+			continue
+		}
+		if err := s.restart(); err != nil {
+			log.Printf("Warning: failed to restart %s: %v", s.cfg.Name, err)
+		}
+	}
+}
+
+// grabHotkey grabs combo on display, again whenever its X server comes
+// back.
+func grabHotkey(display, combo string, fn func()) error {
+	if err := hotkey.Grab(display, combo, fn); err != nil {
+		return err
+	}
+	onDisplayRestored(func() {
+		if err := hotkey.Grab(display, combo, fn); err != nil {
+			log.Printf("Warning: failed to grab hotkey %s again: %v", combo, err)
+		}
+	})
+	return nil
+}
+
+// displayLost returns when the main display's X server went away, if it
+// is down.
+func displayLost() *time.Time {
+	displayMux.Lock()
+	defer displayMux.Unlock()
+	if displayLostAt.IsZero() {
+		return nil
+	}
+	t := displayLostAt
+	return &t
+}
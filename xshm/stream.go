@@ -0,0 +1,158 @@
+package xshm
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"sync"
+	"time"
+)
+
+// Stream captures a display as JPEG frames while anyone is subscribed,
+// handing each subscriber the latest frame; slow ones skip frames rather
+// than fall behind.
+type Stream struct {
+	mu      sync.Mutex
+	display string
+	area    image.Rectangle
+	rate    int
+	quality int
+	width   int // to scale frames down to; 0 keeps them full size
+	subs    map[chan []byte]struct{}
+	stop    chan struct{}
+}
+
+// NewStream captures area of display (all of it when empty) rate times a
+// second at the given JPEG quality.
+func NewStream(display string, area image.Rectangle, rate, quality int) *Stream {
+	return &Stream{display: display, area: area, rate: rate, quality: quality, subs: make(map[chan []byte]struct{})}
+}
+
+// NewScaledStream is NewStream with frames scaled down to width pixels
+// across, for previews; frames already narrower are left alone.
+func NewScaledStream(display string, area image.Rectangle, rate, quality, width int) *Stream {
+	s := NewStream(display, area, rate, quality)
+	s.width = width
+	return s
+}
+
+// Subscribe returns a channel of JPEG frames, starting capture for the
+// first subscriber. The channel is closed if capture fails; call the
+// returned function to unsubscribe.
+func (s *Stream) Subscribe() (<-chan []byte, func(), error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stop == nil {
+		c, err := Open(s.display, s.area)
+		if err != nil {
+			return nil, nil, err
+		}
+		s.stop = make(chan struct{})
+		go s.run(c, s.stop)
+	}
+	ch := make(chan []byte, 1)
+	s.subs[ch] = struct{}{}
+	return ch, func() { s.unsubscribe(ch) }, nil
+}
+
+func (s *Stream) unsubscribe(ch chan []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.subs[ch]; !ok {
+		return
+	}
+	delete(s.subs, ch)
+	close(ch)
+	if len(s.subs) == 0 && s.stop != nil {
+		close(s.stop)
+		s.stop = nil
+	}
+}
+
+func (s *Stream) run(c *Capturer, stop chan struct{}) {
+	defer c.Close()
+	t := time.NewTicker(time.Second / time.Duration(max(s.rate, 1)))
+	defer t.Stop()
+	var img, small *image.RGBA
+	var buf bytes.Buffer
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+		}
+		var err error
+		if img, err = c.Grab(img); err != nil {
+			fmt.Printf("Raw capture of %s failed: %v\n", s.display, err)
+			s.fail(stop)
+			return
+		}
+		out := img
+		if s.width > 0 && img.Rect.Dx() > s.width {
+			small = shrink(img, s.width, small)
+			out = small
+		}
+		buf.Reset()
+		if err := jpeg.Encode(&buf, out, &jpeg.Options{Quality: s.quality}); err != nil {
+			fmt.Printf("Warning: failed to encode frame: %v\n", err)
+			continue
+		}
+		frame := bytes.Clone(buf.Bytes())
+
+		s.mu.Lock()
+		for ch := range s.subs {
+			// Replace an unread frame with the newer one.
+			select {
+			case <-ch:
+			default:
+			}
+			ch <- frame
+		}
+		s.mu.Unlock()
+	}
+}
+
+// fail drops every subscriber after capture broke.
+func (s *Stream) fail(stop chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stop != stop {
+		return
+	}
+	for ch := range s.subs {
+		delete(s.subs, ch)
+		close(ch)
+	}
+	s.stop = nil
+}
+
+// shrink scales img down to width pixels across, keeping its aspect,
+// averaging the pixels each one covers. dst is reused when it fits.
+func shrink(img *image.RGBA, width int, dst *image.RGBA) *image.RGBA {
+	sw, sh := img.Rect.Dx(), img.Rect.Dy()
+	height := max(sh*width/sw, 1)
+	if dst == nil || dst.Rect.Dx() != width || dst.Rect.Dy() != height {
+		dst = image.NewRGBA(image.Rect(0, 0, width, height))
+	}
+	for y := range height {
+		y0, y1 := y*sh/height, max((y+1)*sh/height, y*sh/height+1)
+		for x := range width {
+			x0, x1 := x*sw/width, max((x+1)*sw/width, x*sw/width+1)
+			var r, g, b, n int
+			for sy := y0; sy < y1; sy++ {
+				i := img.PixOffset(img.Rect.Min.X+x0, img.Rect.Min.Y+sy)
+				for range x1 - x0 {
+					r += int(img.Pix[i])
+					g += int(img.Pix[i+1])
+					b += int(img.Pix[i+2])
+					i += 4
+					n++
+				}
+			}
+			j := dst.PixOffset(x, y)
+			dst.Pix[j], dst.Pix[j+1], dst.Pix[j+2], dst.Pix[j+3] = uint8(r/n), uint8(g/n), uint8(b/n), 0xff
+		}
+	}
+	return dst
+}
@@ -0,0 +1,105 @@
+package xshm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/png"
+
+	"github.com/nathfavour/remoter/lz4"
+)
+
+// Tile encodings. PNG suits browsers over the internet; raw and LZ4 are
+// lossless too but cheaper to produce, for LAN viewers that want
+// pixel-perfect text without PNG's CPU cost.
+const (
+	EncodingPNG = "png"
+	// EncodingRaw is a kind byte then the pixels: 0 for RGB, 3 bytes a
+	// pixel; 1 for a palette of up to 256 colors, a byte holding the
+	// count - 1, the colors as RGB, and a byte a pixel.
+	EncodingRaw = "raw"
+	// EncodingLZ4 is EncodingRaw compressed as one LZ4 block, after its
+	// uncompressed length as a big-endian uint32.
+	EncodingLZ4 = "lz4"
+)
+
+// ValidEncoding reports whether tiles can be encoded as e.
+func ValidEncoding(e string) bool {
+	return e == EncodingPNG || e == EncodingRaw || e == EncodingLZ4
+}
+
+func newTile(frame *image.RGBA, r image.Rectangle) *Tile {
+	t := &Tile{X: r.Min.X, Y: r.Min.Y, W: r.Dx(), H: r.Dy(), pix: make([]byte, 0, r.Dx()*r.Dy()*4)}
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		i := frame.PixOffset(r.Min.X, y)
+		t.pix = append(t.pix, frame.Pix[i:i+r.Dx()*4]...)
+	}
+	return t
+}
+
+// Encoded returns the tile in the given encoding.
+func (t *Tile) Encoded(encoding string) ([]byte, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if data, ok := t.encoded[encoding]; ok {
+		return data, nil
+	}
+	var data []byte
+	switch encoding {
+	case EncodingPNG:
+		img := &image.RGBA{Pix: t.pix, Stride: t.W * 4, Rect: image.Rect(0, 0, t.W, t.H)}
+		var buf bytes.Buffer
+		if err := (&png.Encoder{CompressionLevel: png.BestSpeed}).Encode(&buf, img); err != nil {
+			return nil, fmt.Errorf("failed to encode tile: %w", err)
+		}
+		data = buf.Bytes()
+	case EncodingRaw:
+		data = t.raw()
+	case EncodingLZ4:
+		raw := t.raw()
+		data = binary.BigEndian.AppendUint32(nil, uint32(len(raw)))
+		data = append(data, lz4.CompressBlock(raw)...)
+	default:
+		return nil, fmt.Errorf("unknown tile encoding %q", encoding)
+	}
+	if t.encoded == nil {
+		t.encoded = make(map[string][]byte)
+	}
+	t.encoded[encoding] = data
+	return data, nil
+}
+
+// raw packs the pixels with a palette when they have few enough colors,
+// as desktops mostly do.
+func (t *Tile) raw() []byte {
+	n := t.W * t.H
+	index := make(map[[3]byte]byte)
+	var palette []byte
+	indices := make([]byte, n)
+	for i := 0; i < n; i++ {
+		c := [3]byte{t.pix[i*4], t.pix[i*4+1], t.pix[i*4+2]}
+		idx, ok := index[c]
+		if !ok {
+			if len(index) == 256 {
+				palette = nil
+				break
+			}
+			idx = byte(len(index))
+			index[c] = idx
+			palette = append(palette, c[:]...)
+		}
+		indices[i] = idx
+	}
+	if palette != nil {
+		out := make([]byte, 0, 2+len(palette)+n)
+		out = append(out, 1, byte(len(palette)/3-1))
+		out = append(out, palette...)
+		return append(out, indices...)
+	}
+	out := make([]byte, 1, 1+n*3)
+	for i := 0; i < n; i++ {
+		out = append(out, t.pix[i*4:i*4+3]...)
+	}
+	return out
+}
@@ -0,0 +1,54 @@
+package xshm
+
+import (
+	"io"
+	"time"
+)
+
+// Watch follows the X server of display until stop is closed. It holds an
+// idle connection to the server, which reads nothing until the server
+// exits and drops it; it then polls every interval until a server, the
+// old one restarted or a new one, accepts connections again. fn is called
+// with false when the server goes away and with true when one is back.
+func Watch(display string, interval time.Duration, stop <-chan struct{}, fn func(up bool)) {
+	up := true
+	for {
+		x, err := dial(display)
+		if err != nil {
+			if up {
+				up = false
+				fn(false)
+			}
+			select {
+			case <-stop:
+				return
+			case <-time.After(interval):
+			}
+			continue
+		}
+		if !up {
+			up = true
+			fn(true)
+		}
+
+		gone := make(chan struct{})
+		go func() {
+			select {
+			case <-stop:
+				x.Close()
+			case <-gone:
+			}
+		}()
+		// The connection selected no events, so any read ends with it.
+		io.Copy(io.Discard, x.c)
+		close(gone)
+		x.Close()
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		up = false
+		fn(false)
+	}
+}
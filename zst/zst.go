@@ -0,0 +1,84 @@
+package zst
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/gorilla/websocket"
+	"github.com/klauspost/compress/zstd"
+)
+
+// MinSize is the smallest message worth compressing: below it the frame
+// header outweighs what is saved.
+const MinSize = 512
+
+// MaxSize bounds what a message or body may decompress to.
+const MaxSize = 64 << 20
+
+var (
+	encoder, _ = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault), zstd.WithEncoderConcurrency(1))
+	decoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0), zstd.WithDecoderMaxMemory(MaxSize))
+)
+
+func Encode(data []byte) []byte {
+	return encoder.EncodeAll(data, nil)
+}
+
+func Decode(data []byte) ([]byte, error) {
+	out, err := decoder.DecodeAll(data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress message: %w", err)
+	}
+	return out, nil
+}
+
+// Accepts reports whether an Accept-Encoding header lists zstd.
+func Accepts(header string) bool {
+	for _, coding := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(coding), ";")
+		if strings.EqualFold(strings.TrimSpace(name), "zstd") && strings.ReplaceAll(params, " ", "") != "q=0" {
+			return true
+		}
+	}
+	return false
+}
+
+// NewReader decompresses a body.
+func NewReader(r io.Reader) (io.ReadCloser, error) {
+	d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(MaxSize))
+	if err != nil {
+		return nil, err
+	}
+	return d.IOReadCloser(), nil
+}
+
+// NewWriter compresses a body; Close flushes it.
+func NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+}
+
+// WriteJSON sends v on a WebSocket as a text message or, when compress
+// is set and it is at least MinSize, as a binary one holding it
+// compressed.
+func WriteJSON(conn *websocket.Conn, v any, compress bool) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if compress && len(data) >= MinSize {
+		return conn.WriteMessage(websocket.BinaryMessage, Encode(data))
+	}
+	return conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// ReadMessage reads a message from a WebSocket, decompressing binary ones
+// when compress is set, so either kind reads as text.
+func ReadMessage(conn *websocket.Conn, compress bool) ([]byte, error) {
+	typ, data, err := conn.ReadMessage()
+	if err != nil || !compress || typ != websocket.BinaryMessage {
+		return data, err
+	}
+	return Decode(data)
+}